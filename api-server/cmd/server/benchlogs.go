@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/logingest"
+	"github.com/solvyd/solvyd/api-server/internal/metrics"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+const benchLogsUsage = `Usage: %s bench-logs [flags]
+
+Stores the logs of simulated builds through the log ingester, as worker agents
+send them, and reports the lines stored a second and the latency of requests.
+The builds, of a job created for the run, are deleted with their logs after it.
+
+Flags:
+`
+
+// runBenchLogs runs the bench-logs subcommand and returns the exit code
+func runBenchLogs(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("bench-logs", flag.ContinueOnError)
+	builds := fs.Int("builds", 1000, "builds emitting lines at once")
+	lines := fs.Int("lines", 50, "lines each build sends a request")
+	interval := fs.Duration("interval", time.Second, "time between the requests of a build")
+	duration := fs.Duration("duration", 30*time.Second, "how long the builds emit lines")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, benchLogsUsage, filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *builds < 1 || *lines < 1 || *interval <= 0 || *duration <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	db, err := database.NewDatabase(cfg.DatabaseURL)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to connect to database")
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	jobID, buildIDs, err := createBenchBuilds(ctx, db, *builds)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create builds")
+		return 1
+	}
+	defer func() {
+		// Builds and their logs go with the job
+		if _, err := db.GetConn().ExecContext(ctx, `DELETE FROM jobs WHERE id = $1`, jobID); err != nil {
+			log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to delete benchmark job")
+		}
+	}()
+
+	ingestCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	ingester := logingest.NewIngester(db, metrics.NewCollector(), cfg.LogIngest)
+	go ingester.Start(ingestCtx)
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var stored, failed int
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(*duration)
+	for n, buildID := range buildIDs {
+		wg.Add(1)
+		go func(n int, buildID uuid.UUID) {
+			defer wg.Done()
+			// Spread the builds' requests over the interval, as builds that
+			// started at different times send theirs
+			time.Sleep(time.Duration(n) * *interval / time.Duration(len(buildIDs)))
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			sequence := 0
+			for time.Now().Before(deadline) {
+				batch := make([]models.BuildLog, *lines)
+				for k := range batch {
+					sequence++
+					batch[k] = models.BuildLog{
+						SequenceNumber: sequence,
+						Timestamp:      time.Now(),
+						LogLine:        fmt.Sprintf("step %d of build %d: compiling package %d of the benchmark", sequence, n, k),
+						Stream:         "stdout",
+					}
+				}
+				sent := time.Now()
+				result, err := ingester.Append(ctx, buildID, batch)
+				took := time.Since(sent)
+
+				mu.Lock()
+				latencies = append(latencies, took)
+				stored += len(result)
+				if err != nil {
+					failed++
+				}
+				mu.Unlock()
+				<-ticker.C
+			}
+		}(n, buildID)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(float64(len(latencies)-1)*p)]
+	}
+	fmt.Printf("builds:        %d\n", *builds)
+	fmt.Printf("writers:       %d\n", max(cfg.LogIngest.Writers, 1))
+	fmt.Printf("requests:      %d (%d failed)\n", len(latencies), failed)
+	fmt.Printf("lines stored:  %d in %s\n", stored, elapsed.Round(time.Millisecond))
+	fmt.Printf("lines/second:  %.0f\n", float64(stored)/elapsed.Seconds())
+	fmt.Printf("latency p50:   %s\n", percentile(0.50).Round(time.Microsecond))
+	fmt.Printf("latency p99:   %s\n", percentile(0.99).Round(time.Microsecond))
+	fmt.Printf("latency max:   %s\n", percentile(1).Round(time.Microsecond))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// createBenchBuilds creates a disabled job and builds of it that the
+// scheduler leaves alone
+func createBenchBuilds(ctx context.Context, db *database.Database, count int) (uuid.UUID, []uuid.UUID, error) {
+	jobID := uuid.New()
+	_, err := db.GetConn().ExecContext(ctx, `
+		INSERT INTO jobs (id, name, description, scm_type, scm_url, build_config, enabled)
+		VALUES ($1, $2, 'Created by bench-logs', 'git', '', '{}', false)
+	`, jobID, "bench-logs-"+jobID.String()[:8])
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	buildIDs := make([]uuid.UUID, count)
+	ids := make([]string, count)
+	for n := range buildIDs {
+		buildIDs[n] = uuid.New()
+		ids[n] = buildIDs[n].String()
+	}
+	_, err = db.GetConn().ExecContext(ctx, `
+		INSERT INTO builds (id, job_id, status, triggered_by)
+		SELECT id, $2, 'cancelled', 'bench-logs' FROM unnest($1::uuid[]) AS id
+	`, pq.Array(ids), jobID)
+	if err != nil {
+		db.GetConn().ExecContext(ctx, `DELETE FROM jobs WHERE id = $1`, jobID)
+		return uuid.Nil, nil, err
+	}
+	return jobID, buildIDs, nil
+}
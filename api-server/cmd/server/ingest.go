@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/handlers"
+	"github.com/solvyd/solvyd/api-server/internal/logingest"
+	"github.com/solvyd/solvyd/api-server/internal/metrics"
+	"github.com/solvyd/solvyd/api-server/internal/tracing"
+)
+
+// runIngest serves the endpoint worker agents send build logs to, and nothing
+// else, and returns the exit code. Ingest replicas share the API server's
+// database and keep no state, so as many run as the builds' logs need; the
+// API server relays the events they write to its WebSocket clients.
+func runIngest(cfg *config.Config) int {
+	log.Info().Msg("Starting Solvyd log ingestion")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to initialize tracing")
+		return 1
+	}
+
+	db, err := database.NewDatabase(cfg.DatabaseURL)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to connect to database")
+		return 1
+	}
+	defer db.Close()
+
+	metricsCollector := metrics.NewCollector()
+	ingester := logingest.NewIngester(db, metricsCollector, cfg.LogIngest)
+	go ingester.Start(context.Background())
+
+	router := mux.NewRouter()
+	router.Use(tracing.Middleware, metricsCollector.Middleware)
+	router.HandleFunc("/health", handlers.HealthCheck).Methods("GET")
+	router.HandleFunc("/ready", handlers.ReadinessCheck(db)).Methods("GET")
+	router.Handle("/metrics", metrics.Handler())
+
+	logHandler := handlers.NewLogHandler(db, ingester)
+	router.HandleFunc("/api/v1/builds/{id}/logs", logHandler.AppendBuildLogs).Methods("POST")
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
+		Handler:      router,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	go func() {
+		log.Info().Int("port", cfg.Port).Msg("Starting HTTP server")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("HTTP server failed")
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Info().Msg("Shutting down log ingestion...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	code := 0
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Log ingestion forced to shutdown")
+		code = 1
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to flush traces")
+	}
+	return code
+}
@@ -14,11 +14,26 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"github.com/solvyd/solvyd/api-server/internal/alerts"
+	"github.com/solvyd/solvyd/api-server/internal/artifacts"
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/cache"
 	"github.com/solvyd/solvyd/api-server/internal/config"
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
 	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/gitops"
 	"github.com/solvyd/solvyd/api-server/internal/handlers"
+	"github.com/solvyd/solvyd/api-server/internal/libraries"
+	"github.com/solvyd/solvyd/api-server/internal/logingest"
+	"github.com/solvyd/solvyd/api-server/internal/maintenance"
 	"github.com/solvyd/solvyd/api-server/internal/metrics"
+	"github.com/solvyd/solvyd/api-server/internal/oidc"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+	"github.com/solvyd/solvyd/api-server/internal/plugininstall"
 	"github.com/solvyd/solvyd/api-server/internal/scheduler"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+	"github.com/solvyd/solvyd/api-server/internal/storage"
+	"github.com/solvyd/solvyd/api-server/internal/tracing"
 	"github.com/solvyd/solvyd/api-server/internal/worker"
 )
 
@@ -42,6 +57,34 @@ func main() {
 	}
 	zerolog.SetGlobalLevel(level)
 
+	// Run the migrate subcommand instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg.DatabaseURL, os.Args[2:]))
+	}
+
+	// Serve only log ingestion, as one of its replicas
+	if len(os.Args) > 1 && os.Args[1] == "ingest" {
+		os.Exit(runIngest(cfg))
+	}
+
+	// Measure log ingestion against the database
+	if len(os.Args) > 1 && os.Args[1] == "bench-logs" {
+		os.Exit(runBenchLogs(cfg, os.Args[2:]))
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+
+	// Apply pending schema migrations
+	if cfg.DatabaseAutoMigrate {
+		if err := database.Migrate(cfg.DatabaseURL); err != nil {
+			log.Fatal().Err(err).Msg("Failed to migrate database")
+		}
+	}
+
 	// Initialize database
 	db, err := database.NewDatabase(cfg.DatabaseURL)
 	if err != nil {
@@ -51,19 +94,88 @@ func main() {
 
 	log.Info().Msg("Database connection established")
 
+	// Initialize credential store
+	credStore, err := credentials.NewStore(db, cfg.CredentialsEncryptionKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize credential store")
+	}
+
+	// Initialize artifact storage
+	store, err := storage.New(cfg.ArtifactStorageType, cfg.ArtifactStorageConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize artifact storage")
+	}
+
+	// Initialize plugin verification
+	pluginFetcher, err := plugininstall.NewFetcher(cfg.PluginVerification)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load trusted plugin keys")
+	}
+
+	// Load the instance settings administrators change at runtime
+	settingsStore := settings.NewStore(db, settings.Defaults(cfg.BuildLogRetentionDays))
+	if err := settingsStore.Load(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load settings")
+	}
+	go settingsStore.Start(context.Background())
+
 	// Initialize metrics
 	metricsCollector := metrics.NewCollector()
 
 	// Initialize worker manager
-	workerMgr := worker.NewManager(db, metricsCollector)
+	workerMgr := worker.NewManager(db, metricsCollector, settingsStore)
 	go workerMgr.Start(context.Background())
 
+	// Pipelines include templates from the pipeline libraries
+	libraryStore := libraries.NewStore(db, credStore)
+
+	// Builds get identity tokens to federate into cloud providers with
+	var identity *oidc.Provider
+	if cfg.OIDC.Enabled {
+		if identity, err = oidc.NewProvider(cfg.OIDC); err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize OIDC provider")
+		}
+	}
+
 	// Initialize scheduler
-	sched := scheduler.NewScheduler(db, workerMgr, metricsCollector)
+	sched := scheduler.NewScheduler(db, workerMgr, metricsCollector, credStore, libraryStore, settingsStore)
 	go sched.Start(context.Background())
 
-	// Initialize HTTP router
+	// Initialize HTTP router. In maintenance mode writes are rejected but on
+	// the routes registered with handlers.DuringMaintenance, which agents
+	// report builds through; mark new ones as they are added.
 	router := mux.NewRouter()
+	router.Use(tracing.Middleware, metricsCollector.Middleware, handlers.MaintenanceMiddleware(settingsStore))
+
+	// WebSocket clients subscribe to the updates the handlers publish
+	authenticator := auth.NewAuthenticator(db, cfg.JWTSecret)
+	wsHandler := handlers.NewWebSocketHandler(db, authenticator, cfg.CORSAllowedOrigins)
+
+	// Relay the events written with state changes to the WebSocket clients of
+	// every server
+	dispatcher := outbox.NewDispatcher(db, cfg.DatabaseURL, wsHandler)
+	go dispatcher.Start(context.Background())
+
+	// Initialize database maintenance
+	maintainer := maintenance.NewMaintainer(db, settingsStore)
+	go maintainer.Start(context.Background())
+
+	// Start artifact integrity audit
+	auditor := artifacts.NewAuditor(db, store, settingsStore)
+	go auditor.Start(context.Background())
+
+	// Start eviction of unused cache entries
+	cacheStore := cache.NewStore(db, store)
+	cacheEvictor := cache.NewEvictor(cacheStore, settingsStore)
+	go cacheEvictor.Start(context.Background())
+
+	// Store the build logs worker agents send
+	ingester := logingest.NewIngester(db, metricsCollector, cfg.LogIngest)
+	go ingester.Start(context.Background())
+
+	// Start the alerts on worker and queue health
+	alertMonitor := alerts.NewMonitor(db, settingsStore)
+	go alertMonitor.Start(context.Background())
 
 	// Health check endpoint
 	router.HandleFunc("/health", handlers.HealthCheck).Methods("GET")
@@ -73,33 +185,128 @@ func main() {
 	apiV1 := router.PathPrefix("/api/v1").Subrouter()
 
 	// Jobs endpoints
-	jobHandler := handlers.NewJobHandler(db)
+	jobHandler := handlers.NewJobHandler(db, settingsStore, authenticator)
 	apiV1.HandleFunc("/jobs", jobHandler.ListJobs).Methods("GET")
 	apiV1.HandleFunc("/jobs", jobHandler.CreateJob).Methods("POST")
 	apiV1.HandleFunc("/jobs/{id}", jobHandler.GetJob).Methods("GET")
 	apiV1.HandleFunc("/jobs/{id}", jobHandler.UpdateJob).Methods("PUT")
 	apiV1.HandleFunc("/jobs/{id}", jobHandler.DeleteJob).Methods("DELETE")
 	apiV1.HandleFunc("/jobs/{id}/trigger", jobHandler.TriggerJob).Methods("POST")
+	apiV1.HandleFunc("/jobs/{id}/disable", jobHandler.DisableJob).Methods("POST")
+	apiV1.HandleFunc("/jobs/{id}/enable", jobHandler.EnableJob).Methods("POST")
+	apiV1.HandleFunc("/jobs/{id}/coverage", jobHandler.GetJobCoverage).Methods("GET")
+	apiV1.HandleFunc("/jobs/{id}/stats", jobHandler.GetJobStats).Methods("GET")
+	apiV1.HandleFunc("/jobs/{id}/findings/baseline", jobHandler.GetFindingBaseline).Methods("GET")
+	apiV1.HandleFunc("/jobs/{id}/findings/baseline", jobHandler.SetFindingBaseline).Methods("PUT")
+	apiV1.HandleFunc("/jobs/{id}/findings/baseline", jobHandler.DeleteFindingBaseline).Methods("DELETE")
+	apiV1.HandleFunc("/jobs/{id}/findings/suppressions", jobHandler.ListFindingSuppressions).Methods("GET")
+	apiV1.HandleFunc("/jobs/{id}/findings/suppressions", jobHandler.CreateFindingSuppression).Methods("POST")
+	apiV1.HandleFunc("/jobs/{id}/findings/suppressions/{suppression_id}", jobHandler.DeleteFindingSuppression).Methods("DELETE")
+
+	// Environment endpoints
+	environmentHandler := handlers.NewEnvironmentHandler(db)
+	apiV1.HandleFunc("/environment", environmentHandler.GetGlobalEnvironment).Methods("GET")
+	apiV1.HandleFunc("/environment", environmentHandler.SetGlobalEnvironment).Methods("PUT")
+	apiV1.HandleFunc("/projects/{project}/environment", environmentHandler.GetProjectEnvironment).Methods("GET")
+	apiV1.HandleFunc("/projects/{project}/environment", environmentHandler.SetProjectEnvironment).Methods("PUT")
+	apiV1.HandleFunc("/jobs/{id}/environment", environmentHandler.GetJobEnvironment).Methods("GET")
 
 	// Builds endpoints
-	buildHandler := handlers.NewBuildHandler(db)
+	buildHandler := handlers.NewBuildHandler(db, wsHandler, metricsCollector, credStore, libraryStore, identity, settingsStore, authenticator)
 	apiV1.HandleFunc("/builds", buildHandler.ListBuilds).Methods("GET")
 	apiV1.HandleFunc("/builds/{id}", buildHandler.GetBuild).Methods("GET")
 	apiV1.HandleFunc("/builds/{id}/cancel", buildHandler.CancelBuild).Methods("POST")
-	apiV1.HandleFunc("/builds/{id}/logs", buildHandler.GetBuildLogs).Methods("GET")
+	apiV1.HandleFunc("/builds/{id}/rerun", buildHandler.RerunBuild).Methods("POST")
+	apiV1.HandleFunc("/builds/{id}/pin", buildHandler.PinBuild).Methods("PUT")
+	apiV1.HandleFunc("/builds/{id}/pin", buildHandler.UnpinBuild).Methods("DELETE")
+	apiV1.Handle("/builds/{id}/tags", handlers.DuringMaintenance(buildHandler.AddBuildTags)).Methods("POST")
+	apiV1.HandleFunc("/builds/{id}/tags/{tag:.+}", buildHandler.RemoveBuildTag).Methods("DELETE")
 	apiV1.HandleFunc("/builds/{id}/artifacts", buildHandler.ListArtifacts).Methods("GET")
-	apiV1.HandleFunc("/builds/{id}/status", buildHandler.UpdateBuildStatus).Methods("PUT")
+
+	// Build log endpoints
+	logHandler := handlers.NewLogHandler(db, ingester)
+	apiV1.HandleFunc("/builds/{id}/logs", logHandler.GetBuildLogs).Methods("GET")
+	apiV1.Handle("/builds/{id}/logs", handlers.DuringMaintenance(logHandler.AppendBuildLogs)).Methods("POST")
+
+	// Artifact browsing endpoints
+	artifactHandler := handlers.NewArtifactHandler(db, store, authenticator)
+	apiV1.HandleFunc("/builds/{id}/artifacts/tree", artifactHandler.GetArtifactTree).Methods("GET")
+	apiV1.HandleFunc("/builds/{id}/artifacts/{artifact_id}/preview", artifactHandler.GetArtifactPreview).Methods("GET")
+	apiV1.HandleFunc("/builds/{id}/artifacts/{artifact_id}/content", artifactHandler.DownloadArtifact).Methods("GET")
+	apiV1.HandleFunc("/builds/{id}/artifacts/{artifact_id}/quarantine", artifactHandler.QuarantineArtifact).Methods("POST")
+	apiV1.HandleFunc("/builds/{id}/artifacts/{artifact_id}/release", artifactHandler.ReleaseArtifact).Methods("POST")
+	apiV1.Handle("/builds/{id}/artifacts/{path:.+}", handlers.DuringMaintenance(artifactHandler.UploadArtifact)).Methods("PUT")
+
+	apiV1.HandleFunc("/builds/{id}/findings", buildHandler.ListBuildFindings).Methods("GET")
+	apiV1.Handle("/builds/{id}/findings", handlers.DuringMaintenance(buildHandler.AppendBuildFindings)).Methods("POST")
+	apiV1.HandleFunc("/builds/{id}/test-results", buildHandler.ListBuildTestResults).Methods("GET")
+	apiV1.Handle("/builds/{id}/test-results", handlers.DuringMaintenance(buildHandler.AppendBuildTestResults)).Methods("POST")
+	apiV1.HandleFunc("/builds/{id}/annotations", buildHandler.ListBuildAnnotations).Methods("GET")
+	apiV1.Handle("/builds/{id}/annotations", handlers.DuringMaintenance(buildHandler.AppendBuildAnnotations)).Methods("POST")
+	apiV1.HandleFunc("/builds/{id}/coverage", buildHandler.ListBuildCoverage).Methods("GET")
+	apiV1.Handle("/builds/{id}/coverage", handlers.DuringMaintenance(buildHandler.AppendBuildCoverage)).Methods("POST")
+	apiV1.Handle("/builds/{id}/commit", handlers.DuringMaintenance(buildHandler.UpdateBuildCommit)).Methods("PUT")
+	apiV1.HandleFunc("/builds/{id}/pipeline", buildHandler.GetBuildPipeline).Methods("GET")
+	apiV1.Handle("/builds/{id}/pipeline", handlers.DuringMaintenance(buildHandler.SetBuildPipeline)).Methods("PUT")
+	apiV1.HandleFunc("/builds/{id}/notifications", buildHandler.GetBuildNotifications).Methods("GET")
+	apiV1.HandleFunc("/builds/{id}/timeline", buildHandler.GetBuildTimeline).Methods("GET")
+	apiV1.HandleFunc("/builds/{id}/graph", buildHandler.GetBuildGraph).Methods("GET")
+	apiV1.Handle("/builds/{id}/events", handlers.DuringMaintenance(buildHandler.AppendBuildEvents)).Methods("POST")
+	apiV1.Handle("/builds/{id}/status", handlers.DuringMaintenance(buildHandler.UpdateBuildStatus)).Methods("PUT")
+
+	// Cache endpoints
+	cacheHandler := handlers.NewCacheHandler(cacheStore)
+	apiV1.HandleFunc("/cache/{key:.+}", cacheHandler.GetCacheEntry).Methods("GET", "HEAD")
+	apiV1.Handle("/cache/{key:.+}", handlers.DuringMaintenance(cacheHandler.PutCacheEntry)).Methods("PUT")
+	apiV1.HandleFunc("/builds/{id}/stages/{stage}/outputs", cacheHandler.GetStageOutputs).Methods("GET", "HEAD")
+	apiV1.Handle("/builds/{id}/stages/{stage}/outputs", handlers.DuringMaintenance(cacheHandler.PutStageOutputs)).Methods("PUT")
+
+	// Pipeline endpoints
+	pipelineHandler := handlers.NewPipelineHandler(db, libraryStore)
+	apiV1.HandleFunc("/pipelines/lint", pipelineHandler.LintPipeline).Methods("POST")
+
+	// Pipeline library endpoints
+	libraryHandler := handlers.NewLibraryHandler(db, authenticator)
+	apiV1.HandleFunc("/pipeline-libraries", libraryHandler.ListLibraries).Methods("GET")
+	apiV1.HandleFunc("/pipeline-libraries", libraryHandler.CreateLibrary).Methods("POST")
+	apiV1.HandleFunc("/pipeline-libraries/{name}", libraryHandler.GetLibrary).Methods("GET")
+	apiV1.HandleFunc("/pipeline-libraries/{name}", libraryHandler.UpdateLibrary).Methods("PUT")
+	apiV1.HandleFunc("/pipeline-libraries/{name}", libraryHandler.DeleteLibrary).Methods("DELETE")
+
+	// Build image catalog endpoints
+	imageHandler := handlers.NewImageHandler(db, authenticator)
+	apiV1.HandleFunc("/build-images", imageHandler.ListImages).Methods("GET")
+	apiV1.HandleFunc("/build-images", imageHandler.CreateImage).Methods("POST")
+	apiV1.HandleFunc("/build-images/{name}", imageHandler.GetImage).Methods("GET")
+	apiV1.HandleFunc("/build-images/{name}", imageHandler.UpdateImage).Methods("PUT")
+	apiV1.HandleFunc("/build-images/{name}", imageHandler.DeleteImage).Methods("DELETE")
+	apiV1.HandleFunc("/build-images/{name}/jobs", imageHandler.GetImageJobs).Methods("GET")
+
+	// Statistics endpoints
+	statsHandler := handlers.NewStatsHandler(db)
+	apiV1.HandleFunc("/stats", statsHandler.GetStats).Methods("GET")
+	apiV1.HandleFunc("/metrics/dora", statsHandler.GetDORAMetrics).Methods("GET")
 
 	// Workers endpoints
-	workerHandler := handlers.NewWorkerHandler(db, workerMgr)
+	workerHandler := handlers.NewWorkerHandler(db, workerMgr, wsHandler)
 	apiV1.HandleFunc("/workers", workerHandler.ListWorkers).Methods("GET")
-	apiV1.HandleFunc("/workers/register", workerHandler.RegisterWorker).Methods("POST")
+	apiV1.Handle("/workers/register", handlers.DuringMaintenance(workerHandler.RegisterWorker)).Methods("POST")
 	apiV1.HandleFunc("/workers/{id}", workerHandler.GetWorker).Methods("GET")
-	apiV1.HandleFunc("/workers/{id}", workerHandler.UpdateWorker).Methods("PUT")
-	apiV1.HandleFunc("/workers/{id}/heartbeat", workerHandler.Heartbeat).Methods("POST")
-	apiV1.HandleFunc("/workers/{id}/drain", workerHandler.DrainWorker).Methods("POST")
+	apiV1.Handle("/workers/{id}", handlers.DuringMaintenance(workerHandler.UpdateWorker)).Methods("PUT")
+	apiV1.Handle("/workers/{id}", handlers.DuringMaintenance(workerHandler.DeregisterWorker)).Methods("DELETE")
+	apiV1.Handle("/workers/{id}/heartbeat", handlers.DuringMaintenance(workerHandler.Heartbeat)).Methods("POST")
+	apiV1.Handle("/workers/{id}/drain", handlers.DuringMaintenance(workerHandler.DrainWorker)).Methods("POST")
+	apiV1.HandleFunc("/workers/{id}/metrics", workerHandler.GetWorkerMetrics).Methods("GET")
 	apiV1.HandleFunc("/workers/{worker_id}/builds", buildHandler.GetWorkerBuilds).Methods("GET")
 
+	// Worker pools endpoints
+	poolHandler := handlers.NewPoolHandler(db)
+	apiV1.HandleFunc("/pools", poolHandler.ListPools).Methods("GET")
+	apiV1.HandleFunc("/pools", poolHandler.CreatePool).Methods("POST")
+	apiV1.HandleFunc("/pools/{name}", poolHandler.GetPool).Methods("GET")
+	apiV1.HandleFunc("/pools/{name}", poolHandler.UpdatePool).Methods("PUT")
+	apiV1.HandleFunc("/pools/{name}", poolHandler.DeletePool).Methods("DELETE")
+
 	// Deployments endpoints
 	deploymentHandler := handlers.NewDeploymentHandler(db)
 	apiV1.HandleFunc("/deployments", deploymentHandler.ListDeployments).Methods("GET")
@@ -108,27 +315,97 @@ func main() {
 	apiV1.HandleFunc("/deployments/{id}/rollback", deploymentHandler.RollbackDeployment).Methods("POST")
 
 	// Plugins endpoints
-	pluginHandler := handlers.NewPluginHandler(db)
+	pluginHandler := handlers.NewPluginHandler(db, store, pluginFetcher, settingsStore, authenticator)
 	apiV1.HandleFunc("/plugins", pluginHandler.ListPlugins).Methods("GET")
 	apiV1.HandleFunc("/plugins/{id}", pluginHandler.GetPlugin).Methods("GET")
 	apiV1.HandleFunc("/plugins", pluginHandler.InstallPlugin).Methods("POST")
 
+	// Settings endpoints
+	settingsHandler := handlers.NewSettingsHandler(settingsStore, authenticator)
+	apiV1.HandleFunc("/settings", settingsHandler.GetSettings).Methods("GET")
+	apiV1.Handle("/settings", handlers.DuringMaintenance(settingsHandler.UpdateSettings)).Methods("PATCH")
+
+	// Admin endpoints
+	adminHandler := handlers.NewAdminHandler(db, settingsStore, authenticator)
+	apiV1.HandleFunc("/admin/status", adminHandler.GetStatus).Methods("GET")
+	apiV1.HandleFunc("/admin/scheduler/pause", adminHandler.PauseScheduler).Methods("POST")
+	apiV1.HandleFunc("/admin/scheduler/resume", adminHandler.ResumeScheduler).Methods("POST")
+	apiV1.HandleFunc("/admin/jobs/{id}/queue/pause", adminHandler.PauseJobQueue).Methods("POST")
+	apiV1.HandleFunc("/admin/jobs/{id}/queue/resume", adminHandler.ResumeJobQueue).Methods("POST")
+	apiV1.HandleFunc("/admin/maintenance", adminHandler.SetMaintenanceMode).Methods("PUT")
+	apiV1.HandleFunc("/admin/builds/stuck", adminHandler.ListStuckBuilds).Methods("GET")
+	apiV1.HandleFunc("/admin/builds/requeue", adminHandler.RequeueBuilds).Methods("POST")
+	apiV1.HandleFunc("/admin/builds/fail", adminHandler.FailBuilds).Methods("POST")
+	apiV1.HandleFunc("/admin/builds/{id}/requeue", adminHandler.RequeueBuild).Methods("POST")
+	apiV1.HandleFunc("/admin/builds/{id}/fail", adminHandler.FailBuild).Methods("POST")
+
+	// License exception endpoints
+	licenseHandler := handlers.NewLicenseExceptionHandler(db, authenticator)
+	apiV1.HandleFunc("/license-exceptions", licenseHandler.ListLicenseExceptions).Methods("GET")
+	apiV1.HandleFunc("/license-exceptions", licenseHandler.RequestLicenseException).Methods("POST")
+	apiV1.HandleFunc("/license-exceptions/{id}", licenseHandler.GetLicenseException).Methods("GET")
+	apiV1.HandleFunc("/license-exceptions/{id}", licenseHandler.RevokeLicenseException).Methods("DELETE")
+	apiV1.HandleFunc("/license-exceptions/{id}/approve", licenseHandler.ApproveLicenseException).Methods("POST")
+	apiV1.HandleFunc("/license-exceptions/{id}/reject", licenseHandler.RejectLicenseException).Methods("POST")
+
+	// Alert endpoints
+	alertHandler := handlers.NewAlertHandler(db, settingsStore, authenticator)
+	apiV1.HandleFunc("/admin/alerts", alertHandler.ListAlerts).Methods("GET")
+	apiV1.Handle("/workers/{id}/alerts/claim", handlers.DuringMaintenance(alertHandler.ClaimAlerts)).Methods("POST")
+	apiV1.Handle("/workers/{id}/alerts/{alert_id}/delivered", handlers.DuringMaintenance(alertHandler.AlertDelivered)).Methods("POST")
+
+	// Plugin registry endpoints
+	registryHandler := handlers.NewRegistryHandler(db, store, pluginFetcher, authenticator)
+	apiV1.HandleFunc("/registry/plugins", registryHandler.SearchPlugins).Methods("GET")
+	apiV1.HandleFunc("/registry/plugins", registryHandler.PublishPlugin).Methods("POST")
+	apiV1.HandleFunc("/registry/plugins/{name}", registryHandler.ListPluginVersions).Methods("GET")
+	apiV1.HandleFunc("/registry/plugins/{name}/{version}", registryHandler.GetPluginVersion).Methods("GET")
+	apiV1.HandleFunc("/registry/plugins/{name}/{version}", registryHandler.YankPluginVersion).Methods("DELETE")
+	apiV1.HandleFunc("/registry/plugins/{name}/{version}/download", registryHandler.DownloadPlugin).Methods("GET")
+
+	// GitOps endpoints
+	if cfg.GitOps.Enabled {
+		gitopsManager, err := gitops.NewManager(&cfg.GitOps, db, credStore)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize GitOps sync")
+		}
+		if err := gitopsManager.Start(); err != nil {
+			log.Error().Err(err).Msg("GitOps sync failed to start for some sources")
+		}
+		defer gitopsManager.Stop()
+
+		// Libraries can be kept in the GitOps repositories
+		libraryStore.SetSources(gitopsManager)
+
+		gitopsHandler := handlers.NewGitOpsHandler(gitopsManager)
+		apiV1.HandleFunc("/gitops/status", gitopsHandler.GetStatus).Methods("GET")
+		apiV1.HandleFunc("/gitops/sync", gitopsHandler.TriggerSync).Methods("POST")
+	}
+
+	// OIDC provider endpoints
+	if identity != nil {
+		oidcHandler := handlers.NewOIDCHandler(db, identity)
+		router.HandleFunc(oidc.DiscoveryPath, oidcHandler.GetDiscovery).Methods("GET")
+		router.HandleFunc(oidc.JWKSPath, oidcHandler.GetJWKS).Methods("GET")
+		apiV1.HandleFunc("/builds/{id}/oidc/token", oidcHandler.IssueBuildToken).Methods("GET")
+	}
+
 	// Metrics endpoint (Prometheus)
 	router.Handle("/metrics", metrics.Handler())
 
 	// Webhooks endpoint
-	webhookHandler := handlers.NewWebhookHandler(db, sched)
+	webhookHandler := handlers.NewWebhookHandler(db, sched, authenticator)
 	router.HandleFunc("/webhooks/{source}/{jobId}", webhookHandler.HandleWebhook).Methods("POST")
+	apiV1.HandleFunc("/jobs/{id}/webhooks/{source}", webhookHandler.SetWebhookSecret).Methods("PUT")
 
 	// WebSocket for real-time updates
-	wsHandler := handlers.NewWebSocketHandler()
 	router.HandleFunc("/ws", wsHandler.HandleConnection)
 
 	// CORS configuration
 	c := cors.New(cors.Options{
 		AllowedOrigins:   cfg.CORSAllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "traceparent", "tracestate"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
@@ -167,6 +444,9 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to flush traces")
+	}
 
 	log.Info().Msg("Server exited")
 }
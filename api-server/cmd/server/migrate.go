@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+const migrateUsage = `Usage: %s migrate <command>
+
+Commands:
+  up               Apply all pending migrations
+  down [N]         Revert the last N applied migrations (default 1)
+  status           Show the current version and the pending migrations
+  force VERSION    Record the database at VERSION without running migrations,
+                   after repairing a migration that failed halfway
+`
+
+// runMigrate runs the migrate subcommand and returns the exit code
+func runMigrate(databaseURL string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, migrateUsage, filepath.Base(os.Args[0]))
+		return 2
+	}
+
+	m, err := database.NewMigrator(databaseURL)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open migrations")
+		return 1
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintf(os.Stderr, "Invalid number of migrations: %s\n", args[1])
+				return 2
+			}
+		}
+		err = m.Down(steps)
+	case "force":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, migrateUsage, filepath.Base(os.Args[0]))
+			return 2
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			fmt.Fprintf(os.Stderr, "Invalid version: %s\n", args[1])
+			return 2
+		}
+		err = m.Force(version)
+	case "status":
+		err = printMigrationStatus(m)
+	default:
+		fmt.Fprintf(os.Stderr, migrateUsage, filepath.Base(os.Args[0]))
+		return 2
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("command", args[0]).Msg("Migration failed")
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus(m *database.Migrator) error {
+	version, dirty, migrations, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	state := ""
+	if dirty {
+		state = " (dirty: the last migration failed and must be repaired, then forced)"
+	}
+	fmt.Printf("Current version: %d%s\n\n", version, state)
+	for _, migration := range migrations {
+		status := "pending"
+		if migration.Applied {
+			status = "applied"
+		}
+		fmt.Printf("%06d  %-8s %s\n", migration.Version, status, migration.Name)
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package alerts
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+)
+
+// Kinds of alerts
+const (
+	KindWorkerOffline = "worker_offline" // a worker missed its heartbeats
+	KindQueueWait     = "queue_wait"     // a pool's oldest queued build has waited too long
+	KindNoCapacity    = "no_capacity"    // a pool has had queued builds and no free worker for too long
+)
+
+// Events whose notifications workers deliver
+const (
+	EventFired    = "fired"
+	EventResolved = "resolved"
+)
+
+const (
+	// interval is how often the conditions are checked
+	interval = time.Minute
+	// lockKey is the advisory lock that keeps servers sharing a database from
+	// checking the conditions at the same time
+	lockKey = 7_350_003
+	// resolvedRetention is how long resolved alerts are kept
+	resolvedRetention = 30 * 24 * time.Hour
+	// eventChannel is the channel alert events are published on, with the
+	// worker events
+	eventChannel = "workers"
+)
+
+// Monitor watches worker and queue health and raises an alert for each
+// condition it finds, resolving it once the condition clears. Alerts that
+// fire or resolve while the instance settings have alert notifications are
+// left pending, for a worker to deliver their notifications.
+type Monitor struct {
+	db       *database.Database
+	settings *settings.Store
+}
+
+// NewMonitor creates a monitor that checks the conditions the instance
+// settings turn on, with their thresholds, as they are at each run
+func NewMonitor(db *database.Database, settings *settings.Store) *Monitor {
+	return &Monitor{db: db, settings: settings}
+}
+
+// Start checks the conditions every minute until the context is cancelled
+func (m *Monitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Info().Msg("Alert monitor started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Alert monitor stopped")
+			return
+		case <-ticker.C:
+			if err := m.run(ctx); err != nil {
+				log.Error().Err(err).Msg("Alert monitor failed")
+			}
+		}
+	}
+}
+
+// condition is an alert condition found by a check. It fires once it has
+// lasted for its threshold.
+type condition struct {
+	kind      string
+	subject   string
+	message   string
+	details   map[string]interface{}
+	threshold time.Duration
+}
+
+// key identifies the open alert of a condition
+func (c condition) key() string {
+	return c.kind + "\x00" + c.subject
+}
+
+// run checks the conditions and updates the alerts unless another server
+// already is
+func (m *Monitor) run(ctx context.Context) error {
+	s := m.settings.Current()
+	notify := len(s.AlertNotifications) > 0
+
+	return m.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var locked bool
+		if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, lockKey).Scan(&locked); err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		conditions, err := check(ctx, tx, s)
+		if err != nil {
+			return err
+		}
+		found := map[string]bool{}
+		for _, c := range conditions {
+			found[c.key()] = true
+			if err := raise(ctx, tx, c, notify); err != nil {
+				return err
+			}
+		}
+		if err := resolve(ctx, tx, found, notify); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM alerts WHERE resolved_at < $1`, time.Now().Add(-resolvedRetention))
+		return err
+	})
+}
+
+// check returns the conditions the settings turn on that hold now. Queue
+// conditions are not checked while the scheduler is paused, and builds of
+// paused job queues are left out of them.
+func check(ctx context.Context, tx *sql.Tx, s settings.Settings) ([]condition, error) {
+	conditions := []condition{}
+
+	if s.AlertWorkerOffline {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id, name, COALESCE(pool, ''), last_heartbeat
+			FROM workers
+			WHERE status = 'offline'
+		`)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			var name, pool string
+			var lastHeartbeat sql.NullTime
+			if err := rows.Scan(&id, &name, &pool, &lastHeartbeat); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			message := fmt.Sprintf("Worker %s is offline", name)
+			details := map[string]interface{}{"worker_id": id, "worker_name": name, "pool": pool}
+			if lastHeartbeat.Valid {
+				message += ": no heartbeat since " + lastHeartbeat.Time.UTC().Format(time.RFC3339)
+				details["last_heartbeat"] = lastHeartbeat.Time
+			}
+			conditions = append(conditions, condition{kind: KindWorkerOffline, subject: id.String(), message: message, details: details})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.SchedulerPaused {
+		return conditions, nil
+	}
+
+	if s.AlertQueueWaitMinutes > 0 {
+		wait := time.Duration(s.AlertQueueWaitMinutes) * time.Minute
+		rows, err := tx.QueryContext(ctx, `
+			SELECT COALESCE(j.pool, ''), COUNT(*), MIN(b.queued_at)
+			FROM builds b
+			JOIN jobs j ON j.id = b.job_id
+			WHERE b.status = 'queued'
+			  AND j.queue_paused_at IS NULL
+			GROUP BY 1
+			HAVING MIN(b.queued_at) < $1
+		`, time.Now().Add(-wait))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var pool string
+			var queued int
+			var oldest time.Time
+			if err := rows.Scan(&pool, &queued, &oldest); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			waited := time.Since(oldest).Round(time.Minute)
+			conditions = append(conditions, condition{
+				kind:    KindQueueWait,
+				subject: pool,
+				message: fmt.Sprintf("%d builds queued in %s; the oldest has waited %s", queued, poolName(pool), waited),
+				details: map[string]interface{}{"pool": pool, "queued_builds": queued, "oldest_queued_at": oldest},
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.AlertNoCapacityMinutes > 0 {
+		threshold := time.Duration(s.AlertNoCapacityMinutes) * time.Minute
+		rows, err := tx.QueryContext(ctx, `
+			SELECT COALESCE(j.pool, ''), COUNT(*)
+			FROM builds b
+			JOIN jobs j ON j.id = b.job_id
+			WHERE b.status = 'queued'
+			  AND j.queue_paused_at IS NULL
+			  AND NOT EXISTS (
+			      SELECT 1 FROM workers w
+			      WHERE w.status = 'online'
+			        AND w.current_builds < w.max_concurrent_builds
+			        AND w.pool IS NOT DISTINCT FROM j.pool
+			        AND NOT COALESCE((w.resources->>'disk_full')::boolean, false)
+			  )
+			GROUP BY 1
+		`)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var pool string
+			var queued int
+			if err := rows.Scan(&pool, &queued); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			conditions = append(conditions, condition{
+				kind:      KindNoCapacity,
+				subject:   pool,
+				message:   fmt.Sprintf("No worker of %s has been free for %s; %d builds are queued", poolName(pool), threshold, queued),
+				details:   map[string]interface{}{"pool": pool, "queued_builds": queued},
+				threshold: threshold,
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return conditions, nil
+}
+
+// raise records a condition as the open alert of its kind and subject,
+// firing it once the condition has lasted for its threshold
+func raise(ctx context.Context, tx *sql.Tx, c condition, notify bool) error {
+	details, _ := json.Marshal(c.details)
+
+	var id uuid.UUID
+	var since time.Time
+	var fired bool
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO alerts (kind, subject, message, details)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (kind, subject) WHERE resolved_at IS NULL DO UPDATE SET
+			message = EXCLUDED.message,
+			details = EXCLUDED.details
+		RETURNING id, since, fired_at IS NOT NULL
+	`, c.kind, c.subject, c.message, details).Scan(&id, &since, &fired)
+	if err != nil {
+		return err
+	}
+	if fired || time.Since(since) < c.threshold {
+		return nil
+	}
+
+	var pending interface{}
+	if notify {
+		pending = EventFired
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE alerts
+		SET fired_at = CURRENT_TIMESTAMP, pending_event = $2, claimed_by = NULL, claimed_at = NULL
+		WHERE id = $1
+	`, id, pending)
+	if err != nil {
+		return err
+	}
+
+	log.Warn().Str("alert_id", id.String()).Str("kind", c.kind).Str("subject", c.subject).Msg("Alert fired: " + c.message)
+	return outbox.Write(ctx, tx, eventChannel, "alert.fired", map[string]interface{}{
+		"id": id, "kind": c.kind, "subject": c.subject, "message": c.message, "details": c.details,
+	})
+}
+
+// resolve resolves the fired alerts whose condition was not found, and
+// forgets those that cleared before they fired
+func resolve(ctx context.Context, tx *sql.Tx, found map[string]bool, notify bool) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, kind, subject, message, fired_at IS NOT NULL
+		FROM alerts
+		WHERE resolved_at IS NULL
+	`)
+	if err != nil {
+		return err
+	}
+	type openAlert struct {
+		id                     uuid.UUID
+		kind, subject, message string
+		fired                  bool
+	}
+	cleared := []openAlert{}
+	for rows.Next() {
+		var a openAlert
+		if err := rows.Scan(&a.id, &a.kind, &a.subject, &a.message, &a.fired); err != nil {
+			rows.Close()
+			return err
+		}
+		if !found[condition{kind: a.kind, subject: a.subject}.key()] {
+			cleared = append(cleared, a)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var pending interface{}
+	if notify {
+		pending = EventResolved
+	}
+	for _, a := range cleared {
+		if !a.fired {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM alerts WHERE id = $1`, a.id); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE alerts
+			SET resolved_at = CURRENT_TIMESTAMP, pending_event = $2, claimed_by = NULL, claimed_at = NULL
+			WHERE id = $1
+		`, a.id, pending)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Str("alert_id", a.id.String()).Str("kind", a.kind).Str("subject", a.subject).Msg("Alert resolved: " + a.message)
+		err = outbox.Write(ctx, tx, eventChannel, "alert.resolved", map[string]interface{}{
+			"id": a.id, "kind": a.kind, "subject": a.subject, "message": a.message,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// poolName names a pool in alert messages
+func poolName(pool string) string {
+	if pool == "" {
+		return "the default pool"
+	}
+	return "pool " + pool
+}
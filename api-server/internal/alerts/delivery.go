@@ -0,0 +1,110 @@
+package alerts
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+const (
+	// claimBatch is how many alerts a worker claims at once
+	claimBatch = 10
+	// claimTimeout is how long a claimed alert waits for its worker to
+	// deliver its notifications before another worker may claim it
+	claimTimeout = 5 * time.Minute
+)
+
+// alertColumns are the columns scanned by scanAlert
+const alertColumns = `id, kind, subject, message, details, since, fired_at, resolved_at, COALESCE(pending_event, '')`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAlert(row scanner) (models.Alert, error) {
+	var a models.Alert
+	err := row.Scan(&a.ID, &a.Kind, &a.Subject, &a.Message, &a.Details, &a.Since, &a.FiredAt, &a.ResolvedAt, &a.PendingEvent)
+	return a, err
+}
+
+// Claim claims, for a worker, the alerts whose notifications are pending and
+// not claimed by another worker within the claim timeout, oldest first
+func Claim(ctx context.Context, db *database.Database, workerID uuid.UUID) ([]models.Alert, error) {
+	rows, err := db.GetConn().QueryContext(ctx, `
+		UPDATE alerts
+		SET claimed_by = $1, claimed_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM alerts
+			WHERE pending_event IS NOT NULL
+			  AND (claimed_at IS NULL OR claimed_at < $2)
+			ORDER BY fired_at ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+alertColumns,
+		workerID, time.Now().Add(-claimTimeout), claimBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := []models.Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, a)
+	}
+	return claimed, rows.Err()
+}
+
+// Delivered records that a worker delivered the notifications of an alert's
+// event. It reports false if the alert is no longer pending that event for
+// the worker, e.g. because it resolved in the meantime.
+func Delivered(ctx context.Context, db *database.Database, alertID, workerID uuid.UUID, event string) (bool, error) {
+	result, err := db.GetConn().ExecContext(ctx, `
+		UPDATE alerts
+		SET pending_event = NULL, claimed_by = NULL, claimed_at = NULL
+		WHERE id = $1 AND claimed_by = $2 AND pending_event = $3
+	`, alertID, workerID, event)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// List returns the alerts that fired, newest first: the open ones, the
+// resolved ones, or both if open is nil
+func List(ctx context.Context, db *database.Database, open *bool, limit int) ([]models.Alert, error) {
+	query := `SELECT ` + alertColumns + ` FROM alerts WHERE fired_at IS NOT NULL`
+	if open != nil {
+		if *open {
+			query += ` AND resolved_at IS NULL`
+		} else {
+			query += ` AND resolved_at IS NOT NULL`
+		}
+	}
+	query += ` ORDER BY fired_at DESC LIMIT $1`
+
+	rows, err := db.GetConn().QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []models.Alert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
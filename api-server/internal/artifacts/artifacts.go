@@ -0,0 +1,67 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/google/uuid"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// StoragePlugin is the storage_plugin of artifacts held in the server's
+// artifact storage, whose storage_url is their storage key
+const StoragePlugin = "solvyd"
+
+// ErrChecksumMismatch is returned when an artifact's content does not match
+// its recorded checksum
+var ErrChecksumMismatch = errors.New("artifact checksum mismatch")
+
+// VerifyingReader hashes the content read through it, to compare it with an
+// artifact's checksum once it has all been read
+type VerifyingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+// NewVerifyingReader wraps r, the content of an artifact with the expected
+// SHA-256 checksum (hex)
+func NewVerifyingReader(r io.Reader, expected string) *VerifyingReader {
+	h := sha256.New()
+	return &VerifyingReader{r: io.TeeReader(r, h), hash: h, expected: expected}
+}
+
+func (v *VerifyingReader) Read(p []byte) (int, error) {
+	return v.r.Read(p)
+}
+
+// Verify returns ErrChecksumMismatch if the content read so far does not
+// match the checksum; call it once the content has all been read. Artifacts
+// recorded without a checksum always pass.
+func (v *VerifyingReader) Verify() error {
+	if v.expected == "" {
+		return nil
+	}
+	if actual := hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, v.expected, actual)
+	}
+	return nil
+}
+
+// SetVerified records the integrity status found by verifying an artifact's
+// checksum. A quarantined artifact stays quarantined.
+func SetVerified(ctx context.Context, db *database.Database, id uuid.UUID, status models.ArtifactStatus, reason string) error {
+	_, err := db.GetConn().ExecContext(ctx, `
+		UPDATE artifacts
+		SET status = $2, status_reason = NULLIF($3, ''), verified_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status <> $4
+	`, id, status, reason, models.ArtifactStatusQuarantined)
+	return err
+}
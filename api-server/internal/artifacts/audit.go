@@ -0,0 +1,169 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+	"github.com/solvyd/solvyd/api-server/internal/storage"
+)
+
+const (
+	// auditInterval is how often the integrity audit runs
+	auditInterval = time.Hour
+	// auditLockKey is the advisory lock that keeps servers sharing a database
+	// from auditing at the same time
+	auditLockKey = 7_350_002
+)
+
+// Auditor re-hashes stored artifacts and flags those whose content no longer
+// matches their checksum as corrupted, and those gone from storage as
+// missing. Each run verifies the batch size of the instance settings, least
+// recently verified first, so that every artifact is verified in turn.
+type Auditor struct {
+	db       *database.Database
+	storage  storage.Storage
+	settings *settings.Store
+}
+
+// NewAuditor creates an auditor of the artifacts in store
+func NewAuditor(db *database.Database, store storage.Storage, settings *settings.Store) *Auditor {
+	return &Auditor{db: db, storage: store, settings: settings}
+}
+
+// Start audits artifacts every hour until the context is cancelled
+func (a *Auditor) Start(ctx context.Context) {
+	ticker := time.NewTicker(auditInterval)
+	defer ticker.Stop()
+
+	log.Info().Msg("Artifact integrity audit started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Artifact integrity audit stopped")
+			return
+		case <-ticker.C:
+			if err := a.run(ctx); err != nil {
+				log.Error().Err(err).Msg("Artifact integrity audit failed")
+			}
+		}
+	}
+}
+
+// auditedArtifact is an artifact due for verification
+type auditedArtifact struct {
+	id       uuid.UUID
+	key      string
+	checksum string
+	status   models.ArtifactStatus
+}
+
+// run verifies a batch of artifacts unless another server already is
+func (a *Auditor) run(ctx context.Context) error {
+	batch := a.settings.Current().ArtifactAuditBatchSize
+	if batch <= 0 {
+		return nil
+	}
+
+	// The lock is held for the whole run, longer than a transaction should
+	// last, so it is taken on a connection of its own
+	conn, err := a.db.GetConn().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, auditLockKey).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, auditLockKey)
+
+	// Corrupted and missing artifacts are verified again, in case their
+	// content was restored
+	rows, err := a.db.GetConn().QueryContext(ctx, `
+		SELECT id, storage_url, COALESCE(checksum_sha256, ''), status
+		FROM artifacts
+		WHERE storage_plugin = $1 AND status <> $2
+		ORDER BY verified_at ASC NULLS FIRST
+		LIMIT $3
+	`, StoragePlugin, models.ArtifactStatusQuarantined, batch)
+	if err != nil {
+		return err
+	}
+	var due []auditedArtifact
+	for rows.Next() {
+		var artifact auditedArtifact
+		if err := rows.Scan(&artifact.id, &artifact.key, &artifact.checksum, &artifact.status); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, artifact)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, artifact := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		status, reason := a.verify(ctx, artifact)
+		if status == "" {
+			continue
+		}
+		if status != models.ArtifactStatusAvailable {
+			failed++
+		}
+		if status != artifact.status {
+			log.Warn().
+				Str("artifact_id", artifact.id.String()).
+				Str("status", string(status)).
+				Str("reason", reason).
+				Msg("Artifact integrity status changed")
+		}
+		if err := SetVerified(ctx, a.db, artifact.id, status, reason); err != nil {
+			return err
+		}
+	}
+
+	log.Info().Int("verified", len(due)).Int("failed", failed).Msg("Artifact integrity audit finished")
+	return nil
+}
+
+// verify hashes an artifact's content and returns its status, or "" if it
+// could not be read for a reason other than it missing
+func (a *Auditor) verify(ctx context.Context, artifact auditedArtifact) (models.ArtifactStatus, string) {
+	body, err := a.storage.Get(ctx, artifact.key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return models.ArtifactStatusMissing, "content not found in storage"
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("artifact_id", artifact.id.String()).Msg("Failed to read artifact for integrity audit")
+		return "", ""
+	}
+	defer body.Close()
+
+	verifier := NewVerifyingReader(body, artifact.checksum)
+	if _, err := io.Copy(io.Discard, verifier); err != nil {
+		log.Warn().Err(err).Str("artifact_id", artifact.id.String()).Msg("Failed to read artifact for integrity audit")
+		return "", ""
+	}
+	if err := verifier.Verify(); err != nil {
+		return models.ArtifactStatusCorrupted, err.Error()
+	}
+	return models.ArtifactStatusAvailable, ""
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// ErrUnauthenticated is returned for a missing, malformed, expired or revoked
+// token, or one of an inactive user
+var ErrUnauthenticated = errors.New("authentication required")
+
+// Roles with special rights
+const (
+	RoleAdmin      = "admin"      // can read every project
+	RoleCompliance = "compliance" // decides license exceptions
+)
+
+// Principal is the authenticated user behind a request
+type Principal struct {
+	UserID   uuid.UUID
+	Username string
+	Roles    []string
+	Projects []string // the projects the user can read, besides jobs without one
+}
+
+// IsAdmin reports whether the user has the admin role
+func (p *Principal) IsAdmin() bool {
+	return p.HasRole(RoleAdmin)
+}
+
+// HasRole reports whether the user has a role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CanReadProject reports whether the user can read the jobs of a project.
+// Jobs without a project are readable by every user.
+func (p *Principal) CanReadProject(project string) bool {
+	if project == "" || p.IsAdmin() {
+		return true
+	}
+	for _, allowed := range p.Projects {
+		if allowed == project {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves bearer tokens to users. A token is either an HS256
+// JWT signed with the server's JWT secret, whose subject is the user ID, or
+// an API token stored (hashed) in api_tokens.
+type Authenticator struct {
+	db        *database.Database
+	jwtSecret []byte
+}
+
+// NewAuthenticator creates an authenticator
+func NewAuthenticator(db *database.Database, jwtSecret string) *Authenticator {
+	return &Authenticator{db: db, jwtSecret: []byte(jwtSecret)}
+}
+
+// TokenFromRequest returns the bearer token of a request, from the
+// Authorization header or, for browsers that cannot set headers on a
+// WebSocket, the access_token query parameter
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// Authenticate returns the user a token belongs to
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	if strings.Count(token, ".") == 2 {
+		userID, err := a.verifyJWT(token, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return a.user(ctx, `SELECT id, username, roles, projects FROM users WHERE id = $1 AND active`, userID)
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	principal, err := a.user(ctx, `
+		SELECT u.id, u.username, u.roles, u.projects
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.revoked_at IS NULL
+		  AND (t.expires_at IS NULL OR t.expires_at > CURRENT_TIMESTAMP)
+		  AND u.active
+	`, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.db.GetConn().ExecContext(ctx, `
+		UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE token_hash = $1
+	`, hex.EncodeToString(sum[:])); err != nil {
+		log.Warn().Err(err).Msg("Failed to record API token use")
+	}
+	return principal, nil
+}
+
+func (a *Authenticator) user(ctx context.Context, query string, arg interface{}) (*Principal, error) {
+	var p Principal
+	var roles, projects []string
+	err := a.db.GetConn().QueryRowContext(ctx, query, arg).
+		Scan(&p.UserID, &p.Username, pq.Array(&roles), pq.Array(&projects))
+	if err == sql.ErrNoRows {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Roles, p.Projects = roles, projects
+	return &p, nil
+}
+
+// jwtClaims are the registered claims checked in a JWT
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+// verifyJWT checks the signature and validity period of an HS256 JWT and
+// returns its subject. Tokens without an expiry are rejected.
+func (a *Authenticator) verifyJWT(token string, now time.Time) (uuid.UUID, error) {
+	if len(a.jwtSecret) == 0 {
+		return uuid.Nil, fmt.Errorf("%w: JWT secret is not configured", ErrUnauthenticated)
+	}
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return uuid.Nil, fmt.Errorf("%w: unsupported JWT", ErrUnauthenticated)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: malformed JWT signature", ErrUnauthenticated)
+	}
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return uuid.Nil, fmt.Errorf("%w: invalid JWT signature", ErrUnauthenticated)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return uuid.Nil, fmt.Errorf("%w: malformed JWT claims", ErrUnauthenticated)
+	}
+	if claims.ExpiresAt == 0 || now.Unix() >= claims.ExpiresAt {
+		return uuid.Nil, fmt.Errorf("%w: JWT expired", ErrUnauthenticated)
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return uuid.Nil, fmt.Errorf("%w: JWT not yet valid", ErrUnauthenticated)
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: JWT subject is not a user ID", ErrUnauthenticated)
+	}
+	return userID, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
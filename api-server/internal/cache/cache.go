@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/storage"
+)
+
+// DefaultScope is the scope of entries stored without one
+const DefaultScope = "global"
+
+// StageOutputScope is the scope the outputs of pipeline stages are stored
+// in, with the build's ID as the version and the stage's name as the key
+const StageOutputScope = "stage-outputs"
+
+// ErrNotFound is returned for a cache entry that does not exist
+var ErrNotFound = errors.New("cache entry not found")
+
+// Store keeps cache entries in artifact storage, recording them in the
+// cache_entries table. Entries are addressed by key within a scope, such as a
+// project, and a version, so that a tool changing its entry format does not
+// read entries written by older versions. Writing an entry replaces the one
+// stored under the same address.
+type Store struct {
+	db      *database.Database
+	storage storage.Storage
+}
+
+// NewStore creates a cache store on top of store
+func NewStore(db *database.Database, store storage.Storage) *Store {
+	return &Store{db: db, storage: store}
+}
+
+// Stat returns the entry stored under an address without reading it
+func (s *Store) Stat(ctx context.Context, scope, version, key string) (*models.CacheEntry, error) {
+	entry := models.CacheEntry{Scope: scope, Version: version, Key: key}
+	err := s.db.GetConn().QueryRowContext(ctx, `
+		SELECT size_bytes, checksum_sha256, storage_key, created_at, last_accessed_at
+		FROM cache_entries
+		WHERE scope = $1 AND version = $2 AND key = $3
+	`, scope, version, key).Scan(&entry.SizeBytes, &entry.ChecksumSHA256, &entry.StorageKey,
+		&entry.CreatedAt, &entry.LastAccessedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Open returns the entry stored under an address and its content, marking it
+// accessed so that it is not evicted. An entry whose content is gone from
+// storage, e.g. evicted while being written again, is forgotten and reported
+// as not found.
+func (s *Store) Open(ctx context.Context, scope, version, key string) (*models.CacheEntry, io.ReadCloser, error) {
+	entry, err := s.Stat(ctx, scope, version, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := s.storage.Get(ctx, entry.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		_, err := s.db.GetConn().ExecContext(ctx, `
+			DELETE FROM cache_entries WHERE scope = $1 AND version = $2 AND key = $3
+		`, scope, version, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := s.db.GetConn().ExecContext(ctx, `
+		UPDATE cache_entries SET last_accessed_at = CURRENT_TIMESTAMP
+		WHERE scope = $1 AND version = $2 AND key = $3
+	`, scope, version, key); err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	return entry, body, nil
+}
+
+// Put stores size bytes read from r, whose SHA-256 checksum is checksum, under
+// an address
+func (s *Store) Put(ctx context.Context, scope, version, key string, r io.Reader, size int64, checksum string) (*models.CacheEntry, error) {
+	entry := models.CacheEntry{
+		Scope:          scope,
+		Version:        version,
+		Key:            key,
+		SizeBytes:      size,
+		ChecksumSHA256: checksum,
+		StorageKey:     storageKey(scope, version, key),
+	}
+	if err := s.storage.Put(ctx, entry.StorageKey, r, size); err != nil {
+		return nil, err
+	}
+
+	err := s.db.GetConn().QueryRowContext(ctx, `
+		INSERT INTO cache_entries (scope, version, key, size_bytes, checksum_sha256, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (scope, version, key) DO UPDATE
+		SET size_bytes = EXCLUDED.size_bytes,
+		    checksum_sha256 = EXCLUDED.checksum_sha256,
+		    storage_key = EXCLUDED.storage_key,
+		    created_at = CURRENT_TIMESTAMP,
+		    last_accessed_at = CURRENT_TIMESTAMP
+		RETURNING created_at, last_accessed_at
+	`, scope, version, key, size, checksum, entry.StorageKey).Scan(&entry.CreatedAt, &entry.LastAccessedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// storageKey is where the entry stored under an address is kept. Keys are
+// hashed because cache keys are free-form, and may hold characters or path
+// segments storage keys cannot.
+func storageKey(scope, version, key string) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + version + "\x00" + key))
+	return "cache/" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+)
+
+// evictInterval is how often unused cache entries are evicted
+const evictInterval = time.Hour
+
+// Evictor removes the cache entries that were not read or written for the
+// TTL of the instance settings, so that the cache does not grow forever
+type Evictor struct {
+	store    *Store
+	settings *settings.Store
+}
+
+// NewEvictor creates an evictor of the entries of store
+func NewEvictor(store *Store, settings *settings.Store) *Evictor {
+	return &Evictor{store: store, settings: settings}
+}
+
+// Start evicts unused entries every hour until the context is cancelled
+func (e *Evictor) Start(ctx context.Context) {
+	ticker := time.NewTicker(evictInterval)
+	defer ticker.Stop()
+
+	log.Info().Msg("Cache eviction started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Cache eviction stopped")
+			return
+		case <-ticker.C:
+			if err := e.run(ctx); err != nil {
+				log.Error().Err(err).Msg("Cache eviction failed")
+			}
+		}
+	}
+}
+
+// run evicts the entries last accessed before the TTL. Servers sharing a
+// database may run at the same time: each entry is deleted by one of them.
+func (e *Evictor) run(ctx context.Context) error {
+	ttl := time.Duration(e.settings.Current().CacheEntryTTLDays) * 24 * time.Hour
+	if ttl <= 0 {
+		return nil
+	}
+
+	rows, err := e.store.db.GetConn().QueryContext(ctx, `
+		DELETE FROM cache_entries WHERE last_accessed_at < $1 RETURNING storage_key, size_bytes
+	`, time.Now().Add(-ttl))
+	if err != nil {
+		return err
+	}
+	var keys []string
+	var freed int64
+	for rows.Next() {
+		var key string
+		var size int64
+		if err := rows.Scan(&key, &size); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+		freed += size
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	for _, key := range keys {
+		if err := e.store.storage.Delete(ctx, key); err != nil {
+			log.Warn().Err(err).Str("storage_key", key).Msg("Failed to delete evicted cache entry")
+		}
+	}
+	log.Info().Int("entries", len(keys)).Int64("freed_bytes", freed).Msg("Evicted unused cache entries")
+	return nil
+}
@@ -0,0 +1,209 @@
+package credentials
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lib/pq"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// ErrNotFound is returned when a credential does not exist
+var ErrNotFound = errors.New("credential not found")
+
+// ErrNotOwned is returned by Upsert when a credential of the name exists and
+// was created by someone else
+var ErrNotOwned = errors.New("credential created by someone else")
+
+// ValidTypes lists the supported credential types
+var ValidTypes = map[string]bool{
+	"token":             true,
+	"username_password": true,
+	"ssh_key":           true,
+	"certificate":       true,
+	"secret_ref":        true,
+}
+
+// Credential is a decrypted credential
+type Credential struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Description string            `json:"description"`
+	Data        map[string]string `json:"-"`
+	CreatedBy   string            `json:"created_by"`
+}
+
+// Store persists credentials encrypted with AES-256-GCM
+type Store struct {
+	db    *database.Database
+	aead  cipher.AEAD
+	keyID string
+}
+
+// NewStore creates a credential store. The key is either a base64-encoded
+// 32-byte key or a passphrase that is hashed into one.
+func NewStore(db *database.Database, key string) (*Store, error) {
+	if key == "" {
+		return nil, fmt.Errorf("credentials encryption key is not configured")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		sum := sha256.Sum256([]byte(key))
+		raw = sum[:]
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key ID lets a future rotation tell which key encrypted a row
+	id := sha256.Sum256(raw)
+
+	return &Store{
+		db:    db,
+		aead:  aead,
+		keyID: "local:" + hex.EncodeToString(id[:4]),
+	}, nil
+}
+
+// Upsert encrypts and stores a credential keyed by name. It reports whether
+// the credential was newly created. An existing credential is only replaced
+// if it has the same creator, or ErrNotOwned is returned.
+func (s *Store) Upsert(ctx context.Context, c *Credential) (bool, error) {
+	if !ValidTypes[c.Type] {
+		return false, fmt.Errorf("unsupported credential type %q", c.Type)
+	}
+
+	encrypted, err := s.encrypt(c.Data)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		INSERT INTO credentials (name, type, encrypted_data, encryption_key_id, description, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name) DO UPDATE SET
+			type = EXCLUDED.type,
+			encrypted_data = EXCLUDED.encrypted_data,
+			encryption_key_id = EXCLUDED.encryption_key_id,
+			description = EXCLUDED.description,
+			created_by = EXCLUDED.created_by
+		WHERE credentials.created_by = EXCLUDED.created_by
+		RETURNING (xmax = 0) AS inserted
+	`
+
+	var inserted bool
+	err = s.db.GetConn().QueryRowContext(ctx, query,
+		c.Name, c.Type, encrypted, s.keyID, c.Description, c.CreatedBy,
+	).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		return false, ErrNotOwned
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return inserted, nil
+}
+
+// Get loads and decrypts a credential by name
+func (s *Store) Get(ctx context.Context, name string) (*Credential, error) {
+	query := `
+		SELECT name, type, encrypted_data, COALESCE(description, ''), COALESCE(created_by, '')
+		FROM credentials
+		WHERE name = $1
+	`
+
+	var c Credential
+	var encrypted []byte
+	err := s.db.GetConn().QueryRowContext(ctx, query, name).Scan(
+		&c.Name, &c.Type, &encrypted, &c.Description, &c.CreatedBy,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential %s: %w", name, err)
+	}
+	c.Data = data
+
+	s.db.GetConn().ExecContext(ctx, `UPDATE credentials SET last_used_at = CURRENT_TIMESTAMP WHERE name = $1`, name)
+
+	return &c, nil
+}
+
+// DeleteOwnedExcept removes credentials created by owner whose names are not in keep
+func (s *Store) DeleteOwnedExcept(ctx context.Context, owner string, keep []string) ([]string, error) {
+	query := `DELETE FROM credentials WHERE created_by = $1 AND NOT (name = ANY($2)) RETURNING name`
+
+	rows, err := s.db.GetConn().QueryContext(ctx, query, owner, pq.Array(keep))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			continue
+		}
+		deleted = append(deleted, name)
+	}
+
+	return deleted, rows.Err()
+}
+
+func (s *Store) encrypt(data map[string]string) ([]byte, error) {
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *Store) decrypt(ciphertext []byte) (map[string]string, error) {
+	if len(ciphertext) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:s.aead.NonceSize()], ciphertext[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
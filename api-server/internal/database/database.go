@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 )
 
@@ -16,10 +16,11 @@ type Database struct {
 
 // NewDatabase creates a new database connection
 func NewDatabase(url string) (*Database, error) {
-	conn, err := sql.Open("postgres", url)
+	connector, err := pq.NewConnector(url)
 	if err != nil {
 		return nil, err
 	}
+	conn := sql.OpenDB(tracedConnector{connector})
 
 	// Set connection pool settings
 	conn.SetMaxOpenConns(25)
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/rs/zerolog/log"
+)
+
+// migrationFiles are the versioned schema migrations, as
+// NNNNNN_name.up.sql and NNNNNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// baselineVersion is the migration database/schema.sql is equivalent to.
+// Databases initialized from it before migrations were tracked are recorded
+// at this version rather than migrated from scratch.
+const baselineVersion = 1
+
+// Migration is an embedded migration and whether it has been applied
+type Migration struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+// Migrator applies the embedded migrations to a database. Migrations run
+// under a PostgreSQL advisory lock, so servers starting together apply each
+// migration once.
+type Migrator struct {
+	conn    *sql.DB
+	source  source.Driver
+	migrate *migrate.Migrate
+}
+
+// NewMigrator creates a migrator on its own connection to the database
+func NewMigrator(url string) (*Migrator, error) {
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := postgres.WithInstance(conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	src, err := iofs.New(migrationFiles, "migrations")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Migrator{conn: conn, source: src, migrate: m}, nil
+}
+
+// Close closes the migrator's connection
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.migrate.Close()
+	if srcErr != nil {
+		return srcErr
+	}
+	return dbErr
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up() error {
+	if err := m.baseline(); err != nil {
+		return err
+	}
+	from, _, _ := m.migrate.Version()
+	if err := m.migrate.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	to, _, _ := m.migrate.Version()
+	if to != from {
+		log.Info().Uint("from", from).Uint("to", to).Msg("Database migrated")
+	}
+	return nil
+}
+
+// Down reverts the last steps applied migrations
+func (m *Migrator) Down(steps int) error {
+	if err := m.migrate.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// Force records the database as being at version without running any
+// migration, to recover from a migration that failed halfway and left the
+// database dirty once it has been repaired by hand
+func (m *Migrator) Force(version int) error {
+	return m.migrate.Force(version)
+}
+
+// Status returns the current version, whether the last migration failed
+// halfway, and the embedded migrations
+func (m *Migrator) Status() (uint, bool, []Migration, error) {
+	version, dirty, err := m.migrate.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil, err
+	}
+
+	migrations := []Migration{}
+	v, err := m.source.First()
+	for err == nil {
+		name := ""
+		r, identifier, readErr := m.source.ReadUp(v)
+		if readErr == nil {
+			r.Close()
+			name = identifier
+		}
+		migrations = append(migrations, Migration{Version: v, Name: name, Applied: v <= version})
+		v, err = m.source.Next(v)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil, err
+	}
+	return version, dirty, migrations, nil
+}
+
+// baseline records databases created from database/schema.sql, which have the
+// schema but no migration version, at the baseline version
+func (m *Migrator) baseline() error {
+	if _, _, err := m.migrate.Version(); !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	var exists bool
+	if err := m.conn.QueryRow(`SELECT to_regclass('jobs') IS NOT NULL`).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	log.Info().Int("version", baselineVersion).Msg("Recording existing database schema as migrated")
+	return m.migrate.Force(baselineVersion)
+}
+
+// Migrate applies all pending migrations to the database at url
+func Migrate(url string) error {
+	m, err := NewMigrator(url)
+	if err != nil {
+		return fmt.Errorf("failed to open migrations: %w", err)
+	}
+	defer m.Close()
+	return m.Up()
+}
@@ -0,0 +1,32 @@
+-- Drops the initial schema
+
+DROP VIEW IF EXISTS recent_builds;
+DROP VIEW IF EXISTS worker_utilization;
+DROP VIEW IF EXISTS build_statistics;
+
+DROP TABLE IF EXISTS metrics;
+DROP TABLE IF EXISTS audit_logs;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS pipeline_stages;
+DROP TABLE IF EXISTS plugin_versions;
+DROP TABLE IF EXISTS plugins;
+DROP TABLE IF EXISTS credentials;
+DROP TABLE IF EXISTS finding_suppressions;
+DROP TABLE IF EXISTS finding_baselines;
+DROP TABLE IF EXISTS build_coverage;
+DROP TABLE IF EXISTS build_test_results;
+DROP TABLE IF EXISTS build_findings;
+DROP TABLE IF EXISTS build_logs;
+DROP TABLE IF EXISTS event_outbox;
+DROP TABLE IF EXISTS build_events;
+DROP TABLE IF EXISTS deployments;
+DROP TABLE IF EXISTS artifacts;
+DROP TABLE IF EXISTS workers;
+DROP TABLE IF EXISTS builds;
+DROP TABLE IF EXISTS jobs;
+
+DROP FUNCTION IF EXISTS calculate_deployment_duration();
+DROP FUNCTION IF EXISTS calculate_build_duration();
+DROP FUNCTION IF EXISTS update_updated_at_column();
@@ -0,0 +1,26 @@
+-- Moves build logs back into an unpartitioned table
+
+ALTER TABLE build_logs RENAME TO build_logs_partitioned;
+ALTER INDEX build_logs_pkey RENAME TO build_logs_partitioned_pkey;
+
+CREATE TABLE build_logs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+    sequence_number INTEGER NOT NULL,
+    timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    log_line TEXT NOT NULL,
+    stream VARCHAR(10) DEFAULT 'stdout', -- stdout, stderr
+    
+    UNIQUE(build_id, sequence_number)
+);
+
+CREATE INDEX idx_build_logs_build_id ON build_logs(build_id, sequence_number);
+
+INSERT INTO build_logs (id, build_id, sequence_number, timestamp, log_line, stream)
+SELECT id, build_id, sequence_number, timestamp, log_line, stream
+FROM build_logs_partitioned;
+
+DROP TABLE build_logs_partitioned;
+DROP FUNCTION IF EXISTS create_build_logs_partition(DATE);
+
+ALTER TABLE builds ALTER COLUMN queued_at DROP NOT NULL;
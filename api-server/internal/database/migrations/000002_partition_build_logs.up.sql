@@ -0,0 +1,55 @@
+-- Partition build_logs by month of the queued_at of their build, so that the
+-- logs of old builds are dropped a month at a time
+
+-- The partition key of a build's logs must not change
+UPDATE builds SET queued_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE queued_at IS NULL;
+ALTER TABLE builds ALTER COLUMN queued_at SET NOT NULL;
+
+ALTER TABLE build_logs RENAME TO build_logs_unpartitioned;
+ALTER INDEX build_logs_pkey RENAME TO build_logs_unpartitioned_pkey;
+ALTER INDEX build_logs_build_id_sequence_number_key RENAME TO build_logs_unpartitioned_build_id_sequence_number_key;
+ALTER INDEX idx_build_logs_build_id RENAME TO idx_build_logs_unpartitioned_build_id;
+
+CREATE TABLE build_logs (
+    id UUID NOT NULL DEFAULT uuid_generate_v4(),
+    build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+    build_queued_at TIMESTAMP WITH TIME ZONE NOT NULL, -- queued_at of the build, the partition key
+    sequence_number INTEGER NOT NULL,
+    timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    log_line TEXT NOT NULL,
+    stream VARCHAR(10) DEFAULT 'stdout', -- stdout, stderr
+
+    PRIMARY KEY (build_id, sequence_number, build_queued_at)
+) PARTITION BY RANGE (build_queued_at);
+
+-- Catches the logs of builds queued in a month without a partition, which the
+-- server creates ahead of time
+CREATE TABLE build_logs_default PARTITION OF build_logs DEFAULT;
+
+-- Create the partition of the month (UTC) containing a day, named build_logs_YYYY_MM
+CREATE OR REPLACE FUNCTION create_build_logs_partition(day_in_month DATE)
+RETURNS VOID AS $$
+DECLARE
+    month_start TIMESTAMP := date_trunc('month', day_in_month::timestamp);
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS %I PARTITION OF build_logs FOR VALUES FROM (%L) TO (%L)',
+        'build_logs_' || to_char(month_start, 'YYYY_MM'),
+        month_start AT TIME ZONE 'UTC',
+        (month_start + INTERVAL '1 month') AT TIME ZONE 'UTC');
+END;
+$$ language 'plpgsql';
+
+SELECT create_build_logs_partition(month)
+FROM (
+    SELECT DISTINCT (queued_at AT TIME ZONE 'UTC')::date AS month FROM builds
+    UNION SELECT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC')::date
+    UNION SELECT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC' + INTERVAL '1 month')::date
+) months;
+
+INSERT INTO build_logs (id, build_id, build_queued_at, sequence_number, timestamp, log_line, stream)
+SELECT l.id, l.build_id, b.queued_at, l.sequence_number, l.timestamp, l.log_line, l.stream
+FROM build_logs_unpartitioned l
+JOIN builds b ON b.id = l.build_id;
+
+DROP TABLE build_logs_unpartitioned;
@@ -0,0 +1,2 @@
+ALTER TABLE builds DROP COLUMN IF EXISTS pipeline_config;
+ALTER TABLE jobs DROP COLUMN IF EXISTS pipeline_file;
@@ -0,0 +1,4 @@
+-- Pipelines defined in the job's repository rather than in the job
+
+ALTER TABLE jobs ADD COLUMN pipeline_file VARCHAR(255); -- e.g. .solvyd.yml; NULL uses the job's own config
+ALTER TABLE builds ADD COLUMN pipeline_config JSONB; -- the pipeline read from the repository at the build's commit
@@ -0,0 +1,10 @@
+-- Environment variables shared by every job, or by the jobs of a project,
+-- beneath each job's own environment_vars
+
+CREATE TABLE environment_layers (
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('global', 'project')),
+    name VARCHAR(255) NOT NULL DEFAULT '', -- the project; empty for the global layer
+    variables JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, name)
+);
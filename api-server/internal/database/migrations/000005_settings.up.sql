@@ -0,0 +1,9 @@
+-- Instance-wide settings administrators change at runtime, overriding the
+-- server's defaults
+
+CREATE TABLE settings (
+    name VARCHAR(100) PRIMARY KEY,
+    value JSONB NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_by VARCHAR(255)
+);
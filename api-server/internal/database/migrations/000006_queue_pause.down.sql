@@ -0,0 +1,2 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS queue_paused_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS queue_paused_by;
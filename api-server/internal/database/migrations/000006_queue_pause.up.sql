@@ -0,0 +1,5 @@
+-- Job queues paused by an administrator: their builds keep queueing but are
+-- not dispatched
+
+ALTER TABLE jobs ADD COLUMN queue_paused_at TIMESTAMP WITH TIME ZONE; -- NULL while the queue runs
+ALTER TABLE jobs ADD COLUMN queue_paused_by VARCHAR(255);
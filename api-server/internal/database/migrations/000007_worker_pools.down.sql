@@ -0,0 +1,3 @@
+ALTER TABLE jobs DROP COLUMN IF EXISTS pool;
+ALTER TABLE workers DROP COLUMN IF EXISTS pool;
+DROP TABLE IF EXISTS worker_pools;
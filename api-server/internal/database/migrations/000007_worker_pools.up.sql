@@ -0,0 +1,14 @@
+-- Named groups of workers, e.g. linux-large, macos or gpu, that jobs target
+
+CREATE TABLE worker_pools (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    autoscaling JSONB, -- the pool's autoscaling policy; NULL without one
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE workers ADD COLUMN pool VARCHAR(100); -- NULL in the default pool
+ALTER TABLE jobs ADD COLUMN pool VARCHAR(100); -- NULL runs on the default pool
+
+CREATE INDEX idx_workers_pool ON workers(pool);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS worker_resource_samples;
+ALTER TABLE workers DROP COLUMN IF EXISTS resources_reported_at;
+ALTER TABLE workers DROP COLUMN IF EXISTS resources;
@@ -0,0 +1,14 @@
+-- Resource usage workers report with their heartbeats
+
+ALTER TABLE workers ADD COLUMN resources JSONB; -- the latest snapshot; NULL before the first
+ALTER TABLE workers ADD COLUMN resources_reported_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE worker_resource_samples (
+    id BIGSERIAL PRIMARY KEY,
+    worker_id UUID NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resources JSONB NOT NULL
+);
+
+CREATE INDEX idx_worker_resource_samples_worker ON worker_resource_samples(worker_id, recorded_at);
+CREATE INDEX idx_worker_resource_samples_recorded ON worker_resource_samples(recorded_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_artifacts_verified_at;
+ALTER TABLE artifacts DROP COLUMN IF EXISTS verified_at;
+ALTER TABLE artifacts DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE artifacts DROP COLUMN IF EXISTS status;
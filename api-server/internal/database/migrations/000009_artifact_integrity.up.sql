@@ -0,0 +1,7 @@
+-- Integrity of the artifacts held in artifact storage
+
+ALTER TABLE artifacts ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'available'; -- available, corrupted, missing, quarantined
+ALTER TABLE artifacts ADD COLUMN status_reason TEXT;
+ALTER TABLE artifacts ADD COLUMN verified_at TIMESTAMP WITH TIME ZONE; -- when its checksum last matched or failed to
+
+CREATE INDEX idx_artifacts_verified_at ON artifacts(verified_at NULLS FIRST) WHERE storage_plugin = 'solvyd';
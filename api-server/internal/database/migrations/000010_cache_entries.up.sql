@@ -0,0 +1,15 @@
+-- Cache entries stored through the cache API, in artifact storage
+
+CREATE TABLE cache_entries (
+    scope VARCHAR(255) NOT NULL,
+    version VARCHAR(255) NOT NULL DEFAULT '',
+    key TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    checksum_sha256 VARCHAR(64) NOT NULL,
+    storage_key TEXT NOT NULL, -- derived from scope, version and key
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_accessed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, version, key)
+);
+
+CREATE INDEX idx_cache_entries_last_accessed ON cache_entries(last_accessed_at);
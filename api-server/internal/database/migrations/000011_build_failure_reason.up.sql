@@ -0,0 +1,3 @@
+-- Why a build failed, when the agent failed it for a reason of its own
+
+ALTER TABLE builds ADD COLUMN failure_reason VARCHAR(50); -- disk_quota_exceeded
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_builds_rerun_of;
+ALTER TABLE builds DROP COLUMN IF EXISTS rerun_of;
@@ -0,0 +1,5 @@
+-- Reruns of builds, with the commit, parameters and environment of the original
+
+ALTER TABLE builds ADD COLUMN rerun_of UUID REFERENCES builds(id) ON DELETE SET NULL; -- the build this one re-runs
+
+CREATE INDEX idx_builds_rerun_of ON builds(rerun_of);
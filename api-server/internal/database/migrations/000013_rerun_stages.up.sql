@@ -0,0 +1,3 @@
+-- Reruns that restart a build from its failed stage
+
+ALTER TABLE builds ADD COLUMN rerun_stages JSONB; -- the stages a rerun runs; NULL runs them all
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_builds_pinned;
+ALTER TABLE builds DROP COLUMN IF EXISTS pin_reason;
+ALTER TABLE builds DROP COLUMN IF EXISTS pinned_at;
+ALTER TABLE builds DROP COLUMN IF EXISTS pinned_by;
+ALTER TABLE builds DROP COLUMN IF EXISTS pinned;
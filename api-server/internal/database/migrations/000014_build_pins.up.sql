@@ -0,0 +1,8 @@
+-- Pinned builds, kept forever with their artifacts and logs
+
+ALTER TABLE builds ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT false; -- exempt from retention
+ALTER TABLE builds ADD COLUMN pinned_by VARCHAR(255);
+ALTER TABLE builds ADD COLUMN pinned_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE builds ADD COLUMN pin_reason TEXT; -- e.g. the release the build is kept for
+
+CREATE INDEX idx_builds_pinned ON builds(id) WHERE pinned;
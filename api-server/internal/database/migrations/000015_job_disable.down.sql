@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_jobs_reenable_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS reenable_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS disabled_at;
+ALTER TABLE jobs DROP COLUMN IF EXISTS disabled_by;
+ALTER TABLE jobs DROP COLUMN IF EXISTS disabled_reason;
@@ -0,0 +1,8 @@
+-- Why a job is disabled, by whom, and when it is enabled again
+
+ALTER TABLE jobs ADD COLUMN disabled_reason TEXT;
+ALTER TABLE jobs ADD COLUMN disabled_by VARCHAR(255);
+ALTER TABLE jobs ADD COLUMN disabled_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE jobs ADD COLUMN reenable_at TIMESTAMP WITH TIME ZONE; -- NULL stays disabled until enabled
+
+CREATE INDEX idx_jobs_reenable_at ON jobs(reenable_at) WHERE reenable_at IS NOT NULL;
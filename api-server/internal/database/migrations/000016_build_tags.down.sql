@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_builds_tags;
+ALTER TABLE builds DROP COLUMN IF EXISTS tags;
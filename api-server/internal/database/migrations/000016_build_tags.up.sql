@@ -0,0 +1,5 @@
+-- Tags on builds, e.g. release-candidate, to mark and find them by
+
+ALTER TABLE builds ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_builds_tags ON builds USING GIN (tags);
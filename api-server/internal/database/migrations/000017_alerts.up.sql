@@ -0,0 +1,20 @@
+-- Operational alerts: workers offline, builds waiting too long and pools
+-- without capacity, with the notifications workers deliver for them
+
+CREATE TABLE alerts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(50) NOT NULL, -- worker_offline, queue_wait or no_capacity
+    subject VARCHAR(255) NOT NULL DEFAULT '', -- the worker or pool alerted about; '' is the default pool
+    message TEXT NOT NULL,
+    details JSONB,
+    since TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP, -- when the condition was first seen
+    fired_at TIMESTAMP WITH TIME ZONE, -- NULL until the condition lasted long enough
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    pending_event VARCHAR(20), -- fired or resolved, until a worker delivered its notifications
+    claimed_by UUID REFERENCES workers(id) ON DELETE SET NULL,
+    claimed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX idx_alerts_open ON alerts(kind, subject) WHERE resolved_at IS NULL;
+CREATE INDEX idx_alerts_pending ON alerts(fired_at) WHERE pending_event IS NOT NULL;
+CREATE INDEX idx_alerts_resolved_at ON alerts(resolved_at);
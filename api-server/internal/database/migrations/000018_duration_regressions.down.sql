@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_builds_duration_regression;
+ALTER TABLE builds DROP COLUMN IF EXISTS duration_regression;
+ALTER TABLE builds DROP COLUMN IF EXISTS duration_baseline_seconds;
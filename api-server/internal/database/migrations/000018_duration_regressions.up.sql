@@ -0,0 +1,6 @@
+-- Builds that took markedly longer than their job's recent successful builds
+
+ALTER TABLE builds ADD COLUMN duration_baseline_seconds INTEGER; -- median of the job's recent successful builds when it completed
+ALTER TABLE builds ADD COLUMN duration_regression BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX idx_builds_duration_regression ON builds(job_id) WHERE duration_regression;
@@ -0,0 +1,21 @@
+-- License exceptions: package versions approved whatever their license,
+-- requested by anyone and decided by the compliance role
+
+CREATE TABLE license_exceptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    ecosystem VARCHAR(50) NOT NULL DEFAULT '', -- npm, golang or maven; '' matches any
+    package VARCHAR(500) NOT NULL,
+    version VARCHAR(255) NOT NULL,
+    license VARCHAR(255) NOT NULL DEFAULT '', -- the license excepted; '' matches any
+    justification TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, approved or rejected
+    requested_by VARCHAR(255),
+    requested_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    decided_by VARCHAR(255),
+    decided_at TIMESTAMP WITH TIME ZONE,
+    decision_note TEXT,
+    expires_at TIMESTAMP WITH TIME ZONE -- set on approval
+);
+
+CREATE INDEX idx_license_exceptions_package ON license_exceptions(package, version);
+CREATE INDEX idx_license_exceptions_approved ON license_exceptions(expires_at) WHERE status = 'approved';
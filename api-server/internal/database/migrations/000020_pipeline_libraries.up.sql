@@ -0,0 +1,16 @@
+-- Pipeline libraries: Git repositories of stage and step templates that
+-- pipelines include by library name, template file and ref
+
+CREATE TABLE pipeline_libraries (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    repository VARCHAR(500), -- clone URL; NULL for a library kept in a GitOps source
+    gitops_source VARCHAR(100), -- the GitOps source whose repository holds the library
+    credentials VARCHAR(255), -- stored credential the repository is cloned with
+    path VARCHAR(500) NOT NULL DEFAULT '', -- directory of the templates in the repository
+    default_ref VARCHAR(255) NOT NULL DEFAULT 'main', -- branch, tag or commit of includes without a ref
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((repository IS NULL) <> (gitops_source IS NULL))
+);
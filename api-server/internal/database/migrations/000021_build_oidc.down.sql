@@ -0,0 +1 @@
+ALTER TABLE builds DROP COLUMN IF EXISTS oidc_request_token_hash;
@@ -0,0 +1,3 @@
+-- Builds request OIDC identity tokens with a token handed out with them
+
+ALTER TABLE builds ADD COLUMN oidc_request_token_hash VARCHAR(64); -- sha256 of the request token; NULL without OIDC
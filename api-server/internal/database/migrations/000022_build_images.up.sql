@@ -0,0 +1,15 @@
+-- Build images: the catalog of vetted images that jobs and pipelines refer to
+-- by name with build_image, in place of free-form image strings
+
+CREATE TABLE build_images (
+    name VARCHAR(100) PRIMARY KEY, -- such as golang-1.22-standard
+    description TEXT NOT NULL DEFAULT '',
+    image VARCHAR(500) NOT NULL, -- repository, such as registry.example.com/ci/golang
+    tag VARCHAR(128) NOT NULL DEFAULT 'latest',
+    digest VARCHAR(71), -- sha256 digest the tag is pinned to; NULL to follow the tag
+    tools TEXT[] NOT NULL DEFAULT '{}', -- tools the image provides, such as go 1.22 and make
+    maintainer VARCHAR(255) NOT NULL DEFAULT '', -- team or person who keeps the image up to date
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,18 @@
+-- Build annotations: Problems plugin steps report at a line of a file, such
+-- as lint errors and test failures, to render inline and post as pull
+-- request review comments
+
+CREATE TABLE build_annotations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+    step VARCHAR(255) NOT NULL DEFAULT '', -- plugin that reported the annotation
+    path TEXT NOT NULL DEFAULT '', -- file relative to the repository root; empty for the whole build
+    start_line INTEGER NOT NULL DEFAULT 0, -- 0 for the whole file
+    end_line INTEGER NOT NULL DEFAULT 0,
+    severity VARCHAR(20) NOT NULL, -- error, warning or notice
+    title TEXT NOT NULL DEFAULT '',
+    message TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_build_annotations_build_id ON build_annotations(build_id, path, start_line);
@@ -0,0 +1,2 @@
+ALTER TABLE builds DROP COLUMN IF EXISTS schedule_skipped_at;
+ALTER TABLE builds DROP COLUMN IF EXISTS schedule_skip_reason;
@@ -0,0 +1,5 @@
+-- Why the scheduler last left a queued build in the queue, for the admin
+-- API's stuck build snapshot
+
+ALTER TABLE builds ADD COLUMN schedule_skip_reason VARCHAR(50); -- no_workers, workers_busy, disk_full
+ALTER TABLE builds ADD COLUMN schedule_skipped_at TIMESTAMP WITH TIME ZONE;
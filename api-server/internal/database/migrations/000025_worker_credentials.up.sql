@@ -0,0 +1,4 @@
+-- Workers: The hash of the credential issued at registration, which a
+-- worker presents to fetch the builds assigned to it
+
+ALTER TABLE workers ADD COLUMN credential_hash VARCHAR(64);
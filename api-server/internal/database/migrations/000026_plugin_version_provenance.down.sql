@@ -0,0 +1,2 @@
+ALTER TABLE plugin_versions DROP COLUMN IF EXISTS signed;
+ALTER TABLE plugin_versions DROP COLUMN IF EXISTS source;
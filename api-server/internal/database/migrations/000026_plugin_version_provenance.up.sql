@@ -0,0 +1,8 @@
+-- Plugin registry: Where a version was imported from and whether its binary
+-- was signed by a trusted key, which installs are checked against
+
+ALTER TABLE plugin_versions ADD COLUMN source TEXT;
+ALTER TABLE plugin_versions ADD COLUMN signed BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE plugin_versions SET source = substring(published_by FROM 9)
+WHERE published_by LIKE 'install:%';
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_webhooks_job_source;
@@ -0,0 +1,7 @@
+-- Webhooks: One secret per job and sender, which webhook requests are
+-- verified against before they trigger anything
+
+DELETE FROM webhooks a USING webhooks b
+WHERE a.job_id = b.job_id AND a.source = b.source AND a.created_at < b.created_at;
+
+CREATE UNIQUE INDEX idx_webhooks_job_source ON webhooks(job_id, source);
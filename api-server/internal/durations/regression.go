@@ -0,0 +1,102 @@
+package durations
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// minSamples is how many successful builds a job needs before its
+	// builds are compared against their durations
+	minSamples = 5
+	// madScale makes the median absolute deviation comparable to a standard
+	// deviation, for durations that are roughly normally distributed
+	madScale = 1.4826
+	// A build is not a regression unless it is at least this much slower
+	// than the median, so the jitter of short or very regular jobs is not
+	// flagged
+	minSlowdownSeconds = 30
+	minSlowdownRatio   = 0.1
+)
+
+// Querier is a database connection or transaction
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Baseline is the duration of a job's recent successful builds: their median
+// and median absolute deviation, which a few outliers do not skew
+type Baseline struct {
+	Median  float64 `json:"median_seconds"`
+	MAD     float64 `json:"mad_seconds"`
+	Samples int     `json:"samples"`
+}
+
+// Load returns the baseline of the last window successful builds of a job
+// numbered below buildNumber, or nil if it has fewer than minSamples of them
+func Load(ctx context.Context, q Querier, jobID uuid.UUID, buildNumber, window int) (*Baseline, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT duration_seconds FROM builds
+		WHERE job_id = $1 AND build_number < $2
+		  AND status = 'success' AND duration_seconds IS NOT NULL
+		ORDER BY build_number DESC
+		LIMIT $3
+	`, jobID, buildNumber, window)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []float64{}
+	for rows.Next() {
+		var d int
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		samples = append(samples, float64(d))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(samples) < minSamples {
+		return nil, nil
+	}
+
+	b := &Baseline{Median: median(samples), Samples: len(samples)}
+	deviations := make([]float64, len(samples))
+	for i, s := range samples {
+		deviations[i] = math.Abs(s - b.Median)
+	}
+	b.MAD = median(deviations)
+	return b, nil
+}
+
+// Threshold returns the duration above which a build regressed. sensitivity
+// is how many scaled median absolute deviations above the median that is;
+// lower values flag smaller slowdowns.
+func (b *Baseline) Threshold(sensitivity float64) float64 {
+	slowdown := sensitivity * madScale * b.MAD
+	slowdown = math.Max(slowdown, minSlowdownSeconds)
+	slowdown = math.Max(slowdown, minSlowdownRatio*b.Median)
+	return b.Median + slowdown
+}
+
+// Regressed reports whether a build that took seconds is statistically slower
+// than the baseline
+func (b *Baseline) Regressed(seconds int, sensitivity float64) bool {
+	return float64(seconds) > b.Threshold(sensitivity)
+}
+
+// median returns the median of values, which it sorts
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
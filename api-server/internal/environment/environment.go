@@ -0,0 +1,167 @@
+package environment
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// Sources of environment variables, from the lowest precedence to the highest
+const (
+	SourceGlobal     = "global"
+	SourceProject    = "project"
+	SourceJob        = "job"
+	SourceParameters = "parameters"
+)
+
+// SourceRecorded is the source of the variables a build recorded when it
+// was handed out, which its reruns use in place of the global, project and
+// job variables
+const SourceRecorded = "recorded"
+
+// namePattern is the pattern of environment variable names
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Layer is a set of environment variables from one source
+type Layer struct {
+	Source    string            `json:"source"`
+	Variables map[string]string `json:"variables"`
+}
+
+// Variable is a variable of the effective environment and the layer it comes
+// from. Overrides lists the lower layers that set it too.
+type Variable struct {
+	Value     string   `json:"value"`
+	Source    string   `json:"source"`
+	Overrides []string `json:"overrides,omitempty"`
+}
+
+// ValidateNames returns a problem for each variable whose name cannot be an
+// environment variable
+func ValidateNames(variables map[string]string) []string {
+	var problems []string
+	for name := range variables {
+		if !namePattern.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("variables.%s: must be letters, digits and underscores, not starting with a digit", name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Merge returns the effective environment of the layers, given from the
+// lowest precedence to the highest: each variable takes its value from the
+// last layer that sets it
+func Merge(layers []Layer) map[string]Variable {
+	effective := map[string]Variable{}
+	for _, layer := range layers {
+		for name, value := range layer.Variables {
+			v := Variable{Value: value, Source: layer.Source}
+			if prev, ok := effective[name]; ok {
+				v.Overrides = append(prev.Overrides, prev.Source)
+			}
+			effective[name] = v
+		}
+	}
+	return effective
+}
+
+// Get returns the variables of the global layer, or of a project's layer
+func Get(ctx context.Context, db *database.Database, scope, name string) (map[string]string, error) {
+	var variables models.JSONB
+	err := db.GetConn().QueryRowContext(ctx,
+		`SELECT variables FROM environment_layers WHERE scope = $1 AND name = $2`, scope, name).Scan(&variables)
+	if err == sql.ErrNoRows {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Strings(variables), nil
+}
+
+// Set replaces the variables of the global layer, or of a project's layer
+func Set(ctx context.Context, db *database.Database, scope, name string, variables map[string]string) error {
+	_, err := db.GetConn().ExecContext(ctx, `
+		INSERT INTO environment_layers (scope, name, variables)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (scope, name) DO UPDATE SET
+			variables = EXCLUDED.variables,
+			updated_at = CURRENT_TIMESTAMP
+	`, scope, name, models.JSONB(toJSON(variables)))
+	return err
+}
+
+// Layers returns the layers of a job's environment: the global variables,
+// its project's, its own and those of the parameters it is triggered with
+func Layers(ctx context.Context, db *database.Database, project string, jobVars, parameters models.JSONB) ([]Layer, error) {
+	global, err := Get(ctx, db, SourceGlobal, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load global environment: %w", err)
+	}
+	layers := []Layer{{Source: SourceGlobal, Variables: global}}
+
+	projectVars := map[string]string{}
+	if project != "" {
+		if projectVars, err = Get(ctx, db, SourceProject, project); err != nil {
+			return nil, fmt.Errorf("failed to load environment of project %s: %w", project, err)
+		}
+	}
+	layers = append(layers, Layer{Source: SourceProject, Variables: projectVars})
+
+	// Parameters that are not valid variable names, or not scalars, stay
+	// parameters only
+	params := map[string]string{}
+	for name, value := range Strings(parameters) {
+		if namePattern.MatchString(name) {
+			params[name] = value
+		}
+	}
+	return append(layers,
+		Layer{Source: SourceJob, Variables: Strings(jobVars)},
+		Layer{Source: SourceParameters, Variables: params},
+	), nil
+}
+
+// RecordedLayers returns the layers of a build re-run with the variables
+// recorded by the original: those and the parameters it is triggered with
+func RecordedLayers(recorded, parameters models.JSONB) []Layer {
+	params := map[string]string{}
+	for name, value := range Strings(parameters) {
+		if namePattern.MatchString(name) {
+			params[name] = value
+		}
+	}
+	return []Layer{
+		{Source: SourceRecorded, Variables: Strings(recorded)},
+		{Source: SourceParameters, Variables: params},
+	}
+}
+
+// Strings returns the string, number and boolean values of an object as
+// strings
+func Strings(values models.JSONB) map[string]string {
+	out := make(map[string]string, len(values))
+	for name, value := range values {
+		switch v := value.(type) {
+		case string:
+			out[name] = v
+		case float64, bool:
+			out[name] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
+
+func toJSON(variables map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(variables))
+	for name, value := range variables {
+		out[name] = value
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package findings
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// Severities, from least to most severe
+var Severities = []string{"info", "low", "medium", "high", "critical"}
+
+// NormalizeSeverity maps a scanner's severity, either a name such as "HIGH",
+// "Moderate" or "Informational" or a CVSS score such as "7.5", to one of
+// Severities. Unknown severities are "info". It matches the plugin SDK's
+// NormalizeSeverity.
+func NormalizeSeverity(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if score, err := strconv.ParseFloat(s, 64); err == nil {
+		switch {
+		case score >= 9:
+			return "critical"
+		case score >= 7:
+			return "high"
+		case score >= 4:
+			return "medium"
+		case score > 0:
+			return "low"
+		}
+		return "info"
+	}
+	switch s {
+	case "critical", "blocker":
+		return "critical"
+	case "high", "error", "major":
+		return "high"
+	case "medium", "moderate", "warning":
+		return "medium"
+	case "low", "minor", "note":
+		return "low"
+	}
+	return "info"
+}
+
+// ParseSeverity returns the normalized name of a severity in a request, or an
+// error if it is not one of Severities
+func ParseSeverity(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if Rank(s) < 0 {
+		return "", fmt.Errorf("unknown severity %q (want one of %s)", s, strings.Join(Severities, ", "))
+	}
+	return s, nil
+}
+
+// Rank orders severities from 0 for "info" to 4 for "critical"; it is -1 for
+// anything else
+func Rank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// AtLeast returns the severities at least as severe as min
+func AtLeast(min string) []string {
+	if i := Rank(min); i >= 0 {
+		return Severities[i:]
+	}
+	return nil
+}
+
+// Normalize fills in a finding's defaults before it is stored: its severity
+// is normalized, its tool defaults to tool and its fingerprint is derived
+// from its tool, rule and location. Line numbers are left out of the
+// fingerprint so it survives unrelated edits to the file.
+func Normalize(f *models.Finding, tool string) {
+	if f.Tool == "" {
+		f.Tool = tool
+	}
+	f.Severity = NormalizeSeverity(f.Severity)
+	if f.CWEs == nil {
+		f.CWEs = []string{}
+	}
+	if f.CVEs == nil {
+		f.CVEs = []string{}
+	}
+	if f.Fingerprint == "" {
+		sum := sha256.Sum256([]byte(strings.Join([]string{
+			f.Tool, f.RuleID, f.Location.Path, f.Location.URI, f.Location.Package,
+		}, "\x00")))
+		f.Fingerprint = hex.EncodeToString(sum[:16])
+	}
+}
@@ -0,0 +1,129 @@
+package findings
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// testSARIF covers rules looked up by ID and by index, severities from
+// security-severity scores and levels, CWE tags and CVE IDs
+const testSARIF = `{
+  "version": "2.1.0",
+  "runs": [{
+    "tool": {"driver": {"name": "semgrep", "rules": [
+      {
+        "id": "sql-injection",
+        "name": "SqlInjection",
+        "shortDescription": {"text": "SQL injection"},
+        "fullDescription": {"text": "User input reaches a SQL query"},
+        "helpUri": "https://example.com/sql-injection",
+        "properties": {"tags": ["security", "external/cwe/cwe-089", "CWE-89", 7], "security-severity": "8.8"}
+      },
+      {
+        "id": "weak-hash",
+        "name": "WeakHash",
+        "defaultConfiguration": {"level": "note"},
+        "properties": {"tags": ["cwe:327"]}
+      },
+      {"id": "CVE-2023-1234"}
+    ]}},
+    "results": [
+      {
+        "ruleId": "sql-injection",
+        "message": {"text": "query built from request parameter"},
+        "locations": [
+          {"physicalLocation": {"artifactLocation": {"uri": "app/db.go"}, "region": {"startLine": 12, "endLine": 14}}},
+          {"physicalLocation": {"artifactLocation": {"uri": "app/other.go"}}}
+        ]
+      },
+      {"ruleIndex": 1, "message": {"text": "md5 used"}},
+      {"ruleId": "weak-hash", "level": "error", "message": {"text": "md5 used"}, "properties": {"security-severity": 9.5}},
+      {"ruleIndex": 2, "level": "none", "message": {"text": "vulnerable dependency cve-2024-56789"}},
+      {"ruleId": "unknown-rule", "message": {"text": "no rule metadata"}}
+    ]
+  }]
+}`
+
+func TestParseSARIF(t *testing.T) {
+	got, err := ParseSARIF(strings.NewReader(testSARIF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Finding{
+		{
+			Tool:        "semgrep",
+			RuleID:      "sql-injection",
+			Title:       "SQL injection",
+			Description: "query built from request parameter",
+			Severity:    "high",
+			Location:    models.FindingLocation{Path: "app/db.go", StartLine: 12, EndLine: 14},
+			CWEs:        []string{"CWE-89"},
+			HelpURL:     "https://example.com/sql-injection",
+		},
+		{
+			Tool:        "semgrep",
+			RuleID:      "weak-hash",
+			Title:       "WeakHash",
+			Description: "md5 used",
+			Severity:    "low",
+			CWEs:        []string{"CWE-327"},
+		},
+		{
+			Tool:        "semgrep",
+			RuleID:      "weak-hash",
+			Title:       "WeakHash",
+			Description: "md5 used",
+			Severity:    "critical",
+			CWEs:        []string{"CWE-327"},
+		},
+		{
+			Tool:        "semgrep",
+			RuleID:      "CVE-2023-1234",
+			Title:       "vulnerable dependency cve-2024-56789",
+			Description: "vulnerable dependency cve-2024-56789",
+			Severity:    "info",
+			CVEs:        []string{"CVE-2023-1234", "CVE-2024-56789"},
+		},
+		{
+			Tool:        "semgrep",
+			RuleID:      "unknown-rule",
+			Title:       "no rule metadata",
+			Description: "no rule metadata",
+			Severity:    "medium",
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d findings, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("finding %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseSARIFEmpty(t *testing.T) {
+	got, err := ParseSARIF(strings.NewReader(`{"runs": [{"tool": {"driver": {"name": "x"}}}]}`))
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("ParseSARIF = %v, %v, want no findings", got, err)
+	}
+}
+
+func TestParseSARIFErrors(t *testing.T) {
+	tests := []struct {
+		log  string
+		want string
+	}{
+		{`not json`, "invalid SARIF log"},
+		{`{"version": "1.0.0", "runs": []}`, "unsupported SARIF version 1.0.0"},
+	}
+	for _, tt := range tests {
+		_, err := ParseSARIF(strings.NewReader(tt.log))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseSARIF(%s) error = %v, want %q", tt.log, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+)
+
+// githubAppTokenSource mints and caches GitHub App installation tokens
+type githubAppTokenSource struct {
+	cfg        config.GitOpsGitHubApp
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGitHubAppTokenSource(cfg config.GitOpsGitHubApp) *githubAppTokenSource {
+	return &githubAppTokenSource{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Token returns a cached installation token, minting a new one shortly before expiry
+func (g *githubAppTokenSource) Token(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.token != "" && time.Until(g.expires) > 5*time.Minute {
+		return g.token, nil
+	}
+
+	if g.cfg.AppID == 0 || g.cfg.InstallationID == 0 {
+		return "", fmt.Errorf("github_app authentication requires app_id and installation_id")
+	}
+
+	key, err := g.privateKey()
+	if err != nil {
+		return "", err
+	}
+
+	appJWT, err := signAppJWT(g.cfg.AppID, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens",
+		strings.TrimSuffix(g.cfg.APIURL, "/"), g.cfg.InstallationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+appJWT)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("GitHub returned %s when minting installation token", resp.Status)
+	}
+
+	var body struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid installation token response: %w", err)
+	}
+
+	g.token = body.Token
+	g.expires = body.ExpiresAt
+	return g.token, nil
+}
+
+func (g *githubAppTokenSource) privateKey() (*rsa.PrivateKey, error) {
+	data := []byte(g.cfg.PrivateKey)
+	if g.cfg.PrivateKeyFile != "" {
+		var err error
+		if data, err = os.ReadFile(g.cfg.PrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read GitHub App private key: %w", err)
+		}
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key is not PEM-encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key must be RSA")
+	}
+	return key, nil
+}
+
+// signAppJWT creates the short-lived RS256 JWT GitHub expects from an app
+func signAppJWT(appID int64, key *rsa.PrivateKey) (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(), // allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprintf("%d", appID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// authMethod returns the go-git transport auth for the configured authentication type
+func (s *SyncService) authMethod() (transport.AuthMethod, error) {
+	auth := s.cfg.Authentication
+
+	switch auth.Type {
+	case "token":
+		if auth.Token == "" {
+			return nil, nil
+		}
+		return &githttp.BasicAuth{Username: "solvyd", Password: auth.Token}, nil
+	case "github_app":
+		token, err := s.githubApp.Token(s.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get GitHub App installation token: %w", err)
+		}
+		return &githttp.BasicAuth{Username: "x-access-token", Password: token}, nil
+	case "ssh":
+		return s.sshAuth()
+	}
+
+	return nil, nil
+}
+
+// sshAuth builds public key auth from the configured deploy key
+func (s *SyncService) sshAuth() (transport.AuthMethod, error) {
+	auth := s.cfg.Authentication
+
+	key := []byte(auth.SSHKey)
+	if auth.SSHKeyFile != "" {
+		var err error
+		if key, err = os.ReadFile(auth.SSHKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read ssh key: %w", err)
+		}
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("ssh authentication requires ssh_key or ssh_key_file")
+	}
+
+	publicKeys, err := gitssh.NewPublicKeys(sshUser(s.cfg.Repository.URL), key, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh key: %w", err)
+	}
+
+	if auth.KnownHostsFile != "" {
+		callback, err := gitssh.NewKnownHostsCallback(auth.KnownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known hosts: %w", err)
+		}
+		publicKeys.HostKeyCallback = callback
+	} else {
+		publicKeys.HostKeyCallback = s.hostKeys.callback
+	}
+
+	return publicKeys, nil
+}
+
+// sshUser extracts the login user from an SSH repository URL, defaulting to git
+func sshUser(url string) string {
+	rest := strings.TrimPrefix(url, "ssh://")
+	if at := strings.Index(rest, "@"); at > 0 && !strings.ContainsAny(rest[:at], "/:") {
+		return rest[:at]
+	}
+	return "git"
+}
+
+// trustOnFirstUse accepts the first host key seen for each host and rejects changes
+type trustOnFirstUse struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+func (t *trustOnFirstUse) callback(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.keys == nil {
+		t.keys = make(map[string][]byte)
+	}
+	known, ok := t.keys[hostname]
+	if !ok {
+		log.Warn().
+			Str("host", hostname).
+			Str("fingerprint", ssh.FingerprintSHA256(key)).
+			Msg("Trusting GitOps host key on first use; set known_hosts_file to pin it")
+		t.keys[hostname] = key.Marshal()
+		return nil
+	}
+	if !bytes.Equal(known, key.Marshal()) {
+		return fmt.Errorf("host key for %s changed (now %s)", hostname, ssh.FingerprintSHA256(key))
+	}
+	return nil
+}
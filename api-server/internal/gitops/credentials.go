@@ -0,0 +1,227 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
+)
+
+// CredentialManifest is the versioned YAML representation of a credential
+type CredentialManifest struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   ManifestMetadata `yaml:"metadata"`
+	Spec       CredentialSpec   `yaml:"spec"`
+}
+
+// CredentialSpec describes a credential. Secret material is only accepted from
+// SOPS-encrypted fields or an age-armored encrypted_value, never as plaintext.
+type CredentialSpec struct {
+	Type           string            `yaml:"type"`
+	Description    string            `yaml:"description"`
+	Value          string            `yaml:"value"`
+	Data           map[string]string `yaml:"data"`
+	EncryptedValue string            `yaml:"encrypted_value"`
+	SecretRef      map[string]string `yaml:"secret_ref"`
+	CreatedBy      string            `yaml:"created_by"`
+}
+
+// decryptedCredential pairs a manifest with its decrypted secret data
+type decryptedCredential struct {
+	Manifest CredentialManifest
+	Data     map[string]string
+}
+
+// parseCredentialManifests decodes, decrypts and validates every document in data
+func (d *decryptor) parseCredentialManifests(data []byte) ([]decryptedCredential, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	creds := []decryptedCredential{}
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+
+		encrypted := map[string]bool{}
+		if isSOPSDocument(&doc) {
+			if encrypted, err = d.decryptSOPS(&doc); err != nil {
+				return nil, err
+			}
+		}
+
+		// Re-encode the decrypted tree so unknown fields are still rejected
+		plain, err := yaml.Marshal(&doc)
+		if err != nil {
+			return nil, err
+		}
+		strict := yaml.NewDecoder(bytes.NewReader(plain))
+		strict.KnownFields(true)
+
+		var m CredentialManifest
+		if err := strict.Decode(&m); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+
+		secret, err := d.credentialData(&m, encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("credential %s: %w", m.Metadata.Name, err)
+		}
+		creds = append(creds, decryptedCredential{Manifest: m, Data: secret})
+	}
+
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("no credential manifests found")
+	}
+	return creds, nil
+}
+
+// Validate checks a credential manifest for schema errors
+func (m *CredentialManifest) Validate() error {
+	if m.APIVersion != APIVersionV1 {
+		return fmt.Errorf("unsupported apiVersion %q (expected %s)", m.APIVersion, APIVersionV1)
+	}
+	if m.Kind != "Credential" {
+		return fmt.Errorf("unexpected kind %q (expected Credential)", m.Kind)
+	}
+	if !resourceNamePattern.MatchString(m.Metadata.Name) {
+		return fmt.Errorf("metadata.name %q must be lowercase alphanumerics, '.', '_' or '-'", m.Metadata.Name)
+	}
+	if !credentials.ValidTypes[m.Spec.Type] {
+		return fmt.Errorf("unsupported spec.type %q", m.Spec.Type)
+	}
+
+	sources := 0
+	if m.Spec.Value != "" || len(m.Spec.Data) > 0 {
+		sources++
+	}
+	if m.Spec.EncryptedValue != "" {
+		sources++
+	}
+	if len(m.Spec.SecretRef) > 0 {
+		sources++
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of spec.value/spec.data, spec.encrypted_value or spec.secret_ref is required")
+	}
+
+	return nil
+}
+
+// credentialData resolves the secret material of a manifest. Inline values must
+// have been SOPS-encrypted in the file; encrypted paths lists those that were.
+func (d *decryptor) credentialData(m *CredentialManifest, encrypted map[string]bool) (map[string]string, error) {
+	data := make(map[string]string)
+
+	switch {
+	case m.Spec.EncryptedValue != "":
+		value, err := d.decryptAge(m.Spec.EncryptedValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt spec.encrypted_value: %w", err)
+		}
+		data["value"] = string(value)
+
+	case len(m.Spec.SecretRef) > 0:
+		// External references carry no secret material
+		for k, v := range m.Spec.SecretRef {
+			data["secret_ref."+k] = v
+		}
+
+	default:
+		if m.Spec.Value != "" {
+			if !encrypted["spec:value:"] {
+				return nil, fmt.Errorf("spec.value is not encrypted; encrypt the file with SOPS or use spec.encrypted_value")
+			}
+			data["value"] = m.Spec.Value
+		}
+
+		keys := make([]string, 0, len(m.Spec.Data))
+		for k := range m.Spec.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if !encrypted["spec:data:"+k+":"] {
+				return nil, fmt.Errorf("spec.data.%s is not encrypted; encrypt the file with SOPS", k)
+			}
+			data[k] = m.Spec.Data[k]
+		}
+	}
+
+	return data, nil
+}
+
+// applyCredentialFile decrypts a credential file and upserts each credential it contains
+func (s *SyncService) applyCredentialFile(ctx context.Context, file string) ([]FileResult, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := s.decryptor.parseCredentialManifests(data)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []FileResult{}
+	for _, c := range creds {
+		action := "validated"
+		if !s.cfg.Sync.DryRun {
+			if err := s.checkOwner(ctx, "credentials", c.Manifest.Metadata.Name); err != nil {
+				return nil, err
+			}
+			created, err := s.credentials.Upsert(ctx, &credentials.Credential{
+				Name:        c.Manifest.Metadata.Name,
+				Type:        c.Manifest.Spec.Type,
+				Description: c.Manifest.Spec.Description,
+				Data:        c.Data,
+				CreatedBy:   s.owner,
+			})
+			if errors.Is(err, credentials.ErrNotOwned) {
+				// Created by someone else since checkOwner looked
+				return nil, fmt.Errorf("%w: %s was not created by GitOps", errNotOwned, c.Manifest.Metadata.Name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("credential %s: %w", c.Manifest.Metadata.Name, err)
+			}
+			action = "updated"
+			if created {
+				action = "created"
+			}
+		}
+		results = append(results, FileResult{File: file, Kind: "Credential", Name: c.Manifest.Metadata.Name, Action: action})
+	}
+
+	return results, nil
+}
+
+// pruneCredentials deletes GitOps-owned credentials that no longer exist in the repository
+func (s *SyncService) pruneCredentials(ctx context.Context, keep []string) ([]FileResult, error) {
+	deleted, err := s.credentials.DeleteOwnedExcept(ctx, s.owner, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []FileResult{}
+	for _, name := range deleted {
+		log.Info().Str("source", s.name).Str("credential", name).Msg("Pruned credential no longer present in GitOps repository")
+		results = append(results, FileResult{Kind: "Credential", Name: name, Action: "pruned"})
+	}
+	return results, nil
+}
@@ -0,0 +1,460 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+	"github.com/solvyd/solvyd/api-server/internal/pluginconfig"
+	"github.com/solvyd/solvyd/api-server/internal/pools"
+	"github.com/solvyd/solvyd/api-server/internal/triggers"
+)
+
+// APIVersionV1 is the only GitOps manifest version currently understood
+const APIVersionV1 = "solvyd.dev/v1"
+
+// gitopsOwner marks resources created and owned by the GitOps sync
+const gitopsOwner = "gitops"
+
+// prunedJobReason is the disabled_reason of jobs whose manifest was removed
+const prunedJobReason = "Removed from the GitOps repository"
+
+var resourceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// ManifestMetadata identifies a GitOps resource
+type ManifestMetadata struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// JobManifest is the versioned YAML representation of a job
+type JobManifest struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   ManifestMetadata `yaml:"metadata"`
+	Spec       JobSpec          `yaml:"spec"`
+}
+
+// JobSpec describes the desired state of a job
+type JobSpec struct {
+	Description      string                   `yaml:"description"`
+	SCM              SCMSpec                  `yaml:"scm"`
+	Build            BuildSpec                `yaml:"build"`
+	Environment      map[string]interface{}   `yaml:"environment"`
+	Triggers         []map[string]interface{} `yaml:"triggers"`
+	Pipeline         PipelineSpec             `yaml:"pipeline"`
+	Plugins          []map[string]interface{} `yaml:"plugins"`
+	Notifications    []map[string]interface{} `yaml:"notifications"`
+	WorkerLabels     map[string]interface{}   `yaml:"worker_labels"`
+	Pool             string                   `yaml:"pool"`
+	Timeout          int                      `yaml:"timeout"`
+	MaxRetries       int                      `yaml:"max_retries"`
+	Enabled          *bool                    `yaml:"enabled"`
+	DisabledReason   string                   `yaml:"disabled_reason"`
+	CancelInProgress bool                     `yaml:"cancel_in_progress"`
+}
+
+// SCMSpec describes where a job's source lives
+type SCMSpec struct {
+	Type        string `yaml:"type"`
+	URL         string `yaml:"url"`
+	Branch      string `yaml:"branch"`
+	Credentials string `yaml:"credentials"`
+}
+
+// BuildSpec describes how a job is built
+type BuildSpec struct {
+	Type       string                 `yaml:"type"`
+	Image      string                 `yaml:"image"`
+	BuildImage string                 `yaml:"build_image"`
+	Commands   []string               `yaml:"commands"`
+	Artifacts  string                 `yaml:"artifacts"`
+	Config     map[string]interface{} `yaml:"config"`
+}
+
+// PipelineSpec holds the stages of a multi-stage pipeline, or the file in the
+// job's repository that defines its pipeline
+type PipelineSpec struct {
+	Stages []map[string]interface{} `yaml:"stages"`
+	File   string                   `yaml:"file"`
+}
+
+// FileResult reports the outcome of applying a single GitOps file
+type FileResult struct {
+	File   string `json:"file"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Action string `json:"action"` // created, updated, validated, pruned, failed
+	Error  string `json:"error,omitempty"`
+}
+
+// listManifestFiles returns all YAML files in dir
+func listManifestFiles(dir string) ([]string, error) {
+	files := []string{}
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// ParseJobManifests decodes every YAML document in data as a job manifest
+func ParseJobManifests(data []byte) ([]JobManifest, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	manifests := []JobManifest{}
+	for {
+		var m JobManifest
+		err := decoder.Decode(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no job manifests found")
+	}
+	return manifests, nil
+}
+
+// Validate checks a job manifest for schema and semantic errors
+func (m *JobManifest) Validate() error {
+	if m.APIVersion != APIVersionV1 {
+		return fmt.Errorf("unsupported apiVersion %q (expected %s)", m.APIVersion, APIVersionV1)
+	}
+	if m.Kind != "Job" {
+		return fmt.Errorf("unexpected kind %q (expected Job)", m.Kind)
+	}
+	if !resourceNamePattern.MatchString(m.Metadata.Name) {
+		return fmt.Errorf("metadata.name %q must be lowercase alphanumerics, '.', '_' or '-'", m.Metadata.Name)
+	}
+
+	spec := m.Spec
+	if spec.SCM.URL == "" {
+		return fmt.Errorf("spec.scm.url is required")
+	}
+	if spec.Timeout < 0 {
+		return fmt.Errorf("spec.timeout must not be negative")
+	}
+	if spec.MaxRetries < 0 {
+		return fmt.Errorf("spec.max_retries must not be negative")
+	}
+
+	triggerList := make([]interface{}, len(spec.Triggers))
+	for i, trigger := range spec.Triggers {
+		triggerList[i] = trigger
+	}
+	if err := triggers.Validate(triggerList); err != nil {
+		return fmt.Errorf("spec.%v", err)
+	}
+
+	stages := make(map[string]bool)
+	for i, stage := range spec.Pipeline.Stages {
+		name, _ := stage["name"].(string)
+		if name == "" {
+			return fmt.Errorf("spec.pipeline.stages[%d]: name is required", i)
+		}
+		if stages[name] {
+			return fmt.Errorf("spec.pipeline.stages[%d]: duplicate stage name %q", i, name)
+		}
+		stages[name] = true
+		if when, ok := stage["when"].(string); ok {
+			if err := pipeline.ValidWhen(when); err != nil {
+				return fmt.Errorf("spec.pipeline.stages[%d].when: %v", i, err)
+			}
+		}
+	}
+	for _, stage := range spec.Pipeline.Stages {
+		deps, _ := stage["depends_on"].([]interface{})
+		for _, dep := range deps {
+			if depName, _ := dep.(string); !stages[depName] {
+				return fmt.Errorf("stage %q depends on unknown stage %v", stage["name"], dep)
+			}
+		}
+	}
+
+	if spec.Pool != "" && spec.Pool != pools.DefaultPool && !pools.ValidName(spec.Pool) {
+		return fmt.Errorf("spec.pool %q must be lowercase alphanumerics, '.', '_' or '-'", spec.Pool)
+	}
+
+	if spec.Pipeline.File != "" && !pipeline.ValidFile(spec.Pipeline.File) {
+		return fmt.Errorf("spec.pipeline.file %q must be a relative path inside the repository", spec.Pipeline.File)
+	}
+
+	for i, plugin := range spec.Plugins {
+		if name, _ := plugin["name"].(string); name == "" {
+			return fmt.Errorf("spec.plugins[%d]: name is required", i)
+		}
+	}
+	for i, notification := range spec.Notifications {
+		if name, _ := notification["name"].(string); name == "" {
+			return fmt.Errorf("spec.notifications[%d]: name is required", i)
+		}
+	}
+
+	return nil
+}
+
+// buildConfig flattens the build spec into the jobs.build_config document
+func (b BuildSpec) buildConfig() map[string]interface{} {
+	cfg := make(map[string]interface{})
+	for k, v := range b.Config {
+		cfg[k] = v
+	}
+	if b.Type != "" {
+		cfg["type"] = b.Type
+	}
+	if b.Image != "" {
+		cfg["image"] = b.Image
+	}
+	if b.BuildImage != "" {
+		cfg["build_image"] = b.BuildImage
+	}
+	if len(b.Commands) > 0 {
+		cfg["commands"] = b.Commands
+	}
+	if b.Artifacts != "" {
+		cfg["artifacts"] = b.Artifacts
+	}
+	return cfg
+}
+
+// applyJobFile parses a job file and upserts each manifest it contains
+func (s *SyncService) applyJobFile(ctx context.Context, file string) ([]FileResult, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := ParseJobManifests(data)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []FileResult{}
+	for _, m := range manifests {
+		// Plugin schemas live in the database, so this is checked here rather than in Validate
+		if err := pluginconfig.ValidateSteps(ctx, s.db, orEmptyList(m.Spec.Plugins)); err != nil {
+			return nil, fmt.Errorf("job %s: %w", m.Metadata.Name, err)
+		}
+		if err := pluginconfig.ValidateNotifications(ctx, s.db, orEmptyList(m.Spec.Notifications)); err != nil {
+			return nil, fmt.Errorf("job %s: %w", m.Metadata.Name, err)
+		}
+		problems, err := pipeline.CheckImages(ctx, s.db, m.Spec.Build.buildConfig())
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", m.Metadata.Name, err)
+		}
+		if len(problems) > 0 {
+			return nil, fmt.Errorf("job %s: invalid build images: %s", m.Metadata.Name, strings.Join(problems, "; "))
+		}
+
+		action := "validated"
+		if !s.cfg.Sync.DryRun {
+			created, err := s.upsertJob(ctx, &m)
+			if err != nil {
+				return nil, fmt.Errorf("job %s: %w", m.Metadata.Name, err)
+			}
+			action = "updated"
+			if created {
+				action = "created"
+			}
+		}
+		results = append(results, FileResult{File: file, Kind: "Job", Name: m.Metadata.Name, Action: action})
+	}
+
+	return results, nil
+}
+
+// upsertJob writes a job manifest into the jobs table keyed by its name
+func (s *SyncService) upsertJob(ctx context.Context, m *JobManifest) (bool, error) {
+	spec := m.Spec
+
+	branch := spec.SCM.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	timeout := spec.Timeout
+	if timeout == 0 {
+		timeout = 60
+	}
+	enabled := true
+	if spec.Enabled != nil {
+		enabled = *spec.Enabled
+	}
+	disabledReason := ""
+	if !enabled {
+		disabledReason = spec.DisabledReason
+		if disabledReason == "" {
+			disabledReason = "Disabled in the GitOps repository"
+		}
+	}
+
+	var credentialsID interface{}
+	if spec.SCM.Credentials != "" {
+		var id string
+		err := s.db.GetConn().QueryRowContext(ctx,
+			`SELECT id FROM credentials WHERE name = $1`, spec.SCM.Credentials).Scan(&id)
+		if err == nil {
+			credentialsID = id
+		} else {
+			log.Warn().
+				Str("job", m.Metadata.Name).
+				Str("credentials", spec.SCM.Credentials).
+				Msg("Referenced credentials not found, job will be created without them")
+		}
+	}
+
+	if err := s.checkOwner(ctx, "jobs", m.Metadata.Name); err != nil {
+		return false, err
+	}
+
+	// Pools are created with the first job or worker that names them
+	pool := spec.Pool
+	if pool == pools.DefaultPool {
+		pool = ""
+	}
+	if pool != "" {
+		if err := pools.Ensure(ctx, s.db, pool); err != nil {
+			return false, err
+		}
+	}
+
+	buildConfig, _ := json.Marshal(spec.Build.buildConfig())
+	envVars, _ := json.Marshal(orEmptyMap(spec.Environment))
+	triggers, _ := json.Marshal(orEmptyList(spec.Triggers))
+	workerLabels, _ := json.Marshal(orEmptyMap(spec.WorkerLabels))
+	plugins, _ := json.Marshal(orEmptyList(spec.Plugins))
+	notifications, _ := json.Marshal(orEmptyList(spec.Notifications))
+	stages, _ := json.Marshal(orEmptyList(spec.Pipeline.Stages))
+	labels := map[string]string{}
+	for k, v := range m.Metadata.Labels {
+		labels[k] = v
+	}
+	labelsJSON, _ := json.Marshal(labels)
+
+	query := `
+		INSERT INTO jobs (name, description, scm_type, scm_url, scm_branch, scm_credentials_id,
+		                  build_config, environment_vars, triggers, enabled, worker_labels,
+		                  plugins, pipeline_stages, timeout_minutes, max_retries, created_by, project, labels,
+		                  notifications, cancel_in_progress, pipeline_file, pool,
+		                  disabled_reason, disabled_by, disabled_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''), $18, $19, $20,
+		        NULLIF($21, ''), NULLIF($22, ''),
+		        NULLIF($23, ''), CASE WHEN $10 THEN NULL ELSE $16 END, CASE WHEN $10 THEN NULL ELSE CURRENT_TIMESTAMP END)
+		ON CONFLICT (name) DO UPDATE SET
+			description = EXCLUDED.description,
+			scm_type = EXCLUDED.scm_type,
+			scm_url = EXCLUDED.scm_url,
+			scm_branch = EXCLUDED.scm_branch,
+			scm_credentials_id = EXCLUDED.scm_credentials_id,
+			build_config = EXCLUDED.build_config,
+			environment_vars = EXCLUDED.environment_vars,
+			triggers = EXCLUDED.triggers,
+			enabled = EXCLUDED.enabled,
+			worker_labels = EXCLUDED.worker_labels,
+			plugins = EXCLUDED.plugins,
+			pipeline_stages = EXCLUDED.pipeline_stages,
+			timeout_minutes = EXCLUDED.timeout_minutes,
+			max_retries = EXCLUDED.max_retries,
+			created_by = EXCLUDED.created_by,
+			project = EXCLUDED.project,
+			labels = EXCLUDED.labels,
+			notifications = EXCLUDED.notifications,
+			cancel_in_progress = EXCLUDED.cancel_in_progress,
+			pipeline_file = EXCLUDED.pipeline_file,
+			pool = EXCLUDED.pool,
+			disabled_reason = EXCLUDED.disabled_reason,
+			disabled_by = EXCLUDED.disabled_by,
+			disabled_at = CASE WHEN EXCLUDED.enabled THEN NULL ELSE COALESCE(jobs.disabled_at, EXCLUDED.disabled_at) END,
+			reenable_at = NULL
+		WHERE jobs.created_by = EXCLUDED.created_by
+		RETURNING (xmax = 0) AS inserted
+	`
+
+	var inserted bool
+	err := s.db.GetConn().QueryRowContext(ctx, query,
+		m.Metadata.Name, spec.Description, spec.SCM.Type, spec.SCM.URL, branch, credentialsID,
+		buildConfig, envVars, triggers, enabled, workerLabels,
+		plugins, stages, timeout, spec.MaxRetries, s.owner, s.project, labelsJSON,
+		notifications, spec.CancelInProgress, spec.Pipeline.File, pool,
+		disabledReason,
+	).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		// Created by someone else since checkOwner looked
+		return false, fmt.Errorf("%w: %s was not created by GitOps", errNotOwned, m.Metadata.Name)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return inserted, nil
+}
+
+// pruneJobs disables GitOps-owned jobs that no longer exist in the repository.
+// They are not deleted, which would take their builds, pinned ones included,
+// with them; restoring the file enables the job again.
+func (s *SyncService) pruneJobs(ctx context.Context, keep []string) ([]FileResult, error) {
+	query := `
+		UPDATE jobs
+		SET enabled = false, disabled_reason = $3, disabled_by = $1, disabled_at = CURRENT_TIMESTAMP,
+		    reenable_at = NULL
+		WHERE created_by = $1 AND enabled AND NOT (name = ANY($2))
+		RETURNING name
+	`
+
+	rows, err := s.db.GetConn().QueryContext(ctx, query, s.owner, pq.Array(keep), prunedJobReason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []FileResult{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			continue
+		}
+		log.Info().Str("source", s.name).Str("job", name).Msg("Disabled job no longer present in GitOps repository")
+		results = append(results, FileResult{Kind: "Job", Name: name, Action: "pruned"})
+	}
+
+	return results, rows.Err()
+}
+
+func orEmptyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
+
+func orEmptyList(l []map[string]interface{}) []map[string]interface{} {
+	if l == nil {
+		return []map[string]interface{}{}
+	}
+	return l
+}
@@ -0,0 +1,199 @@
+package gitops
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// defaultSourceName identifies the repository configured under gitops.repository
+const defaultSourceName = "default"
+
+// ErrUnknownSource is returned when a sync is requested for a source that is not configured
+var ErrUnknownSource = errors.New("unknown GitOps source")
+
+// errNotOwned is returned when a manifest names a resource the source does not own
+var errNotOwned = errors.New("resource not owned by this GitOps source")
+
+// Manager runs an independent SyncService per configured GitOps repository, so a
+// broken repository never blocks or prunes resources from another
+type Manager struct {
+	services []*SyncService
+}
+
+// NewManager creates sync services for the primary repository and every additional source
+func NewManager(cfg *config.GitOpsConfig, db *database.Database, creds *credentials.Store) (*Manager, error) {
+	m := &Manager{}
+
+	if cfg.Repository.URL != "" {
+		svc, err := NewSyncService(cfg, db, creds)
+		if err != nil {
+			return nil, err
+		}
+		m.services = append(m.services, svc)
+	}
+
+	seen := map[string]bool{defaultSourceName: true}
+	for _, src := range cfg.Sources {
+		if !resourceNamePattern.MatchString(src.Name) {
+			return nil, fmt.Errorf("gitops source name %q must be lowercase alphanumerics, '.', '_' or '-'", src.Name)
+		}
+		if seen[src.Name] {
+			return nil, fmt.Errorf("duplicate gitops source %q", src.Name)
+		}
+		seen[src.Name] = true
+
+		if src.Repository.URL == "" {
+			return nil, fmt.Errorf("gitops source %q has no repository url", src.Name)
+		}
+
+		svc, err := NewSyncService(sourceConfig(cfg, src), db, creds)
+		if err != nil {
+			return nil, fmt.Errorf("gitops source %q: %w", src.Name, err)
+		}
+		svc.name = src.Name
+		svc.project = src.Project
+		svc.owner = gitopsOwner + ":" + src.Name
+		svc.repoPath = filepath.Join(os.TempDir(), "solvyd-gitops-"+src.Name)
+		m.services = append(m.services, svc)
+	}
+
+	if len(m.services) == 0 {
+		return nil, fmt.Errorf("no GitOps repositories configured")
+	}
+
+	return m, nil
+}
+
+// sourceConfig derives a per-source configuration, sharing sync and decryption settings
+func sourceConfig(base *config.GitOpsConfig, src config.GitOpsSource) *config.GitOpsConfig {
+	cfg := *base
+	cfg.Sources = nil
+	cfg.Repository = src.Repository
+	cfg.Authentication = src.Authentication
+
+	if cfg.Repository.Branch == "" {
+		cfg.Repository.Branch = "main"
+	}
+	if cfg.Repository.Path == "" {
+		cfg.Repository.Path = "/"
+	}
+	if cfg.Authentication.Type == "" {
+		cfg.Authentication.Type = "token"
+	}
+	if cfg.Authentication.GitHubApp.APIURL == "" {
+		cfg.Authentication.GitHubApp.APIURL = base.Authentication.GitHubApp.APIURL
+	}
+
+	return &cfg
+}
+
+// Start starts every source; a failing initial sync is reported but does not stop the others
+func (m *Manager) Start() error {
+	var errs []error
+	for _, svc := range m.services {
+		if err := svc.Start(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", svc.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stop stops every source
+func (m *Manager) Stop() {
+	for _, svc := range m.services {
+		svc.Stop()
+	}
+}
+
+// Sync synchronizes the named source, or every source when name is empty
+func (m *Manager) Sync(name string) error {
+	if name != "" {
+		for _, svc := range m.services {
+			if svc.name == name {
+				return svc.Sync()
+			}
+		}
+		return ErrUnknownSource
+	}
+
+	var errs []error
+	for _, svc := range m.services {
+		if err := svc.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", svc.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Repository returns the repository URL of the named source and the auth it
+// is cloned with, for the pipeline libraries kept in it
+func (m *Manager) Repository(name string) (string, transport.AuthMethod, error) {
+	for _, svc := range m.services {
+		if svc.name == name {
+			auth, err := svc.authMethod()
+			return svc.cfg.Repository.URL, auth, err
+		}
+	}
+	return "", nil, fmt.Errorf("%w %q", ErrUnknownSource, name)
+}
+
+// GetStatus returns the sync status of every source
+func (m *Manager) GetStatus() map[string]interface{} {
+	sources := make([]map[string]interface{}, 0, len(m.services))
+	healthy := true
+	for _, svc := range m.services {
+		status := svc.GetStatus()
+		if status["last_error"] != "" {
+			healthy = false
+		}
+		sources = append(sources, status)
+	}
+
+	return map[string]interface{}{
+		"enabled": true,
+		"healthy": healthy,
+		"sources": sources,
+	}
+}
+
+// checkOwner refuses to overwrite a resource this source does not own: one
+// another GitOps source manages, or one created through the API or UI, which
+// would otherwise be adopted and later pruned
+func (s *SyncService) checkOwner(ctx context.Context, table, name string) error {
+	var owner sql.NullString
+	err := s.db.GetConn().QueryRowContext(ctx,
+		"SELECT created_by FROM "+table+" WHERE name = $1", name).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if isGitOpsOwner(owner.String) && owner.String != s.owner {
+		source := strings.TrimPrefix(strings.TrimPrefix(owner.String, gitopsOwner), ":")
+		if source == "" {
+			source = defaultSourceName
+		}
+		return fmt.Errorf("%s is already managed by GitOps source %q", name, source)
+	}
+	if owner.String != s.owner {
+		return fmt.Errorf("%w: %s was not created by GitOps; delete or rename it to manage it from the repository", errNotOwned, name)
+	}
+	return nil
+}
+
+func isGitOpsOwner(owner string) bool {
+	return owner == gitopsOwner || strings.HasPrefix(owner, gitopsOwner+":")
+}
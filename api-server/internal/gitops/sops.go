@@ -0,0 +1,296 @@
+package gitops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+)
+
+// sopsValuePattern matches a single SOPS-encrypted scalar
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// sopsMetadata is the subset of the `sops` block needed to decrypt with age
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+// decryptor decrypts SOPS- and age-encrypted GitOps documents with the configured age identities
+type decryptor struct {
+	identities []age.Identity
+}
+
+// newDecryptor loads age identities from the inline key and/or key file
+func newDecryptor(cfg config.GitOpsDecryption) (*decryptor, error) {
+	d := &decryptor{}
+
+	sources := []string{}
+	if cfg.AgeKey != "" {
+		sources = append(sources, cfg.AgeKey)
+	}
+	if cfg.AgeKeyFile != "" {
+		data, err := os.ReadFile(cfg.AgeKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age key file: %w", err)
+		}
+		sources = append(sources, string(data))
+	}
+
+	for _, src := range sources {
+		ids, err := age.ParseIdentities(strings.NewReader(src))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity: %w", err)
+		}
+		d.identities = append(d.identities, ids...)
+	}
+
+	return d, nil
+}
+
+// decryptAge decrypts an ASCII-armored age payload
+func (d *decryptor) decryptAge(armored string) ([]byte, error) {
+	if len(d.identities) == 0 {
+		return nil, fmt.Errorf("no age identity configured (set gitops.decryption.age_key or age_key_file)")
+	}
+
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(armored))), d.identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// isSOPSDocument reports whether a decoded YAML document carries SOPS metadata
+func isSOPSDocument(doc *yaml.Node) bool {
+	_, idx := sopsBlock(doc)
+	return idx >= 0
+}
+
+// decryptSOPS decrypts a SOPS-encrypted document in place, verifies its MAC and
+// strips the sops block. It returns the paths of the values that were encrypted.
+func (d *decryptor) decryptSOPS(doc *yaml.Node) (map[string]bool, error) {
+	root, idx := sopsBlock(doc)
+	if idx < 0 {
+		return nil, fmt.Errorf("document is not SOPS-encrypted")
+	}
+
+	var meta sopsMetadata
+	if err := root.Content[idx+1].Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid sops metadata: %w", err)
+	}
+	root.Content = append(root.Content[:idx], root.Content[idx+2:]...)
+
+	key, err := d.sopsDataKey(&meta)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &sopsWalker{
+		key:              key,
+		mac:              sha512.New(),
+		macOnlyEncrypted: meta.MACOnlyEncrypted,
+		encrypted:        make(map[string]bool),
+	}
+	if err := w.walk(root, nil); err != nil {
+		return nil, err
+	}
+
+	// The MAC covers every value and is itself encrypted with the timestamp as AAD
+	if meta.MAC == "" {
+		return nil, fmt.Errorf("sops metadata has no MAC")
+	}
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sops lastmodified: %w", err)
+	}
+	expected, _, err := decryptSOPSValue(meta.MAC, key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sops MAC: %w", err)
+	}
+	actual := strings.ToUpper(hex.EncodeToString(w.mac.Sum(nil)))
+	if string(expected) != actual {
+		return nil, fmt.Errorf("sops MAC mismatch: file has been tampered with")
+	}
+
+	return w.encrypted, nil
+}
+
+// sopsDataKey recovers the document data key from its age recipients
+func (d *decryptor) sopsDataKey(meta *sopsMetadata) ([]byte, error) {
+	if len(meta.Age) == 0 {
+		return nil, fmt.Errorf("sops file has no age recipients; only age is supported")
+	}
+
+	var lastErr error
+	for _, recipient := range meta.Age {
+		key, err := d.decryptAge(recipient.Enc)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to decrypt sops data key: %w", lastErr)
+}
+
+// sopsBlock returns the root mapping of doc and the index of its `sops` key, or -1
+func sopsBlock(doc *yaml.Node) (*yaml.Node, int) {
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return root, -1
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sops" {
+			return root, i
+		}
+	}
+	return root, -1
+}
+
+// sopsWalker decrypts values in document order while accumulating the MAC
+type sopsWalker struct {
+	key              []byte
+	mac              hash.Hash
+	macOnlyEncrypted bool
+	encrypted        map[string]bool
+}
+
+func (w *sopsWalker) walk(node *yaml.Node, path []string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			child := append(append([]string{}, path...), node.Content[i].Value)
+			if err := w.walk(node.Content[i+1], child); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		// SOPS does not include list indexes in the authenticated path
+		for _, item := range node.Content {
+			if err := w.walk(item, path); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return w.leaf(node, path)
+	case yaml.AliasNode:
+		return fmt.Errorf("YAML aliases are not supported in encrypted files")
+	}
+	return nil
+}
+
+func (w *sopsWalker) leaf(node *yaml.Node, path []string) error {
+	pathString := strings.Join(path, ":") + ":"
+
+	if !sopsValuePattern.MatchString(node.Value) {
+		if !w.macOnlyEncrypted {
+			w.mac.Write(scalarBytes(node))
+		}
+		return nil
+	}
+
+	plaintext, valueType, err := decryptSOPSValue(node.Value, w.key, pathString)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", strings.TrimSuffix(pathString, ":"), err)
+	}
+	w.mac.Write(plaintext)
+	w.encrypted[pathString] = true
+
+	node.Style = 0
+	node.Value = string(plaintext)
+	switch valueType {
+	case "int":
+		node.Tag = "!!int"
+	case "float":
+		node.Tag = "!!float"
+	case "bool":
+		node.Tag = "!!bool"
+		node.Value = strings.ToLower(node.Value)
+	default:
+		node.Tag = "!!str"
+	}
+	return nil
+}
+
+// decryptSOPSValue decrypts one ENC[AES256_GCM,...] value with the path as AAD
+func decryptSOPSValue(value string, key []byte, aad string) ([]byte, string, error) {
+	m := sopsValuePattern.FindStringSubmatch(value)
+	if m == nil {
+		return nil, "", fmt.Errorf("malformed encrypted value")
+	}
+
+	parts := make([][]byte, 3)
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return nil, "", fmt.Errorf("malformed encrypted value: %w", err)
+		}
+		parts[i] = b
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		return nil, "", err
+	}
+	return plaintext, m[4], nil
+}
+
+// scalarBytes renders an unencrypted scalar the way SOPS hashes it
+func scalarBytes(node *yaml.Node) []byte {
+	switch node.ShortTag() {
+	case "!!null":
+		return nil
+	case "!!bool":
+		var b bool
+		if node.Decode(&b) == nil {
+			if b {
+				return []byte("True")
+			}
+			return []byte("False")
+		}
+	case "!!int":
+		var i int
+		if node.Decode(&i) == nil {
+			return []byte(strconv.Itoa(i))
+		}
+	case "!!float":
+		var f float64
+		if node.Decode(&f) == nil {
+			return []byte(strconv.FormatFloat(f, 'f', -1, 64))
+		}
+	}
+	return []byte(node.Value)
+}
@@ -2,39 +2,77 @@ package gitops
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/rs/zerolog/log"
 
 	"github.com/solvyd/solvyd/api-server/internal/config"
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
 	"github.com/solvyd/solvyd/api-server/internal/database"
 )
 
-// SyncService handles GitOps synchronization
+// SyncService handles GitOps synchronization of a single repository
 type SyncService struct {
-	cfg      *config.GitOpsConfig
-	db       *database.Database
-	repoPath string
-	lastSync time.Time
-	lastHash string
-	ctx      context.Context
-	cancel   context.CancelFunc
+	name        string
+	project     string
+	owner       string // created_by marker for resources this source manages
+	cfg         *config.GitOpsConfig
+	db          *database.Database
+	credentials *credentials.Store
+	decryptor   *decryptor
+	githubApp   *githubAppTokenSource
+	reporter    statusReporter
+	hostKeys    trustOnFirstUse
+	repoPath    string
+	// The commit and state last reported to the repository, apart from
+	// lastHash, which moves once a commit applies even if reporting it failed
+	reportedHash  string
+	reportedState string
+	ctx           context.Context
+	cancel        context.CancelFunc
+
+	syncMu sync.Mutex // serializes scheduled and manual syncs
+	mu     sync.RWMutex
+	// lastSync and lastHash are written holding syncMu as well as mu, so a
+	// sync reads them without mu
+	lastSync    time.Time
+	lastHash    string
+	fileResults []FileResult
+	lastError   string
 }
 
 // NewSyncService creates a new GitOps sync service
-func NewSyncService(cfg *config.GitOpsConfig, db *database.Database) *SyncService {
+func NewSyncService(cfg *config.GitOpsConfig, db *database.Database, creds *credentials.Store) (*SyncService, error) {
+	dec, err := newDecryptor(cfg.Decryption)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	return &SyncService{
-		cfg:      cfg,
-		db:       db,
-		repoPath: filepath.Join(os.TempDir(), "solvyd-gitops-repo"),
-		ctx:      ctx,
-		cancel:   cancel,
+	s := &SyncService{
+		name:        defaultSourceName,
+		owner:       gitopsOwner,
+		cfg:         cfg,
+		db:          db,
+		credentials: creds,
+		decryptor:   dec,
+		repoPath:    filepath.Join(os.TempDir(), "solvyd-gitops-repo"),
+		reporter:    newStatusReporter(),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
+	if cfg.Authentication.Type == "github_app" {
+		s.githubApp = newGitHubAppTokenSource(cfg.Authentication.GitHubApp)
+	}
+	return s, nil
 }
 
 // Start begins the GitOps synchronization loop
@@ -45,21 +83,22 @@ func (s *SyncService) Start() error {
 	}
 
 	log.Info().
+		Str("source", s.name).
 		Str("repo", s.cfg.Repository.URL).
 		Str("branch", s.cfg.Repository.Branch).
 		Int("interval", s.cfg.Sync.Interval).
 		Msg("Starting GitOps sync service")
 
-	// Initial sync
-	if err := s.Sync(); err != nil {
+	// Initial sync; keep the loop running on failure so fixes in Git are picked up
+	err := s.Sync()
+	if err != nil {
 		log.Error().Err(err).Msg("Initial GitOps sync failed")
-		return err
 	}
 
 	// Start periodic sync
 	go s.syncLoop()
 
-	return nil
+	return err
 }
 
 // Stop stops the sync service
@@ -87,6 +126,23 @@ func (s *SyncService) syncLoop() {
 
 // Sync performs a synchronization from Git to database
 func (s *SyncService) Sync() error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	err := s.sync()
+
+	s.mu.Lock()
+	s.lastError = ""
+	if err != nil {
+		s.lastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	return err
+}
+
+// sync clones or pulls the repository and applies it if the commit changed
+func (s *SyncService) sync() error {
 	log.Info().Msg("Starting GitOps sync")
 
 	// Clone or pull repository
@@ -100,9 +156,11 @@ func (s *SyncService) Sync() error {
 		return fmt.Errorf("failed to get current commit: %w", err)
 	}
 
-	// Check if anything changed
+	// Check if anything changed, finishing the report of the applied commit
+	// if posting it failed
 	if hash == s.lastHash {
 		log.Debug().Msg("No changes detected, skipping sync")
+		s.reportStatus(hash, nil)
 		return nil
 	}
 
@@ -111,13 +169,17 @@ func (s *SyncService) Sync() error {
 		Str("new_commit", hash).
 		Msg("Changes detected, applying configuration")
 
-	// Apply configuration
-	if err := s.applyConfiguration(); err != nil {
+	// Apply configuration and tell config authors whether it applied
+	err = s.applyConfiguration()
+	s.reportStatus(hash, err)
+	if err != nil {
 		return fmt.Errorf("failed to apply configuration: %w", err)
 	}
 
+	s.mu.Lock()
 	s.lastHash = hash
 	s.lastSync = time.Now()
+	s.mu.Unlock()
 
 	log.Info().
 		Str("commit", hash).
@@ -129,45 +191,85 @@ func (s *SyncService) Sync() error {
 
 // cloneOrPull clones the repository or pulls latest changes
 func (s *SyncService) cloneOrPull() error {
-	if _, err := os.Stat(filepath.Join(s.repoPath, ".git")); os.IsNotExist(err) {
+	repo, err := git.PlainOpen(s.repoPath)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
 		// Repository doesn't exist, clone it
 		log.Info().Str("path", s.repoPath).Msg("Cloning GitOps repository")
 		return s.cloneRepository()
 	}
+	if err != nil {
+		return err
+	}
 
 	// Repository exists, pull latest changes
 	log.Debug().Msg("Pulling latest changes")
-	return s.pullRepository()
+	if err := s.pullRepository(repo); err != nil {
+		// A shallow clone can fail to fetch after history rewrites; start over
+		log.Warn().Err(err).Msg("Failed to update GitOps repository, re-cloning")
+		return s.cloneRepository()
+	}
+	return nil
 }
 
-// cloneRepository clones the Git repository
+// cloneRepository shallow-clones the configured branch
 func (s *SyncService) cloneRepository() error {
 	// Remove existing directory if it exists
 	os.RemoveAll(s.repoPath)
 
-	cmd := exec.Command("git", "clone",
-		"--branch", s.cfg.Repository.Branch,
-		"--depth", "1",
-		s.getAuthenticatedURL(),
-		s.repoPath,
-	)
+	auth, err := s.authMethod()
+	if err != nil {
+		return err
+	}
 
-	output, err := cmd.CombinedOutput()
+	_, err = git.PlainCloneContext(s.ctx, s.repoPath, false, &git.CloneOptions{
+		URL:           s.cfg.Repository.URL,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(s.cfg.Repository.Branch),
+		SingleBranch:  true,
+		Depth:         1,
+	})
 	if err != nil {
-		return fmt.Errorf("git clone failed: %w, output: %s", err, output)
+		return fmt.Errorf("git clone failed: %w", err)
 	}
 
 	return nil
 }
 
-// pullRepository pulls latest changes
-func (s *SyncService) pullRepository() error {
-	cmd := exec.Command("git", "pull", "--rebase")
-	cmd.Dir = s.repoPath
+// pullRepository shallow-fetches the branch and hard-resets the worktree to it,
+// so force-pushes to the config repository are applied as-is
+func (s *SyncService) pullRepository(repo *git.Repository) error {
+	auth, err := s.authMethod()
+	if err != nil {
+		return err
+	}
+
+	branch := s.cfg.Repository.Branch
+	remoteRef := plumbing.NewRemoteReferenceName("origin", branch)
+
+	err = repo.FetchContext(s.ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs: []gitconfig.RefSpec{
+			gitconfig.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), remoteRef)),
+		},
+		Auth:  auth,
+		Depth: 1,
+		Force: true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("git fetch failed: %w", err)
+	}
+
+	ref, err := repo.Reference(remoteRef, true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", remoteRef, err)
+	}
 
-	output, err := cmd.CombinedOutput()
+	worktree, err := repo.Worktree()
 	if err != nil {
-		return fmt.Errorf("git pull failed: %w, output: %s", err, output)
+		return err
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: ref.Hash(), Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("git reset failed: %w", err)
 	}
 
 	return nil
@@ -175,46 +277,38 @@ func (s *SyncService) pullRepository() error {
 
 // getCurrentCommit gets the current commit hash
 func (s *SyncService) getCurrentCommit() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "HEAD")
-	cmd.Dir = s.repoPath
-
-	output, err := cmd.Output()
+	repo, err := git.PlainOpen(s.repoPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to get commit hash: %w", err)
+		return "", err
 	}
 
-	return string(output[:40]), nil // First 40 chars = full SHA
-}
-
-// getAuthenticatedURL returns the Git URL with authentication
-func (s *SyncService) getAuthenticatedURL() string {
-	// Insert token into HTTPS URL
-	// https://github.com/org/repo -> https://TOKEN@github.com/org/repo
-	if s.cfg.Authentication.Type == "token" && s.cfg.Authentication.Token != "" {
-		url := s.cfg.Repository.URL
-		if len(url) > 8 && url[:8] == "https://" {
-			return "https://" + s.cfg.Authentication.Token + "@" + url[8:]
-		}
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit hash: %w", err)
 	}
 
-	return s.cfg.Repository.URL
+	return head.Hash().String(), nil
 }
 
 // applyConfiguration applies the configuration from Git to database
 func (s *SyncService) applyConfiguration() error {
 	configPath := filepath.Join(s.repoPath, s.cfg.Repository.Path)
 
-	// Apply jobs
-	if err := s.applyJobs(filepath.Join(configPath, "jobs")); err != nil {
-		log.Error().Err(err).Msg("Failed to apply jobs")
+	s.mu.Lock()
+	s.fileResults = []FileResult{}
+	s.mu.Unlock()
+
+	// Apply credentials first so jobs can reference them
+	if err := s.applyCredentials(filepath.Join(configPath, "credentials")); err != nil {
+		log.Error().Err(err).Msg("Failed to apply credentials")
 		if !s.cfg.Sync.DryRun {
 			return err
 		}
 	}
 
-	// Apply credentials
-	if err := s.applyCredentials(filepath.Join(configPath, "credentials")); err != nil {
-		log.Error().Err(err).Msg("Failed to apply credentials")
+	// Apply jobs
+	if err := s.applyJobs(filepath.Join(configPath, "jobs")); err != nil {
+		log.Error().Err(err).Msg("Failed to apply jobs")
 		if !s.cfg.Sync.DryRun {
 			return err
 		}
@@ -238,39 +332,116 @@ func (s *SyncService) applyJobs(jobsPath string) error {
 		return nil
 	}
 
-	files, err := filepath.Glob(filepath.Join(jobsPath, "*.yaml"))
+	files, err := listManifestFiles(jobsPath)
 	if err != nil {
 		return err
 	}
 
 	log.Info().Int("count", len(files)).Msg("Applying jobs from GitOps")
 
+	applied := []string{}
+	failed := 0
+
 	for _, file := range files {
 		log.Debug().Str("file", file).Msg("Processing job configuration")
-		// TODO: Parse YAML and apply to database
-		// This will be implemented in the next iteration
+
+		results, err := s.applyJobFile(s.ctx, file)
+		if err != nil {
+			log.Error().Err(err).Str("file", file).Msg("Failed to apply job file")
+			s.recordResults(FileResult{File: s.relativePath(file), Kind: "Job", Action: "failed", Error: err.Error()})
+			failed++
+			continue
+		}
+
+		for i := range results {
+			results[i].File = s.relativePath(file)
+			applied = append(applied, results[i].Name)
+		}
+		s.recordResults(results...)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d job files failed to apply", failed, len(files))
+	}
+
+	// Only prune after every file applied cleanly, so a broken file never
+	// disables its job, and never on an empty directory, which is far more
+	// likely a mistake than the intent to stop every job
+	if s.cfg.Sync.Prune && !s.cfg.Sync.DryRun && len(applied) == 0 {
+		log.Warn().Str("source", s.name).Msg("No jobs in the GitOps repository, not pruning")
+	} else if s.cfg.Sync.Prune && !s.cfg.Sync.DryRun {
+		pruned, err := s.pruneJobs(s.ctx, applied)
+		if err != nil {
+			return fmt.Errorf("failed to prune jobs: %w", err)
+		}
+		s.recordResults(pruned...)
 	}
 
 	return nil
 }
 
-// applyCredentials applies credential configurations
+// recordResults appends per-file outcomes to the sync status
+func (s *SyncService) recordResults(results ...FileResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fileResults = append(s.fileResults, results...)
+}
+
+// relativePath returns file relative to the repository root for status reporting
+func (s *SyncService) relativePath(file string) string {
+	if rel, err := filepath.Rel(s.repoPath, file); err == nil {
+		return rel
+	}
+	return file
+}
+
+// applyCredentials decrypts credential configurations and stores them encrypted at rest
 func (s *SyncService) applyCredentials(credsPath string) error {
 	if _, err := os.Stat(credsPath); os.IsNotExist(err) {
 		log.Debug().Str("path", credsPath).Msg("Credentials directory not found, skipping")
 		return nil
 	}
 
-	files, err := filepath.Glob(filepath.Join(credsPath, "*.yaml"))
+	files, err := listManifestFiles(credsPath)
 	if err != nil {
 		return err
 	}
 
 	log.Info().Int("count", len(files)).Msg("Applying credentials from GitOps")
 
+	applied := []string{}
+	failed := 0
+
 	for _, file := range files {
 		log.Debug().Str("file", file).Msg("Processing credential configuration")
-		// TODO: Parse YAML and apply to database
+
+		results, err := s.applyCredentialFile(s.ctx, file)
+		if err != nil {
+			log.Error().Err(err).Str("file", file).Msg("Failed to apply credential file")
+			s.recordResults(FileResult{File: s.relativePath(file), Kind: "Credential", Action: "failed", Error: err.Error()})
+			failed++
+			continue
+		}
+
+		for i := range results {
+			results[i].File = s.relativePath(file)
+			applied = append(applied, results[i].Name)
+		}
+		s.recordResults(results...)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d credential files failed to apply", failed, len(files))
+	}
+
+	if s.cfg.Sync.Prune && !s.cfg.Sync.DryRun && len(applied) == 0 {
+		log.Warn().Str("source", s.name).Msg("No credentials in the GitOps repository, not pruning")
+	} else if s.cfg.Sync.Prune && !s.cfg.Sync.DryRun {
+		pruned, err := s.pruneCredentials(s.ctx, applied)
+		if err != nil {
+			return fmt.Errorf("failed to prune credentials: %w", err)
+		}
+		s.recordResults(pruned...)
 	}
 
 	return nil
@@ -300,7 +471,13 @@ func (s *SyncService) applyWebhooks(webhooksPath string) error {
 
 // GetStatus returns the current sync status
 func (s *SyncService) GetStatus() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return map[string]interface{}{
+		"name":        s.name,
+		"project":     s.project,
+		"path":        s.cfg.Repository.Path,
 		"enabled":     s.cfg.Enabled,
 		"repository":  s.cfg.Repository.URL,
 		"branch":      s.cfg.Repository.Branch,
@@ -308,5 +485,7 @@ func (s *SyncService) GetStatus() map[string]interface{} {
 		"last_commit": s.lastHash,
 		"auto_apply":  s.cfg.Sync.AutoApply,
 		"dry_run":     s.cfg.Sync.DryRun,
+		"last_error":  s.lastError,
+		"files":       s.fileResults,
 	}
 }
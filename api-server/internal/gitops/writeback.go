@@ -0,0 +1,228 @@
+package gitops
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// statusReporter posts sync results to the config repository as commit statuses
+type statusReporter struct {
+	httpClient *http.Client
+}
+
+func newStatusReporter() statusReporter {
+	return statusReporter{httpClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// reportStatus publishes the outcome of applying sha. Failures are logged, never
+// returned, so an unreachable API cannot fail a sync.
+func (s *SyncService) reportStatus(sha string, applyErr error) {
+	wb := s.cfg.Writeback
+	if wb.Provider == "" || s.cfg.Sync.DryRun {
+		return
+	}
+
+	state, description := s.statusSummary(applyErr)
+
+	// Failed applies are retried every interval; only report when something
+	// changed. A post that failed is retried with the next sync.
+	if s.reportedHash == sha && s.reportedState == state {
+		return
+	}
+
+	var err error
+	switch wb.Provider {
+	case "github":
+		err = s.postGitHubStatus(sha, state, description)
+	case "gitlab":
+		err = s.postGitLabStatus(sha, state, description)
+	default:
+		err = fmt.Errorf("unsupported write-back provider %q", wb.Provider)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("source", s.name).Str("commit", sha).Msg("Failed to report GitOps sync status")
+		return
+	}
+
+	s.reportedHash = sha
+	s.reportedState = state
+}
+
+// statusSummary condenses the recorded file results into a short description
+func (s *SyncService) statusSummary(applyErr error) (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[string]int{}
+	firstFailure := ""
+	for _, r := range s.fileResults {
+		counts[r.Action]++
+		if r.Action == "failed" && firstFailure == "" {
+			firstFailure = fmt.Sprintf("%s: %s", r.File, r.Error)
+		}
+	}
+
+	if applyErr != nil {
+		description := applyErr.Error()
+		if firstFailure != "" {
+			description = fmt.Sprintf("%d file(s) failed; %s", counts["failed"], firstFailure)
+		}
+		return "failure", truncateDescription(description)
+	}
+
+	return "success", truncateDescription(fmt.Sprintf("Applied: %d created, %d updated, %d pruned",
+		counts["created"], counts["updated"], counts["pruned"]))
+}
+
+func (s *SyncService) postGitHubStatus(sha, state, description string) error {
+	slug, err := repoSlug(s.cfg.Repository.URL)
+	if err != nil {
+		return err
+	}
+
+	token := s.cfg.Writeback.Token
+	if token == "" {
+		if token, err = s.repositoryToken(); err != nil {
+			return err
+		}
+	}
+
+	apiURL := s.cfg.Writeback.APIURL
+	if apiURL == "" {
+		apiURL = "https://api.github.com"
+	}
+
+	payload := map[string]string{
+		"state":       state,
+		"description": description,
+		"context":     s.statusContext(),
+	}
+	if s.cfg.Writeback.TargetURL != "" {
+		payload["target_url"] = s.cfg.Writeback.TargetURL
+	}
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost,
+		fmt.Sprintf("%s/repos/%s/statuses/%s", strings.TrimSuffix(apiURL, "/"), slug, sha),
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	return s.doStatusRequest(req)
+}
+
+func (s *SyncService) postGitLabStatus(sha, state, description string) error {
+	slug, err := repoSlug(s.cfg.Repository.URL)
+	if err != nil {
+		return err
+	}
+
+	token := s.cfg.Writeback.Token
+	if token == "" {
+		if token, err = s.repositoryToken(); err != nil {
+			return err
+		}
+	}
+
+	apiURL := s.cfg.Writeback.APIURL
+	if apiURL == "" {
+		apiURL = "https://gitlab.com/api/v4"
+	}
+
+	if state == "failure" {
+		state = "failed"
+	}
+	params := url.Values{}
+	params.Set("state", state)
+	params.Set("name", s.statusContext())
+	params.Set("description", description)
+	if s.cfg.Writeback.TargetURL != "" {
+		params.Set("target_url", s.cfg.Writeback.TargetURL)
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost,
+		fmt.Sprintf("%s/projects/%s/statuses/%s?%s",
+			strings.TrimSuffix(apiURL, "/"), url.PathEscape(slug), sha, params.Encode()),
+		nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", token)
+
+	return s.doStatusRequest(req)
+}
+
+func (s *SyncService) doStatusRequest(req *http.Request) error {
+	resp, err := s.reporter.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("commit status API returned %s", resp.Status)
+	}
+	return nil
+}
+
+// repositoryToken returns the token used for cloning, if the source authenticates with one
+func (s *SyncService) repositoryToken() (string, error) {
+	switch s.cfg.Authentication.Type {
+	case "token":
+		if s.cfg.Authentication.Token != "" {
+			return s.cfg.Authentication.Token, nil
+		}
+	case "github_app":
+		return s.githubApp.Token(s.ctx)
+	}
+	return "", fmt.Errorf("write-back requires gitops.writeback.token when the repository does not use token or github_app auth")
+}
+
+// statusContext names the commit status, distinguishing additional sources
+func (s *SyncService) statusContext() string {
+	ctx := s.cfg.Writeback.Context
+	if s.name != defaultSourceName {
+		ctx += "/" + s.name
+	}
+	return ctx
+}
+
+// repoSlug returns the owner/repo path of an HTTPS, ssh:// or scp-style Git URL
+func repoSlug(repoURL string) (string, error) {
+	path := ""
+	if strings.Contains(repoURL, "://") {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return "", err
+		}
+		path = u.Path
+	} else if i := strings.Index(repoURL, ":"); i >= 0 {
+		path = repoURL[i+1:] // git@host:owner/repo.git
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if !strings.Contains(path, "/") {
+		return "", fmt.Errorf("cannot determine repository path from %q", repoURL)
+	}
+	return path, nil
+}
+
+func truncateDescription(s string) string {
+	// GitHub rejects descriptions longer than 140 characters
+	runes := []rune(s)
+	if len(runes) <= 140 {
+		return s
+	}
+	return string(runes[:137]) + "..."
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+)
+
+// AdminHandler lets administrators stop builds from being dispatched and put
+// the instance in maintenance mode, e.g. for an upgrade
+type AdminHandler struct {
+	db       *database.Database
+	settings *settings.Store
+	auth     *auth.Authenticator
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(db *database.Database, settings *settings.Store, authenticator *auth.Authenticator) *AdminHandler {
+	return &AdminHandler{db: db, settings: settings, auth: authenticator}
+}
+
+// pausedQueue is a job whose queue is paused
+type pausedQueue struct {
+	JobID        uuid.UUID `json:"job_id"`
+	JobName      string    `json:"job_name"`
+	PausedAt     time.Time `json:"paused_at"`
+	PausedBy     string    `json:"paused_by,omitempty"`
+	QueuedBuilds int       `json:"queued_builds"`
+}
+
+// GetStatus returns whether the scheduler is paused and the instance in
+// maintenance mode, the paused job queues, and how many builds are queued
+// and running
+func (h *AdminHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeAdmin(h.auth, w, r); !ok {
+		return
+	}
+	ctx := r.Context()
+
+	var queued, running int
+	err := h.db.GetConn().QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE status = 'queued'),
+		       COUNT(*) FILTER (WHERE status = 'running')
+		FROM builds
+		WHERE status IN ('queued', 'running')
+	`).Scan(&queued, &running)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count queued and running builds")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch status")
+		return
+	}
+
+	rows, err := h.db.GetConn().QueryContext(ctx, `
+		SELECT j.id, j.name, j.queue_paused_at, COALESCE(j.queue_paused_by, ''),
+		       COUNT(b.id)
+		FROM jobs j
+		LEFT JOIN builds b ON b.job_id = j.id AND b.status = 'queued'
+		WHERE j.queue_paused_at IS NOT NULL
+		GROUP BY j.id
+		ORDER BY j.queue_paused_at ASC
+	`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query paused job queues")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch status")
+		return
+	}
+	defer rows.Close()
+
+	paused := []pausedQueue{}
+	for rows.Next() {
+		var q pausedQueue
+		if err := rows.Scan(&q.JobID, &q.JobName, &q.PausedAt, &q.PausedBy, &q.QueuedBuilds); err != nil {
+			log.Error().Err(err).Msg("Failed to scan paused job queue")
+			continue
+		}
+		paused = append(paused, q)
+	}
+
+	current := h.settings.Current()
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"scheduler_paused":    current.SchedulerPaused,
+		"maintenance_mode":    current.MaintenanceMode,
+		"maintenance_message": current.MaintenanceMessage,
+		"paused_queues":       paused,
+		"queued_builds":       queued,
+		"running_builds":      running,
+	})
+}
+
+// PauseScheduler stops queued builds from being dispatched. Builds keep
+// queueing, and those running are not affected.
+func (h *AdminHandler) PauseScheduler(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulerPaused(w, r, true)
+}
+
+// ResumeScheduler dispatches queued builds again
+func (h *AdminHandler) ResumeScheduler(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulerPaused(w, r, false)
+}
+
+func (h *AdminHandler) setSchedulerPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	value, _ := json.Marshal(paused)
+	if _, err := h.settings.Update(r.Context(), map[string]json.RawMessage{"scheduler_paused": value}, principal.Username); err != nil {
+		log.Error().Err(err).Msg("Failed to update scheduler state")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update scheduler state")
+		return
+	}
+
+	log.Info().Bool("paused", paused).Str("by", principal.Username).Msg("Scheduler pause changed")
+	SendJSON(w, http.StatusOK, map[string]bool{"scheduler_paused": paused})
+}
+
+// PauseJobQueue stops the queued builds of a job from being dispatched. The
+// job's builds keep queueing.
+func (h *AdminHandler) PauseJobQueue(w http.ResponseWriter, r *http.Request) {
+	h.setJobQueuePaused(w, r, true)
+}
+
+// ResumeJobQueue dispatches the queued builds of a job again
+func (h *AdminHandler) ResumeJobQueue(w http.ResponseWriter, r *http.Request) {
+	h.setJobQueuePaused(w, r, false)
+}
+
+func (h *AdminHandler) setJobQueuePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	jobID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	query := `
+		UPDATE jobs
+		SET queue_paused_at = NULL, queue_paused_by = NULL
+		WHERE id = $1
+	`
+	args := []interface{}{jobID}
+	if paused {
+		// Pausing a paused queue keeps when and by whom it was first paused
+		query = `
+			UPDATE jobs
+			SET queue_paused_at = COALESCE(queue_paused_at, CURRENT_TIMESTAMP),
+			    queue_paused_by = COALESCE(queue_paused_by, NULLIF($2, ''))
+			WHERE id = $1
+		`
+		args = append(args, principal.Username)
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update job queue state")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update job queue state")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+
+	log.Info().Str("job_id", jobID.String()).Bool("paused", paused).Str("by", principal.Username).Msg("Job queue pause changed")
+	SendJSON(w, http.StatusOK, map[string]interface{}{"job_id": jobID, "queue_paused": paused})
+}
+
+// SetMaintenanceMode turns maintenance mode on or off. The body is
+// {"enabled": bool, "message": string}; the message is returned to clients
+// whose writes are rejected.
+func (h *AdminHandler) SetMaintenanceMode(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.Enabled == nil {
+		SendError(w, http.StatusBadRequest, nil, "enabled is required")
+		return
+	}
+
+	enabled, _ := json.Marshal(*req.Enabled)
+	message, _ := json.Marshal(req.Message)
+	updated, err := h.settings.Update(r.Context(), map[string]json.RawMessage{
+		"maintenance_mode":    enabled,
+		"maintenance_message": message,
+	}, principal.Username)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update maintenance mode")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update maintenance mode")
+		return
+	}
+
+	log.Info().Bool("enabled", updated.MaintenanceMode).Str("by", principal.Username).Msg("Maintenance mode changed")
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"maintenance_mode":    updated.MaintenanceMode,
+		"maintenance_message": updated.MaintenanceMessage,
+	})
+}
+
+// duringMaintenance is the handler of a route that keeps accepting writes in
+// maintenance mode
+type duringMaintenance struct {
+	http.HandlerFunc
+}
+
+// DuringMaintenance marks the handler of a route that keeps accepting writes
+// in maintenance mode: those administrators use to leave it, and those agents
+// report the builds they are running through, so that these can finish.
+// Routes are marked as they are registered.
+func DuringMaintenance(h http.HandlerFunc) http.Handler {
+	return duringMaintenance{h}
+}
+
+// maintenanceRetryAfter is the Retry-After of writes rejected in maintenance
+// mode, in seconds
+const maintenanceRetryAfter = "300"
+
+// MaintenanceMiddleware rejects writes with 503 while the instance settings
+// have the instance in maintenance mode. Reads, the admin API and the
+// routes marked with DuringMaintenance are served as usual.
+func MaintenanceMiddleware(s *settings.Store) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			current := s.Current()
+			if !current.MaintenanceMode || isReadMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if route := mux.CurrentRoute(r); route != nil {
+				_, exempt := route.GetHandler().(duringMaintenance)
+				template, _ := route.GetPathTemplate()
+				if exempt || strings.HasPrefix(template, "/api/v1/admin/") {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			message := current.MaintenanceMessage
+			if message == "" {
+				message = "The server is in maintenance mode"
+			}
+			w.Header().Set("Retry-After", maintenanceRetryAfter)
+			SendError(w, http.StatusServiceUnavailable, errors.New("maintenance mode"), message)
+		})
+	}
+}
+
+// isReadMethod reports whether requests of a method do not change anything
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
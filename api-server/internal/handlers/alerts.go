@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/alerts"
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+)
+
+// AlertHandler lists the operational alerts to administrators and hands the
+// pending notifications of alerts to workers to deliver
+type AlertHandler struct {
+	db       *database.Database
+	settings *settings.Store
+	auth     *auth.Authenticator
+}
+
+// NewAlertHandler creates a new alert handler, which hands out the alert
+// notifications of the instance settings
+func NewAlertHandler(db *database.Database, settings *settings.Store, authenticator *auth.Authenticator) *AlertHandler {
+	return &AlertHandler{db: db, settings: settings, auth: authenticator}
+}
+
+// ListAlerts returns the alerts that fired, newest first. ?status=open or
+// ?status=resolved returns only those; limit is 1–500 (default 100).
+func (h *AlertHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeAdmin(h.auth, w, r); !ok {
+		return
+	}
+	q := r.URL.Query()
+
+	var open *bool
+	switch q.Get("status") {
+	case "":
+	case "open", "resolved":
+		isOpen := q.Get("status") == "open"
+		open = &isOpen
+	default:
+		SendError(w, http.StatusBadRequest, nil, "Invalid status (want open or resolved)")
+		return
+	}
+	limit := 100
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 500 {
+			SendError(w, http.StatusBadRequest, err, "Invalid limit (want 1-500)")
+			return
+		}
+		limit = n
+	}
+
+	list, err := alerts.List(r.Context(), h.db, open, limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query alerts")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch alerts")
+		return
+	}
+	SendJSON(w, http.StatusOK, list)
+}
+
+// ClaimAlerts hands a worker the alerts whose notifications are pending,
+// with the notification steps to run for them. A worker that does not
+// report them delivered within 5 minutes loses them to the next worker that
+// asks. Only the worker, with its credential, may claim: the notification
+// steps carry webhook URLs and tokens.
+func (h *AlertHandler) ClaimAlerts(w http.ResponseWriter, r *http.Request) {
+	workerID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid worker ID")
+		return
+	}
+	if !authorizeWorker(r.Context(), h.db, w, r, workerID) {
+		return
+	}
+
+	notifications := h.settings.Current().AlertNotifications
+	if len(notifications) == 0 {
+		SendJSON(w, http.StatusOK, map[string]interface{}{"alerts": []interface{}{}, "notifications": notifications})
+		return
+	}
+	claimed, err := alerts.Claim(r.Context(), h.db, workerID)
+	if err != nil {
+		log.Error().Err(err).Str("worker_id", workerID.String()).Msg("Failed to claim alerts")
+		SendError(w, http.StatusInternalServerError, err, "Failed to claim alerts")
+		return
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{"alerts": claimed, "notifications": notifications})
+}
+
+// AlertDelivered records that a worker delivered the notifications of an
+// alert's event, given as {"event": "fired"} or {"event": "resolved"}
+func (h *AlertHandler) AlertDelivered(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workerID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid worker ID")
+		return
+	}
+	alertID, err := uuid.Parse(vars["alert_id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid alert ID")
+		return
+	}
+	if !authorizeWorker(r.Context(), h.db, w, r, workerID) {
+		return
+	}
+
+	var req struct {
+		Event string `json:"event"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.Event != alerts.EventFired && req.Event != alerts.EventResolved {
+		SendError(w, http.StatusBadRequest, nil, "Invalid event (want fired or resolved)")
+		return
+	}
+
+	delivered, err := alerts.Delivered(r.Context(), h.db, alertID, workerID, req.Event)
+	if err != nil {
+		log.Error().Err(err).Str("alert_id", alertID.String()).Msg("Failed to record alert delivery")
+		SendError(w, http.StatusInternalServerError, err, "Failed to record alert delivery")
+		return
+	}
+	// An alert claimed by another worker or resolved since is not an error:
+	// the worker has nothing to retry
+	SendJSON(w, http.StatusOK, map[string]interface{}{"alert_id": alertID, "event": req.Event, "recorded": delivered})
+}
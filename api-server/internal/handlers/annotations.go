@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+)
+
+// maxAnnotationsBytes limits the size of one AppendBuildAnnotations request
+const maxAnnotationsBytes = 8 << 20
+
+// annotationSeverities are the valid annotation severities, from most to
+// least severe
+var annotationSeverities = []string{
+	models.AnnotationError, models.AnnotationWarning, models.AnnotationNotice,
+}
+
+func validAnnotationSeverity(severity string) bool {
+	for _, s := range annotationSeverities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendBuildAnnotations stores annotations reported during a build, as
+// {"step": "...", "annotations": [...]} as sent by the worker agent
+func (h *BuildHandler) AppendBuildAnnotations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		Step        string              `json:"step"`
+		Annotations []models.Annotation `json:"annotations"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationsBytes)).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	for i := range req.Annotations {
+		a := &req.Annotations[i]
+		switch {
+		case a.Message == "" || !validAnnotationSeverity(a.Severity):
+			SendError(w, http.StatusBadRequest, nil, "Annotations need a message and a severity of error, warning or notice")
+			return
+		case a.Path != "" && !pipeline.ValidFile(a.Path):
+			SendError(w, http.StatusBadRequest, nil, "Annotation paths must be relative to the repository root: "+a.Path)
+			return
+		case a.StartLine < 0 || a.EndLine < 0 || (a.EndLine != 0 && a.EndLine < a.StartLine):
+			SendError(w, http.StatusBadRequest, nil, "Annotation lines must be positive, and end_line at least start_line")
+			return
+		case a.Path == "" && a.StartLine != 0:
+			SendError(w, http.StatusBadRequest, nil, "Annotations at a line need a path")
+			return
+		}
+		if a.EndLine == 0 {
+			a.EndLine = a.StartLine
+		}
+		a.Step = req.Step
+	}
+
+	var exists bool
+	err = h.db.GetConn().QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM builds WHERE id = $1)`, buildID).Scan(&exists)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to look up build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store annotations")
+		return
+	}
+	if !exists {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	if err := h.storeAnnotations(ctx, buildID, req.Annotations); err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to store annotations")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store annotations")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{"stored": len(req.Annotations)})
+}
+
+// storeAnnotations stores annotations of a build in one transaction
+func (h *BuildHandler) storeAnnotations(ctx context.Context, buildID uuid.UUID, annotations []models.Annotation) error {
+	query := `
+		INSERT INTO build_annotations (build_id, step, path, start_line, end_line, severity, title, message)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	return h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		for _, a := range annotations {
+			_, err := tx.ExecContext(ctx, query, buildID, a.Step, a.Path, a.StartLine, a.EndLine,
+				a.Severity, a.Title, a.Message)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListBuildAnnotations lists a build's annotations by file and line, with the
+// number of each severity. The severity query parameter filters by a
+// comma-separated list of severities, step by the plugin that reported them
+// and path by file.
+func (h *BuildHandler) ListBuildAnnotations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var severities []string
+	if s := r.URL.Query().Get("severity"); s != "" {
+		for _, severity := range strings.Split(s, ",") {
+			severity = strings.ToLower(strings.TrimSpace(severity))
+			if !validAnnotationSeverity(severity) {
+				SendError(w, http.StatusBadRequest, nil, "Invalid severity (want error, warning or notice)")
+				return
+			}
+			severities = append(severities, severity)
+		}
+	}
+
+	query := `
+		SELECT id, build_id, step, path, start_line, end_line, severity, title, message, created_at
+		FROM build_annotations
+		WHERE build_id = $1
+	`
+	args := []interface{}{buildID}
+	argCount := 2
+
+	if step := r.URL.Query().Get("step"); step != "" {
+		query += ` AND step = $` + strconv.Itoa(argCount)
+		args = append(args, step)
+		argCount++
+	}
+	if path := r.URL.Query().Get("path"); path != "" {
+		query += ` AND path = $` + strconv.Itoa(argCount)
+		args = append(args, path)
+		argCount++
+	}
+	countQuery := `SELECT severity, COUNT(*) FROM (` + query + `) t GROUP BY severity`
+	countArgs := append([]interface{}{}, args...)
+
+	if severities != nil {
+		query += ` AND severity = ANY($` + strconv.Itoa(argCount) + `)`
+		args = append(args, pq.Array(severities))
+	}
+	query += ` ORDER BY path, start_line, CASE severity
+		WHEN 'error' THEN 0 WHEN 'warning' THEN 1 ELSE 2
+		END, created_at`
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query annotations")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch annotations")
+		return
+	}
+	defer rows.Close()
+
+	list := []models.Annotation{}
+	for rows.Next() {
+		var a models.Annotation
+		err := rows.Scan(&a.ID, &a.BuildID, &a.Step, &a.Path, &a.StartLine, &a.EndLine, &a.Severity,
+			&a.Title, &a.Message, &a.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan annotation row")
+			continue
+		}
+		list = append(list, a)
+	}
+
+	counts := make(map[string]int, len(annotationSeverities))
+	for _, severity := range annotationSeverities {
+		counts[severity] = 0
+	}
+	countRows, err := h.db.GetConn().QueryContext(ctx, countQuery, countArgs...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count annotations")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch annotations")
+		return
+	}
+	defer countRows.Close()
+	for countRows.Next() {
+		var severity string
+		var n int
+		if err := countRows.Scan(&severity, &n); err != nil {
+			log.Error().Err(err).Msg("Failed to scan annotation count")
+			continue
+		}
+		counts[severity] = n
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"annotations": list,
+		"counts":      counts,
+	})
+}
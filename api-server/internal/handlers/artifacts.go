@@ -0,0 +1,724 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/artifacts"
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/storage"
+)
+
+const (
+	// maxArtifactUploadBytes limits the size of an uploaded artifact
+	maxArtifactUploadBytes = 4 << 30
+	// defaultPreviewBytes and maxPreviewBytes bound the text returned by
+	// artifact previews
+	defaultPreviewBytes = 64 << 10
+	maxPreviewBytes     = 1 << 20
+)
+
+// errArtifactNotFound is returned for an artifact that is not one of the build's
+var errArtifactNotFound = errors.New("artifact not found")
+
+// errArtifactsImmutable is returned when uploading to a build that is no
+// longer running, or is pinned
+var errArtifactsImmutable = errors.New("the build's artifacts are immutable")
+
+// ArtifactHandler stores build artifacts and serves them to the artifact
+// browser: as a directory tree, as text previews and as downloads verified
+// against their checksums
+type ArtifactHandler struct {
+	db      *database.Database
+	storage storage.Storage
+	auth    *auth.Authenticator
+}
+
+// NewArtifactHandler creates a new artifact handler; administrators
+// authenticated by authenticator quarantine and release artifacts
+func NewArtifactHandler(db *database.Database, store storage.Storage, authenticator *auth.Authenticator) *ArtifactHandler {
+	return &ArtifactHandler{db: db, storage: store, auth: authenticator}
+}
+
+// artifactNode is a directory or file of a build's artifact tree
+type artifactNode struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"` // directory or file
+	// Of the file, or of all files below the directory
+	SizeBytes   int64            `json:"size_bytes"`
+	ContentType string           `json:"content_type,omitempty"`
+	Artifact    *models.Artifact `json:"artifact,omitempty"`
+	Children    []*artifactNode  `json:"children,omitempty"`
+}
+
+// UploadArtifact stores the request body as the artifact at a path of the
+// build, replacing the artifact previously uploaded there. The content type
+// is taken from the Content-Type header, or guessed from the path and the
+// content. With an X-Checksum-Sha256 header, a body that does not match it is
+// rejected (422).
+//
+// Only the worker running the build may upload, with its credential as the
+// bearer token, and only while the build runs: the artifacts of finished and
+// pinned builds are immutable (409), so that what the integrity audit checks
+// is what the build produced.
+func (h *ArtifactHandler) UploadArtifact(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+	artifactPath, ok := cleanArtifactPath(vars["path"])
+	if !ok {
+		SendError(w, http.StatusBadRequest, nil, "Invalid artifact path")
+		return
+	}
+
+	var status string
+	var workerID uuid.NullUUID
+	var pinned bool
+	err = h.db.GetConn().QueryRowContext(ctx, `
+		SELECT status, worker_id, pinned FROM builds WHERE id = $1
+	`, buildID).Scan(&status, &workerID, &pinned)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch build")
+		return
+	}
+	if status != "running" || pinned || !workerID.Valid {
+		SendError(w, http.StatusConflict, errArtifactsImmutable, "Artifacts can only be uploaded while the build is running")
+		return
+	}
+	if !authorizeWorker(ctx, h.db, w, r, workerID.UUID) {
+		return
+	}
+	extendDeadlines(w)
+
+	// Spool to disk so the checksum, size and content type are known before
+	// uploading
+	r.Body = http.MaxBytesReader(w, r.Body, maxArtifactUploadBytes)
+	spool, err := os.CreateTemp("", "solvyd-artifact-*")
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store artifact")
+		return
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), r.Body)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Failed to read artifact")
+		return
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expected := r.Header.Get("X-Checksum-Sha256"); expected != "" && !strings.EqualFold(expected, checksum) {
+		SendError(w, http.StatusUnprocessableEntity, artifacts.ErrChecksumMismatch, "Artifact does not match X-Checksum-Sha256 (got "+checksum+")")
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = guessContentType(artifactPath, spool)
+	}
+
+	artifact := models.Artifact{
+		ID:             uuid.New(),
+		BuildID:        buildID,
+		Name:           path.Base(artifactPath),
+		Path:           artifactPath,
+		SizeBytes:      size,
+		ChecksumSHA256: checksum,
+		ContentType:    contentType,
+		Status:         models.ArtifactStatusAvailable,
+		StoragePlugin:  artifacts.StoragePlugin,
+	}
+	// Each upload has a key of its own, so one that is rejected below never
+	// overwrites the content of an artifact already recorded
+	artifact.StorageURL = fmt.Sprintf("artifacts/%s/%s/%s", buildID, artifact.ID, artifactPath)
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store artifact")
+		return
+	}
+	if err := h.storage.Put(ctx, artifact.StorageURL, spool, size); err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Str("path", artifactPath).Msg("Failed to upload artifact")
+		SendError(w, http.StatusBadGateway, err, "Failed to store artifact")
+		return
+	}
+
+	var replaced []string
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		// The build may have finished during the upload
+		var writable bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT status = 'running' AND NOT pinned AND worker_id = $2 FROM builds WHERE id = $1 FOR SHARE
+		`, buildID, workerID.UUID).Scan(&writable)
+		if err != nil {
+			return err
+		}
+		if !writable {
+			return errArtifactsImmutable
+		}
+		rows, err := tx.QueryContext(ctx, `DELETE FROM artifacts WHERE build_id = $1 AND path = $2 RETURNING storage_url`, buildID, artifactPath)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return err
+			}
+			replaced = append(replaced, key)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO artifacts (id, build_id, name, path, size_bytes, checksum_sha256,
+			                       content_type, storage_plugin, storage_url, verified_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
+			RETURNING promotion_status, created_at, verified_at
+		`, artifact.ID, artifact.BuildID, artifact.Name, artifact.Path, artifact.SizeBytes,
+			artifact.ChecksumSHA256, artifact.ContentType, artifact.StoragePlugin, artifact.StorageURL,
+		).Scan(&artifact.PromotionStatus, &artifact.CreatedAt, &artifact.VerifiedAt)
+	})
+	if err != nil {
+		if derr := h.storage.Delete(context.Background(), artifact.StorageURL); derr != nil {
+			log.Warn().Err(derr).Str("key", artifact.StorageURL).Msg("Failed to delete unrecorded artifact")
+		}
+	}
+	if err == errArtifactsImmutable {
+		SendError(w, http.StatusConflict, err, "Artifacts can only be uploaded while the build is running")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Str("path", artifactPath).Msg("Failed to record artifact")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store artifact")
+		return
+	}
+	for _, key := range replaced {
+		if err := h.storage.Delete(ctx, key); err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("Failed to delete replaced artifact")
+		}
+	}
+
+	log.Info().
+		Str("build_id", buildID.String()).
+		Str("path", artifactPath).
+		Int64("size_bytes", size).
+		Msg("Artifact uploaded")
+	SendJSON(w, http.StatusCreated, artifact)
+}
+
+// GetArtifactTree returns a build's artifacts as a directory tree, or with
+// path the subtree of one of its directories
+func (h *ArtifactHandler) GetArtifactTree(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	rows, err := h.db.GetConn().QueryContext(ctx, `
+		SELECT `+artifactColumns+`
+		FROM artifacts
+		WHERE build_id = $1
+		ORDER BY path ASC
+	`, buildID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query artifacts")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch artifacts")
+		return
+	}
+	defer rows.Close()
+
+	root := &artifactNode{Name: "", Path: "", Type: "directory", Children: []*artifactNode{}}
+	for rows.Next() {
+		artifact, err := scanArtifact(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan artifact row")
+			continue
+		}
+		root.add(artifact)
+	}
+	if err := rows.Err(); err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch artifacts")
+		return
+	}
+	root.sort()
+
+	if dir := r.URL.Query().Get("path"); dir != "" {
+		subtree := root.find(strings.Trim(dir, "/"))
+		if subtree == nil || subtree.Type != "directory" {
+			SendError(w, http.StatusNotFound, nil, "Directory not found")
+			return
+		}
+		root = subtree
+	}
+
+	SendJSON(w, http.StatusOK, root)
+}
+
+// GetArtifactPreview returns the beginning of a text artifact, up to
+// max_bytes (default 64 KiB, at most 1 MiB). Binary artifacts are rejected
+// with 415.
+func (h *ArtifactHandler) GetArtifactPreview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit := defaultPreviewBytes
+	if s := r.URL.Query().Get("max_bytes"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPreviewBytes {
+			SendError(w, http.StatusBadRequest, err, fmt.Sprintf("max_bytes must be between 1 and %d", maxPreviewBytes))
+			return
+		}
+		limit = n
+	}
+
+	artifact, ok := h.readableArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := h.storage.Get(ctx, artifact.StorageURL)
+	if err != nil {
+		h.sendStorageError(w, r, artifact, err)
+		return
+	}
+	defer body.Close()
+
+	// One byte more tells whether the preview is truncated
+	data, err := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	if err != nil {
+		log.Error().Err(err).Str("artifact_id", artifact.ID.String()).Msg("Failed to read artifact")
+		SendError(w, http.StatusBadGateway, err, "Failed to read artifact")
+		return
+	}
+	truncated := len(data) > limit
+	if truncated {
+		data = trimPartialRune(data[:limit])
+	}
+
+	contentType := artifactContentType(artifact)
+	if !isText(contentType, data) {
+		SendError(w, http.StatusUnsupportedMediaType, nil, "Artifact is not a text file ("+contentType+")")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"artifact_id":  artifact.ID,
+		"path":         artifact.Path,
+		"content_type": contentType,
+		"size_bytes":   artifact.SizeBytes,
+		"content":      string(data),
+		"truncated":    truncated,
+	})
+}
+
+// DownloadArtifact returns the content of an artifact with its content type,
+// verifying it against the artifact's checksum as it is sent. Content that
+// does not match is flagged corrupted, and its response is aborted so that
+// the client does not take it for a complete download.
+func (h *ArtifactHandler) DownloadArtifact(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	artifact, ok := h.readableArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := h.storage.Get(ctx, artifact.StorageURL)
+	if err != nil {
+		h.sendStorageError(w, r, artifact, err)
+		return
+	}
+	defer body.Close()
+	extendDeadlines(w)
+
+	w.Header().Set("Content-Type", artifactContentType(artifact))
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": artifact.Name}))
+	// Served from the API's origin, so never rendered as anything else
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if artifact.ChecksumSHA256 != "" {
+		w.Header().Set("X-Checksum-Sha256", artifact.ChecksumSHA256)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	verifier := artifacts.NewVerifyingReader(body, artifact.ChecksumSHA256)
+	if _, err := io.Copy(w, verifier); err != nil {
+		log.Warn().Err(err).Str("artifact_id", artifact.ID.String()).Msg("Artifact download interrupted")
+		return
+	}
+
+	status, reason := models.ArtifactStatusAvailable, ""
+	verifyErr := verifier.Verify()
+	if verifyErr != nil {
+		status, reason = models.ArtifactStatusCorrupted, verifyErr.Error()
+		log.Error().Err(verifyErr).Str("artifact_id", artifact.ID.String()).Msg("Downloaded artifact failed its checksum")
+	}
+	if err := artifacts.SetVerified(context.WithoutCancel(ctx), h.db, artifact.ID, status, reason); err != nil {
+		log.Warn().Err(err).Str("artifact_id", artifact.ID.String()).Msg("Failed to record artifact verification")
+	}
+	if verifyErr != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// readableArtifact returns the artifact of the request, sending an error if
+// it does not exist, is held by a storage plugin rather than the server, or
+// is not available
+func (h *ArtifactHandler) readableArtifact(w http.ResponseWriter, r *http.Request) (*models.Artifact, bool) {
+	artifact, ok := h.requestArtifact(w, r)
+	if !ok {
+		return nil, false
+	}
+	if artifact.StoragePlugin != artifacts.StoragePlugin {
+		SendError(w, http.StatusConflict, nil, fmt.Sprintf("Artifact is held by storage plugin %q at %s", artifact.StoragePlugin, artifact.StorageURL))
+		return nil, false
+	}
+	if artifact.Status != models.ArtifactStatusAvailable {
+		message := "Artifact is " + string(artifact.Status)
+		if artifact.StatusReason != "" {
+			message += ": " + artifact.StatusReason
+		}
+		SendError(w, http.StatusConflict, nil, message)
+		return nil, false
+	}
+	return artifact, true
+}
+
+// requestArtifact returns the artifact of the request, sending an error if
+// it does not exist
+func (h *ArtifactHandler) requestArtifact(w http.ResponseWriter, r *http.Request) (*models.Artifact, bool) {
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return nil, false
+	}
+	artifactID, err := uuid.Parse(vars["artifact_id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid artifact ID")
+		return nil, false
+	}
+
+	artifact, err := getArtifact(r.Context(), h.db, buildID, artifactID)
+	if err == errArtifactNotFound {
+		SendError(w, http.StatusNotFound, nil, "Artifact not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query artifact")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch artifact")
+		return nil, false
+	}
+	return artifact, true
+}
+
+// sendStorageError responds to a failure to read an artifact from storage,
+// flagging the artifact missing if its content is gone
+func (h *ArtifactHandler) sendStorageError(w http.ResponseWriter, r *http.Request, artifact *models.Artifact, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		if err := artifacts.SetVerified(r.Context(), h.db, artifact.ID, models.ArtifactStatusMissing, "content not found in storage"); err != nil {
+			log.Warn().Err(err).Str("artifact_id", artifact.ID.String()).Msg("Failed to record missing artifact")
+		}
+		SendError(w, http.StatusNotFound, err, "Artifact content not found in storage")
+		return
+	}
+	log.Error().Err(err).Str("artifact_id", artifact.ID.String()).Msg("Failed to read artifact")
+	SendError(w, http.StatusBadGateway, err, "Failed to read artifact")
+}
+
+// QuarantineArtifact stops an artifact from being served, e.g. while it is
+// investigated, with an optional {"reason": string} body. The integrity
+// audit leaves quarantined artifacts alone.
+func (h *ArtifactHandler) QuarantineArtifact(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	artifact, ok := h.requestArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "quarantined by " + principal.Username
+	}
+
+	_, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE artifacts SET status = $2, status_reason = $3 WHERE id = $1
+	`, artifact.ID, models.ArtifactStatusQuarantined, reason)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to quarantine artifact")
+		SendError(w, http.StatusInternalServerError, err, "Failed to quarantine artifact")
+		return
+	}
+
+	log.Warn().Str("artifact_id", artifact.ID.String()).Str("by", principal.Username).Str("reason", reason).Msg("Artifact quarantined")
+	h.sendArtifact(w, r, artifact)
+}
+
+// ReleaseArtifact takes an artifact out of quarantine, or clears a corrupted
+// or missing flag once its content was restored. It is served again at once
+// and verified first by the next integrity audit.
+func (h *ArtifactHandler) ReleaseArtifact(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	artifact, ok := h.requestArtifact(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE artifacts SET status = $2, status_reason = NULL, verified_at = NULL WHERE id = $1
+	`, artifact.ID, models.ArtifactStatusAvailable)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to release artifact")
+		SendError(w, http.StatusInternalServerError, err, "Failed to release artifact")
+		return
+	}
+
+	log.Info().Str("artifact_id", artifact.ID.String()).Str("by", principal.Username).Msg("Artifact released")
+	h.sendArtifact(w, r, artifact)
+}
+
+// sendArtifact responds with an artifact as it is now
+func (h *ArtifactHandler) sendArtifact(w http.ResponseWriter, r *http.Request, artifact *models.Artifact) {
+	current, err := getArtifact(r.Context(), h.db, artifact.BuildID, artifact.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query artifact")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch artifact")
+		return
+	}
+	SendJSON(w, http.StatusOK, current)
+}
+
+// artifactColumns are the columns scanArtifact reads
+const artifactColumns = `
+	id, build_id, name, path, COALESCE(size_bytes, 0), COALESCE(checksum_sha256, ''),
+	COALESCE(content_type, ''), status, COALESCE(status_reason, ''), verified_at,
+	COALESCE(storage_plugin, ''), storage_url,
+	COALESCE(promotion_status, ''), promoted_at, COALESCE(promoted_by, ''), created_at`
+
+// rowScanner is a row of *sql.Rows or *sql.Row
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanArtifact(row rowScanner) (*models.Artifact, error) {
+	var a models.Artifact
+	err := row.Scan(
+		&a.ID, &a.BuildID, &a.Name, &a.Path, &a.SizeBytes, &a.ChecksumSHA256,
+		&a.ContentType, &a.Status, &a.StatusReason, &a.VerifiedAt,
+		&a.StoragePlugin, &a.StorageURL,
+		&a.PromotionStatus, &a.PromotedAt, &a.PromotedBy, &a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// getArtifact returns an artifact of a build
+func getArtifact(ctx context.Context, db *database.Database, buildID, artifactID uuid.UUID) (*models.Artifact, error) {
+	artifact, err := scanArtifact(db.GetConn().QueryRowContext(ctx, `
+		SELECT `+artifactColumns+`
+		FROM artifacts
+		WHERE id = $1 AND build_id = $2
+	`, artifactID, buildID))
+	if err == sql.ErrNoRows {
+		return nil, errArtifactNotFound
+	}
+	return artifact, err
+}
+
+// cleanArtifactPath returns the relative, slash-separated form of an
+// artifact path, and false for a path that leaves the build's artifacts
+func cleanArtifactPath(p string) (string, bool) {
+	p = path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+	p = strings.TrimPrefix(p, "/")
+	return p, p != "" && p != "." && len(p) <= 1024
+}
+
+// add places an artifact in the tree below n, creating its directories
+func (n *artifactNode) add(artifact *models.Artifact) {
+	p, ok := cleanArtifactPath(artifact.Path)
+	if !ok {
+		p = artifact.Name
+	}
+	parts := strings.Split(p, "/")
+
+	dir := n
+	for i, part := range parts[:len(parts)-1] {
+		dir.SizeBytes += artifact.SizeBytes
+		child := dir.child(part)
+		if child == nil {
+			child = &artifactNode{Name: part, Path: strings.Join(parts[:i+1], "/"), Type: "directory", Children: []*artifactNode{}}
+			dir.Children = append(dir.Children, child)
+		}
+		dir = child
+	}
+	dir.SizeBytes += artifact.SizeBytes
+
+	dir.Children = append(dir.Children, &artifactNode{
+		Name:        parts[len(parts)-1],
+		Path:        p,
+		Type:        "file",
+		SizeBytes:   artifact.SizeBytes,
+		ContentType: artifactContentType(artifact),
+		Artifact:    artifact,
+	})
+}
+
+// child returns the directory of n with a name, or nil
+func (n *artifactNode) child(name string) *artifactNode {
+	for _, c := range n.Children {
+		if c.Name == name && c.Type == "directory" {
+			return c
+		}
+	}
+	return nil
+}
+
+// find returns the node at a path below n, or nil
+func (n *artifactNode) find(p string) *artifactNode {
+	node := n
+	for _, part := range strings.Split(p, "/") {
+		var next *artifactNode
+		for _, c := range node.Children {
+			if c.Name == part {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// sort orders the tree below n with directories first, then by name
+func (n *artifactNode) sort() {
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if a.Type != b.Type {
+			return a.Type == "directory"
+		}
+		return a.Name < b.Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// artifactContentType returns the recorded content type of an artifact, or
+// one guessed from its name
+func artifactContentType(artifact *models.Artifact) string {
+	if artifact.ContentType != "" {
+		return artifact.ContentType
+	}
+	if t := mime.TypeByExtension(path.Ext(artifact.Name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// guessContentType guesses the content type of a file from its extension,
+// then from its first bytes
+func guessContentType(name string, f io.ReadSeeker) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "application/octet-stream"
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return http.DetectContentType(head[:n])
+}
+
+// textContentTypes are the content types outside text/* that are text
+var textContentTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/yaml":       true,
+	"application/x-yaml":     true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"application/toml":       true,
+}
+
+// isText reports whether content of a content type is text that can be
+// previewed
+func isText(contentType string, data []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "text/") || textContentTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return utf8.Valid(data)
+	}
+	// Files such as logs often have no known extension
+	return mediaType == "application/octet-stream" &&
+		strings.HasPrefix(http.DetectContentType(data), "text/plain") && utf8.Valid(data)
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence a cut left at the end
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
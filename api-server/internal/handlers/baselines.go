@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// GetFindingBaseline returns the findings a job accepts as existing backlog
+func (h *JobHandler) GetFindingBaseline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	query := `
+		SELECT id, job_id, build_id, fingerprint, tool, rule_id, title, severity, created_at
+		FROM finding_baselines
+		WHERE job_id = $1
+		ORDER BY tool, rule_id
+	`
+	rows, err := h.db.GetConn().QueryContext(ctx, query, jobID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query finding baseline")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch baseline")
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.FindingBaselineEntry{}
+	for rows.Next() {
+		var e models.FindingBaselineEntry
+		err := rows.Scan(&e.ID, &e.JobID, &e.BuildID, &e.Fingerprint, &e.Tool,
+			&e.RuleID, &e.Title, &e.Severity, &e.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan baseline row")
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	SendJSON(w, http.StatusOK, entries)
+}
+
+// SetFindingBaseline replaces a job's baseline with a snapshot of the
+// findings of one of its builds. Later builds report those findings as
+// "baseline" rather than "new".
+func (h *JobHandler) SetFindingBaseline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	var req struct {
+		BuildID uuid.UUID `json:"build_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	var buildJobID uuid.UUID
+	err = h.db.GetConn().QueryRowContext(ctx, `SELECT job_id FROM builds WHERE id = $1`, req.BuildID).Scan(&buildJobID)
+	if err == sql.ErrNoRows || (err == nil && buildJobID != jobID) {
+		SendError(w, http.StatusBadRequest, nil, "build_id must be a build of this job")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to look up build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to set baseline")
+		return
+	}
+
+	var stored int64
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM finding_baselines WHERE job_id = $1`, jobID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO finding_baselines (job_id, build_id, fingerprint, tool, rule_id, title, severity)
+			SELECT DISTINCT ON (fingerprint) $1::uuid, build_id, fingerprint, tool, rule_id, title, severity
+			FROM build_findings
+			WHERE build_id = $2
+			ORDER BY fingerprint
+		`, jobID, req.BuildID)
+		if err != nil {
+			return err
+		}
+		stored, _ = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to set finding baseline")
+		SendError(w, http.StatusInternalServerError, err, "Failed to set baseline")
+		return
+	}
+
+	log.Info().Str("job_id", jobID.String()).Str("build_id", req.BuildID.String()).Int64("findings", stored).Msg("Finding baseline set")
+	SendJSON(w, http.StatusOK, map[string]interface{}{"build_id": req.BuildID, "findings": stored})
+}
+
+// DeleteFindingBaseline clears a job's baseline, so every finding is new again
+func (h *JobHandler) DeleteFindingBaseline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	if _, err := h.db.GetConn().ExecContext(ctx, `DELETE FROM finding_baselines WHERE job_id = $1`, jobID); err != nil {
+		log.Error().Err(err).Msg("Failed to delete finding baseline")
+		SendError(w, http.StatusInternalServerError, err, "Failed to delete baseline")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListFindingSuppressions returns a job's finding suppressions. Expired
+// suppressions are left out unless include_expired is true.
+func (h *JobHandler) ListFindingSuppressions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	query := `
+		SELECT id, job_id, fingerprint, reason, expires_at, created_at, COALESCE(created_by, '')
+		FROM finding_suppressions
+		WHERE job_id = $1
+	`
+	if r.URL.Query().Get("include_expired") != "true" {
+		query += ` AND (expires_at IS NULL OR expires_at > NOW())`
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, jobID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query finding suppressions")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch suppressions")
+		return
+	}
+	defer rows.Close()
+
+	suppressions := []models.FindingSuppression{}
+	for rows.Next() {
+		var s models.FindingSuppression
+		if err := rows.Scan(&s.ID, &s.JobID, &s.Fingerprint, &s.Reason, &s.ExpiresAt, &s.CreatedAt, &s.CreatedBy); err != nil {
+			log.Error().Err(err).Msg("Failed to scan suppression row")
+			continue
+		}
+		suppressions = append(suppressions, s)
+	}
+
+	SendJSON(w, http.StatusOK, suppressions)
+}
+
+// CreateFindingSuppression suppresses a finding for a job's builds. The
+// finding is named by its fingerprint, or by the ID of one of its
+// occurrences in a build. A reason is required; without expires_at the
+// suppression never expires.
+func (h *JobHandler) CreateFindingSuppression(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	var req struct {
+		Fingerprint string     `json:"fingerprint"`
+		FindingID   *uuid.UUID `json:"finding_id"`
+		Reason      string     `json:"reason"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+		CreatedBy   string     `json:"created_by"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.Reason == "" {
+		SendError(w, http.StatusBadRequest, nil, "A reason is required")
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		SendError(w, http.StatusBadRequest, nil, "expires_at must be in the future")
+		return
+	}
+	if req.FindingID != nil {
+		err := h.db.GetConn().QueryRowContext(ctx, `
+			SELECT f.fingerprint FROM build_findings f JOIN builds b ON b.id = f.build_id
+			WHERE f.id = $1 AND b.job_id = $2
+		`, *req.FindingID, jobID).Scan(&req.Fingerprint)
+		if err == sql.ErrNoRows {
+			SendError(w, http.StatusBadRequest, nil, "finding_id must be a finding of this job")
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to look up finding")
+			SendError(w, http.StatusInternalServerError, err, "Failed to create suppression")
+			return
+		}
+	}
+	if req.Fingerprint == "" {
+		SendError(w, http.StatusBadRequest, nil, "A fingerprint or finding_id is required")
+		return
+	}
+
+	s := models.FindingSuppression{
+		ID:          uuid.New(),
+		JobID:       jobID,
+		Fingerprint: req.Fingerprint,
+		Reason:      req.Reason,
+		ExpiresAt:   req.ExpiresAt,
+		CreatedBy:   req.CreatedBy,
+	}
+	query := `
+		INSERT INTO finding_suppressions (id, job_id, fingerprint, reason, expires_at, created_by)
+		SELECT $1::uuid, $2::uuid, $3, $4, $5::timestamptz, NULLIF($6, '')
+		WHERE EXISTS (SELECT 1 FROM jobs WHERE id = $2)
+		RETURNING created_at
+	`
+	err = h.db.GetConn().QueryRowContext(ctx, query, s.ID, s.JobID, s.Fingerprint, s.Reason, s.ExpiresAt, s.CreatedBy).Scan(&s.CreatedAt)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create finding suppression")
+		SendError(w, http.StatusInternalServerError, err, "Failed to create suppression")
+		return
+	}
+
+	log.Info().Str("job_id", jobID.String()).Str("fingerprint", s.Fingerprint).Str("reason", s.Reason).Msg("Finding suppressed")
+	SendJSON(w, http.StatusCreated, s)
+}
+
+// DeleteFindingSuppression removes a suppression, so its finding counts again
+func (h *JobHandler) DeleteFindingSuppression(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+	suppressionID, err := uuid.Parse(vars["suppression_id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid suppression ID")
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(ctx,
+		`DELETE FROM finding_suppressions WHERE id = $1 AND job_id = $2`, suppressionID, jobID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete finding suppression")
+		SendError(w, http.StatusInternalServerError, err, "Failed to delete suppression")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		SendError(w, http.StatusNotFound, nil, "Suppression not found")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
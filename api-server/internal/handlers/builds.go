@@ -1,39 +1,101 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
 	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/interpolate"
+	"github.com/solvyd/solvyd/api-server/internal/metrics"
 	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/oidc"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+	"github.com/solvyd/solvyd/api-server/internal/scheduler"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
 )
 
 // BuildHandler handles build-related requests
 type BuildHandler struct {
-	db *database.Database
+	db        *database.Database
+	events    *WebSocketHandler
+	metrics   *metrics.Collector
+	creds     *credentials.Store
+	templates pipeline.Templates
+	identity  *oidc.Provider // nil unless builds are issued identity tokens
+	settings  *settings.Store
+	auth      *auth.Authenticator
 }
 
-// NewBuildHandler creates a new build handler
-func NewBuildHandler(db *database.Database) *BuildHandler {
-	return &BuildHandler{db: db}
+// NewBuildHandler creates a new build handler, which publishes build updates
+// to events, records the plugin steps of builds in m, resolves the secrets
+// job configuration references from creds and the templates pipelines
+// include from templates, hands builds out able to request identity tokens
+// from identity, if not nil, applies the instance settings and authenticates
+// the administrators that pin builds
+func NewBuildHandler(db *database.Database, events *WebSocketHandler, m *metrics.Collector, creds *credentials.Store, templates pipeline.Templates, identity *oidc.Provider, settings *settings.Store, authenticator *auth.Authenticator) *BuildHandler {
+	return &BuildHandler{db: db, events: events, metrics: m, creds: creds, templates: templates, identity: identity, settings: settings, auth: authenticator}
 }
 
-// ListBuilds returns all builds
+// buildSortColumns are the columns builds can be sorted by
+var buildSortColumns = map[string]string{
+	"queued_at":    "b.queued_at",
+	"started_at":   "b.started_at",
+	"completed_at": "b.completed_at",
+	"build_number": "b.build_number",
+	"duration":     "b.duration_seconds",
+}
+
+// commitPrefix matches an abbreviated or full commit SHA
+var commitPrefix = regexp.MustCompile(`^[0-9a-fA-F]{4,64}$`)
+
+// ListBuilds returns builds, newest first, filtered by job_id, status
+// (comma-separated), branch, author (name or email), commit (a SHA prefix),
+// triggered_by, and since and until (RFC 3339 times or dates, an until date
+// including the whole day) on the time they were queued. sort and order
+// choose another order, and limit how many are returned.
 func (h *BuildHandler) ListBuilds(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-
-	// Optional filters
-	jobID := r.URL.Query().Get("job_id")
-	status := r.URL.Query().Get("status")
-	limit := r.URL.Query().Get("limit")
-	if limit == "" {
-		limit = "50"
+	q := r.URL.Query()
+
+	limit := 50
+	if l := q.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > 1000 {
+			SendError(w, http.StatusBadRequest, err, "limit must be between 1 and 1000")
+			return
+		}
+	}
+	sortColumn, ok := buildSortColumns[q.Get("sort")]
+	if q.Get("sort") == "" {
+		sortColumn, ok = "b.queued_at", true
+	}
+	if !ok {
+		SendError(w, http.StatusBadRequest, nil, "Invalid sort (want queued_at, started_at, completed_at, build_number or duration)")
+		return
+	}
+	order := "DESC"
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		order = "ASC"
+	default:
+		SendError(w, http.StatusBadRequest, nil, "Invalid order (want asc or desc)")
+		return
 	}
 
 	query := `
@@ -41,7 +103,9 @@ func (h *BuildHandler) ListBuilds(w http.ResponseWriter, r *http.Request) {
 		       b.started_at, b.completed_at, b.duration_seconds, b.worker_id,
 		       b.scm_commit_sha, b.scm_commit_message, b.scm_author, b.branch,
 		       b.triggered_by, b.exit_code, b.error_message, b.artifact_count,
-		       j.name as job_name
+		       COALESCE(b.cancel_reason, ''), b.superseded_by, COALESCE(b.failure_reason, ''),
+		       b.rerun_of, b.pinned, b.tags, b.duration_regression, j.name as job_name,
+		       COALESCE(b.schedule_skip_reason, ''), b.schedule_skipped_at, j.queue_paused_at IS NOT NULL
 		FROM builds b
 		JOIN jobs j ON b.job_id = j.id
 		WHERE 1=1
@@ -49,20 +113,81 @@ func (h *BuildHandler) ListBuilds(w http.ResponseWriter, r *http.Request) {
 
 	args := []interface{}{}
 	argCount := 1
-
-	if jobID != "" {
-		query += ` AND b.job_id = $` + strconv.Itoa(argCount)
-		args = append(args, jobID)
+	where := func(cond string, arg interface{}) {
+		query += ` AND ` + strings.ReplaceAll(cond, "$?", "$"+strconv.Itoa(argCount))
+		args = append(args, arg)
 		argCount++
 	}
 
-	if status != "" {
-		query += ` AND b.status = $` + strconv.Itoa(argCount)
-		args = append(args, status)
-		argCount++
+	if v := q.Get("job_id"); v != "" {
+		jobID, err := uuid.Parse(v)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid job_id")
+			return
+		}
+		where(`b.job_id = $?`, jobID)
+	}
+	if v := q.Get("status"); v != "" {
+		where(`b.status = ANY($?)`, pq.Array(strings.Split(v, ",")))
+	}
+	if v := q.Get("branch"); v != "" {
+		where(`b.branch = $?`, v)
+	}
+	if v := q.Get("author"); v != "" {
+		where(`(LOWER(b.scm_author) = LOWER($?) OR LOWER(b.scm_author_email) = LOWER($?))`, v)
+	}
+	if v := q.Get("commit"); v != "" {
+		if !commitPrefix.MatchString(v) {
+			SendError(w, http.StatusBadRequest, nil, "Invalid commit (want at least 4 hex digits of a SHA)")
+			return
+		}
+		where(`LOWER(b.scm_commit_sha) LIKE $? || '%'`, strings.ToLower(v))
+	}
+	if v := q.Get("triggered_by"); v != "" {
+		where(`b.triggered_by = $?`, v)
+	}
+	if v := q.Get("tag"); v != "" {
+		where(`b.tags @> $?`, pq.Array(strings.Split(v, ",")))
+	}
+	if v := q.Get("pinned"); v != "" {
+		pinned, err := strconv.ParseBool(v)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid pinned (want true or false)")
+			return
+		}
+		where(`b.pinned = $?`, pinned)
+	}
+	if v := q.Get("duration_regression"); v != "" {
+		regressed, err := strconv.ParseBool(v)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid duration_regression (want true or false)")
+			return
+		}
+		where(`b.duration_regression = $?`, regressed)
+	}
+	if v := q.Get("since"); v != "" {
+		since, _, err := parseTimeOrDate(v)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid since (want an RFC 3339 time or a date)")
+			return
+		}
+		where(`b.queued_at >= $?`, since)
+	}
+	if v := q.Get("until"); v != "" {
+		until, isDate, err := parseTimeOrDate(v)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid until (want an RFC 3339 time or a date)")
+			return
+		}
+		if isDate {
+			where(`b.queued_at < $?`, until.AddDate(0, 0, 1))
+		} else {
+			where(`b.queued_at <= $?`, until)
+		}
 	}
 
-	query += ` ORDER BY b.queued_at DESC LIMIT $` + strconv.Itoa(argCount)
+	// Builds that have not started or completed sort last either way
+	query += ` ORDER BY ` + sortColumn + ` ` + order + ` NULLS LAST, b.queued_at DESC LIMIT $` + strconv.Itoa(argCount)
 	args = append(args, limit)
 
 	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
@@ -77,12 +202,15 @@ func (h *BuildHandler) ListBuilds(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var build models.Build
 		var jobName string
+		var queuePaused bool
 		err := rows.Scan(
 			&build.ID, &build.JobID, &build.BuildNumber, &build.Status,
 			&build.QueuedAt, &build.StartedAt, &build.CompletedAt, &build.Duration,
 			&build.WorkerID, &build.CommitSHA, &build.CommitMessage, &build.Author,
 			&build.Branch, &build.TriggeredBy, &build.ExitCode, &build.ErrorMessage,
-			&build.ArtifactCount, &jobName,
+			&build.ArtifactCount, &build.CancelReason, &build.SupersededBy, &build.FailureReason,
+			&build.RerunOf, &build.Pinned, pq.Array(&build.Tags), &build.DurationRegression, &jobName,
+			&build.SkipReason, &build.SkippedAt, &queuePaused,
 		)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to scan build row")
@@ -90,25 +218,50 @@ func (h *BuildHandler) ListBuilds(w http.ResponseWriter, r *http.Request) {
 		}
 
 		buildMap := map[string]interface{}{
-			"id":            build.ID,
-			"job_id":        build.JobID,
-			"job_name":      jobName,
-			"build_number":  build.BuildNumber,
-			"status":        build.Status,
-			"queued_at":     build.QueuedAt,
-			"started_at":    build.StartedAt,
-			"completed_at":  build.CompletedAt,
-			"duration":      build.Duration,
-			"worker_id":     build.WorkerID,
-			"commit_sha":    build.CommitSHA,
-			"commit_msg":    build.CommitMessage,
-			"author":        build.Author,
-			"branch":        build.Branch,
-			"triggered_by":  build.TriggeredBy,
-			"exit_code":     build.ExitCode,
-			"error_message": build.ErrorMessage,
-			"artifacts":     build.ArtifactCount,
+			"id":                  build.ID,
+			"job_id":              build.JobID,
+			"job_name":            jobName,
+			"build_number":        build.BuildNumber,
+			"status":              build.Status,
+			"queued_at":           build.QueuedAt,
+			"started_at":          build.StartedAt,
+			"completed_at":        build.CompletedAt,
+			"duration":            build.Duration,
+			"duration_regression": build.DurationRegression,
+			"worker_id":           build.WorkerID,
+			"commit_sha":          build.CommitSHA,
+			"commit_msg":          build.CommitMessage,
+			"author":              build.Author,
+			"branch":              build.Branch,
+			"triggered_by":        build.TriggeredBy,
+			"exit_code":           build.ExitCode,
+			"error_message":       build.ErrorMessage,
+			"artifacts":           build.ArtifactCount,
+			"pinned":              build.Pinned,
+			"tags":                build.Tags,
+		}
+		if build.CancelReason != "" {
+			buildMap["cancel_reason"] = build.CancelReason
+			buildMap["superseded_by"] = build.SupersededBy
 		}
+		if build.FailureReason != "" {
+			buildMap["failure_reason"] = build.FailureReason
+		}
+		if build.RerunOf != nil {
+			buildMap["rerun_of"] = build.RerunOf
+		}
+		if h.setSkipReason(&build, queuePaused) {
+			buildMap["skip_reason"] = build.SkipReason
+			buildMap["skipped_at"] = build.SkippedAt
+		}
+		// Coverage plugins compare against the last successful build
+		baseline, err := h.coverageBaseline(ctx, build.JobID, build.Branch)
+		if err != nil {
+			log.Warn().Err(err).Str("build_id", build.ID.String()).Msg("Failed to look up coverage baseline")
+		} else if baseline != nil {
+			buildMap["coverage_baseline"] = baseline
+		}
+
 		builds = append(builds, buildMap)
 	}
 
@@ -128,21 +281,32 @@ func (h *BuildHandler) GetBuild(w http.ResponseWriter, r *http.Request) {
 	query := `
 		SELECT id, job_id, build_number, status, queued_at, started_at, 
 		       completed_at, duration_seconds, worker_id, scm_commit_sha,
-		       scm_commit_message, scm_author, branch, parameters,
-		       environment_vars, triggered_by, trigger_metadata, exit_code,
-		       error_message, log_url, artifact_count
+		       scm_commit_message, scm_author, COALESCE(scm_author_email, ''),
+		       scm_committed_at, branch, changed_files, parameters, environment_vars, triggered_by, trigger_metadata, exit_code,
+		       error_message, log_url, artifact_count, COALESCE(cancel_reason, ''), superseded_by,
+		       COALESCE(failure_reason, ''), rerun_of, rerun_stages,
+		       pinned, COALESCE(pinned_by, ''), pinned_at, COALESCE(pin_reason, ''), tags,
+		       duration_baseline_seconds, duration_regression,
+		       COALESCE(schedule_skip_reason, ''), schedule_skipped_at,
+		       (SELECT queue_paused_at IS NOT NULL FROM jobs WHERE jobs.id = builds.job_id)
 		FROM builds
 		WHERE id = $1
 	`
 
 	var build models.Build
+	var queuePaused bool
 	err = h.db.GetConn().QueryRowContext(ctx, query, buildID).Scan(
 		&build.ID, &build.JobID, &build.BuildNumber, &build.Status,
 		&build.QueuedAt, &build.StartedAt, &build.CompletedAt, &build.Duration,
 		&build.WorkerID, &build.CommitSHA, &build.CommitMessage, &build.Author,
-		&build.Branch, &build.Parameters, &build.EnvVars, &build.TriggeredBy,
+		&build.AuthorEmail, &build.CommittedAt, &build.Branch, &build.ChangedFiles,
+		&build.Parameters, &build.EnvVars, &build.TriggeredBy,
 		&build.TriggerMetadata, &build.ExitCode, &build.ErrorMessage,
-		&build.LogURL, &build.ArtifactCount,
+		&build.LogURL, &build.ArtifactCount, &build.CancelReason, &build.SupersededBy,
+		&build.FailureReason, &build.RerunOf, &build.RerunStages,
+		&build.Pinned, &build.PinnedBy, &build.PinnedAt, &build.PinReason, pq.Array(&build.Tags),
+		&build.DurationBaseline, &build.DurationRegression,
+		&build.SkipReason, &build.SkippedAt, &queuePaused,
 	)
 
 	if err == sql.ErrNoRows {
@@ -154,10 +318,27 @@ func (h *BuildHandler) GetBuild(w http.ResponseWriter, r *http.Request) {
 		SendError(w, http.StatusInternalServerError, err, "Failed to fetch build")
 		return
 	}
+	h.setSkipReason(&build, queuePaused)
 
 	SendJSON(w, http.StatusOK, build)
 }
 
+// setSkipReason sets why a queued build has no worker yet, with when the
+// scheduler last found so if it recorded the reason, and clears them for
+// other builds. It reports whether the build is queued.
+func (h *BuildHandler) setSkipReason(build *models.Build, queuePaused bool) bool {
+	if build.Status != models.JobStatusQueued {
+		build.SkipReason, build.SkippedAt = "", nil
+		return false
+	}
+	reason, recorded := scheduler.SkipReason(build.SkipReason, h.settings.Current().SchedulerPaused, queuePaused)
+	build.SkipReason = reason
+	if !recorded {
+		build.SkippedAt = nil
+	}
+	return true
+}
+
 // CancelBuild cancels a running build
 func (h *BuildHandler) CancelBuild(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -170,66 +351,34 @@ func (h *BuildHandler) CancelBuild(w http.ResponseWriter, r *http.Request) {
 
 	query := `
 		UPDATE builds
-		SET status = 'cancelled', completed_at = CURRENT_TIMESTAMP
+		SET status = 'cancelled', completed_at = CURRENT_TIMESTAMP, cancel_reason = $2
 		WHERE id = $1 AND status IN ('queued', 'running')
+		RETURNING job_id
 	`
 
-	result, err := h.db.GetConn().ExecContext(ctx, query, buildID)
+	var jobID uuid.UUID
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, query, buildID, cancelReasonUser).Scan(&jobID); err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, buildID, "build.status", map[string]interface{}{
+			"build_id": buildID, "job_id": jobID, "status": "cancelled", "cancel_reason": cancelReasonUser,
+		})
+	})
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found or already completed")
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to cancel build")
 		SendError(w, http.StatusInternalServerError, err, "Failed to cancel build")
 		return
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		SendError(w, http.StatusNotFound, nil, "Build not found or already completed")
-		return
-	}
-
 	log.Info().Str("build_id", buildID.String()).Msg("Build cancelled")
 	SendJSON(w, http.StatusOK, map[string]string{"status": "cancelled"})
 }
 
-// GetBuildLogs returns build logs
-func (h *BuildHandler) GetBuildLogs(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	buildID, err := uuid.Parse(vars["id"])
-	if err != nil {
-		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
-		return
-	}
-
-	query := `
-		SELECT sequence_number, timestamp, log_line, stream
-		FROM build_logs
-		WHERE build_id = $1
-		ORDER BY sequence_number ASC
-	`
-
-	rows, err := h.db.GetConn().QueryContext(ctx, query, buildID)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to query build logs")
-		SendError(w, http.StatusInternalServerError, err, "Failed to fetch logs")
-		return
-	}
-	defer rows.Close()
-
-	logs := []models.BuildLog{}
-	for rows.Next() {
-		var log models.BuildLog
-		err := rows.Scan(&log.SequenceNumber, &log.Timestamp, &log.LogLine, &log.Stream)
-		if err != nil {
-			continue
-		}
-		log.BuildID = buildID
-		logs = append(logs, log)
-	}
-
-	SendJSON(w, http.StatusOK, logs)
-}
-
 // ListArtifacts returns artifacts for a build
 func (h *BuildHandler) ListArtifacts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -282,15 +431,22 @@ func (h *BuildHandler) GetWorkerBuilds(w http.ResponseWriter, r *http.Request) {
 	workerID := vars["worker_id"]
 
 	// Validate worker ID
-	if _, err := uuid.Parse(workerID); err != nil {
+	id, err := uuid.Parse(workerID)
+	if err != nil {
 		SendError(w, http.StatusBadRequest, err, "Invalid worker ID")
 		return
 	}
+	// The builds carry their secrets and identity token request tokens, so
+	// only the worker they are assigned to gets them
+	if !authorizeWorker(ctx, h.db, w, r, id) {
+		return
+	}
 
 	query := `
 		SELECT b.id, b.job_id, b.build_number, b.status, b.queued_at, 
-		       b.scm_commit_sha, b.branch, b.triggered_by, b.build_config,
-		       j.name as job_name, j.scm_url, j.scm_type
+		       b.scm_commit_sha, b.branch, b.triggered_by, b.parameters, b.rerun_of, b.rerun_stages,
+		       j.name as job_name, j.scm_url, j.scm_type, j.plugins, j.labels,
+		       COALESCE(b.trace_context, '{}'), COALESCE(j.pipeline_file, '')
 		FROM builds b
 		JOIN jobs j ON b.job_id = j.id
 		WHERE b.worker_id = $1 AND b.status = 'queued'
@@ -306,35 +462,130 @@ func (h *BuildHandler) GetWorkerBuilds(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	exceptions, err := activeLicenseExceptions(ctx, h.db)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to look up license exceptions")
+	}
+
 	builds := []map[string]interface{}{}
 	for rows.Next() {
 		var build models.Build
 		var jobName, scmURL, scmType string
-		var buildConfig models.JSONB
+		var plugins models.JSONArray
+		var labels, traceContext models.JSONB
+		var pipelineFile string
 
 		err := rows.Scan(
 			&build.ID, &build.JobID, &build.BuildNumber, &build.Status,
 			&build.QueuedAt, &build.CommitSHA, &build.Branch,
-			&build.TriggeredBy, &buildConfig, &jobName, &scmURL, &scmType,
+			&build.TriggeredBy, &build.Parameters, &build.RerunOf, &build.RerunStages,
+			&jobName, &scmURL, &scmType, &plugins, &labels,
+			&traceContext, &pipelineFile,
 		)
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to scan build row")
 			continue
 		}
 
+		// Expressions in the job's config are resolved as the build is handed out
+		resolved, err := interpolate.ResolveBuild(ctx, h.db, h.creds, h.templates, build.ID)
+		var undefined *interpolate.UndefinedError
+		if errors.As(err, &undefined) {
+			// Checked at dispatch, but secrets may have been removed since
+			log.Warn().Str("build_id", build.ID.String()).Strs("references", undefined.References).Msg("Build failed: undefined references in job configuration")
+			if err := h.failBuild(ctx, build.ID, undefined.Error()); err != nil {
+				log.Error().Err(err).Str("build_id", build.ID.String()).Msg("Failed to fail build")
+			}
+			continue
+		}
+		var invalid *pipeline.ValidationError
+		if errors.As(err, &invalid) {
+			// Checked at dispatch, but a library or build image may have changed since
+			log.Warn().Str("build_id", build.ID.String()).Strs("problems", invalid.Problems).Msg("Build failed: invalid pipeline includes or build images")
+			if err := h.failBuild(ctx, build.ID, invalid.Error()); err != nil {
+				log.Error().Err(err).Str("build_id", build.ID.String()).Msg("Failed to fail build")
+			}
+			continue
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("build_id", build.ID.String()).Msg("Failed to resolve job configuration")
+			continue
+		}
+
+		// Reruns of the build get the environment it runs with now
+		recorded, _ := json.Marshal(resolved.Recorded)
+		if _, err := h.db.GetConn().ExecContext(ctx, `UPDATE builds SET environment_vars = $2 WHERE id = $1`, build.ID, recorded); err != nil {
+			log.Warn().Err(err).Str("build_id", build.ID.String()).Msg("Failed to record build environment")
+		}
+
+		// Steps and plugins request identity tokens for cloud providers with
+		// a token only this build has
+		if h.identity != nil {
+			env, err := oidcRequestEnv(ctx, h.db, h.identity, build.ID)
+			if err != nil {
+				log.Warn().Err(err).Str("build_id", build.ID.String()).Msg("Failed to create identity token request token")
+			}
+			for name, value := range env {
+				resolved.EnvVars[name] = value
+			}
+			if token, ok := env[oidcRequestTokenVar]; ok {
+				resolved.SecretValues = append(resolved.SecretValues, token)
+			}
+		}
+
 		buildMap := map[string]interface{}{
-			"id":           build.ID,
-			"job_id":       build.JobID,
-			"job_name":     jobName,
-			"build_number": build.BuildNumber,
-			"status":       build.Status,
-			"queued_at":    build.QueuedAt,
-			"commit_sha":   build.CommitSHA,
-			"branch":       build.Branch,
-			"triggered_by": build.TriggeredBy,
-			"build_config": buildConfig,
-			"scm_url":      scmURL,
-			"scm_type":     scmType,
+			"id":               build.ID,
+			"job_id":           build.JobID,
+			"job_name":         jobName,
+			"build_number":     build.BuildNumber,
+			"status":           build.Status,
+			"queued_at":        build.QueuedAt,
+			"commit_sha":       build.CommitSHA,
+			"branch":           build.Branch,
+			"triggered_by":     build.TriggeredBy,
+			"parameters":       build.Parameters,
+			"build_config":     resolved.BuildConfig,
+			"environment_vars": resolved.EnvVars,
+			"scm_url":          scmURL,
+			"scm_type":         scmType,
+			"plugins":          append(models.JSONArray(resolved.Plugins), plugins...),
+			"job_labels":       labels,
+			// The agent continues the trace the build was queued in
+			"trace_context": traceContext,
+		}
+		if len(resolved.SecretValues) > 0 {
+			// The agent masks secrets in the build log
+			buildMap["masked_values"] = resolved.SecretValues
+		}
+		if pipelineFile != "" {
+			// The agent reads the pipeline from the repository once checked out
+			buildMap["pipeline_file"] = pipelineFile
+		}
+		if build.RerunOf != nil && len(build.RerunStages) > 0 {
+			// The agent resumes in the original's workspace if it kept it
+			buildMap["rerun_of"] = build.RerunOf
+			buildMap["rerun_stages"] = build.RerunStages
+		}
+
+		// Coverage plugins compare against the last successful build
+		baseline, err := h.coverageBaseline(ctx, build.JobID, build.Branch)
+		if err != nil {
+			log.Warn().Err(err).Str("build_id", build.ID.String()).Msg("Failed to look up coverage baseline")
+		} else if baseline != nil {
+			buildMap["coverage_baseline"] = baseline
+		}
+
+		// The license-compliance plugin approves the packages with exceptions
+		if exceptions != nil {
+			buildMap["license_exceptions"] = exceptions
+		}
+
+		// SCM plugins list the files changed since the last successful build
+		previous, err := h.previousCommit(ctx, build.JobID, build.Branch, build.BuildNumber)
+		if err != nil {
+			log.Warn().Err(err).Str("build_id", build.ID.String()).Msg("Failed to look up previous commit")
+		} else if previous != "" {
+			buildMap["previous_commit_sha"] = previous
 		}
 
 		builds = append(builds, buildMap)
@@ -343,6 +594,31 @@ func (h *BuildHandler) GetWorkerBuilds(w http.ResponseWriter, r *http.Request) {
 	SendJSON(w, http.StatusOK, builds)
 }
 
+// failBuild fails a build whose job configuration cannot be resolved as it
+// is handed out, telling the build's subscribers in the same transaction
+func (h *BuildHandler) failBuild(ctx context.Context, buildID uuid.UUID, message string) error {
+	err := h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var jobID uuid.UUID
+		var completedAt time.Time
+		err := tx.QueryRowContext(ctx, `
+			UPDATE builds SET status = 'failed', error_message = $2, completed_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND status IN ('queued', 'running')
+			RETURNING job_id, completed_at
+		`, buildID, message).Scan(&jobID, &completedAt)
+		if err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, buildID, "build.status", map[string]interface{}{
+			"build_id": buildID, "job_id": jobID, "status": "failed",
+			"error_message": message, "completed_at": completedAt,
+		})
+	})
+	if err == sql.ErrNoRows {
+		return nil // Cancelled or finished in the meantime
+	}
+	return err
+}
+
 // UpdateBuildStatus updates the status and details of a build
 func (h *BuildHandler) UpdateBuildStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -350,7 +626,8 @@ func (h *BuildHandler) UpdateBuildStatus(w http.ResponseWriter, r *http.Request)
 	buildID := vars["id"]
 
 	// Validate build ID
-	if _, err := uuid.Parse(buildID); err != nil {
+	id, err := uuid.Parse(buildID)
+	if err != nil {
 		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
 		return
 	}
@@ -362,6 +639,8 @@ func (h *BuildHandler) UpdateBuildStatus(w http.ResponseWriter, r *http.Request)
 		ExitCode     *int    `json:"exit_code,omitempty"`
 		ErrorMessage *string `json:"error_message,omitempty"`
 		Duration     *int    `json:"duration_seconds,omitempty"`
+		// Why the agent failed the build, e.g. disk_quota_exceeded
+		FailureReason *string `json:"failure_reason,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -378,6 +657,10 @@ func (h *BuildHandler) UpdateBuildStatus(w http.ResponseWriter, r *http.Request)
 		SendError(w, http.StatusBadRequest, nil, "Invalid status value")
 		return
 	}
+	if req.FailureReason != nil && (req.Status != "failure" || !failureReasons[*req.FailureReason]) {
+		SendError(w, http.StatusBadRequest, nil, "Invalid failure_reason (want disk_quota_exceeded, with status failure)")
+		return
+	}
 
 	// Build dynamic update query
 	query := `UPDATE builds SET status = $1, updated_at = NOW()`
@@ -414,22 +697,49 @@ func (h *BuildHandler) UpdateBuildStatus(w http.ResponseWriter, r *http.Request)
 		argCount++
 	}
 
-	query += ` WHERE id = $` + strconv.Itoa(argCount)
+	if req.FailureReason != nil {
+		query += `, failure_reason = $` + strconv.Itoa(argCount)
+		args = append(args, req.FailureReason)
+		argCount++
+	}
+
+	query += ` WHERE id = $` + strconv.Itoa(argCount) + ` RETURNING job_id, build_number`
 	args = append(args, buildID)
 
-	result, err := h.db.GetConn().ExecContext(ctx, query, args...)
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var jobID uuid.UUID
+		var buildNumber int
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&jobID, &buildNumber); err != nil {
+			return err
+		}
+		event := map[string]interface{}{
+			"build_id":         id,
+			"job_id":           jobID,
+			"status":           req.Status,
+			"started_at":       req.StartedAt,
+			"completed_at":     req.CompletedAt,
+			"exit_code":        req.ExitCode,
+			"error_message":    req.ErrorMessage,
+			"duration_seconds": req.Duration,
+			"failure_reason":   req.FailureReason,
+		}
+		if req.Status == "success" && req.Duration != nil {
+			if err := h.recordDurationRegression(ctx, tx, id, jobID, buildNumber, *req.Duration, event); err != nil {
+				return err
+			}
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, id, "build.status", event)
+	})
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Str("build_id", buildID).Msg("Failed to update build status")
 		SendError(w, http.StatusInternalServerError, err, "Failed to update build")
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		SendError(w, http.StatusNotFound, nil, "Build not found")
-		return
-	}
-
 	log.Info().
 		Str("build_id", buildID).
 		Str("status", req.Status).
@@ -441,3 +751,19 @@ func (h *BuildHandler) UpdateBuildStatus(w http.ResponseWriter, r *http.Request)
 		"status":   req.Status,
 	})
 }
+
+// failureReasons are the failure_reason values agents report builds they
+// failed for a reason of their own with
+var failureReasons = map[string]bool{
+	"disk_quota_exceeded": true, // its workspace, or its worker, went over a disk quota
+}
+
+// parseTimeOrDate parses an RFC 3339 time or a date (2006-01-02, in UTC), and
+// reports which it was
+func parseTimeOrDate(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	return t, true, err
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/cache"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+const (
+	// maxCacheEntryBytes limits the size of a cache entry
+	maxCacheEntryBytes = 4 << 30
+	// maxCacheKeyLength, maxCacheScopeLength and maxCacheVersionLength bound
+	// the parts of a cache entry's address
+	maxCacheKeyLength     = 1024
+	maxCacheScopeLength   = 255
+	maxCacheVersionLength = 255
+)
+
+// CacheHandler serves the cache to build steps and external tools, such as
+// remote cache shims of build systems, as plain blobs under keys
+type CacheHandler struct {
+	store *cache.Store
+}
+
+// NewCacheHandler creates a new cache handler
+func NewCacheHandler(store *cache.Store) *CacheHandler {
+	return &CacheHandler{store: store}
+}
+
+// GetCacheEntry returns the content of the entry stored under a key, within
+// the ?scope= (default global) and ?version= of the request. HEAD requests
+// only report whether it exists, and its size and checksum.
+func (h *CacheHandler) GetCacheEntry(w http.ResponseWriter, r *http.Request) {
+	scope, version, key, ok := cacheAddress(w, r)
+	if !ok {
+		return
+	}
+	h.get(w, r, scope, version, key)
+}
+
+// GetStageOutputs returns the archive of the outputs a stage of a build
+// stored once it succeeded, for a rerun of the build on another worker to
+// restore. HEAD requests only report whether it exists.
+func (h *CacheHandler) GetStageOutputs(w http.ResponseWriter, r *http.Request) {
+	buildID, stage, ok := stageOutputAddress(w, r)
+	if !ok {
+		return
+	}
+	h.get(w, r, cache.StageOutputScope, buildID, stage)
+}
+
+// get responds with the entry stored under an address
+func (h *CacheHandler) get(w http.ResponseWriter, r *http.Request, scope, version, key string) {
+	if r.Method == http.MethodHead {
+		entry, err := h.store.Stat(r.Context(), scope, version, key)
+		if err != nil {
+			sendCacheError(w, err)
+			return
+		}
+		setCacheEntryHeaders(w, entry)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	entry, body, err := h.store.Open(r.Context(), scope, version, key)
+	if err != nil {
+		sendCacheError(w, err)
+		return
+	}
+	defer body.Close()
+	extendDeadlines(w)
+
+	setCacheEntryHeaders(w, entry)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Warn().Err(err).Str("scope", scope).Str("key", key).Msg("Cache entry download interrupted")
+	}
+}
+
+// PutCacheEntry stores the request body under a key, within the ?scope= and
+// ?version= of the request, replacing the entry stored there. With an
+// X-Checksum-Sha256 header, a body that does not match it is rejected (422).
+func (h *CacheHandler) PutCacheEntry(w http.ResponseWriter, r *http.Request) {
+	scope, version, key, ok := cacheAddress(w, r)
+	if !ok {
+		return
+	}
+	h.put(w, r, scope, version, key)
+}
+
+// PutStageOutputs stores the request body, an archive of the outputs a stage
+// of a build declares, once the stage succeeded. The outputs are kept as
+// long as cache entries.
+func (h *CacheHandler) PutStageOutputs(w http.ResponseWriter, r *http.Request) {
+	buildID, stage, ok := stageOutputAddress(w, r)
+	if !ok {
+		return
+	}
+	h.put(w, r, cache.StageOutputScope, buildID, stage)
+}
+
+// put stores the request body under an address
+func (h *CacheHandler) put(w http.ResponseWriter, r *http.Request, scope, version, key string) {
+	extendDeadlines(w)
+	// Spool to disk so the size and checksum are known before storing
+	r.Body = http.MaxBytesReader(w, r.Body, maxCacheEntryBytes)
+	spool, err := os.CreateTemp("", "solvyd-cache-*")
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store cache entry")
+		return
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), r.Body)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Failed to read cache entry")
+		return
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expected := r.Header.Get("X-Checksum-Sha256"); expected != "" && !strings.EqualFold(expected, checksum) {
+		SendError(w, http.StatusUnprocessableEntity, nil, "Cache entry does not match X-Checksum-Sha256 (got "+checksum+")")
+		return
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store cache entry")
+		return
+	}
+
+	entry, err := h.store.Put(r.Context(), scope, version, key, spool, size, checksum)
+	if err != nil {
+		log.Error().Err(err).Str("scope", scope).Str("key", key).Msg("Failed to store cache entry")
+		SendError(w, http.StatusBadGateway, err, "Failed to store cache entry")
+		return
+	}
+
+	log.Debug().Str("scope", scope).Str("version", version).Str("key", key).Int64("size_bytes", size).Msg("Cache entry stored")
+	SendJSON(w, http.StatusCreated, entry)
+}
+
+// cacheAddress returns the scope, version and key of a request, sending an
+// error if one is invalid
+func cacheAddress(w http.ResponseWriter, r *http.Request) (string, string, string, bool) {
+	key := mux.Vars(r)["key"]
+	scope := r.URL.Query().Get("scope")
+	if scope == "" {
+		scope = cache.DefaultScope
+	}
+	version := r.URL.Query().Get("version")
+
+	switch {
+	case len(key) > maxCacheKeyLength:
+		SendError(w, http.StatusBadRequest, nil, "Cache key must be at most "+strconv.Itoa(maxCacheKeyLength)+" bytes")
+	case len(scope) > maxCacheScopeLength:
+		SendError(w, http.StatusBadRequest, nil, "Cache scope must be at most "+strconv.Itoa(maxCacheScopeLength)+" bytes")
+	case len(version) > maxCacheVersionLength:
+		SendError(w, http.StatusBadRequest, nil, "Cache version must be at most "+strconv.Itoa(maxCacheVersionLength)+" bytes")
+	default:
+		return scope, version, key, true
+	}
+	return "", "", "", false
+}
+
+// stageOutputAddress returns the build ID and stage name of a request for a
+// stage's outputs, sending an error if one is invalid
+func stageOutputAddress(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return "", "", false
+	}
+	stage := vars["stage"]
+	if len(stage) > maxCacheKeyLength {
+		SendError(w, http.StatusBadRequest, nil, "Stage name must be at most "+strconv.Itoa(maxCacheKeyLength)+" bytes")
+		return "", "", false
+	}
+	return buildID.String(), stage, true
+}
+
+// setCacheEntryHeaders describes a cache entry in the response headers
+func setCacheEntryHeaders(w http.ResponseWriter, entry *models.CacheEntry) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(entry.SizeBytes, 10))
+	w.Header().Set("X-Checksum-Sha256", entry.ChecksumSHA256)
+	w.Header().Set("Last-Modified", entry.CreatedAt.UTC().Format(http.TimeFormat))
+}
+
+// sendCacheError responds to a failure to read a cache entry
+func sendCacheError(w http.ResponseWriter, err error) {
+	if errors.Is(err, cache.ErrNotFound) {
+		SendError(w, http.StatusNotFound, err, "Cache entry not found")
+		return
+	}
+	log.Error().Err(err).Msg("Failed to read cache entry")
+	SendError(w, http.StatusInternalServerError, err, "Failed to read cache entry")
+}
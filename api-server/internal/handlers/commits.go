@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// UpdateBuildCommit records the commit an SCM plugin step checked out for a
+// build, as {"sha", "message", "author", "email", "timestamp", "changed_files"}
+// as sent by the worker agent. It replaces the build's commit SHA, which is
+// empty for builds of a branch's head until then.
+func (h *BuildHandler) UpdateBuildCommit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		SHA          string   `json:"sha"`
+		Message      string   `json:"message"`
+		Author       string   `json:"author"`
+		Email        string   `json:"email"`
+		Timestamp    string   `json:"timestamp"`
+		ChangedFiles []string `json:"changed_files"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.SHA == "" {
+		SendError(w, http.StatusBadRequest, nil, "sha is required")
+		return
+	}
+	var committedAt *time.Time
+	if req.Timestamp != "" {
+		t, err := time.Parse(time.RFC3339, req.Timestamp)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "timestamp must be RFC 3339")
+			return
+		}
+		committedAt = &t
+	}
+	changedFiles := models.JSONArray{}
+	for _, f := range req.ChangedFiles {
+		changedFiles = append(changedFiles, f)
+	}
+
+	query := `
+		UPDATE builds
+		SET scm_commit_sha = $2, scm_commit_message = $3, scm_author = $4,
+		    scm_author_email = $5, scm_committed_at = $6, changed_files = $7
+		WHERE id = $1
+	`
+	result, err := h.db.GetConn().ExecContext(ctx, query, buildID, req.SHA, req.Message, req.Author,
+		req.Email, committedAt, changedFiles)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to store build commit")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store commit")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"sha":           req.SHA,
+		"changed_files": len(changedFiles),
+	})
+}
+
+// previousCommit returns the commit of the job's last successful build on the
+// branch before the given build, or "" if there is none. SCM plugins list the
+// files changed since it, so a build also covers the changes of the failed
+// builds before it.
+func (h *BuildHandler) previousCommit(ctx context.Context, jobID uuid.UUID, branch string, buildNumber int) (string, error) {
+	query := `
+		SELECT scm_commit_sha
+		FROM builds
+		WHERE job_id = $1 AND branch = $2 AND build_number < $3 AND status = 'success'
+		  AND scm_commit_sha IS NOT NULL AND scm_commit_sha <> ''
+		ORDER BY build_number DESC
+		LIMIT 1
+	`
+	var sha string
+	err := h.db.GetConn().QueryRowContext(ctx, query, jobID, branch, buildNumber).Scan(&sha)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return sha, err
+}
@@ -3,10 +3,31 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
 
 	"github.com/solvyd/solvyd/api-server/internal/database"
 )
 
+// streamingTimeout bounds requests that upload or download artifacts, cache
+// entries and stage outputs, which the server's read and write timeouts are
+// far too short for
+const streamingTimeout = time.Hour
+
+// extendDeadlines replaces the server's read and write timeouts of a request
+// that streams a large body with streamingTimeout
+func extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(streamingTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Warn().Err(err).Msg("Failed to extend the read deadline of a streaming request")
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.Warn().Err(err).Msg("Failed to extend the write deadline of a streaming request")
+	}
+}
+
 // HealthCheck handles health check requests
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -39,9 +60,10 @@ func ReadinessCheck(db *database.Database) http.HandlerFunc {
 
 // ErrorResponse is a standard error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
-	Code    int    `json:"code"`
+	Error   string   `json:"error"`
+	Message string   `json:"message,omitempty"`
+	Code    int      `json:"code"`
+	Details []string `json:"details,omitempty"` // one entry per validation problem
 }
 
 // SendError sends a JSON error response
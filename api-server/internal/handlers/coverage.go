@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// coveragePercent returns covered as a percentage of total, or 0 if total is 0
+func coveragePercent(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// setCoveragePercent fills in the percentages of c from its counts
+func setCoveragePercent(c *models.Coverage) {
+	c.LinePercent = coveragePercent(c.LinesCovered, c.LinesTotal)
+	c.BranchPercent = coveragePercent(c.BranchesCovered, c.BranchesTotal)
+}
+
+// AppendBuildCoverage stores the code coverage a plugin step reported, as
+// {"step": "...", "coverage": {...}} as sent by the worker agent. A step that
+// reports again replaces its earlier coverage.
+func (h *BuildHandler) AppendBuildCoverage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		Step     string          `json:"step"`
+		Coverage models.Coverage `json:"coverage"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	c := req.Coverage
+	if c.LinesCovered < 0 || c.LinesCovered > c.LinesTotal || c.BranchesCovered < 0 || c.BranchesCovered > c.BranchesTotal {
+		SendError(w, http.StatusBadRequest, nil, "Covered counts must be between 0 and the totals")
+		return
+	}
+
+	var exists bool
+	err = h.db.GetConn().QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM builds WHERE id = $1)`, buildID).Scan(&exists)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to look up build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store coverage")
+		return
+	}
+	if !exists {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	query := `
+		INSERT INTO build_coverage (build_id, step, format, lines_covered, lines_total,
+		                            branches_covered, branches_total)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (build_id, step) DO UPDATE SET
+			format = EXCLUDED.format,
+			lines_covered = EXCLUDED.lines_covered,
+			lines_total = EXCLUDED.lines_total,
+			branches_covered = EXCLUDED.branches_covered,
+			branches_total = EXCLUDED.branches_total,
+			created_at = CURRENT_TIMESTAMP
+		RETURNING id, created_at
+	`
+	err = h.db.GetConn().QueryRowContext(ctx, query, buildID, req.Step, c.Format, c.LinesCovered, c.LinesTotal,
+		c.BranchesCovered, c.BranchesTotal).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to store coverage")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store coverage")
+		return
+	}
+
+	c.BuildID = buildID
+	c.Step = req.Step
+	setCoveragePercent(&c)
+	SendJSON(w, http.StatusOK, c)
+}
+
+// ListBuildCoverage lists the coverage each step of a build reported
+func (h *BuildHandler) ListBuildCoverage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	query := `
+		SELECT id, build_id, step, format, lines_covered, lines_total,
+		       branches_covered, branches_total, created_at
+		FROM build_coverage
+		WHERE build_id = $1
+		ORDER BY step
+	`
+	rows, err := h.db.GetConn().QueryContext(ctx, query, buildID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query coverage")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch coverage")
+		return
+	}
+	defer rows.Close()
+
+	list := []models.Coverage{}
+	for rows.Next() {
+		var c models.Coverage
+		err := rows.Scan(&c.ID, &c.BuildID, &c.Step, &c.Format, &c.LinesCovered, &c.LinesTotal,
+			&c.BranchesCovered, &c.BranchesTotal, &c.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan coverage row")
+			continue
+		}
+		setCoveragePercent(&c)
+		list = append(list, c)
+	}
+
+	SendJSON(w, http.StatusOK, list)
+}
+
+// GetJobCoverage returns the coverage reported by a job's builds, newest
+// first, to chart it over time. The branch query parameter limits it to
+// builds of one branch, and limit to a number of rows (default 50).
+func (h *JobHandler) GetJobCoverage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > 1000 {
+			SendError(w, http.StatusBadRequest, err, "limit must be between 1 and 1000")
+			return
+		}
+	}
+
+	query := `
+		SELECT c.id, c.build_id, b.build_number, COALESCE(b.branch, ''), c.step, c.format,
+		       c.lines_covered, c.lines_total, c.branches_covered, c.branches_total, c.created_at
+		FROM build_coverage c
+		JOIN builds b ON b.id = c.build_id
+		WHERE b.job_id = $1
+	`
+	args := []interface{}{jobID}
+	if branch := r.URL.Query().Get("branch"); branch != "" {
+		query += ` AND b.branch = $2`
+		args = append(args, branch)
+	}
+	query += ` ORDER BY b.build_number DESC, c.step LIMIT ` + strconv.Itoa(limit)
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query job coverage")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch coverage")
+		return
+	}
+	defer rows.Close()
+
+	list := []models.Coverage{}
+	for rows.Next() {
+		var c models.Coverage
+		err := rows.Scan(&c.ID, &c.BuildID, &c.BuildNumber, &c.Branch, &c.Step, &c.Format,
+			&c.LinesCovered, &c.LinesTotal, &c.BranchesCovered, &c.BranchesTotal, &c.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan coverage row")
+			continue
+		}
+		setCoveragePercent(&c)
+		list = append(list, c)
+	}
+
+	SendJSON(w, http.StatusOK, list)
+}
+
+// coverageBaseline returns the coverage a new build of a job is compared
+// against: the total of the last successful build on the same branch that
+// reported coverage, or else on the job's default branch. It returns nil if
+// there is no such build.
+func (h *BuildHandler) coverageBaseline(ctx context.Context, jobID uuid.UUID, branch string) (map[string]interface{}, error) {
+	query := `
+		SELECT b.id, b.build_number, SUM(c.lines_covered), SUM(c.lines_total),
+		       SUM(c.branches_covered), SUM(c.branches_total)
+		FROM builds b
+		JOIN jobs j ON j.id = b.job_id
+		JOIN build_coverage c ON c.build_id = b.id
+		WHERE b.job_id = $1 AND b.status = 'success' AND (b.branch = $2 OR b.branch = j.scm_branch)
+		GROUP BY b.id, b.build_number, b.branch, b.completed_at
+		ORDER BY b.branch = $2 DESC, b.completed_at DESC NULLS LAST
+		LIMIT 1
+	`
+	var buildID uuid.UUID
+	var buildNumber, linesCovered, linesTotal, branchesCovered, branchesTotal int
+	err := h.db.GetConn().QueryRowContext(ctx, query, jobID, branch).Scan(&buildID, &buildNumber,
+		&linesCovered, &linesTotal, &branchesCovered, &branchesTotal)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"build_id":         buildID,
+		"build_number":     buildNumber,
+		"lines_covered":    linesCovered,
+		"lines_total":      linesTotal,
+		"branches_covered": branchesCovered,
+		"branches_total":   branchesTotal,
+	}, nil
+}
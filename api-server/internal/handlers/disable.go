@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// jobDisabled is whether a job is disabled, and why
+type jobDisabled struct {
+	disabled   bool
+	reason     string
+	reenableAt *time.Time
+}
+
+// String is the message builds of a disabled job are refused with
+func (d jobDisabled) String() string {
+	msg := "Job is disabled"
+	if d.reason != "" {
+		msg += ": " + d.reason
+	}
+	if d.reenableAt != nil {
+		msg += " (until " + d.reenableAt.UTC().Format(time.RFC3339) + ")"
+	}
+	return msg
+}
+
+// DisableJob disables a job, so that it is not triggered, with a reason and
+// optionally the time it is enabled again. The body is
+// {"reason": string, "until": RFC 3339 time}. Disabling a disabled job
+// replaces its reason and re-enable time.
+func (h *JobHandler) DisableJob(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authenticate(h.auth, w, r)
+	if !ok {
+		return
+	}
+	jobID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	var req struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		SendError(w, http.StatusBadRequest, nil, "reason is required")
+		return
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		SendError(w, http.StatusBadRequest, nil, "until must be in the future")
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE jobs
+		SET enabled = false, disabled_reason = $2, disabled_by = NULLIF($3, ''),
+		    disabled_at = CURRENT_TIMESTAMP, reenable_at = $4
+		WHERE id = $1
+	`, jobID, req.Reason, principal.Username, req.Until)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to disable job")
+		SendError(w, http.StatusInternalServerError, err, "Failed to disable job")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+
+	log.Info().
+		Str("job_id", jobID.String()).
+		Str("by", principal.Username).
+		Str("reason", req.Reason).
+		Msg("Job disabled")
+	h.GetJob(w, r)
+}
+
+// EnableJob enables a disabled job ahead of its re-enable time, if it has one
+func (h *JobHandler) EnableJob(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authenticate(h.auth, w, r)
+	if !ok {
+		return
+	}
+	jobID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE jobs
+		SET enabled = true, disabled_reason = NULL, disabled_by = NULL,
+		    disabled_at = NULL, reenable_at = NULL
+		WHERE id = $1
+	`, jobID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to enable job")
+		SendError(w, http.StatusInternalServerError, err, "Failed to enable job")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+
+	log.Info().Str("job_id", jobID.String()).Str("by", principal.Username).Msg("Job enabled")
+	h.GetJob(w, r)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// doraQuery computes the DORA metrics of the deployments started in a window
+// ($1, $2), of one job ($3) and environment ($4) if set, for each environment
+// and overall. Completed deployments are those that succeeded, failed or were
+// rolled back; a change failure is a deployment that failed, was rolled back
+// or was the target of a rollback. An outage starts with a change failure
+// after a deployment that was not one, and is restored by the environment's
+// next successful deployment.
+const doraQuery = `
+	WITH scoped AS (
+		SELECT d.id, d.environment, d.status, d.started_at,
+		       COALESCE(d.completed_at, d.started_at) AS finished_at,
+		       b.scm_committed_at,
+		       d.status IN ('failed', 'rolled_back')
+		           OR EXISTS (SELECT 1 FROM deployments r WHERE r.rollback_from_deployment_id = d.id) AS failed
+		FROM deployments d
+		JOIN builds b ON b.id = d.build_id
+		WHERE d.started_at >= $1 AND d.started_at <= $2
+		  AND d.status IN ('success', 'failed', 'rolled_back')
+		  AND ($3::uuid IS NULL OR b.job_id = $3)
+		  AND ($4::text IS NULL OR d.environment = $4)
+	), ordered AS (
+		SELECT s.*,
+		       COALESCE(LAG(s.failed) OVER (PARTITION BY s.environment ORDER BY s.started_at), false) AS after_failure
+		FROM scoped s
+	), measured AS (
+		SELECT o.environment, o.status, o.failed,
+		       CASE WHEN o.status = 'success' AND o.scm_committed_at IS NOT NULL
+		            THEN EXTRACT(EPOCH FROM o.finished_at - o.scm_committed_at) END AS lead_seconds,
+		       o.failed AND NOT o.after_failure AS outage,
+		       CASE WHEN o.failed AND NOT o.after_failure THEN (
+		           SELECT EXTRACT(EPOCH FROM MIN(COALESCE(n.completed_at, n.started_at)) - o.finished_at)
+		           FROM deployments n
+		           JOIN builds nb ON nb.id = n.build_id
+		           WHERE n.environment = o.environment AND n.status = 'success'
+		             AND n.started_at > o.started_at
+		             AND ($3::uuid IS NULL OR nb.job_id = $3)
+		       ) END AS restore_seconds
+		FROM ordered o
+	)
+	SELECT COALESCE(environment, ''), GROUPING(environment) = 1,
+	       COUNT(*),
+	       COUNT(*) FILTER (WHERE status = 'success'),
+	       COUNT(*) FILTER (WHERE failed),
+	       AVG(lead_seconds),
+	       PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY lead_seconds),
+	       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY lead_seconds),
+	       COUNT(*) FILTER (WHERE outage),
+	       COUNT(*) FILTER (WHERE outage AND restore_seconds IS NULL),
+	       AVG(restore_seconds),
+	       PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY restore_seconds)
+	FROM measured
+	GROUP BY GROUPING SETS ((environment), ())
+	ORDER BY GROUPING(environment), environment
+`
+
+// GetDORAMetrics returns the four DORA metrics of the deployments started in
+// a window (?window=30d, as for statistics), of one job (?job_id=) and
+// environment (?environment=production) if given, for each environment and
+// overall: deployment frequency, lead time from a build's commit to its
+// deployment, change failure rate and time to restore service
+func (h *StatsHandler) GetDORAMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	window, ok := statsWindow(w, r)
+	if !ok {
+		return
+	}
+	var jobID *uuid.UUID
+	if v := r.URL.Query().Get("job_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid job_id")
+			return
+		}
+		jobID = &id
+	}
+	environment := r.URL.Query().Get("environment")
+
+	metrics := &models.DORAMetrics{
+		JobID:        jobID,
+		Environment:  environment,
+		Until:        time.Now().UTC(),
+		Environments: []models.DORAEnvironment{},
+	}
+	metrics.Since = metrics.Until.Add(-window)
+	days := window.Hours() / 24
+
+	rows, err := h.db.GetConn().QueryContext(ctx, doraQuery, metrics.Since, metrics.Until, jobID, nullString(environment))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to compute DORA metrics")
+		SendError(w, http.StatusInternalServerError, err, "Failed to compute DORA metrics")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.DORAEnvironment
+		var overall bool
+		err := rows.Scan(&m.Environment, &overall,
+			&m.Deployments, &m.SuccessfulDeployments, &m.FailedDeployments,
+			&m.LeadTime.Average, &m.LeadTime.Median, &m.LeadTime.P95,
+			&m.Outages, &m.OpenOutages, &m.TimeToRestore.Average, &m.TimeToRestore.Median)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan DORA metrics row")
+			SendError(w, http.StatusInternalServerError, err, "Failed to compute DORA metrics")
+			return
+		}
+		m.DeploymentsPerDay = float64(m.SuccessfulDeployments) / days
+		if m.Deployments > 0 {
+			rate := float64(m.FailedDeployments) / float64(m.Deployments)
+			m.ChangeFailureRate = &rate
+		}
+		if overall {
+			m.Environment = ""
+			metrics.Overall = m
+		} else {
+			metrics.Environments = append(metrics.Environments, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("Failed to read DORA metrics")
+		SendError(w, http.StatusInternalServerError, err, "Failed to compute DORA metrics")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, metrics)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/durations"
+)
+
+// durationRegression compares how long a successful build took with the
+// recent successful builds of its job. It returns a nil baseline if detection
+// is off or the job has too few builds to compare with.
+func (h *BuildHandler) durationRegression(ctx context.Context, q durations.Querier, jobID uuid.UUID, buildNumber, seconds int) (*durations.Baseline, bool, error) {
+	s := h.settings.Current()
+	if s.DurationRegressionBuilds == 0 {
+		return nil, false, nil
+	}
+	baseline, err := durations.Load(ctx, q, jobID, buildNumber, s.DurationRegressionBuilds)
+	if err != nil || baseline == nil {
+		return nil, false, err
+	}
+	return baseline, baseline.Regressed(seconds, s.DurationRegressionSensitivity), nil
+}
+
+// recordDurationRegression records on a successful build the baseline it was
+// compared with and whether it regressed, and adds both to its status event
+func (h *BuildHandler) recordDurationRegression(ctx context.Context, tx *sql.Tx, buildID, jobID uuid.UUID, buildNumber, seconds int, event map[string]interface{}) error {
+	baseline, regressed, err := h.durationRegression(ctx, tx, jobID, buildNumber, seconds)
+	if err != nil || baseline == nil {
+		return err
+	}
+	median := int(math.Round(baseline.Median))
+	_, err = tx.ExecContext(ctx, `
+		UPDATE builds SET duration_baseline_seconds = $2, duration_regression = $3
+		WHERE id = $1
+	`, buildID, median, regressed)
+	if err != nil {
+		return err
+	}
+
+	event["duration_baseline_seconds"] = median
+	event["duration_regression"] = regressed
+	if regressed {
+		log.Warn().
+			Str("build_id", buildID.String()).
+			Int("duration_seconds", seconds).
+			Int("baseline_seconds", median).
+			Int("samples", baseline.Samples).
+			Msg("Build duration regressed")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/environment"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// EnvironmentHandler manages the environment variables shared by jobs and
+// previews the environment jobs run with
+type EnvironmentHandler struct {
+	db *database.Database
+}
+
+// NewEnvironmentHandler creates a new environment handler
+func NewEnvironmentHandler(db *database.Database) *EnvironmentHandler {
+	return &EnvironmentHandler{db: db}
+}
+
+// GetGlobalEnvironment returns the variables every job's environment starts from
+func (h *EnvironmentHandler) GetGlobalEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.getLayer(w, r, environment.SourceGlobal, "")
+}
+
+// SetGlobalEnvironment replaces the global variables, as {"variables": {...}}
+func (h *EnvironmentHandler) SetGlobalEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.setLayer(w, r, environment.SourceGlobal, "")
+}
+
+// GetProjectEnvironment returns the variables of the jobs of a project
+func (h *EnvironmentHandler) GetProjectEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.getLayer(w, r, environment.SourceProject, mux.Vars(r)["project"])
+}
+
+// SetProjectEnvironment replaces the variables of a project, as
+// {"variables": {...}}
+func (h *EnvironmentHandler) SetProjectEnvironment(w http.ResponseWriter, r *http.Request) {
+	h.setLayer(w, r, environment.SourceProject, mux.Vars(r)["project"])
+}
+
+func (h *EnvironmentHandler) getLayer(w http.ResponseWriter, r *http.Request, scope, name string) {
+	variables, err := environment.Get(r.Context(), h.db, scope, name)
+	if err != nil {
+		log.Error().Err(err).Str("scope", scope).Str("name", name).Msg("Failed to query environment")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch environment")
+		return
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{"variables": variables})
+}
+
+func (h *EnvironmentHandler) setLayer(w http.ResponseWriter, r *http.Request, scope, name string) {
+	var req struct {
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.Variables == nil {
+		req.Variables = map[string]string{}
+	}
+	if problems := environment.ValidateNames(req.Variables); len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:   "invalid variable names",
+			Message: "Invalid environment variables",
+			Code:    http.StatusUnprocessableEntity,
+			Details: problems,
+		})
+		return
+	}
+
+	if err := environment.Set(r.Context(), h.db, scope, name, req.Variables); err != nil {
+		log.Error().Err(err).Str("scope", scope).Str("name", name).Msg("Failed to store environment")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store environment")
+		return
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{"variables": req.Variables})
+}
+
+// GetJobEnvironment previews the environment a job's builds run with: each
+// layer, from the global variables through the project's and the job's to
+// the trigger parameters, given as ?param.NAME=value, and the effective
+// variables with the layer each comes from. Expressions such as
+// ${{ secrets.NAME }} are shown as written; they are resolved at dispatch.
+func (h *EnvironmentHandler) GetJobEnvironment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	jobID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	var project string
+	var jobVars models.JSONB
+	err = h.db.GetConn().QueryRowContext(ctx,
+		`SELECT COALESCE(project, ''), environment_vars FROM jobs WHERE id = $1`, jobID).Scan(&project, &jobVars)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query job")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch environment")
+		return
+	}
+
+	parameters := models.JSONB{}
+	for key, values := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(key, "param."); ok && len(values) > 0 {
+			parameters[name] = values[0]
+		}
+	}
+
+	layers, err := environment.Layers(ctx, h.db, project, jobVars, parameters)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to load environment layers")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch environment")
+		return
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"job_id":    jobID,
+		"project":   project,
+		"layers":    layers,
+		"variables": environment.Merge(layers),
+	})
+}
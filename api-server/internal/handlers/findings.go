@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/findings"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// maxFindingsBytes limits the size of one AppendBuildFindings request
+const maxFindingsBytes = 32 << 20
+
+// AppendBuildFindings stores security findings reported during a build. The
+// body is either {"findings": [...]} as sent by the worker agent, or a SARIF
+// 2.1.0 log (Content-Type application/sarif+json, or any JSON object with
+// "runs"). The tool query parameter names the tool for findings that do not.
+func (h *BuildHandler) AppendBuildFindings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFindingsBytes))
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	var req struct {
+		Findings []models.Finding `json:"findings"`
+		Runs     json.RawMessage  `json:"runs"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == "application/sarif+json" || req.Runs != nil {
+		if req.Findings, err = findings.ParseSARIF(bytes.NewReader(body)); err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid SARIF log")
+			return
+		}
+	}
+
+	tool := r.URL.Query().Get("tool")
+	for i := range req.Findings {
+		findings.Normalize(&req.Findings[i], tool)
+		if req.Findings[i].Tool == "" || req.Findings[i].RuleID == "" {
+			SendError(w, http.StatusBadRequest, nil, "Findings need a tool and a rule_id")
+			return
+		}
+	}
+
+	var exists bool
+	err = h.db.GetConn().QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM builds WHERE id = $1)`, buildID).Scan(&exists)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to look up build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store findings")
+		return
+	}
+	if !exists {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	query := `
+		INSERT INTO build_findings (build_id, tool, rule_id, title, description, severity,
+		                            path, start_line, end_line, uri, package, version,
+		                            cwes, cves, help_url, fingerprint)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		RETURNING id
+	`
+	ids := make([]string, len(req.Findings))
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		for i, f := range req.Findings {
+			err := tx.QueryRowContext(ctx, query, buildID, f.Tool, f.RuleID, f.Title, f.Description, f.Severity,
+				f.Location.Path, f.Location.StartLine, f.Location.EndLine, f.Location.URI,
+				f.Location.Package, f.Location.Version, pq.Array(f.CWEs), pq.Array(f.CVEs),
+				f.HelpURL, f.Fingerprint).Scan(&ids[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to store findings")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store findings")
+		return
+	}
+
+	// Report how many of the stored findings are new, so the worker can fail
+	// the step on new findings only
+	counts, err := h.countFindings(ctx,
+		`SELECT severity, COUNT(*) FROM (`+findingsWithStatus+`) f
+		 WHERE id = ANY($2::uuid[]) AND status = 'new' GROUP BY severity`,
+		buildID, pq.Array(ids))
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to count new findings")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store findings")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{"stored": len(req.Findings), "new": counts})
+}
+
+// findingsWithStatus selects a build's findings ($1) with their status
+// relative to the job's baseline and unexpired suppressions
+const findingsWithStatus = `
+	SELECT f.*,
+	       CASE
+	           WHEN EXISTS (SELECT 1 FROM finding_suppressions s
+	                        WHERE s.job_id = b.job_id AND s.fingerprint = f.fingerprint
+	                          AND (s.expires_at IS NULL OR s.expires_at > NOW())) THEN 'suppressed'
+	           WHEN EXISTS (SELECT 1 FROM finding_baselines bl
+	                        WHERE bl.job_id = b.job_id AND bl.fingerprint = f.fingerprint) THEN 'baseline'
+	           ELSE 'new'
+	       END AS status
+	FROM build_findings f
+	JOIN builds b ON b.id = f.build_id
+	WHERE f.build_id = $1
+`
+
+// countFindings runs a query returning severities and counts, and returns the
+// count of every severity
+func (h *BuildHandler) countFindings(ctx context.Context, query string, args ...interface{}) (map[string]int, error) {
+	counts := make(map[string]int, len(findings.Severities))
+	for _, severity := range findings.Severities {
+		counts[severity] = 0
+	}
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var severity string
+		var n int
+		if err := rows.Scan(&severity, &n); err != nil {
+			return nil, err
+		}
+		counts[severity] = n
+	}
+	return counts, rows.Err()
+}
+
+// ListBuildFindings returns a build's security findings, most severe first,
+// each with its status against the job's baseline and suppressions, and the
+// number of findings and of new findings of each severity. Findings can be filtered by
+// severity (a comma-separated list), min_severity, tool and status.
+func (h *BuildHandler) ListBuildFindings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var severities []string
+	if s := r.URL.Query().Get("severity"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			severity, err := findings.ParseSeverity(name)
+			if err != nil {
+				SendError(w, http.StatusBadRequest, err, "Invalid severity")
+				return
+			}
+			severities = append(severities, severity)
+		}
+	}
+	var minSeverities []string
+	if s := r.URL.Query().Get("min_severity"); s != "" {
+		min, err := findings.ParseSeverity(s)
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid min_severity")
+			return
+		}
+		minSeverities = findings.AtLeast(min)
+	}
+	tool := r.URL.Query().Get("tool")
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.FindingStatusNew, models.FindingStatusBaseline, models.FindingStatusSuppressed:
+	default:
+		SendError(w, http.StatusBadRequest, nil, "Invalid status (want new, baseline or suppressed)")
+		return
+	}
+
+	query := `
+		SELECT id, build_id, tool, rule_id, title, description, severity,
+		       path, start_line, end_line, uri, package, version,
+		       cwes, cves, help_url, fingerprint, status, created_at
+		FROM (` + findingsWithStatus + `) f
+		WHERE 1=1
+	`
+	args := []interface{}{buildID}
+	argCount := 2
+
+	if tool != "" {
+		query += ` AND tool = $` + strconv.Itoa(argCount)
+		args = append(args, tool)
+		argCount++
+	}
+	countQuery := `SELECT severity, COUNT(*) FROM (` + query + `) f GROUP BY severity`
+	newCountQuery := `SELECT severity, COUNT(*) FROM (` + query + `) f WHERE status = 'new' GROUP BY severity`
+	countArgs := append([]interface{}{}, args...)
+
+	if severities != nil {
+		query += ` AND severity = ANY($` + strconv.Itoa(argCount) + `)`
+		args = append(args, pq.Array(severities))
+		argCount++
+	}
+	if minSeverities != nil {
+		query += ` AND severity = ANY($` + strconv.Itoa(argCount) + `)`
+		args = append(args, pq.Array(minSeverities))
+		argCount++
+	}
+	if status != "" {
+		query += ` AND status = $` + strconv.Itoa(argCount)
+		args = append(args, status)
+	}
+
+	query += ` ORDER BY CASE severity
+		WHEN 'critical' THEN 0 WHEN 'high' THEN 1 WHEN 'medium' THEN 2 WHEN 'low' THEN 3 ELSE 4
+		END, tool, rule_id, path, start_line`
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query findings")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch findings")
+		return
+	}
+	defer rows.Close()
+
+	list := []models.Finding{}
+	for rows.Next() {
+		var f models.Finding
+		err := rows.Scan(
+			&f.ID, &f.BuildID, &f.Tool, &f.RuleID, &f.Title, &f.Description, &f.Severity,
+			&f.Location.Path, &f.Location.StartLine, &f.Location.EndLine, &f.Location.URI,
+			&f.Location.Package, &f.Location.Version, pq.Array(&f.CWEs), pq.Array(&f.CVEs),
+			&f.HelpURL, &f.Fingerprint, &f.Status, &f.CreatedAt,
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan finding row")
+			continue
+		}
+		list = append(list, f)
+	}
+
+	counts, err := h.countFindings(ctx, countQuery, countArgs...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count findings")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch findings")
+		return
+	}
+	newCounts, err := h.countFindings(ctx, newCountQuery, countArgs...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count findings")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch findings")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"findings": list,
+		"counts":   counts,
+		"new":      newCounts,
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/solvyd/solvyd/api-server/internal/gitops"
@@ -8,11 +9,11 @@ import (
 
 // GitOpsHandler handles GitOps-related requests
 type GitOpsHandler struct {
-	sync *gitops.SyncService
+	sync *gitops.Manager
 }
 
 // NewGitOpsHandler creates a new GitOps handler
-func NewGitOpsHandler(sync *gitops.SyncService) *GitOpsHandler {
+func NewGitOpsHandler(sync *gitops.Manager) *GitOpsHandler {
 	return &GitOpsHandler{sync: sync}
 }
 
@@ -22,9 +23,14 @@ func (h *GitOpsHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	SendJSON(w, http.StatusOK, status)
 }
 
-// TriggerSync triggers a manual synchronization
+// TriggerSync triggers a manual synchronization of one source (?source=) or all sources
 func (h *GitOpsHandler) TriggerSync(w http.ResponseWriter, r *http.Request) {
-	if err := h.sync.Sync(); err != nil {
+	err := h.sync.Sync(r.URL.Query().Get("source"))
+	if errors.Is(err, gitops.ErrUnknownSource) {
+		SendError(w, http.StatusNotFound, err, "GitOps source not found")
+		return
+	}
+	if err != nil {
 		SendError(w, http.StatusInternalServerError, err, "Sync failed")
 		return
 	}
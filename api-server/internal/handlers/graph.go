@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+)
+
+// cloneStage is the stage in which the worker agent checks out the
+// repository, before any other stage runs
+const cloneStage = "clone"
+
+// GetBuildGraph returns the stages of a build as a graph the UI can draw: each
+// stage with its dependencies, its level (the column it is drawn in), its
+// status and how long it ran, and the edges between stages. Stages come from
+// the pipeline definition the build read from its repository, or else the
+// stages of its job's build config, which run one after another; all of them
+// follow the checkout of the repository.
+func (h *BuildHandler) GetBuildGraph(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var pipelineConfig, buildConfig models.JSONB
+	err = h.db.GetConn().QueryRowContext(ctx, `
+		SELECT b.pipeline_config, j.build_config
+		FROM builds b
+		JOIN jobs j ON j.id = b.job_id
+		WHERE b.id = $1
+	`, buildID).Scan(&pipelineConfig, &buildConfig)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to query build stages")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch build graph")
+		return
+	}
+
+	timeline, err := h.loadTimeline(ctx, buildID)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to load build timeline")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch build graph")
+		return
+	}
+
+	graph := &models.BuildGraph{BuildID: buildID, Status: timeline.Status}
+	var stages []pipeline.Stage
+	graph.Source, stages = graphStages(pipelineConfig, buildConfig)
+	graph.Nodes, graph.Edges = layoutGraph(stages, timeline)
+	SendJSON(w, http.StatusOK, graph)
+}
+
+// graphStages returns the stages a build runs after the checkout and where
+// they are defined. Stages of the build config run in the order listed, so
+// each depends on the one before.
+func graphStages(pipelineConfig, buildConfig models.JSONB) (string, []pipeline.Stage) {
+	if len(pipelineConfig) > 0 {
+		var def pipeline.Definition
+		data, _ := json.Marshal(pipelineConfig)
+		if err := json.Unmarshal(data, &def); err == nil && len(def.Stages) > 0 {
+			return "pipeline_file", def.Stages
+		}
+	}
+
+	items, _ := buildConfig["stages"].([]interface{})
+	var stages []pipeline.Stage
+	for _, item := range items {
+		s, _ := item.(map[string]interface{})
+		name, _ := s["name"].(string)
+		if name == "" {
+			continue
+		}
+		stage := pipeline.Stage{Name: name}
+		stage.Image = configImage(s)
+		if len(stages) > 0 {
+			stage.DependsOn = []string{stages[len(stages)-1].Name}
+		}
+		stages = append(stages, stage)
+	}
+	if len(stages) > 0 {
+		return "build_config", stages
+	}
+	return "default", []pipeline.Stage{{Name: "build", Image: configImage(buildConfig)}}
+}
+
+// configImage returns the image of a build config or stage, or the name of
+// the build image it runs in
+func configImage(cfg map[string]interface{}) string {
+	if image, _ := cfg["image"].(string); image != "" {
+		return image
+	}
+	image, _ := cfg["build_image"].(string)
+	return image
+}
+
+// layoutGraph places the checkout and the stages in the graph, with the
+// status and duration of the span each ran in. Stages the build ran that are
+// not defined, which can happen if the job changed since, are added after
+// the checkout.
+func layoutGraph(stages []pipeline.Stage, timeline *models.BuildTimeline) ([]models.GraphNode, []models.GraphEdge) {
+	stages = append([]pipeline.Stage{{Name: cloneStage}}, stages...)
+	defined := make(map[string]bool, len(stages))
+	for _, stage := range stages {
+		defined[stage.Name] = true
+	}
+	spans := map[string]models.TimelineSpan{}
+	for _, span := range timeline.Spans {
+		if span.Kind != "stage" {
+			continue
+		}
+		spans[span.Name] = span
+		if !defined[span.Name] {
+			defined[span.Name] = true
+			stages = append(stages, pipeline.Stage{Name: span.Name})
+		}
+	}
+
+	finished := timeline.Status != models.JobStatusQueued && timeline.Status != models.JobStatusRunning
+	levels := map[string]int{}
+	var level func(name string, depth int) int
+	level = func(name string, depth int) int {
+		if l, ok := levels[name]; ok || depth > len(stages) {
+			return l
+		}
+		l := 0
+		for _, stage := range stages {
+			if stage.Name != name {
+				continue
+			}
+			for _, dep := range graphDependencies(stage) {
+				if d := level(dep, depth+1) + 1; d > l {
+					l = d
+				}
+			}
+		}
+		levels[name] = l
+		return l
+	}
+
+	nodes := make([]models.GraphNode, 0, len(stages))
+	edges := []models.GraphEdge{}
+	for _, stage := range stages {
+		node := models.GraphNode{
+			Name:      stage.Name,
+			Image:     stage.Image,
+			DependsOn: graphDependencies(stage),
+			Level:     level(stage.Name, 0),
+			Status:    "pending",
+		}
+		if span, ok := spans[stage.Name]; ok {
+			started := span.StartedAt
+			node.StartedAt = &started
+			node.FinishedAt = span.FinishedAt
+			node.DurationMS = span.DurationMS
+			switch {
+			case span.FinishedAt != nil:
+				node.Status = span.Status
+			case finished:
+				node.Status = "interrupted"
+			default:
+				node.Status = "running"
+			}
+		} else if finished {
+			node.Status = "skipped"
+		}
+		for _, dep := range node.DependsOn {
+			edges = append(edges, models.GraphEdge{From: dep, To: stage.Name})
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, edges
+}
+
+// graphDependencies returns the stages a stage follows: those it depends on,
+// or the checkout if none
+func graphDependencies(stage pipeline.Stage) []string {
+	if stage.Name == cloneStage {
+		return []string{}
+	}
+	if len(stage.DependsOn) == 0 {
+		return []string{cloneStage}
+	}
+	return stage.DependsOn
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/images"
+)
+
+// ImageHandler manages the catalog of build images jobs and pipelines run in
+type ImageHandler struct {
+	db   *database.Database
+	auth *auth.Authenticator
+}
+
+// NewImageHandler creates a new build image handler, which authenticates the
+// administrators that manage the catalog
+func NewImageHandler(db *database.Database, authenticator *auth.Authenticator) *ImageHandler {
+	return &ImageHandler{db: db, auth: authenticator}
+}
+
+// imageRequest is the body of the requests creating and updating build images
+type imageRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Image       string   `json:"image"`
+	Tag         string   `json:"tag"`
+	Digest      string   `json:"digest"`
+	Tools       []string `json:"tools"`
+	Maintainer  string   `json:"maintainer"`
+}
+
+// validate returns the problems of the image's settings, defaulting its tag
+// to latest
+func (req *imageRequest) validate() []string {
+	var problems []string
+	if !images.ValidRepository(req.Image) {
+		problems = append(problems, "image: must be an image repository without a tag or digest, such as registry.example.com/ci/golang")
+	}
+	if req.Tag == "" {
+		req.Tag = "latest"
+	}
+	if !images.ValidTag(req.Tag) {
+		problems = append(problems, "tag: must be an image tag")
+	}
+	if req.Digest != "" && !images.ValidDigest(req.Digest) {
+		problems = append(problems, "digest: must be a sha256 digest, such as sha256:4f2a...")
+	}
+	for _, tool := range req.Tools {
+		if strings.TrimSpace(tool) == "" {
+			problems = append(problems, "tools: must not be empty")
+			break
+		}
+	}
+	if req.Tools == nil {
+		req.Tools = []string{}
+	}
+	return problems
+}
+
+// ListImages returns the build images of the catalog
+func (h *ImageHandler) ListImages(w http.ResponseWriter, r *http.Request) {
+	all, err := images.List(r.Context(), h.db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query build images")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch build images")
+		return
+	}
+	SendJSON(w, http.StatusOK, all)
+}
+
+// GetImage returns a build image
+func (h *ImageHandler) GetImage(w http.ResponseWriter, r *http.Request) {
+	h.sendImage(w, r, http.StatusOK, mux.Vars(r)["name"])
+}
+
+// GetImageJobs returns the names of the jobs that run in a build image
+func (h *ImageHandler) GetImageJobs(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, err := images.Get(r.Context(), h.db, name); errors.Is(err, images.ErrNotFound) {
+		SendError(w, http.StatusNotFound, nil, "Build image not found")
+		return
+	} else if err != nil {
+		log.Error().Err(err).Msg("Failed to query build image")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch build image")
+		return
+	}
+
+	jobs, err := images.Jobs(r.Context(), h.db, name)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query jobs of build image")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch jobs")
+		return
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
+
+// CreateImage adds a vetted image to the catalog
+func (h *ImageHandler) CreateImage(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	var req imageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if !images.ValidName(req.Name) {
+		SendError(w, http.StatusBadRequest, nil, "Build image name must be lowercase letters, digits, '.', '_' and '-'")
+		return
+	}
+	if problems := req.validate(); len(problems) > 0 {
+		SendError(w, http.StatusBadRequest, nil, "Invalid build image: "+strings.Join(problems, "; "))
+		return
+	}
+
+	_, err := h.db.GetConn().ExecContext(r.Context(), `
+		INSERT INTO build_images (name, description, image, tag, digest, tools, maintainer, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+	`, req.Name, req.Description, req.Image, req.Tag, req.Digest, pq.Array(req.Tools), req.Maintainer, principal.Username)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		SendError(w, http.StatusConflict, nil, "Build image already exists")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create build image")
+		SendError(w, http.StatusInternalServerError, err, "Failed to create build image")
+		return
+	}
+
+	log.Info().Str("build_image", req.Name).Str("by", principal.Username).Msg("Build image created")
+	h.sendImage(w, r, http.StatusCreated, req.Name)
+}
+
+// UpdateImage replaces the settings of a build image, such as to move it to
+// a new tag. Builds that start after the change run the new image.
+func (h *ImageHandler) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	var req imageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if problems := req.validate(); len(problems) > 0 {
+		SendError(w, http.StatusBadRequest, nil, "Invalid build image: "+strings.Join(problems, "; "))
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE build_images
+		SET description = $2, image = $3, tag = $4, digest = NULLIF($5, ''), tools = $6,
+		    maintainer = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE name = $1
+	`, name, req.Description, req.Image, req.Tag, req.Digest, pq.Array(req.Tools), req.Maintainer)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update build image")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update build image")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Build image not found")
+		return
+	}
+
+	log.Info().Str("build_image", name).Str("by", principal.Username).Msg("Build image updated")
+	h.sendImage(w, r, http.StatusOK, name)
+}
+
+// DeleteImage removes a build image from the catalog, unless a job's build
+// config still runs in it. Pipelines in repositories that name it fail.
+func (h *ImageHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	jobs, err := images.Jobs(r.Context(), h.db, name)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query jobs of build image")
+		SendError(w, http.StatusInternalServerError, err, "Failed to delete build image")
+		return
+	}
+	if len(jobs) > 0 {
+		SendError(w, http.StatusConflict, nil, "Build image is used by jobs: "+strings.Join(jobs, ", "))
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `DELETE FROM build_images WHERE name = $1`, name)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete build image")
+		SendError(w, http.StatusInternalServerError, err, "Failed to delete build image")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Build image not found")
+		return
+	}
+
+	log.Info().Str("build_image", name).Str("by", principal.Username).Msg("Build image deleted")
+	SendJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// sendImage responds with a build image as it is now
+func (h *ImageHandler) sendImage(w http.ResponseWriter, r *http.Request, code int, name string) {
+	img, err := images.Get(r.Context(), h.db, name)
+	if errors.Is(err, images.ErrNotFound) {
+		SendError(w, http.StatusNotFound, nil, "Build image not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query build image")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch build image")
+		return
+	}
+	SendJSON(w, code, img)
+}
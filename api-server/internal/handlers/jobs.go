@@ -3,24 +3,40 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 
+	"github.com/solvyd/solvyd/api-server/internal/auth"
 	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/matchers"
 	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+	"github.com/solvyd/solvyd/api-server/internal/pluginconfig"
+	"github.com/solvyd/solvyd/api-server/internal/pools"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+	"github.com/solvyd/solvyd/api-server/internal/tracing"
+	"github.com/solvyd/solvyd/api-server/internal/triggers"
 )
 
 // JobHandler handles job-related requests
 type JobHandler struct {
-	db *database.Database
+	db       *database.Database
+	settings *settings.Store
+	auth     *auth.Authenticator
 }
 
-// NewJobHandler creates a new job handler
-func NewJobHandler(db *database.Database) *JobHandler {
-	return &JobHandler{db: db}
+// NewJobHandler creates a new job handler, which gives jobs created without
+// a timeout the default of the instance settings and records the users that
+// disable jobs
+func NewJobHandler(db *database.Database, settings *settings.Store, authenticator *auth.Authenticator) *JobHandler {
+	return &JobHandler{db: db, settings: settings, auth: authenticator}
 }
 
 // ListJobs returns all jobs
@@ -31,12 +47,22 @@ func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
 		SELECT id, name, description, scm_type, scm_url, scm_branch, 
 		       build_config, environment_vars, triggers, enabled, 
 		       worker_labels, plugins, pipeline_stages, timeout_minutes, 
-		       max_retries, created_at, updated_at, created_by
+		       max_retries, created_at, updated_at, created_by, COALESCE(project, ''), labels,
+		       notifications, cancel_in_progress, COALESCE(pipeline_file, ''),
+		       queue_paused_at IS NOT NULL, COALESCE(pool, ''),
+		       COALESCE(disabled_reason, ''), COALESCE(disabled_by, ''), disabled_at, reenable_at
 		FROM jobs
-		ORDER BY created_at DESC
 	`
+	args := []interface{}{}
 
-	rows, err := h.db.GetConn().QueryContext(ctx, query)
+	if project := r.URL.Query().Get("project"); project != "" {
+		query += " WHERE project = $1"
+		args = append(args, project)
+	}
+
+	query += " ORDER BY created_at DESC"
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query jobs")
 		SendError(w, http.StatusInternalServerError, err, "Failed to fetch jobs")
@@ -52,7 +78,9 @@ func (h *JobHandler) ListJobs(w http.ResponseWriter, r *http.Request) {
 			&job.SCMBranch, &job.BuildConfig, &job.EnvVars, &job.Triggers,
 			&job.Enabled, &job.WorkerLabels, &job.Plugins, &job.PipelineStages,
 			&job.TimeoutMinutes, &job.MaxRetries, &job.CreatedAt, &job.UpdatedAt,
-			&job.CreatedBy,
+			&job.CreatedBy, &job.Project, &job.Labels, &job.Notifications,
+			&job.CancelInProgress, &job.PipelineFile, &job.QueuePaused, &job.Pool,
+			&job.DisabledReason, &job.DisabledBy, &job.DisabledAt, &job.ReenableAt,
 		)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to scan job row")
@@ -78,7 +106,10 @@ func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
 		SELECT id, name, description, scm_type, scm_url, scm_branch, 
 		       build_config, environment_vars, triggers, enabled, 
 		       worker_labels, plugins, pipeline_stages, timeout_minutes, 
-		       max_retries, created_at, updated_at, created_by
+		       max_retries, created_at, updated_at, created_by, COALESCE(project, ''), labels,
+		       notifications, cancel_in_progress, COALESCE(pipeline_file, ''),
+		       queue_paused_at IS NOT NULL, COALESCE(pool, ''),
+		       COALESCE(disabled_reason, ''), COALESCE(disabled_by, ''), disabled_at, reenable_at
 		FROM jobs
 		WHERE id = $1
 	`
@@ -89,7 +120,9 @@ func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
 		&job.SCMBranch, &job.BuildConfig, &job.EnvVars, &job.Triggers,
 		&job.Enabled, &job.WorkerLabels, &job.Plugins, &job.PipelineStages,
 		&job.TimeoutMinutes, &job.MaxRetries, &job.CreatedAt, &job.UpdatedAt,
-		&job.CreatedBy,
+		&job.CreatedBy, &job.Project, &job.Labels, &job.Notifications,
+		&job.CancelInProgress, &job.PipelineFile, &job.QueuePaused, &job.Pool,
+		&job.DisabledReason, &job.DisabledBy, &job.DisabledAt, &job.ReenableAt,
 	)
 	if err == sql.ErrNoRows {
 		SendError(w, http.StatusNotFound, nil, "Job not found")
@@ -115,19 +148,45 @@ func (h *JobHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate required fields
-	if job.Name == "" {
-		SendError(w, http.StatusBadRequest, nil, "Job name is required")
+	if !validJobName(w, job.Name) {
 		return
 	}
 
+	if err := triggers.Validate(job.Triggers); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid triggers")
+		return
+	}
+	if !h.validatePluginSteps(w, r, job.Plugins, job.Notifications) {
+		return
+	}
+	if job.PipelineFile != "" && !pipeline.ValidFile(job.PipelineFile) {
+		SendError(w, http.StatusBadRequest, nil, "pipeline_file must be a relative path inside the repository")
+		return
+	}
+	if !h.validatePool(w, r, job.Pool) {
+		return
+	}
+	if !h.validateBuildImages(w, r, job.BuildConfig) {
+		return
+	}
+	if !validateProblemMatchers(w, job.BuildConfig) {
+		return
+	}
+
+	if job.TimeoutMinutes == 0 {
+		job.TimeoutMinutes = h.settings.Current().DefaultTimeoutMinutes
+	}
+
 	job.ID = uuid.New()
 
 	query := `
 		INSERT INTO jobs (id, name, description, scm_type, scm_url, scm_branch,
 		                  build_config, environment_vars, triggers, enabled,
 		                  worker_labels, plugins, pipeline_stages, timeout_minutes,
-		                  max_retries, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+		                  max_retries, created_by, project, labels, notifications, cancel_in_progress,
+		                  pipeline_file, pool)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''), $18, $19, $20,
+		        NULLIF($21, ''), NULLIF($22, ''))
 		RETURNING created_at, updated_at
 	`
 
@@ -135,7 +194,8 @@ func (h *JobHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
 		job.ID, job.Name, job.Description, job.SCMType, job.SCMURL, job.SCMBranch,
 		job.BuildConfig, job.EnvVars, job.Triggers, job.Enabled,
 		job.WorkerLabels, job.Plugins, job.PipelineStages, job.TimeoutMinutes,
-		job.MaxRetries, job.CreatedBy,
+		job.MaxRetries, job.CreatedBy, job.Project, job.Labels, job.Notifications,
+		job.CancelInProgress, job.PipelineFile, job.Pool,
 	).Scan(&job.CreatedAt, &job.UpdatedAt)
 
 	if err != nil {
@@ -164,12 +224,62 @@ func (h *JobHandler) UpdateJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !validJobName(w, job.Name) {
+		return
+	}
+	if err := triggers.Validate(job.Triggers); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid triggers")
+		return
+	}
+	if !h.validatePluginSteps(w, r, job.Plugins, job.Notifications) {
+		return
+	}
+	if job.PipelineFile != "" && !pipeline.ValidFile(job.PipelineFile) {
+		SendError(w, http.StatusBadRequest, nil, "pipeline_file must be a relative path inside the repository")
+		return
+	}
+	if !h.validatePool(w, r, job.Pool) {
+		return
+	}
+	if !h.validateBuildImages(w, r, job.BuildConfig) {
+		return
+	}
+	if !validateProblemMatchers(w, job.BuildConfig) {
+		return
+	}
+
+	// Disabling a job takes a reason, which only the disable endpoint records
+	if !job.Enabled {
+		var enabled bool
+		err := h.db.GetConn().QueryRowContext(ctx, `SELECT enabled FROM jobs WHERE id = $1`, jobID).Scan(&enabled)
+		if err == sql.ErrNoRows {
+			SendError(w, http.StatusNotFound, nil, "Job not found")
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to query job")
+			SendError(w, http.StatusInternalServerError, err, "Failed to update job")
+			return
+		}
+		if enabled {
+			SendError(w, http.StatusUnprocessableEntity, nil, "Disabling a job requires a reason: use POST /api/v1/jobs/{id}/disable")
+			return
+		}
+	}
+
+	// Enabling a job clears why it was disabled
 	query := `
 		UPDATE jobs
 		SET name = $2, description = $3, scm_type = $4, scm_url = $5, scm_branch = $6,
 		    build_config = $7, environment_vars = $8, triggers = $9, enabled = $10,
 		    worker_labels = $11, plugins = $12, pipeline_stages = $13,
-		    timeout_minutes = $14, max_retries = $15
+		    timeout_minutes = $14, max_retries = $15, project = NULLIF($16, ''), labels = $17,
+		    notifications = $18, cancel_in_progress = $19, pipeline_file = NULLIF($20, ''),
+		    pool = NULLIF($21, ''),
+		    disabled_reason = CASE WHEN $10 THEN NULL ELSE disabled_reason END,
+		    disabled_by = CASE WHEN $10 THEN NULL ELSE disabled_by END,
+		    disabled_at = CASE WHEN $10 THEN NULL ELSE disabled_at END,
+		    reenable_at = CASE WHEN $10 THEN NULL ELSE reenable_at END
 		WHERE id = $1
 	`
 
@@ -177,7 +287,8 @@ func (h *JobHandler) UpdateJob(w http.ResponseWriter, r *http.Request) {
 		jobID, job.Name, job.Description, job.SCMType, job.SCMURL, job.SCMBranch,
 		job.BuildConfig, job.EnvVars, job.Triggers, job.Enabled,
 		job.WorkerLabels, job.Plugins, job.PipelineStages, job.TimeoutMinutes,
-		job.MaxRetries,
+		job.MaxRetries, job.Project, job.Labels, job.Notifications,
+		job.CancelInProgress, job.PipelineFile, job.Pool,
 	)
 
 	if err != nil {
@@ -225,7 +336,10 @@ func (h *JobHandler) DeleteJob(w http.ResponseWriter, r *http.Request) {
 	SendJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
-// TriggerJob triggers a manual build for a job
+// TriggerJob triggers a manual build for a job, of a branch or, with tag, a
+// tag. The job's manual triggers may restrict which branches and tags can be
+// built. If the job has cancel_in_progress set, the build cancels the
+// in-progress builds of its branch.
 func (h *JobHandler) TriggerJob(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
@@ -239,27 +353,83 @@ func (h *JobHandler) TriggerJob(w http.ResponseWriter, r *http.Request) {
 	var params struct {
 		Parameters map[string]interface{} `json:"parameters"`
 		Branch     string                 `json:"branch"`
+		Tag        string                 `json:"tag"`
+		Tags       []string               `json:"tags"` // build tags, unlike tag
 	}
 	json.NewDecoder(r.Body).Decode(&params)
+	if err := validateTags(params.Tags); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid tags")
+		return
+	}
+
+	var jobTriggers models.JSONArray
+	var defaultBranch string
+	var cancelPrevious bool
+	var disabled jobDisabled
+	err = h.db.GetConn().QueryRowContext(ctx, `
+		SELECT triggers, COALESCE(scm_branch, ''), cancel_in_progress,
+		       NOT enabled, COALESCE(disabled_reason, ''), reenable_at
+		FROM jobs
+		WHERE id = $1
+	`, jobID).Scan(&jobTriggers, &defaultBranch, &cancelPrevious, &disabled.disabled, &disabled.reason, &disabled.reenableAt)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query job")
+		SendError(w, http.StatusInternalServerError, err, "Failed to trigger build")
+		return
+	}
+	if disabled.disabled {
+		SendError(w, http.StatusConflict, nil, disabled.String())
+		return
+	}
+
+	ref := triggers.Ref{Branch: params.Branch, Tag: params.Tag}
+	if ref.Tag == "" && ref.Branch == "" {
+		ref.Branch = defaultBranch
+	}
+	if ok, reason := triggers.Allowed(jobTriggers, triggers.TypeManual, ref); !ok {
+		SendError(w, http.StatusUnprocessableEntity, nil, "Build not allowed by the job's manual triggers: "+reason)
+		return
+	}
+	if params.Tag != "" {
+		// The SCM plugin checks out the tag instead of the branch
+		if params.Parameters == nil {
+			params.Parameters = map[string]interface{}{}
+		}
+		params.Parameters["tag"] = params.Tag
+	}
 
 	// Create a new build
 	buildID := uuid.New()
 
 	query := `
-		INSERT INTO builds (id, job_id, status, triggered_by, parameters, branch)
-		VALUES ($1, $2, 'queued', 'manual', $3, $4)
+		INSERT INTO builds (id, job_id, status, triggered_by, parameters, branch, trace_context, tags)
+		VALUES ($1, $2, 'queued', 'manual', $3, $4, $5, ARRAY(SELECT DISTINCT unnest($6::text[]) ORDER BY 1))
 		RETURNING id, build_number, queued_at
 	`
 
 	paramsJSON, _ := json.Marshal(params.Parameters)
+	traceJSON, _ := json.Marshal(tracing.Carrier(ctx))
 	var build struct {
 		ID          uuid.UUID `json:"id"`
 		BuildNumber int       `json:"build_number"`
 		QueuedAt    string    `json:"queued_at"`
 	}
 
-	err = h.db.GetConn().QueryRowContext(ctx, query, buildID, jobID, paramsJSON, params.Branch).
-		Scan(&build.ID, &build.BuildNumber, &build.QueuedAt)
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, buildID, jobID, paramsJSON, ref.Branch, traceJSON, pq.Array(params.Tags)).
+			Scan(&build.ID, &build.BuildNumber, &build.QueuedAt)
+		if err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, build.ID, "build.queued", map[string]interface{}{
+			"build_id": build.ID, "job_id": jobID, "build_number": build.BuildNumber,
+			"branch": ref.Branch, "triggered_by": "manual", "queued_at": build.QueuedAt,
+		})
+	})
 
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to trigger build")
@@ -273,5 +443,110 @@ func (h *JobHandler) TriggerJob(w http.ResponseWriter, r *http.Request) {
 		Int("build_number", build.BuildNumber).
 		Msg("Build triggered")
 
+	if cancelPrevious && ref.Tag == "" {
+		supersede(ctx, h.db, buildScope{jobID: jobID, branch: ref.Branch}, build.ID)
+	}
+
 	SendJSON(w, http.StatusCreated, build)
 }
+
+// validJobName checks a job name, writing the error response if it is
+// invalid. Names may not contain ':', which separates the parts of the
+// subject of the job's identity tokens.
+func validJobName(w http.ResponseWriter, name string) bool {
+	if name == "" {
+		SendError(w, http.StatusBadRequest, nil, "Job name is required")
+		return false
+	}
+	if strings.Contains(name, ":") {
+		SendError(w, http.StatusBadRequest, nil, "Job name may not contain ':'")
+		return false
+	}
+	return true
+}
+
+// validatePool checks that the pool a job targets exists, sending a 422 and
+// returning false if not. An empty pool is the default pool.
+func (h *JobHandler) validatePool(w http.ResponseWriter, r *http.Request, pool string) bool {
+	if pool == "" {
+		return true
+	}
+	exists, err := pools.Exists(r.Context(), h.db, pool)
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to validate pool")
+		return false
+	}
+	if !exists {
+		SendError(w, http.StatusUnprocessableEntity, nil, "Unknown worker pool: "+pool)
+		return false
+	}
+	return true
+}
+
+// validateBuildImages checks that the build images a job's build config and
+// its stages name are in the catalog, sending a 422 listing every problem and
+// returning false if not
+func (h *JobHandler) validateBuildImages(w http.ResponseWriter, r *http.Request, buildConfig models.JSONB) bool {
+	problems, err := pipeline.CheckImages(r.Context(), h.db, buildConfig)
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to validate build images")
+		return false
+	}
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:   "unknown or conflicting build images",
+			Message: "Invalid build images",
+			Code:    http.StatusUnprocessableEntity,
+			Details: problems,
+		})
+		return false
+	}
+	return true
+}
+
+// validateProblemMatchers checks the problem matchers of a job's build config,
+// sending a 422 listing every problem and returning false if one is invalid
+func validateProblemMatchers(w http.ResponseWriter, buildConfig models.JSONB) bool {
+	_, problems := matchers.Parse(buildConfig[matchers.Field], "build_config."+matchers.Field)
+	if len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:   "invalid problem matchers",
+			Message: "Invalid problem matchers",
+			Code:    http.StatusUnprocessableEntity,
+			Details: problems,
+		})
+		return false
+	}
+	return true
+}
+
+// validatePluginSteps checks the config of each plugin step and notification
+// against the installed plugin's schema, and the notifications' rules. On
+// failure it sends a 422 listing every problem and returns false.
+func (h *JobHandler) validatePluginSteps(w http.ResponseWriter, r *http.Request, plugins, notifications models.JSONArray) bool {
+	err := pluginconfig.ValidateSteps(r.Context(), h.db, plugins)
+	if err == nil {
+		err = pluginconfig.ValidateNotifications(r.Context(), h.db, notifications)
+	}
+	var invalid *pluginconfig.ValidationError
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Invalid plugin configuration",
+			Code:    http.StatusUnprocessableEntity,
+			Details: invalid.Problems,
+		})
+		return false
+	}
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to validate plugin configuration")
+		return false
+	}
+	return true
+}
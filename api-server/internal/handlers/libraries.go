@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/libraries"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+)
+
+// LibraryHandler manages the pipeline libraries pipelines include templates
+// from
+type LibraryHandler struct {
+	db   *database.Database
+	auth *auth.Authenticator
+}
+
+// NewLibraryHandler creates a new library handler, which authenticates the
+// administrators that manage libraries
+func NewLibraryHandler(db *database.Database, authenticator *auth.Authenticator) *LibraryHandler {
+	return &LibraryHandler{db: db, auth: authenticator}
+}
+
+// libraryRequest is the body of the requests creating and updating libraries
+type libraryRequest struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Repository   string `json:"repository"`
+	GitOpsSource string `json:"gitops_source"`
+	Credentials  string `json:"credentials"`
+	Path         string `json:"path"`
+	DefaultRef   string `json:"default_ref"`
+}
+
+// validate returns the problems of the library's settings, defaulting its
+// ref to main
+func (req *libraryRequest) validate() []string {
+	var problems []string
+	if (req.Repository == "") == (req.GitOpsSource == "") {
+		problems = append(problems, "exactly one of repository and gitops_source must be set")
+	}
+	if req.Credentials != "" && req.Repository == "" {
+		problems = append(problems, "credentials: only a repository is cloned with credentials; a GitOps source uses its own")
+	}
+	req.Path = strings.Trim(req.Path, "/")
+	if req.Path != "" && !pipeline.ValidFile(req.Path) {
+		problems = append(problems, "path: must be a directory inside the repository")
+	}
+	if req.DefaultRef == "" {
+		req.DefaultRef = "main"
+	}
+	return problems
+}
+
+// ListLibraries returns the pipeline libraries
+func (h *LibraryHandler) ListLibraries(w http.ResponseWriter, r *http.Request) {
+	all, err := libraries.List(r.Context(), h.db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query pipeline libraries")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch libraries")
+		return
+	}
+	SendJSON(w, http.StatusOK, all)
+}
+
+// GetLibrary returns a pipeline library
+func (h *LibraryHandler) GetLibrary(w http.ResponseWriter, r *http.Request) {
+	h.sendLibrary(w, r, http.StatusOK, mux.Vars(r)["name"])
+}
+
+// CreateLibrary registers a repository, or the repository of a GitOps
+// source, as a pipeline library
+func (h *LibraryHandler) CreateLibrary(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	var req libraryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if !libraries.ValidName(req.Name) {
+		SendError(w, http.StatusBadRequest, nil, "Library name must be lowercase letters, digits, '.', '_' and '-'")
+		return
+	}
+	if problems := req.validate(); len(problems) > 0 {
+		SendError(w, http.StatusBadRequest, nil, "Invalid library: "+strings.Join(problems, "; "))
+		return
+	}
+
+	_, err := h.db.GetConn().ExecContext(r.Context(), `
+		INSERT INTO pipeline_libraries (name, description, repository, gitops_source, credentials, path, default_ref, created_by)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8)
+	`, req.Name, req.Description, req.Repository, req.GitOpsSource, req.Credentials, req.Path, req.DefaultRef, principal.Username)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		SendError(w, http.StatusConflict, nil, "Library already exists")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create pipeline library")
+		SendError(w, http.StatusInternalServerError, err, "Failed to create library")
+		return
+	}
+
+	log.Info().Str("library", req.Name).Str("by", principal.Username).Msg("Pipeline library created")
+	h.sendLibrary(w, r, http.StatusCreated, req.Name)
+}
+
+// UpdateLibrary replaces the settings of a pipeline library. Pipelines pick
+// up the change with their next build.
+func (h *LibraryHandler) UpdateLibrary(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	var req libraryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if problems := req.validate(); len(problems) > 0 {
+		SendError(w, http.StatusBadRequest, nil, "Invalid library: "+strings.Join(problems, "; "))
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE pipeline_libraries
+		SET description = $2, repository = NULLIF($3, ''), gitops_source = NULLIF($4, ''),
+		    credentials = NULLIF($5, ''), path = $6, default_ref = $7, updated_at = CURRENT_TIMESTAMP
+		WHERE name = $1
+	`, name, req.Description, req.Repository, req.GitOpsSource, req.Credentials, req.Path, req.DefaultRef)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update pipeline library")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update library")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Library not found")
+		return
+	}
+
+	log.Info().Str("library", name).Str("by", principal.Username).Msg("Pipeline library updated")
+	h.sendLibrary(w, r, http.StatusOK, name)
+}
+
+// DeleteLibrary deletes a pipeline library; builds of pipelines that still
+// include its templates fail
+func (h *LibraryHandler) DeleteLibrary(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `DELETE FROM pipeline_libraries WHERE name = $1`, name)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete pipeline library")
+		SendError(w, http.StatusInternalServerError, err, "Failed to delete library")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Library not found")
+		return
+	}
+
+	log.Info().Str("library", name).Str("by", principal.Username).Msg("Pipeline library deleted")
+	SendJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// sendLibrary responds with a library as it is now
+func (h *LibraryHandler) sendLibrary(w http.ResponseWriter, r *http.Request, code int, name string) {
+	lib, err := libraries.Get(r.Context(), h.db, name)
+	if errors.Is(err, libraries.ErrNotFound) {
+		SendError(w, http.StatusNotFound, nil, "Library not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query pipeline library")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch library")
+		return
+	}
+	SendJSON(w, code, lib)
+}
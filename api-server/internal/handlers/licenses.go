@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// licenseEcosystems are the package ecosystems the license-compliance plugin
+// scans
+var licenseEcosystems = map[string]bool{"": true, "npm": true, "golang": true, "maven": true}
+
+// licenseExceptionColumns are the columns scanned by scanLicenseException. An
+// approved exception past its expiry is reported as expired.
+const licenseExceptionColumns = `
+	id, ecosystem, package, version, license, justification,
+	CASE WHEN status = 'approved' AND expires_at <= NOW() THEN 'expired' ELSE status END,
+	COALESCE(requested_by, ''), requested_at, COALESCE(decided_by, ''), decided_at,
+	COALESCE(decision_note, ''), expires_at`
+
+func scanLicenseException(row interface{ Scan(...interface{}) error }) (models.LicenseException, error) {
+	var e models.LicenseException
+	err := row.Scan(&e.ID, &e.Ecosystem, &e.Package, &e.Version, &e.License, &e.Justification,
+		&e.Status, &e.RequestedBy, &e.RequestedAt, &e.DecidedBy, &e.DecidedAt,
+		&e.DecisionNote, &e.ExpiresAt)
+	return e, err
+}
+
+// LicenseExceptionHandler handles the requests for and decisions on license
+// exceptions
+type LicenseExceptionHandler struct {
+	db   *database.Database
+	auth *auth.Authenticator
+}
+
+// NewLicenseExceptionHandler creates a new license exception handler
+func NewLicenseExceptionHandler(db *database.Database, authenticator *auth.Authenticator) *LicenseExceptionHandler {
+	return &LicenseExceptionHandler{db: db, auth: authenticator}
+}
+
+// ListLicenseExceptions returns license exceptions, newest first, filtered by
+// status (pending, approved, rejected or expired), package and version
+func (h *LicenseExceptionHandler) ListLicenseExceptions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := `SELECT ` + licenseExceptionColumns + ` FROM license_exceptions WHERE 1=1`
+	args := []interface{}{}
+	switch status := q.Get("status"); status {
+	case "":
+	case "approved":
+		query += ` AND status = 'approved' AND expires_at > NOW()`
+	case "expired":
+		query += ` AND status = 'approved' AND expires_at <= NOW()`
+	case "pending", "rejected":
+		args = append(args, status)
+		query += ` AND status = $1`
+	default:
+		SendError(w, http.StatusBadRequest, nil, "Invalid status (want pending, approved, rejected or expired)")
+		return
+	}
+	if v := q.Get("package"); v != "" {
+		args = append(args, v)
+		query += ` AND package = $` + strconv.Itoa(len(args))
+	}
+	if v := q.Get("version"); v != "" {
+		args = append(args, v)
+		query += ` AND version = $` + strconv.Itoa(len(args))
+	}
+	query += ` ORDER BY requested_at DESC`
+
+	rows, err := h.db.GetConn().QueryContext(r.Context(), query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query license exceptions")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch license exceptions")
+		return
+	}
+	defer rows.Close()
+
+	exceptions := []models.LicenseException{}
+	for rows.Next() {
+		e, err := scanLicenseException(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan license exception row")
+			continue
+		}
+		exceptions = append(exceptions, e)
+	}
+
+	SendJSON(w, http.StatusOK, exceptions)
+}
+
+// GetLicenseException returns a license exception
+func (h *LicenseExceptionHandler) GetLicenseException(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid license exception ID")
+		return
+	}
+
+	e, err := scanLicenseException(h.db.GetConn().QueryRowContext(r.Context(),
+		`SELECT `+licenseExceptionColumns+` FROM license_exceptions WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "License exception not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query license exception")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch license exception")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, e)
+}
+
+// RequestLicenseException requests an exception for a package version, as
+// {"package", "version", "license", "ecosystem", "justification"}. It applies
+// once the compliance role approves it.
+func (h *LicenseExceptionHandler) RequestLicenseException(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authenticate(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Ecosystem     string `json:"ecosystem"`
+		Package       string `json:"package"`
+		Version       string `json:"version"`
+		License       string `json:"license"`
+		Justification string `json:"justification"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	req.Package = strings.TrimSpace(req.Package)
+	req.Version = strings.TrimSpace(req.Version)
+	req.Justification = strings.TrimSpace(req.Justification)
+	switch {
+	case req.Package == "":
+		SendError(w, http.StatusBadRequest, nil, "package is required")
+		return
+	case req.Version == "":
+		SendError(w, http.StatusBadRequest, nil, "version is required")
+		return
+	case req.Justification == "":
+		SendError(w, http.StatusBadRequest, nil, "justification is required")
+		return
+	case !licenseEcosystems[req.Ecosystem]:
+		SendError(w, http.StatusBadRequest, nil, "Invalid ecosystem (want npm, golang or maven)")
+		return
+	}
+
+	var id uuid.UUID
+	err := h.db.GetConn().QueryRowContext(r.Context(), `
+		INSERT INTO license_exceptions (ecosystem, package, version, license, justification, requested_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		RETURNING id
+	`, req.Ecosystem, req.Package, req.Version, strings.TrimSpace(req.License), req.Justification, principal.Username).Scan(&id)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create license exception")
+		SendError(w, http.StatusInternalServerError, err, "Failed to request license exception")
+		return
+	}
+
+	log.Info().
+		Str("exception_id", id.String()).
+		Str("package", req.Package+"@"+req.Version).
+		Str("by", principal.Username).
+		Msg("License exception requested")
+	h.sendLicenseException(w, r, id, http.StatusCreated)
+}
+
+// ApproveLicenseException approves a pending license exception until
+// expires_at, as {"expires_at": RFC 3339 time, "note": string}. Only the
+// compliance role may approve.
+func (h *LicenseExceptionHandler) ApproveLicenseException(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		Note      string     `json:"note"`
+	}
+	h.decide(w, r, &req, func() (string, string, *time.Time, bool) {
+		if req.ExpiresAt == nil || !req.ExpiresAt.After(time.Now()) {
+			SendError(w, http.StatusBadRequest, nil, "expires_at is required and must be in the future")
+			return "", "", nil, false
+		}
+		return "approved", strings.TrimSpace(req.Note), req.ExpiresAt, true
+	})
+}
+
+// RejectLicenseException rejects a pending license exception, as
+// {"note": string}. Only the compliance role may reject.
+func (h *LicenseExceptionHandler) RejectLicenseException(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	h.decide(w, r, &req, func() (string, string, *time.Time, bool) {
+		if strings.TrimSpace(req.Note) == "" {
+			SendError(w, http.StatusBadRequest, nil, "note is required")
+			return "", "", nil, false
+		}
+		return "rejected", strings.TrimSpace(req.Note), nil, true
+	})
+}
+
+// decide records the compliance role's decision on a pending exception. It
+// decodes the body into req and asks decision for the status, note and expiry
+// to record, which writes the error response if the request is invalid.
+func (h *LicenseExceptionHandler) decide(w http.ResponseWriter, r *http.Request, req interface{},
+	decision func() (string, string, *time.Time, bool)) {
+	principal, ok := authorizeCompliance(h.auth, w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid license exception ID")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	status, note, expiresAt, ok := decision()
+	if !ok {
+		return
+	}
+
+	var current string
+	err = h.db.WithTransaction(r.Context(), func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(r.Context(),
+			`SELECT status FROM license_exceptions WHERE id = $1 FOR UPDATE`, id).Scan(&current)
+		if err != nil || current != "pending" {
+			return err
+		}
+		_, err = tx.ExecContext(r.Context(), `
+			UPDATE license_exceptions
+			SET status = $2, decided_by = NULLIF($3, ''), decided_at = CURRENT_TIMESTAMP,
+			    decision_note = NULLIF($4, ''), expires_at = $5
+			WHERE id = $1
+		`, id, status, principal.Username, note, expiresAt)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "License exception not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to decide license exception")
+		SendError(w, http.StatusInternalServerError, err, "Failed to decide license exception")
+		return
+	}
+	if current != "pending" {
+		SendError(w, http.StatusConflict, nil, "License exception is already "+current)
+		return
+	}
+
+	log.Info().
+		Str("exception_id", id.String()).
+		Str("status", status).
+		Str("by", principal.Username).
+		Msg("License exception decided")
+	h.sendLicenseException(w, r, id, http.StatusOK)
+}
+
+// RevokeLicenseException deletes a license exception, so builds fail on its
+// package's license again. Only the compliance role may revoke.
+func (h *LicenseExceptionHandler) RevokeLicenseException(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeCompliance(h.auth, w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid license exception ID")
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `DELETE FROM license_exceptions WHERE id = $1`, id)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete license exception")
+		SendError(w, http.StatusInternalServerError, err, "Failed to revoke license exception")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		SendError(w, http.StatusNotFound, nil, "License exception not found")
+		return
+	}
+
+	log.Info().Str("exception_id", id.String()).Str("by", principal.Username).Msg("License exception revoked")
+	SendJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (h *LicenseExceptionHandler) sendLicenseException(w http.ResponseWriter, r *http.Request, id uuid.UUID, code int) {
+	e, err := scanLicenseException(h.db.GetConn().QueryRowContext(r.Context(),
+		`SELECT `+licenseExceptionColumns+` FROM license_exceptions WHERE id = $1`, id))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query license exception")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch license exception")
+		return
+	}
+	SendJSON(w, code, e)
+}
+
+// authorizeCompliance authenticates the request and checks that it is made
+// by the compliance role or an administrator, writing the error response if
+// not
+func authorizeCompliance(a *auth.Authenticator, w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, ok := authenticate(a, w, r)
+	if !ok {
+		return nil, false
+	}
+	if !principal.HasRole(auth.RoleCompliance) && !principal.IsAdmin() {
+		SendError(w, http.StatusForbidden, nil, "Compliance role required")
+		return nil, false
+	}
+	return principal, true
+}
+
+// activeLicenseExceptions returns the approved, unexpired license exceptions,
+// which the license-compliance plugin consults before failing a build
+func activeLicenseExceptions(ctx context.Context, db *database.Database) ([]map[string]interface{}, error) {
+	rows, err := db.GetConn().QueryContext(ctx, `
+		SELECT ecosystem, package, version, license, justification, expires_at
+		FROM license_exceptions
+		WHERE status = 'approved' AND expires_at > NOW()
+		ORDER BY package, version
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exceptions := []map[string]interface{}{}
+	for rows.Next() {
+		var ecosystem, pkg, version, license, justification string
+		var expiresAt time.Time
+		if err := rows.Scan(&ecosystem, &pkg, &version, &license, &justification, &expiresAt); err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, map[string]interface{}{
+			"ecosystem":     ecosystem,
+			"package":       pkg,
+			"version":       version,
+			"license":       license,
+			"justification": justification,
+			"expires_at":    expiresAt,
+		})
+	}
+	return exceptions, rows.Err()
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/logingest"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// LogHandler serves build logs: it stores the lines worker agents send, in
+// the API server or an ingest replica, and returns them to clients
+type LogHandler struct {
+	db       *database.Database
+	ingester *logingest.Ingester
+}
+
+// NewLogHandler creates a new log handler, storing lines with ingester
+func NewLogHandler(db *database.Database, ingester *logingest.Ingester) *LogHandler {
+	return &LogHandler{db: db, ingester: ingester}
+}
+
+// GetBuildLogs returns build logs, optionally only those after a sequence number
+func (h *LogHandler) GetBuildLogs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	// Clients following a running build pass the last sequence number they have
+	after := 0
+	if v := r.URL.Query().Get("after"); v != "" {
+		if after, err = strconv.Atoi(v); err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid after parameter")
+			return
+		}
+	}
+
+	query := `
+		SELECT sequence_number, timestamp, log_line, stream
+		FROM build_logs
+		WHERE build_id = $1 AND sequence_number > $2
+		  AND build_queued_at = (SELECT queued_at FROM builds WHERE id = $1)
+		ORDER BY sequence_number ASC
+	`
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, buildID, after)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query build logs")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch logs")
+		return
+	}
+	defer rows.Close()
+
+	logs := []models.BuildLog{}
+	for rows.Next() {
+		var log models.BuildLog
+		err := rows.Scan(&log.SequenceNumber, &log.Timestamp, &log.LogLine, &log.Stream)
+		if err != nil {
+			continue
+		}
+		log.BuildID = buildID
+		logs = append(logs, log)
+	}
+
+	SendJSON(w, http.StatusOK, logs)
+}
+
+// maxLogBatchBytes limits the size of one AppendBuildLogs request
+const maxLogBatchBytes = 8 << 20
+
+// AppendBuildLogs stores log lines sent by the worker running a build, and the
+// annotations the build's problem matchers find in them. Workers number lines
+// themselves, so a retried batch is not stored twice.
+func (h *LogHandler) AppendBuildLogs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		Lines []models.BuildLog `json:"lines"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLogBatchBytes)).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if len(req.Lines) == 0 {
+		SendJSON(w, http.StatusOK, map[string]interface{}{"stored": 0})
+		return
+	}
+
+	for i := range req.Lines {
+		line := &req.Lines[i]
+		if line.SequenceNumber <= 0 {
+			SendError(w, http.StatusBadRequest, nil, "Log lines need a positive sequence_number")
+			return
+		}
+		if line.Timestamp.IsZero() {
+			line.Timestamp = time.Now()
+		}
+		if line.Stream != "stderr" {
+			line.Stream = "stdout"
+		}
+	}
+
+	stored, err := h.ingester.Append(ctx, buildID, req.Lines)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to store build logs")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store logs")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{"stored": len(stored)})
+}
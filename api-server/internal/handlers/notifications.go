@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/notifications"
+)
+
+// GetBuildNotifications evaluates the job's notification rules against the
+// build's outcome and returns the notification steps that fire, for the worker
+// agent to run once the build completes. With ?status= the rules are evaluated
+// for that status instead of the stored one, as the agent asks before it
+// reports the final status.
+func (h *BuildHandler) GetBuildNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var jobID uuid.UUID
+	var buildNumber int
+	var outcome notifications.Outcome
+	var rules models.JSONArray
+	var startedAt sql.NullTime
+	query := `
+		SELECT b.job_id, b.build_number, b.status, COALESCE(b.branch, ''),
+		       COALESCE(b.parameters->>'environment', j.labels->>'environment', ''),
+		       j.notifications, b.started_at
+		FROM builds b
+		JOIN jobs j ON b.job_id = j.id
+		WHERE b.id = $1
+	`
+	err = h.db.GetConn().QueryRowContext(ctx, query, buildID).Scan(
+		&jobID, &buildNumber, &outcome.Status, &outcome.Branch, &outcome.Environment, &rules, &startedAt,
+	)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to query build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to evaluate notifications")
+		return
+	}
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		switch status {
+		case "success", "failure", "cancelled":
+			outcome.Status = status
+		default:
+			SendError(w, http.StatusBadRequest, nil, "Invalid status value")
+			return
+		}
+	}
+
+	// First failures and recoveries compare with the last completed build
+	// of the job on the same branch
+	query = `
+		SELECT status FROM builds
+		WHERE job_id = $1 AND COALESCE(branch, '') = $2 AND build_number < $3
+		  AND status IN ('success', 'failure', 'failed', 'timeout')
+		ORDER BY build_number DESC
+		LIMIT 1
+	`
+	err = h.db.GetConn().QueryRowContext(ctx, query, jobID, outcome.Branch, buildNumber).Scan(&outcome.PreviousStatus)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to query previous build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to evaluate notifications")
+		return
+	}
+
+	if outcome.Status == "success" {
+		query = `
+			SELECT EXISTS (
+				SELECT 1 FROM build_test_results
+				WHERE build_id = $1 AND status IN ('failed', 'error')
+			)
+		`
+		if err := h.db.GetConn().QueryRowContext(ctx, query, buildID).Scan(&outcome.Unstable); err != nil {
+			log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to query test results")
+			SendError(w, http.StatusInternalServerError, err, "Failed to evaluate notifications")
+			return
+		}
+	}
+	// Builds markedly slower than the job's recent ones can count as
+	// unstable too; the agent asks before it reports the duration, so the
+	// build is timed from its start
+	if outcome.Status == "success" && !outcome.Unstable && startedAt.Valid && h.settings.Current().DurationRegressionUnstable {
+		seconds := int(time.Since(startedAt.Time).Seconds())
+		_, regressed, err := h.durationRegression(ctx, h.db.GetConn(), jobID, buildNumber, seconds)
+		if err != nil {
+			log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to query the duration baseline")
+			SendError(w, http.StatusInternalServerError, err, "Failed to evaluate notifications")
+			return
+		}
+		outcome.Unstable = regressed
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"status":          outcome.Status,
+		"previous_status": outcome.PreviousStatus,
+		"events":          outcome.Events(),
+		"notifications":   notifications.Select(rules, outcome, h.settings.Current().NotificationEvents),
+	})
+}
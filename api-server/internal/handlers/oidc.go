@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/oidc"
+)
+
+// Environment variables a build requests identity tokens with
+const (
+	oidcRequestURLVar   = "SOLVYD_OIDC_REQUEST_URL"
+	oidcRequestTokenVar = "SOLVYD_OIDC_REQUEST_TOKEN"
+)
+
+// maxAudienceLength limits the audience a token is requested for
+const maxAudienceLength = 255
+
+// OIDCHandler serves the OIDC discovery documents and issues identity
+// tokens to running builds
+type OIDCHandler struct {
+	db       *database.Database
+	provider *oidc.Provider
+}
+
+// NewOIDCHandler creates a new OIDC handler
+func NewOIDCHandler(db *database.Database, provider *oidc.Provider) *OIDCHandler {
+	return &OIDCHandler{db: db, provider: provider}
+}
+
+// GetDiscovery returns the OpenID Provider metadata
+func (h *OIDCHandler) GetDiscovery(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, http.StatusOK, h.provider.Discovery())
+}
+
+// GetJWKS returns the key tokens are signed with
+func (h *OIDCHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, http.StatusOK, h.provider.JWKS())
+}
+
+// IssueBuildToken issues an identity token for ?audience= (the issuer by
+// default) to a running build. The request is authenticated with the
+// build's request token, handed out with the build in
+// SOLVYD_OIDC_REQUEST_TOKEN.
+func (h *OIDCHandler) IssueBuildToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+	audience := r.URL.Query().Get("audience")
+	if len(audience) > maxAudienceLength {
+		SendError(w, http.StatusBadRequest, nil, "Audience is too long")
+		return
+	}
+
+	var tokenHash sql.NullString
+	var status, defaultBranch string
+	var claims oidc.Claims
+	err = h.db.GetConn().QueryRowContext(ctx, `
+		SELECT b.oidc_request_token_hash, b.status, b.build_number, COALESCE(b.branch, ''),
+		       COALESCE(b.scm_commit_sha, ''), COALESCE(b.triggered_by, ''),
+		       j.id, j.name, COALESCE(j.project, ''), COALESCE(j.build_config->>'deploy_environment', ''),
+		       COALESCE(j.scm_branch, '')
+		FROM builds b
+		JOIN jobs j ON j.id = b.job_id
+		WHERE b.id = $1
+	`, buildID).Scan(&tokenHash, &status, &claims.BuildNumber, &claims.Branch,
+		&claims.CommitSHA, &claims.TriggeredBy,
+		&claims.JobID, &claims.Job, &claims.Project, &claims.Environment, &defaultBranch)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to query build for identity token")
+		SendError(w, http.StatusInternalServerError, err, "Failed to issue identity token")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(auth.TokenFromRequest(r)))
+	if !tokenHash.Valid || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(tokenHash.String)) != 1 {
+		SendError(w, http.StatusUnauthorized, nil, "Invalid build request token")
+		return
+	}
+	if status != "running" {
+		SendError(w, http.StatusForbidden, nil, "Identity tokens are only issued to running builds")
+		return
+	}
+
+	claims.DeploysFrom(defaultBranch)
+	if !claims.Representable() {
+		SendError(w, http.StatusForbidden, nil, "The job name, environment or branch contains ':', which identity tokens cannot represent")
+		return
+	}
+
+	claims.BuildID = buildID.String()
+	token, expires, err := h.provider.Issue(claims, audience, time.Now())
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to sign identity token")
+		SendError(w, http.StatusInternalServerError, err, "Failed to issue identity token")
+		return
+	}
+
+	log.Info().
+		Str("build_id", buildID.String()).
+		Str("subject", claims.Subject()).
+		Str("audience", audience).
+		Msg("Identity token issued")
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// oidcRequestEnv returns the environment variables a build requests identity
+// tokens with, storing the hash of a new request token for it
+func oidcRequestEnv(ctx context.Context, db *database.Database, provider *oidc.Provider, buildID uuid.UUID) (map[string]string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(token))
+	if _, err := db.GetConn().ExecContext(ctx, `
+		UPDATE builds SET oidc_request_token_hash = $2 WHERE id = $1
+	`, buildID, hex.EncodeToString(sum[:])); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		oidcRequestURLVar:   provider.Issuer() + "/api/v1/builds/" + buildID.String() + "/oidc/token",
+		oidcRequestTokenVar: token,
+	}, nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+// PinBuild pins a build, e.g. a release build kept for audits: it is exempt
+// from every retention policy, and so are its artifacts and logs. Only
+// administrators can pin builds.
+func (h *BuildHandler) PinBuild(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+	}
+
+	// Pinning a pinned build again updates the reason, but keeps who pinned
+	// it and when
+	result, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE builds
+		SET pin_reason = NULLIF($3, ''),
+		    pinned_by = CASE WHEN pinned THEN pinned_by ELSE $2 END,
+		    pinned_at = CASE WHEN pinned THEN pinned_at ELSE CURRENT_TIMESTAMP END,
+		    pinned = true
+		WHERE id = $1
+	`, buildID, principal.Username, req.Reason)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to pin build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to pin build")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	log.Info().Str("build_id", buildID.String()).Str("by", principal.Username).Msg("Build pinned")
+	h.GetBuild(w, r)
+}
+
+// UnpinBuild unpins a build, which retention policies then apply to again
+func (h *BuildHandler) UnpinBuild(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE builds
+		SET pinned = false, pinned_by = NULL, pinned_at = NULL, pin_reason = NULL
+		WHERE id = $1
+	`, buildID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unpin build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to unpin build")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	log.Info().Str("build_id", buildID.String()).Str("by", principal.Username).Msg("Build unpinned")
+	h.GetBuild(w, r)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+)
+
+// maxPipelineBytes limits the size of a pipeline definition
+const maxPipelineBytes = 1 << 20
+
+// SetBuildPipeline validates the pipeline definition a worker agent read from
+// the repository of a build's job, at the build's commit, as
+// {"file": ".solvyd.yml", "content": "..."}, with the templates it includes
+// from pipeline libraries. A valid definition is stored with the build, with
+// the commits of the templates, and its build config and plugin steps are
+// returned for the agent
+// to run in place of the job's; an invalid one is rejected with 422 and every
+// problem found.
+func (h *BuildHandler) SetBuildPipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		File    string `json:"file"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPipelineBytes)).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	def, err := pipeline.Parse(ctx, h.db, h.templates, []byte(req.Content))
+	var invalid *pipeline.ValidationError
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Invalid pipeline definition in " + req.File,
+			Code:    http.StatusUnprocessableEntity,
+			Details: invalid.Problems,
+		})
+		return
+	}
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to validate pipeline definition")
+		return
+	}
+
+	stored, err := json.Marshal(def)
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store pipeline definition")
+		return
+	}
+	result, err := h.db.GetConn().ExecContext(ctx, `UPDATE builds SET pipeline_config = $2 WHERE id = $1`, buildID, stored)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to store build pipeline")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store pipeline definition")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	resp := map[string]interface{}{"build_config": def.BuildConfig()}
+	if def.Plugins != nil {
+		resp["plugins"] = def.Plugins
+	}
+	SendJSON(w, http.StatusOK, resp)
+}
+
+// GetBuildPipeline returns the pipeline definition a build ran, as read from
+// its repository
+func (h *BuildHandler) GetBuildPipeline(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var def models.JSONB
+	err = h.db.GetConn().QueryRowContext(ctx, `SELECT pipeline_config FROM builds WHERE id = $1`, buildID).Scan(&def)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch pipeline definition")
+		return
+	}
+	if len(def) == 0 {
+		SendError(w, http.StatusNotFound, nil, "Build did not run a pipeline from its repository")
+		return
+	}
+	SendJSON(w, http.StatusOK, def)
+}
+
+// PipelineHandler checks pipeline definitions for editors and other tools
+type PipelineHandler struct {
+	db        *database.Database
+	templates pipeline.Templates
+}
+
+// NewPipelineHandler creates a new pipeline handler, which reads the
+// templates pipelines include from templates
+func NewPipelineHandler(db *database.Database, templates pipeline.Templates) *PipelineHandler {
+	return &PipelineHandler{db: db, templates: templates}
+}
+
+// LintPipeline checks a document, given as {"kind": "pipeline", "content":
+// "..."}, and returns every problem found with its path, line and column. The
+// kind is "pipeline" (the default) for a .solvyd.yml, or "job" for the
+// pipeline_stages, triggers, plugins and notifications of a job.
+func (h *PipelineHandler) LintPipeline(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind    string `json:"kind"`
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPipelineBytes)).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = pipeline.KindPipeline
+	}
+	if req.Kind != pipeline.KindPipeline && req.Kind != pipeline.KindJob {
+		SendError(w, http.StatusBadRequest, nil, "kind must be pipeline or job")
+		return
+	}
+
+	diagnostics, err := pipeline.Lint(r.Context(), h.db, h.templates, req.Kind, []byte(req.Content))
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to lint pipeline definition")
+		return
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"valid":       len(diagnostics) == 0,
+		"diagnostics": diagnostics,
+	})
+}
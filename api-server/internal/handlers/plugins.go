@@ -1,20 +1,37 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
 	"github.com/solvyd/solvyd/api-server/internal/database"
 	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/plugininstall"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+	"github.com/solvyd/solvyd/api-server/internal/storage"
 )
 
 // PluginHandler handles plugin-related requests
 type PluginHandler struct {
-	db *database.Database
+	db       *database.Database
+	storage  storage.Storage
+	fetcher  *plugininstall.Fetcher
+	settings *settings.Store
+	auth     *auth.Authenticator
 }
 
-// NewPluginHandler creates a new plugin handler
-func NewPluginHandler(db *database.Database) *PluginHandler {
-	return &PluginHandler{db: db}
+// NewPluginHandler creates a new plugin handler, which installs plugins from
+// the URLs and OCI registries the instance settings allow
+func NewPluginHandler(db *database.Database, store storage.Storage, fetcher *plugininstall.Fetcher, settings *settings.Store, authenticator *auth.Authenticator) *PluginHandler {
+	return &PluginHandler{db: db, storage: store, fetcher: fetcher, settings: settings, auth: authenticator}
 }
 
 // ListPlugins returns all plugins
@@ -22,8 +39,8 @@ func (h *PluginHandler) ListPlugins(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	query := `
-		SELECT id, name, type, version, description, author, homepage_url,
-		       enabled, installed_at, updated_at
+		SELECT id, name, type, version, COALESCE(binary_path, ''), COALESCE(binary_checksum, ''),
+		       description, author, homepage_url, enabled, installed_at, updated_at
 		FROM plugins
 		ORDER BY type, name
 	`
@@ -39,7 +56,7 @@ func (h *PluginHandler) ListPlugins(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var p models.Plugin
 		err := rows.Scan(
-			&p.ID, &p.Name, &p.Type, &p.Version, &p.Description,
+			&p.ID, &p.Name, &p.Type, &p.Version, &p.BinaryPath, &p.BinaryChecksum, &p.Description,
 			&p.Author, &p.HomepageURL, &p.Enabled, &p.InstalledAt, &p.UpdatedAt,
 		)
 		if err != nil {
@@ -56,7 +73,242 @@ func (h *PluginHandler) GetPlugin(w http.ResponseWriter, r *http.Request) {
 	SendJSON(w, http.StatusOK, map[string]string{"status": "stub"})
 }
 
-// InstallPlugin installs a new plugin
+// installPluginRequest selects the plugin to install. Plugins from a URL or OCI
+// registry are verified, then imported into the registry so workers download
+// them like any other registry plugin.
+type installPluginRequest struct {
+	Source string `json:"source"` // trivy-container-scan@1.2.0, https://... or oci://registry/repo:tag
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+
+	// External sources only
+	Name         string       `json:"name"`    // defaults to the OCI repository name
+	Version      string       `json:"version"` // defaults to the OCI tag
+	Type         string       `json:"type"`
+	SHA256       string       `json:"sha256"`        // required for URLs; optional for OCI
+	Signature    string       `json:"signature"`     // base64 cosign signature (URLs)
+	SignatureURL string       `json:"signature_url"` // or where to fetch it (URLs)
+	Description  string       `json:"description"`
+	Author       string       `json:"author"`
+	HomepageURL  string       `json:"homepage_url"`
+	ConfigSchema models.JSONB `json:"config_schema"`
+}
+
+// InstallPlugin installs or upgrades a plugin from the plugin registry, an HTTPS
+// URL or an OCI registry. Only administrators may install plugins, and registry
+// versions are held to the same source policy and signature requirement as
+// external plugins.
 func (h *PluginHandler) InstallPlugin(w http.ResponseWriter, r *http.Request) {
-	SendJSON(w, http.StatusOK, map[string]string{"status": "stub - plugin installation"})
+	ctx := r.Context()
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	var req installPluginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if req.Source == "" {
+		SendError(w, http.StatusBadRequest, nil, "Plugin source is required")
+		return
+	}
+
+	var resolved *models.PluginVersion
+	if plugininstall.IsExternal(req.Source) {
+		if !h.settings.Current().AllowsPluginSource(req.Source) {
+			SendError(w, http.StatusForbidden, nil, "Plugins may not be installed from this source")
+			return
+		}
+		if resolved, ok = h.importExternal(w, r, &req, principal); !ok {
+			return
+		}
+	} else {
+		name, version := splitPluginRef(req.Source)
+		var err error
+		resolved, err = resolvePluginVersion(ctx, h.db, name, version, req.OS, req.Arch)
+		if err == errPluginVersionNotFound {
+			SendError(w, http.StatusNotFound, err, fmt.Sprintf("No published version of %s matches %s", name, version))
+			return
+		}
+		if err != nil {
+			SendError(w, http.StatusInternalServerError, err, "Failed to resolve plugin")
+			return
+		}
+	}
+	if resolved.Yanked {
+		SendError(w, http.StatusConflict, nil, fmt.Sprintf("%s@%s has been yanked", resolved.PluginName, resolved.Version))
+		return
+	}
+	// Versions imported from elsewhere are checked against the policy by where
+	// they came from, so tightening the policy also covers earlier imports
+	if resolved.Source != "" && !h.settings.Current().AllowsPluginSource(resolved.Source) {
+		SendError(w, http.StatusForbidden, nil, fmt.Sprintf("%s@%s was imported from a source plugins may not be installed from",
+			resolved.PluginName, resolved.Version))
+		return
+	}
+	if h.fetcher.RequiresSignature() && !resolved.Signed {
+		SendError(w, http.StatusUnprocessableEntity, nil, fmt.Sprintf("%s@%s is not signed by a trusted key",
+			resolved.PluginName, resolved.Version))
+		return
+	}
+
+	downloadPath := fmt.Sprintf("/api/v1/registry/plugins/%s/%s/download?os=%s&arch=%s",
+		url.PathEscape(resolved.PluginName), url.PathEscape(resolved.Version),
+		url.QueryEscape(resolved.OS), url.QueryEscape(resolved.Arch))
+
+	query := `
+		INSERT INTO plugins (name, type, version, binary_path, binary_checksum, description,
+		                     author, homepage_url, config_schema)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (name) DO UPDATE SET
+			type = EXCLUDED.type, version = EXCLUDED.version, binary_path = EXCLUDED.binary_path,
+			binary_checksum = EXCLUDED.binary_checksum, description = EXCLUDED.description,
+			author = EXCLUDED.author, homepage_url = EXCLUDED.homepage_url,
+			config_schema = EXCLUDED.config_schema, updated_at = CURRENT_TIMESTAMP
+		RETURNING id, enabled, installed_at, updated_at, (xmax = 0)
+	`
+
+	p := models.Plugin{
+		Name:           resolved.PluginName,
+		Type:           resolved.Type,
+		Version:        resolved.Version,
+		BinaryPath:     downloadPath,
+		BinaryChecksum: resolved.ChecksumSHA256,
+		Description:    resolved.Description,
+		Author:         resolved.Author,
+		HomepageURL:    resolved.HomepageURL,
+		ConfigSchema:   resolved.ConfigSchema,
+	}
+	var created bool
+	err := h.db.GetConn().QueryRowContext(ctx, query,
+		p.Name, p.Type, p.Version, p.BinaryPath, p.BinaryChecksum, p.Description,
+		p.Author, p.HomepageURL, p.ConfigSchema,
+	).Scan(&p.ID, &p.Enabled, &p.InstalledAt, &p.UpdatedAt, &created)
+	if err != nil {
+		log.Error().Err(err).Str("plugin", p.Name).Msg("Failed to install plugin")
+		SendError(w, http.StatusInternalServerError, err, "Failed to install plugin")
+		return
+	}
+
+	log.Info().Str("plugin", p.Name).Str("version", p.Version).Bool("upgrade", !created).Str("by", principal.Username).Msg("Plugin installed")
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	SendJSON(w, status, p)
+}
+
+// importExternal downloads and verifies a plugin from a URL or OCI registry and
+// publishes it to the registry. Re-installing the same binary reuses the
+// existing registry version. On failure the error response has been sent.
+func (h *PluginHandler) importExternal(w http.ResponseWriter, r *http.Request, req *installPluginRequest, principal *auth.Principal) (*models.PluginVersion, bool) {
+	ctx := r.Context()
+
+	if strings.HasPrefix(req.Source, "http://") {
+		SendError(w, http.StatusBadRequest, nil, "Plugins must be downloaded over HTTPS")
+		return nil, false
+	}
+	if strings.HasPrefix(req.Source, "https://") && req.SHA256 == "" {
+		SendError(w, http.StatusBadRequest, nil, "sha256 is required when installing from a URL")
+		return nil, false
+	}
+
+	download, err := h.fetcher.Fetch(ctx, plugininstall.Request{
+		Source:       req.Source,
+		SHA256:       req.SHA256,
+		Signature:    req.Signature,
+		SignatureURL: req.SignatureURL,
+	})
+	if errors.Is(err, plugininstall.ErrVerificationFailed) {
+		log.Warn().Err(err).Str("source", req.Source).Msg("Rejected unverified plugin")
+		SendError(w, http.StatusUnprocessableEntity, err, "Plugin verification failed")
+		return nil, false
+	}
+	if err != nil {
+		SendError(w, http.StatusBadGateway, err, "Failed to download plugin")
+		return nil, false
+	}
+	defer download.Close()
+
+	meta := pluginVersionMetadata{
+		Name:         req.Name,
+		Version:      req.Version,
+		Type:         req.Type,
+		OS:           req.OS,
+		Arch:         req.Arch,
+		Description:  req.Description,
+		Author:       req.Author,
+		HomepageURL:  req.HomepageURL,
+		ConfigSchema: req.ConfigSchema,
+		PublishedBy:  principal.Username,
+	}
+	if meta.Name == "" {
+		meta.Name = download.Repo
+	}
+	if meta.Version == "" {
+		meta.Version = download.Tag
+	}
+	if err := meta.normalize(); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid plugin metadata")
+		return nil, false
+	}
+
+	version := &models.PluginVersion{
+		ID:             uuid.New(),
+		PluginName:     meta.Name,
+		Version:        meta.Version,
+		Type:           meta.Type,
+		OS:             meta.OS,
+		Arch:           meta.Arch,
+		ChecksumSHA256: download.SHA256,
+		SizeBytes:      download.Size,
+		Description:    meta.Description,
+		Author:         meta.Author,
+		HomepageURL:    meta.HomepageURL,
+		ConfigSchema:   meta.ConfigSchema,
+		Source:         req.Source,
+		Signed:         download.Signed,
+		PublishedBy:    meta.PublishedBy,
+	}
+
+	err = storePluginVersion(ctx, h.db, h.storage, version, download.File)
+	if err == errPluginVersionExists {
+		existing, err := resolvePluginVersion(ctx, h.db, meta.Name, meta.Version, meta.OS, meta.Arch)
+		if err != nil {
+			SendError(w, http.StatusInternalServerError, err, "Failed to resolve plugin")
+			return nil, false
+		}
+		if existing.ChecksumSHA256 != download.SHA256 {
+			SendError(w, http.StatusConflict, nil, fmt.Sprintf(
+				"%s@%s is already in the registry with a different checksum", meta.Name, meta.Version))
+			return nil, false
+		}
+		if download.Signed && !existing.Signed {
+			// The same binary, now with a signature that checks out
+			if _, err := h.db.GetConn().ExecContext(ctx,
+				"UPDATE plugin_versions SET signed = true WHERE id = $1", existing.ID); err != nil {
+				SendError(w, http.StatusInternalServerError, err, "Failed to record plugin signature")
+				return nil, false
+			}
+			existing.Signed = true
+		}
+		return existing, true
+	}
+	if err != nil {
+		log.Error().Err(err).Str("plugin", meta.Name).Msg("Failed to import plugin")
+		SendError(w, http.StatusInternalServerError, err, "Failed to import plugin")
+		return nil, false
+	}
+
+	log.Info().
+		Str("plugin", version.PluginName).
+		Str("version", version.Version).
+		Str("source", req.Source).
+		Str("checksum", version.ChecksumSHA256).
+		Bool("signed", download.Signed).
+		Msg("Imported external plugin")
+	return version, true
 }
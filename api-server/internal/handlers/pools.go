@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/pools"
+)
+
+// PoolHandler manages the worker pools jobs target
+type PoolHandler struct {
+	db *database.Database
+}
+
+// NewPoolHandler creates a new pool handler
+func NewPoolHandler(db *database.Database) *PoolHandler {
+	return &PoolHandler{db: db}
+}
+
+// poolRequest is the body of the requests creating and updating pools
+type poolRequest struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Autoscaling *pools.AutoscalingPolicy `json:"autoscaling"`
+}
+
+// validate returns the problems of the pool's autoscaling policy
+func (req poolRequest) validate() []string {
+	if req.Autoscaling == nil {
+		return nil
+	}
+	return req.Autoscaling.Validate()
+}
+
+// ListPools returns the default pool and the named pools with their
+// capacity, queue depth and desired workers
+func (h *PoolHandler) ListPools(w http.ResponseWriter, r *http.Request) {
+	all, err := pools.List(r.Context(), h.db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query worker pools")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch pools")
+		return
+	}
+	SendJSON(w, http.StatusOK, all)
+}
+
+// GetPool returns a pool with its capacity, queue depth and desired workers
+func (h *PoolHandler) GetPool(w http.ResponseWriter, r *http.Request) {
+	pool, err := pools.Get(r.Context(), h.db, mux.Vars(r)["name"])
+	if errors.Is(err, pools.ErrNotFound) {
+		SendError(w, http.StatusNotFound, nil, "Pool not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query worker pool")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch pool")
+		return
+	}
+	SendJSON(w, http.StatusOK, pool)
+}
+
+// CreatePool creates a pool, with an optional autoscaling policy
+func (h *PoolHandler) CreatePool(w http.ResponseWriter, r *http.Request) {
+	var req poolRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if !pools.ValidName(req.Name) {
+		SendError(w, http.StatusBadRequest, nil, "Pool name must be lowercase letters, digits, '.', '_' and '-', other than \""+pools.DefaultPool+"\"")
+		return
+	}
+	if problems := req.validate(); len(problems) > 0 {
+		SendError(w, http.StatusBadRequest, nil, "Invalid autoscaling policy: "+strings.Join(problems, "; "))
+		return
+	}
+
+	autoscaling := nullableJSON(req.Autoscaling)
+	_, err := h.db.GetConn().ExecContext(r.Context(), `
+		INSERT INTO worker_pools (name, description, autoscaling)
+		VALUES ($1, $2, $3)
+	`, req.Name, req.Description, autoscaling)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		SendError(w, http.StatusConflict, nil, "Pool already exists")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create worker pool")
+		SendError(w, http.StatusInternalServerError, err, "Failed to create pool")
+		return
+	}
+
+	log.Info().Str("pool", req.Name).Msg("Worker pool created")
+	h.sendPool(w, r, http.StatusCreated, req.Name)
+}
+
+// UpdatePool replaces the description and autoscaling policy of a pool; a
+// null policy removes it
+func (h *PoolHandler) UpdatePool(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var req poolRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if problems := req.validate(); len(problems) > 0 {
+		SendError(w, http.StatusBadRequest, nil, "Invalid autoscaling policy: "+strings.Join(problems, "; "))
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `
+		UPDATE worker_pools
+		SET description = $2, autoscaling = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE name = $1
+	`, name, req.Description, nullableJSON(req.Autoscaling))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update worker pool")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update pool")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Pool not found")
+		return
+	}
+
+	log.Info().Str("pool", name).Msg("Worker pool updated")
+	h.sendPool(w, r, http.StatusOK, name)
+}
+
+// DeletePool deletes a pool no worker or job belongs to
+func (h *PoolHandler) DeletePool(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var inUse bool
+	err := h.db.GetConn().QueryRowContext(r.Context(), `
+		SELECT EXISTS(SELECT 1 FROM workers WHERE pool = $1)
+		    OR EXISTS(SELECT 1 FROM jobs WHERE pool = $1)
+	`, name).Scan(&inUse)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query worker pool use")
+		SendError(w, http.StatusInternalServerError, err, "Failed to delete pool")
+		return
+	}
+	if inUse {
+		SendError(w, http.StatusConflict, nil, "Pool has workers or jobs")
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(r.Context(), `DELETE FROM worker_pools WHERE name = $1`, name)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete worker pool")
+		SendError(w, http.StatusInternalServerError, err, "Failed to delete pool")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		SendError(w, http.StatusNotFound, nil, "Pool not found")
+		return
+	}
+
+	log.Info().Str("pool", name).Msg("Worker pool deleted")
+	SendJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// sendPool responds with a pool as it is now
+func (h *PoolHandler) sendPool(w http.ResponseWriter, r *http.Request, code int, name string) {
+	pool, err := pools.Get(r.Context(), h.db, name)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query worker pool")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch pool")
+		return
+	}
+	SendJSON(w, code, pool)
+}
+
+// nullableJSON returns the JSON of a policy, or nil to store NULL
+func nullableJSON(policy *pools.AutoscalingPolicy) []byte {
+	if policy == nil {
+		return nil
+	}
+	data, _ := json.Marshal(policy)
+	return data
+}
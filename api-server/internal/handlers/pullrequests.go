@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/solvyd/solvyd/api-server/internal/triggers"
+)
+
+// pullRequest is a GitHub pull request or GitLab merge request
+type pullRequest struct {
+	Number     int
+	URL        string
+	Title      string
+	BaseBranch string // the branch it merges into
+	HeadBranch string
+	HeadSHA    string
+	Closed     bool // closed or merged, so nothing is built
+}
+
+// Pull request actions that build the pull request; closing cancels its
+// builds, and other actions such as labelling are ignored
+var (
+	githubPullRequestActions  = map[string]bool{"opened": true, "synchronize": true, "reopened": true, "closed": true}
+	gitlabMergeRequestActions = map[string]bool{"open": true, "update": true, "reopen": true, "close": true, "merge": true}
+)
+
+// parsePullRequestEvent reads a GitHub pull_request or GitLab Merge Request
+// Hook event. The event's ref is the one holding the result of merging the
+// head into the base, which the provider keeps up to date.
+func parsePullRequestEvent(source, name string, body []byte) (*webhookEvent, bool, error) {
+	event := &webhookEvent{Type: triggers.TypePullRequest, Event: name}
+	pr := &pullRequest{}
+
+	switch source {
+	case "github":
+		var payload struct {
+			Action      string `json:"action"`
+			Number      int    `json:"number"`
+			PullRequest struct {
+				HTMLURL string `json:"html_url"`
+				Title   string `json:"title"`
+				Base    struct {
+					Ref string `json:"ref"`
+				} `json:"base"`
+				Head struct {
+					Ref string `json:"ref"`
+					SHA string `json:"sha"`
+				} `json:"head"`
+				User struct {
+					Login string `json:"login"`
+				} `json:"user"`
+			} `json:"pull_request"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, false, err
+		}
+		if !githubPullRequestActions[payload.Action] || payload.Number == 0 {
+			return nil, false, nil
+		}
+		event.Event = name + "." + payload.Action
+		event.Ref = fmt.Sprintf("refs/pull/%d/merge", payload.Number)
+		event.Author = payload.PullRequest.User.Login
+		pr.Number = payload.Number
+		pr.URL = payload.PullRequest.HTMLURL
+		pr.Title = payload.PullRequest.Title
+		pr.BaseBranch = payload.PullRequest.Base.Ref
+		pr.HeadBranch = payload.PullRequest.Head.Ref
+		pr.HeadSHA = payload.PullRequest.Head.SHA
+		pr.Closed = payload.Action == "closed"
+
+	case "gitlab":
+		var payload struct {
+			User struct {
+				Username string `json:"username"`
+			} `json:"user"`
+			ObjectAttributes struct {
+				IID          int    `json:"iid"`
+				URL          string `json:"url"`
+				Title        string `json:"title"`
+				Action       string `json:"action"`
+				SourceBranch string `json:"source_branch"`
+				TargetBranch string `json:"target_branch"`
+				LastCommit   struct {
+					ID      string `json:"id"`
+					Message string `json:"message"`
+				} `json:"last_commit"`
+			} `json:"object_attributes"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, false, err
+		}
+		attrs := payload.ObjectAttributes
+		if !gitlabMergeRequestActions[attrs.Action] || attrs.IID == 0 {
+			return nil, false, nil
+		}
+		event.Event = name + "." + attrs.Action
+		event.Ref = fmt.Sprintf("refs/merge-requests/%d/merge", attrs.IID)
+		event.Message = attrs.LastCommit.Message
+		event.Author = payload.User.Username
+		pr.Number = attrs.IID
+		pr.URL = attrs.URL
+		pr.Title = attrs.Title
+		pr.BaseBranch = attrs.TargetBranch
+		pr.HeadBranch = attrs.SourceBranch
+		pr.HeadSHA = attrs.LastCommit.ID
+		pr.Closed = attrs.Action == "close" || attrs.Action == "merge"
+
+	default:
+		return nil, false, nil
+	}
+
+	if event.Message == "" {
+		event.Message = pr.Title
+	}
+	event.PullRequest = pr
+	return event, true, nil
+}
+
+// addTo records the pull request in a build's parameters and trigger
+// metadata. The build checks out ref, the merge result, rather than a commit;
+// head_sha is the commit statuses are reported on.
+func (pr *pullRequest) addTo(params, metadata map[string]interface{}, ref string) {
+	params["ref"] = ref
+	params["pull_request_number"] = strconv.Itoa(pr.Number)
+	params["pull_request_url"] = pr.URL
+	params["base_branch"] = pr.BaseBranch
+	params["head_branch"] = pr.HeadBranch
+	params["head_sha"] = pr.HeadSHA
+
+	metadata["pull_request"] = strconv.Itoa(pr.Number)
+	metadata["pull_request_url"] = pr.URL
+	metadata["pull_request_title"] = pr.Title
+	metadata["base_branch"] = pr.BaseBranch
+	metadata["head_branch"] = pr.HeadBranch
+	metadata["head_sha"] = pr.HeadSHA
+}
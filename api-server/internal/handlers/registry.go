@@ -0,0 +1,600 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/plugininstall"
+	"github.com/solvyd/solvyd/api-server/internal/storage"
+)
+
+// maxPluginUploadBytes limits the size of a published plugin binary
+const maxPluginUploadBytes = 512 << 20
+
+var (
+	pluginNamePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+	pluginVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+	platformPattern      = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+var (
+	// errPluginVersionNotFound is returned when a registry lookup matches no published version
+	errPluginVersionNotFound = errors.New("plugin version not found")
+	// errPluginVersionExists is returned when publishing a version that already exists
+	errPluginVersionExists = errors.New("plugin version already published")
+)
+
+// RegistryHandler serves the plugin registry: publishing, searching and downloading plugins
+type RegistryHandler struct {
+	db      *database.Database
+	storage storage.Storage
+	fetcher *plugininstall.Fetcher
+	auth    *auth.Authenticator
+}
+
+// NewRegistryHandler creates a new registry handler, which verifies the
+// signatures of published binaries against the fetcher's trusted keys
+func NewRegistryHandler(db *database.Database, store storage.Storage, fetcher *plugininstall.Fetcher, authenticator *auth.Authenticator) *RegistryHandler {
+	return &RegistryHandler{db: db, storage: store, fetcher: fetcher, auth: authenticator}
+}
+
+// pluginVersionMetadata is the "metadata" part of a publish request
+type pluginVersionMetadata struct {
+	Name         string       `json:"name"`
+	Version      string       `json:"version"`
+	Type         string       `json:"type"`
+	OS           string       `json:"os"`
+	Arch         string       `json:"arch"`
+	Description  string       `json:"description"`
+	Author       string       `json:"author"`
+	HomepageURL  string       `json:"homepage_url"`
+	ConfigSchema models.JSONB `json:"config_schema"`
+	PublishedBy  string       `json:"-"` // set from the caller, never from the request
+}
+
+// PublishPlugin publishes a plugin version. The request is multipart/form-data with
+// a "metadata" JSON part, a "binary" file part and optionally a "signature" part
+// holding a base64 cosign sign-blob signature of the binary. Published versions
+// are immutable. Only administrators may publish.
+func (h *RegistryHandler) PublishPlugin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPluginUploadBytes)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Expected a multipart/form-data request")
+		return
+	}
+
+	var meta *pluginVersionMetadata
+	var binary *os.File
+	var checksum, signature string
+	var size int64
+	defer func() {
+		if binary != nil {
+			binary.Close()
+			os.Remove(binary.Name())
+		}
+	}()
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid multipart body")
+			return
+		}
+
+		switch part.FormName() {
+		case "metadata":
+			meta = &pluginVersionMetadata{}
+			if err := json.NewDecoder(part).Decode(meta); err != nil {
+				SendError(w, http.StatusBadRequest, err, "Invalid plugin metadata")
+				return
+			}
+		case "binary":
+			if binary != nil {
+				SendError(w, http.StatusBadRequest, nil, "Only one binary may be published per request")
+				return
+			}
+			// Spool to disk so the checksum and size are known before uploading
+			if binary, err = os.CreateTemp("", "solvyd-plugin-*"); err != nil {
+				SendError(w, http.StatusInternalServerError, err, "Failed to store plugin binary")
+				return
+			}
+			hash := sha256.New()
+			if size, err = io.Copy(io.MultiWriter(binary, hash), part); err != nil {
+				SendError(w, http.StatusBadRequest, err, "Failed to read plugin binary")
+				return
+			}
+			checksum = hex.EncodeToString(hash.Sum(nil))
+		case "signature":
+			data, err := io.ReadAll(io.LimitReader(part, 1<<20))
+			if err != nil {
+				SendError(w, http.StatusBadRequest, err, "Failed to read signature")
+				return
+			}
+			signature = string(data)
+		}
+		part.Close()
+	}
+
+	if meta == nil || binary == nil || size == 0 {
+		SendError(w, http.StatusBadRequest, nil, "Both metadata and a non-empty binary are required")
+		return
+	}
+	if err := meta.normalize(); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid plugin metadata")
+		return
+	}
+	meta.PublishedBy = principal.Username
+
+	spooled := &plugininstall.Download{File: binary, Size: size, SHA256: checksum}
+	if signature != "" {
+		if err := h.fetcher.VerifyBlob(spooled, signature); err != nil {
+			log.Warn().Err(err).Str("plugin", meta.Name).Msg("Rejected plugin with an invalid signature")
+			SendError(w, http.StatusUnprocessableEntity, err, "Plugin verification failed")
+			return
+		}
+	}
+
+	version := models.PluginVersion{
+		ID:             uuid.New(),
+		PluginName:     meta.Name,
+		Version:        meta.Version,
+		Type:           meta.Type,
+		OS:             meta.OS,
+		Arch:           meta.Arch,
+		ChecksumSHA256: checksum,
+		SizeBytes:      size,
+		Description:    meta.Description,
+		Author:         meta.Author,
+		HomepageURL:    meta.HomepageURL,
+		ConfigSchema:   meta.ConfigSchema,
+		Signed:         spooled.Signed,
+		PublishedBy:    meta.PublishedBy,
+	}
+
+	err = storePluginVersion(ctx, h.db, h.storage, &version, binary)
+	if err == errPluginVersionExists {
+		SendError(w, http.StatusConflict, nil, "This plugin version is already published for the platform")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("plugin", version.PluginName).Msg("Failed to publish plugin version")
+		SendError(w, http.StatusInternalServerError, err, "Failed to publish plugin")
+		return
+	}
+
+	log.Info().
+		Str("plugin", version.PluginName).
+		Str("version", version.Version).
+		Str("platform", version.OS+"/"+version.Arch).
+		Str("checksum", version.ChecksumSHA256).
+		Bool("signed", version.Signed).
+		Str("by", version.PublishedBy).
+		Msg("Plugin version published")
+	SendJSON(w, http.StatusCreated, version)
+}
+
+// storePluginVersion records a version and uploads its binary under a key of
+// its own. The row is inserted in a transaction that commits only once the
+// upload succeeds, so a concurrent publish of the same version waits on the
+// unique constraint and then fails without touching the winner's binary. It
+// returns errPluginVersionExists if the version is already published for the platform.
+func storePluginVersion(ctx context.Context, db *database.Database, store storage.Storage, version *models.PluginVersion, binary io.ReadSeeker) error {
+	version.StorageKey = pluginStorageKey(version)
+
+	uploaded := false
+	err := db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO plugin_versions (id, plugin_name, version, type, os, arch, storage_key,
+			                             checksum_sha256, size_bytes, description, author,
+			                             homepage_url, config_schema, source, signed, published_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''), $15, $16)
+			ON CONFLICT (plugin_name, version, os, arch) DO NOTHING
+			RETURNING published_at`,
+			version.ID, version.PluginName, version.Version, version.Type, version.OS, version.Arch,
+			version.StorageKey, version.ChecksumSHA256, version.SizeBytes, version.Description,
+			version.Author, version.HomepageURL, version.ConfigSchema, version.Source, version.Signed,
+			version.PublishedBy,
+		).Scan(&version.PublishedAt)
+		if err == sql.ErrNoRows {
+			return errPluginVersionExists
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := binary.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		uploaded = true
+		if err := store.Put(ctx, version.StorageKey, binary, version.SizeBytes); err != nil {
+			return fmt.Errorf("failed to upload plugin binary: %w", err)
+		}
+		return nil
+	})
+	if err != nil && uploaded {
+		// The row was rolled back; nothing refers to the binary
+		if derr := store.Delete(context.Background(), version.StorageKey); derr != nil {
+			log.Warn().Err(derr).Str("key", version.StorageKey).Msg("Failed to delete orphaned plugin binary")
+		}
+	}
+	return err
+}
+
+// pluginStorageKey is where a version's binary is kept in artifact storage.
+// The key includes the row's ID so no two publishes ever write the same object.
+func pluginStorageKey(v *models.PluginVersion) string {
+	return fmt.Sprintf("plugins/%s/%s/%s-%s/%s/%s", v.PluginName, v.Version, v.OS, v.Arch, v.ID, v.PluginName)
+}
+
+// normalize validates publish metadata and fills in defaults
+func (m *pluginVersionMetadata) normalize() error {
+	if !pluginNamePattern.MatchString(m.Name) {
+		return fmt.Errorf("name %q must be lowercase alphanumerics, '.', '_' or '-'", m.Name)
+	}
+	if !pluginVersionPattern.MatchString(m.Version) {
+		return fmt.Errorf("version %q is not a semantic version", m.Version)
+	}
+	m.Version = strings.TrimPrefix(m.Version, "v")
+	if m.Type == "" {
+		return fmt.Errorf("type is required")
+	}
+	if m.OS == "" {
+		m.OS = "linux"
+	}
+	if m.Arch == "" {
+		m.Arch = "amd64"
+	}
+	if !platformPattern.MatchString(m.OS) || !platformPattern.MatchString(m.Arch) {
+		return fmt.Errorf("invalid platform %s/%s", m.OS, m.Arch)
+	}
+	if m.ConfigSchema == nil {
+		m.ConfigSchema = models.JSONB{}
+	}
+	return nil
+}
+
+// SearchPlugins lists registry plugins with their latest version, filtered by
+// ?q= (name or description) and ?type=
+func (h *RegistryHandler) SearchPlugins(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	query := `
+		SELECT plugin_name, version, type, COALESCE(description, ''), COALESCE(author, ''),
+		       COALESCE(homepage_url, ''), download_count
+		FROM plugin_versions
+		WHERE NOT yanked
+	`
+	args := []interface{}{}
+
+	if q := r.URL.Query().Get("q"); q != "" {
+		args = append(args, "%"+q+"%")
+		query += fmt.Sprintf(" AND (plugin_name ILIKE $%d OR description ILIKE $%d)", len(args), len(args))
+	}
+	if pluginType := r.URL.Query().Get("type"); pluginType != "" {
+		args = append(args, pluginType)
+		query += fmt.Sprintf(" AND type = $%d", len(args))
+	}
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to search plugins")
+		return
+	}
+	defer rows.Close()
+
+	type registryPlugin struct {
+		Name          string   `json:"name"`
+		LatestVersion string   `json:"latest_version"`
+		Versions      []string `json:"versions"`
+		Type          string   `json:"type"`
+		Description   string   `json:"description"`
+		Author        string   `json:"author"`
+		HomepageURL   string   `json:"homepage_url"`
+		Downloads     int      `json:"downloads"`
+	}
+
+	byName := map[string]*registryPlugin{}
+	for rows.Next() {
+		var name, version, pluginType, description, author, homepage string
+		var downloads int
+		if err := rows.Scan(&name, &version, &pluginType, &description, &author, &homepage, &downloads); err != nil {
+			continue
+		}
+
+		p, ok := byName[name]
+		if !ok {
+			p = &registryPlugin{Name: name}
+			byName[name] = p
+		}
+		p.Downloads += downloads
+		if !containsString(p.Versions, version) {
+			p.Versions = append(p.Versions, version)
+		}
+		// Metadata of the newest version wins
+		if p.LatestVersion == "" || compareVersions(version, p.LatestVersion) > 0 {
+			p.LatestVersion = version
+			p.Type = pluginType
+			p.Description = description
+			p.Author = author
+			p.HomepageURL = homepage
+		}
+	}
+
+	plugins := make([]*registryPlugin, 0, len(byName))
+	for _, p := range byName {
+		sort.Slice(p.Versions, func(i, j int) bool { return compareVersions(p.Versions[i], p.Versions[j]) > 0 })
+		plugins = append(plugins, p)
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+
+	SendJSON(w, http.StatusOK, plugins)
+}
+
+// ListPluginVersions returns every published version of a plugin, newest first
+func (h *RegistryHandler) ListPluginVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := mux.Vars(r)["name"]
+
+	versions, err := queryPluginVersions(ctx, h.db, name, true)
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch plugin versions")
+		return
+	}
+	if len(versions) == 0 {
+		SendError(w, http.StatusNotFound, nil, "Plugin not found in registry")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, versions)
+}
+
+// GetPluginVersion returns a version's metadata for ?os= and ?arch= (default linux/amd64).
+// The version may be "latest".
+func (h *RegistryHandler) GetPluginVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	version, err := resolvePluginVersion(ctx, h.db, vars["name"], vars["version"],
+		r.URL.Query().Get("os"), r.URL.Query().Get("arch"))
+	if err == errPluginVersionNotFound {
+		SendError(w, http.StatusNotFound, err, "Plugin version not found in registry")
+		return
+	}
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch plugin version")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, version)
+}
+
+// DownloadPlugin streams a version's binary for ?os= and ?arch=
+func (h *RegistryHandler) DownloadPlugin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	version, err := resolvePluginVersion(ctx, h.db, vars["name"], vars["version"],
+		r.URL.Query().Get("os"), r.URL.Query().Get("arch"))
+	if err == errPluginVersionNotFound {
+		SendError(w, http.StatusNotFound, err, "Plugin version not found in registry")
+		return
+	}
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch plugin version")
+		return
+	}
+
+	body, err := h.storage.Get(ctx, version.StorageKey)
+	if err != nil {
+		log.Error().Err(err).Str("plugin", version.PluginName).Str("version", version.Version).Msg("Failed to read plugin binary")
+		SendError(w, http.StatusBadGateway, err, "Failed to read plugin binary")
+		return
+	}
+	defer body.Close()
+
+	if _, err := h.db.GetConn().ExecContext(ctx,
+		"UPDATE plugin_versions SET download_count = download_count + 1 WHERE id = $1", version.ID); err != nil {
+		log.Warn().Err(err).Str("plugin", version.PluginName).Msg("Failed to count plugin download")
+	}
+
+	filename := version.PluginName
+	if version.OS == "wasip1" {
+		filename += ".wasm"
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(version.SizeBytes, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("X-Checksum-Sha256", version.ChecksumSHA256)
+	w.Header().Set("X-Plugin-Version", version.Version)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		log.Warn().Err(err).Str("plugin", version.PluginName).Msg("Plugin download interrupted")
+	}
+}
+
+// YankPluginVersion marks a version as withdrawn without deleting it: existing
+// installations can still download it, but "latest" and new installs skip it.
+// Only administrators may yank.
+func (h *RegistryHandler) YankPluginVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	result, err := h.db.GetConn().ExecContext(ctx,
+		"UPDATE plugin_versions SET yanked = true WHERE plugin_name = $1 AND version = $2",
+		vars["name"], strings.TrimPrefix(vars["version"], "v"))
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to yank plugin version")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		SendError(w, http.StatusNotFound, nil, "Plugin version not found in registry")
+		return
+	}
+
+	log.Info().Str("plugin", vars["name"]).Str("version", vars["version"]).Str("by", principal.Username).Msg("Plugin version yanked")
+	SendJSON(w, http.StatusOK, map[string]string{"status": "yanked"})
+}
+
+// queryPluginVersions loads all platform builds of a plugin, newest version first
+func queryPluginVersions(ctx context.Context, db *database.Database, name string, includeYanked bool) ([]models.PluginVersion, error) {
+	query := `
+		SELECT id, plugin_name, version, type, os, arch, storage_key, checksum_sha256, size_bytes,
+		       COALESCE(description, ''), COALESCE(author, ''), COALESCE(homepage_url, ''),
+		       config_schema, yanked, download_count, COALESCE(source, ''), signed,
+		       COALESCE(published_by, ''), published_at
+		FROM plugin_versions
+		WHERE plugin_name = $1
+	`
+	if !includeYanked {
+		query += " AND NOT yanked"
+	}
+
+	rows, err := db.GetConn().QueryContext(ctx, query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []models.PluginVersion{}
+	for rows.Next() {
+		var v models.PluginVersion
+		err := rows.Scan(
+			&v.ID, &v.PluginName, &v.Version, &v.Type, &v.OS, &v.Arch, &v.StorageKey,
+			&v.ChecksumSHA256, &v.SizeBytes, &v.Description, &v.Author, &v.HomepageURL,
+			&v.ConfigSchema, &v.Yanked, &v.DownloadCount, &v.Source, &v.Signed, &v.PublishedBy, &v.PublishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if c := compareVersions(versions[i].Version, versions[j].Version); c != 0 {
+			return c > 0
+		}
+		return versions[i].OS+"/"+versions[i].Arch < versions[j].OS+"/"+versions[j].Arch
+	})
+	return versions, nil
+}
+
+// resolvePluginVersion finds the published build of name@version for a platform.
+// "latest" or an empty version selects the highest non-yanked release.
+func resolvePluginVersion(ctx context.Context, db *database.Database, name, version, goos, goarch string) (*models.PluginVersion, error) {
+	if goos == "" {
+		goos = "linux"
+	}
+	if goarch == "" {
+		goarch = "amd64"
+	}
+	version = strings.TrimPrefix(version, "v")
+	latest := version == "" || version == "latest"
+
+	versions, err := queryPluginVersions(ctx, db, name, !latest)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range versions {
+		v := &versions[i]
+		if v.OS != goos || v.Arch != goarch {
+			continue
+		}
+		if latest {
+			// Pre-releases are only installed when asked for explicitly
+			if m := pluginVersionPattern.FindStringSubmatch(v.Version); m != nil && m[4] != "" {
+				continue
+			}
+			return v, nil
+		}
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, errPluginVersionNotFound
+}
+
+// compareVersions orders semantic versions; pre-releases sort before their release
+func compareVersions(a, b string) int {
+	ma := pluginVersionPattern.FindStringSubmatch(a)
+	mb := pluginVersionPattern.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return strings.Compare(a, b)
+	}
+
+	for i := 1; i <= 3; i++ {
+		x, _ := strconv.Atoi(ma[i])
+		y, _ := strconv.Atoi(mb[i])
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case ma[4] == mb[4]:
+		return 0
+	case ma[4] == "":
+		return 1
+	case mb[4] == "":
+		return -1
+	default:
+		return strings.Compare(ma[4], mb[4])
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPluginRef parses "name@version"; the version defaults to latest
+func splitPluginRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "@"); i > 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+	"github.com/solvyd/solvyd/api-server/internal/tracing"
+)
+
+// triggeredByRerun is the triggered_by of builds created by re-running one
+const triggeredByRerun = "rerun"
+
+// RerunBuild queues a new build of a build's job at the same commit, rather
+// than the branch head, with the same parameters and the environment the
+// original ran with. The new build links to the original in rerun_of.
+//
+// With {"from_failed_stage": true}, a failed build of stages is restarted
+// from the stage that failed: the rerun runs only the stages that did not
+// succeed, in the original's workspace, if the worker that ran it still
+// keeps it, and otherwise runs them all.
+func (h *BuildHandler) RerunBuild(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	originalID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		FromFailedStage bool `json:"from_failed_stage"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+	}
+
+	var jobID uuid.UUID
+	var commitSHA, branch string
+	var status models.JobStatus
+	var pipelineConfig, buildConfig models.JSONB
+	var disabled jobDisabled
+	err = h.db.GetConn().QueryRowContext(ctx, `
+		SELECT b.job_id, COALESCE(b.scm_commit_sha, ''), COALESCE(b.branch, ''), b.status,
+		       b.pipeline_config, j.build_config,
+		       NOT j.enabled, COALESCE(j.disabled_reason, ''), j.reenable_at
+		FROM builds b
+		JOIN jobs j ON j.id = b.job_id
+		WHERE b.id = $1
+	`, originalID).Scan(&jobID, &commitSHA, &branch, &status, &pipelineConfig, &buildConfig,
+		&disabled.disabled, &disabled.reason, &disabled.reenableAt)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to re-run build")
+		return
+	}
+	if disabled.disabled {
+		SendError(w, http.StatusConflict, nil, disabled.String())
+		return
+	}
+	// Without its commit, the rerun would build the branch head instead
+	if commitSHA == "" {
+		SendError(w, http.StatusConflict, nil, "Build has no commit to re-run: it was not checked out yet")
+		return
+	}
+
+	var stages []byte // NULL runs every stage
+	if req.FromFailedStage {
+		rerunStages, ok := h.failedStages(w, r, originalID, status, pipelineConfig, buildConfig)
+		if !ok {
+			return
+		}
+		stages, _ = json.Marshal(rerunStages)
+	}
+
+	traceJSON, _ := json.Marshal(tracing.Carrier(ctx))
+	var build struct {
+		ID          uuid.UUID `json:"id"`
+		BuildNumber int       `json:"build_number"`
+		QueuedAt    string    `json:"queued_at"`
+		RerunOf     uuid.UUID `json:"rerun_of"`
+		RerunStages []string  `json:"rerun_stages,omitempty"`
+	}
+	build.RerunOf = originalID
+	json.Unmarshal(stages, &build.RerunStages)
+
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO builds (id, job_id, status, triggered_by, rerun_of, rerun_stages, trace_context,
+			                    scm_commit_sha, scm_commit_message, scm_author, scm_author_email,
+			                    scm_committed_at, branch, changed_files, parameters, environment_vars,
+			                    trigger_metadata)
+			SELECT $1, job_id, 'queued', $2, id, $3, $4,
+			       scm_commit_sha, scm_commit_message, scm_author, scm_author_email,
+			       scm_committed_at, branch, changed_files, parameters, environment_vars,
+			       trigger_metadata
+			FROM builds
+			WHERE id = $5
+			RETURNING id, build_number, queued_at
+		`, uuid.New(), triggeredByRerun, stages, traceJSON, originalID).Scan(&build.ID, &build.BuildNumber, &build.QueuedAt)
+		if err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, build.ID, "build.queued", map[string]interface{}{
+			"build_id": build.ID, "job_id": jobID, "build_number": build.BuildNumber,
+			"branch": branch, "triggered_by": triggeredByRerun, "queued_at": build.QueuedAt,
+			"rerun_of": originalID, "rerun_stages": build.RerunStages,
+		})
+	})
+	if err != nil {
+		log.Error().Err(err).Str("build_id", originalID.String()).Msg("Failed to re-run build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to re-run build")
+		return
+	}
+
+	log.Info().
+		Str("job_id", jobID.String()).
+		Str("build_id", build.ID.String()).
+		Str("rerun_of", originalID.String()).
+		Str("commit_sha", commitSHA).
+		Strs("stages", build.RerunStages).
+		Msg("Build re-run")
+
+	SendJSON(w, http.StatusCreated, build)
+}
+
+// failedStages returns the stages a rerun from the failed stage of a build
+// runs: those that did not succeed, which are the failed stage and the
+// stages after it. It sends an error if the build did not fail in a stage.
+func (h *BuildHandler) failedStages(w http.ResponseWriter, r *http.Request, buildID uuid.UUID, status models.JobStatus, pipelineConfig, buildConfig models.JSONB) ([]string, bool) {
+	switch status {
+	case "failure", models.JobStatusFailed, models.JobStatusTimeout:
+	default:
+		SendError(w, http.StatusConflict, nil, "Only a failed build can be restarted from its failed stage")
+		return nil, false
+	}
+	source, stages := graphStages(pipelineConfig, buildConfig)
+	if source == "default" {
+		SendError(w, http.StatusConflict, nil, "Build has no stages to restart from")
+		return nil, false
+	}
+
+	timeline, err := h.loadTimeline(r.Context(), buildID)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to load build timeline")
+		SendError(w, http.StatusInternalServerError, err, "Failed to re-run build")
+		return nil, false
+	}
+	succeeded := map[string]bool{}
+	failed := false
+	for _, span := range timeline.Spans {
+		if span.Kind != "stage" {
+			continue
+		}
+		switch span.Status {
+		case "success":
+			succeeded[span.Name] = true
+		case "failed":
+			failed = span.Name != cloneStage
+		}
+	}
+	if !failed {
+		SendError(w, http.StatusConflict, nil, "Build did not fail in a stage; re-run it whole")
+		return nil, false
+	}
+
+	rerun := []string{}
+	for _, stage := range stages {
+		if !succeeded[stage.Name] {
+			rerun = append(rerun, stage.Name)
+		}
+	}
+	return rerun, true
+}
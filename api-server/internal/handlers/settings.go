@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+)
+
+// SettingsHandler lets administrators view and change the instance settings
+type SettingsHandler struct {
+	settings *settings.Store
+	auth     *auth.Authenticator
+}
+
+// NewSettingsHandler creates a new settings handler
+func NewSettingsHandler(settings *settings.Store, authenticator *auth.Authenticator) *SettingsHandler {
+	return &SettingsHandler{settings: settings, auth: authenticator}
+}
+
+// GetSettings returns the settings in effect
+func (h *SettingsHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeAdmin(h.auth, w, r); !ok {
+		return
+	}
+	SendJSON(w, http.StatusOK, h.settings.Current())
+}
+
+// UpdateSettings changes the settings given in the body, an object of
+// setting names to values; null restores a setting's default. Changes take
+// effect without a restart.
+func (h *SettingsHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+
+	var changes map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+
+	updated, err := h.settings.Update(r.Context(), changes, principal.Username)
+	var invalid *settings.ValidationError
+	if errors.As(err, &invalid) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Invalid settings",
+			Code:    http.StatusUnprocessableEntity,
+			Details: invalid.Problems,
+		})
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update settings")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update settings")
+		return
+	}
+	SendJSON(w, http.StatusOK, updated)
+}
+
+// authorizeAdmin authenticates the request and checks that it is an
+// administrator's, writing the error response if not
+func authorizeAdmin(a *auth.Authenticator, w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, ok := authenticate(a, w, r)
+	if !ok {
+		return nil, false
+	}
+	if !principal.IsAdmin() {
+		SendError(w, http.StatusForbidden, nil, "Administrator role required")
+		return nil, false
+	}
+	return principal, true
+}
+
+// authenticate returns the user a request is made by, writing the error
+// response if it is not authenticated
+func authenticate(a *auth.Authenticator, w http.ResponseWriter, r *http.Request) (*auth.Principal, bool) {
+	principal, err := a.Authenticate(r.Context(), auth.TokenFromRequest(r))
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		SendError(w, http.StatusUnauthorized, err, "Authentication required")
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to authenticate request")
+		SendError(w, http.StatusInternalServerError, err, "Failed to authenticate")
+		return nil, false
+	}
+	return principal, true
+}
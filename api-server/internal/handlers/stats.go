@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// maxStatsWindow is the longest window statistics are computed over
+const maxStatsWindow = 365 * 24 * time.Hour
+
+// StatsHandler serves build statistics across all jobs
+type StatsHandler struct {
+	db *database.Database
+}
+
+// NewStatsHandler creates a new statistics handler
+func NewStatsHandler(db *database.Database) *StatsHandler {
+	return &StatsHandler{db: db}
+}
+
+// GetStats returns build statistics of all jobs over a window (?window=30d,
+// the default, or a duration such as 12h), optionally of one branch
+// (?branch=main)
+func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	window, ok := statsWindow(w, r)
+	if !ok {
+		return
+	}
+	stats, err := buildStats(r.Context(), h.db, nil, r.URL.Query().Get("branch"), window)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to compute build statistics")
+		SendError(w, http.StatusInternalServerError, err, "Failed to compute statistics")
+		return
+	}
+	SendJSON(w, http.StatusOK, stats)
+}
+
+// GetJobStats returns the build statistics of a job, with the same options as
+// GetStats
+func (h *JobHandler) GetJobStats(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+	window, ok := statsWindow(w, r)
+	if !ok {
+		return
+	}
+
+	var exists bool
+	if err := h.db.GetConn().QueryRowContext(r.Context(), `SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1)`, jobID).Scan(&exists); err != nil {
+		log.Error().Err(err).Msg("Failed to query job")
+		SendError(w, http.StatusInternalServerError, err, "Failed to compute statistics")
+		return
+	}
+	if !exists {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+
+	stats, err := buildStats(r.Context(), h.db, &jobID, r.URL.Query().Get("branch"), window)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to compute job statistics")
+		SendError(w, http.StatusInternalServerError, err, "Failed to compute statistics")
+		return
+	}
+	SendJSON(w, http.StatusOK, stats)
+}
+
+// statsWindow reads the window query parameter, sending a 400 if it is
+// invalid
+func statsWindow(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	window, err := parseWindow(r.URL.Query().Get("window"), 30*24*time.Hour)
+	if err == nil && (window <= 0 || window > maxStatsWindow) {
+		err = fmt.Errorf("window must be positive and at most 365d")
+	}
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid window (want days such as 30d or a duration such as 12h)")
+		return 0, false
+	}
+	return window, true
+}
+
+// parseWindow parses a number of days (30d) or a Go duration (12h), or
+// returns def for an empty string
+func parseWindow(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// buildStats computes the statistics of the builds queued in the window, of
+// one job if jobID is set and one branch if branch is set. Cancelled builds
+// count towards the totals only; the success rate and failure streaks are of
+// completed builds, where a failure is a failed or timed out build.
+func buildStats(ctx context.Context, db *database.Database, jobID *uuid.UUID, branch string, window time.Duration) (*models.BuildStats, error) {
+	until := time.Now().UTC()
+	since := until.Add(-window)
+	stats := &models.BuildStats{
+		JobID:    jobID,
+		Branch:   branch,
+		Since:    since,
+		Until:    until,
+		ByStatus: map[string]int{},
+		PerDay:   []models.DailyBuilds{},
+	}
+
+	// The builds in scope; $1 and $2 are the window, $3 the job and $4 the
+	// branch, either of which may be NULL
+	scope := `
+		SELECT b.job_id, b.status, b.queued_at, b.duration_seconds
+		FROM builds b
+		WHERE b.queued_at >= $1 AND b.queued_at <= $2
+		  AND ($3::uuid IS NULL OR b.job_id = $3)
+		  AND ($4::text IS NULL OR b.branch = $4)
+	`
+	args := []interface{}{since, until, jobID, nullString(branch)}
+
+	rows, err := db.GetConn().QueryContext(ctx, `SELECT status, COUNT(*) FROM (`+scope+`) s GROUP BY status`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.ByStatus[status] = n
+		stats.TotalBuilds += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	succeeded := stats.ByStatus["success"]
+	// Agents report failed builds as failure; the server fails them as failed
+	failed := stats.ByStatus["failure"] + stats.ByStatus["failed"] + stats.ByStatus["timeout"]
+	if succeeded+failed > 0 {
+		rate := float64(succeeded) / float64(succeeded+failed)
+		stats.SuccessRate = &rate
+	}
+
+	// Durations of completed builds
+	err = db.GetConn().QueryRowContext(ctx, `
+		SELECT AVG(duration_seconds),
+		       PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY duration_seconds),
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_seconds)
+		FROM (`+scope+`) s
+		WHERE status IN ('success', 'failure', 'failed', 'timeout') AND duration_seconds IS NOT NULL
+	`, args...).Scan(&stats.Duration.Average, &stats.Duration.Median, &stats.Duration.P95)
+	if err != nil {
+		return nil, err
+	}
+
+	// Builds per day, including days without any
+	rows, err = db.GetConn().QueryContext(ctx, `
+		SELECT d::date,
+		       COUNT(s.status),
+		       COUNT(s.status) FILTER (WHERE s.status = 'success'),
+		       COUNT(s.status) FILTER (WHERE s.status IN ('failure', 'failed', 'timeout'))
+		FROM generate_series(date_trunc('day', $1::timestamptz AT TIME ZONE 'UTC'),
+		                     date_trunc('day', $2::timestamptz AT TIME ZONE 'UTC'), '1 day') d
+		LEFT JOIN (`+scope+`) s ON date_trunc('day', s.queued_at AT TIME ZONE 'UTC') = d
+		GROUP BY d
+		ORDER BY d
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day models.DailyBuilds
+		var date time.Time
+		if err := rows.Scan(&date, &day.Total, &day.Success, &day.Failed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		day.Date = date.Format("2006-01-02")
+		stats.PerDay = append(stats.PerDay, day)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Failure streaks are runs of failures between successes of the same job,
+	// numbered as islands: a build's run is its position among the job's
+	// completed builds minus its position among those with the same outcome
+	err = db.GetConn().QueryRowContext(ctx, `
+		WITH completed AS (
+			SELECT job_id, queued_at, status <> 'success' AS failed,
+			       ROW_NUMBER() OVER (PARTITION BY job_id ORDER BY queued_at)
+			     - ROW_NUMBER() OVER (PARTITION BY job_id, status <> 'success' ORDER BY queued_at) AS run
+			FROM (`+scope+`) s
+			WHERE status IN ('success', 'failure', 'failed', 'timeout')
+		), streaks AS (
+			SELECT job_id, COUNT(*) AS length, MAX(queued_at) AS last_at
+			FROM completed
+			WHERE failed
+			GROUP BY job_id, run
+		), latest AS (
+			SELECT job_id, MAX(queued_at) AS last_at FROM completed GROUP BY job_id
+		)
+		SELECT COALESCE(MAX(s.length), 0),
+		       COALESCE(MAX(s.length) FILTER (WHERE s.last_at = l.last_at), 0),
+		       COUNT(*) FILTER (WHERE s.last_at = l.last_at)
+		FROM streaks s
+		JOIN latest l ON l.job_id = s.job_id
+	`, args...).Scan(&stats.FailureStreaks.Longest, &stats.FailureStreaks.Current, &stats.FailureStreaks.FailingJobs)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// nullString returns nil for an empty string, for optional query parameters
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+	"github.com/solvyd/solvyd/api-server/internal/scheduler"
+)
+
+// Why a build is stuck
+const (
+	stuckQueued        = "queued"         // queued for longer than the threshold
+	stuckWorkerOffline = "worker_offline" // running on a worker that stopped sending heartbeats
+	stuckWorkerGone    = "worker_gone"    // running on a worker that was removed
+)
+
+// Why a forced requeue or failure left a build alone
+const (
+	forceSkipNotFound      = "not_found"
+	forceSkipCompleted     = "completed"      // the build has finished
+	forceSkipWorkerOnline  = "worker_online"  // its worker is alive and would keep running it; cancel it instead
+	forceSkipAlreadyQueued = "already_queued" // a queued build is not requeued
+)
+
+// failureReasonAdmin is the failure_reason of builds failed by an
+// administrator
+const failureReasonAdmin = "failed_by_admin"
+
+// maxStuckBuilds bounds the stuck build snapshot and the builds of a bulk
+// requeue or failure
+const maxStuckBuilds = 500
+
+// stuckBuild is a build that is not making progress, with why the scheduler
+// last left it in the queue
+type stuckBuild struct {
+	BuildID       uuid.UUID  `json:"build_id"`
+	JobID         uuid.UUID  `json:"job_id"`
+	JobName       string     `json:"job_name"`
+	BuildNumber   int        `json:"build_number"`
+	Status        string     `json:"status"`
+	Pool          string     `json:"pool,omitempty"`
+	Stuck         string     `json:"stuck"`
+	QueuedAt      time.Time  `json:"queued_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	WorkerID      *uuid.UUID `json:"worker_id,omitempty"`
+	WorkerName    string     `json:"worker_name,omitempty"`
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	// Of queued builds
+	SkipReason string     `json:"skip_reason,omitempty"`
+	SkippedAt  *time.Time `json:"skipped_at,omitempty"`
+}
+
+// ListStuckBuilds returns the builds queued for longer than ?queued_for=
+// (default 30m) and the running builds whose worker is offline or removed,
+// oldest first
+func (h *AdminHandler) ListStuckBuilds(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authorizeAdmin(h.auth, w, r); !ok {
+		return
+	}
+	queuedFor, err := parseWindow(r.URL.Query().Get("queued_for"), 30*time.Minute)
+	if err == nil && queuedFor < 0 {
+		err = fmt.Errorf("queued_for must not be negative")
+	}
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid queued_for (want a duration such as 30m or days such as 1d)")
+		return
+	}
+
+	rows, err := h.db.GetConn().QueryContext(r.Context(), `
+		SELECT b.id, b.job_id, j.name, b.build_number, b.status, COALESCE(j.pool, ''),
+		       b.queued_at, b.started_at, b.worker_id, COALESCE(w.name, ''), COALESCE(w.status, ''),
+		       w.last_heartbeat, j.queue_paused_at IS NOT NULL,
+		       COALESCE(b.schedule_skip_reason, ''), b.schedule_skipped_at
+		FROM builds b
+		JOIN jobs j ON j.id = b.job_id
+		LEFT JOIN workers w ON w.id = b.worker_id
+		WHERE (b.status = 'queued' AND b.queued_at < $1)
+		   OR (b.status = 'running' AND (w.id IS NULL OR w.status = 'offline'))
+		ORDER BY b.queued_at ASC
+		LIMIT $2
+	`, time.Now().Add(-queuedFor), maxStuckBuilds)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query stuck builds")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch stuck builds")
+		return
+	}
+	defer rows.Close()
+
+	schedulerPaused := h.settings.Current().SchedulerPaused
+	builds := []stuckBuild{}
+	for rows.Next() {
+		var b stuckBuild
+		var workerStatus string
+		var queuePaused bool
+		var skippedAt sql.NullTime
+		if err := rows.Scan(&b.BuildID, &b.JobID, &b.JobName, &b.BuildNumber, &b.Status, &b.Pool,
+			&b.QueuedAt, &b.StartedAt, &b.WorkerID, &b.WorkerName, &workerStatus,
+			&b.LastHeartbeat, &queuePaused, &b.SkipReason, &skippedAt); err != nil {
+			log.Error().Err(err).Msg("Failed to scan stuck build")
+			continue
+		}
+
+		switch {
+		case b.Status == "queued":
+			b.Stuck = stuckQueued
+			var recorded bool
+			b.SkipReason, recorded = scheduler.SkipReason(b.SkipReason, schedulerPaused, queuePaused)
+			if recorded && skippedAt.Valid {
+				b.SkippedAt = &skippedAt.Time
+			}
+		case workerStatus == "":
+			b.Stuck = stuckWorkerGone
+		default:
+			b.Stuck = stuckWorkerOffline
+		}
+		builds = append(builds, b)
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"scheduler_paused": schedulerPaused,
+		"queued_for":       queuedFor.String(),
+		"builds":           builds,
+	})
+}
+
+// RequeueBuild puts a build running on an offline or removed worker back in
+// the queue, for another worker to take
+func (h *AdminHandler) RequeueBuild(w http.ResponseWriter, r *http.Request) {
+	h.forceBuild(w, r, false)
+}
+
+// FailBuild fails a queued build, or one running on an offline or removed
+// worker. The optional body is {"message": string}, the build's error
+// message.
+func (h *AdminHandler) FailBuild(w http.ResponseWriter, r *http.Request) {
+	h.forceBuild(w, r, true)
+}
+
+// RequeueBuilds requeues builds as RequeueBuild does; the body is
+// {"build_ids": [...]}. Builds that cannot be requeued are skipped, with
+// why.
+func (h *AdminHandler) RequeueBuilds(w http.ResponseWriter, r *http.Request) {
+	h.forceBuilds(w, r, false)
+}
+
+// FailBuilds fails builds as FailBuild does; the body is {"build_ids": [...],
+// "message": string}. Builds that cannot be failed are skipped, with why.
+func (h *AdminHandler) FailBuilds(w http.ResponseWriter, r *http.Request) {
+	h.forceBuilds(w, r, true)
+}
+
+// forceRequest is the body of the requeue and fail endpoints
+type forceRequest struct {
+	BuildIDs []uuid.UUID `json:"build_ids"`
+	Message  string      `json:"message"`
+}
+
+// skippedBuild is a build a forced requeue or failure left alone
+type skippedBuild struct {
+	BuildID uuid.UUID `json:"build_id"`
+	Reason  string    `json:"reason"`
+}
+
+func (h *AdminHandler) forceBuild(w http.ResponseWriter, r *http.Request, fail bool) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+	var req forceRequest
+	if fail && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			SendError(w, http.StatusBadRequest, err, "Invalid request body")
+			return
+		}
+	}
+
+	skip, err := h.force(r.Context(), buildID, fail, req.Message, principal.Username)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to force build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update build")
+		return
+	}
+	switch skip {
+	case "":
+	case forceSkipNotFound:
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	default:
+		SendError(w, http.StatusConflict, errors.New(skip), forceSkipMessage(skip))
+		return
+	}
+
+	status := "queued"
+	if fail {
+		status = "failed"
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{"build_id": buildID, "status": status})
+}
+
+func (h *AdminHandler) forceBuilds(w http.ResponseWriter, r *http.Request, fail bool) {
+	principal, ok := authorizeAdmin(h.auth, w, r)
+	if !ok {
+		return
+	}
+	var req forceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if len(req.BuildIDs) == 0 || len(req.BuildIDs) > maxStuckBuilds {
+		SendError(w, http.StatusBadRequest, nil, fmt.Sprintf("build_ids must list 1 to %d builds", maxStuckBuilds))
+		return
+	}
+
+	done := []uuid.UUID{}
+	skipped := []skippedBuild{}
+	seen := map[uuid.UUID]bool{}
+	for _, buildID := range req.BuildIDs {
+		if seen[buildID] {
+			continue
+		}
+		seen[buildID] = true
+		skip, err := h.force(r.Context(), buildID, fail, req.Message, principal.Username)
+		if err != nil {
+			log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to force build")
+			SendError(w, http.StatusInternalServerError, err, "Failed to update builds")
+			return
+		}
+		if skip != "" {
+			skipped = append(skipped, skippedBuild{BuildID: buildID, Reason: skip})
+			continue
+		}
+		done = append(done, buildID)
+	}
+
+	key := "requeued"
+	if fail {
+		key = "failed"
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{key: done, "skipped": skipped})
+}
+
+// force requeues or fails a build, returning why it was left alone if it
+// was. A build running on a live worker is left alone, as the worker would
+// go on running it.
+func (h *AdminHandler) force(ctx context.Context, buildID uuid.UUID, fail bool, message, by string) (string, error) {
+	var skip string
+	err := h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var jobID uuid.UUID
+		var status string
+		var workerID uuid.NullUUID
+		var workerStatus sql.NullString
+		err := tx.QueryRowContext(ctx, `
+			SELECT b.job_id, b.status, b.worker_id, w.status
+			FROM builds b
+			LEFT JOIN workers w ON w.id = b.worker_id
+			WHERE b.id = $1
+			FOR UPDATE OF b
+		`, buildID).Scan(&jobID, &status, &workerID, &workerStatus)
+		switch {
+		case err == sql.ErrNoRows:
+			skip = forceSkipNotFound
+			return nil
+		case err != nil:
+			return err
+		case status != "queued" && status != "running":
+			skip = forceSkipCompleted
+			return nil
+		case status == "running" && workerStatus.Valid && workerStatus.String != "offline":
+			skip = forceSkipWorkerOnline
+			return nil
+		case status == "queued" && !fail:
+			skip = forceSkipAlreadyQueued
+			return nil
+		}
+
+		if fail {
+			if message == "" {
+				message = "Failed by an administrator"
+			}
+			_, err := tx.ExecContext(ctx, `
+				UPDATE builds
+				SET status = 'failed', error_message = $2, failure_reason = $3, completed_at = CURRENT_TIMESTAMP
+				WHERE id = $1
+			`, buildID, message, failureReasonAdmin)
+			if err != nil {
+				return err
+			}
+			return outbox.WriteBuildEvent(ctx, tx, &jobID, buildID, "build.status", map[string]interface{}{
+				"build_id": buildID, "job_id": jobID, "status": "failed",
+				"error_message": message, "failure_reason": failureReasonAdmin,
+			})
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE builds
+			SET status = 'queued', worker_id = NULL, started_at = NULL,
+			    schedule_skip_reason = NULL, schedule_skipped_at = NULL
+			WHERE id = $1
+		`, buildID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO build_events (build_id, type, occurred_at, metadata)
+			VALUES ($1, $2, CURRENT_TIMESTAMP, jsonb_build_object('reason', 'admin_requeue', 'worker_id', $3::text, 'by', $4::text))
+		`, buildID, models.BuildEventQueued, workerID, by)
+		if err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, buildID, "build.status", map[string]interface{}{
+			"build_id": buildID, "job_id": jobID, "status": "queued",
+		})
+	})
+	if err == nil && skip == "" {
+		log.Info().Str("build_id", buildID.String()).Bool("failed", fail).Str("by", by).Msg("Build forced by an administrator")
+	}
+	return skip, err
+}
+
+// forceSkipMessage describes why a forced requeue or failure left a build
+// alone
+func forceSkipMessage(skip string) string {
+	switch skip {
+	case forceSkipCompleted:
+		return "Build has already completed"
+	case forceSkipWorkerOnline:
+		return "Build is running on an online worker; cancel it instead"
+	case forceSkipAlreadyQueued:
+		return "Build is already queued"
+	}
+	return skip
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+)
+
+// Why a build was cancelled, as its cancel_reason
+const (
+	cancelReasonUser              = "user"                // through the cancel endpoint
+	cancelReasonSuperseded        = "superseded"          // by a newer build of the same branch or pull request
+	cancelReasonPullRequestClosed = "pull_request_closed" // the pull request was closed or merged
+)
+
+// buildScope is the branch or pull request whose builds a new build
+// supersedes. Builds of a pull request are only superseded by builds of the
+// same pull request, and tag builds are never superseded.
+type buildScope struct {
+	jobID       uuid.UUID
+	branch      string
+	source      string // the webhook source of a pull request
+	pullRequest string // the pull request number
+}
+
+// cancelInProgress cancels the job's queued and running builds in scope,
+// other than supersededBy, and returns their IDs. With supersededBy set the
+// cancelled builds point to it. Running builds are stopped by their agents,
+// which poll for cancellation.
+func cancelInProgress(ctx context.Context, db *database.Database, scope buildScope, reason string, supersededBy *uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		UPDATE builds
+		SET status = 'cancelled', completed_at = CURRENT_TIMESTAMP,
+		    cancel_reason = $2, superseded_by = $3
+		WHERE job_id = $1 AND status IN ('queued', 'running')
+		  AND id IS DISTINCT FROM $3
+	`
+	args := []interface{}{scope.jobID, reason, supersededBy}
+	if scope.pullRequest != "" {
+		query += `
+		  AND triggered_by = 'pull_request'
+		  AND trigger_metadata->>'source' = $4
+		  AND trigger_metadata->>'pull_request' = $5`
+		args = append(args, scope.source, scope.pullRequest)
+	} else {
+		query += `
+		  AND triggered_by IS DISTINCT FROM 'pull_request'
+		  AND branch = $4
+		  AND COALESCE(parameters->>'tag', '') = ''`
+		args = append(args, scope.branch)
+	}
+	query += ` RETURNING id`
+
+	cancelled := []uuid.UUID{}
+	err := db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			cancelled = append(cancelled, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, id := range cancelled {
+			data := map[string]interface{}{
+				"build_id": id, "job_id": scope.jobID, "status": "cancelled",
+				"cancel_reason": reason, "superseded_by": supersededBy,
+			}
+			if err := outbox.WriteBuildEvent(ctx, tx, &scope.jobID, id, "build.status", data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cancelled, nil
+}
+
+// supersede cancels the builds in scope that the new build replaces. Failures
+// are logged; the new build is queued either way.
+func supersede(ctx context.Context, db *database.Database, scope buildScope, build uuid.UUID) {
+	if scope.branch == "" && scope.pullRequest == "" {
+		return
+	}
+	cancelled, err := cancelInProgress(ctx, db, scope, cancelReasonSuperseded, &build)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", scope.jobID.String()).Str("build_id", build.String()).Msg("Failed to cancel superseded builds")
+		return
+	}
+	for _, id := range cancelled {
+		log.Info().Str("job_id", scope.jobID.String()).Str("build_id", id.String()).
+			Str("superseded_by", build.String()).Msg("Build superseded")
+	}
+}
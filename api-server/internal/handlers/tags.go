@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// maxBuildTags is how many tags can be added to a build at once
+const maxBuildTags = 50
+
+// buildTag is the form of a build tag, e.g. release-candidate or perf-baseline
+var buildTag = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/+-]{0,63}$`)
+
+// validateTags checks the tags to add to a build
+func validateTags(tags []string) error {
+	if len(tags) > maxBuildTags {
+		return fmt.Errorf("at most %d tags at once", maxBuildTags)
+	}
+	for _, tag := range tags {
+		if !buildTag.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q: want letters, digits and . _ : / + -, at most 64 characters", tag)
+		}
+	}
+	return nil
+}
+
+// AddBuildTags adds tags to a build, as {"tags": [string]}, and returns all
+// its tags. Tags it already has are left alone. Plugin steps tag builds
+// through it too.
+func (h *BuildHandler) AddBuildTags(w http.ResponseWriter, r *http.Request) {
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if err := validateTags(req.Tags); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid tags")
+		return
+	}
+
+	var tags []string
+	err = h.db.GetConn().QueryRowContext(r.Context(), `
+		UPDATE builds
+		SET tags = ARRAY(SELECT DISTINCT t FROM unnest(tags || $2::text[]) t ORDER BY t)
+		WHERE id = $1
+		RETURNING tags
+	`, buildID, pq.Array(req.Tags)).Scan(pq.Array(&tags))
+	h.sendBuildTags(w, buildID, tags, err)
+}
+
+// RemoveBuildTag removes a tag from a build and returns its other tags
+func (h *BuildHandler) RemoveBuildTag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var tags []string
+	err = h.db.GetConn().QueryRowContext(r.Context(), `
+		UPDATE builds SET tags = array_remove(tags, $2) WHERE id = $1 RETURNING tags
+	`, buildID, vars["tag"]).Scan(pq.Array(&tags))
+	h.sendBuildTags(w, buildID, tags, err)
+}
+
+// sendBuildTags sends the tags of a build after they changed, or the error
+// changing them
+func (h *BuildHandler) sendBuildTags(w http.ResponseWriter, buildID uuid.UUID, tags []string, err error) {
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to update build tags")
+		SendError(w, http.StatusInternalServerError, err, "Failed to update build tags")
+		return
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{"build_id": buildID, "tags": tags})
+}
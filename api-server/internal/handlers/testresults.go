@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// maxTestResultsBytes limits the size of one AppendBuildTestResults request
+const maxTestResultsBytes = 32 << 20
+
+// testStatuses are the valid test case statuses
+var testStatuses = []string{
+	models.TestStatusPassed, models.TestStatusFailed, models.TestStatusError, models.TestStatusSkipped,
+}
+
+func validTestStatus(status string) bool {
+	for _, s := range testStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendBuildTestResults stores test cases reported during a build, as
+// {"step": "...", "test_cases": [...]} as sent by the worker agent
+func (h *BuildHandler) AppendBuildTestResults(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		Step      string              `json:"step"`
+		TestCases []models.TestResult `json:"test_cases"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTestResultsBytes)).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	for _, t := range req.TestCases {
+		if t.Name == "" || !validTestStatus(t.Status) {
+			SendError(w, http.StatusBadRequest, nil, "Test cases need a name and a status of passed, failed, error or skipped")
+			return
+		}
+	}
+
+	var exists bool
+	err = h.db.GetConn().QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM builds WHERE id = $1)`, buildID).Scan(&exists)
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to look up build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store test results")
+		return
+	}
+	if !exists {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+
+	query := `
+		INSERT INTO build_test_results (build_id, step, suite, class_name, name, status,
+		                                message, details, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		for _, t := range req.TestCases {
+			_, err := tx.ExecContext(ctx, query, buildID, req.Step, t.Suite, t.ClassName, t.Name, t.Status,
+				t.Message, t.Details, t.DurationMs)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to store test results")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store test results")
+		return
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{"stored": len(req.TestCases)})
+}
+
+// ListBuildTestResults lists a build's test cases, errors and failures first,
+// with the number of each status. The status query parameter filters by a
+// comma-separated list of statuses and step by the plugin that reported them.
+func (h *BuildHandler) ListBuildTestResults(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	buildID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var statuses []string
+	if s := r.URL.Query().Get("status"); s != "" {
+		for _, status := range strings.Split(s, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if !validTestStatus(status) {
+				SendError(w, http.StatusBadRequest, nil, "Invalid status (want passed, failed, error or skipped)")
+				return
+			}
+			statuses = append(statuses, status)
+		}
+	}
+
+	query := `
+		SELECT id, build_id, step, suite, class_name, name, status,
+		       message, details, duration_ms, created_at
+		FROM build_test_results
+		WHERE build_id = $1
+	`
+	args := []interface{}{buildID}
+	argCount := 2
+
+	if step := r.URL.Query().Get("step"); step != "" {
+		query += ` AND step = $` + strconv.Itoa(argCount)
+		args = append(args, step)
+		argCount++
+	}
+	countQuery := `SELECT status, COUNT(*) FROM (` + query + `) t GROUP BY status`
+	countArgs := append([]interface{}{}, args...)
+
+	if statuses != nil {
+		query += ` AND status = ANY($` + strconv.Itoa(argCount) + `)`
+		args = append(args, pq.Array(statuses))
+	}
+	query += ` ORDER BY CASE status
+		WHEN 'error' THEN 0 WHEN 'failed' THEN 1 WHEN 'skipped' THEN 2 ELSE 3
+		END, suite, class_name, name`
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query test results")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch test results")
+		return
+	}
+	defer rows.Close()
+
+	list := []models.TestResult{}
+	for rows.Next() {
+		var t models.TestResult
+		err := rows.Scan(&t.ID, &t.BuildID, &t.Step, &t.Suite, &t.ClassName, &t.Name, &t.Status,
+			&t.Message, &t.Details, &t.DurationMs, &t.CreatedAt)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan test result row")
+			continue
+		}
+		list = append(list, t)
+	}
+
+	counts := make(map[string]int, len(testStatuses))
+	for _, status := range testStatuses {
+		counts[status] = 0
+	}
+	countRows, err := h.db.GetConn().QueryContext(ctx, countQuery, countArgs...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count test results")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch test results")
+		return
+	}
+	defer countRows.Close()
+	for countRows.Next() {
+		var status string
+		var n int
+		if err := countRows.Scan(&status, &n); err != nil {
+			log.Error().Err(err).Msg("Failed to scan test result count")
+			continue
+		}
+		counts[status] = n
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"test_results": list,
+		"counts":       counts,
+	})
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+)
+
+// maxEventBatch caps the number of events accepted in one request
+const maxEventBatch = 500
+
+// AppendBuildEvents records lifecycle events of a build, as
+// {"events": [{"type": "step_started", "name": "git-scm", "timestamp": "..."}]}
+// (used by worker agents). Events without a timestamp happened now.
+func (h *BuildHandler) AppendBuildEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	var req struct {
+		Events []models.BuildEvent `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid request body")
+		return
+	}
+	if len(req.Events) > maxEventBatch {
+		SendError(w, http.StatusBadRequest, nil, "Too many events in one request")
+		return
+	}
+	for _, e := range req.Events {
+		if !models.ValidBuildEventType(e.Type) {
+			SendError(w, http.StatusBadRequest, nil, "Invalid event type: "+e.Type)
+			return
+		}
+	}
+
+	tx, err := h.db.GetConn().BeginTx(ctx, nil)
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store events")
+		return
+	}
+	defer tx.Rollback()
+
+	var jobName string
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(j.name, '') FROM builds b LEFT JOIN jobs j ON j.id = b.job_id WHERE b.id = $1
+	`, buildID).Scan(&jobName)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store events")
+		return
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO build_events (build_id, type, name, status, occurred_at, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`)
+	if err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store events")
+		return
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for i := range req.Events {
+		e := &req.Events[i]
+		if e.Timestamp.IsZero() {
+			e.Timestamp = now
+		}
+		if e.Metadata == nil {
+			e.Metadata = models.JSONB{}
+		}
+		if _, err := stmt.ExecContext(ctx, buildID, e.Type, e.Name, e.Status, e.Timestamp, e.Metadata); err != nil {
+			log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to insert build event")
+			SendError(w, http.StatusInternalServerError, err, "Failed to store events")
+			return
+		}
+	}
+	for _, e := range req.Events {
+		if err := outbox.WriteBuildEvent(ctx, tx, nil, buildID, "build.event", e); err != nil {
+			SendError(w, http.StatusInternalServerError, err, "Failed to store events")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to store events")
+		return
+	}
+	h.recordPluginSteps(jobName, req.Events)
+
+	SendJSON(w, http.StatusOK, map[string]int{"stored": len(req.Events)})
+}
+
+// recordPluginSteps records the finished plugin steps among events in the
+// per-plugin metrics, from the duration_ms and findings the agent reports
+// with them
+func (h *BuildHandler) recordPluginSteps(jobName string, events []models.BuildEvent) {
+	if h.metrics == nil {
+		return
+	}
+	for _, e := range events {
+		if e.Type != models.BuildEventStepFinished || e.Name == "" {
+			continue
+		}
+		durationMs, _ := e.Metadata["duration_ms"].(float64)
+		findings, _ := e.Metadata["findings"].(float64)
+		h.metrics.RecordPluginStep(e.Name, jobName, e.Status, durationMs/1000, int(findings))
+	}
+}
+
+// GetBuildTimeline returns the lifecycle events of a build in order, from
+// being queued to finishing, and the spans of time between each start event
+// and its end: the wait in the queue, each stage and plugin step, and the
+// build as a whole. A span that has not ended runs until now.
+func (h *BuildHandler) GetBuildTimeline(w http.ResponseWriter, r *http.Request) {
+	buildID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid build ID")
+		return
+	}
+
+	timeline, err := h.loadTimeline(r.Context(), buildID)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Build not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("build_id", buildID.String()).Msg("Failed to load build timeline")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch timeline")
+		return
+	}
+	SendJSON(w, http.StatusOK, timeline)
+}
+
+// loadTimeline reads a build's events and computes its spans. It returns
+// sql.ErrNoRows if the build does not exist.
+func (h *BuildHandler) loadTimeline(ctx context.Context, buildID uuid.UUID) (*models.BuildTimeline, error) {
+	timeline := &models.BuildTimeline{BuildID: buildID, Events: []models.BuildEvent{}, Spans: []models.TimelineSpan{}}
+	var startedAt, completedAt *time.Time
+	err := h.db.GetConn().QueryRowContext(ctx, `
+		SELECT status, queued_at, started_at, completed_at FROM builds WHERE id = $1
+	`, buildID).Scan(&timeline.Status, &timeline.QueuedAt, &startedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.GetConn().QueryContext(ctx, `
+		SELECT type, COALESCE(name, ''), COALESCE(status, ''), occurred_at, metadata
+		FROM build_events
+		WHERE build_id = $1
+		ORDER BY occurred_at, id
+	`, buildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// The queued and finished events come from the build itself
+	timeline.Events = append(timeline.Events, models.BuildEvent{Type: models.BuildEventQueued, Timestamp: timeline.QueuedAt})
+	for rows.Next() {
+		var e models.BuildEvent
+		if err := rows.Scan(&e.Type, &e.Name, &e.Status, &e.Timestamp, &e.Metadata); err != nil {
+			log.Error().Err(err).Msg("Failed to scan build event")
+			continue
+		}
+		timeline.Events = append(timeline.Events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if completedAt != nil {
+		timeline.Events = append(timeline.Events, models.BuildEvent{
+			Type: models.BuildEventFinished, Status: string(timeline.Status), Timestamp: *completedAt,
+		})
+	}
+
+	timeline.Spans = timelineSpans(timeline.Events, startedAt, completedAt, time.Now())
+	return timeline, nil
+}
+
+// timelineSpans pairs the start and end events of the timeline. The queue
+// span ends when the build was assigned or started, and the build span runs
+// from then until it finished.
+func timelineSpans(events []models.BuildEvent, startedAt, completedAt *time.Time, now time.Time) []models.TimelineSpan {
+	spans := []models.TimelineSpan{}
+	end := func(s *models.TimelineSpan, at time.Time, status string) {
+		s.FinishedAt = &at
+		s.Status = status
+	}
+
+	// The first of assigned and started ends the wait in the queue
+	queued := events[0].Timestamp
+	var dequeued *time.Time
+	for _, e := range events {
+		if e.Type == models.BuildEventAssigned || e.Type == models.BuildEventStarted {
+			t := e.Timestamp
+			dequeued = &t
+			break
+		}
+	}
+	if dequeued == nil {
+		dequeued = startedAt
+	}
+	queue := models.TimelineSpan{Kind: "queue", Name: "queue", StartedAt: queued}
+	if dequeued != nil {
+		end(&queue, *dequeued, "")
+	}
+	spans = append(spans, queue)
+	if dequeued != nil {
+		build := models.TimelineSpan{Kind: "build", Name: "build", StartedAt: *dequeued}
+		if completedAt != nil {
+			end(&build, *completedAt, "")
+		}
+		spans = append(spans, build)
+	}
+
+	// Stages, steps and notifications, matched by kind and name
+	open := map[string]int{}
+	for _, e := range events {
+		kind, started := strings.CutSuffix(e.Type, "_started")
+		if !started {
+			var finished bool
+			if kind, finished = strings.CutSuffix(e.Type, "_finished"); !finished {
+				continue
+			}
+			if i, ok := open[kind+"/"+e.Name]; ok {
+				end(&spans[i], e.Timestamp, e.Status)
+				delete(open, kind+"/"+e.Name)
+			}
+			continue
+		}
+		open[kind+"/"+e.Name] = len(spans)
+		spans = append(spans, models.TimelineSpan{Kind: kind, Name: e.Name, StartedAt: e.Timestamp})
+	}
+
+	for i := range spans {
+		finished := now
+		if spans[i].FinishedAt != nil {
+			finished = *spans[i].FinishedAt
+		}
+		spans[i].DurationMS = finished.Sub(spans[i].StartedAt).Milliseconds()
+	}
+	return spans
+}
@@ -1,29 +1,386 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
 	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
 	"github.com/solvyd/solvyd/api-server/internal/scheduler"
+	"github.com/solvyd/solvyd/api-server/internal/tracing"
+	"github.com/solvyd/solvyd/api-server/internal/triggers"
 )
 
 // WebhookHandler handles webhook requests from SCM providers
 type WebhookHandler struct {
 	db    *database.Database
 	sched *scheduler.Scheduler
+	auth  *auth.Authenticator
 }
 
 // NewWebhookHandler creates a new webhook handler
-func NewWebhookHandler(db *database.Database, sched *scheduler.Scheduler) *WebhookHandler {
-	return &WebhookHandler{db: db, sched: sched}
+func NewWebhookHandler(db *database.Database, sched *scheduler.Scheduler, authenticator *auth.Authenticator) *WebhookHandler {
+	return &WebhookHandler{db: db, sched: sched, auth: authenticator}
+}
+
+// maxWebhookBytes limits the size of a webhook payload
+const maxWebhookBytes = 4 << 20
+
+var webhookSourcePattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// webhookEvent is a push or pull request event a webhook delivered
+type webhookEvent struct {
+	Type        string // triggers.TypeWebhook for a push or triggers.TypePullRequest
+	Event       string // the sender's event name
+	Ref         string // the pushed ref, or the ref holding a pull request's merge result
+	Commit      string
+	Message     string
+	Author      string
+	Deleted     bool         // a deleted ref
+	PullRequest *pullRequest // for pull request events
 }
 
-// HandleWebhook processes incoming webhooks
+// HandleWebhook queues a build of a job for a push or pull request webhook
+// from GitHub (source "github"), GitLab ("gitlab") or any other sender
+// posting {"ref": "refs/heads/main", "commit_sha": "..."}. Pushes are built if
+// the job has a webhook trigger, and pull requests if it has a pull_request
+// trigger, whose branch and tag filters allow the ref; other events and
+// deleted refs are acknowledged and ignored. A new build cancels the
+// in-progress builds it supersedes: those of the same pull request, or of the
+// same branch if the job has cancel_in_progress set.
+//
+// Requests are verified against the job's secret for the source before
+// anything else is done with them (see verifyWebhook).
 func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement webhook processing for GitHub, GitLab, etc.
-	// This would parse webhook payload, verify signatures, and trigger builds
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	source := vars["source"]
+	jobID, err := uuid.Parse(vars["jobId"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid webhook payload")
+		return
+	}
+	if !h.verifyWebhook(w, r, jobID, source, body) {
+		return
+	}
+
+	event, ok, err := parseWebhookEvent(source, r.Header, body)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid webhook payload")
+		return
+	}
+	if !ok {
+		SendJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "not a push or pull request event"})
+		return
+	}
+	if event.Deleted {
+		SendJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "ref deleted"})
+		return
+	}
+
+	var jobTriggers models.JSONArray
+	var cancelPrevious bool
+	var disabled jobDisabled
+	err = h.db.GetConn().QueryRowContext(ctx, `
+		SELECT triggers, cancel_in_progress, NOT enabled, COALESCE(disabled_reason, ''), reenable_at
+		FROM jobs
+		WHERE id = $1
+	`, jobID).Scan(&jobTriggers, &cancelPrevious, &disabled.disabled, &disabled.reason, &disabled.reenableAt)
+	if err == sql.ErrNoRows {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to query job")
+		SendError(w, http.StatusInternalServerError, err, "Failed to process webhook")
+		return
+	}
+
+	pr := event.PullRequest
+	if pr != nil && pr.Closed {
+		scope := buildScope{jobID: jobID, source: source, pullRequest: strconv.Itoa(pr.Number)}
+		cancelled, err := cancelInProgress(ctx, h.db, scope, cancelReasonPullRequestClosed, nil)
+		if err != nil {
+			log.Error().Err(err).Str("job_id", jobID.String()).Int("pull_request", pr.Number).Msg("Failed to cancel builds of a closed pull request")
+		}
+		SendJSON(w, http.StatusOK, map[string]interface{}{"status": "ignored", "reason": "pull request closed", "cancelled": cancelled})
+		return
+	}
+
+	if disabled.disabled {
+		SendJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": disabled.String()})
+		return
+	}
+	if !triggers.Has(jobTriggers, event.Type) {
+		SendJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "job has no " + event.Type + " trigger"})
+		return
+	}
+
+	// Pull requests are filtered by the branch they merge into
+	ref := triggers.ParseRef(event.Ref)
+	if pr != nil {
+		ref = triggers.Ref{Branch: pr.BaseBranch}
+	}
+	if ok, reason := triggers.Allowed(jobTriggers, event.Type, ref); !ok {
+		log.Info().Str("job_id", jobID.String()).Str("ref", event.Ref).Str("reason", reason).Msg("Webhook filtered")
+		SendJSON(w, http.StatusOK, map[string]string{"status": "skipped", "reason": reason})
+		return
+	}
+
+	triggeredBy := "webhook"
+	branch := ref.Branch
+	params := map[string]interface{}{}
+	metadata := map[string]interface{}{
+		"source": source,
+		"event":  event.Event,
+		"ref":    event.Ref,
+	}
+	if ref.Tag != "" {
+		params["tag"] = ref.Tag
+	}
+	if pr != nil {
+		triggeredBy = "pull_request"
+		branch = pr.HeadBranch
+		pr.addTo(params, metadata, event.Ref)
+	}
+	paramsJSON, _ := json.Marshal(params)
+	metadataJSON, _ := json.Marshal(metadata)
+	traceJSON, _ := json.Marshal(tracing.Carrier(ctx))
+
+	query := `
+		INSERT INTO builds (job_id, status, triggered_by, trigger_metadata, parameters, branch,
+		                    scm_commit_sha, scm_commit_message, scm_author, trace_context)
+		VALUES ($1, 'queued', $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, build_number, queued_at
+	`
+	var build struct {
+		ID          uuid.UUID `json:"id"`
+		BuildNumber int       `json:"build_number"`
+		QueuedAt    string    `json:"queued_at"`
+	}
+	err = h.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, jobID, triggeredBy, metadataJSON, paramsJSON, branch,
+			event.Commit, event.Message, event.Author, traceJSON).Scan(&build.ID, &build.BuildNumber, &build.QueuedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE webhooks SET last_triggered_at = CURRENT_TIMESTAMP, trigger_count = trigger_count + 1
+			WHERE job_id = $1 AND source = $2
+		`, jobID, source); err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, build.ID, "build.queued", map[string]interface{}{
+			"build_id": build.ID, "job_id": jobID, "build_number": build.BuildNumber,
+			"branch": branch, "triggered_by": triggeredBy, "queued_at": build.QueuedAt,
+		})
+	})
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to trigger build")
+		SendError(w, http.StatusInternalServerError, err, "Failed to trigger build")
+		return
+	}
+
+	log.Info().
+		Str("job_id", jobID.String()).
+		Str("build_id", build.ID.String()).
+		Str("ref", event.Ref).
+		Msg("Build triggered by webhook")
+
+	// A new push to a pull request always makes its earlier builds out of
+	// date; builds of a branch only with the job's cancel_in_progress
+	if pr != nil {
+		supersede(ctx, h.db, buildScope{jobID: jobID, source: source, pullRequest: strconv.Itoa(pr.Number)}, build.ID)
+	} else if cancelPrevious && ref.Tag == "" {
+		supersede(ctx, h.db, buildScope{jobID: jobID, branch: branch}, build.ID)
+	}
+	SendJSON(w, http.StatusAccepted, build)
+}
+
+// verifyWebhook checks a webhook request against the job's secret for the
+// source, writing the error response if it does not match: GitHub's
+// X-Hub-Signature-256 HMAC of the body, GitLab's X-Gitlab-Token, and for
+// other senders an X-Solvyd-Signature-256 computed as GitHub's is. Jobs
+// without a secret for the source accept no webhooks from it.
+func (h *WebhookHandler) verifyWebhook(w http.ResponseWriter, r *http.Request, jobID uuid.UUID, source string, body []byte) bool {
+	var secret string
+	err := h.db.GetConn().QueryRowContext(r.Context(), `
+		SELECT COALESCE(secret_token, '') FROM webhooks
+		WHERE job_id = $1 AND source = $2 AND enabled
+	`, jobID, source).Scan(&secret)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to query webhook secret")
+		SendError(w, http.StatusInternalServerError, err, "Failed to process webhook")
+		return false
+	}
+	if secret == "" {
+		SendError(w, http.StatusUnauthorized, nil, "No webhook secret is configured for this job and source")
+		return false
+	}
+
+	var valid bool
+	switch source {
+	case "gitlab":
+		valid = subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+	default:
+		header := "X-Solvyd-Signature-256"
+		if source == "github" {
+			header = "X-Hub-Signature-256"
+		}
+		signature, err := hex.DecodeString(strings.TrimPrefix(r.Header.Get(header), "sha256="))
+		if err == nil {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(body)
+			valid = hmac.Equal(signature, mac.Sum(nil))
+		}
+	}
+	if !valid {
+		log.Warn().Str("job_id", jobID.String()).Str("source", source).Msg("Rejected webhook with an invalid signature")
+		SendError(w, http.StatusUnauthorized, nil, "Invalid webhook signature")
+		return false
+	}
+	return true
+}
+
+// SetWebhookSecret generates a new secret for a job's webhooks from a source,
+// replacing any earlier one, and returns it. The secret is shown only here:
+// it is configured as the webhook's secret at GitHub, its secret token at
+// GitLab, or the HMAC key of X-Solvyd-Signature-256 for other senders.
+func (h *WebhookHandler) SetWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	principal, ok := authenticate(h.auth, w, r)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	jobID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid job ID")
+		return
+	}
+	source := vars["source"]
+	if !webhookSourcePattern.MatchString(source) {
+		SendError(w, http.StatusBadRequest, nil, "source must be lowercase alphanumerics, '_' or '-'")
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		SendError(w, http.StatusInternalServerError, err, "Failed to generate webhook secret")
+		return
+	}
+	secret := hex.EncodeToString(raw)
+
+	_, err = h.db.GetConn().ExecContext(ctx, `
+		INSERT INTO webhooks (job_id, source, secret_token)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (job_id, source) DO UPDATE SET secret_token = EXCLUDED.secret_token, enabled = true
+	`, jobID, source, secret)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		SendError(w, http.StatusNotFound, nil, "Job not found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID.String()).Msg("Failed to store webhook secret")
+		SendError(w, http.StatusInternalServerError, err, "Failed to store webhook secret")
+		return
+	}
+
+	log.Info().Str("job_id", jobID.String()).Str("source", source).Str("by", principal.Username).Msg("Webhook secret rotated")
 	SendJSON(w, http.StatusOK, map[string]string{
-		"status": "webhook received",
-		"note":   "Implementation pending",
+		"source": source,
+		"secret": secret,
+		"url":    "/webhooks/" + source + "/" + jobID.String(),
 	})
 }
+
+// parseWebhookEvent reads a push or pull request event from a webhook
+// request. It returns false for other events, such as GitHub's ping.
+func parseWebhookEvent(source string, header http.Header, body []byte) (*webhookEvent, bool, error) {
+	var name string
+	switch source {
+	case "github":
+		name = header.Get("X-GitHub-Event")
+		if name != "push" && name != "pull_request" {
+			return nil, false, nil
+		}
+	case "gitlab":
+		name = header.Get("X-Gitlab-Event")
+		if name != "Push Hook" && name != "Tag Push Hook" && name != "Merge Request Hook" {
+			return nil, false, nil
+		}
+	default:
+		name = "push"
+	}
+
+	if name == "pull_request" || name == "Merge Request Hook" {
+		return parsePullRequestEvent(source, name, body)
+	}
+
+	var payload struct {
+		Ref         string `json:"ref"`
+		After       string `json:"after"`
+		CheckoutSHA string `json:"checkout_sha"`
+		CommitSHA   string `json:"commit_sha"`
+		Deleted     bool   `json:"deleted"`
+		HeadCommit  *struct {
+			Message string `json:"message"`
+			Author  struct {
+				Name string `json:"name"`
+			} `json:"author"`
+		} `json:"head_commit"`
+		UserName string `json:"user_name"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, false, err
+	}
+	if payload.Ref == "" {
+		return nil, false, nil
+	}
+
+	push := &webhookEvent{Type: triggers.TypeWebhook, Event: name, Ref: payload.Ref, Commit: payload.CommitSHA}
+	switch source {
+	case "github":
+		push.Commit = payload.After
+		push.Deleted = payload.Deleted
+		if payload.HeadCommit != nil {
+			push.Message = payload.HeadCommit.Message
+			push.Author = payload.HeadCommit.Author.Name
+		}
+	case "gitlab":
+		// GitLab sends no checkout SHA for a deleted ref
+		push.Commit = payload.CheckoutSHA
+		push.Deleted = payload.CheckoutSHA == ""
+		push.Author = payload.UserName
+	}
+	if strings.Trim(push.Commit, "0") == "" {
+		push.Deleted = push.Deleted || push.Commit != ""
+		push.Commit = ""
+	}
+	return push, true, nil
+}
@@ -1,71 +1,383 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/auth"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+)
+
+const (
+	// wsSendBuffer is how many frames may wait for a slow client before it is
+	// disconnected
+	wsSendBuffer = 256
+	// wsMaxFrameBytes limits the size of a frame from a client
+	wsMaxFrameBytes = 4096
+	// wsMaxSubscriptions limits the channels one client subscribes to
+	wsMaxSubscriptions = 100
+
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = wsPongTimeout * 9 / 10
+)
+
+// Channels clients subscribe to: the events of one build, the builds of one
+// job, and the workers
+const (
+	channelBuildPrefix = outbox.BuildChannelPrefix
+	channelJobPrefix   = outbox.JobChannelPrefix
+	channelWorkers     = "workers"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // TODO: Implement proper origin checking
-	},
+// Frames exchanged over a connection. Clients send subscribe, unsubscribe and
+// ping frames, and the server answers each with subscribed, unsubscribed,
+// pong or error, and sends an event frame for each event on a channel the
+// client subscribed to.
+const (
+	frameSubscribe    = "subscribe"
+	frameUnsubscribe  = "unsubscribe"
+	framePing         = "ping"
+	frameSubscribed   = "subscribed"
+	frameUnsubscribed = "unsubscribed"
+	framePong         = "pong"
+	frameError        = "error"
+	frameEvent        = "event"
+)
+
+// wsFrame is a JSON frame of the subscription protocol, such as
+// {"type": "subscribe", "channel": "build:<id>"} or
+// {"type": "event", "channel": "build:<id>", "event": "build.status", "data": {...}}
+type wsFrame struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"` // set by the client, echoed in the reply
+	Channel string      `json:"channel,omitempty"`
+	Event   string      `json:"event,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
 }
 
-// WebSocketHandler handles WebSocket connections for real-time updates
+// wsClient is a connected client, the user it authenticated as and the
+// channels it subscribed to
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan []byte
+	principal *auth.Principal
+	channels  map[string]bool // guarded by the handler's lock
+}
+
+// WebSocketHandler handles WebSocket connections for real-time updates.
+// Clients authenticate when connecting, subscribe to the channels they can
+// read and receive only the events published on them.
 type WebSocketHandler struct {
-	clients map[*websocket.Conn]bool
+	db             *database.Database
+	auth           *auth.Authenticator
+	allowedOrigins []string
+	upgrader       websocket.Upgrader
+
+	mu      sync.RWMutex
+	clients map[*wsClient]bool
 }
 
-// NewWebSocketHandler creates a new WebSocket handler
-func NewWebSocketHandler() *WebSocketHandler {
-	return &WebSocketHandler{
-		clients: make(map[*websocket.Conn]bool),
+// NewWebSocketHandler creates a new WebSocket handler accepting browser
+// connections from the allowed origins ("*" allows any)
+func NewWebSocketHandler(db *database.Database, authenticator *auth.Authenticator, allowedOrigins []string) *WebSocketHandler {
+	h := &WebSocketHandler{
+		db:             db,
+		auth:           authenticator,
+		allowedOrigins: allowedOrigins,
+		clients:        make(map[*wsClient]bool),
 	}
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     h.checkOrigin,
+	}
+	return h
+}
+
+// checkOrigin accepts connections without an Origin header (not from a
+// browser), from the allowed origins and from the server's own origin
+func (h *WebSocketHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
 }
 
-// HandleConnection handles new WebSocket connections
+// HandleConnection authenticates and upgrades new WebSocket connections. The
+// token is an Authorization bearer token or the access_token query parameter.
 func (h *WebSocketHandler) HandleConnection(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if !h.checkOrigin(r) {
+		SendError(w, http.StatusForbidden, nil, "Origin not allowed")
+		return
+	}
+	principal, err := h.auth.Authenticate(r.Context(), auth.TokenFromRequest(r))
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		SendError(w, http.StatusUnauthorized, err, "Authentication required")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to authenticate WebSocket connection")
+		SendError(w, http.StatusInternalServerError, err, "Failed to authenticate")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to upgrade WebSocket connection")
 		return
 	}
-	defer conn.Close()
 
-	h.clients[conn] = true
-	defer delete(h.clients, conn)
+	client := &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer), principal: principal, channels: map[string]bool{}}
+	h.mu.Lock()
+	h.clients[client] = true
+	h.mu.Unlock()
 
-	log.Info().Msg("WebSocket client connected")
+	log.Info().Str("user", principal.Username).Msg("WebSocket client connected")
+
+	go client.writeLoop()
+	h.readLoop(client)
+
+	// Publishers hold the read lock while sending, so once the client is
+	// removed nothing sends on it any more
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+	close(client.send)
+
+	log.Info().Msg("WebSocket client disconnected")
+}
+
+// readLoop handles the frames of a client until it disconnects
+func (h *WebSocketHandler) readLoop(c *wsClient) {
+	c.conn.SetReadLimit(wsMaxFrameBytes)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
 
-	// Keep connection alive and handle messages
 	for {
-		messageType, message, err := conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			log.Error().Err(err).Msg("WebSocket read error")
-			break
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Error().Err(err).Msg("WebSocket read error")
+			}
+			return
 		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
 
-		// Echo message back (stub implementation)
-		if err := conn.WriteMessage(messageType, message); err != nil {
-			log.Error().Err(err).Msg("WebSocket write error")
-			break
+		var frame wsFrame
+		if err := json.Unmarshal(message, &frame); err != nil {
+			h.reply(c, wsFrame{Type: frameError, Error: "invalid frame: " + err.Error()})
+			continue
 		}
+		h.reply(c, h.handleFrame(c, frame))
 	}
+}
 
-	log.Info().Msg("WebSocket client disconnected")
+// handleFrame applies a client's frame and returns the reply
+func (h *WebSocketHandler) handleFrame(c *wsClient, frame wsFrame) wsFrame {
+	reply := wsFrame{ID: frame.ID}
+	switch frame.Type {
+	case framePing:
+		reply.Type = framePong
+		return reply
+	case frameSubscribe, frameUnsubscribe:
+	default:
+		reply.Type = frameError
+		reply.Error = fmt.Sprintf("unknown frame type %q", frame.Type)
+		return reply
+	}
+
+	channel, err := parseChannel(frame.Channel)
+	if err != nil {
+		reply.Type = frameError
+		reply.Error = err.Error()
+		return reply
+	}
+	reply.Channel = channel
+
+	if frame.Type == frameSubscribe {
+		if err := h.authorize(c.principal, channel); err != nil {
+			reply.Type = frameError
+			reply.Error = err.Error()
+			return reply
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if frame.Type == frameUnsubscribe {
+		delete(c.channels, channel)
+		reply.Type = frameUnsubscribed
+		return reply
+	}
+	if !c.channels[channel] && len(c.channels) >= wsMaxSubscriptions {
+		reply.Type = frameError
+		reply.Error = fmt.Sprintf("at most %d subscriptions per connection", wsMaxSubscriptions)
+		return reply
+	}
+	c.channels[channel] = true
+	reply.Type = frameSubscribed
+	return reply
+}
+
+// authorize checks that a user can read a channel: the builds and jobs of
+// the projects they can read, and the workers
+func (h *WebSocketHandler) authorize(principal *auth.Principal, channel string) error {
+	if channel == channelWorkers {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var project string
+	var err error
+	if id, ok := strings.CutPrefix(channel, channelBuildPrefix); ok {
+		err = h.db.GetConn().QueryRowContext(ctx, `
+			SELECT COALESCE(j.project, '') FROM builds b JOIN jobs j ON j.id = b.job_id WHERE b.id = $1
+		`, id).Scan(&project)
+	} else {
+		id := strings.TrimPrefix(channel, channelJobPrefix)
+		err = h.db.GetConn().QueryRowContext(ctx, `SELECT COALESCE(project, '') FROM jobs WHERE id = $1`, id).Scan(&project)
+	}
+	// Channels of other projects look the same as missing ones
+	if err == sql.ErrNoRows || (err == nil && !principal.CanReadProject(project)) {
+		return fmt.Errorf("channel %q not found", channel)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("channel", channel).Msg("Failed to authorize WebSocket subscription")
+		return fmt.Errorf("failed to authorize channel %q", channel)
+	}
+	return nil
+}
+
+// reply queues a frame for one client
+func (h *WebSocketHandler) reply(c *wsClient, frame wsFrame) {
+	message, err := json.Marshal(frame)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode WebSocket frame")
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.deliver(c, message)
+}
+
+// deliver queues a message for a client, disconnecting it if it cannot keep
+// up. The caller holds the lock.
+func (h *WebSocketHandler) deliver(c *wsClient, message []byte) {
+	select {
+	case c.send <- message:
+	default:
+		log.Warn().Msg("WebSocket client too slow, disconnecting")
+		c.conn.Close()
+	}
+}
+
+// writeLoop writes the queued messages of a client and keeps the connection
+// alive with pings
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	defer c.conn.Close()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Error().Err(err).Msg("WebSocket write error")
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// parseChannel validates a channel name and returns it in canonical form
+func parseChannel(channel string) (string, error) {
+	if channel == channelWorkers {
+		return channel, nil
+	}
+	for _, prefix := range []string{channelBuildPrefix, channelJobPrefix} {
+		if id, ok := strings.CutPrefix(channel, prefix); ok {
+			parsed, err := uuid.Parse(id)
+			if err != nil {
+				return "", fmt.Errorf("invalid channel %q: %v", channel, err)
+			}
+			return prefix + parsed.String(), nil
+		}
+	}
+	return "", fmt.Errorf("unknown channel %q (want build:<id>, job:<id> or workers)", channel)
+}
+
+// Publish sends an event to the clients subscribed to the channel. A nil
+// handler publishes nothing. Events of state changes reach it through the
+// outbox; only transient ones, such as log lines and heartbeats, are
+// published directly.
+func (h *WebSocketHandler) Publish(channel, event string, data interface{}) {
+	if h == nil {
+		return
+	}
+	message, err := json.Marshal(wsFrame{Type: frameEvent, Channel: channel, Event: event, Data: data})
+	if err != nil {
+		log.Error().Err(err).Str("channel", channel).Msg("Failed to encode WebSocket event")
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.channels[channel] {
+			h.deliver(client, message)
+		}
+	}
+}
+
+// publishBuild sends an event of a build to its subscribers and, with jobID
+// set, to the subscribers of its job
+func (h *WebSocketHandler) publishBuild(jobID *uuid.UUID, buildID uuid.UUID, event string, data interface{}) {
+	h.Publish(channelBuildPrefix+buildID.String(), event, data)
+	if jobID != nil {
+		h.Publish(channelJobPrefix+jobID.String(), event, data)
+	}
 }
 
 // Broadcast sends a message to all connected clients
 func (h *WebSocketHandler) Broadcast(message []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 	for client := range h.clients {
-		if err := client.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Error().Err(err).Msg("Failed to broadcast message")
-			client.Close()
-			delete(h.clients, client)
-		}
+		h.deliver(client, message)
 	}
 }
@@ -1,44 +1,66 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 
+	"github.com/solvyd/solvyd/api-server/internal/auth"
 	"github.com/solvyd/solvyd/api-server/internal/database"
 	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/pools"
 	"github.com/solvyd/solvyd/api-server/internal/worker"
 )
 
 // WorkerHandler handles worker-related requests
 type WorkerHandler struct {
-	db  *database.Database
-	mgr *worker.Manager
+	db     *database.Database
+	mgr    *worker.Manager
+	events *WebSocketHandler
 }
 
-// NewWorkerHandler creates a new worker handler
-func NewWorkerHandler(db *database.Database, mgr *worker.Manager) *WorkerHandler {
-	return &WorkerHandler{db: db, mgr: mgr}
+// NewWorkerHandler creates a new worker handler, which publishes worker
+// updates to events
+func NewWorkerHandler(db *database.Database, mgr *worker.Manager, events *WebSocketHandler) *WorkerHandler {
+	return &WorkerHandler{db: db, mgr: mgr, events: events}
 }
 
-// ListWorkers returns all workers
+// ListWorkers returns all workers, or with pool those of a worker pool
+// ("default" for workers without one)
 func (h *WorkerHandler) ListWorkers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	query := `
 		SELECT id, name, hostname, ip_address, max_concurrent_builds,
-		       current_builds, cpu_cores, memory_mb, labels, capabilities,
+		       current_builds, cpu_cores, memory_mb, COALESCE(pool, ''), labels, capabilities,
 		       status, last_heartbeat, health_status, agent_version,
-		       registered_at, updated_at
+		       resources, resources_reported_at, registered_at, updated_at
 		FROM workers
-		ORDER BY name ASC
 	`
+	args := []interface{}{}
 
-	rows, err := h.db.GetConn().QueryContext(ctx, query)
+	if pool := r.URL.Query().Get("pool"); pool == pools.DefaultPool {
+		query += " WHERE pool IS NULL"
+	} else if pool != "" {
+		query += " WHERE pool = $1"
+		args = append(args, pool)
+	}
+
+	query += " ORDER BY name ASC"
+
+	rows, err := h.db.GetConn().QueryContext(ctx, query, args...)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query workers")
 		SendError(w, http.StatusInternalServerError, err, "Failed to fetch workers")
@@ -49,18 +71,20 @@ func (h *WorkerHandler) ListWorkers(w http.ResponseWriter, r *http.Request) {
 	workers := []models.Worker{}
 	for rows.Next() {
 		var worker models.Worker
+		var resources []byte
 		err := rows.Scan(
 			&worker.ID, &worker.Name, &worker.Hostname, &worker.IP,
 			&worker.MaxConcurrentBuilds, &worker.CurrentBuilds,
-			&worker.CPUCores, &worker.MemoryMB, &worker.Labels, &worker.Capabilities,
+			&worker.CPUCores, &worker.MemoryMB, &worker.Pool, &worker.Labels, &worker.Capabilities,
 			&worker.Status, &worker.LastHeartbeat,
-			&worker.HealthStatus, &worker.AgentVersion, &worker.RegisteredAt,
-			&worker.UpdatedAt,
+			&worker.HealthStatus, &worker.AgentVersion, &resources,
+			&worker.ResourcesReportedAt, &worker.RegisteredAt, &worker.UpdatedAt,
 		)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to scan worker row")
 			continue
 		}
+		worker.Resources = decodeResources(resources)
 		workers = append(workers, worker)
 	}
 
@@ -80,21 +104,22 @@ func (h *WorkerHandler) GetWorker(w http.ResponseWriter, r *http.Request) {
 
 	query := `
 		SELECT id, name, hostname, ip_address, max_concurrent_builds,
-		       current_builds, cpu_cores, memory_mb, labels, capabilities,
+		       current_builds, cpu_cores, memory_mb, COALESCE(pool, ''), labels, capabilities,
 		       status, last_heartbeat, health_status, agent_version,
-		       registered_at, updated_at
+		       resources, resources_reported_at, registered_at, updated_at
 		FROM workers
 		WHERE id = $1
 	`
 
 	var worker models.Worker
+	var resources []byte
 	err = h.db.GetConn().QueryRowContext(ctx, query, workerID).Scan(
 		&worker.ID, &worker.Name, &worker.Hostname, &worker.IP,
 		&worker.MaxConcurrentBuilds, &worker.CurrentBuilds,
-		&worker.CPUCores, &worker.MemoryMB, &worker.Labels,
+		&worker.CPUCores, &worker.MemoryMB, &worker.Pool, &worker.Labels,
 		&worker.Capabilities, &worker.Status, &worker.LastHeartbeat,
-		&worker.HealthStatus, &worker.AgentVersion, &worker.RegisteredAt,
-		&worker.UpdatedAt,
+		&worker.HealthStatus, &worker.AgentVersion, &resources,
+		&worker.ResourcesReportedAt, &worker.RegisteredAt, &worker.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		SendError(w, http.StatusNotFound, nil, "Worker not found")
@@ -106,6 +131,8 @@ func (h *WorkerHandler) GetWorker(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	worker.Resources = decodeResources(resources)
+
 	SendJSON(w, http.StatusOK, worker)
 }
 
@@ -179,6 +206,10 @@ func (h *WorkerHandler) UpdateWorker(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Info().Str("worker_id", workerID.String()).Msg("Worker updated")
+	h.events.Publish(channelWorkers, "worker.updated", map[string]interface{}{
+		"worker_id": workerID, "max_concurrent_builds": updates.MaxConcurrentBuilds,
+		"labels": updates.Labels, "status": updates.Status,
+	})
 	SendJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
 
@@ -208,9 +239,46 @@ func (h *WorkerHandler) DrainWorker(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Info().Str("worker_id", workerID.String()).Msg("Worker set to draining")
+	h.events.Publish(channelWorkers, "worker.status", map[string]interface{}{"worker_id": workerID, "status": "draining"})
 	SendJSON(w, http.StatusOK, map[string]string{"status": "draining"})
 }
 
+// DeregisterWorker removes a worker, as one-shot agents do once their build
+// is done. A worker still running builds is not removed (409) unless
+// requeue=true is given, which puts its builds back in the queue first.
+func (h *WorkerHandler) DeregisterWorker(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	workerID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid worker ID")
+		return
+	}
+	requeue := r.URL.Query().Get("requeue") == "true"
+
+	requeued, err := h.mgr.Remove(ctx, workerID, requeue)
+	if errors.Is(err, worker.ErrNotFound) {
+		SendError(w, http.StatusNotFound, nil, "Worker not found")
+		return
+	}
+	if errors.Is(err, worker.ErrBusy) {
+		SendError(w, http.StatusConflict, nil, "Worker has builds in progress")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to deregister worker")
+		SendError(w, http.StatusInternalServerError, err, "Failed to deregister worker")
+		return
+	}
+
+	log.Info().Str("worker_id", workerID.String()).Int("requeued_builds", len(requeued)).Msg("Worker deregistered")
+	if requeued == nil {
+		requeued = []uuid.UUID{}
+	}
+	SendJSON(w, http.StatusOK, map[string]interface{}{"status": "deregistered", "requeued_builds": requeued})
+}
+
 // RegisterWorker registers a new worker
 func (h *WorkerHandler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -222,6 +290,7 @@ func (h *WorkerHandler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
 		MaxConcurrentBuilds int                    `json:"max_concurrent_builds"`
 		CPUCores            int                    `json:"cpu_cores"`
 		MemoryMB            int                    `json:"memory_mb"`
+		Pool                string                 `json:"pool"`
 		Labels              map[string]interface{} `json:"labels"`
 		Capabilities        []string               `json:"capabilities"`
 		AgentVersion        string                 `json:"agent_version"`
@@ -240,18 +309,41 @@ func (h *WorkerHandler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
 	if req.MaxConcurrentBuilds <= 0 {
 		req.MaxConcurrentBuilds = 2 // Default
 	}
+	if req.Pool == pools.DefaultPool {
+		req.Pool = ""
+	}
+	if req.Pool != "" {
+		if !pools.ValidName(req.Pool) {
+			SendError(w, http.StatusBadRequest, nil, "Invalid pool name")
+			return
+		}
+		// Workers may register into a pool nobody has created yet
+		if err := pools.Ensure(ctx, h.db, req.Pool); err != nil {
+			log.Error().Err(err).Msg("Failed to create worker pool")
+			SendError(w, http.StatusInternalServerError, err, "Failed to register worker")
+			return
+		}
+	}
 
 	// Convert labels and capabilities to JSON
 	labelsJSON, _ := json.Marshal(req.Labels)
 	capsJSON, _ := json.Marshal(req.Capabilities)
 
+	// A worker registering again, e.g. after a restart, gets a new credential
+	credential, credentialHash, err := newWorkerCredential()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate worker credential")
+		SendError(w, http.StatusInternalServerError, err, "Failed to register worker")
+		return
+	}
+
 	// Insert worker into database
 	query := `
 		INSERT INTO workers (
 			name, hostname, ip_address, max_concurrent_builds,
 			cpu_cores, memory_mb, labels, capabilities,
-			status, health_status, agent_version
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'online', 'healthy', $9)
+			status, health_status, agent_version, pool, credential_hash
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'online', 'healthy', $9, NULLIF($10, ''), $11)
 		ON CONFLICT (name) 
 		DO UPDATE SET 
 			hostname = EXCLUDED.hostname,
@@ -262,6 +354,8 @@ func (h *WorkerHandler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
 			labels = EXCLUDED.labels,
 			capabilities = EXCLUDED.capabilities,
 			agent_version = EXCLUDED.agent_version,
+			pool = EXCLUDED.pool,
+			credential_hash = EXCLUDED.credential_hash,
 			status = 'online',
 			last_heartbeat = CURRENT_TIMESTAMP,
 			updated_at = CURRENT_TIMESTAMP
@@ -272,9 +366,10 @@ func (h *WorkerHandler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
 	var workerName string
 	var registeredAt interface{}
 
-	err := h.db.GetConn().QueryRowContext(ctx, query,
+	err = h.db.GetConn().QueryRowContext(ctx, query,
 		req.Name, req.Hostname, req.IPAddress, req.MaxConcurrentBuilds,
-		req.CPUCores, req.MemoryMB, labelsJSON, capsJSON, req.AgentVersion,
+		req.CPUCores, req.MemoryMB, labelsJSON, capsJSON, req.AgentVersion, req.Pool,
+		credentialHash,
 	).Scan(&workerID, &workerName, &registeredAt)
 
 	if err != nil {
@@ -286,18 +381,59 @@ func (h *WorkerHandler) RegisterWorker(w http.ResponseWriter, r *http.Request) {
 	log.Info().
 		Str("worker_id", workerID.String()).
 		Str("worker_name", workerName).
+		Str("pool", req.Pool).
 		Msg("Worker registered")
+	h.events.Publish(channelWorkers, "worker.registered", map[string]interface{}{
+		"worker_id": workerID, "name": workerName, "status": "online", "pool": req.Pool,
+	})
 
 	response := map[string]interface{}{
 		"id":            workerID,
 		"name":          workerName,
 		"registered_at": registeredAt,
 		"status":        "online",
+		// Only returned here; the server keeps its hash
+		"credential": credential,
 	}
 
 	SendJSON(w, http.StatusCreated, response)
 }
 
+// newWorkerCredential returns a credential for a worker and the hash of it
+// the server keeps
+func newWorkerCredential() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	credential := hex.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(credential))
+	return credential, hex.EncodeToString(sum[:]), nil
+}
+
+// authorizeWorker checks that a request carries, as its bearer token, the
+// credential the worker was issued at registration, writing the error
+// response if not
+func authorizeWorker(ctx context.Context, db *database.Database, w http.ResponseWriter, r *http.Request, workerID uuid.UUID) bool {
+	var credentialHash sql.NullString
+	err := db.GetConn().QueryRowContext(ctx, `
+		SELECT credential_hash FROM workers WHERE id = $1
+	`, workerID).Scan(&credentialHash)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error().Err(err).Str("worker_id", workerID.String()).Msg("Failed to query worker credential")
+		SendError(w, http.StatusInternalServerError, err, "Failed to authenticate worker")
+		return false
+	}
+
+	// An unknown worker is rejected the same way as a wrong credential
+	sum := sha256.Sum256([]byte(auth.TokenFromRequest(r)))
+	if !credentialHash.Valid || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(credentialHash.String)) != 1 {
+		SendError(w, http.StatusUnauthorized, nil, "Invalid worker credential")
+		return false
+	}
+	return true
+}
+
 // Heartbeat handles worker heartbeat updates
 func (h *WorkerHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -314,6 +450,8 @@ func (h *WorkerHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 		HealthStatus  string `json:"health_status"`
 		CPUUsage      *int   `json:"cpu_usage,omitempty"`
 		MemoryUsage   *int   `json:"memory_usage,omitempty"`
+		// Resource usage, from agents that report it
+		Resources *models.WorkerResources `json:"resources,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -336,6 +474,8 @@ func (h *WorkerHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 		        WHEN status = 'draining' THEN 'draining'
 		        ELSE 'online'
 		    END,
+		    resources = COALESCE($4, resources),
+		    resources_reported_at = CASE WHEN $4::jsonb IS NULL THEN resources_reported_at ELSE CURRENT_TIMESTAMP END,
 		    updated_at = CURRENT_TIMESTAMP
 		WHERE id = $3
 		RETURNING status, current_builds, max_concurrent_builds
@@ -344,7 +484,8 @@ func (h *WorkerHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 	var status string
 	var currentBuilds, maxBuilds int
 
-	err = h.db.GetConn().QueryRowContext(ctx, query, req.CurrentBuilds, req.HealthStatus, workerID).
+	// A nil *WorkerResources stores NULL, keeping the previous snapshot
+	err = h.db.GetConn().QueryRowContext(ctx, query, req.CurrentBuilds, req.HealthStatus, workerID, req.Resources).
 		Scan(&status, &currentBuilds, &maxBuilds)
 
 	if err == sql.ErrNoRows {
@@ -357,11 +498,25 @@ func (h *WorkerHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Resources != nil {
+		_, err := h.db.GetConn().ExecContext(ctx, `
+			INSERT INTO worker_resource_samples (worker_id, resources)
+			VALUES ($1, $2)
+		`, workerID, req.Resources)
+		if err != nil {
+			log.Warn().Err(err).Str("worker_id", workerID.String()).Msg("Failed to record worker resource sample")
+		}
+	}
+
 	log.Debug().
 		Str("worker_id", workerID.String()).
 		Int("current_builds", currentBuilds).
 		Str("health", req.HealthStatus).
 		Msg("Heartbeat received")
+	h.events.Publish(channelWorkers, "worker.heartbeat", map[string]interface{}{
+		"worker_id": workerID, "status": status, "health_status": req.HealthStatus,
+		"current_builds": currentBuilds, "max_builds": maxBuilds, "resources": req.Resources,
+	})
 
 	// Check if there are pending builds for this worker
 	hasWork := false
@@ -386,3 +541,83 @@ func (h *WorkerHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
 
 	SendJSON(w, http.StatusOK, response)
 }
+
+// GetWorkerMetrics returns the resource samples of a worker's heartbeats
+// over a window (default 24h, at most as long as samples are kept), oldest
+// first
+func (h *WorkerHandler) GetWorkerMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	workerID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid worker ID")
+		return
+	}
+
+	window, err := parseWindow(r.URL.Query().Get("window"), 24*time.Hour)
+	if err == nil && (window <= 0 || window > worker.ResourceSampleRetention) {
+		err = fmt.Errorf("window must be positive and at most 7d")
+	}
+	if err != nil {
+		SendError(w, http.StatusBadRequest, err, "Invalid window (want days such as 1d or a duration such as 6h)")
+		return
+	}
+
+	var exists bool
+	err = h.db.GetConn().QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM workers WHERE id = $1)`, workerID).Scan(&exists)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query worker")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch worker metrics")
+		return
+	}
+	if !exists {
+		SendError(w, http.StatusNotFound, nil, "Worker not found")
+		return
+	}
+
+	rows, err := h.db.GetConn().QueryContext(ctx, `
+		SELECT recorded_at, resources
+		FROM worker_resource_samples
+		WHERE worker_id = $1 AND recorded_at >= $2
+		ORDER BY recorded_at ASC
+	`, workerID, time.Now().Add(-window))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query worker resource samples")
+		SendError(w, http.StatusInternalServerError, err, "Failed to fetch worker metrics")
+		return
+	}
+	defer rows.Close()
+
+	samples := []models.WorkerResourceSample{}
+	for rows.Next() {
+		var sample models.WorkerResourceSample
+		var resources []byte
+		if err := rows.Scan(&sample.RecordedAt, &resources); err != nil {
+			log.Error().Err(err).Msg("Failed to scan worker resource sample")
+			continue
+		}
+		if decoded := decodeResources(resources); decoded != nil {
+			sample.WorkerResources = *decoded
+		}
+		samples = append(samples, sample)
+	}
+
+	SendJSON(w, http.StatusOK, map[string]interface{}{
+		"worker_id": workerID,
+		"window":    window.String(),
+		"samples":   samples,
+	})
+}
+
+// decodeResources decodes a stored resource snapshot, nil if there is none
+func decodeResources(data []byte) *models.WorkerResources {
+	if data == nil {
+		return nil
+	}
+	var resources models.WorkerResources
+	if err := json.Unmarshal(data, &resources); err != nil {
+		log.Warn().Err(err).Msg("Invalid worker resource snapshot")
+		return nil
+	}
+	return &resources
+}
@@ -0,0 +1,161 @@
+package images
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// ErrNotFound is returned for a build image that does not exist
+var ErrNotFound = errors.New("build image not found")
+
+// namePattern is the pattern of build image names, which jobs and pipelines
+// refer to images by
+var namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// repositoryPattern matches an image repository without a tag or digest,
+// optionally on a registry with a port
+var repositoryPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)*$`)
+
+// tagPattern and digestPattern match the tag and digest of an image
+var (
+	tagPattern    = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+)
+
+// ValidName reports whether name can name a build image
+func ValidName(name string) bool {
+	return len(name) <= 100 && namePattern.MatchString(name)
+}
+
+// ValidRepository reports whether repository is an image repository without
+// a tag or digest
+func ValidRepository(repository string) bool {
+	return len(repository) <= 500 && repositoryPattern.MatchString(repository)
+}
+
+// ValidTag reports whether tag is an image tag
+func ValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// ValidDigest reports whether digest is a sha256 image digest
+func ValidDigest(digest string) bool {
+	return digestPattern.MatchString(digest)
+}
+
+// Image is a vetted build image of the catalog, which jobs and pipelines run
+// their stages in by naming it with build_image
+type Image struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Image is the repository, such as registry.example.com/ci/golang
+	Image string `json:"image"`
+	Tag   string `json:"tag"`
+	// Digest pins the tag, so that builds run the image that was vetted
+	// even if the tag is pushed again
+	Digest string `json:"digest,omitempty"`
+	// Tools lists the tools the image provides, such as "go 1.22"
+	Tools      []string   `json:"tools"`
+	Maintainer string     `json:"maintainer"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// Reference returns the reference the executors pull the image by:
+// image:tag, followed by @digest if it is pinned
+func (i Image) Reference() string {
+	ref := i.Image + ":" + i.Tag
+	if i.Digest != "" {
+		ref += "@" + i.Digest
+	}
+	return ref
+}
+
+const selectImage = `
+	SELECT name, description, image, tag, COALESCE(digest, ''), tools, maintainer,
+	       COALESCE(created_by, ''), created_at, updated_at
+	FROM build_images`
+
+// List returns the build images by name
+func List(ctx context.Context, db *database.Database) ([]Image, error) {
+	rows, err := db.GetConn().QueryContext(ctx, selectImage+` ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []Image{}
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+// Get returns a build image
+func Get(ctx context.Context, db *database.Database, name string) (Image, error) {
+	img, err := scanImage(db.GetConn().QueryRowContext(ctx, selectImage+` WHERE name = $1`, name))
+	if err == sql.ErrNoRows {
+		return Image{}, ErrNotFound
+	}
+	return img, err
+}
+
+// Jobs returns the names of the jobs whose build config, or one of its
+// stages, runs in the build image
+func Jobs(ctx context.Context, db *database.Database, name string) ([]string, error) {
+	rows, err := db.GetConn().QueryContext(ctx, `
+		SELECT name FROM jobs
+		WHERE build_config->>'build_image' = $1
+		   OR EXISTS (
+		       SELECT 1
+		       FROM jsonb_array_elements(CASE jsonb_typeof(build_config->'stages')
+		                                 WHEN 'array' THEN build_config->'stages' ELSE '[]' END) stage
+		       WHERE stage->>'build_image' = $1)
+		ORDER BY name ASC
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []string{}
+	for rows.Next() {
+		var job string
+		if err := rows.Scan(&job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanImage(row scanner) (Image, error) {
+	var img Image
+	var createdAt, updatedAt time.Time
+	err := row.Scan(&img.Name, &img.Description, &img.Image, &img.Tag, &img.Digest,
+		pq.Array(&img.Tools), &img.Maintainer, &img.CreatedBy, &createdAt, &updatedAt)
+	if err != nil {
+		return Image{}, err
+	}
+	if img.Tools == nil {
+		img.Tools = []string{}
+	}
+	img.CreatedAt, img.UpdatedAt = &createdAt, &updatedAt
+	return img, nil
+}
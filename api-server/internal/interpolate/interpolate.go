@@ -0,0 +1,265 @@
+package interpolate
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/environment"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+)
+
+// expression matches ${{ <context>.<name> }}
+var expression = regexp.MustCompile(`\$\{\{\s*([^{}]*?)\s*\}\}`)
+
+// UndefinedError lists the expressions that reference values that do not exist
+type UndefinedError struct {
+	References []string
+}
+
+func (e *UndefinedError) Error() string {
+	return "undefined references: " + strings.Join(e.References, ", ")
+}
+
+// Resolver replaces ${{ env.NAME }}, ${{ secrets.NAME }} and
+// ${{ build.FIELD }} expressions in strings. References that cannot be
+// resolved are collected rather than left in place, and reported by Err.
+type Resolver struct {
+	Env   map[string]string // nil if env references are not allowed
+	Build map[string]string
+	// Secret returns the value of a secret and whether it exists
+	Secret func(name string) (string, bool, error)
+
+	secrets   map[string]string
+	undefined []string
+	err       error
+}
+
+// Expand replaces the expressions in the strings of a value decoded from
+// JSON, recursing into objects and lists
+func (r *Resolver) Expand(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.String(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = r.Expand(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.Expand(item)
+		}
+		return out
+	}
+	return value
+}
+
+// String replaces the expressions in s
+func (r *Resolver) String(s string) string {
+	return expression.ReplaceAllStringFunc(s, func(match string) string {
+		ref := expression.FindStringSubmatch(match)[1]
+		value, ok := r.lookup(ref)
+		if !ok {
+			r.undefined = append(r.undefined, ref)
+		}
+		return value
+	})
+}
+
+func (r *Resolver) lookup(ref string) (string, bool) {
+	scope, name, _ := strings.Cut(ref, ".")
+	if name == "" {
+		return "", false
+	}
+	switch scope {
+	case "env":
+		value, ok := r.Env[name]
+		return value, ok
+	case "build":
+		value, ok := r.Build[name]
+		return value, ok
+	case "secrets":
+		if value, ok := r.secrets[name]; ok {
+			return value, true
+		}
+		if r.Secret == nil {
+			return "", false
+		}
+		value, ok, err := r.Secret(name)
+		if err != nil {
+			if r.err == nil {
+				r.err = err
+			}
+			return "", true
+		}
+		if ok {
+			if r.secrets == nil {
+				r.secrets = map[string]string{}
+			}
+			r.secrets[name] = value
+		}
+		return value, ok
+	}
+	return "", false
+}
+
+// Err returns the first error looking up a secret, or an *UndefinedError if
+// any expression could not be resolved
+func (r *Resolver) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.undefined) > 0 {
+		return &UndefinedError{References: unique(r.undefined)}
+	}
+	return nil
+}
+
+// SecretValues returns the values of the secrets that were resolved, so that
+// they can be masked wherever the resolved config is shown
+func (r *Resolver) SecretValues() []string {
+	values := make([]string, 0, len(r.secrets))
+	for _, value := range r.secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return unique(values)
+}
+
+func unique(items []string) []string {
+	sort.Strings(items)
+	out := items[:0]
+	for i, item := range items {
+		if i == 0 || item != items[i-1] {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Build is the config and environment a build runs with, its expressions
+// resolved
+type Build struct {
+	BuildConfig  map[string]interface{}
+	EnvVars      map[string]string
+	SecretValues []string
+	// The plugin steps of the templates the build config includes, which
+	// run ahead of the job's
+	Plugins []interface{}
+	// The environment variables other than trigger parameters before their
+	// expressions were resolved, which the build records for its reruns
+	Recorded map[string]string
+}
+
+// ResolveBuild resolves the expressions in the build config and effective
+// environment of a build's job (see environment.Layers). Environment
+// variables other than trigger parameters may reference secrets and the
+// build; the build config may
+// reference them as well as the environment variables. Secrets are the
+// credentials of the credential store: ${{ secrets.NAME }} is the value of
+// credential NAME and ${{ secrets.NAME.KEY }} a field of its data. It returns
+// an *UndefinedError listing every reference that could not be resolved.
+// A build that has recorded variables, such as a rerun, uses those in place
+// of the global, project and job variables.
+//
+// The templates the build config includes from pipeline libraries are read
+// from templates and added first, so that their expressions are resolved
+// too, and the build images it names are replaced by their references; a
+// *pipeline.ValidationError lists the problems found with them.
+func ResolveBuild(ctx context.Context, db *database.Database, creds *credentials.Store, templates pipeline.Templates, buildID uuid.UUID) (*Build, error) {
+	var buildConfig, jobEnv, parameters, recorded models.JSONB
+	var number int
+	var jobName, project, branch, commitSHA, triggeredBy string
+	err := db.GetConn().QueryRowContext(ctx, `
+		SELECT j.build_config, j.environment_vars, b.parameters, COALESCE(b.environment_vars, '{}'),
+		       j.name, COALESCE(j.project, ''), b.build_number,
+		       COALESCE(b.branch, ''), COALESCE(b.scm_commit_sha, ''), COALESCE(b.triggered_by, '')
+		FROM builds b
+		JOIN jobs j ON j.id = b.job_id
+		WHERE b.id = $1
+	`, buildID).Scan(&buildConfig, &jobEnv, &parameters, &recorded, &jobName, &project, &number, &branch, &commitSHA, &triggeredBy)
+	if err != nil {
+		return nil, err
+	}
+	config, included, err := pipeline.ExpandConfig(ctx, templates, buildConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []environment.Layer
+	if len(recorded) > 0 {
+		layers = environment.RecordedLayers(recorded, parameters)
+	} else if layers, err = environment.Layers(ctx, db, project, jobEnv, parameters); err != nil {
+		return nil, err
+	}
+
+	r := &Resolver{
+		Build: map[string]string{
+			"id":           buildID.String(),
+			"number":       strconv.Itoa(number),
+			"branch":       branch,
+			"commit_sha":   commitSHA,
+			"triggered_by": triggeredBy,
+			"job_name":     jobName,
+		},
+		Secret: func(name string) (string, bool, error) {
+			return secret(ctx, creds, name)
+		},
+	}
+
+	effective := environment.Merge(layers)
+	env := make(map[string]string, len(effective))
+	unresolved := make(map[string]string, len(effective))
+	for name, v := range effective {
+		// Trigger parameters are taken literally, so that whoever triggers
+		// a build cannot read secrets through them
+		if v.Source == environment.SourceParameters {
+			env[name] = v.Value
+			continue
+		}
+		unresolved[name] = v.Value
+		env[name] = r.String(v.Value)
+	}
+	r.Env = env
+	resolved, _ := r.Expand(config).(map[string]interface{})
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	if resolved, err = pipeline.ResolveImages(ctx, db, resolved); err != nil {
+		return nil, err
+	}
+	return &Build{BuildConfig: resolved, EnvVars: env, SecretValues: r.SecretValues(), Plugins: included, Recorded: unresolved}, nil
+}
+
+// secret returns the value of credential name, or a field of its data if the
+// name ends in .KEY and no credential has the whole name
+func secret(ctx context.Context, creds *credentials.Store, name string) (string, bool, error) {
+	c, err := creds.Get(ctx, name)
+	key := "value"
+	if errors.Is(err, credentials.ErrNotFound) {
+		if i := strings.LastIndex(name, "."); i > 0 {
+			key = name[i+1:]
+			c, err = creds.Get(ctx, name[:i])
+		}
+	}
+	if errors.Is(err, credentials.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := c.Data[key]
+	return value, ok, nil
+}
@@ -0,0 +1,115 @@
+package interpolate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newResolver(lookups *int) *Resolver {
+	secrets := map[string]string{"token": "s3cret", "empty": ""}
+	return &Resolver{
+		Env:   map[string]string{"HOME": "/home/ci", "EMPTY": ""},
+		Build: map[string]string{"number": "42", "branch": "main"},
+		Secret: func(name string) (string, bool, error) {
+			*lookups++
+			if name == "broken" {
+				return "", false, errors.New("store unavailable")
+			}
+			value, ok := secrets[name]
+			return value, ok, nil
+		},
+	}
+}
+
+func TestResolverString(t *testing.T) {
+	tests := []struct {
+		in            string
+		want          string
+		wantUndefined []string
+	}{
+		{in: "plain", want: "plain"},
+		{in: "${{ env.HOME }}/bin", want: "/home/ci/bin"},
+		{in: "${{env.HOME}}", want: "/home/ci"},
+		{in: "build-${{ build.number }}-${{ build.branch }}", want: "build-42-main"},
+		{in: "${{ secrets.token }}", want: "s3cret"},
+		{in: "[${{ env.EMPTY }}]", want: "[]"},
+		{in: "[${{ secrets.empty }}]", want: "[]"},
+		{in: "$HOME ${HOME} ${{ }", want: "$HOME ${HOME} ${{ }"},
+		{in: "${{ env.MISSING }}", want: "", wantUndefined: []string{"env.MISSING"}},
+		{in: "${{ build.nope }}", want: "", wantUndefined: []string{"build.nope"}},
+		{in: "${{ secrets.nope }}", want: "", wantUndefined: []string{"secrets.nope"}},
+		{in: "${{ vars.HOME }}", want: "", wantUndefined: []string{"vars.HOME"}},
+		{in: "${{ env }}", want: "", wantUndefined: []string{"env"}},
+		{in: "${{ env.B }}${{ env.A }}${{ env.B }}", want: "", wantUndefined: []string{"env.A", "env.B"}},
+	}
+	for _, tt := range tests {
+		var lookups int
+		r := newResolver(&lookups)
+		if got := r.String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		err := r.Err()
+		if tt.wantUndefined == nil {
+			if err != nil {
+				t.Errorf("String(%q): unexpected error %v", tt.in, err)
+			}
+			continue
+		}
+		var undefined *UndefinedError
+		if !errors.As(err, &undefined) || !reflect.DeepEqual(undefined.References, tt.wantUndefined) {
+			t.Errorf("String(%q): error = %v, want undefined %v", tt.in, err, tt.wantUndefined)
+		}
+	}
+}
+
+func TestResolverNoEnv(t *testing.T) {
+	var lookups int
+	r := newResolver(&lookups)
+	r.Env = nil
+	r.String("${{ env.HOME }}")
+	var undefined *UndefinedError
+	if !errors.As(r.Err(), &undefined) {
+		t.Errorf("Err() = %v, want env references undefined", r.Err())
+	}
+}
+
+func TestResolverSecrets(t *testing.T) {
+	var lookups int
+	r := newResolver(&lookups)
+	r.String("${{ secrets.token }} ${{ secrets.token }} ${{ secrets.empty }}")
+	if lookups != 2 {
+		t.Errorf("looked up secrets %d times, want each once", lookups)
+	}
+	if got := r.SecretValues(); !reflect.DeepEqual(got, []string{"s3cret"}) {
+		t.Errorf("SecretValues() = %q, want the non-empty secret", got)
+	}
+
+	r.String("${{ secrets.broken }} ${{ env.MISSING }}")
+	if err := r.Err(); err == nil || err.Error() != "store unavailable" {
+		t.Errorf("Err() = %v, want the secret store's error first", err)
+	}
+}
+
+func TestResolverExpand(t *testing.T) {
+	var lookups int
+	r := newResolver(&lookups)
+	got := r.Expand(map[string]interface{}{
+		"image":   "golang:${{ build.branch }}",
+		"steps":   []interface{}{"echo ${{ env.HOME }}", 3.0, map[string]interface{}{"token": "${{ secrets.token }}"}},
+		"enabled": true,
+		"nothing": nil,
+	})
+	want := map[string]interface{}{
+		"image":   "golang:main",
+		"steps":   []interface{}{"echo /home/ci", 3.0, map[string]interface{}{"token": "s3cret"}},
+		"enabled": true,
+		"nothing": nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expand() = %v, want %v", got, want)
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
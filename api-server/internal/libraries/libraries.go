@@ -0,0 +1,94 @@
+package libraries
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// ErrNotFound is returned for a library that does not exist
+var ErrNotFound = errors.New("pipeline library not found")
+
+// namePattern is the pattern of library names, which pipelines include
+// templates by
+var namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// ValidName reports whether name can name a library
+func ValidName(name string) bool {
+	return len(name) <= 100 && namePattern.MatchString(name)
+}
+
+// Library is a Git repository of stage and step templates, either a
+// repository of its own or that of a GitOps source
+type Library struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Repository  string `json:"repository,omitempty"`
+	// GitOpsSource names the GitOps source whose repository holds the
+	// library, in place of a repository
+	GitOpsSource string `json:"gitops_source,omitempty"`
+	// Credentials names the stored credential, of type token or
+	// username_password, the repository is cloned with
+	Credentials string `json:"credentials,omitempty"`
+	// Path is the directory of the templates in the repository
+	Path string `json:"path,omitempty"`
+	// DefaultRef is the branch, tag or commit of includes that name no ref
+	DefaultRef string     `json:"default_ref"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+const selectLibrary = `
+	SELECT name, description, COALESCE(repository, ''), COALESCE(gitops_source, ''),
+	       COALESCE(credentials, ''), path, default_ref, COALESCE(created_by, ''),
+	       created_at, updated_at
+	FROM pipeline_libraries`
+
+// List returns the libraries by name
+func List(ctx context.Context, db *database.Database) ([]Library, error) {
+	rows, err := db.GetConn().QueryContext(ctx, selectLibrary+` ORDER BY name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	libraries := []Library{}
+	for rows.Next() {
+		lib, err := scanLibrary(rows)
+		if err != nil {
+			return nil, err
+		}
+		libraries = append(libraries, lib)
+	}
+	return libraries, rows.Err()
+}
+
+// Get returns a library
+func Get(ctx context.Context, db *database.Database, name string) (Library, error) {
+	lib, err := scanLibrary(db.GetConn().QueryRowContext(ctx, selectLibrary+` WHERE name = $1`, name))
+	if err == sql.ErrNoRows {
+		return Library{}, ErrNotFound
+	}
+	return lib, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLibrary(row scanner) (Library, error) {
+	var lib Library
+	var createdAt, updatedAt time.Time
+	err := row.Scan(&lib.Name, &lib.Description, &lib.Repository, &lib.GitOpsSource,
+		&lib.Credentials, &lib.Path, &lib.DefaultRef, &lib.CreatedBy, &createdAt, &updatedAt)
+	if err != nil {
+		return Library{}, err
+	}
+	lib.CreatedAt, lib.UpdatedAt = &createdAt, &updatedAt
+	return lib, nil
+}
@@ -0,0 +1,219 @@
+package libraries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// cacheTTL is how long the files of a fetched commit are kept after they
+// were last read
+const cacheTTL = time.Hour
+
+// commitPattern matches a full commit hash, which is fetched as is rather
+// than looked up among the branches and tags
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Sources gives access to the repositories of GitOps sources
+type Sources interface {
+	// Repository returns the URL of the named source's repository and the
+	// auth it is cloned with
+	Repository(name string) (string, transport.AuthMethod, error)
+}
+
+// Store reads templates from pipeline libraries. A branch or tag is looked
+// up on every read, so that includes follow it as it moves, while the files
+// of the commits it points to are kept in memory.
+type Store struct {
+	db    *database.Database
+	creds *credentials.Store
+
+	mu      sync.Mutex
+	sources Sources
+	commits map[string]*fetchedCommit // by repository URL and the hash its ref pointed to
+}
+
+// fetchedCommit is the tree of a commit fetched from a library
+type fetchedCommit struct {
+	hash     string
+	tree     *object.Tree
+	lastRead time.Time
+}
+
+// NewStore creates a store that clones library repositories with the
+// credentials of creds
+func NewStore(db *database.Database, creds *credentials.Store) *Store {
+	return &Store{db: db, creds: creds, commits: map[string]*fetchedCommit{}}
+}
+
+// SetSources lets libraries be kept in the repositories of GitOps sources
+func (s *Store) SetSources(sources Sources) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = sources
+}
+
+// Template returns the content of a template file of a library at ref, or at
+// the library's default ref if ref is empty, and the commit it was read at
+func (s *Store) Template(ctx context.Context, library, ref, file string) ([]byte, string, error) {
+	lib, err := Get(ctx, s.db, library)
+	if errors.Is(err, ErrNotFound) {
+		return nil, "", fmt.Errorf("unknown pipeline library %q", library)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if ref == "" {
+		ref = lib.DefaultRef
+	}
+
+	url, auth, err := s.repository(ctx, lib)
+	if err != nil {
+		return nil, "", err
+	}
+	commit, err := s.fetch(ctx, url, auth, ref)
+	if err != nil {
+		return nil, "", fmt.Errorf("library %s at %s: %w", library, ref, err)
+	}
+
+	f, err := commit.tree.File(path.Join(lib.Path, file))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, "", fmt.Errorf("library %s has no template %s at %s", library, file, ref)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, "", err
+	}
+	return []byte(content), commit.hash, nil
+}
+
+// repository returns the URL of a library's repository and the auth it is
+// cloned with
+func (s *Store) repository(ctx context.Context, lib Library) (string, transport.AuthMethod, error) {
+	if lib.GitOpsSource != "" {
+		s.mu.Lock()
+		sources := s.sources
+		s.mu.Unlock()
+		if sources == nil {
+			return "", nil, fmt.Errorf("library %s is kept in GitOps source %q, but GitOps is not enabled", lib.Name, lib.GitOpsSource)
+		}
+		return sources.Repository(lib.GitOpsSource)
+	}
+	if lib.Credentials == "" {
+		return lib.Repository, nil, nil
+	}
+
+	c, err := s.creds.Get(ctx, lib.Credentials)
+	if errors.Is(err, credentials.ErrNotFound) {
+		return "", nil, fmt.Errorf("credential %q of library %s does not exist", lib.Credentials, lib.Name)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	switch c.Type {
+	case "token":
+		username := c.Data["username"]
+		if username == "" {
+			username = "solvyd"
+		}
+		return lib.Repository, &githttp.BasicAuth{Username: username, Password: c.Data["value"]}, nil
+	case "username_password":
+		return lib.Repository, &githttp.BasicAuth{Username: c.Data["username"], Password: c.Data["password"]}, nil
+	}
+	return "", nil, fmt.Errorf("credential %q of library %s is of type %s; libraries are cloned with token or username_password credentials", lib.Credentials, lib.Name, c.Type)
+}
+
+// fetch returns the commit ref points to in the repository, which is a
+// branch, a tag or a full commit hash
+func (s *Store) fetch(ctx context.Context, url string, auth transport.AuthMethod, ref string) (*fetchedCommit, error) {
+	var refName plumbing.ReferenceName
+	target := ref
+	if !commitPattern.MatchString(ref) {
+		remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{url}})
+		refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list refs: %w", err)
+		}
+		found := map[plumbing.ReferenceName]plumbing.Hash{}
+		for _, r := range refs {
+			found[r.Name()] = r.Hash()
+		}
+		for _, name := range []plumbing.ReferenceName{plumbing.NewBranchReferenceName(ref), plumbing.NewTagReferenceName(ref)} {
+			if hash, ok := found[name]; ok {
+				refName, target = name, hash.String()
+				break
+			}
+		}
+		if refName == "" {
+			return nil, fmt.Errorf("no branch or tag %s", ref)
+		}
+	}
+
+	key := url + "@" + target
+	s.mu.Lock()
+	cached, ok := s.commits[key]
+	if ok {
+		cached.lastRead = time.Now()
+	}
+	s.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	// Only the commit of a branch or tag is fetched; a commit hash needs
+	// the history it can be found in
+	opts := &git.CloneOptions{URL: url, Auth: auth}
+	if refName != "" {
+		opts.ReferenceName = refName
+		opts.SingleBranch = true
+		opts.Depth = 1
+	}
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, opts)
+	if err != nil {
+		return nil, fmt.Errorf("git clone failed: %w", err)
+	}
+
+	hash := plumbing.NewHash(target)
+	var commit *object.Commit
+	if tag, err := repo.TagObject(hash); err == nil {
+		commit, err = tag.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("tag %s does not point to a commit: %w", ref, err)
+		}
+	} else if commit, err = repo.CommitObject(hash); err != nil {
+		return nil, fmt.Errorf("commit %s not found: %w", target, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	fetched := &fetchedCommit{hash: commit.Hash.String(), tree: tree, lastRead: time.Now()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, c := range s.commits {
+		if time.Since(c.lastRead) > cacheTTL {
+			delete(s.commits, k)
+		}
+	}
+	s.commits[key] = fetched
+	return fetched, nil
+}
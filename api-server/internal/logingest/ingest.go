@@ -0,0 +1,294 @@
+package logingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/metrics"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+)
+
+// Ingester stores the build log lines worker agents send. Rather than one
+// insert per request, a few writers each take the requests waiting when they
+// are free and store their lines together, with one COPY and one insert per
+// flush, so that thousands of builds emitting lines cost a few transactions a
+// second. The same transaction writes the build.logs event of each build to
+// the outbox, for the servers' WebSocket clients, and the annotations the
+// builds' problem matchers find. An ingester keeps nothing between flushes,
+// so any number of them, in API servers or ingest replicas, can share a
+// database.
+type Ingester struct {
+	db       *database.Database
+	metrics  *metrics.Collector
+	writers  int
+	maxLines int
+	batches  chan *batch
+}
+
+// batch is the lines of one request, waiting for a writer
+type batch struct {
+	buildID uuid.UUID
+	lines   []models.BuildLog
+	done    chan result
+}
+
+type result struct {
+	stored []models.BuildLog
+	err    error
+}
+
+// lineKey identifies a stored line
+type lineKey struct {
+	buildID  uuid.UUID
+	sequence int
+}
+
+// NewIngester creates an ingester; Start runs its writers
+func NewIngester(db *database.Database, collector *metrics.Collector, cfg config.LogIngestConfig) *Ingester {
+	writers := max(cfg.Writers, 1)
+	return &Ingester{
+		db:       db,
+		metrics:  collector,
+		writers:  writers,
+		maxLines: max(cfg.MaxBatchLines, 1),
+		batches:  make(chan *batch, writers*64),
+	}
+}
+
+// Start runs the writers until the context is cancelled
+func (i *Ingester) Start(ctx context.Context) {
+	log.Info().Int("writers", i.writers).Msg("Log ingester started")
+	done := make(chan struct{})
+	for n := 0; n < i.writers; n++ {
+		go func() {
+			i.write(ctx)
+			done <- struct{}{}
+		}()
+	}
+	for n := 0; n < i.writers; n++ {
+		<-done
+	}
+	log.Info().Msg("Log ingester stopped")
+}
+
+// Append stores log lines of a build, numbered by the worker, and returns
+// those stored now: lines stored before, as when a worker retries a request,
+// and lines of unknown builds are left out.
+func (i *Ingester) Append(ctx context.Context, buildID uuid.UUID, lines []models.BuildLog) ([]models.BuildLog, error) {
+	b := &batch{buildID: buildID, lines: lines, done: make(chan result, 1)}
+	select {
+	case i.batches <- b:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case r := <-b.done:
+		return r.stored, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// write flushes the batches waiting, up to maxLines at a time, until the
+// context is cancelled. It keeps a connection of its own, with the temporary
+// table lines are copied into, and opens another after a failure.
+func (i *Ingester) write(ctx context.Context) {
+	var conn *sql.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		var pending []*batch
+		select {
+		case <-ctx.Done():
+			return
+		case b := <-i.batches:
+			pending = append(pending, b)
+		}
+		lines := len(pending[0].lines)
+	collect:
+		for lines < i.maxLines {
+			select {
+			case b := <-i.batches:
+				pending = append(pending, b)
+				lines += len(b.lines)
+			default:
+				break collect
+			}
+		}
+
+		var err error
+		if conn == nil {
+			conn, err = i.connect(ctx)
+		}
+		var stored map[lineKey]bool
+		if err == nil {
+			start := time.Now()
+			stored, err = i.flush(ctx, conn, pending)
+			i.metrics.RecordLogFlush(lines, len(stored), time.Since(start).Seconds())
+		}
+		if err != nil {
+			log.Error().Err(err).Int("lines", lines).Msg("Failed to store build logs")
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+		}
+
+		for _, b := range pending {
+			if err != nil {
+				b.done <- result{err: err}
+				continue
+			}
+			var r result
+			for _, line := range b.lines {
+				key := lineKey{b.buildID, line.SequenceNumber}
+				if stored[key] {
+					// A line sent twice in the flush counts once
+					delete(stored, key)
+					r.stored = append(r.stored, line)
+				}
+			}
+			b.done <- r
+		}
+	}
+}
+
+// connect takes a connection from the pool for a writer and creates the
+// temporary table lines are copied into, which is emptied as each flush
+// commits
+func (i *Ingester) connect(ctx context.Context) (*sql.Conn, error) {
+	conn, err := i.db.GetConn().Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.ExecContext(ctx, `
+		CREATE TEMPORARY TABLE IF NOT EXISTS incoming_build_logs (
+			build_id UUID NOT NULL,
+			sequence_number INTEGER NOT NULL,
+			timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+			log_line TEXT NOT NULL,
+			stream VARCHAR(10) NOT NULL
+		) ON COMMIT DELETE ROWS
+	`)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// flush stores the lines of the batches in one transaction and returns those
+// it stored
+func (i *Ingester) flush(ctx context.Context, conn *sql.Conn, pending []*batch) (map[lineKey]bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	copyIn, err := tx.PrepareContext(ctx, pq.CopyIn("incoming_build_logs",
+		"build_id", "sequence_number", "timestamp", "log_line", "stream"))
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range pending {
+		for _, line := range b.lines {
+			if _, err := copyIn.ExecContext(ctx, b.buildID, line.SequenceNumber, line.Timestamp, line.LogLine, line.Stream); err != nil {
+				copyIn.Close()
+				return nil, err
+			}
+		}
+	}
+	if _, err := copyIn.ExecContext(ctx); err != nil {
+		copyIn.Close()
+		return nil, err
+	}
+	if err := copyIn.Close(); err != nil {
+		return nil, err
+	}
+
+	// Lines of builds that do not exist are dropped by the join
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO build_logs (build_id, build_queued_at, sequence_number, timestamp, log_line, stream)
+		SELECT l.build_id, b.queued_at, l.sequence_number, l.timestamp, l.log_line, l.stream
+		FROM incoming_build_logs l
+		JOIN builds b ON b.id = l.build_id
+		ON CONFLICT (build_id, sequence_number, build_queued_at) DO NOTHING
+		RETURNING build_id, sequence_number
+	`)
+	if err != nil {
+		return nil, err
+	}
+	stored := map[lineKey]bool{}
+	for rows.Next() {
+		var key lineKey
+		if err := rows.Scan(&key.buildID, &key.sequence); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stored[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return stored, tx.Commit()
+	}
+
+	// The lines each build stored now, in the order they were sent
+	byBuild := map[uuid.UUID][]models.BuildLog{}
+	seen := make(map[lineKey]bool, len(stored))
+	for _, b := range pending {
+		for _, line := range b.lines {
+			key := lineKey{b.buildID, line.SequenceNumber}
+			if stored[key] && !seen[key] {
+				seen[key] = true
+				line.BuildID = b.buildID
+				byBuild[b.buildID] = append(byBuild[b.buildID], line)
+			}
+		}
+	}
+
+	if err := writeEvents(ctx, tx, byBuild); err != nil {
+		return nil, err
+	}
+	if err := annotate(ctx, tx, byBuild); err != nil {
+		return nil, err
+	}
+	return stored, tx.Commit()
+}
+
+// writeEvents writes the build.logs event of each build, with the lines it
+// stored, to the outbox in one statement
+func writeEvents(ctx context.Context, tx *sql.Tx, byBuild map[uuid.UUID][]models.BuildLog) error {
+	channels := make([]string, 0, len(byBuild))
+	payloads := make([]string, 0, len(byBuild))
+	for buildID, lines := range byBuild {
+		payload, err := json.Marshal(lines)
+		if err != nil {
+			return err
+		}
+		channels = append(channels, outbox.BuildChannelPrefix+buildID.String())
+		payloads = append(payloads, string(payload))
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO event_outbox (channel, event, payload)
+		SELECT e.channel, 'build.logs', e.payload::jsonb
+		FROM unnest($1::text[], $2::text[]) AS e(channel, payload)
+	`, pq.Array(channels), pq.Array(payloads))
+	return err
+}
@@ -0,0 +1,96 @@
+package logingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/matchers"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// maxMatchedAnnotations caps the annotations problem matchers add to a build,
+// so that a noisy log does not flood it
+const maxMatchedAnnotations = 1000
+
+// annotate stores the annotations the problem matchers of the builds find in
+// the lines they stored. A build's matchers are those of the pipeline it read
+// from its repository, or else of its job's build config.
+func annotate(ctx context.Context, tx *sql.Tx, byBuild map[uuid.UUID][]models.BuildLog) error {
+	ids := make([]string, 0, len(byBuild))
+	for buildID := range byBuild {
+		ids = append(ids, buildID.String())
+	}
+	// With the annotations the matchers added before, by the names of the
+	// matchers: a built-in's name or a custom matcher's name field
+	rows, err := tx.QueryContext(ctx, `
+		SELECT b.id, m.matchers,
+		       (SELECT COUNT(*) FROM build_annotations a
+		        WHERE a.build_id = b.id AND a.step IN (
+		            SELECT COALESCE(e->>'name', e#>>'{}') FROM jsonb_array_elements(m.matchers) e))
+		FROM builds b
+		LEFT JOIN jobs j ON j.id = b.job_id
+		CROSS JOIN LATERAL (
+			SELECT CASE WHEN b.pipeline_config IS NOT NULL THEN b.pipeline_config->'problem_matchers'
+			            ELSE j.build_config->'problem_matchers' END AS matchers
+		) m
+		WHERE b.id = ANY($1::uuid[]) AND jsonb_typeof(m.matchers) = 'array'
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var annotations []models.Annotation
+	var buildIDs []string
+	for rows.Next() {
+		var buildID uuid.UUID
+		var raw []byte
+		var matched int
+		if err := rows.Scan(&buildID, &raw, &matched); err != nil {
+			return err
+		}
+		var list interface{}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			log.Warn().Err(err).Str("build_id", buildID.String()).Msg("Invalid problem matchers")
+			continue
+		}
+		// Matchers were checked as the pipeline or job was stored
+		found, _ := matchers.Parse(list, matchers.Field)
+		for _, line := range byBuild[buildID] {
+			if matched >= maxMatchedAnnotations {
+				break
+			}
+			if a, ok := matchers.Match(found, line.LogLine); ok {
+				annotations = append(annotations, a)
+				buildIDs = append(buildIDs, buildID.String())
+				matched++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	n := len(annotations)
+	steps, paths, severities, titles, messages := make([]string, n), make([]string, n), make([]string, n), make([]string, n), make([]string, n)
+	startLines, endLines := make([]int64, n), make([]int64, n)
+	for k, a := range annotations {
+		steps[k], paths[k], severities[k], titles[k], messages[k] = a.Step, a.Path, a.Severity, a.Title, a.Message
+		startLines[k], endLines[k] = int64(a.StartLine), int64(a.EndLine)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO build_annotations (build_id, step, path, start_line, end_line, severity, title, message)
+		SELECT * FROM unnest($1::uuid[], $2::text[], $3::text[], $4::int[], $5::int[], $6::text[], $7::text[], $8::text[])
+	`, pq.Array(buildIDs), pq.Array(steps), pq.Array(paths), pq.Array(startLines), pq.Array(endLines),
+		pq.Array(severities), pq.Array(titles), pq.Array(messages))
+	return err
+}
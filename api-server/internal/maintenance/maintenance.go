@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+)
+
+const (
+	// interval is how often maintenance runs
+	interval = time.Hour
+	// partitionsAhead is how many months past the current one have build log
+	// partitions
+	partitionsAhead = 2
+	// lockKey is the advisory lock that keeps servers sharing a database from
+	// maintaining it at the same time
+	lockKey = 7_350_001
+)
+
+// Maintainer keeps the monthly partitions of build_logs: it creates those of
+// the coming months, so that logs are never routed to the default partition,
+// and drops those past the retention period, but for the logs of pinned
+// builds.
+type Maintainer struct {
+	db       *database.Database
+	settings *settings.Store
+}
+
+// NewMaintainer creates a maintainer. Build logs are kept for the retention
+// of the instance settings as it is at each run, or forever if it is zero.
+func NewMaintainer(db *database.Database, settings *settings.Store) *Maintainer {
+	return &Maintainer{db: db, settings: settings}
+}
+
+// Start runs maintenance now and then every hour until the context is
+// cancelled
+func (m *Maintainer) Start(ctx context.Context) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Info().Msg("Database maintenance started")
+
+	for {
+		if err := m.run(ctx, time.Now().UTC()); err != nil {
+			log.Error().Err(err).Msg("Database maintenance failed")
+		}
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Database maintenance stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run maintains the database unless another server already is
+func (m *Maintainer) run(ctx context.Context, now time.Time) error {
+	return m.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var locked bool
+		if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, lockKey).Scan(&locked); err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i <= partitionsAhead; i++ {
+			if _, err := tx.ExecContext(ctx, `SELECT create_build_logs_partition($1)`, month.AddDate(0, i, 0).Format("2006-01-02")); err != nil {
+				return fmt.Errorf("failed to create build log partition: %w", err)
+			}
+		}
+
+		retention := time.Duration(m.settings.Current().BuildLogRetentionDays) * 24 * time.Hour
+		if retention <= 0 {
+			return nil
+		}
+		return m.dropExpiredLogPartitions(ctx, tx, now.Add(-retention))
+	})
+}
+
+// dropExpiredLogPartitions drops the build log partitions of the months that
+// ended before cutoff. The logs of pinned builds in them move to the default
+// partition first, which catches them once their month's partition is
+// detached.
+func (m *Maintainer) dropExpiredLogPartitions(ctx context.Context, tx *sql.Tx, cutoff time.Time) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'build_logs'::regclass
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return err
+	}
+	expired := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		month, err := time.Parse("build_logs_2006_01", name)
+		if err != nil {
+			continue // the default partition
+		}
+		if month.AddDate(0, 1, 0).Before(cutoff) {
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range expired {
+		partition := pq.QuoteIdentifier(name)
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE build_logs DETACH PARTITION `+partition); err != nil {
+			return fmt.Errorf("failed to detach build log partition %s: %w", name, err)
+		}
+		kept, err := tx.ExecContext(ctx, `
+			INSERT INTO build_logs
+			SELECT l.* FROM `+partition+` l
+			JOIN builds b ON b.id = l.build_id
+			WHERE b.pinned
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to keep pinned build logs of partition %s: %w", name, err)
+		}
+		if n, _ := kept.RowsAffected(); n > 0 {
+			log.Info().Str("partition", name).Int64("lines", n).Msg("Kept log lines of pinned builds")
+		}
+		if _, err := tx.ExecContext(ctx, `DROP TABLE `+partition); err != nil {
+			return fmt.Errorf("failed to drop build log partition %s: %w", name, err)
+		}
+		log.Info().Str("partition", name).Msg("Dropped expired build log partition")
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package matchers
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// Problem matchers turn the plain log output of a build, such as compiler
+// errors and failed tests, into annotations, for the steps that do not report
+// annotations as plugins do. A job's build config or a pipeline lists them
+// under problem_matchers, each either the name of a built-in matcher or a
+// regular expression whose named groups capture the parts of an annotation:
+//
+//	problem_matchers:
+//	  - go
+//	  - name: mypy
+//	    pattern: '^(?P<file>[^:]+):(?P<line>\d+): (?P<severity>error|note): (?P<message>.+)$'
+//	    severity: warning
+//
+// The groups are file, line, end_line, severity, title and message, which a
+// pattern must have. A line matched by several matchers is annotated by the
+// first. The severity of a match is the severity group, read as error,
+// warning or notice, or the matcher's severity, error unless it sets one.
+
+// Field is where build configs and pipelines list their problem matchers
+const Field = "problem_matchers"
+
+// Workspace is where the build workspace is mounted in the containers builds
+// run in, removed from the start of the paths matchers capture
+const Workspace = "/workspace/"
+
+// namePattern is the pattern of matcher names, which annotations report as
+// their step
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// builtins are the matchers builds name
+var builtins = map[string]struct {
+	pattern  *regexp.Regexp
+	severity string
+}{
+	// go build, go vet and the failures of go test
+	"go": {regexp.MustCompile(`^\s*(?:\./)?(?P<file>[^\s:]+\.go):(?P<line>\d+)(?::\d+)?: (?P<message>.+)$`), models.AnnotationError},
+	// GCC and Clang
+	"gcc": {regexp.MustCompile(`^(?P<file>[^\s:]+):(?P<line>\d+):(?:\d+:)? (?P<severity>(?:fatal )?error|warning|note): (?P<message>.+)$`), models.AnnotationError},
+	// The TypeScript compiler
+	"tsc": {regexp.MustCompile(`^(?P<file>[^\s(]+)\((?P<line>\d+),\d+\): (?P<severity>error|warning) (?P<title>TS\d+): (?P<message>.+)$`), models.AnnotationError},
+	// ESLint with --format compact
+	"eslint-compact": {regexp.MustCompile(`^(?P<file>[^\s:]+): line (?P<line>\d+), col \d+, (?P<severity>Error|Warning) - (?P<message>.+?)(?: \((?P<title>[^()]+)\))?$`), models.AnnotationError},
+	// flake8 and ruff
+	"flake8": {regexp.MustCompile(`^(?P<file>[^\s:]+\.py):(?P<line>\d+):\d+: (?P<title>[A-Z]+\d+) (?P<message>.+)$`), models.AnnotationWarning},
+}
+
+// Builtins returns the names of the built-in matchers
+func Builtins() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Matcher extracts annotations from log lines
+type Matcher struct {
+	Name     string
+	pattern  *regexp.Regexp
+	severity string
+}
+
+// Parse returns the matchers of a problem_matchers list, reporting the
+// problems found at the path of the list, field. A missing list has none.
+func Parse(raw interface{}, field string) ([]Matcher, []string) {
+	if raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, []string{field + ": must be a list of matcher names or patterns"}
+	}
+
+	var matchers []Matcher
+	var problems []string
+	seen := map[string]bool{}
+	for i, item := range items {
+		at := fmt.Sprintf("%s[%d]", field, i)
+		var m Matcher
+		switch item := item.(type) {
+		case string:
+			b, ok := builtins[item]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown problem matcher %q (built in are %s)", at, item, strings.Join(Builtins(), ", ")))
+				continue
+			}
+			m = Matcher{Name: item, pattern: b.pattern, severity: b.severity}
+		case map[string]interface{}:
+			var problem string
+			if m, problem = parseCustom(item); problem != "" {
+				problems = append(problems, at+problem)
+				continue
+			}
+		default:
+			problems = append(problems, at+": must be a matcher name or an object with a name and a pattern")
+			continue
+		}
+		if seen[m.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate problem matcher %q", at, m.Name))
+			continue
+		}
+		seen[m.Name] = true
+		matchers = append(matchers, m)
+	}
+	return matchers, problems
+}
+
+// parseCustom returns the matcher an object of a problem_matchers list
+// defines, or the problem with it after its path
+func parseCustom(obj map[string]interface{}) (Matcher, string) {
+	for key := range obj {
+		if key != "name" && key != "pattern" && key != "severity" {
+			return Matcher{}, fmt.Sprintf(": unknown field %q", key)
+		}
+	}
+	name, _ := obj["name"].(string)
+	if !namePattern.MatchString(name) {
+		return Matcher{}, ".name: must be letters, digits, '.', '_' or '-'"
+	}
+	source, _ := obj["pattern"].(string)
+	if source == "" {
+		return Matcher{}, ".pattern: is required"
+	}
+	pattern, err := regexp.Compile(source)
+	if err != nil {
+		return Matcher{}, ".pattern: " + err.Error()
+	}
+	if pattern.SubexpIndex("message") < 0 {
+		return Matcher{}, ".pattern: needs a message group, (?P<message>...)"
+	}
+	for _, group := range pattern.SubexpNames() {
+		switch group {
+		case "", "file", "line", "end_line", "severity", "title", "message":
+		default:
+			return Matcher{}, fmt.Sprintf(".pattern: unknown group %q (want file, line, end_line, severity, title or message)", group)
+		}
+	}
+	severity := models.AnnotationError
+	if raw, ok := obj["severity"]; ok {
+		severity, _ = raw.(string)
+		if severity != models.AnnotationError && severity != models.AnnotationWarning && severity != models.AnnotationNotice {
+			return Matcher{}, ".severity: must be error, warning or notice"
+		}
+	}
+	return Matcher{Name: name, pattern: pattern, severity: severity}, ""
+}
+
+// Match returns the annotation of a log line for the first of the matchers
+// it matches, with the matcher's name as its step
+func Match(matchers []Matcher, line string) (models.Annotation, bool) {
+	for _, m := range matchers {
+		groups := m.pattern.FindStringSubmatch(line)
+		if groups == nil {
+			continue
+		}
+		group := func(name string) string {
+			if i := m.pattern.SubexpIndex(name); i >= 0 {
+				return strings.TrimSpace(groups[i])
+			}
+			return ""
+		}
+		message := group("message")
+		if message == "" {
+			continue
+		}
+
+		a := models.Annotation{
+			Step:     m.Name,
+			Path:     workspacePath(group("file")),
+			Severity: m.severity,
+			Title:    group("title"),
+			Message:  message,
+		}
+		if s := group("severity"); s != "" {
+			a.Severity = severity(s)
+		}
+		if a.Path != "" {
+			a.StartLine, _ = strconv.Atoi(group("line"))
+			a.EndLine, _ = strconv.Atoi(group("end_line"))
+			if a.StartLine < 0 {
+				a.StartLine = 0
+			}
+			if a.EndLine < a.StartLine {
+				a.EndLine = a.StartLine
+			}
+		}
+		return a, true
+	}
+	return models.Annotation{}, false
+}
+
+// severity reads a tool's severity, such as "fatal error", "Warning" or
+// "note", as an annotation severity
+func severity(s string) string {
+	s = strings.ToLower(s)
+	switch {
+	case strings.Contains(s, "error") || strings.Contains(s, "fatal") || strings.Contains(s, "fail"):
+		return models.AnnotationError
+	case strings.Contains(s, "warn"):
+		return models.AnnotationWarning
+	}
+	return models.AnnotationNotice
+}
+
+// workspacePath returns a path a tool printed relative to the repository
+// root, or "" if it is outside the workspace
+func workspacePath(name string) string {
+	name = strings.TrimPrefix(name, Workspace)
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return ""
+	}
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return ""
+	}
+	return clean
+}
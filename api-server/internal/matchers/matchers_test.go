@@ -0,0 +1,185 @@
+package matchers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		raw          interface{}
+		wantNames    []string
+		wantProblems []string
+	}{
+		{name: "missing"},
+		{
+			name:         "not a list",
+			raw:          "go",
+			wantProblems: []string{"problem_matchers: must be a list of matcher names or patterns"},
+		},
+		{
+			name:      "builtins and a custom matcher",
+			raw:       []interface{}{"go", "tsc", map[string]interface{}{"name": "mypy", "pattern": `^(?P<file>[^:]+):(?P<line>\d+): (?P<message>.+)$`, "severity": "warning"}},
+			wantNames: []string{"go", "tsc", "mypy"},
+		},
+		{
+			name:         "unknown builtin",
+			raw:          []interface{}{"rustc"},
+			wantProblems: []string{`problem_matchers[0]: unknown problem matcher "rustc" (built in are eslint-compact, flake8, gcc, go, tsc)`},
+		},
+		{
+			name:         "duplicate",
+			raw:          []interface{}{"go", map[string]interface{}{"name": "go", "pattern": `(?P<message>.+)`}},
+			wantNames:    []string{"go"},
+			wantProblems: []string{`problem_matchers[1]: duplicate problem matcher "go"`},
+		},
+		{
+			name:         "neither a name nor an object",
+			raw:          []interface{}{1.0},
+			wantProblems: []string{"problem_matchers[0]: must be a matcher name or an object with a name and a pattern"},
+		},
+		{
+			name: "invalid custom matchers",
+			raw: []interface{}{
+				map[string]interface{}{"name": "a", "pattern": `(?P<message>.+)`, "regex": "x"},
+				map[string]interface{}{"name": "-a", "pattern": `(?P<message>.+)`},
+				map[string]interface{}{"name": "a"},
+				map[string]interface{}{"name": "a", "pattern": `(?P<message>.+`},
+				map[string]interface{}{"name": "a", "pattern": `(?P<file>.+)`},
+				map[string]interface{}{"name": "a", "pattern": `(?P<col>\d+) (?P<message>.+)`},
+				map[string]interface{}{"name": "a", "pattern": `(?P<message>.+)`, "severity": "fatal"},
+			},
+			wantProblems: []string{
+				`problem_matchers[0]: unknown field "regex"`,
+				"problem_matchers[1].name: must be letters, digits, '.', '_' or '-'",
+				"problem_matchers[2].pattern: is required",
+				"problem_matchers[3].pattern: error parsing regexp: missing closing ): `(?P<message>.+`",
+				"problem_matchers[4].pattern: needs a message group, (?P<message>...)",
+				`problem_matchers[5].pattern: unknown group "col" (want file, line, end_line, severity, title or message)`,
+				"problem_matchers[6].severity: must be error, warning or notice",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, problems := Parse(tt.raw, Field)
+			var names []string
+			for _, m := range matchers {
+				names = append(names, m.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("names = %q, want %q", names, tt.wantNames)
+			}
+			if !reflect.DeepEqual(problems, tt.wantProblems) {
+				t.Errorf("problems =\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(tt.wantProblems, "\n"))
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	matchers, problems := Parse([]interface{}{
+		"go", "gcc", "tsc", "eslint-compact", "flake8",
+		map[string]interface{}{
+			"name":     "custom",
+			"pattern":  `^LINT (?P<file>\S+) (?P<line>-?\d+)-(?P<end_line>\d+) (?P<severity>\w+): (?P<message>.*)$`,
+			"severity": "notice",
+		},
+	}, Field)
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+
+	tests := []struct {
+		line string
+		want *models.Annotation
+	}{
+		{
+			line: "./cmd/server/main.go:42:7: undefined: foo",
+			want: &models.Annotation{Step: "go", Path: "cmd/server/main.go", StartLine: 42, EndLine: 42, Severity: "error", Message: "undefined: foo"},
+		},
+		{
+			line: "    handler_test.go:17: got 2, want 3",
+			want: &models.Annotation{Step: "go", Path: "handler_test.go", StartLine: 17, EndLine: 17, Severity: "error", Message: "got 2, want 3"},
+		},
+		{
+			line: "/workspace/src/main.c:10:5: warning: unused variable 'x'",
+			want: &models.Annotation{Step: "gcc", Path: "src/main.c", StartLine: 10, EndLine: 10, Severity: "warning", Message: "unused variable 'x'"},
+		},
+		{
+			line: "src/main.c:3: fatal error: foo.h: No such file or directory",
+			want: &models.Annotation{Step: "gcc", Path: "src/main.c", StartLine: 3, EndLine: 3, Severity: "error", Message: "foo.h: No such file or directory"},
+		},
+		{
+			line: "src/main.c:4:1: note: declared here",
+			want: &models.Annotation{Step: "gcc", Path: "src/main.c", StartLine: 4, EndLine: 4, Severity: "notice", Message: "declared here"},
+		},
+		{
+			line: "/usr/include/stdio.h:1:1: error: broken header",
+			want: &models.Annotation{Step: "gcc", Severity: "error", Message: "broken header"},
+		},
+		{
+			line: "src/app.ts(12,5): error TS2322: Type 'string' is not assignable to type 'number'.",
+			want: &models.Annotation{Step: "tsc", Path: "src/app.ts", StartLine: 12, EndLine: 12, Severity: "error", Title: "TS2322", Message: "Type 'string' is not assignable to type 'number'."},
+		},
+		{
+			line: "src/app.js: line 3, col 1, Warning - Unexpected console statement. (no-console)",
+			want: &models.Annotation{Step: "eslint-compact", Path: "src/app.js", StartLine: 3, EndLine: 3, Severity: "warning", Title: "no-console", Message: "Unexpected console statement."},
+		},
+		{
+			line: "app/views.py:8:1: E302 expected 2 blank lines, found 1",
+			want: &models.Annotation{Step: "flake8", Path: "app/views.py", StartLine: 8, EndLine: 8, Severity: "warning", Title: "E302", Message: "expected 2 blank lines, found 1"},
+		},
+		{
+			line: "LINT ../secret 5-9 info: outside the workspace",
+			want: &models.Annotation{Step: "custom", Severity: "notice", Message: "outside the workspace"},
+		},
+		{
+			line: "LINT a/./b.txt 9-5 Failure: end before start",
+			want: &models.Annotation{Step: "custom", Path: "a/b.txt", StartLine: 9, EndLine: 9, Severity: "error", Message: "end before start"},
+		},
+		{
+			line: "LINT a.txt -3-2 warn: negative line",
+			want: &models.Annotation{Step: "custom", Path: "a.txt", StartLine: 0, EndLine: 2, Severity: "warning", Message: "negative line"},
+		},
+		{line: "LINT a.txt 1-2 error: "},
+		{line: "ok  	github.com/solvyd/solvyd/api-server	0.01s"},
+		{line: ""},
+	}
+	for _, tt := range tests {
+		got, ok := Match(matchers, tt.line)
+		if tt.want == nil {
+			if ok {
+				t.Errorf("Match(%q) = %+v, want no match", tt.line, got)
+			}
+			continue
+		}
+		if !ok || got != *tt.want {
+			t.Errorf("Match(%q) = %+v, %v, want %+v", tt.line, got, ok, *tt.want)
+		}
+	}
+}
+
+func TestWorkspacePath(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"main.go", "main.go"},
+		{"/workspace/cmd/main.go", "cmd/main.go"},
+		{"./a/../b/c.go", "b/c.go"},
+		{"", ""},
+		{".", ""},
+		{"/workspace/", ""},
+		{"/etc/passwd", ""},
+		{"../outside.go", ""},
+		{"a/../../outside.go", ""},
+		{`C:\src\main.go`, ""},
+	}
+	for _, tt := range tests {
+		if got := workspacePath(tt.in); got != tt.want {
+			t.Errorf("workspacePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -55,6 +55,30 @@ var (
 		[]string{"worker_name"},
 	)
 
+	poolCapacity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ritmo_pool_capacity",
+			Help: "Build slots of the online workers of a worker pool",
+		},
+		[]string{"pool"},
+	)
+
+	poolBuilds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ritmo_pool_builds",
+			Help: "Builds queued for and running on a worker pool, by status",
+		},
+		[]string{"pool", "status"},
+	)
+
+	poolDesiredWorkers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ritmo_pool_desired_workers",
+			Help: "Workers the autoscaling policy of a worker pool asks for",
+		},
+		[]string{"pool"},
+	)
+
 	deploymentsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "ritmo_deployments_total",
@@ -79,6 +103,54 @@ var (
 		},
 		[]string{"method", "endpoint"},
 	)
+
+	pluginStepsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ritmo_plugin_steps_total",
+			Help: "Total number of plugin steps run, by plugin, job and outcome",
+		},
+		[]string{"plugin", "job_name", "status"},
+	)
+
+	pluginStepDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ritmo_plugin_step_duration_seconds",
+			Help:    "Plugin step duration in seconds",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 13), // 1s to ~68 minutes
+		},
+		[]string{"plugin", "job_name", "status"},
+	)
+
+	pluginFindingsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ritmo_plugin_findings_total",
+			Help: "Total number of findings reported by plugin steps",
+		},
+		[]string{"plugin", "job_name"},
+	)
+
+	logLinesStored = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ritmo_log_lines_stored_total",
+			Help: "Total number of build log lines stored",
+		},
+	)
+
+	logFlushLines = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ritmo_log_flush_lines",
+			Help:    "Build log lines sent in one flush of the log ingester",
+			Buckets: prometheus.ExponentialBuckets(10, 4, 7), // 10 to ~40000
+		},
+	)
+
+	logFlushDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ritmo_log_flush_duration_seconds",
+			Help:    "Duration of a flush of the log ingester in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 10), // 5ms to ~2.5s
+		},
+	)
 )
 
 func init() {
@@ -88,9 +160,18 @@ func init() {
 	prometheus.MustRegister(buildDuration)
 	prometheus.MustRegister(workersTotal)
 	prometheus.MustRegister(workerUtilization)
+	prometheus.MustRegister(poolCapacity)
+	prometheus.MustRegister(poolBuilds)
+	prometheus.MustRegister(poolDesiredWorkers)
 	prometheus.MustRegister(deploymentsTotal)
 	prometheus.MustRegister(apiRequestsTotal)
 	prometheus.MustRegister(apiRequestDuration)
+	prometheus.MustRegister(pluginStepsTotal)
+	prometheus.MustRegister(pluginStepDuration)
+	prometheus.MustRegister(pluginFindingsTotal)
+	prometheus.MustRegister(logLinesStored)
+	prometheus.MustRegister(logFlushLines)
+	prometheus.MustRegister(logFlushDuration)
 }
 
 // Collector provides methods to record metrics
@@ -112,11 +193,30 @@ func (c *Collector) RecordBuildCompleted(jobName, status string, duration float6
 	buildDuration.WithLabelValues(jobName, status).Observe(duration)
 }
 
+// RecordBuildQueue updates the queued and running builds gauges
+func (c *Collector) RecordBuildQueue(queued, running int) {
+	buildsQueued.Set(float64(queued))
+	buildsRunning.Set(float64(running))
+}
+
 // RecordWorkerCount updates the worker count metric
 func (c *Collector) RecordWorkerCount(status string, count int) {
 	workersTotal.WithLabelValues(status).Set(float64(count))
 }
 
+// RecordPool updates the capacity and queue depth gauges of a worker pool,
+// and its desired workers if it has an autoscaling policy
+func (c *Collector) RecordPool(pool string, capacity, queued, running int, desiredWorkers *int) {
+	poolCapacity.WithLabelValues(pool).Set(float64(capacity))
+	poolBuilds.WithLabelValues(pool, "queued").Set(float64(queued))
+	poolBuilds.WithLabelValues(pool, "running").Set(float64(running))
+	if desiredWorkers != nil {
+		poolDesiredWorkers.WithLabelValues(pool).Set(float64(*desiredWorkers))
+	} else {
+		poolDesiredWorkers.DeleteLabelValues(pool)
+	}
+}
+
 // RecordDeployment records a deployment
 func (c *Collector) RecordDeployment(environment, status string) {
 	deploymentsTotal.WithLabelValues(environment, status).Inc()
@@ -128,6 +228,23 @@ func (c *Collector) RecordAPIRequest(method, endpoint, status string, duration f
 	apiRequestDuration.WithLabelValues(method, endpoint).Observe(duration)
 }
 
+// RecordPluginStep records a finished plugin step of a build of a job
+func (c *Collector) RecordPluginStep(plugin, jobName, status string, duration float64, findings int) {
+	pluginStepsTotal.WithLabelValues(plugin, jobName, status).Inc()
+	pluginStepDuration.WithLabelValues(plugin, jobName, status).Observe(duration)
+	if findings > 0 {
+		pluginFindingsTotal.WithLabelValues(plugin, jobName).Add(float64(findings))
+	}
+}
+
+// RecordLogFlush records a flush of the log ingester, of the lines sent and
+// those stored
+func (c *Collector) RecordLogFlush(lines, stored int, duration float64) {
+	logLinesStored.Add(float64(stored))
+	logFlushLines.Observe(float64(lines))
+	logFlushDuration.Observe(duration)
+}
+
 // Handler returns the Prometheus HTTP handler
 func Handler() http.Handler {
 	return promhttp.Handler()
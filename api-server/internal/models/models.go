@@ -62,6 +62,30 @@ func (j *JSONB) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, j)
 }
 
+// JSONArray is a custom type for PostgreSQL JSONB arrays
+type JSONArray []interface{}
+
+// Value implements the driver.Valuer interface
+func (a JSONArray) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(a)
+}
+
+// Scan implements the sql.Scanner interface
+func (a *JSONArray) Scan(value interface{}) error {
+	if value == nil {
+		*a = JSONArray{}
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, a)
+}
+
 // Job represents a CI/CD job
 type Job struct {
 	ID          uuid.UUID `json:"id"`
@@ -76,18 +100,37 @@ type Job struct {
 	BuildConfig JSONB `json:"build_config"`
 	EnvVars     JSONB `json:"environment_vars"`
 	// Scheduling
-	Triggers       JSONB `json:"triggers"`
-	Enabled        bool  `json:"enabled"`
-	WorkerLabels   JSONB `json:"worker_labels"`
-	Plugins        JSONB `json:"plugins"`
-	PipelineStages JSONB `json:"pipeline_stages"`
+	Triggers       JSONArray `json:"triggers"`
+	Enabled        bool      `json:"enabled"`
+	WorkerLabels   JSONB     `json:"worker_labels"`
+	Plugins        JSONArray `json:"plugins"`
+	Notifications  JSONArray `json:"notifications"` // notification steps with the rules that fire them
+	PipelineStages JSONArray `json:"pipeline_stages"`
+	// Why, by whom and when a disabled job was disabled, and when it is
+	// enabled again; set through POST /jobs/{id}/disable
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	DisabledBy     string     `json:"disabled_by,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	ReenableAt     *time.Time `json:"reenable_at,omitempty"`
+	// Path of the pipeline definition in the repository, read at each build's
+	// commit in place of build_config and plugins
+	PipelineFile string `json:"pipeline_file,omitempty"`
 	// Timeout and retry
 	TimeoutMinutes int `json:"timeout_minutes"`
 	MaxRetries     int `json:"max_retries"`
+	// A new build of a branch cancels the branch's queued and running builds
+	CancelInProgress bool `json:"cancel_in_progress"`
+	// Builds keep queueing but are not dispatched while an administrator
+	// has paused the job's queue
+	QueuePaused bool `json:"queue_paused"`
+	// Worker pool the job's builds run on; empty runs them on the default pool
+	Pool string `json:"pool,omitempty"`
 	// Metadata
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	CreatedBy string    `json:"created_by"`
+	Project   string    `json:"project,omitempty"`
+	Labels    JSONB     `json:"labels,omitempty"` // passed to plugins, e.g. to route notifications
 }
 
 // Build represents a single build execution
@@ -102,13 +145,24 @@ type Build struct {
 	StartedAt   *time.Time `json:"started_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 	Duration    *int       `json:"duration_seconds,omitempty"`
+	// Median duration of the job's recent successful builds when this one
+	// succeeded, and whether it took markedly longer
+	DurationBaseline   *int `json:"duration_baseline_seconds,omitempty"`
+	DurationRegression bool `json:"duration_regression"`
 	// Worker
 	WorkerID *uuid.UUID `json:"worker_id,omitempty"`
+	// Of a queued build, why no worker has taken it yet, and when the
+	// scheduler last found so
+	SkipReason string     `json:"skip_reason,omitempty"`
+	SkippedAt  *time.Time `json:"skipped_at,omitempty"`
 	// SCM context
-	CommitSHA     string `json:"scm_commit_sha"`
-	CommitMessage string `json:"scm_commit_message"`
-	Author        string `json:"scm_author"`
-	Branch        string `json:"branch"`
+	CommitSHA     string     `json:"scm_commit_sha"`
+	CommitMessage string     `json:"scm_commit_message"`
+	Author        string     `json:"scm_author"`
+	AuthorEmail   string     `json:"scm_author_email,omitempty"`
+	CommittedAt   *time.Time `json:"scm_committed_at,omitempty"`
+	Branch        string     `json:"branch"`
+	ChangedFiles  JSONArray  `json:"changed_files"` // paths changed since the previous successful build's commit
 	// Build context
 	Parameters JSONB `json:"parameters"`
 	EnvVars    JSONB `json:"environment_vars"`
@@ -116,11 +170,22 @@ type Build struct {
 	TriggeredBy     string `json:"triggered_by"`
 	TriggerMetadata JSONB  `json:"trigger_metadata"`
 	// Results
-	ExitCode      *int      `json:"exit_code,omitempty"`
-	ErrorMessage  string    `json:"error_message,omitempty"`
-	LogURL        string    `json:"log_url,omitempty"`
-	ArtifactCount int       `json:"artifact_count"`
-	CreatedAt     time.Time `json:"created_at"`
+	ExitCode      *int       `json:"exit_code,omitempty"`
+	ErrorMessage  string     `json:"error_message,omitempty"`
+	CancelReason  string     `json:"cancel_reason,omitempty"`  // user, superseded or pull_request_closed
+	FailureReason string     `json:"failure_reason,omitempty"` // disk_quota_exceeded or failed_by_admin
+	RerunOf       *uuid.UUID `json:"rerun_of,omitempty"`       // the build this one re-runs
+	RerunStages   JSONArray  `json:"rerun_stages,omitempty"`   // the stages a rerun from the failed stage runs
+	SupersededBy  *uuid.UUID `json:"superseded_by,omitempty"`  // the build that replaced a superseded build
+	LogURL        string     `json:"log_url,omitempty"`
+	ArtifactCount int        `json:"artifact_count"`
+	Tags          []string   `json:"tags"`
+	// Retention
+	Pinned    bool       `json:"pinned"` // kept forever, with its artifacts and logs
+	PinnedBy  string     `json:"pinned_by,omitempty"`
+	PinnedAt  *time.Time `json:"pinned_at,omitempty"`
+	PinReason string     `json:"pin_reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 // Worker represents a worker node
@@ -134,6 +199,8 @@ type Worker struct {
 	CurrentBuilds       int `json:"current_builds"`
 	CPUCores            int `json:"cpu_cores"`
 	MemoryMB            int `json:"memory_mb"`
+	// Worker pool; empty for the default pool
+	Pool string `json:"pool,omitempty"`
 	// Labels and capabilities
 	Labels       JSONB `json:"labels"`
 	Capabilities JSONB `json:"capabilities"`
@@ -142,8 +209,57 @@ type Worker struct {
 	LastHeartbeat time.Time    `json:"last_heartbeat"`
 	HealthStatus  string       `json:"health_status"`
 	AgentVersion  string       `json:"agent_version"`
-	RegisteredAt  time.Time    `json:"registered_at"`
-	UpdatedAt     time.Time    `json:"updated_at"`
+	// Resource usage of the latest heartbeat reporting it
+	Resources           *WorkerResources `json:"resources,omitempty"`
+	ResourcesReportedAt *time.Time       `json:"resources_reported_at,omitempty"`
+	RegisteredAt        time.Time        `json:"registered_at"`
+	UpdatedAt           time.Time        `json:"updated_at"`
+}
+
+// WorkerResources is the load and free resources of a worker, and what its
+// running builds use, as reported with its heartbeats
+type WorkerResources struct {
+	CPUCores int `json:"cpu_cores"`
+	// Load averages over 1, 5 and 15 minutes
+	Load1  float64 `json:"load_1m"`
+	Load5  float64 `json:"load_5m"`
+	Load15 float64 `json:"load_15m"`
+	// Memory, and memory available to new processes
+	MemoryTotalMB     int64 `json:"memory_total_mb"`
+	MemoryAvailableMB int64 `json:"memory_available_mb"`
+	// Disk of the build workspaces
+	DiskTotalMB int64 `json:"disk_total_mb"`
+	DiskFreeMB  int64 `json:"disk_free_mb"`
+	// Disk used by the build workspaces and the cache, and the worker's
+	// quota of it (0 is unlimited)
+	WorkspaceUsedMB int64 `json:"workspace_used_mb"`
+	CacheUsedMB     int64 `json:"cache_used_mb"`
+	DiskQuotaMB     int64 `json:"disk_quota_mb"`
+	// At or over its disk quota; the scheduler assigns it no builds
+	DiskFull bool         `json:"disk_full"`
+	Builds   []BuildUsage `json:"builds"`
+}
+
+// BuildUsage is what a running build uses of its worker's resources
+type BuildUsage struct {
+	BuildID    string  `json:"build_id"`
+	CPUPercent float64 `json:"cpu_percent"` // of one core
+	MemoryMB   int64   `json:"memory_mb"`
+	DiskMB     int64   `json:"disk_mb"` // of its workspace
+}
+
+// Value implements the driver.Valuer interface
+func (r WorkerResources) Value() (driver.Value, error) {
+	if r.Builds == nil {
+		r.Builds = []BuildUsage{}
+	}
+	return json.Marshal(r)
+}
+
+// WorkerResourceSample is a worker's resource usage at a point in time
+type WorkerResourceSample struct {
+	RecordedAt time.Time `json:"recorded_at"`
+	WorkerResources
 }
 
 // Artifact represents a build artifact
@@ -155,6 +271,11 @@ type Artifact struct {
 	SizeBytes      int64     `json:"size_bytes"`
 	ChecksumSHA256 string    `json:"checksum_sha256"`
 	ContentType    string    `json:"content_type"`
+	// Integrity: whether its content can be served, and when its checksum
+	// was last verified
+	Status       ArtifactStatus `json:"status"`
+	StatusReason string         `json:"status_reason,omitempty"`
+	VerifiedAt   *time.Time     `json:"verified_at,omitempty"`
 	// Storage
 	StoragePlugin   string `json:"storage_plugin"`
 	StorageURL      string `json:"storage_url"`
@@ -167,6 +288,45 @@ type Artifact struct {
 	Metadata        JSONB      `json:"metadata"`
 }
 
+// ArtifactStatus is the integrity status of an artifact
+type ArtifactStatus string
+
+const (
+	ArtifactStatusAvailable   ArtifactStatus = "available"
+	ArtifactStatusCorrupted   ArtifactStatus = "corrupted" // its content no longer matches its checksum
+	ArtifactStatusMissing     ArtifactStatus = "missing"   // its content is gone from storage
+	ArtifactStatusQuarantined ArtifactStatus = "quarantined"
+)
+
+// CacheEntry is a blob stored through the cache API under a key, within a
+// scope and a version
+type CacheEntry struct {
+	Scope          string    `json:"scope"`
+	Version        string    `json:"version"`
+	Key            string    `json:"key"`
+	SizeBytes      int64     `json:"size_bytes"`
+	ChecksumSHA256 string    `json:"checksum_sha256"`
+	StorageKey     string    `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+}
+
+// Alert is an operational condition of the instance, such as a worker gone
+// offline, from when it was first seen until it is resolved
+type Alert struct {
+	ID         uuid.UUID  `json:"id"`
+	Kind       string     `json:"kind"`    // worker_offline, queue_wait or no_capacity
+	Subject    string     `json:"subject"` // the worker or pool alerted about
+	Message    string     `json:"message"`
+	Details    JSONB      `json:"details,omitempty"`
+	Since      time.Time  `json:"since"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"` // unset while the condition has not lasted long enough
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	// The notifications of the event, fired or resolved, that workers have
+	// yet to deliver
+	PendingEvent string `json:"pending_event,omitempty"`
+}
+
 // Deployment represents a deployment record
 type Deployment struct {
 	ID          uuid.UUID        `json:"id"`
@@ -211,6 +371,29 @@ type Plugin struct {
 	UpdatedAt      time.Time `json:"updated_at"`
 }
 
+// PluginVersion is a published plugin binary in the plugin registry
+type PluginVersion struct {
+	ID             uuid.UUID `json:"id"`
+	PluginName     string    `json:"plugin_name"`
+	Version        string    `json:"version"`
+	Type           string    `json:"type"`
+	OS             string    `json:"os"`
+	Arch           string    `json:"arch"`
+	StorageKey     string    `json:"-"`
+	ChecksumSHA256 string    `json:"checksum_sha256"`
+	SizeBytes      int64     `json:"size_bytes"`
+	Description    string    `json:"description"`
+	Author         string    `json:"author"`
+	HomepageURL    string    `json:"homepage_url"`
+	ConfigSchema   JSONB     `json:"config_schema"`
+	Yanked         bool      `json:"yanked"`
+	DownloadCount  int       `json:"download_count"`
+	Source         string    `json:"source,omitempty"` // URL or OCI reference it was imported from
+	Signed         bool      `json:"signed"`           // signed by a trusted key when stored
+	PublishedBy    string    `json:"published_by"`
+	PublishedAt    time.Time `json:"published_at"`
+}
+
 // BuildLog represents a log line from a build
 type BuildLog struct {
 	ID             uuid.UUID `json:"id"`
@@ -220,3 +403,295 @@ type BuildLog struct {
 	LogLine        string    `json:"log_line"`
 	Stream         string    `json:"stream"` // stdout or stderr
 }
+
+// Finding represents a security finding reported during a build
+type Finding struct {
+	ID          uuid.UUID       `json:"id"`
+	BuildID     uuid.UUID       `json:"build_id"`
+	Tool        string          `json:"tool"`
+	RuleID      string          `json:"rule_id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Severity    string          `json:"severity"` // critical, high, medium, low or info
+	Location    FindingLocation `json:"location"`
+	CWEs        []string        `json:"cwes"`
+	CVEs        []string        `json:"cves"`
+	HelpURL     string          `json:"help_url,omitempty"`
+	Fingerprint string          `json:"fingerprint"` // stable across builds
+	Status      string          `json:"status"`      // new, baseline or suppressed
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Finding statuses, relative to the job's baseline and suppressions
+const (
+	FindingStatusNew        = "new"
+	FindingStatusBaseline   = "baseline"
+	FindingStatusSuppressed = "suppressed"
+)
+
+// FindingLocation is where a finding was found: a source file, a dependency
+// or a URL
+type FindingLocation struct {
+	Path      string `json:"path,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	URI       string `json:"uri,omitempty"`
+	Package   string `json:"package,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// TestResult is the outcome of one test case reported during a build
+type TestResult struct {
+	ID         uuid.UUID `json:"id"`
+	BuildID    uuid.UUID `json:"build_id"`
+	Step       string    `json:"step,omitempty"` // plugin that reported it
+	Suite      string    `json:"suite,omitempty"`
+	ClassName  string    `json:"class_name,omitempty"`
+	Name       string    `json:"name"`
+	Status     string    `json:"status"` // passed, failed, error or skipped
+	Message    string    `json:"message,omitempty"`
+	Details    string    `json:"details,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Test case statuses
+const (
+	TestStatusPassed  = "passed"
+	TestStatusFailed  = "failed"
+	TestStatusError   = "error"
+	TestStatusSkipped = "skipped"
+)
+
+// Annotation is a problem a plugin step reported at a line of a file during a
+// build, such as a lint error, rendered inline with the file and posted as a
+// pull request review comment
+type Annotation struct {
+	ID        uuid.UUID `json:"id"`
+	BuildID   uuid.UUID `json:"build_id"`
+	Step      string    `json:"step,omitempty"` // plugin that reported it
+	Path      string    `json:"path,omitempty"` // relative to the repository root; empty for the whole build
+	StartLine int       `json:"start_line,omitempty"`
+	EndLine   int       `json:"end_line,omitempty"`
+	Severity  string    `json:"severity"` // error, warning or notice
+	Title     string    `json:"title,omitempty"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Annotation severities
+const (
+	AnnotationError   = "error"
+	AnnotationWarning = "warning"
+	AnnotationNotice  = "notice"
+)
+
+// Coverage is the code coverage a plugin step reported for a build
+type Coverage struct {
+	ID              uuid.UUID `json:"id"`
+	BuildID         uuid.UUID `json:"build_id"`
+	BuildNumber     int       `json:"build_number,omitempty"` // set in a job's coverage history
+	Branch          string    `json:"branch,omitempty"`       // set in a job's coverage history
+	Step            string    `json:"step,omitempty"`         // plugin that reported it
+	Format          string    `json:"format,omitempty"`
+	LinesCovered    int       `json:"lines_covered"`
+	LinesTotal      int       `json:"lines_total"`
+	BranchesCovered int       `json:"branches_covered"`
+	BranchesTotal   int       `json:"branches_total"`
+	LinePercent     float64   `json:"line_percent"`
+	BranchPercent   float64   `json:"branch_percent"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Build lifecycle event types. Events ending in _started and _finished mark
+// the start and end of a span of the build's timeline, matched by name.
+const (
+	BuildEventQueued               = "queued"
+	BuildEventAssigned             = "assigned" // to a worker, by the scheduler
+	BuildEventStarted              = "started"  // the worker agent began the build
+	BuildEventStageStarted         = "stage_started"
+	BuildEventStageFinished        = "stage_finished" // clone and build commands
+	BuildEventStepStarted          = "step_started"
+	BuildEventStepFinished         = "step_finished" // plugin steps
+	BuildEventNotificationStarted  = "notification_started"
+	BuildEventNotificationFinished = "notification_finished"
+	BuildEventArtifact             = "artifact" // a plugin step produced an artifact
+	BuildEventCancelled            = "cancelled"
+	BuildEventFinished             = "finished"
+)
+
+// ValidBuildEventType reports whether t is a known build event type
+func ValidBuildEventType(t string) bool {
+	switch t {
+	case BuildEventQueued, BuildEventAssigned, BuildEventStarted,
+		BuildEventStageStarted, BuildEventStageFinished,
+		BuildEventStepStarted, BuildEventStepFinished,
+		BuildEventNotificationStarted, BuildEventNotificationFinished,
+		BuildEventArtifact, BuildEventCancelled, BuildEventFinished:
+		return true
+	}
+	return false
+}
+
+// BuildEvent is a point in a build's lifecycle
+type BuildEvent struct {
+	Type      string    `json:"type"`
+	Name      string    `json:"name,omitempty"`   // the stage, step or artifact
+	Status    string    `json:"status,omitempty"` // how a stage or step ended
+	Timestamp time.Time `json:"timestamp"`
+	Metadata  JSONB     `json:"metadata,omitempty"`
+}
+
+// BuildTimeline is a build's events and the spans of time between them
+type BuildTimeline struct {
+	BuildID  uuid.UUID      `json:"build_id"`
+	Status   JobStatus      `json:"status"`
+	QueuedAt time.Time      `json:"queued_at"`
+	Events   []BuildEvent   `json:"events"`
+	Spans    []TimelineSpan `json:"spans"`
+}
+
+// TimelineSpan is the time a part of a build took
+type TimelineSpan struct {
+	Kind       string     `json:"kind"` // queue, build, stage, step or notification
+	Name       string     `json:"name"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"` // unset while it runs
+	Status     string     `json:"status,omitempty"`
+	DurationMS int64      `json:"duration_ms"`
+}
+
+// BuildGraph is the stage graph of a build, laid out for drawing: stages are
+// placed in columns by level, after the stages they depend on
+type BuildGraph struct {
+	BuildID uuid.UUID   `json:"build_id"`
+	Status  JobStatus   `json:"status"`
+	Source  string      `json:"source"` // pipeline_file, build_config or default
+	Nodes   []GraphNode `json:"nodes"`
+	Edges   []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a stage of a build graph and how it ran
+type GraphNode struct {
+	Name       string     `json:"name"`
+	Image      string     `json:"image,omitempty"`
+	DependsOn  []string   `json:"depends_on"`
+	Level      int        `json:"level"`  // the longest chain of dependencies before it
+	Status     string     `json:"status"` // pending, running, success, failed, skipped or interrupted
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMS int64      `json:"duration_ms"`
+}
+
+// GraphEdge is a dependency between two stages of a build graph
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// BuildStats summarises the builds of a job, or of all jobs, queued in a
+// window
+type BuildStats struct {
+	JobID       *uuid.UUID     `json:"job_id,omitempty"`
+	Branch      string         `json:"branch,omitempty"`
+	Since       time.Time      `json:"since"`
+	Until       time.Time      `json:"until"`
+	TotalBuilds int            `json:"total_builds"`
+	ByStatus    map[string]int `json:"by_status"`
+	SuccessRate *float64       `json:"success_rate"` // of completed builds, 0 to 1; null without any
+	Duration    struct {
+		Average *float64 `json:"average"`
+		Median  *float64 `json:"median"`
+		P95     *float64 `json:"p95"`
+	} `json:"duration_seconds"` // of completed builds
+	PerDay         []DailyBuilds `json:"per_day"`
+	FailureStreaks struct {
+		Current     int `json:"current"`      // the longest run of failures a job has not recovered from yet
+		Longest     int `json:"longest"`      // the longest run of failures of a job
+		FailingJobs int `json:"failing_jobs"` // jobs whose last completed build failed
+	} `json:"failure_streaks"`
+}
+
+// DailyBuilds counts the builds queued on a day (UTC)
+type DailyBuilds struct {
+	Date    string `json:"date"`
+	Total   int    `json:"total"`
+	Success int    `json:"success"`
+	Failed  int    `json:"failed"` // failed or timed out
+}
+
+// DORAMetrics are the DORA metrics of the deployments started in a window,
+// overall and for each environment
+type DORAMetrics struct {
+	JobID        *uuid.UUID        `json:"job_id,omitempty"`
+	Environment  string            `json:"environment,omitempty"`
+	Since        time.Time         `json:"since"`
+	Until        time.Time         `json:"until"`
+	Overall      DORAEnvironment   `json:"overall"`
+	Environments []DORAEnvironment `json:"environments"`
+}
+
+// DORAEnvironment holds the DORA metrics of one environment, or of all of them
+type DORAEnvironment struct {
+	Environment           string  `json:"environment,omitempty"`
+	Deployments           int     `json:"deployments"` // completed deployments
+	SuccessfulDeployments int     `json:"successful_deployments"`
+	FailedDeployments     int     `json:"failed_deployments"` // failed or rolled back
+	DeploymentsPerDay     float64 `json:"deployments_per_day"`
+	LeadTime              struct {
+		Average *float64 `json:"average"`
+		Median  *float64 `json:"median"`
+		P95     *float64 `json:"p95"`
+	} `json:"lead_time_seconds"` // from commit to successful deployment
+	ChangeFailureRate *float64 `json:"change_failure_rate"` // 0 to 1; null without deployments
+	Outages           int      `json:"outages"`             // runs of failed deployments
+	OpenOutages       int      `json:"open_outages"`        // not yet restored by a successful deployment
+	TimeToRestore     struct {
+		Average *float64 `json:"average"`
+		Median  *float64 `json:"median"`
+	} `json:"time_to_restore_seconds"`
+}
+
+// FindingBaselineEntry is a finding a job accepts as existing backlog
+type FindingBaselineEntry struct {
+	ID          uuid.UUID  `json:"id"`
+	JobID       uuid.UUID  `json:"job_id"`
+	BuildID     *uuid.UUID `json:"build_id,omitempty"`
+	Fingerprint string     `json:"fingerprint"`
+	Tool        string     `json:"tool"`
+	RuleID      string     `json:"rule_id"`
+	Title       string     `json:"title"`
+	Severity    string     `json:"severity"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// FindingSuppression excludes a finding from a job's build results until it
+// expires
+type FindingSuppression struct {
+	ID          uuid.UUID  `json:"id"`
+	JobID       uuid.UUID  `json:"job_id"`
+	Fingerprint string     `json:"fingerprint"`
+	Reason      string     `json:"reason"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+}
+
+// LicenseException approves a package version whose license the license
+// policy denies or does not know. Anyone may request one; it applies once
+// the compliance role approves it, until it expires.
+type LicenseException struct {
+	ID            uuid.UUID  `json:"id"`
+	Ecosystem     string     `json:"ecosystem,omitempty"` // npm, golang or maven; any if empty
+	Package       string     `json:"package"`
+	Version       string     `json:"version"`
+	License       string     `json:"license,omitempty"` // the license excepted; any if empty
+	Justification string     `json:"justification"`
+	Status        string     `json:"status"` // pending, approved, rejected or expired
+	RequestedBy   string     `json:"requested_by,omitempty"`
+	RequestedAt   time.Time  `json:"requested_at"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+	DecidedAt     *time.Time `json:"decided_at,omitempty"`
+	DecisionNote  string     `json:"decision_note,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
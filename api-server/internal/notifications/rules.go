@@ -0,0 +1,168 @@
+package notifications
+
+import "path"
+
+// Events a completed build can raise. A notification fires if any of the
+// events in its "on" list was raised.
+const (
+	EventFailure      = "failure"       // the build failed
+	EventFirstFailure = "first_failure" // the build failed and the previous one did not
+	EventRecovery     = "recovery"      // the build succeeded and the previous one failed
+	EventUnstable     = "unstable"      // the build succeeded with failed tests, or regressed in duration
+	EventSuccess      = "success"       // the build succeeded
+	EventAlways       = "always"        // the build completed, whatever its outcome
+)
+
+// DefaultEvents are the events a notification without an "on" list fires for,
+// unless the instance settings say otherwise
+var DefaultEvents = []string{EventFailure}
+
+// knownEvents are the events a build can raise
+var knownEvents = []string{EventFailure, EventFirstFailure, EventRecovery, EventUnstable, EventSuccess, EventAlways}
+
+// ValidEvent reports whether a build can raise the event
+func ValidEvent(event string) bool {
+	for _, e := range knownEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleKeys are the keys of a notification that the API server evaluates;
+// the rest is the plugin step the worker agent runs
+var ruleKeys = []string{"on", "branches", "environments"}
+
+// Outcome is what the rules are evaluated against
+type Outcome struct {
+	Status         string // the build's status
+	PreviousStatus string // status of the job's previous completed build on the branch, or ""
+	Unstable       bool   // the build has failed or errored test results, or a duration regression the settings count
+	Branch         string
+	Environment    string // the build's environment parameter or the job's environment label
+}
+
+// Failed reports whether a build status is a failure
+func Failed(status string) bool {
+	switch status {
+	case "failure", "failed", "timeout":
+		return true
+	}
+	return false
+}
+
+// Events returns the events the outcome raises. Cancelled builds raise only
+// EventAlways.
+func (o Outcome) Events() []string {
+	events := []string{EventAlways}
+	switch {
+	case Failed(o.Status):
+		events = append(events, EventFailure)
+		if !Failed(o.PreviousStatus) {
+			events = append(events, EventFirstFailure)
+		}
+	case o.Status == "success":
+		events = append(events, EventSuccess)
+		if Failed(o.PreviousStatus) {
+			events = append(events, EventRecovery)
+		}
+		if o.Unstable {
+			events = append(events, EventUnstable)
+		}
+	}
+	return events
+}
+
+// Notification is a notification step selected for a build
+type Notification struct {
+	Step   map[string]interface{} `json:"step"`   // the plugin step, without its rules
+	Events []string               `json:"events"` // the raised events it fires for
+}
+
+// Select returns the notifications of a job that fire for the outcome, in the
+// job's order. Notifications without an "on" list fire for defaultEvents, and
+// those that are a bare plugin name for defaultEvents on every branch and
+// environment.
+func Select(notifications []interface{}, o Outcome, defaultEvents []string) []Notification {
+	raised := map[string]bool{}
+	for _, e := range o.Events() {
+		raised[e] = true
+	}
+
+	selected := []Notification{}
+	for _, raw := range notifications {
+		var rule map[string]interface{}
+		switch n := raw.(type) {
+		case string:
+			rule = map[string]interface{}{"name": n}
+		case map[string]interface{}:
+			rule = n
+		default:
+			continue
+		}
+
+		if !matchesAny(stringList(rule["branches"]), o.Branch, true) ||
+			!matchesAny(stringList(rule["environments"]), o.Environment, false) {
+			continue
+		}
+
+		on := stringList(rule["on"])
+		if on == nil {
+			on = defaultEvents
+		}
+		var events []string
+		for _, e := range on {
+			if raised[e] {
+				events = append(events, e)
+			}
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		step := map[string]interface{}{}
+		for k, v := range rule {
+			step[k] = v
+		}
+		for _, k := range ruleKeys {
+			delete(step, k)
+		}
+		selected = append(selected, Notification{Step: step, Events: events})
+	}
+	return selected
+}
+
+// matchesAny reports whether value matches one of the patterns, or whether
+// there are none. Branch patterns may use path.Match wildcards; environments
+// are compared exactly.
+func matchesAny(patterns []string, value string, glob bool) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if glob {
+			if ok, _ := path.Match(p, value); ok {
+				return true
+			}
+		} else if p == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList returns the strings of a JSON list, or nil if v is not a list
+func stringList(v interface{}) []string {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	out := []string{}
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
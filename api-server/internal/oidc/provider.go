@@ -0,0 +1,218 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+)
+
+// Paths of the discovery documents, under the issuer URL
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/.well-known/jwks"
+)
+
+// Provider issues OIDC identity tokens to running builds, signed with RS256,
+// and publishes the discovery document and signing key cloud providers
+// verify them with
+type Provider struct {
+	issuer string
+	key    *rsa.PrivateKey
+	keyID  string
+	ttl    time.Duration
+}
+
+// NewProvider creates a provider from the OIDC configuration, which must
+// name the issuer and an RSA signing key of at least 2048 bits. Every
+// replica of the server must be configured with the same key.
+func NewProvider(cfg config.OIDCConfig) (*Provider, error) {
+	issuer := strings.TrimSuffix(cfg.Issuer, "/")
+	if !strings.HasPrefix(issuer, "https://") && !strings.HasPrefix(issuer, "http://") {
+		return nil, fmt.Errorf("oidc.issuer must be the server's external http(s) URL")
+	}
+
+	data := []byte(cfg.SigningKey)
+	if cfg.SigningKeyFile != "" {
+		var err error
+		if data, err = os.ReadFile(cfg.SigningKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read OIDC signing key: %w", err)
+		}
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if key.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("OIDC signing key must be at least 2048 bits")
+	}
+
+	ttl := time.Duration(cfg.TokenTTL) * time.Second
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &Provider{issuer: issuer, key: key, keyID: thumbprint(&key.PublicKey), ttl: ttl}, nil
+}
+
+func parseKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("OIDC signing key is not PEM-encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC signing key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("OIDC signing key must be RSA")
+	}
+	return key, nil
+}
+
+// Issuer returns the issuer URL, the iss of the tokens
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// Discovery returns the OpenID Provider metadata served at DiscoveryPath
+func (p *Provider) Discovery() map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":                                p.issuer,
+		"jwks_uri":                              p.issuer + JWKSPath,
+		"response_types_supported":              []string{"id_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid"},
+		"claims_supported": []string{
+			"iss", "sub", "aud", "exp", "iat", "nbf", "jti",
+			"job", "job_id", "project", "build_id", "build_number",
+			"branch", "commit_sha", "environment", "triggered_by",
+		},
+	}
+}
+
+// JWKS returns the JSON Web Key Set of the signing key, served at JWKSPath
+func (p *Provider) JWKS() map[string]interface{} {
+	return map[string]interface{}{
+		"keys": []interface{}{publicJWK(&p.key.PublicKey, p.keyID)},
+	}
+}
+
+// Claims describe the build a token is issued to
+type Claims struct {
+	Job         string `json:"job"`
+	JobID       string `json:"job_id"`
+	Project     string `json:"project,omitempty"`
+	BuildID     string `json:"build_id"`
+	BuildNumber int    `json:"build_number"`
+	Branch      string `json:"branch,omitempty"`
+	CommitSHA   string `json:"commit_sha,omitempty"`
+	// Environment is the environment the job deploys to, if any
+	Environment string `json:"environment,omitempty"`
+	TriggeredBy string `json:"triggered_by,omitempty"`
+}
+
+// Subject returns the sub of a token, which trust policies match on:
+// job:NAME:environment:ENV for a job that deploys to an environment, and
+// job:NAME:branch:BRANCH otherwise. Callers make sure no part contains ':',
+// which would let one job's subject pass for another's (see Representable).
+func (c Claims) Subject() string {
+	if c.Environment != "" {
+		return "job:" + c.Job + ":environment:" + c.Environment
+	}
+	return "job:" + c.Job + ":branch:" + c.Branch
+}
+
+// Representable reports whether the subject of the claims is unambiguous:
+// job names, environments and branches may not contain ':'
+func (c Claims) Representable() bool {
+	return !strings.Contains(c.Job, ":") && !strings.Contains(c.Environment, ":") &&
+		!strings.Contains(c.Branch, ":")
+}
+
+// DeploysFrom drops the environment from the claims of a build that may not
+// take on its identity: pull request builds, and builds of any branch but the
+// job's own. Those get the branch subject, as builds of jobs that deploy
+// nowhere do.
+func (c *Claims) DeploysFrom(defaultBranch string) {
+	if c.TriggeredBy == "pull_request" || c.Branch != defaultBranch {
+		c.Environment = ""
+	}
+}
+
+// Issue signs a token for the build with the audience, which defaults to
+// the issuer, and returns it with its expiry
+func (p *Provider) Issue(claims Claims, audience string, now time.Time) (string, time.Time, error) {
+	if audience == "" {
+		audience = p.issuer
+	}
+	expires := now.Add(p.ttl)
+
+	// The registered claims are added to the build's
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		return "", time.Time{}, err
+	}
+	payload["iss"] = p.issuer
+	payload["sub"] = claims.Subject()
+	payload["aud"] = audience
+	payload["iat"] = now.Unix()
+	payload["nbf"] = now.Add(-30 * time.Second).Unix() // allow for clock drift
+	payload["exp"] = expires.Unix()
+	payload["jti"] = uuid.New().String()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.keyID})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), expires, nil
+}
+
+// publicJWK returns the JWK of an RSA public key
+func publicJWK(key *rsa.PublicKey, keyID string) map[string]string {
+	n, e := jwkComponents(key)
+	return map[string]string{"kty": "RSA", "alg": "RS256", "use": "sig", "kid": keyID, "n": n, "e": e}
+}
+
+func jwkComponents(key *rsa.PublicKey) (string, string) {
+	return base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+}
+
+// thumbprint returns the RFC 7638 thumbprint of a key, its key ID
+func thumbprint(key *rsa.PublicKey) string {
+	n, e := jwkComponents(key)
+	sum := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,226 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// Channel prefixes of the events of one build and of the builds of one job
+const (
+	BuildChannelPrefix = "build:"
+	JobChannelPrefix   = "job:"
+)
+
+const (
+	// pollInterval is how often the dispatcher looks for new events
+	pollInterval = 500 * time.Millisecond
+	// batchSize is how many events are relayed per poll
+	batchSize = 200
+	// retention is how long relayed events are kept
+	retention = 24 * time.Hour
+	// notifyChannel is the PostgreSQL channel relayed events are announced on
+	notifyChannel = "event_outbox"
+)
+
+// Execer runs statements, as *sql.DB and *sql.Tx do
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Publisher is the event bus events are relayed to
+type Publisher interface {
+	Publish(channel, event string, data interface{})
+}
+
+// Write adds an event to the outbox. Called with the transaction of the state
+// change the event describes, the event is relayed if and only if the change
+// is committed.
+func Write(ctx context.Context, ex Execer, channel, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = ex.ExecContext(ctx, `
+		INSERT INTO event_outbox (channel, event, payload) VALUES ($1, $2, $3)
+	`, channel, event, payload)
+	return err
+}
+
+// WriteBuildEvent adds an event of a build to the outbox, for the build's
+// channel and, with jobID set, its job's
+func WriteBuildEvent(ctx context.Context, ex Execer, jobID *uuid.UUID, buildID uuid.UUID, event string, data interface{}) error {
+	if err := Write(ctx, ex, BuildChannelPrefix+buildID.String(), event, data); err != nil {
+		return err
+	}
+	if jobID == nil {
+		return nil
+	}
+	return Write(ctx, ex, JobChannelPrefix+jobID.String(), event, data)
+}
+
+// Dispatcher relays the events in the outbox to the event bus of every
+// server sharing the database, in the order they were written. One server
+// claims each batch and announces it with NOTIFY in the transaction marking
+// it relayed; every server LISTENs and publishes the announced events to its
+// own clients. Delivery is at least once: a batch is announced only if it is
+// marked relayed, so one claimed just before a crash is claimed again.
+type Dispatcher struct {
+	db          *database.Database
+	databaseURL string
+	publisher   Publisher
+}
+
+// NewDispatcher creates a dispatcher relaying events to publisher. It listens
+// for announced events on a connection of its own to databaseURL.
+func NewDispatcher(db *database.Database, databaseURL string, publisher Publisher) *Dispatcher {
+	return &Dispatcher{db: db, databaseURL: databaseURL, publisher: publisher}
+}
+
+// Start relays events until the context is cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	listener := pq.NewListener(d.databaseURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn().Err(err).Msg("Event outbox listener connection problem")
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(notifyChannel); err != nil {
+		log.Error().Err(err).Msg("Failed to listen for outbox events")
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	log.Info().Msg("Event outbox dispatcher started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Event outbox dispatcher stopped")
+			return
+		case n := <-listener.Notify:
+			if n == nil {
+				// Announcements made while the connection was down are lost
+				log.Warn().Msg("Event outbox listener reconnected; events relayed meanwhile were missed")
+				continue
+			}
+			if err := d.deliver(ctx, n.Extra); err != nil {
+				log.Error().Err(err).Msg("Failed to publish outbox events")
+			}
+		case <-ticker.C:
+			// Keep going while there is a backlog
+			for {
+				n, err := d.dispatch(ctx)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to relay outbox events")
+				}
+				if err != nil || n < batchSize {
+					break
+				}
+			}
+		case <-ping.C:
+			// Detects a dead connection, which is then re-established
+			go listener.Ping()
+		case <-prune.C:
+			d.prune(ctx)
+		}
+	}
+}
+
+// dispatch claims one batch of events, marks it relayed and announces its IDs,
+// and returns how many it claimed. Rows are locked so that several servers
+// sharing a database announce each event once.
+func (d *Dispatcher) dispatch(ctx context.Context) (int, error) {
+	var relayed int
+	err := d.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT id
+			FROM event_outbox
+			WHERE dispatched_at IS NULL
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		`, batchSize)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		ids := []string{}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE event_outbox SET dispatched_at = CURRENT_TIMESTAMP WHERE id = ANY($1)
+		`, pq.Array(ids)); err != nil {
+			return err
+		}
+		// Delivered to the listeners when the transaction commits, in order
+		_, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, strings.Join(ids, ","))
+		relayed = len(ids)
+		return err
+	})
+	return relayed, err
+}
+
+// deliver publishes the events of an announcement, a comma-separated list of
+// their IDs, to this server's clients
+func (d *Dispatcher) deliver(ctx context.Context, announced string) error {
+	ids := strings.Split(announced, ",")
+	rows, err := d.db.GetConn().QueryContext(ctx, `
+		SELECT channel, event, payload FROM event_outbox WHERE id = ANY($1) ORDER BY id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channel, event string
+		var payload json.RawMessage
+		if err := rows.Scan(&channel, &event, &payload); err != nil {
+			return err
+		}
+		d.publisher.Publish(channel, event, payload)
+	}
+	return rows.Err()
+}
+
+// prune deletes the events relayed more than the retention period ago
+func (d *Dispatcher) prune(ctx context.Context) {
+	result, err := d.db.GetConn().ExecContext(ctx, `
+		DELETE FROM event_outbox WHERE dispatched_at < $1
+	`, time.Now().Add(-retention))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to prune relayed outbox events")
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Debug().Int64("count", n).Msg("Pruned relayed outbox events")
+	}
+}
@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/images"
+)
+
+// A build config, pipeline or stage runs in an image of the build image
+// catalog by naming it with build_image in place of image:
+//
+//	build_image: golang-1.22-standard
+//	stages:
+//	  - name: lint
+//	    build_image: golangci-lint-1.59
+//	    commands: [golangci-lint run]
+//
+// The server replaces the name with the image's reference before the worker
+// agent runs the build, so that the executors pull the vetted image.
+
+// expression matches a ${{ }} expression, which a build image may be named
+// with in a job's build config
+var expression = regexp.MustCompile(`\$\{\{.*\}\}`)
+
+// ResolveImages returns the build config of a job with the build images it
+// and its stages name replaced by their references, as the image the
+// executors run them in. It returns a *ValidationError listing the problems
+// found.
+func ResolveImages(ctx context.Context, db *database.Database, cfg map[string]interface{}) (map[string]interface{}, error) {
+	return resolveConfigImages(cfg, imageLookup(ctx, db))
+}
+
+// CheckImages reports the build images a job's build config and its stages
+// name that are not in the catalog, and those that also set an image. Names
+// that are expressions, resolved as the build starts, are not checked.
+func CheckImages(ctx context.Context, db *database.Database, cfg map[string]interface{}) ([]string, error) {
+	lookup := imageLookup(ctx, db)
+	_, err := resolveConfigImages(cfg, func(name string) (string, error) {
+		if expression.MatchString(name) {
+			return name, nil
+		}
+		return lookup(name)
+	})
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return invalid.Problems, nil
+	}
+	return nil, err
+}
+
+// resolveConfigImages replaces the build images of a build config and its
+// stages with the references lookup returns for them
+func resolveConfigImages(cfg map[string]interface{}, lookup func(string) (string, error)) (map[string]interface{}, error) {
+	var problems []string
+	resolved, err := resolveImage(cfg, "build_config", lookup, &problems)
+	if err != nil {
+		return nil, err
+	}
+	if stages, ok := cfg["stages"].([]interface{}); ok {
+		all := make([]interface{}, len(stages))
+		for i, item := range stages {
+			all[i] = item
+			if stage, ok := item.(map[string]interface{}); ok {
+				if all[i], err = resolveImage(stage, fmt.Sprintf("build_config.stages[%d]", i), lookup, &problems); err != nil {
+					return nil, err
+				}
+			}
+		}
+		resolved["stages"] = all
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return resolved, nil
+}
+
+// resolveImage returns a copy of the object, a build config or a stage at
+// path, with its build_image replaced by image, adding the problems found
+func resolveImage(obj map[string]interface{}, path string, lookup func(string) (string, error), problems *[]string) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(obj))
+	for key, value := range obj {
+		out[key] = value
+	}
+	raw, ok := obj["build_image"]
+	if !ok {
+		return out, nil
+	}
+	delete(out, "build_image")
+	name, _ := raw.(string)
+	if name == "" {
+		*problems = append(*problems, path+".build_image: must name a build image")
+		return out, nil
+	}
+	if image, _ := obj["image"].(string); image != "" {
+		*problems = append(*problems, path+": sets both image and build_image")
+		return out, nil
+	}
+	ref, err := lookup(name)
+	if errors.Is(err, images.ErrNotFound) {
+		*problems = append(*problems, fmt.Sprintf("%s.build_image: unknown build image %q", path, name))
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out["image"] = ref
+	return out, nil
+}
+
+// resolveImages sets the image of the definition and its stages that name a
+// build image to the image's reference, and reports the build images that
+// are not in the catalog at the paths under field. Stages of included
+// templates are reported at the include that added them.
+func (d *Definition) resolveImages(ctx context.Context, db *database.Database, field string) ([]string, error) {
+	lookup := imageLookup(ctx, db)
+	var problems []string
+	// at is the path of the object, and named that of its build_image
+	resolve := func(name, image *string, at, named string) error {
+		if *name == "" {
+			return nil
+		}
+		if *image != "" {
+			problems = append(problems, at+": sets both image and build_image")
+			return nil
+		}
+		ref, err := lookup(*name)
+		if errors.Is(err, images.ErrNotFound) {
+			problems = append(problems, fmt.Sprintf("%s: unknown build image %q", named, *name))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		*image = ref
+		return nil
+	}
+
+	if err := resolve(&d.BuildImage, &d.Image, field, field+".build_image"); err != nil {
+		return nil, err
+	}
+	for i := range d.Stages {
+		at := fmt.Sprintf("%s.stages[%d]", field, i-d.included)
+		named := at + ".build_image"
+		if i < d.included {
+			at = fmt.Sprintf("%s.include: stage %q", field, d.Stages[i].Name)
+			named = at
+		}
+		if err := resolve(&d.Stages[i].BuildImage, &d.Stages[i].Image, at, named); err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// imageLookup returns a function that looks up the reference of a build
+// image, reading each image from the catalog once
+func imageLookup(ctx context.Context, db *database.Database) func(string) (string, error) {
+	refs := map[string]string{}
+	return func(name string) (string, error) {
+		if ref, ok := refs[name]; ok {
+			return ref, nil
+		}
+		img, err := images.Get(ctx, db, name)
+		if err != nil {
+			return "", err
+		}
+		refs[name] = img.Reference()
+		return refs[name], nil
+	}
+}
@@ -0,0 +1,353 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/solvyd/solvyd/api-server/internal/pluginconfig"
+)
+
+// Templates reads the templates of pipeline libraries
+type Templates interface {
+	// Template returns the content of a template file of a library at ref,
+	// or at the library's default ref if ref is empty, and the commit it
+	// was read at
+	Template(ctx context.Context, library, ref, file string) ([]byte, string, error)
+}
+
+// Include is a template of a pipeline library that a pipeline, or a job's
+// build config, includes:
+//
+//	include:
+//	  - library: platform
+//	    template: build-and-scan.yml
+//	    ref: v2
+//	    with:
+//	      image: golang:1.22
+//
+// The ref is a branch, tag or commit of the library's repository, and
+// defaults to the library's default ref. The stages and plugin steps of the
+// template are added ahead of those of the pipeline, which can depend on the
+// included stages. Commit records the commit the template was read at.
+type Include struct {
+	Library  string                 `json:"library"`
+	Template string                 `json:"template"`
+	Ref      string                 `json:"ref,omitempty"`
+	With     map[string]interface{} `json:"with,omitempty"`
+	Commit   string                 `json:"commit,omitempty"`
+}
+
+// A template is a YAML file of stages and plugin steps, and the inputs
+// includes set with their defaults. An input whose default is null must be
+// set by every include.
+//
+//	inputs:
+//	  image: golang:1.22
+//	  severity: ~
+//	stages:
+//	  - name: build
+//	    image: ${{ inputs.image }}
+//	    commands: [make build]
+//	  - name: scan
+//	    depends_on: [build]
+//	    commands: [make scan]
+//	plugins:
+//	  - name: trivy-scan
+//	    config:
+//	      severity: ${{ inputs.severity }}
+//
+// ${{ inputs.NAME }} is replaced by the value of the input, and a string
+// that is only the expression takes the value as it is, such as a number.
+// Stages of a template can depend on and refer to its other stages only.
+
+// includeSchema checks the include list of a pipeline or build config
+var includeSchema = map[string]interface{}{
+	"type": "array",
+	"items": map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"library", "template"},
+		"properties": map[string]interface{}{
+			"library":  map[string]interface{}{"type": "string", "minLength": 1.0},
+			"template": map[string]interface{}{"type": "string", "minLength": 1.0},
+			"ref":      map[string]interface{}{"type": "string", "minLength": 1.0},
+			"with":     map[string]interface{}{"type": "object"},
+		},
+		"additionalProperties": false,
+	},
+}
+
+// inputsSchema checks the inputs of a template
+var inputsSchema = map[string]interface{}{
+	"type":                 "object",
+	"additionalProperties": map[string]interface{}{"type": []interface{}{"string", "number", "boolean", "null"}},
+}
+
+// templateSchema checks a template once its inputs are set
+var templateSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"inputs":  inputsSchema,
+		"stages":  map[string]interface{}{"type": "array", "items": stageSchema},
+		"plugins": map[string]interface{}{"type": "array"},
+	},
+	"additionalProperties": false,
+}
+
+// inputExpression matches ${{ inputs.NAME }}
+var inputExpression = regexp.MustCompile(`\$\{\{\s*inputs\.([^{}\s]*)\s*\}\}`)
+
+// ExpandConfig adds the stages of the templates a job's build config
+// includes ahead of its own stages. It returns the build config without its
+// include list, and the plugin steps the templates add, to run ahead of the
+// job's. It returns a *ValidationError listing the problems found.
+func ExpandConfig(ctx context.Context, templates Templates, cfg map[string]interface{}) (map[string]interface{}, []interface{}, error) {
+	raw, ok := cfg["include"]
+	if !ok {
+		return cfg, nil, nil
+	}
+	if problems := pluginconfig.Validate(includeSchema, raw, "build_config.include"); len(problems) > 0 {
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	var includes []Include
+	if err := json.Unmarshal(encoded, &includes); err != nil {
+		return nil, nil, err
+	}
+
+	stages, plugins, problems := expandIncludes(ctx, templates, includes, "build_config.include")
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+	included := make(map[string]bool, len(stages))
+	for _, stage := range stages {
+		included[stage.Name] = true
+	}
+	own, _ := cfg["stages"].([]interface{})
+	for i, item := range own {
+		stage, _ := item.(map[string]interface{})
+		if name, _ := stage["name"].(string); included[name] {
+			problems = append(problems, fmt.Sprintf("build_config.stages[%d].name: duplicate stage name %q", i, name))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+
+	expanded := make(map[string]interface{}, len(cfg))
+	for key, value := range cfg {
+		if key != "include" {
+			expanded[key] = value
+		}
+	}
+	all := make([]interface{}, 0, len(stages)+len(own))
+	for _, stage := range stages {
+		all = append(all, stage.buildConfig())
+	}
+	expanded["stages"] = append(all, own...)
+	return expanded, plugins, nil
+}
+
+// addIncludes reads the templates the definition includes and adds their
+// stages and plugin steps ahead of its own, recording the commit each
+// template was read at. It reports problems at the paths under field.
+func (d *Definition) addIncludes(ctx context.Context, templates Templates, field string) []string {
+	var problems []string
+	if len(d.Include) > 0 {
+		var stages []Stage
+		var plugins []interface{}
+		stages, plugins, problems = expandIncludes(ctx, templates, d.Include, field+".include")
+		if len(problems) > 0 {
+			return problems
+		}
+		d.Stages = append(stages, d.Stages...)
+		d.included = len(stages)
+		if len(plugins) > 0 {
+			d.Plugins = append(plugins, d.Plugins...)
+		}
+	}
+	if len(d.Stages) == 0 {
+		problems = append(problems, field+".stages: the pipeline has no stages of its own or from included templates")
+	}
+	return problems
+}
+
+// expandIncludes reads the templates of the includes, recording the commit
+// each was read at, and returns their stages, each template's in dependency
+// order, and their plugin steps. It reports problems at the paths under
+// field, the path of the include list.
+func expandIncludes(ctx context.Context, templates Templates, includes []Include, field string) ([]Stage, []interface{}, []string) {
+	var stages []Stage
+	var plugins []interface{}
+	var problems []string
+	from := map[string]int{}
+	for i := range includes {
+		include := &includes[i]
+		at := fmt.Sprintf("%s[%d]", field, i)
+		if !ValidFile(include.Template) {
+			problems = append(problems, at+".template: must be a relative path inside the library")
+			continue
+		}
+		if templates == nil {
+			problems = append(problems, at+": pipeline libraries are not available")
+			continue
+		}
+
+		content, commit, err := templates.Template(ctx, include.Library, include.Ref, include.Template)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", at, err))
+			continue
+		}
+		include.Commit = commit
+
+		tmplStages, tmplPlugins, tmplProblems := instantiate(content, include.With)
+		for _, problem := range tmplProblems {
+			problems = append(problems, fmt.Sprintf("%s: %s/%s: %s", at, include.Library, include.Template, problem))
+		}
+		for _, stage := range tmplStages {
+			if j, ok := from[stage.Name]; ok {
+				problems = append(problems, fmt.Sprintf("%s: stage %q is also defined by the template of include[%d]", at, stage.Name, j))
+			}
+			from[stage.Name] = i
+		}
+		stages = append(stages, tmplStages...)
+		plugins = append(plugins, tmplPlugins...)
+	}
+	return stages, plugins, problems
+}
+
+// instantiate decodes a template and sets its inputs to the values given,
+// or their defaults. It returns the template's stages in dependency order
+// and its plugin steps, or the problems found.
+func instantiate(content []byte, with map[string]interface{}) ([]Stage, []interface{}, []string) {
+	var raw interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, nil, []string{"invalid YAML: " + err.Error()}
+	}
+	// Round-trip through JSON so values compare as the schema expects
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, []string{"invalid YAML: " + err.Error()}
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, nil, []string{"template: must be a mapping of inputs, stages and plugins"}
+	}
+	if declared, ok := doc["inputs"]; ok {
+		if problems := pluginconfig.Validate(inputsSchema, declared, "template.inputs"); len(problems) > 0 {
+			return nil, nil, problems
+		}
+	}
+
+	inputs, _ := doc["inputs"].(map[string]interface{})
+	names := make([]string, 0, len(inputs)+len(with))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	for name := range with {
+		if _, ok := inputs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var problems []string
+	values := make(map[string]interface{}, len(inputs))
+	for _, name := range names {
+		value, set := with[name]
+		def, declared := inputs[name]
+		switch {
+		case !declared:
+			problems = append(problems, fmt.Sprintf("with.%s: the template has no input %s", name, name))
+		case set:
+			values[name] = value
+		case def == nil:
+			problems = append(problems, fmt.Sprintf("with.%s: the template requires input %s", name, name))
+		default:
+			values[name] = def
+		}
+	}
+	if len(problems) > 0 {
+		return nil, nil, problems
+	}
+
+	undefined := map[string]bool{}
+	for _, key := range []string{"stages", "plugins"} {
+		if value, ok := doc[key]; ok {
+			doc[key] = setInputs(value, values, undefined)
+		}
+	}
+	for name := range undefined {
+		problems = append(problems, "template: undefined input "+name)
+	}
+	sort.Strings(problems)
+	if len(problems) > 0 {
+		return nil, nil, problems
+	}
+	if problems := pluginconfig.Validate(templateSchema, doc, "template"); len(problems) > 0 {
+		return nil, nil, problems
+	}
+
+	encoded, err = json.Marshal(doc)
+	if err != nil {
+		return nil, nil, []string{err.Error()}
+	}
+	var tmpl Definition
+	if err := json.Unmarshal(encoded, &tmpl); err != nil {
+		return nil, nil, []string{err.Error()}
+	}
+	if problems := tmpl.checkDependencies("template.stages"); len(problems) > 0 {
+		return nil, nil, problems
+	}
+	if problems := tmpl.checkConditions("template.stages"); len(problems) > 0 {
+		return nil, nil, problems
+	}
+	if problems := tmpl.checkInputs("template.stages"); len(problems) > 0 {
+		return nil, nil, problems
+	}
+	ordered, _ := tmpl.order()
+	return ordered, tmpl.Plugins, nil
+}
+
+// setInputs replaces the input expressions in the strings of a value
+// decoded from JSON, recursing into objects and lists, and collects the
+// names of inputs that do not exist
+func setInputs(value interface{}, inputs map[string]interface{}, undefined map[string]bool) interface{} {
+	switch v := value.(type) {
+	case string:
+		if m := inputExpression.FindStringSubmatch(v); m != nil && m[0] == v {
+			if input, ok := inputs[m[1]]; ok {
+				return input
+			}
+		}
+		return inputExpression.ReplaceAllStringFunc(v, func(match string) string {
+			name := inputExpression.FindStringSubmatch(match)[1]
+			input, ok := inputs[name]
+			if !ok {
+				undefined[name] = true
+				return match
+			}
+			return fmt.Sprint(input)
+		})
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = setInputs(item, inputs, undefined)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = setInputs(item, inputs, undefined)
+		}
+		return out
+	}
+	return value
+}
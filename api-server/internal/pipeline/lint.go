@@ -0,0 +1,365 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/matchers"
+	"github.com/solvyd/solvyd/api-server/internal/pluginconfig"
+	"github.com/solvyd/solvyd/api-server/internal/triggers"
+)
+
+// Kinds of documents Lint checks
+const (
+	KindPipeline = "pipeline" // a pipeline definition, such as a .solvyd.yml
+	KindJob      = "job"      // the pipeline_stages, triggers, plugins and notifications of a job
+)
+
+// Diagnostic is a problem found in a document: the path of the offending
+// field, its line and column in the document where they are known, and what
+// is wrong with it
+type Diagnostic struct {
+	Path    string `json:"path,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// jobSchema checks the parts of a job Lint is concerned with. Stages carry
+// other settings the build runs them with, which are not checked here.
+var jobSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"pipeline_stages": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"name"},
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "string", "pattern": stageNamePattern},
+					"depends_on": map[string]interface{}{
+						"type":        "array",
+						"uniqueItems": true,
+						"items":       map[string]interface{}{"type": "string"},
+					},
+					"when": map[string]interface{}{"type": "string", "minLength": 1.0},
+				},
+			},
+		},
+		"triggers":      map[string]interface{}{"type": "array"},
+		"plugins":       map[string]interface{}{"type": "array"},
+		"notifications": map[string]interface{}{"type": "array"},
+	},
+}
+
+// Lint checks a document of the kind, YAML or JSON, and returns every problem
+// found, located in the document: its structure, included templates that
+// cannot be read or are invalid, stage dependencies that are unknown or form
+// a cycle, invalid when expressions and problem matchers, unknown build
+// images, cron schedules, plugin steps whose plugin is not installed or whose
+// config does not match its schema, and secrets a step's config names that
+// the step does not define.
+// Unlike Parse it does not stop at the first kind of problem, so that editors
+// can show them all.
+func Lint(ctx context.Context, db *database.Database, templates Templates, kind string, data []byte) ([]Diagnostic, error) {
+	if kind != KindPipeline && kind != KindJob {
+		return nil, fmt.Errorf("unknown document kind %q", kind)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return yamlDiagnostics(err), nil
+	}
+	var raw interface{}
+	if err := root.Decode(&raw); err != nil {
+		return yamlDiagnostics(err), nil
+	}
+	// Round-trip through JSON so values compare as the schemas expect
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return []Diagnostic{{Message: "invalid YAML: " + err.Error()}}, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, err
+	}
+	fields, _ := doc.(map[string]interface{})
+
+	var problems []string
+	if kind == KindPipeline {
+		problems = pluginconfig.Validate(definitionSchema, doc, kind)
+		if len(problems) == 0 {
+			var def Definition
+			if err := json.Unmarshal(encoded, &def); err != nil {
+				return nil, err
+			}
+			problems = def.addIncludes(ctx, templates, kind)
+			if len(problems) == 0 {
+				problems = def.checkDependencies(kind + ".stages")
+			}
+			if len(problems) == 0 {
+				problems = def.checkConditions(kind + ".stages")
+			}
+			if len(problems) == 0 {
+				problems = def.checkInputs(kind + ".stages")
+			}
+			if len(problems) == 0 {
+				_, problems = matchers.Parse(def.ProblemMatchers, kind+"."+matchers.Field)
+			}
+			if len(problems) == 0 {
+				if problems, err = def.resolveImages(ctx, db, kind); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		problems = pluginconfig.Validate(jobSchema, doc, kind)
+		if len(problems) == 0 {
+			stages := jobStages(fields["pipeline_stages"])
+			problems = stages.checkDependencies("pipeline_stages")
+			if len(problems) == 0 {
+				problems = stages.checkConditions("pipeline_stages")
+			}
+		}
+		if list, ok := fields["triggers"].([]interface{}); ok {
+			if err := triggers.Validate(list); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+
+	stepLists := []string{"plugins"}
+	if kind == KindJob {
+		stepLists = append(stepLists, "notifications")
+	}
+	for _, field := range stepLists {
+		stepProblems, err := lintSteps(ctx, db, field, fields[field])
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, stepProblems...)
+	}
+
+	diagnostics := make([]Diagnostic, 0, len(problems))
+	for _, problem := range problems {
+		diagnostics = append(diagnostics, diagnose(&root, kind, problem))
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Line < diagnostics[j].Line })
+	return diagnostics, nil
+}
+
+// jobStages returns the stage names, dependencies and when expressions of a
+// job's pipeline_stages, which have been checked against jobSchema
+func jobStages(raw interface{}) *Definition {
+	items, _ := raw.([]interface{})
+	def := &Definition{Stages: make([]Stage, len(items))}
+	for i, item := range items {
+		stage, _ := item.(map[string]interface{})
+		def.Stages[i].Name, _ = stage["name"].(string)
+		def.Stages[i].When, _ = stage["when"].(string)
+		deps, _ := stage["depends_on"].([]interface{})
+		for _, dep := range deps {
+			name, _ := dep.(string)
+			def.Stages[i].DependsOn = append(def.Stages[i].DependsOn, name)
+		}
+	}
+	return def
+}
+
+// lintSteps checks the plugin steps of the named list: their config and
+// policies, that their plugins are installed, and that the secrets named by
+// config fields ending in _secret are defined in the step's secrets
+func lintSteps(ctx context.Context, db *database.Database, field string, raw interface{}) ([]string, error) {
+	steps, ok := raw.([]interface{})
+	if !ok || len(steps) == 0 {
+		return nil, nil
+	}
+
+	validate := pluginconfig.ValidateSteps
+	if field == "notifications" {
+		validate = pluginconfig.ValidateNotifications
+	}
+	var problems []string
+	err := validate(ctx, db, steps)
+	var invalid *pluginconfig.ValidationError
+	if errors.As(err, &invalid) {
+		problems = append(problems, invalid.Problems...)
+	} else if err != nil {
+		return nil, err
+	}
+
+	installed, err := installedPlugins(ctx, db, steps)
+	if err != nil {
+		return nil, err
+	}
+	for i, raw := range steps {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		step, isObject := raw.(map[string]interface{})
+		name, _ := raw.(string)
+		if isObject {
+			name, _ = step["name"].(string)
+			path += ".name"
+		}
+		if name != "" && !installed[name] {
+			problems = append(problems, fmt.Sprintf("%s: plugin %q is not installed", path, name))
+		}
+		if isObject {
+			problems = append(problems, undefinedSecrets(step, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+	}
+	return problems, nil
+}
+
+// undefinedSecrets reports the config fields of a step ending in _secret that
+// name a secret missing from the step's secrets, which the worker agent would
+// fail to resolve when the plugin asks for it
+func undefinedSecrets(step map[string]interface{}, path string) []string {
+	config, _ := step["config"].(map[string]interface{})
+	secrets, _ := step["secrets"].(map[string]interface{})
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		secret, _ := config[key].(string)
+		if !strings.HasSuffix(key, "_secret") || secret == "" {
+			continue
+		}
+		if _, ok := secrets[secret]; !ok {
+			problems = append(problems, fmt.Sprintf("%s.config.%s: secret %q is not defined in the step's secrets", path, key, secret))
+		}
+	}
+	return problems
+}
+
+// installedPlugins returns which of the plugins the steps name are installed
+func installedPlugins(ctx context.Context, db *database.Database, steps []interface{}) (map[string]bool, error) {
+	names := []string{}
+	for _, raw := range steps {
+		name, _ := raw.(string)
+		if step, ok := raw.(map[string]interface{}); ok {
+			name, _ = step["name"].(string)
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	installed := map[string]bool{}
+	if len(names) == 0 {
+		return installed, nil
+	}
+	rows, err := db.GetConn().QueryContext(ctx, `SELECT name FROM plugins WHERE name = ANY($1)`, pq.Array(names))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plugins: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		installed[name] = true
+	}
+	return installed, rows.Err()
+}
+
+var (
+	// stepNamePattern matches the plugin name pluginconfig puts in the paths
+	// of step config problems, as in "plugins[0] (slack-notify) config.channel"
+	stepNamePattern = regexp.MustCompile(` \([^)]*\) `)
+	pathToken       = regexp.MustCompile(`\[\d+\]|[^.\[\]]+`)
+	yamlErrorLine   = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+)
+
+// diagnose turns a "<path>: <message>" problem into a diagnostic located in
+// the document. Paths are given relative to the document root.
+func diagnose(root *yaml.Node, kind, problem string) Diagnostic {
+	path, message, ok := strings.Cut(problem, ": ")
+	if !ok {
+		return Diagnostic{Message: problem}
+	}
+	path = stepNamePattern.ReplaceAllString(path, ".")
+	if path == kind {
+		path = ""
+	}
+	path = strings.TrimPrefix(path, kind+".")
+
+	d := Diagnostic{Path: path, Message: message}
+	d.Line, d.Column = position(root, path)
+	return d
+}
+
+// position returns where the field at path is in the document: the key of a
+// mapping entry or the item of a sequence. A path that does not exist, such
+// as a missing required field, is located at the deepest part that does.
+func position(root *yaml.Node, path string) (int, int) {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line, column := node.Line, node.Column
+
+	for _, token := range pathToken.FindAllString(path, -1) {
+		var next *yaml.Node
+		switch {
+		case node.Kind == yaml.SequenceNode && strings.HasPrefix(token, "["):
+			i, _ := strconv.Atoi(strings.Trim(token, "[]"))
+			if i < len(node.Content) {
+				next = node.Content[i]
+				line, column = next.Line, next.Column
+			}
+		case node.Kind == yaml.MappingNode:
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == token {
+					next = node.Content[j+1]
+					line, column = node.Content[j].Line, node.Content[j].Column
+					break
+				}
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.Kind == yaml.AliasNode && next.Alias != nil {
+			next = next.Alias
+		}
+		node = next
+	}
+	return line, column
+}
+
+// yamlDiagnostics turns a YAML syntax or decoding error into diagnostics at
+// the lines it names
+func yamlDiagnostics(err error) []Diagnostic {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	diagnostics := make([]Diagnostic, 0, len(messages))
+	for _, message := range messages {
+		d := Diagnostic{Message: "invalid YAML: " + strings.TrimSpace(message)}
+		if m := yamlErrorLine.FindStringSubmatch(strings.TrimSpace(message)); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = "invalid YAML: " + m[2]
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
@@ -0,0 +1,417 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/matchers"
+	"github.com/solvyd/solvyd/api-server/internal/pluginconfig"
+)
+
+// DefaultFile is where a job's pipeline definition lives in its repository
+// unless the job names another file
+const DefaultFile = ".solvyd.yml"
+
+// ValidationError lists every problem found in a pipeline definition
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid pipeline definition: " + strings.Join(e.Problems, "; ")
+}
+
+// stageNamePattern is the pattern of stage names, which appear in build
+// timelines and are referenced by depends_on
+const stageNamePattern = `^[A-Za-z0-9][A-Za-z0-9._-]*$`
+
+// definitionSchema checks the structure of a pipeline definition
+var definitionSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []interface{}{"version"},
+	"properties": map[string]interface{}{
+		"version":     map[string]interface{}{"const": 1.0},
+		"image":       map[string]interface{}{"type": "string", "minLength": 1.0},
+		"build_image": map[string]interface{}{"type": "string", "minLength": 1.0},
+		"tools": map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": "string", "minLength": 1.0},
+		},
+		"stages": map[string]interface{}{
+			"type":     "array",
+			"minItems": 1.0,
+			"items":    stageSchema,
+		},
+		"include":          includeSchema,
+		"artifacts":        map[string]interface{}{"type": "string"},
+		"plugins":          map[string]interface{}{"type": "array"},
+		"problem_matchers": map[string]interface{}{"type": "array"},
+	},
+	"additionalProperties": false,
+}
+
+// stageSchema checks a stage of a pipeline definition or template
+var stageSchema = map[string]interface{}{
+	"type":     "object",
+	"required": []interface{}{"name", "commands"},
+	"properties": map[string]interface{}{
+		"name":        map[string]interface{}{"type": "string", "pattern": stageNamePattern},
+		"image":       map[string]interface{}{"type": "string", "minLength": 1.0},
+		"build_image": map[string]interface{}{"type": "string", "minLength": 1.0},
+		"commands": map[string]interface{}{
+			"type":     "array",
+			"minItems": 1.0,
+			"items":    map[string]interface{}{"type": "string", "minLength": 1.0},
+		},
+		"depends_on": map[string]interface{}{
+			"type":        "array",
+			"uniqueItems": true,
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"when": map[string]interface{}{"type": "string", "minLength": 1.0},
+		"outputs": map[string]interface{}{
+			"type":     "array",
+			"minItems": 1.0,
+			"items":    map[string]interface{}{"type": "string", "minLength": 1.0},
+		},
+		"inputs": map[string]interface{}{
+			"type":        "array",
+			"uniqueItems": true,
+			"items":       map[string]interface{}{"type": "string"},
+		},
+	},
+	"additionalProperties": false,
+}
+
+// Definition is a pipeline defined in a repository:
+//
+//	version: 1
+//	build_image: golang-1.22-standard
+//	tools:
+//	  node: "20"
+//	include:
+//	  - library: platform
+//	    template: lint.yml
+//	stages:
+//	  - name: test
+//	    depends_on: [lint]
+//	    commands: [go test ./...]
+//	  - name: build
+//	    depends_on: [test]
+//	    commands: [go build -o bin/app .]
+//	    outputs: [bin/app]
+//	  - name: publish
+//	    depends_on: [build]
+//	    inputs: [build]
+//	    when: branch == "main" && changed("cmd/**", "go.mod")
+//	    commands: [./scripts/publish.sh]
+//	artifacts: bin/*
+//	plugins:
+//	  - name: trivy-scan
+//	problem_matchers: [go]
+//
+// Once parsed, its stages and plugins include those of the templates it
+// includes (see Include), and the images of the pipeline and stages that
+// name a build image are set to its reference.
+type Definition struct {
+	Version         int               `json:"version"`
+	Image           string            `json:"image,omitempty"`
+	BuildImage      string            `json:"build_image,omitempty"`
+	Tools           map[string]string `json:"tools,omitempty"`
+	Include         []Include         `json:"include,omitempty"`
+	Stages          []Stage           `json:"stages"`
+	Artifacts       string            `json:"artifacts,omitempty"`
+	Plugins         []interface{}     `json:"plugins,omitempty"`
+	ProblemMatchers []interface{}     `json:"problem_matchers,omitempty"`
+
+	// included is how many stages ahead of its own the includes added
+	included int
+}
+
+// Stage is a set of commands run in the build workspace, if its when
+// expression, when it has one, is true as the build reaches it. The files
+// its outputs match are stored once it succeeds, and restored for the stages
+// that take it as an input when they run on another worker, such as a rerun
+// from a failed stage.
+type Stage struct {
+	Name       string   `json:"name"`
+	Image      string   `json:"image,omitempty"`
+	BuildImage string   `json:"build_image,omitempty"`
+	Commands   []string `json:"commands"`
+	DependsOn  []string `json:"depends_on,omitempty"`
+	When       string   `json:"when,omitempty"`
+	Outputs    []string `json:"outputs,omitempty"`
+	Inputs     []string `json:"inputs,omitempty"`
+}
+
+// ValidFile reports whether name is a path inside a repository, so that the
+// agent reading it stays in the build workspace
+func ValidFile(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, `\`) {
+		return false
+	}
+	clean := path.Clean(name)
+	return clean == name && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// Parse decodes and validates a pipeline definition, adding the stages and
+// plugin steps of the templates it includes: its structure, the templates,
+// the stage dependencies, which must name other stages and not form a cycle,
+// the when expressions and the inputs and outputs of stages, the problem
+// matchers, the build images it names, and the config of its plugin steps
+// against the installed plugins. It returns a *ValidationError listing every
+// problem found.
+func Parse(ctx context.Context, db *database.Database, templates Templates, data []byte) (*Definition, error) {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, &ValidationError{Problems: []string{"invalid YAML: " + err.Error()}}
+	}
+	// Round-trip through JSON so values compare as the schema expects
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, &ValidationError{Problems: []string{"invalid YAML: " + err.Error()}}
+	}
+	var doc interface{}
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, err
+	}
+
+	if problems := pluginconfig.Validate(definitionSchema, doc, "pipeline"); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	var def Definition
+	if err := json.Unmarshal(encoded, &def); err != nil {
+		return nil, err
+	}
+	if problems := def.addIncludes(ctx, templates, "pipeline"); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	if problems := def.checkDependencies("pipeline.stages"); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	if problems := def.checkConditions("pipeline.stages"); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	if problems := def.checkInputs("pipeline.stages"); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	if _, problems := matchers.Parse(def.ProblemMatchers, "pipeline."+matchers.Field); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	problems, err := def.resolveImages(ctx, db, "pipeline")
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	if def.Plugins != nil {
+		err := pluginconfig.ValidateSteps(ctx, db, def.Plugins)
+		var invalid *pluginconfig.ValidationError
+		if errors.As(err, &invalid) {
+			return nil, &ValidationError{Problems: invalid.Problems}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &def, nil
+}
+
+// checkDependencies reports duplicate stage names, dependencies on unknown
+// stages and dependency cycles, at the path of the stages list. Included
+// stages, checked with their templates, are not reported.
+func (d *Definition) checkDependencies(field string) []string {
+	var problems []string
+	index := make(map[string]int, len(d.Stages))
+	for i, stage := range d.Stages {
+		if _, ok := index[stage.Name]; ok {
+			problems = append(problems, fmt.Sprintf("%s[%d].name: duplicate stage name %q", field, i-d.included, stage.Name))
+			continue
+		}
+		index[stage.Name] = i
+	}
+	for i, stage := range d.Stages[d.included:] {
+		for _, dep := range stage.DependsOn {
+			if _, ok := index[dep]; !ok {
+				problems = append(problems, fmt.Sprintf("%s[%d].depends_on: unknown stage %q", field, i, dep))
+			} else if dep == stage.Name {
+				problems = append(problems, fmt.Sprintf("%s[%d].depends_on: stage depends on itself", field, i))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	if _, cycle := d.order(); cycle != nil {
+		problems = append(problems, field+": dependency cycle "+strings.Join(cycle, " -> "))
+	}
+	return problems
+}
+
+// checkConditions reports when expressions that are invalid or refer to a
+// stage that does not run before theirs, at the path of the stages list,
+// other than those of included stages. The dependencies must have been
+// checked.
+func (d *Definition) checkConditions(field string) []string {
+	var problems []string
+	ordered, _ := d.order()
+	position := make(map[string]int, len(ordered))
+	for i, stage := range ordered {
+		position[stage.Name] = i
+	}
+	for i, stage := range d.Stages[d.included:] {
+		if stage.When == "" {
+			continue
+		}
+		refs, err := whenStages(stage.When)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s[%d].when: %v", field, i, err))
+			continue
+		}
+		for _, ref := range refs {
+			if at, ok := position[ref]; !ok {
+				problems = append(problems, fmt.Sprintf("%s[%d].when: unknown stage %q", field, i, ref))
+			} else if at >= position[stage.Name] {
+				problems = append(problems, fmt.Sprintf("%s[%d].when: stage %q does not run before this one; add it to depends_on", field, i, ref))
+			}
+		}
+	}
+	return problems
+}
+
+// checkInputs reports outputs that are not paths in the workspace, and
+// inputs that are not stages with outputs that run before theirs, at the
+// path of the stages list, other than those of included stages. The
+// dependencies must have been checked.
+func (d *Definition) checkInputs(field string) []string {
+	var problems []string
+	ordered, _ := d.order()
+	position := make(map[string]int, len(ordered))
+	for i, stage := range ordered {
+		position[stage.Name] = i
+	}
+	byName := make(map[string]Stage, len(d.Stages))
+	for _, stage := range d.Stages {
+		byName[stage.Name] = stage
+	}
+	for i, stage := range d.Stages[d.included:] {
+		for j, output := range stage.Outputs {
+			if !ValidFile(output) {
+				problems = append(problems, fmt.Sprintf("%s[%d].outputs[%d]: must be a path or pattern in the workspace, such as bin/app or dist/*", field, i, j))
+			}
+		}
+		for _, input := range stage.Inputs {
+			if at, ok := position[input]; !ok {
+				problems = append(problems, fmt.Sprintf("%s[%d].inputs: unknown stage %q", field, i, input))
+			} else if at >= position[stage.Name] {
+				problems = append(problems, fmt.Sprintf("%s[%d].inputs: stage %q does not run before this one; add it to depends_on", field, i, input))
+			} else if len(byName[input].Outputs) == 0 {
+				problems = append(problems, fmt.Sprintf("%s[%d].inputs: stage %q declares no outputs", field, i, input))
+			}
+		}
+	}
+	return problems
+}
+
+// order returns the stages in an order that runs each after the stages it
+// depends on, keeping the declared order where dependencies allow. If the
+// dependencies form a cycle it returns the stage names along it instead.
+func (d *Definition) order() ([]Stage, []string) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	byName := make(map[string]Stage, len(d.Stages))
+	for _, stage := range d.Stages {
+		byName[stage.Name] = stage
+	}
+
+	state := make(map[string]int, len(d.Stages))
+	ordered := make([]Stage, 0, len(d.Stages))
+	var stack []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					return append(append([]string{}, stack[i:]...), name)
+				}
+			}
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range byName[name].DependsOn {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+	for _, stage := range d.Stages {
+		if cycle := visit(stage.Name); cycle != nil {
+			return nil, cycle
+		}
+	}
+	return ordered, nil
+}
+
+// BuildConfig returns the build config the worker agent runs the pipeline
+// with: its stages, in dependency order, and the image, tools and artifacts
+func (d *Definition) BuildConfig() map[string]interface{} {
+	ordered, _ := d.order()
+	stages := make([]interface{}, len(ordered))
+	for i, stage := range ordered {
+		stages[i] = stage.buildConfig()
+	}
+
+	cfg := map[string]interface{}{"stages": stages}
+	if d.Image != "" {
+		cfg["image"] = d.Image
+	}
+	if len(d.Tools) > 0 {
+		cfg["tools"] = d.Tools
+	}
+	if d.Artifacts != "" {
+		cfg["artifacts"] = d.Artifacts
+	}
+	return cfg
+}
+
+// buildConfig returns the stage as the worker agent runs it. A build image
+// that has not been resolved, as in a job's build config, is resolved as the
+// build starts.
+func (s Stage) buildConfig() map[string]interface{} {
+	cfg := map[string]interface{}{"name": s.Name, "commands": s.Commands}
+	if s.Image != "" {
+		cfg["image"] = s.Image
+	} else if s.BuildImage != "" {
+		cfg["build_image"] = s.BuildImage
+	}
+	if s.When != "" {
+		cfg["when"] = s.When
+	}
+	if len(s.Outputs) > 0 {
+		cfg["outputs"] = s.Outputs
+	}
+	if len(s.Inputs) > 0 {
+		cfg["inputs"] = s.Inputs
+	}
+	return cfg
+}
@@ -0,0 +1,224 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A stage's when expression is evaluated by the worker agent as the build
+// reaches the stage, and the stage is skipped if it is false:
+//
+//	branch == "main" && changed("src/**", "go.mod")
+//	params.deploy || stages.test == "failed"
+//
+// Operands are string literals, branch, status (the build's status so far),
+// params.NAME and stages.NAME (how an earlier stage ended), compared with ==
+// and != and combined with &&, || and ! and parentheses. changed("glob", ...)
+// tests the files changed since the previous successful build. The grammar
+// is:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" or ")" | changed | operand [ ("==" | "!=") operand ]
+//	changed = "changed" "(" string { "," string } ")"
+//	operand = string | "branch" | "status" | "params." NAME | "stages." NAME
+//
+// The API server only checks expressions, and the stages they refer to.
+
+// ValidWhen checks a stage's when expression
+func ValidWhen(expr string) error {
+	_, err := whenStages(expr)
+	return err
+}
+
+// whenStages checks a when expression and returns the stages it refers to
+func whenStages(expr string) ([]string, error) {
+	tokens, err := tokenizeWhen(expr)
+	if err != nil {
+		return nil, err
+	}
+	c := &whenChecker{tokens: tokens}
+	if err := c.or(); err != nil {
+		return nil, err
+	}
+	if c.pos < len(c.tokens) {
+		return nil, fmt.Errorf("unexpected %s", describeToken(c.tokens[c.pos]))
+	}
+	return c.stages, nil
+}
+
+// whenChecker walks the tokens of an expression by its grammar
+type whenChecker struct {
+	tokens []string
+	pos    int
+	stages []string
+}
+
+func (c *whenChecker) peek() string {
+	if c.pos < len(c.tokens) {
+		return c.tokens[c.pos]
+	}
+	return ""
+}
+
+func (c *whenChecker) next() string {
+	t := c.peek()
+	c.pos++
+	return t
+}
+
+func (c *whenChecker) expect(want string) error {
+	if got := c.next(); got != want {
+		return fmt.Errorf("expected %s, got %s", want, describeToken(got))
+	}
+	return nil
+}
+
+func (c *whenChecker) or() error {
+	if err := c.and(); err != nil {
+		return err
+	}
+	for c.peek() == "||" {
+		c.next()
+		if err := c.and(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *whenChecker) and() error {
+	if err := c.unary(); err != nil {
+		return err
+	}
+	for c.peek() == "&&" {
+		c.next()
+		if err := c.unary(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *whenChecker) unary() error {
+	switch c.peek() {
+	case "!":
+		c.next()
+		return c.unary()
+	case "(":
+		c.next()
+		if err := c.or(); err != nil {
+			return err
+		}
+		return c.expect(")")
+	case "changed":
+		c.next()
+		if err := c.expect("("); err != nil {
+			return err
+		}
+		for {
+			if t := c.next(); !strings.HasPrefix(t, `"`) {
+				return fmt.Errorf("changed takes glob strings, got %s", describeToken(t))
+			}
+			if c.peek() != "," {
+				break
+			}
+			c.next()
+		}
+		return c.expect(")")
+	}
+
+	if err := c.operand(); err != nil {
+		return err
+	}
+	if op := c.peek(); op == "==" || op == "!=" {
+		c.next()
+		return c.operand()
+	}
+	return nil
+}
+
+func (c *whenChecker) operand() error {
+	t := c.next()
+	if strings.HasPrefix(t, `"`) {
+		return nil
+	}
+	scope, name, dotted := strings.Cut(t, ".")
+	switch {
+	case (scope == "branch" || scope == "status") && !dotted:
+	case scope == "params" && dotted && name != "":
+	case scope == "stages" && dotted && name != "":
+		c.stages = append(c.stages, name)
+	default:
+		return fmt.Errorf("expected a string, branch, status, params.NAME or stages.NAME, got %s", describeToken(t))
+	}
+	return nil
+}
+
+// tokenizeWhen splits an expression into operators, parentheses, commas,
+// words and string literals, which keep their opening quote
+func tokenizeWhen(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+		case ch == '(' || ch == ')' || ch == ',':
+			tokens = append(tokens, string(ch))
+			i++
+		case strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") ||
+			strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case ch == '!':
+			tokens = append(tokens, "!")
+			i++
+		case ch == '"' || ch == '\'':
+			var b strings.Builder
+			b.WriteByte('"')
+			j := i + 1
+			for ; j < len(expr) && expr[j] != ch; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) {
+					j++
+				}
+				b.WriteByte(expr[j])
+			}
+			if j == len(expr) {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			tokens = append(tokens, b.String())
+			i = j + 1
+		case isWordChar(ch):
+			j := i
+			for j < len(expr) && isWordChar(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", ch, i+1)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return tokens, nil
+}
+
+// isWordChar reports whether ch can be part of a reference, which includes
+// the characters of stage and parameter names
+func isWordChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+		ch == '_' || ch == '-' || ch == '.'
+}
+
+func describeToken(t string) string {
+	switch {
+	case t == "":
+		return "the end"
+	case strings.HasPrefix(t, `"`):
+		return fmt.Sprintf("string %q", t[1:])
+	}
+	return t
+}
@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWhenStages(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    []string
+		wantErr string
+	}{
+		{expr: `branch == "main"`},
+		{expr: `params.deploy || status == 'failed'`},
+		{expr: `stages.test == "failed"`, want: []string{"test"}},
+		{expr: `!(stages.build == "success" && stages.lint != "skipped")`, want: []string{"build", "lint"}},
+		{expr: `changed("src/**", "go.mod") && branch == "main"`},
+		{expr: `"stages.test" == branch`},
+		{expr: ``, wantErr: "empty expression"},
+		{expr: `branch ==`, wantErr: "got the end"},
+		{expr: `branch = "main"`, wantErr: `unexpected '='`},
+		{expr: `branch == "main`, wantErr: "unterminated string"},
+		{expr: `(branch == "main"`, wantErr: "expected ), got the end"},
+		{expr: `branch == "main")`, wantErr: "unexpected )"},
+		{expr: `commit == "abc"`, wantErr: "got commit"},
+		{expr: `stages. == "x"`, wantErr: "got stages."},
+		{expr: `status.code == "0"`, wantErr: "got status.code"},
+		{expr: `changed()`, wantErr: "changed takes glob strings, got )"},
+		{expr: `changed(branch)`, wantErr: "changed takes glob strings, got branch"},
+	}
+	for _, tt := range tests {
+		got, err := whenStages(tt.expr)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("whenStages(%s) error = %v, want %q", tt.expr, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("whenStages(%s): %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("whenStages(%s) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCheckConditions(t *testing.T) {
+	d := &Definition{Stages: []Stage{
+		{Name: "build"},
+		{Name: "lint", When: `stages.test == "failed"`},
+		{Name: "test", DependsOn: []string{"build"}, When: `stages.build == "success"`},
+		{Name: "deploy", DependsOn: []string{"test"}, When: `stages.test == "success" && stages.release == ""`},
+		{Name: "notify", When: `branch ==`},
+	}}
+	want := []string{
+		`stages[1].when: stage "test" does not run before this one; add it to depends_on`,
+		`stages[3].when: unknown stage "release"`,
+		`stages[4].when: expected a string, branch, status, params.NAME or stages.NAME, got the end`,
+	}
+	if got := d.checkConditions("stages"); !reflect.DeepEqual(got, want) {
+		t.Errorf("checkConditions =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,297 @@
+package pluginconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks a value against a JSON Schema and returns one message per
+// problem, each prefixed with the path of the offending field. It implements
+// the keywords plugin config schemas use: type, enum, const, properties,
+// required, additionalProperties, items, minItems, maxItems, uniqueItems,
+// minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, multipleOf, allOf, anyOf, oneOf and not. Other keywords
+// (format, $ref, ...) are ignored. Values must be decoded from JSON.
+func Validate(schema map[string]interface{}, value interface{}, path string) []string {
+	v := &validator{}
+	v.check(schema, value, path)
+	return v.problems
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) check(schema map[string]interface{}, value interface{}, path string) {
+	if len(schema) == 0 {
+		return
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		v.addf(path, "must be %s, got %s", describeType(t), jsonType(value))
+		return // the remaining keywords would only repeat the problem
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		v.addf(path, "must be one of %s", formatValues(enum))
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		v.addf(path, "must be %s", formatValue(c))
+	}
+
+	switch val := value.(type) {
+	case string:
+		v.checkString(schema, val, path)
+	case float64:
+		v.checkNumber(schema, val, path)
+	case map[string]interface{}:
+		v.checkObject(schema, val, path)
+	case []interface{}:
+		v.checkArray(schema, val, path)
+	}
+
+	for _, sub := range subschemas(schema["allOf"]) {
+		v.check(sub, value, path)
+	}
+	if anyOf := subschemas(schema["anyOf"]); len(anyOf) > 0 && countMatches(anyOf, value, path) == 0 {
+		v.addf(path, "must match at least one of the %d allowed forms", len(anyOf))
+	}
+	if oneOf := subschemas(schema["oneOf"]); len(oneOf) > 0 {
+		if n := countMatches(oneOf, value, path); n != 1 {
+			v.addf(path, "must match exactly one of the %d allowed forms, matched %d", len(oneOf), n)
+		}
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok && len(Validate(not, value, path)) == 0 {
+		v.addf(path, "matches a disallowed form")
+	}
+}
+
+func (v *validator) checkString(schema map[string]interface{}, s, path string) {
+	length := utf8.RuneCountInString(s)
+	if n, ok := number(schema["minLength"]); ok && float64(length) < n {
+		v.addf(path, "must be at least %v characters", n)
+	}
+	if n, ok := number(schema["maxLength"]); ok && float64(length) > n {
+		v.addf(path, "must be at most %v characters", n)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.addf(path, "schema pattern %q is invalid: %v", pattern, err)
+		} else if !re.MatchString(s) {
+			v.addf(path, "must match pattern %s", pattern)
+		}
+	}
+}
+
+func (v *validator) checkNumber(schema map[string]interface{}, n float64, path string) {
+	if min, ok := number(schema["minimum"]); ok && n < min {
+		v.addf(path, "must be >= %v", min)
+	}
+	if max, ok := number(schema["maximum"]); ok && n > max {
+		v.addf(path, "must be <= %v", max)
+	}
+	if min, ok := number(schema["exclusiveMinimum"]); ok && n <= min {
+		v.addf(path, "must be > %v", min)
+	}
+	if max, ok := number(schema["exclusiveMaximum"]); ok && n >= max {
+		v.addf(path, "must be < %v", max)
+	}
+	if m, ok := number(schema["multipleOf"]); ok && m > 0 {
+		if q := n / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.addf(path, "must be a multiple of %v", m)
+		}
+	}
+}
+
+func (v *validator) checkObject(schema map[string]interface{}, obj map[string]interface{}, path string) {
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					v.addf(path+"."+name, "is required")
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if sub, ok := properties[k].(map[string]interface{}); ok {
+			v.check(sub, obj[k], path+"."+k)
+			continue
+		}
+		if _, declared := properties[k]; declared {
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				v.addf(path+"."+k, "is not a known field (expected one of %s)", strings.Join(sortedKeys(properties), ", "))
+			}
+		case map[string]interface{}:
+			v.check(extra, obj[k], path+"."+k)
+		}
+	}
+}
+
+func (v *validator) checkArray(schema map[string]interface{}, items []interface{}, path string) {
+	if n, ok := number(schema["minItems"]); ok && float64(len(items)) < n {
+		v.addf(path, "must have at least %v items", n)
+	}
+	if n, ok := number(schema["maxItems"]); ok && float64(len(items)) > n {
+		v.addf(path, "must have at most %v items", n)
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range items {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(items[i], items[j]) {
+					v.addf(fmt.Sprintf("%s[%d]", path, i), "duplicates item %d", j)
+				}
+			}
+		}
+	}
+	if sub, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range items {
+			v.check(sub, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func countMatches(schemas []map[string]interface{}, value interface{}, path string) int {
+	n := 0
+	for _, s := range schemas {
+		if len(Validate(s, value, path)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func subschemas(raw interface{}) []map[string]interface{} {
+	list, _ := raw.([]interface{})
+	schemas := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(map[string]interface{}); ok {
+			schemas = append(schemas, s)
+		}
+	}
+	return schemas
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// matchesType checks a "type" keyword, which is a type name or a list of them
+func matchesType(t interface{}, value interface{}) bool {
+	actual := jsonType(value)
+	for _, name := range typeNames(t) {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeNames(t interface{}) []string {
+	switch val := t.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		names := []string{}
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func describeType(t interface{}) string {
+	names := typeNames(t)
+	for i, name := range names {
+		if name != "" && strings.ContainsRune("aeiou", rune(name[0])) {
+			names[i] = "an " + name
+		} else {
+			names[i] = "a " + name
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func number(raw interface{}) (float64, bool) {
+	n, ok := raw.(float64)
+	return n, ok
+}
+
+func formatValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func formatValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = formatValue(value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,210 @@
+package pluginconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+)
+
+// ValidationError lists every problem found in a job's plugin configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid plugin configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// durationSchema matches a Go duration string such as "90s" or "1h30m", or a number of seconds
+var durationSchema = map[string]interface{}{
+	"type":    []interface{}{"string", "number"},
+	"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+	"minimum": 0.0,
+}
+
+// stepPolicySchema checks the timeout, retry policy, secret references,
+// findings threshold and caches of a step, which the worker agent enforces
+var stepPolicySchema = map[string]interface{}{
+	"properties": map[string]interface{}{
+		"timeout": durationSchema,
+		"retry": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"count":       map[string]interface{}{"type": "integer", "minimum": 0.0, "maximum": 10.0},
+				"backoff":     durationSchema,
+				"max_backoff": durationSchema,
+				"retry_on": map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"enum": []interface{}{"failure", "error", "timeout"}},
+				},
+			},
+			"additionalProperties": false,
+		},
+		// Secret names mapped to "<provider>:<reference>"; providers are
+		// registered with the worker agent, so they are not checked here
+		"secrets": map[string]interface{}{
+			"type": "object",
+			"additionalProperties": map[string]interface{}{
+				"type":    "string",
+				"pattern": `^[A-Za-z0-9_.-]+:.+$`,
+			},
+		},
+		"fail_on_findings": map[string]interface{}{
+			"enum": []interface{}{"info", "low", "medium", "high", "critical"},
+		},
+		// Workspace directories kept between builds under a key
+		"caches": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"key", "path"},
+				"properties": map[string]interface{}{
+					"key":  map[string]interface{}{"type": "string", "minLength": 1.0},
+					"path": map[string]interface{}{"type": "string", "minLength": 1.0, "pattern": `^[^/]`},
+				},
+				"additionalProperties": false,
+			},
+		},
+	},
+}
+
+// notificationRuleSchema checks the rules of a notification step, which the
+// API server evaluates when the build completes
+var notificationRuleSchema = map[string]interface{}{
+	"properties": map[string]interface{}{
+		"on": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"enum": []interface{}{"failure", "first_failure", "recovery", "unstable", "success", "always"},
+			},
+		},
+		"branches": map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string", "minLength": 1.0},
+		},
+		"environments": map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string", "minLength": 1.0},
+		},
+	},
+}
+
+// ValidateSteps checks the config of each plugin step in a job's plugins list
+// against the config_schema of the installed plugin. Steps are plugin names or
+// objects with "name" and "config", and optionally "timeout", "retry",
+// "secrets", "fail_on_findings" and "caches", which are checked here as well.
+// Plugins that are not installed, or declare an empty schema, are not checked.
+// It returns a *ValidationError if any step is invalid.
+func ValidateSteps(ctx context.Context, db *database.Database, plugins interface{}) error {
+	return validateSteps(ctx, db, "plugins", plugins, stepPolicySchema)
+}
+
+// ValidateNotifications checks a job's notifications list like ValidateSteps,
+// and the rules of each notification: the build outcomes, branches and
+// environments it fires for.
+func ValidateNotifications(ctx context.Context, db *database.Database, notifications interface{}) error {
+	return validateSteps(ctx, db, "notifications", notifications, stepPolicySchema, notificationRuleSchema)
+}
+
+// ValidateAlertNotifications checks the notification steps the instance
+// settings run for operational alerts like ValidateSteps
+func ValidateAlertNotifications(ctx context.Context, db *database.Database, notifications interface{}) error {
+	return validateSteps(ctx, db, "alert_notifications", notifications, stepPolicySchema)
+}
+
+// validateSteps checks the steps of the named list against the schemas and the
+// config schemas of their plugins
+func validateSteps(ctx context.Context, db *database.Database, field string, plugins interface{}, stepSchemas ...map[string]interface{}) error {
+	// Round-trip through JSON so values from YAML and JSON compare alike
+	data, err := json.Marshal(plugins)
+	if err != nil {
+		return err
+	}
+	var steps []interface{}
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return &ValidationError{Problems: []string{field + " must be a list"}}
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	type step struct {
+		name   string
+		config interface{}
+	}
+	parsed := make([]step, len(steps))
+	names := []string{}
+	var problems []string
+	for i, raw := range steps {
+		switch s := raw.(type) {
+		case string:
+			parsed[i] = step{name: s, config: map[string]interface{}{}}
+		case map[string]interface{}:
+			for _, schema := range stepSchemas {
+				problems = append(problems, Validate(schema, s, fmt.Sprintf("%s[%d]", field, i))...)
+			}
+			parsed[i].name, _ = s["name"].(string)
+			parsed[i].config = s["config"]
+			if parsed[i].config == nil {
+				parsed[i].config = map[string]interface{}{}
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s[%d]: must be a plugin name or an object", field, i))
+			continue
+		}
+		if parsed[i].name == "" {
+			problems = append(problems, fmt.Sprintf("%s[%d]: name is required", field, i))
+			continue
+		}
+		names = append(names, parsed[i].name)
+	}
+
+	schemas, err := loadSchemas(ctx, db, names)
+	if err != nil {
+		return err
+	}
+
+	for i, s := range parsed {
+		schema := schemas[s.name]
+		if s.name == "" || len(schema) == 0 {
+			continue
+		}
+		problems = append(problems, Validate(schema, s.config, fmt.Sprintf("%s[%d] (%s) config", field, i, s.name))...)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// loadSchemas returns the config schemas of the named installed plugins
+func loadSchemas(ctx context.Context, db *database.Database, names []string) (map[string]models.JSONB, error) {
+	schemas := map[string]models.JSONB{}
+	if len(names) == 0 {
+		return schemas, nil
+	}
+
+	rows, err := db.GetConn().QueryContext(ctx,
+		`SELECT name, config_schema FROM plugins WHERE name = ANY($1)`, pq.Array(names))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plugin schemas: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var schema models.JSONB
+		if err := rows.Scan(&name, &schema); err != nil {
+			return nil, err
+		}
+		schemas[name] = schema
+	}
+	return schemas, rows.Err()
+}
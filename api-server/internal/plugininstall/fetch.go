@@ -0,0 +1,249 @@
+package plugininstall
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/api-server/internal/config"
+)
+
+// MaxPluginBytes limits the size of a downloaded plugin binary
+const MaxPluginBytes = 512 << 20
+
+// maxSignatureBytes limits signatures and cosign payloads
+const maxSignatureBytes = 1 << 20
+
+// ErrVerificationFailed is returned when a plugin's checksum or signature does not check out
+var ErrVerificationFailed = errors.New("plugin verification failed")
+
+// Request describes a plugin hosted outside the registry
+type Request struct {
+	Source       string // https:// URL or oci:// reference
+	SHA256       string // expected checksum; required for URL sources
+	Signature    string // base64 cosign signature of the binary (URL sources)
+	SignatureURL string // where to fetch the signature instead (URL sources)
+}
+
+// Download is a verified plugin binary spooled to a temporary file
+type Download struct {
+	File   *os.File
+	Size   int64
+	SHA256 string // hex, without a "sha256:" prefix
+	Signed bool   // a signature was verified against a trusted key
+	Tag    string // OCI tag the binary was fetched from, if any
+	Repo   string // last path element of the OCI repository, if any
+}
+
+// Close removes the temporary file
+func (d *Download) Close() error {
+	d.File.Close()
+	return os.Remove(d.File.Name())
+}
+
+// Fetcher downloads plugin binaries from HTTPS URLs and OCI registries and
+// verifies their checksum and cosign signature
+type Fetcher struct {
+	httpClient       *http.Client
+	keys             []trustedKey
+	requireSignature bool
+}
+
+// NewFetcher creates a fetcher that trusts the configured public keys
+func NewFetcher(cfg config.PluginVerification) (*Fetcher, error) {
+	keys, err := loadTrustedKeys(cfg.TrustedKeys)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RequireSignature && len(keys) == 0 {
+		return nil, fmt.Errorf("plugin signatures are required but no trusted keys are configured")
+	}
+
+	return &Fetcher{
+		httpClient:       &http.Client{Timeout: 10 * time.Minute},
+		keys:             keys,
+		requireSignature: cfg.RequireSignature,
+	}, nil
+}
+
+// IsExternal reports whether source is a URL or OCI reference rather than a registry reference
+func IsExternal(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") ||
+		strings.HasPrefix(source, "oci://")
+}
+
+// Fetch downloads and verifies a plugin. The caller must Close the download.
+func (f *Fetcher) Fetch(ctx context.Context, req Request) (*Download, error) {
+	expected := strings.ToLower(strings.TrimPrefix(req.SHA256, "sha256:"))
+
+	var d *Download
+	var err error
+	switch {
+	case strings.HasPrefix(req.Source, "https://"):
+		if expected == "" {
+			return nil, fmt.Errorf("sha256 is required for URL sources")
+		}
+		d, err = f.fetchURL(ctx, req)
+	case strings.HasPrefix(req.Source, "oci://"):
+		d, err = f.fetchOCI(ctx, strings.TrimPrefix(req.Source, "oci://"))
+	case strings.HasPrefix(req.Source, "http://"):
+		return nil, fmt.Errorf("plugins must be downloaded over HTTPS")
+	default:
+		return nil, fmt.Errorf("unsupported plugin source %q", req.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if expected != "" && d.SHA256 != expected {
+		d.Close()
+		return nil, fmt.Errorf("%w: checksum is %s, expected %s", ErrVerificationFailed, d.SHA256, expected)
+	}
+	if f.requireSignature && !d.Signed {
+		d.Close()
+		return nil, fmt.Errorf("%w: %s is not signed by a trusted key", ErrVerificationFailed, req.Source)
+	}
+	return d, nil
+}
+
+// fetchURL downloads a binary over HTTPS and verifies its cosign blob signature, if any
+func (f *Fetcher) fetchURL(ctx context.Context, req Request) (*Download, error) {
+	resp, err := f.get(ctx, req.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	d, err := spool(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := req.Signature
+	if signature == "" && req.SignatureURL != "" {
+		if !strings.HasPrefix(req.SignatureURL, "https://") {
+			d.Close()
+			return nil, fmt.Errorf("signatures must be downloaded over HTTPS")
+		}
+		data, err := f.getSmall(ctx, req.SignatureURL, nil)
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("failed to fetch signature: %w", err)
+		}
+		signature = string(data)
+	}
+	if signature == "" {
+		return d, nil
+	}
+	if err := f.VerifyBlob(d, signature); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// VerifyBlob checks a base64 cosign sign-blob signature of a spooled binary
+// against the trusted keys and marks the download signed
+func (f *Fetcher) VerifyBlob(d *Download, signature string) error {
+	// cosign sign-blob writes the signature base64-encoded
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return fmt.Errorf("%w: signature is not valid base64", ErrVerificationFailed)
+	}
+	digest, _ := hex.DecodeString(d.SHA256)
+	readBlob := func() ([]byte, error) {
+		if _, err := d.File.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return io.ReadAll(d.File)
+	}
+	if err := f.verify(digest, readBlob, sig); err != nil {
+		return err
+	}
+	d.Signed = true
+	return nil
+}
+
+// RequiresSignature reports whether plugins must be signed by a trusted key
+// to be installed
+func (f *Fetcher) RequiresSignature() bool {
+	return f.requireSignature
+}
+
+// get performs a GET and fails on any status other than 200
+func (f *Fetcher) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// getSmall fetches a small document such as a signature or manifest
+func (f *Fetcher) getSmall(ctx context.Context, url string, header http.Header) ([]byte, error) {
+	resp, err := f.get(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readLimited(resp.Body, maxSignatureBytes)
+}
+
+// spool copies a binary to a temporary file, hashing it on the way
+func spool(r io.Reader) (*Download, error) {
+	file, err := os.CreateTemp("", "solvyd-plugin-*")
+	if err != nil {
+		return nil, err
+	}
+	d := &Download{File: file}
+
+	hash := sha256.New()
+	d.Size, err = io.Copy(io.MultiWriter(file, hash), io.LimitReader(r, MaxPluginBytes+1))
+	if err == nil && d.Size > MaxPluginBytes {
+		err = fmt.Errorf("plugin binary exceeds %d bytes", MaxPluginBytes)
+	}
+	if err == nil && d.Size == 0 {
+		err = fmt.Errorf("plugin binary is empty")
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	d.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return d, nil
+}
+
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response exceeds %d bytes", limit)
+	}
+	return data, nil
+}
@@ -0,0 +1,319 @@
+package plugininstall
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// cosignSignatureAnnotation holds the base64 signature on a cosign signature layer
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// titleAnnotation names the file stored in a layer, as pushed by oras
+	titleAnnotation = "org.opencontainers.image.title"
+)
+
+// errRegistryNotFound is returned for manifests and blobs the registry does not have
+var errRegistryNotFound = errors.New("not found in registry")
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// simpleSigningPayload is the document cosign signs for an image
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// ociRepository talks to one repository of an OCI distribution registry
+type ociRepository struct {
+	f     *Fetcher
+	host  string
+	name  string
+	token string // bearer token obtained after the first 401
+}
+
+// fetchOCI downloads a plugin pushed as a single-layer OCI artifact (e.g. with
+// oras push) from registry/repository:tag or registry/repository@sha256:...,
+// and verifies a cosign signature stored alongside it
+func (f *Fetcher) fetchOCI(ctx context.Context, ref string) (*Download, error) {
+	slash := strings.Index(ref, "/")
+	if slash <= 0 {
+		return nil, fmt.Errorf("OCI reference %q must include a registry host", ref)
+	}
+	repo := &ociRepository{f: f, host: ref[:slash]}
+	name, reference := ref[slash+1:], "latest"
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference = name[:i], name[i+1:]
+	}
+	repo.name = name
+
+	manifest, manifestDigest, err := repo.manifest(ctx, reference)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(reference, "sha256:") && reference != manifestDigest {
+		return nil, fmt.Errorf("%w: manifest digest is %s, expected %s", ErrVerificationFailed, manifestDigest, reference)
+	}
+
+	layer, err := pluginLayer(manifest, path.Base(name))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := repo.do(ctx, "blobs/"+layer.Digest, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	d, err := spool(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	d.Repo = path.Base(name)
+	if !strings.HasPrefix(reference, "sha256:") {
+		d.Tag = reference
+	}
+
+	// Content addressing makes the layer digest the checksum to trust
+	if "sha256:"+d.SHA256 != layer.Digest {
+		d.Close()
+		return nil, fmt.Errorf("%w: blob digest is sha256:%s, expected %s", ErrVerificationFailed, d.SHA256, layer.Digest)
+	}
+
+	if len(f.keys) > 0 {
+		if d.Signed, err = repo.verifyCosign(ctx, manifestDigest); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// pluginLayer picks the layer holding the plugin binary
+func pluginLayer(m *ociManifest, name string) (*ociDescriptor, error) {
+	if len(m.Layers) == 1 {
+		return &m.Layers[0], nil
+	}
+	for i, layer := range m.Layers {
+		if title := layer.Annotations[titleAnnotation]; title == name || title == name+".wasm" {
+			return &m.Layers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("manifest has %d layers and none is titled %q", len(m.Layers), name)
+}
+
+// manifest fetches a manifest and returns it with its digest
+func (r *ociRepository) manifest(ctx context.Context, reference string) (*ociManifest, string, error) {
+	resp, err := r.do(ctx, "manifests/"+reference, mediaTypeOCIManifest+", "+mediaTypeDockerManifest)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := readLimited(resp.Body, maxSignatureBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	var m ociManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, "", fmt.Errorf("invalid OCI manifest: %w", err)
+	}
+	if m.MediaType != "" && m.MediaType != mediaTypeOCIManifest && m.MediaType != mediaTypeDockerManifest {
+		return nil, "", fmt.Errorf("unsupported manifest type %s; reference a single-platform artifact", m.MediaType)
+	}
+
+	sum := sha256.Sum256(data)
+	return &m, "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// verifyCosign reports whether a cosign signature on the manifest verifies
+// against a trusted key. Signatures by other keys are ignored.
+func (r *ociRepository) verifyCosign(ctx context.Context, manifestDigest string) (bool, error) {
+	tag := strings.Replace(manifestDigest, ":", "-", 1) + ".sig"
+	sigManifest, _, err := r.manifest(ctx, tag)
+	if err != nil {
+		if errors.Is(err, errRegistryNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to fetch cosign signature: %w", err)
+	}
+
+	for _, layer := range sigManifest.Layers {
+		encoded := layer.Annotations[cosignSignatureAnnotation]
+		if encoded == "" {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+
+		payload, err := r.blob(ctx, layer.Digest)
+		if err != nil {
+			return false, err
+		}
+		sum := sha256.Sum256(payload)
+		if "sha256:"+hex.EncodeToString(sum[:]) != layer.Digest {
+			continue
+		}
+		if r.f.verify(sum[:], func() ([]byte, error) { return payload, nil }, sig) != nil {
+			continue
+		}
+
+		// The signature is genuine; make sure it is for this manifest
+		var signed simpleSigningPayload
+		if err := json.Unmarshal(payload, &signed); err != nil {
+			continue
+		}
+		if signed.Critical.Image.DockerManifestDigest == manifestDigest {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// blob fetches a small blob such as a signature payload
+func (r *ociRepository) blob(ctx context.Context, digest string) ([]byte, error) {
+	resp, err := r.do(ctx, "blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readLimited(resp.Body, maxSignatureBytes)
+}
+
+// do issues a registry API request, authenticating with an anonymous bearer
+// token when the registry asks for one
+func (r *ociRepository) do(ctx context.Context, resource, accept string) (*http.Response, error) {
+	endpoint := fmt.Sprintf("https://%s/v2/%s/%s", r.host, r.name, resource)
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		}
+
+		resp, err := r.f.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := r.authenticate(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", errRegistryNotFound, endpoint)
+		}
+		return nil, fmt.Errorf("GET %s returned %s", endpoint, resp.Status)
+	}
+}
+
+// authenticate requests a pull token from the realm in a Bearer challenge
+func (r *ociRepository) authenticate(ctx context.Context, challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("registry %s requires unsupported authentication %q", r.host, challenge)
+	}
+	params := parseChallenge(strings.TrimPrefix(challenge, "Bearer "))
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme != "https" {
+		return fmt.Errorf("registry %s returned an invalid token realm", r.host)
+	}
+
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + r.name + ":pull"
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	data, err := r.f.getSmall(ctx, realm.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to obtain registry token: %w", err)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return fmt.Errorf("invalid registry token response: %w", err)
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	if r.token == "" {
+		return fmt.Errorf("registry %s returned an empty token", r.host)
+	}
+	return nil
+}
+
+// parseChallenge splits key="value" pairs of a WWW-Authenticate challenge
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	for s != "" {
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		params[key] = value
+		s = strings.TrimLeft(s, ", ")
+	}
+	return params
+}
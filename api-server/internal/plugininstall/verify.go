@@ -0,0 +1,91 @@
+package plugininstall
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// trustedKey is a cosign public key plugins may be signed with
+type trustedKey struct {
+	source string // file path, or "inline key N"
+	key    crypto.PublicKey
+}
+
+// loadTrustedKeys parses PEM public keys, each given inline or as a file path
+func loadTrustedKeys(entries []string) ([]trustedKey, error) {
+	var keys []trustedKey
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		source := entry
+		data := []byte(entry)
+		if strings.HasPrefix(entry, "-----BEGIN") {
+			source = fmt.Sprintf("inline key %d", i+1)
+		} else {
+			var err error
+			if data, err = os.ReadFile(entry); err != nil {
+				return nil, fmt.Errorf("failed to read trusted key: %w", err)
+			}
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("%s is not a PEM public key", source)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", source, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("%s: unsupported key type %T", source, key)
+		}
+		keys = append(keys, trustedKey{source: source, key: key})
+	}
+	return keys, nil
+}
+
+// verify checks a cosign signature against the trusted keys. ECDSA and RSA
+// signatures cover the SHA-256 digest of the message; Ed25519 signs the message
+// itself, which is only read when such a key is configured.
+func (f *Fetcher) verify(digest []byte, readMessage func() ([]byte, error), sig []byte) error {
+	if len(f.keys) == 0 {
+		return fmt.Errorf("%w: the plugin is signed but no trusted keys are configured", ErrVerificationFailed)
+	}
+
+	var message []byte
+	for _, k := range f.keys {
+		switch key := k.key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest, sig) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig) == nil {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if message == nil {
+				var err error
+				if message, err = readMessage(); err != nil {
+					return err
+				}
+			}
+			if ed25519.Verify(key, message, sig) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: signature does not match any trusted key", ErrVerificationFailed)
+}
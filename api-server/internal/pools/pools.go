@@ -0,0 +1,258 @@
+package pools
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+)
+
+// DefaultPool is the name workers and jobs without a pool are reported under
+const DefaultPool = "default"
+
+// ErrNotFound is returned for a pool that does not exist
+var ErrNotFound = errors.New("pool not found")
+
+// namePattern is the pattern of pool names
+var namePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// ValidName reports whether name can name a pool
+func ValidName(name string) bool {
+	return len(name) <= 100 && name != DefaultPool && namePattern.MatchString(name)
+}
+
+// AutoscalingPolicy tells an autoscaler how many workers a pool should have
+// for its load
+type AutoscalingPolicy struct {
+	MinWorkers int `json:"min_workers"`
+	MaxWorkers int `json:"max_workers"`
+	// Queued and running builds a worker is expected to take; defaults to 1
+	BuildsPerWorker int `json:"builds_per_worker,omitempty"`
+}
+
+// Validate checks the values of the policy
+func (p AutoscalingPolicy) Validate() []string {
+	var problems []string
+	if p.MinWorkers < 0 {
+		problems = append(problems, "autoscaling.min_workers: must not be negative")
+	}
+	if p.MaxWorkers < 1 {
+		problems = append(problems, "autoscaling.max_workers: must be at least 1")
+	}
+	if p.MaxWorkers < p.MinWorkers {
+		problems = append(problems, "autoscaling.max_workers: must not be less than min_workers")
+	}
+	if p.BuildsPerWorker < 0 {
+		problems = append(problems, "autoscaling.builds_per_worker: must not be negative")
+	}
+	return problems
+}
+
+// DesiredWorkers returns how many workers the pool should have for its
+// queued and running builds, within the policy's bounds
+func (p AutoscalingPolicy) DesiredWorkers(queued, running int) int {
+	perWorker := p.BuildsPerWorker
+	if perWorker < 1 {
+		perWorker = 1
+	}
+	desired := (queued + running + perWorker - 1) / perWorker
+	if desired < p.MinWorkers {
+		desired = p.MinWorkers
+	}
+	if desired > p.MaxWorkers {
+		desired = p.MaxWorkers
+	}
+	return desired
+}
+
+// Pool is a named group of workers with its capacity and load
+type Pool struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Autoscaling *AutoscalingPolicy `json:"autoscaling,omitempty"`
+	// Registered workers, and those online
+	Workers       int `json:"workers"`
+	OnlineWorkers int `json:"online_workers"`
+	// Build slots of the online workers
+	Capacity      int `json:"capacity"`
+	RunningBuilds int `json:"running_builds"`
+	// Builds queued for the pool's workers, its queue depth
+	QueuedBuilds int `json:"queued_builds"`
+	// How many workers the autoscaling policy asks for
+	DesiredWorkers *int       `json:"desired_workers,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// List returns the default pool and the named pools, by name, with their
+// capacity and load
+func List(ctx context.Context, db *database.Database) ([]Pool, error) {
+	rows, err := db.GetConn().QueryContext(ctx, `
+		SELECT name, description, autoscaling, created_at, updated_at
+		FROM worker_pools
+		ORDER BY name ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pools := []Pool{{Name: DefaultPool, Description: "Workers registered without a pool"}}
+	for rows.Next() {
+		p, err := scanPool(rows)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := addLoad(ctx, db, pools); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// Get returns a pool, or the default pool, with its capacity and load
+func Get(ctx context.Context, db *database.Database, name string) (Pool, error) {
+	p := Pool{Name: DefaultPool, Description: "Workers registered without a pool"}
+	if name != DefaultPool {
+		var err error
+		p, err = scanPool(db.GetConn().QueryRowContext(ctx, `
+			SELECT name, description, autoscaling, created_at, updated_at
+			FROM worker_pools
+			WHERE name = $1
+		`, name))
+		if err == sql.ErrNoRows {
+			return Pool{}, ErrNotFound
+		}
+		if err != nil {
+			return Pool{}, err
+		}
+	}
+
+	pools := []Pool{p}
+	if err := addLoad(ctx, db, pools); err != nil {
+		return Pool{}, err
+	}
+	return pools[0], nil
+}
+
+// Exists reports whether a named pool exists
+func Exists(ctx context.Context, db *database.Database, name string) (bool, error) {
+	var exists bool
+	err := db.GetConn().QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM worker_pools WHERE name = $1)`, name).Scan(&exists)
+	return exists, err
+}
+
+// Ensure creates a pool that does not exist yet, as when a worker registers
+// into it
+func Ensure(ctx context.Context, db *database.Database, name string) error {
+	_, err := db.GetConn().ExecContext(ctx,
+		`INSERT INTO worker_pools (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, name)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPool(row scanner) (Pool, error) {
+	var p Pool
+	var autoscaling []byte
+	var createdAt, updatedAt time.Time
+	if err := row.Scan(&p.Name, &p.Description, &autoscaling, &createdAt, &updatedAt); err != nil {
+		return Pool{}, err
+	}
+	if autoscaling != nil {
+		p.Autoscaling = &AutoscalingPolicy{}
+		if err := json.Unmarshal(autoscaling, p.Autoscaling); err != nil {
+			return Pool{}, fmt.Errorf("invalid autoscaling policy of pool %s: %w", p.Name, err)
+		}
+	}
+	p.CreatedAt, p.UpdatedAt = &createdAt, &updatedAt
+	return p, nil
+}
+
+// addLoad fills in the workers, capacity and builds of the pools, and the
+// workers their autoscaling policies ask for
+func addLoad(ctx context.Context, db *database.Database, pools []Pool) error {
+	byName := make(map[string]*Pool, len(pools))
+	for i := range pools {
+		byName[pools[i].Name] = &pools[i]
+	}
+
+	rows, err := db.GetConn().QueryContext(ctx, `
+		SELECT COALESCE(pool, $1), COUNT(*),
+		       COUNT(*) FILTER (WHERE status = 'online'),
+		       COALESCE(SUM(max_concurrent_builds) FILTER (WHERE status = 'online'), 0)
+		FROM workers
+		GROUP BY 1
+	`, DefaultPool)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var workers, online, capacity int
+		if err := rows.Scan(&name, &workers, &online, &capacity); err != nil {
+			rows.Close()
+			return err
+		}
+		if p, ok := byName[name]; ok {
+			p.Workers, p.OnlineWorkers, p.Capacity = workers, online, capacity
+		}
+	}
+	rows.Close()
+
+	// Running builds count against the pool of their worker, queued builds
+	// against the pool of their job
+	rows, err = db.GetConn().QueryContext(ctx, `
+		SELECT COALESCE(w.pool, $1), COUNT(*), 0
+		FROM builds b
+		JOIN workers w ON w.id = b.worker_id
+		WHERE b.status = 'running'
+		GROUP BY 1
+		UNION ALL
+		SELECT COALESCE(j.pool, $1), 0, COUNT(*)
+		FROM builds b
+		JOIN jobs j ON j.id = b.job_id
+		WHERE b.status = 'queued'
+		GROUP BY 1
+	`, DefaultPool)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var running, queued int
+		if err := rows.Scan(&name, &running, &queued); err != nil {
+			return err
+		}
+		if p, ok := byName[name]; ok {
+			p.RunningBuilds += running
+			p.QueuedBuilds += queued
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range pools {
+		if policy := pools[i].Autoscaling; policy != nil {
+			desired := policy.DesiredWorkers(pools[i].QueuedBuilds, pools[i].RunningBuilds)
+			pools[i].DesiredWorkers = &desired
+		}
+	}
+	return nil
+}
@@ -3,29 +3,83 @@ package scheduler
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/solvyd/solvyd/api-server/internal/credentials"
 	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/interpolate"
 	"github.com/solvyd/solvyd/api-server/internal/metrics"
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+	"github.com/solvyd/solvyd/api-server/internal/pipeline"
+	"github.com/solvyd/solvyd/api-server/internal/pluginconfig"
+	"github.com/solvyd/solvyd/api-server/internal/pools"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
+	"github.com/solvyd/solvyd/api-server/internal/tracing"
 	"github.com/solvyd/solvyd/api-server/internal/worker"
 )
 
+// Why the scheduler left a queued build in the queue. Builds record the
+// reason of the last tick they were considered in; a paused scheduler or job
+// queue does not consider them, and each tick considers the oldest
+// queuedPerTick builds of each pool only.
+const (
+	SkipNoWorkers       = "no_workers"       // no online worker in the job's pool
+	SkipWorkersBusy     = "workers_busy"     // the pool's online workers are running all the builds they can
+	SkipDiskFull        = "disk_full"        // the pool's workers with free slots are at their disk quota
+	SkipSchedulerPaused = "scheduler_paused" // the scheduler is paused
+	SkipQueuePaused     = "queue_paused"     // the job's queue is paused
+	SkipWaiting         = "waiting"          // not considered yet, behind older queued builds of its pool
+)
+
+// queuedPerTick is how many of the oldest queued builds of each pool a tick
+// considers, so that a pool without free workers never holds up the others
+const queuedPerTick = 10
+
+// SkipReason returns why a queued build is still queued, from the reason the
+// last tick that considered it recorded and whether the scheduler and the
+// job's queue are paused, which take precedence. It reports whether the
+// reason is the recorded one.
+func SkipReason(recorded string, schedulerPaused, queuePaused bool) (string, bool) {
+	switch {
+	case schedulerPaused:
+		return SkipSchedulerPaused, false
+	case queuePaused:
+		return SkipQueuePaused, false
+	case recorded == "":
+		return SkipWaiting, false
+	}
+	return recorded, true
+}
+
 // Scheduler handles job scheduling and build assignment
 type Scheduler struct {
 	db        *database.Database
 	workerMgr *worker.Manager
 	metrics   *metrics.Collector
+	creds     *credentials.Store
+	templates pipeline.Templates
+	settings  *settings.Store
 }
 
-// NewScheduler creates a new scheduler
-func NewScheduler(db *database.Database, workerMgr *worker.Manager, m *metrics.Collector) *Scheduler {
+// NewScheduler creates a new scheduler, which dispatches no builds while the
+// instance settings have it paused, and expands the templates build configs
+// include from templates as it dispatches them
+func NewScheduler(db *database.Database, workerMgr *worker.Manager, m *metrics.Collector, creds *credentials.Store, templates pipeline.Templates, settings *settings.Store) *Scheduler {
 	return &Scheduler{
 		db:        db,
 		workerMgr: workerMgr,
 		metrics:   m,
+		creds:     creds,
+		templates: templates,
+		settings:  settings,
 	}
 }
 
@@ -42,59 +96,168 @@ func (s *Scheduler) Start(ctx context.Context) {
 			log.Info().Msg("Scheduler stopped")
 			return
 		case <-ticker.C:
+			s.reenableJobs(ctx)
 			s.schedulePendingBuilds(ctx)
+			s.updateQueueMetrics(ctx)
 		}
 	}
 }
 
-// schedulePendingBuilds assigns queued builds to available workers
+// reenableJobs enables the disabled jobs whose re-enable time has come
+func (s *Scheduler) reenableJobs(ctx context.Context) {
+	rows, err := s.db.GetConn().QueryContext(ctx, `
+		UPDATE jobs
+		SET enabled = true, disabled_reason = NULL, disabled_by = NULL,
+		    disabled_at = NULL, reenable_at = NULL
+		WHERE reenable_at <= CURRENT_TIMESTAMP
+		RETURNING id, name
+	`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to re-enable jobs")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jobID uuid.UUID
+		var name string
+		if err := rows.Scan(&jobID, &name); err != nil {
+			continue
+		}
+		log.Info().Str("job_id", jobID.String()).Str("job_name", name).Msg("Job re-enabled")
+	}
+}
+
+// schedulePendingBuilds assigns queued builds to available workers, unless
+// the scheduler is paused. Builds of jobs whose queue is paused are left
+// queued.
 func (s *Scheduler) schedulePendingBuilds(ctx context.Context) {
-	// Get queued builds
+	if s.settings.Current().SchedulerPaused {
+		return
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "scheduler.tick")
+	defer span.End()
+
+	// Get the oldest queued builds of each pool
 	query := `
-		SELECT id, job_id
-		FROM builds
-		WHERE status = 'queued'
+		SELECT id, job_id, trace_context, pool, preferred
+		FROM (
+			SELECT b.id, b.job_id, COALESCE(b.trace_context, '{}') AS trace_context, j.pool,
+			       o.worker_id AS preferred, b.queued_at,
+			       ROW_NUMBER() OVER (PARTITION BY j.pool ORDER BY b.queued_at ASC) AS position
+			FROM builds b
+			JOIN jobs j ON j.id = b.job_id
+			LEFT JOIN builds o ON o.id = b.rerun_of AND b.rerun_stages IS NOT NULL
+			WHERE b.status = 'queued'
+			  AND j.queue_paused_at IS NULL
+		) q
+		WHERE position <= $1
 		ORDER BY queued_at ASC
-		LIMIT 10
 	`
 
-	rows, err := s.db.GetConn().QueryContext(ctx, query)
+	rows, err := s.db.GetConn().QueryContext(ctx, query, queuedPerTick)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query queued builds")
 		return
 	}
 	defer rows.Close()
 
+	type queuedBuild struct {
+		buildID, jobID uuid.UUID
+		trace          map[string]string
+		pool           sql.NullString
+		// The worker that ran the build a rerun restarts from its failed
+		// stage, which may keep its workspace
+		preferred uuid.NullUUID
+	}
+	queued := []queuedBuild{}
 	for rows.Next() {
-		var buildID, jobID uuid.UUID
-		if err := rows.Scan(&buildID, &jobID); err != nil {
+		var b queuedBuild
+		var traceContext []byte
+		if err := rows.Scan(&b.buildID, &b.jobID, &traceContext, &b.pool, &b.preferred); err != nil {
 			continue
 		}
+		json.Unmarshal(traceContext, &b.trace)
+		queued = append(queued, b)
+	}
+	rows.Close()
+	span.SetAttributes(attribute.Int("builds.queued", len(queued)))
+
+	for _, b := range queued {
+		// The assignment belongs to the trace the build was queued in, and
+		// is linked to the tick that made it
+		buildCtx, buildSpan := tracing.Tracer().Start(tracing.FromCarrier(ctx, b.trace), "scheduler.assign",
+			trace.WithLinks(trace.LinkFromContext(ctx)),
+			trace.WithAttributes(attribute.String("build.id", b.buildID.String()), attribute.String("job.id", b.jobID.String())))
 
 		// Try to assign to a worker
-		if err := s.assignBuildToWorker(ctx, buildID, jobID); err != nil {
-			log.Debug().Err(err).Str("build_id", buildID.String()).Msg("Could not assign build to worker")
+		err := s.assignBuildToWorker(buildCtx, b.buildID, b.jobID, b.pool, b.preferred)
+		if err != nil {
+			log.Debug().Err(err).Str("build_id", b.buildID.String()).Msg("Could not assign build to worker")
 		}
+		tracing.End(buildSpan, err)
 	}
 }
 
-// assignBuildToWorker finds an available worker and assigns the build
-func (s *Scheduler) assignBuildToWorker(ctx context.Context, buildID, jobID uuid.UUID) error {
-	// Find available worker
+// updateQueueMetrics records how many builds are queued and running, overall
+// and per worker pool
+func (s *Scheduler) updateQueueMetrics(ctx context.Context) {
+	var queued, running int
+	err := s.db.GetConn().QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE status = 'queued'),
+		       COUNT(*) FILTER (WHERE status = 'running')
+		FROM builds
+		WHERE status IN ('queued', 'running')
+	`).Scan(&queued, &running)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count queued and running builds")
+		return
+	}
+	s.metrics.RecordBuildQueue(queued, running)
+
+	all, err := pools.List(ctx, s.db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query worker pools")
+		return
+	}
+	for _, p := range all {
+		s.metrics.RecordPool(p.Name, p.Capacity, p.QueuedBuilds, p.RunningBuilds, p.DesiredWorkers)
+	}
+}
+
+// assignBuildToWorker finds an available worker of the job's pool, or of the
+// default pool if the job has none, and assigns the build. The preferred
+// worker, if set and available, is chosen over the others.
+func (s *Scheduler) assignBuildToWorker(ctx context.Context, buildID, jobID uuid.UUID, pool sql.NullString, preferred uuid.NullUUID) error {
+	// Plugin schemas may have changed since the job was saved
+	if ok, err := s.checkPluginConfig(ctx, buildID, jobID); err != nil || !ok {
+		return err
+	}
+	// Secrets and variables may have been removed since the job was saved,
+	// and the templates it includes changed
+	if ok, err := s.checkExpressions(ctx, buildID); err != nil || !ok {
+		return err
+	}
+
+	// Find an available worker, skipping those at their disk quota
 	query := `
 		SELECT id
 		FROM workers
 		WHERE status = 'online'
 		  AND current_builds < max_concurrent_builds
-		ORDER BY current_builds ASC
+		  AND pool IS NOT DISTINCT FROM $1
+		  AND NOT COALESCE((resources->>'disk_full')::boolean, false)
+		ORDER BY COALESCE(id = $2, false) DESC, current_builds ASC
 		LIMIT 1
 		FOR UPDATE SKIP LOCKED
 	`
 
 	var workerID uuid.UUID
-	err := s.db.GetConn().QueryRowContext(ctx, query).Scan(&workerID)
+	err := s.db.GetConn().QueryRowContext(ctx, query, pool, preferred).Scan(&workerID)
 	if err == sql.ErrNoRows {
-		return nil // No workers available, will retry next tick
+		// No workers available, will retry next tick
+		return s.recordSkip(ctx, buildID, pool)
 	}
 	if err != nil {
 		return err
@@ -103,10 +266,32 @@ func (s *Scheduler) assignBuildToWorker(ctx context.Context, buildID, jobID uuid
 	// Assign build to worker
 	updateBuild := `
 		UPDATE builds
-		SET status = 'running', worker_id = $1, started_at = CURRENT_TIMESTAMP
+		SET status = 'running', worker_id = $1, started_at = CURRENT_TIMESTAMP,
+		    schedule_skip_reason = NULL, schedule_skipped_at = NULL
 		WHERE id = $2 AND status = 'queued'
+		RETURNING started_at
+	`
+	recordAssigned := `
+		INSERT INTO build_events (build_id, type, occurred_at, metadata)
+		VALUES ($1, $2, CURRENT_TIMESTAMP, jsonb_build_object('worker_id', $3::text))
 	`
-	if _, err := s.db.GetConn().ExecContext(ctx, updateBuild, workerID, buildID); err != nil {
+	err = s.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var startedAt time.Time
+		if err := tx.QueryRowContext(ctx, updateBuild, workerID, buildID).Scan(&startedAt); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, recordAssigned, buildID, models.BuildEventAssigned, workerID.String()); err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, buildID, "build.status", map[string]interface{}{
+			"build_id": buildID, "job_id": jobID, "status": "running",
+			"worker_id": workerID, "started_at": startedAt,
+		})
+	})
+	if err == sql.ErrNoRows {
+		return nil // Cancelled or assigned elsewhere in the meantime
+	}
+	if err != nil {
 		return err
 	}
 
@@ -129,3 +314,123 @@ func (s *Scheduler) assignBuildToWorker(ctx context.Context, buildID, jobID uuid
 
 	return nil
 }
+
+// recordSkip records on a queued build why no worker of its pool could take
+// it
+func (s *Scheduler) recordSkip(ctx context.Context, buildID uuid.UUID, pool sql.NullString) error {
+	var online, free int
+	err := s.db.GetConn().QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE status = 'online'),
+		       COUNT(*) FILTER (WHERE status = 'online' AND current_builds < max_concurrent_builds)
+		FROM workers
+		WHERE pool IS NOT DISTINCT FROM $1
+	`, pool).Scan(&online, &free)
+	if err != nil {
+		return err
+	}
+	reason := SkipDiskFull
+	switch {
+	case online == 0:
+		reason = SkipNoWorkers
+	case free == 0:
+		reason = SkipWorkersBusy
+	}
+
+	_, err = s.db.GetConn().ExecContext(ctx, `
+		UPDATE builds
+		SET schedule_skip_reason = $2, schedule_skipped_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'queued'
+	`, buildID, reason)
+	return err
+}
+
+// checkPluginConfig validates the job's plugin steps and notifications before
+// dispatch and fails the build if they no longer match the installed plugins'
+// schemas
+func (s *Scheduler) checkPluginConfig(ctx context.Context, buildID, jobID uuid.UUID) (bool, error) {
+	var plugins, notifications models.JSONArray
+	err := s.db.GetConn().QueryRowContext(ctx,
+		`SELECT plugins, notifications FROM jobs WHERE id = $1`, jobID).Scan(&plugins, &notifications)
+	if err != nil {
+		return false, err
+	}
+
+	err = pluginconfig.ValidateSteps(ctx, s.db, plugins)
+	if err == nil {
+		err = pluginconfig.ValidateNotifications(ctx, s.db, notifications)
+	}
+	var invalid *pluginconfig.ValidationError
+	if !errors.As(err, &invalid) {
+		return err == nil, err
+	}
+
+	if err := s.failQueuedBuild(ctx, buildID, invalid.Error()); err != nil {
+		return false, err
+	}
+
+	log.Warn().
+		Str("build_id", buildID.String()).
+		Strs("problems", invalid.Problems).
+		Msg("Build failed: invalid plugin configuration")
+	return false, nil
+}
+
+// checkExpressions resolves the expressions in the job's build config and
+// environment variables, and the templates and build images the build config
+// names, before dispatch and fails the build if any reference is undefined or
+// an include or build image is invalid
+func (s *Scheduler) checkExpressions(ctx context.Context, buildID uuid.UUID) (bool, error) {
+	_, err := interpolate.ResolveBuild(ctx, s.db, s.creds, s.templates, buildID)
+	var invalid *pipeline.ValidationError
+	if errors.As(err, &invalid) {
+		if err := s.failQueuedBuild(ctx, buildID, invalid.Error()); err != nil {
+			return false, err
+		}
+		log.Warn().
+			Str("build_id", buildID.String()).
+			Strs("problems", invalid.Problems).
+			Msg("Build failed: invalid pipeline includes or build images")
+		return false, nil
+	}
+	var undefined *interpolate.UndefinedError
+	if !errors.As(err, &undefined) {
+		return err == nil, err
+	}
+
+	if err := s.failQueuedBuild(ctx, buildID, undefined.Error()); err != nil {
+		return false, err
+	}
+
+	log.Warn().
+		Str("build_id", buildID.String()).
+		Strs("references", undefined.References).
+		Msg("Build failed: undefined references in job configuration")
+	return false, nil
+}
+
+// failQueuedBuild fails a build that cannot be dispatched, telling the
+// build's subscribers in the same transaction
+func (s *Scheduler) failQueuedBuild(ctx context.Context, buildID uuid.UUID, message string) error {
+	failBuild := `
+		UPDATE builds
+		SET status = 'failed', error_message = $2, completed_at = CURRENT_TIMESTAMP,
+		    schedule_skip_reason = NULL, schedule_skipped_at = NULL
+		WHERE id = $1 AND status = 'queued'
+		RETURNING job_id, completed_at
+	`
+	err := s.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var jobID uuid.UUID
+		var completedAt time.Time
+		if err := tx.QueryRowContext(ctx, failBuild, buildID, message).Scan(&jobID, &completedAt); err != nil {
+			return err
+		}
+		return outbox.WriteBuildEvent(ctx, tx, &jobID, buildID, "build.status", map[string]interface{}{
+			"build_id": buildID, "job_id": jobID, "status": "failed",
+			"error_message": message, "completed_at": completedAt,
+		})
+	})
+	if err == sql.ErrNoRows {
+		return nil // Cancelled or assigned elsewhere in the meantime
+	}
+	return err
+}
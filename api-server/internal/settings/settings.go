@@ -0,0 +1,349 @@
+package settings
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/database"
+	"github.com/solvyd/solvyd/api-server/internal/notifications"
+	"github.com/solvyd/solvyd/api-server/internal/pluginconfig"
+)
+
+// reloadInterval is how often settings changed through another server are
+// picked up
+const reloadInterval = 30 * time.Second
+
+// Settings are the instance-wide defaults administrators change at runtime
+type Settings struct {
+	// Timeout of jobs created without one
+	DefaultTimeoutMinutes int `json:"default_timeout_minutes"`
+	// Days build logs are kept; 0 keeps them forever
+	BuildLogRetentionDays int `json:"build_log_retention_days"`
+	// Prefixes of the URLs and OCI references plugins may be installed from;
+	// empty allows any
+	AllowedPluginSources []string `json:"allowed_plugin_sources"`
+	// Events notifications without an "on" list fire for
+	NotificationEvents []string `json:"notification_events"`
+	// Queued builds are not dispatched while the scheduler is paused
+	SchedulerPaused bool `json:"scheduler_paused"`
+	// Writes to the API are rejected with 503 while in maintenance mode
+	MaintenanceMode bool `json:"maintenance_mode"`
+	// Shown to clients whose writes are rejected in maintenance mode
+	MaintenanceMessage string `json:"maintenance_message"`
+	// Hours a worker stays offline before it is removed and its builds
+	// requeued; 0 keeps offline workers
+	StaleWorkerTTLHours int `json:"stale_worker_ttl_hours"`
+	// Stored artifacts whose checksum is verified every hour, least recently
+	// verified first; 0 turns the integrity audit off
+	ArtifactAuditBatchSize int `json:"artifact_audit_batch_size"`
+	// Days a cache entry is kept after it was last read or written; 0 keeps
+	// entries forever
+	CacheEntryTTLDays int `json:"cache_entry_ttl_days"`
+	// Alert when a worker goes offline
+	AlertWorkerOffline bool `json:"alert_worker_offline"`
+	// Alert when a pool's oldest queued build has waited this long; 0 turns
+	// the alert off
+	AlertQueueWaitMinutes int `json:"alert_queue_wait_minutes"`
+	// Alert when a pool has had queued builds and no worker free to take
+	// them for this long; 0 turns the alert off
+	AlertNoCapacityMinutes int `json:"alert_no_capacity_minutes"`
+	// Notification plugin steps run for each alert fired and resolved
+	AlertNotifications []interface{} `json:"alert_notifications"`
+	// Successful builds of a job whose durations a new build is compared
+	// against; 0 turns duration regression detection off
+	DurationRegressionBuilds int `json:"duration_regression_builds"`
+	// How many scaled median absolute deviations above the median duration
+	// a build must take to be flagged; lower flags smaller slowdowns
+	DurationRegressionSensitivity float64 `json:"duration_regression_sensitivity"`
+	// Successful builds flagged as slower raise the unstable notification
+	// event, as builds with failed tests do
+	DurationRegressionUnstable bool `json:"duration_regression_unstable"`
+}
+
+// Defaults returns the settings of a server nobody has changed, with the
+// retention configured in its config file
+func Defaults(buildLogRetentionDays int) Settings {
+	return Settings{
+		DefaultTimeoutMinutes:  60,
+		BuildLogRetentionDays:  buildLogRetentionDays,
+		AllowedPluginSources:   []string{},
+		NotificationEvents:     notifications.DefaultEvents,
+		StaleWorkerTTLHours:    24,
+		ArtifactAuditBatchSize: 500,
+		CacheEntryTTLDays:      7,
+		AlertWorkerOffline:     true,
+		AlertQueueWaitMinutes:  30,
+		AlertNoCapacityMinutes: 10,
+		AlertNotifications:     []interface{}{},
+
+		DurationRegressionBuilds:      20,
+		DurationRegressionSensitivity: 3,
+	}
+}
+
+// ValidationError lists every problem found in a settings change
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid settings: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the values of the settings
+func (s Settings) Validate() []string {
+	var problems []string
+	if s.DefaultTimeoutMinutes < 1 {
+		problems = append(problems, "default_timeout_minutes: must be at least 1")
+	}
+	if s.BuildLogRetentionDays < 0 {
+		problems = append(problems, "build_log_retention_days: must not be negative")
+	}
+	if s.ArtifactAuditBatchSize < 0 {
+		problems = append(problems, "artifact_audit_batch_size: must not be negative")
+	}
+	if s.CacheEntryTTLDays < 0 {
+		problems = append(problems, "cache_entry_ttl_days: must not be negative")
+	}
+	if s.StaleWorkerTTLHours < 0 {
+		problems = append(problems, "stale_worker_ttl_hours: must not be negative")
+	}
+	if s.AlertQueueWaitMinutes < 0 {
+		problems = append(problems, "alert_queue_wait_minutes: must not be negative")
+	}
+	if s.AlertNoCapacityMinutes < 0 {
+		problems = append(problems, "alert_no_capacity_minutes: must not be negative")
+	}
+	if s.DurationRegressionBuilds < 0 {
+		problems = append(problems, "duration_regression_builds: must not be negative")
+	}
+	if s.DurationRegressionSensitivity <= 0 {
+		problems = append(problems, "duration_regression_sensitivity: must be positive")
+	}
+	for i, source := range s.AllowedPluginSources {
+		if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "oci://") {
+			problems = append(problems, fmt.Sprintf("allowed_plugin_sources[%d]: must start with https://, http:// or oci://", i))
+		}
+	}
+	for i, event := range s.NotificationEvents {
+		if !notifications.ValidEvent(event) {
+			problems = append(problems, fmt.Sprintf("notification_events[%d]: unknown event %q", i, event))
+		}
+	}
+	return problems
+}
+
+// AllowsPluginSource reports whether plugins may be installed from source
+func (s Settings) AllowsPluginSource(source string) bool {
+	if len(s.AllowedPluginSources) == 0 {
+		return true
+	}
+	for _, prefix := range s.AllowedPluginSources {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store holds the current settings: the defaults overridden by those stored
+// in the database. Changes made through this server apply at once, and those
+// made through other servers sharing the database within reloadInterval.
+type Store struct {
+	db       *database.Database
+	defaults Settings
+
+	mu      sync.RWMutex
+	current Settings
+}
+
+// NewStore creates a store starting from the defaults
+func NewStore(db *database.Database, defaults Settings) *Store {
+	return &Store{db: db, defaults: defaults, current: defaults}
+}
+
+// Current returns the settings in effect
+func (s *Store) Current() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Start reloads the stored settings every reloadInterval until the context
+// is cancelled
+func (s *Store) Start(ctx context.Context) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Load(ctx); err != nil {
+				log.Error().Err(err).Msg("Failed to reload settings")
+			}
+		}
+	}
+}
+
+// Load reads the stored settings over the defaults
+func (s *Store) Load(ctx context.Context) error {
+	stored, err := s.stored(ctx)
+	if err != nil {
+		return err
+	}
+	settings, err := s.merge(stored)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.current = settings
+	s.mu.Unlock()
+	return nil
+}
+
+// Update applies a change to the settings: a value for each setting to
+// change, or null to restore its default. It returns a *ValidationError if a
+// setting is unknown or the result is invalid.
+func (s *Store) Update(ctx context.Context, changes map[string]json.RawMessage, updatedBy string) (Settings, error) {
+	known := s.names()
+	var problems []string
+	for name := range changes {
+		if !known[name] {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting", name))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return Settings{}, &ValidationError{Problems: problems}
+	}
+
+	stored, err := s.stored(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	for name, value := range changes {
+		if string(value) == "null" {
+			delete(stored, name)
+		} else {
+			stored[name] = value
+		}
+	}
+	settings, err := s.merge(stored)
+	if err != nil {
+		return Settings{}, &ValidationError{Problems: []string{err.Error()}}
+	}
+	if problems := settings.Validate(); len(problems) > 0 {
+		return Settings{}, &ValidationError{Problems: problems}
+	}
+	// Alert notifications are checked against the installed plugins
+	err = pluginconfig.ValidateAlertNotifications(ctx, s.db, settings.AlertNotifications)
+	var invalid *pluginconfig.ValidationError
+	if errors.As(err, &invalid) {
+		return Settings{}, &ValidationError{Problems: invalid.Problems}
+	}
+	if err != nil {
+		return Settings{}, err
+	}
+
+	err = s.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		for name, value := range changes {
+			if string(value) == "null" {
+				if _, err := tx.ExecContext(ctx, `DELETE FROM settings WHERE name = $1`, name); err != nil {
+					return err
+				}
+				continue
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO settings (name, value, updated_by)
+				VALUES ($1, $2, NULLIF($3, ''))
+				ON CONFLICT (name) DO UPDATE SET
+					value = EXCLUDED.value,
+					updated_by = EXCLUDED.updated_by,
+					updated_at = CURRENT_TIMESTAMP
+			`, name, []byte(value), updatedBy)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Settings{}, err
+	}
+
+	s.mu.Lock()
+	s.current = settings
+	s.mu.Unlock()
+	log.Info().Str("updated_by", updatedBy).Int("changes", len(changes)).Msg("Settings updated")
+	return settings, nil
+}
+
+// stored returns the settings stored in the database by name
+func (s *Store) stored(ctx context.Context) (map[string]json.RawMessage, error) {
+	rows, err := s.db.GetConn().QueryContext(ctx, `SELECT name, value FROM settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := map[string]json.RawMessage{}
+	for rows.Next() {
+		var name string
+		var value []byte
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		stored[name] = value
+	}
+	return stored, rows.Err()
+}
+
+// merge returns the defaults overridden by the stored settings
+func (s *Store) merge(stored map[string]json.RawMessage) (Settings, error) {
+	data, err := json.Marshal(s.defaults)
+	if err != nil {
+		return Settings{}, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Settings{}, err
+	}
+	for name, value := range stored {
+		if _, ok := fields[name]; ok {
+			fields[name] = value
+		}
+	}
+	if data, err = json.Marshal(fields); err != nil {
+		return Settings{}, err
+	}
+
+	var settings Settings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return Settings{}, fmt.Errorf("invalid setting value: %w", err)
+	}
+	return settings, nil
+}
+
+// names returns the names of the settings
+func (s *Store) names() map[string]bool {
+	data, _ := json.Marshal(s.defaults)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	names := make(map[string]bool, len(fields))
+	for name := range fields {
+		names[name] = true
+	}
+	return names
+}
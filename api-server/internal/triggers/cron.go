@@ -0,0 +1,101 @@
+package triggers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cronMacros are the shorthands accepted in place of the five fields
+var cronMacros = map[string]bool{
+	"@yearly": true, "@annually": true, "@monthly": true, "@weekly": true,
+	"@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// cronField is the range and value names of a field of a cron schedule
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names of the values from min, if any
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+}
+
+// ValidateSchedule checks a cron schedule: five fields (minute, hour, day of
+// month, month and day of week), each a comma separated list of "*", values
+// or ranges with an optional "/step", or one of the macros such as @daily
+func ValidateSchedule(schedule string) error {
+	if strings.HasPrefix(schedule, "@") {
+		if !cronMacros[schedule] {
+			return fmt.Errorf("unknown schedule macro %s", schedule)
+		}
+		return nil
+	}
+
+	fields := strings.Fields(schedule)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("schedule must have %d fields (minute hour day-of-month month day-of-week), got %d", len(cronFields), len(fields))
+	}
+	for i, f := range cronFields {
+		for _, item := range strings.Split(fields[i], ",") {
+			if err := f.check(item); err != nil {
+				return fmt.Errorf("%s field %q: %v", f.name, fields[i], err)
+			}
+		}
+	}
+	return nil
+}
+
+// check validates an item of a list in the field
+func (f cronField) check(item string) error {
+	span, step, hasStep := strings.Cut(item, "/")
+	if hasStep {
+		n, err := strconv.Atoi(step)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid step %q", step)
+		}
+	}
+	if span == "*" {
+		return nil
+	}
+
+	lo, hi, isRange := strings.Cut(span, "-")
+	from, err := f.value(lo)
+	if err != nil {
+		return err
+	}
+	if !isRange {
+		return nil
+	}
+	to, err := f.value(hi)
+	if err != nil {
+		return err
+	}
+	if from > to {
+		return fmt.Errorf("range %s is reversed", span)
+	}
+	return nil
+}
+
+// value parses a number or name in the field's range
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("value %d is outside %d-%d", n, f.min, f.max)
+	}
+	return n, nil
+}
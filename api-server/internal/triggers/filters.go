@@ -0,0 +1,172 @@
+package triggers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Trigger types
+const (
+	TypeWebhook     = "webhook"
+	TypeManual      = "manual"
+	TypeCron        = "cron"
+	TypePullRequest = "pull_request" // builds pull requests, filtered by their base branch
+)
+
+// Ref is the branch or tag a build is triggered for
+type Ref struct {
+	Branch string
+	Tag    string
+}
+
+// ParseRef reads a Git ref such as refs/heads/main or refs/tags/v1.2.0. Other
+// values are taken as a branch name.
+func ParseRef(ref string) Ref {
+	if tag, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+		return Ref{Tag: tag}
+	}
+	return Ref{Branch: strings.TrimPrefix(ref, "refs/heads/")}
+}
+
+func (r Ref) String() string {
+	if r.Tag != "" {
+		return "tag " + r.Tag
+	}
+	return "branch " + r.Branch
+}
+
+// Has reports whether the job has a trigger of the type
+func Has(triggers []interface{}, typ string) bool {
+	for _, raw := range triggers {
+		if t, ok := raw.(map[string]interface{}); ok && t["type"] == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the job's triggers of the type let a build of ref
+// run, and if not, why. Each trigger may have branches and tags filters, as
+//
+//	{"type": "webhook", "tags": {"allow": ["^v[0-9]"]}, "branches": {"deny": [".*"]}}
+//
+// Both are lists of regular expressions, matched anywhere in the name unless
+// anchored. A name is allowed if it matches a pattern of allow, or allow is
+// empty, and no pattern of deny. Branches and tags without a filter are
+// allowed. With several triggers of the type, one allowing ref is enough, and
+// a job without triggers of the type allows every ref.
+func Allowed(triggers []interface{}, typ string, ref Ref) (bool, string) {
+	found := false
+	reason := ""
+	for _, raw := range triggers {
+		t, ok := raw.(map[string]interface{})
+		if !ok || t["type"] != typ {
+			continue
+		}
+		found = true
+
+		key, name := "branches", ref.Branch
+		if ref.Tag != "" {
+			key, name = "tags", ref.Tag
+		}
+		filter, _ := t[key].(map[string]interface{})
+		ok, why := match(filter, name)
+		if ok {
+			return true, ""
+		}
+		reason = fmt.Sprintf("%s %s", ref, why)
+	}
+	if !found {
+		return true, ""
+	}
+	return false, reason
+}
+
+// match applies a filter's allow and deny lists to a name
+func match(filter map[string]interface{}, name string) (bool, string) {
+	allow := patterns(filter["allow"])
+	if len(allow) > 0 {
+		allowed := false
+		for _, p := range allow {
+			if re, err := regexp.Compile(p); err == nil && re.MatchString(name) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false, "matches none of the allowed patterns"
+		}
+	}
+	for _, p := range patterns(filter["deny"]) {
+		if re, err := regexp.Compile(p); err == nil && re.MatchString(name) {
+			return false, fmt.Sprintf("matches denied pattern %q", p)
+		}
+	}
+	return true, ""
+}
+
+// Validate checks the type of each trigger, the schedule of cron triggers and
+// that its filters are lists of valid regular expressions
+func Validate(triggers []interface{}) error {
+	for i, raw := range triggers {
+		t, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("triggers[%d]: must be an object", i)
+		}
+		switch t["type"] {
+		case TypeWebhook, TypeManual, TypePullRequest:
+		case TypeCron:
+			s, _ := t["schedule"].(string)
+			if s == "" {
+				return fmt.Errorf("triggers[%d]: cron trigger requires a schedule", i)
+			}
+			if err := ValidateSchedule(s); err != nil {
+				return fmt.Errorf("triggers[%d].schedule: %v", i, err)
+			}
+		default:
+			return fmt.Errorf("triggers[%d]: unknown trigger type %v", i, t["type"])
+		}
+
+		for _, key := range []string{"branches", "tags"} {
+			if t[key] == nil {
+				continue
+			}
+			filter, ok := t[key].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("triggers[%d].%s: must be an object with allow and deny lists", i, key)
+			}
+			for list, v := range filter {
+				if list != "allow" && list != "deny" {
+					return fmt.Errorf("triggers[%d].%s: unknown key %q", i, key, list)
+				}
+				items, ok := v.([]interface{})
+				if !ok {
+					return fmt.Errorf("triggers[%d].%s.%s: must be a list of regular expressions", i, key, list)
+				}
+				for _, item := range items {
+					p, ok := item.(string)
+					if !ok {
+						return fmt.Errorf("triggers[%d].%s.%s: must be a list of regular expressions", i, key, list)
+					}
+					if _, err := regexp.Compile(p); err != nil {
+						return fmt.Errorf("triggers[%d].%s.%s: invalid pattern %q: %v", i, key, list, p, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// patterns returns the strings of a JSON list
+func patterns(v interface{}) []string {
+	items, _ := v.([]interface{})
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
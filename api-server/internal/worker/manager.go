@@ -8,19 +8,27 @@ import (
 
 	"github.com/solvyd/solvyd/api-server/internal/database"
 	"github.com/solvyd/solvyd/api-server/internal/metrics"
+	"github.com/solvyd/solvyd/api-server/internal/settings"
 )
 
+// ResourceSampleRetention is how long the resource samples of worker
+// heartbeats are kept
+const ResourceSampleRetention = 7 * 24 * time.Hour
+
 // Manager handles worker registration and health monitoring
 type Manager struct {
-	db      *database.Database
-	metrics *metrics.Collector
+	db       *database.Database
+	metrics  *metrics.Collector
+	settings *settings.Store
 }
 
-// NewManager creates a new worker manager
-func NewManager(db *database.Database, m *metrics.Collector) *Manager {
+// NewManager creates a new worker manager, which removes workers offline for
+// longer than the settings' stale worker TTL
+func NewManager(db *database.Database, m *metrics.Collector, settings *settings.Store) *Manager {
 	return &Manager{
-		db:      db,
-		metrics: m,
+		db:       db,
+		metrics:  m,
+		settings: settings,
 	}
 }
 
@@ -73,10 +81,30 @@ func (m *Manager) checkWorkerHealth(ctx context.Context) {
 		log.Info().Int("count", count).Msg("Marked workers as offline")
 	}
 
+	if ttl := m.settings.Current().StaleWorkerTTLHours; ttl > 0 {
+		m.collectStaleWorkers(ctx, time.Duration(ttl)*time.Hour)
+	}
+	m.pruneResourceSamples(ctx)
+
 	// Update metrics
 	m.updateWorkerMetrics(ctx)
 }
 
+// pruneResourceSamples deletes the resource samples older than
+// ResourceSampleRetention
+func (m *Manager) pruneResourceSamples(ctx context.Context) {
+	result, err := m.db.GetConn().ExecContext(ctx,
+		`DELETE FROM worker_resource_samples WHERE recorded_at < $1`,
+		time.Now().Add(-ResourceSampleRetention))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to prune worker resource samples")
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		log.Debug().Int64("count", rows).Msg("Pruned old worker resource samples")
+	}
+}
+
 // updateWorkerMetrics collects and records worker metrics
 func (m *Manager) updateWorkerMetrics(ctx context.Context) {
 	query := `
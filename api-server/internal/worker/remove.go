@@ -0,0 +1,176 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/api-server/internal/models"
+	"github.com/solvyd/solvyd/api-server/internal/outbox"
+)
+
+// eventChannel is the channel worker events are published on
+const eventChannel = "workers"
+
+var (
+	// ErrNotFound is returned for a worker that does not exist
+	ErrNotFound = errors.New("worker not found")
+	// ErrBusy is returned when removing a worker with builds in progress
+	// without requeueing them
+	ErrBusy = errors.New("worker has builds in progress")
+	// errNotStale is returned when a worker found stale has sent a
+	// heartbeat since
+	errNotStale = errors.New("worker is not stale")
+)
+
+// Remove deletes a worker. With requeue its queued and running builds are
+// put back in the queue first, for other workers to take; without, a worker
+// with builds in progress is not removed (ErrBusy). It returns the requeued
+// builds.
+func (m *Manager) Remove(ctx context.Context, workerID uuid.UUID, requeue bool) ([]uuid.UUID, error) {
+	return m.remove(ctx, workerID, requeue, time.Time{})
+}
+
+// remove deletes a worker as Remove does; with staleBefore set, only if it is
+// offline and its last heartbeat is older
+func (m *Manager) remove(ctx context.Context, workerID uuid.UUID, requeue bool, staleBefore time.Time) ([]uuid.UUID, error) {
+	var requeued []uuid.UUID
+	err := m.db.WithTransaction(ctx, func(tx *sql.Tx) error {
+		var status string
+		var lastHeartbeat time.Time
+		err := tx.QueryRowContext(ctx, `
+			SELECT status, last_heartbeat FROM workers WHERE id = $1 FOR UPDATE
+		`, workerID).Scan(&status, &lastHeartbeat)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !staleBefore.IsZero() && (status != "offline" || !lastHeartbeat.Before(staleBefore)) {
+			return errNotStale
+		}
+
+		if requeue {
+			requeued, err = requeueBuilds(ctx, tx, workerID)
+			if err != nil {
+				return err
+			}
+		} else {
+			var busy bool
+			err := tx.QueryRowContext(ctx, `
+				SELECT EXISTS(SELECT 1 FROM builds WHERE worker_id = $1 AND status IN ('queued', 'running'))
+			`, workerID).Scan(&busy)
+			if err != nil {
+				return err
+			}
+			if busy {
+				return ErrBusy
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM workers WHERE id = $1`, workerID); err != nil {
+			return err
+		}
+		return outbox.Write(ctx, tx, eventChannel, "worker.deregistered", map[string]interface{}{
+			"worker_id": workerID, "requeued_builds": requeued,
+		})
+	})
+	return requeued, err
+}
+
+// requeueBuilds puts the queued and running builds of a worker back in the
+// queue, unassigned
+func requeueBuilds(ctx context.Context, tx *sql.Tx, workerID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE builds
+		SET status = 'queued', worker_id = NULL, started_at = NULL
+		WHERE worker_id = $1 AND status IN ('queued', 'running')
+		RETURNING id, job_id
+	`, workerID)
+	if err != nil {
+		return nil, err
+	}
+
+	type build struct{ id, jobID uuid.UUID }
+	var builds []build
+	for rows.Next() {
+		var b build
+		if err := rows.Scan(&b.id, &b.jobID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		builds = append(builds, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	requeued := make([]uuid.UUID, 0, len(builds))
+	for _, b := range builds {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO build_events (build_id, type, occurred_at, metadata)
+			VALUES ($1, $2, CURRENT_TIMESTAMP, jsonb_build_object('reason', 'worker_removed', 'worker_id', $3::text))
+		`, b.id, models.BuildEventQueued, workerID.String())
+		if err != nil {
+			return nil, err
+		}
+		err = outbox.WriteBuildEvent(ctx, tx, &b.jobID, b.id, "build.status", map[string]interface{}{
+			"build_id": b.id, "job_id": b.jobID, "status": "queued",
+		})
+		if err != nil {
+			return nil, err
+		}
+		requeued = append(requeued, b.id)
+	}
+	return requeued, nil
+}
+
+// collectStaleWorkers removes the workers offline for longer than ttl,
+// requeueing their builds, so that dead ephemeral agents do not pile up
+func (m *Manager) collectStaleWorkers(ctx context.Context, ttl time.Duration) {
+	staleBefore := time.Now().Add(-ttl)
+	rows, err := m.db.GetConn().QueryContext(ctx, `
+		SELECT id, name FROM workers
+		WHERE status = 'offline' AND last_heartbeat < $1
+	`, staleBefore)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query stale workers")
+		return
+	}
+
+	type stale struct {
+		id   uuid.UUID
+		name string
+	}
+	var workers []stale
+	for rows.Next() {
+		var w stale
+		if err := rows.Scan(&w.id, &w.name); err != nil {
+			continue
+		}
+		workers = append(workers, w)
+	}
+	rows.Close()
+
+	for _, w := range workers {
+		requeued, err := m.remove(ctx, w.id, true, staleBefore)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, errNotStale) {
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("worker_id", w.id.String()).Msg("Failed to remove stale worker")
+			continue
+		}
+		log.Info().
+			Str("worker_id", w.id.String()).
+			Str("worker_name", w.name).
+			Int("requeued_builds", len(requeued)).
+			Msg("Removed stale worker")
+	}
+}
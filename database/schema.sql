@@ -1,5 +1,10 @@
 -- Ritmo Database Schema
 -- PostgreSQL 15+
+--
+-- This is the schema of migration 1 of the API server
+-- (api-server/internal/database/migrations). Later schema changes are
+-- migrations, applied by the server on start; a database initialized from
+-- this file is recorded at version 1 and migrated from there.
 
 -- Enable UUID extension
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
@@ -29,23 +34,30 @@ CREATE TABLE jobs (
     
     -- Plugin references
     plugins JSONB DEFAULT '[]'::jsonb,
+    notifications JSONB DEFAULT '[]'::jsonb, -- Notification plugin steps and the build outcomes that fire them
     
     -- Metadata
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     created_by VARCHAR(255),
+    project VARCHAR(255), -- Owning project/team, e.g. set by a GitOps source
+    labels JSONB DEFAULT '{}'::jsonb, -- Key/value labels, e.g. team or tier, passed to plugins
     
     -- Pipeline stages (for complex pipelines)
     pipeline_stages JSONB DEFAULT '[]'::jsonb,
     
     -- Timeout and retry
     timeout_minutes INTEGER DEFAULT 60,
-    max_retries INTEGER DEFAULT 0
+    max_retries INTEGER DEFAULT 0,
+    
+    -- Cancel a branch's queued and running builds when a new one is queued
+    cancel_in_progress BOOLEAN DEFAULT false
 );
 
 CREATE INDEX idx_jobs_name ON jobs(name);
 CREATE INDEX idx_jobs_enabled ON jobs(enabled);
 CREATE INDEX idx_jobs_created_at ON jobs(created_at DESC);
+CREATE INDEX idx_jobs_project ON jobs(project);
 
 -- Builds table: Stores individual build executions
 CREATE TABLE builds (
@@ -69,7 +81,10 @@ CREATE TABLE builds (
     scm_commit_sha VARCHAR(255),
     scm_commit_message TEXT,
     scm_author VARCHAR(255),
+    scm_author_email VARCHAR(255),
+    scm_committed_at TIMESTAMP WITH TIME ZONE,
     branch VARCHAR(255),
+    changed_files JSONB DEFAULT '[]'::jsonb, -- paths changed since the previous build's commit
     
     -- Build parameters
     parameters JSONB DEFAULT '{}'::jsonb,
@@ -78,10 +93,13 @@ CREATE TABLE builds (
     -- Trigger info
     triggered_by VARCHAR(255), -- user, webhook, schedule, manual
     trigger_metadata JSONB DEFAULT '{}'::jsonb,
+    trace_context JSONB, -- W3C trace context of the request that queued the build
     
     -- Results
     exit_code INTEGER,
     error_message TEXT,
+    cancel_reason VARCHAR(50), -- user, superseded, pull_request_closed
+    superseded_by UUID REFERENCES builds(id) ON DELETE SET NULL,
     
     -- Logs reference
     log_url TEXT,
@@ -101,6 +119,7 @@ CREATE INDEX idx_builds_queued_at ON builds(queued_at DESC);
 CREATE INDEX idx_builds_started_at ON builds(started_at DESC);
 CREATE INDEX idx_builds_worker_id ON builds(worker_id);
 CREATE INDEX idx_builds_scm_commit ON builds(scm_commit_sha);
+CREATE INDEX idx_builds_job_queued_at ON builds(job_id, queued_at DESC);
 
 -- Workers table: Stores worker node information
 CREATE TABLE workers (
@@ -212,6 +231,34 @@ CREATE INDEX idx_deployments_build_id ON deployments(build_id);
 CREATE INDEX idx_deployments_environment ON deployments(environment);
 CREATE INDEX idx_deployments_status ON deployments(status);
 CREATE INDEX idx_deployments_started_at ON deployments(started_at DESC);
+CREATE INDEX idx_deployments_rollback_from ON deployments(rollback_from_deployment_id);
+
+-- Build events table: Lifecycle events of builds, for their timelines
+CREATE TABLE build_events (
+    id BIGSERIAL PRIMARY KEY,
+    build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL, -- assigned, started, stage_started, step_finished, artifact, ...
+    name VARCHAR(255), -- the stage, step or artifact
+    status VARCHAR(50), -- how a stage or step ended
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    metadata JSONB DEFAULT '{}'::jsonb
+);
+
+CREATE INDEX idx_build_events_build_id ON build_events(build_id, occurred_at);
+
+-- Event outbox table: Events written with the state changes they describe,
+-- relayed to WebSocket subscribers by the server at least once
+CREATE TABLE event_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    channel VARCHAR(255) NOT NULL, -- build:<id>, job:<id>, workers
+    event VARCHAR(100) NOT NULL, -- build.queued, build.status, build.event, ...
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    dispatched_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_event_outbox_pending ON event_outbox(id) WHERE dispatched_at IS NULL;
+CREATE INDEX idx_event_outbox_dispatched_at ON event_outbox(dispatched_at);
 
 -- Build logs table: Stores build log chunks
 CREATE TABLE build_logs (
@@ -227,6 +274,94 @@ CREATE TABLE build_logs (
 
 CREATE INDEX idx_build_logs_build_id ON build_logs(build_id, sequence_number);
 
+-- Build findings table: Security findings reported by scanners during a build
+CREATE TABLE build_findings (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+    tool VARCHAR(255) NOT NULL,
+    rule_id VARCHAR(512) NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    severity VARCHAR(20) NOT NULL, -- critical, high, medium, low, info
+    
+    -- Location: a source file, a dependency or a URL
+    path TEXT NOT NULL DEFAULT '',
+    start_line INTEGER NOT NULL DEFAULT 0,
+    end_line INTEGER NOT NULL DEFAULT 0,
+    uri TEXT NOT NULL DEFAULT '',
+    package VARCHAR(512) NOT NULL DEFAULT '',
+    version VARCHAR(255) NOT NULL DEFAULT '',
+    
+    cwes TEXT[] NOT NULL DEFAULT '{}',
+    cves TEXT[] NOT NULL DEFAULT '{}',
+    help_url TEXT NOT NULL DEFAULT '',
+    fingerprint VARCHAR(255) NOT NULL, -- stable across builds
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_build_findings_build_id ON build_findings(build_id, severity);
+CREATE INDEX idx_build_findings_fingerprint ON build_findings(fingerprint);
+
+-- Build test results table: Test cases reported by test plugins during a build, usually only those that did not pass
+CREATE TABLE build_test_results (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+    step VARCHAR(255) NOT NULL DEFAULT '', -- plugin that reported the test case
+    suite TEXT NOT NULL DEFAULT '',
+    class_name TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL, -- passed, failed, error, skipped
+    message TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '', -- failure output, such as a stack trace
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_build_test_results_build_id ON build_test_results(build_id, status);
+
+-- Build coverage table: Code coverage reported by coverage plugins, one row per build and step
+CREATE TABLE build_coverage (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    build_id UUID NOT NULL REFERENCES builds(id) ON DELETE CASCADE,
+    step VARCHAR(255) NOT NULL DEFAULT '', -- plugin that reported the coverage
+    format VARCHAR(50) NOT NULL DEFAULT '', -- cobertura, lcov, jacoco, go
+    lines_covered INTEGER NOT NULL DEFAULT 0,
+    lines_total INTEGER NOT NULL DEFAULT 0,
+    branches_covered INTEGER NOT NULL DEFAULT 0,
+    branches_total INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    
+    UNIQUE(build_id, step)
+);
+
+-- Finding baselines table: Findings a job accepts as existing backlog, snapshotted from a build
+CREATE TABLE finding_baselines (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    build_id UUID REFERENCES builds(id) ON DELETE SET NULL, -- build the snapshot was taken from
+    fingerprint VARCHAR(255) NOT NULL,
+    tool VARCHAR(255) NOT NULL,
+    rule_id VARCHAR(512) NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    severity VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    
+    UNIQUE(job_id, fingerprint)
+);
+
+-- Finding suppressions table: Findings a job ignores, with a reason and optional expiry
+CREATE TABLE finding_suppressions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    fingerprint VARCHAR(255) NOT NULL,
+    reason TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE, -- NULL never expires
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    created_by VARCHAR(255)
+);
+
+CREATE INDEX idx_finding_suppressions_job_id ON finding_suppressions(job_id, fingerprint);
+
 -- Credentials table: Stores encrypted credentials
 CREATE TABLE credentials (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -279,6 +414,42 @@ CREATE TABLE plugins (
 CREATE INDEX idx_plugins_type ON plugins(type);
 CREATE INDEX idx_plugins_enabled ON plugins(enabled);
 
+-- Plugin registry: published plugin versions, one row per platform build
+CREATE TABLE plugin_versions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    plugin_name VARCHAR(255) NOT NULL,
+    version VARCHAR(50) NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    
+    -- Target platform ('wasip1'/'wasm' for WASM plugins)
+    os VARCHAR(50) NOT NULL DEFAULT 'linux',
+    arch VARCHAR(50) NOT NULL DEFAULT 'amd64',
+    
+    -- Binary in artifact storage
+    storage_key TEXT NOT NULL,
+    checksum_sha256 VARCHAR(64) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    
+    -- Plugin metadata
+    description TEXT,
+    author VARCHAR(255),
+    homepage_url TEXT,
+    config_schema JSONB DEFAULT '{}'::jsonb,
+    
+    -- Status
+    yanked BOOLEAN DEFAULT false,
+    download_count INTEGER DEFAULT 0,
+    
+    -- Metadata
+    published_by VARCHAR(255),
+    published_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    
+    UNIQUE(plugin_name, version, os, arch)
+);
+
+CREATE INDEX idx_plugin_versions_name ON plugin_versions(plugin_name);
+CREATE INDEX idx_plugin_versions_type ON plugin_versions(type);
+
 -- Pipeline stages table: For complex multi-stage pipelines
 CREATE TABLE pipeline_stages (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -352,6 +523,7 @@ CREATE TABLE users (
     
     -- Roles
     roles VARCHAR(50)[], -- admin, developer, viewer
+    projects VARCHAR(255)[], -- Projects the user can read; admins read all
     
     -- Metadata
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
@@ -361,6 +533,21 @@ CREATE TABLE users (
 CREATE INDEX idx_users_username ON users(username);
 CREATE INDEX idx_users_email ON users(email);
 
+-- API tokens table: Long-lived bearer tokens of users, stored hashed
+CREATE TABLE api_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 hex of the token
+    
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
+
 -- Audit log table: Track all actions
 CREATE TABLE audit_logs (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -503,3 +690,4 @@ COMMENT ON TABLE builds IS 'Stores individual build execution records';
 COMMENT ON TABLE workers IS 'Stores worker node registrations and status';
 COMMENT ON TABLE artifacts IS 'Stores build artifact metadata and storage locations';
 COMMENT ON TABLE deployments IS 'Stores CD deployment records';
+COMMENT ON TABLE plugin_versions IS 'Plugin registry of published versions and their binaries';
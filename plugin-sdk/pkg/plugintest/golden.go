@@ -0,0 +1,50 @@
+package plugintest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UpdateEnv is the environment variable that makes AssertGolden rewrite golden
+// files instead of comparing against them:
+//
+//	PLUGINTEST_UPDATE=1 go test ./...
+const UpdateEnv = "PLUGINTEST_UPDATE"
+
+// workDirPlaceholder replaces the temporary workspace path in golden files
+const workDirPlaceholder = "$WORKDIR"
+
+// AssertGolden compares got, usually the *sdk.Result returned by Run, with the
+// JSON in the golden file at path. The workspace path is written as $WORKDIR
+// so golden files do not depend on the temporary directory. A missing golden
+// file fails the test; set PLUGINTEST_UPDATE=1 to create or update it.
+func (c *Context) AssertGolden(path string, got interface{}) {
+	c.t.Helper()
+
+	data, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		c.t.Fatalf("failed to marshal result: %v", err)
+	}
+	actual := strings.ReplaceAll(string(data), c.WorkDir, workDirPlaceholder) + "\n"
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			c.t.Fatalf("failed to create directory for golden file: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			c.t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		c.t.Fatalf("failed to read golden file (run with %s=1 to create it): %v", UpdateEnv, err)
+	}
+	if !bytes.Equal(bytes.ReplaceAll(expected, []byte("\r\n"), []byte("\n")), []byte(actual)) {
+		c.t.Errorf("result does not match %s (run with %s=1 to update it)\n--- expected\n%s--- actual\n%s", path, UpdateEnv, expected, actual)
+	}
+}
@@ -0,0 +1,167 @@
+package plugintest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// LevelOutput is the level of entries recorded from lines written to
+// ExecutionContext.Output
+const LevelOutput = "output"
+
+// Entry is a recorded log message or output line
+type Entry struct {
+	Level   string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// Logger is an in-memory sdk.Logger that records entries for assertions. It
+// is also an io.Writer that records each line written to it as an output
+// entry, like the worker agent does for ExecutionContext.Output. It is safe
+// for concurrent use.
+type Logger struct {
+	t testing.TB
+
+	mu      sync.Mutex
+	entries []Entry
+	partial []byte // output after the last newline
+}
+
+// NewLogger returns an empty Logger that reports assertion failures to t
+func NewLogger(t testing.TB) *Logger {
+	return &Logger{t: t}
+}
+
+func (l *Logger) Debug(msg string, fields ...interface{}) { l.add("debug", msg, fields) }
+func (l *Logger) Info(msg string, fields ...interface{})  { l.add("info", msg, fields) }
+func (l *Logger) Warn(msg string, fields ...interface{})  { l.add("warn", msg, fields) }
+func (l *Logger) Error(msg string, fields ...interface{}) { l.add("error", msg, fields) }
+
+func (l *Logger) add(level, msg string, fields []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, Entry{Level: level, Message: msg, Fields: fieldMap(fields)})
+}
+
+// Write records each complete line in p as an output entry
+func (l *Logger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(l.partial[:i]), "\r")
+		l.entries = append(l.entries, Entry{Level: LevelOutput, Message: line})
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush records output written without a trailing newline
+func (l *Logger) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.partial) > 0 {
+		l.entries = append(l.entries, Entry{Level: LevelOutput, Message: string(l.partial)})
+		l.partial = nil
+	}
+}
+
+// Entries returns every recorded entry in order, including output written
+// without a trailing newline so far
+func (l *Logger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := append([]Entry{}, l.entries...)
+	if len(l.partial) > 0 {
+		entries = append(entries, Entry{Level: LevelOutput, Message: string(l.partial)})
+	}
+	return entries
+}
+
+// Messages returns the messages recorded at a level, such as "info" or LevelOutput
+func (l *Logger) Messages(level string) []string {
+	messages := []string{}
+	for _, e := range l.Entries() {
+		if e.Level == level {
+			messages = append(messages, e.Message)
+		}
+	}
+	return messages
+}
+
+// Output returns the lines written to the logger as an io.Writer
+func (l *Logger) Output() []string {
+	return l.Messages(LevelOutput)
+}
+
+// AssertLogged fails the test unless a message at level contains substr
+func (l *Logger) AssertLogged(level, substr string) {
+	l.t.Helper()
+	if !l.contains(level, substr) {
+		l.t.Errorf("expected a %s message containing %q, got:\n%s", level, substr, l.dump())
+	}
+}
+
+// AssertNotLogged fails the test if a message at level contains substr
+func (l *Logger) AssertNotLogged(level, substr string) {
+	l.t.Helper()
+	if l.contains(level, substr) {
+		l.t.Errorf("expected no %s message containing %q, got:\n%s", level, substr, l.dump())
+	}
+}
+
+// AssertNoErrors fails the test if anything was logged at the error level
+func (l *Logger) AssertNoErrors() {
+	l.t.Helper()
+	if errs := l.Messages("error"); len(errs) > 0 {
+		l.t.Errorf("expected no error messages, got:\n  %s", strings.Join(errs, "\n  "))
+	}
+}
+
+func (l *Logger) contains(level, substr string) bool {
+	for _, m := range l.Messages(level) {
+		if strings.Contains(m, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// dump formats the recorded entries for failure messages
+func (l *Logger) dump() string {
+	entries := l.Entries()
+	if len(entries) == 0 {
+		return "  (nothing logged)"
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = fmt.Sprintf("  [%s] %s", e.Level, e.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fieldMap pairs up key/value logging fields the way the SDK sends them to the agent
+func fieldMap(fields []interface{}) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{})
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if i+1 < len(fields) {
+			m[key] = fields[i+1]
+		} else {
+			m[key] = nil
+		}
+	}
+	return m
+}
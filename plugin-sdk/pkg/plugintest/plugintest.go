@@ -0,0 +1,137 @@
+// Package plugintest helps plugin authors unit test plugins without a worker
+// agent or a running solvyd instance. It provides an ExecutionContext backed by
+// a temporary workspace and an in-memory logger, and compares results against
+// golden files.
+//
+//	func TestExecute(t *testing.T) {
+//		ctx := plugintest.NewContext(t)
+//		ctx.Parameters["branch"] = "main"
+//		ctx.WriteFiles(map[string]string{"go.mod": "module example.com/app\n"})
+//
+//		result, err := plugintest.Run(t, &MyPlugin{}, map[string]interface{}{"depth": 1}, ctx)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		ctx.Log.AssertLogged("info", "completed")
+//		ctx.AssertGolden("testdata/execute.golden.json", result)
+//	}
+package plugintest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// Context is an ExecutionContext for tests. Its WorkDir is a temporary
+// directory removed when the test ends, and everything the plugin logs or
+// writes to Output is recorded in Log.
+type Context struct {
+	*sdk.ExecutionContext
+
+	// Log records the plugin's log entries and output lines
+	Log *Logger
+
+	t      testing.TB
+	cancel context.CancelFunc
+}
+
+// NewContext returns a Context with a fresh workspace and empty parameters,
+// environment and secrets. Its context is cancelled when the test ends, or
+// earlier by Cancel.
+func NewContext(t testing.TB) *Context {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	log := NewLogger(t)
+	return &Context{
+		ExecutionContext: &sdk.ExecutionContext{
+			Context:    ctx,
+			BuildID:    "test-build",
+			JobID:      "test-job",
+			WorkDir:    t.TempDir(),
+			EnvVars:    make(map[string]string),
+			Parameters: make(map[string]interface{}),
+			Secrets:    make(map[string]string),
+			Logger:     log,
+			Output:     log,
+		},
+		Log:    log,
+		t:      t,
+		cancel: cancel,
+	}
+}
+
+// Cancel cancels the context, as the worker agent does when a build is cancelled
+func (c *Context) Cancel() {
+	c.cancel()
+}
+
+// WriteFiles creates files in the workspace, keyed by their slash-separated
+// path relative to WorkDir. Parent directories are created as needed.
+func (c *Context) WriteFiles(files map[string]string) {
+	c.t.Helper()
+	for name, content := range files {
+		path := c.Path(name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			c.t.Fatalf("failed to create directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			c.t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// ReadFile returns the content of a file in the workspace, failing the test if
+// it does not exist
+func (c *Context) ReadFile(name string) string {
+	c.t.Helper()
+	data, err := os.ReadFile(c.Path(name))
+	if err != nil {
+		c.t.Fatalf("failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+// AssertFileExists fails the test if the workspace has no file at name
+func (c *Context) AssertFileExists(name string) {
+	c.t.Helper()
+	if _, err := os.Stat(c.Path(name)); err != nil {
+		c.t.Errorf("expected %s in the workspace: %v", name, err)
+	}
+}
+
+// Path returns the absolute path of a slash-separated path relative to WorkDir
+func (c *Context) Path(name string) string {
+	return filepath.Join(c.WorkDir, filepath.FromSlash(name))
+}
+
+// Run calls Initialize, Health, Execute and Cleanup in the order the worker
+// agent does and returns what Execute returned. The test fails immediately if
+// Initialize or Health fails, and is marked failed if Cleanup does.
+func Run(t testing.TB, p sdk.Plugin, config map[string]interface{}, ctx *Context) (*sdk.Result, error) {
+	t.Helper()
+
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+	if err := p.Initialize(config); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	if err := p.Health(); err != nil {
+		t.Fatalf("Health failed: %v", err)
+	}
+
+	result, err := p.Execute(ctx.ExecutionContext)
+	ctx.Log.flush()
+
+	if cleanupErr := p.Cleanup(); cleanupErr != nil {
+		t.Errorf("Cleanup failed: %v", cleanupErr)
+	}
+	return result, err
+}
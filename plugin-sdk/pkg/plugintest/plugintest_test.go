@@ -0,0 +1,270 @@
+package plugintest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// recorder is a testing.TB that records failures instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+	fatal  bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	r.fatal = true
+	runtime.Goexit()
+}
+
+// run calls f with a recorder, in its own goroutine so Fatalf can stop it
+func run(t *testing.T, f func(tb testing.TB)) *recorder {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(r)
+	}()
+	<-done
+	return r
+}
+
+// fakePlugin records the calls Run makes and returns what it is told to
+type fakePlugin struct {
+	calls      []string
+	config     map[string]interface{}
+	initErr    error
+	cleanupErr error
+	execute    func(ctx *sdk.ExecutionContext) (*sdk.Result, error)
+}
+
+func (p *fakePlugin) Name() string           { return "fake" }
+func (p *fakePlugin) Version() string        { return "0.0.0" }
+func (p *fakePlugin) Type() string           { return "test" }
+func (p *fakePlugin) Capabilities() []string { return nil }
+
+func (p *fakePlugin) Initialize(config map[string]interface{}) error {
+	p.calls = append(p.calls, "Initialize")
+	p.config = config
+	return p.initErr
+}
+
+func (p *fakePlugin) Health() error {
+	p.calls = append(p.calls, "Health")
+	return nil
+}
+
+func (p *fakePlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	p.calls = append(p.calls, "Execute")
+	if p.execute != nil {
+		return p.execute(ctx)
+	}
+	return &sdk.Result{Success: true}, nil
+}
+
+func (p *fakePlugin) Cleanup() error {
+	p.calls = append(p.calls, "Cleanup")
+	return p.cleanupErr
+}
+
+func TestRun(t *testing.T) {
+	ctx := NewContext(t)
+	p := &fakePlugin{execute: func(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+		ctx.Logger.Info("started", "step", "build")
+		fmt.Fprint(ctx.Output, "line one\r\nline two\npartial")
+		return &sdk.Result{Success: true, Output: "done"}, errors.New("execute error")
+	}}
+
+	result, err := Run(t, p, nil, ctx)
+	if err == nil || err.Error() != "execute error" {
+		t.Errorf("err = %v, want the error Execute returned", err)
+	}
+	if result == nil || result.Output != "done" {
+		t.Errorf("result = %+v, want the result Execute returned", result)
+	}
+	if want := []string{"Initialize", "Health", "Execute", "Cleanup"}; !reflect.DeepEqual(p.calls, want) {
+		t.Errorf("calls = %v, want %v", p.calls, want)
+	}
+	if p.config == nil {
+		t.Error("Initialize got a nil config")
+	}
+	if want := []string{"line one", "line two", "partial"}; !reflect.DeepEqual(ctx.Log.Output(), want) {
+		t.Errorf("output = %q, want %q", ctx.Log.Output(), want)
+	}
+	entries := ctx.Log.Entries()
+	if len(entries) == 0 || !reflect.DeepEqual(entries[0].Fields, map[string]interface{}{"step": "build"}) {
+		t.Errorf("entries = %+v, want the first to have the step field", entries)
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		plugin    *fakePlugin
+		wantCalls []string
+		wantFatal bool
+	}{
+		{
+			name:      "initialize",
+			plugin:    &fakePlugin{initErr: errors.New("bad config")},
+			wantCalls: []string{"Initialize"},
+			wantFatal: true,
+		},
+		{
+			name:      "cleanup",
+			plugin:    &fakePlugin{cleanupErr: errors.New("busy")},
+			wantCalls: []string{"Initialize", "Health", "Execute", "Cleanup"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := run(t, func(tb testing.TB) {
+				Run(tb, tt.plugin, nil, NewContext(t))
+			})
+			if len(r.errors) != 1 {
+				t.Errorf("errors = %q, want one", r.errors)
+			}
+			if r.fatal != tt.wantFatal {
+				t.Errorf("fatal = %v, want %v", r.fatal, tt.wantFatal)
+			}
+			if !reflect.DeepEqual(tt.plugin.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", tt.plugin.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestContextFiles(t *testing.T) {
+	ctx := NewContext(t)
+	ctx.WriteFiles(map[string]string{
+		"go.mod":          "module example.com/app\n",
+		"cmd/app/main.go": "package main\n",
+	})
+
+	if got := ctx.ReadFile("cmd/app/main.go"); got != "package main\n" {
+		t.Errorf("ReadFile = %q", got)
+	}
+	if want := filepath.Join(ctx.WorkDir, "cmd", "app", "main.go"); ctx.Path("cmd/app/main.go") != want {
+		t.Errorf("Path = %q, want %q", ctx.Path("cmd/app/main.go"), want)
+	}
+	ctx.AssertFileExists("go.mod")
+
+	r := run(t, func(tb testing.TB) {
+		c := NewContext(t)
+		c.t = tb
+		c.AssertFileExists("missing")
+		c.ReadFile("missing")
+	})
+	if len(r.errors) != 2 || !r.fatal {
+		t.Errorf("errors = %q, fatal = %v, want two errors ending in a fatal one", r.errors, r.fatal)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	ctx := NewContext(t)
+	if ctx.Context.Err() != nil {
+		t.Fatal("context cancelled before Cancel")
+	}
+	ctx.Cancel()
+	if ctx.Context.Err() == nil {
+		t.Error("context not cancelled by Cancel")
+	}
+}
+
+func TestLoggerAssertions(t *testing.T) {
+	tests := []struct {
+		name       string
+		assert     func(l *Logger)
+		wantFailed bool
+	}{
+		{"logged", func(l *Logger) { l.AssertLogged("info", "build") }, false},
+		{"logged at another level", func(l *Logger) { l.AssertLogged("warn", "build") }, true},
+		{"logged output", func(l *Logger) { l.AssertLogged(LevelOutput, "compiling") }, false},
+		{"not logged", func(l *Logger) { l.AssertNotLogged("error", "build") }, false},
+		{"not logged but was", func(l *Logger) { l.AssertNotLogged("error", "disk") }, true},
+		{"no errors", func(l *Logger) { l.AssertNoErrors() }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := run(t, func(tb testing.TB) {
+				l := NewLogger(tb)
+				l.Info("build started")
+				l.Error("disk full")
+				fmt.Fprintln(l, "compiling")
+				tt.assert(l)
+			})
+			if failed := len(r.errors) > 0; failed != tt.wantFailed {
+				t.Errorf("failed = %v, want %v: %q", failed, tt.wantFailed, r.errors)
+			}
+		})
+	}
+}
+
+func TestFieldMap(t *testing.T) {
+	tests := []struct {
+		fields []interface{}
+		want   map[string]interface{}
+	}{
+		{nil, nil},
+		{[]interface{}{"a", 1, "b", "two"}, map[string]interface{}{"a": 1, "b": "two"}},
+		{[]interface{}{"a", 1, "odd"}, map[string]interface{}{"a": 1, "odd": nil}},
+		{[]interface{}{42, true}, map[string]interface{}{"42": true}},
+	}
+	for _, tt := range tests {
+		if got := fieldMap(tt.fields); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fieldMap(%v) = %v, want %v", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	ctx := NewContext(t)
+	golden := filepath.Join(t.TempDir(), "testdata", "result.golden.json")
+	result := &sdk.Result{Success: true, Output: "built " + ctx.Path("bin/app")}
+
+	r := run(t, func(tb testing.TB) {
+		c := NewContext(t)
+		c.t = tb
+		c.AssertGolden(golden, result)
+	})
+	if !r.fatal {
+		t.Error("a missing golden file did not fail the test")
+	}
+
+	t.Setenv(UpdateEnv, "1")
+	ctx.AssertGolden(golden, result)
+	data, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), workDirPlaceholder) || strings.Contains(string(data), ctx.WorkDir) {
+		t.Errorf("golden file does not use the workspace placeholder:\n%s", data)
+	}
+
+	t.Setenv(UpdateEnv, "")
+	ctx.AssertGolden(golden, result)
+
+	r = run(t, func(tb testing.TB) {
+		ctx.t = tb
+		ctx.AssertGolden(golden, &sdk.Result{Success: false})
+	})
+	ctx.t = t
+	if len(r.errors) != 1 || r.fatal {
+		t.Errorf("errors = %q, want a mismatch", r.errors)
+	}
+}
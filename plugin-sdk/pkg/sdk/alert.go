@@ -0,0 +1,38 @@
+package sdk
+
+// Alert events
+const (
+	AlertFired    = "fired"
+	AlertResolved = "resolved"
+)
+
+// Alert is an operational alert of the API server about worker or queue
+// health, such as a worker going offline. The worker agent runs the
+// notification steps of the alert_notifications setting when an alert fires
+// or resolves, with the alert in their "alert" parameter; notification
+// plugins check Alert to tell these runs from a build's.
+type Alert struct {
+	ID      string
+	Kind    string // worker_offline, queue_wait or no_capacity
+	Subject string // the worker ID or the pool the alert is about
+	Message string
+	Event   string // AlertFired or AlertResolved
+	Details Config // e.g. worker_name, pool and queued_builds
+}
+
+// Alert returns the alert the step runs for, or nil if it runs for a build
+func (c *ExecutionContext) Alert() *Alert {
+	params := Config(c.Parameters)
+	if !params.Has("alert") {
+		return nil
+	}
+	a := params.Map("alert")
+	return &Alert{
+		ID:      a.String("id", ""),
+		Kind:    a.String("kind", ""),
+		Subject: a.String("subject", ""),
+		Message: a.String("message", ""),
+		Event:   a.String("event", ""),
+		Details: a.Map("details"),
+	}
+}
@@ -0,0 +1,46 @@
+package sdk
+
+// Annotation severities, from most to least severe
+const (
+	AnnotationError   = "error"
+	AnnotationWarning = "warning"
+	AnnotationNotice  = "notice"
+)
+
+// Annotation is a problem at a line of a file, such as a lint error or the
+// assertion of a failed test. Plugins return annotations in
+// Result.Annotations; the worker agent uploads them with the build so they
+// can be rendered inline with the file, and passes them to the build's
+// notifications, which can post them as pull request review comments.
+type Annotation struct {
+	Path      string // relative to the repository root; empty for the whole build
+	StartLine int    // 0 for the whole file
+	EndLine   int    // StartLine if 0
+	Severity  string // one of the Annotation severity constants
+	Title     string
+	Message   string
+}
+
+// BuildAnnotations returns the annotations the build's plugin steps
+// reported, passed by the worker agent to notifications in the annotations
+// parameter
+func BuildAnnotations(ctx *ExecutionContext) []Annotation {
+	items, _ := ctx.Parameters["annotations"].([]interface{})
+	annotations := make([]Annotation, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cfg := Config(m)
+		annotations = append(annotations, Annotation{
+			Path:      cfg.String("path", ""),
+			StartLine: cfg.Int("start_line", 0),
+			EndLine:   cfg.Int("end_line", 0),
+			Severity:  cfg.String("severity", AnnotationNotice),
+			Title:     cfg.String("title", ""),
+			Message:   cfg.String("message", ""),
+		})
+	}
+	return annotations
+}
@@ -0,0 +1,209 @@
+package sdk
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteCacheArchive writes the directory dir to w as a gzipped tar archive.
+// Regular files, directories and symlinks are kept with their permissions;
+// other files, such as sockets, are skipped.
+func WriteCacheArchive(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		switch {
+		case info.Mode().IsRegular(), info.IsDir():
+		case info.Mode()&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		// Ownership is not restored, so leave it out
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadCacheArchive extracts an archive written by WriteCacheArchive into
+// dest, creating it if needed. Entries and symlinks that would point outside
+// dest are rejected.
+func ReadCacheArchive(r io.Reader, dest string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid cache archive: %w", err)
+	}
+	defer gz.Close()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	var links []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return checkSymlinks(dest, links)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid cache archive: %w", err)
+		}
+
+		path, ok := withinDir(dest, header.Name)
+		if !ok {
+			return fmt.Errorf("cache archive entry %s is outside the destination", header.Name)
+		}
+		if !noSymlinkParents(dest, path) {
+			return fmt.Errorf("cache archive entry %s is below a symlink", header.Name)
+		}
+		mode := fs.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := extractFile(tr, path, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target := header.Linkname
+			if filepath.IsAbs(target) {
+				return fmt.Errorf("cache archive symlink %s has an absolute target", header.Name)
+			}
+			if _, ok := withinDir(dest, filepath.Join(filepath.Dir(header.Name), target)); !ok {
+				return fmt.Errorf("cache archive symlink %s points outside the destination", header.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			os.Remove(path)
+			if err := os.Symlink(target, path); err != nil {
+				return err
+			}
+			links = append(links, path)
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string, mode fs.FileMode) error {
+	os.Remove(path) // replace symlinks rather than writing through them
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// checkSymlinks fails if an extracted symlink resolves outside dir, which a
+// target leading through another symlink could do
+func checkSymlinks(dir string, links []string) error {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		resolved, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue // dangling
+		}
+		if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			os.Remove(link)
+			return fmt.Errorf("cache archive symlink %s points outside the destination", link)
+		}
+	}
+	return nil
+}
+
+// noSymlinkParents reports whether none of the directories between dir and
+// path is a symlink, so the checks on archive paths hold on disk
+func noSymlinkParents(dir, path string) bool {
+	rel, err := filepath.Rel(dir, filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	current := dir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return true // nothing below a missing directory exists yet
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// withinDir joins a slash-separated archive path to dir, reporting false if
+// the result would be outside dir
+func withinDir(dir, name string) (string, bool) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(dir, rel), true
+}
@@ -0,0 +1,171 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config wraps the configuration passed to Initialize with typed getters.
+// Values are decoded from JSON, so numbers arrive as float64. A getter returns
+// its default when the key is missing or holds a value of the wrong type.
+//
+//	cfg := sdk.Config(config)
+//	if err := cfg.Require("server_url", "token"); err != nil {
+//		return err
+//	}
+//	p.timeout = cfg.Duration("timeout", 5*time.Minute)
+type Config map[string]interface{}
+
+// Has reports whether key is set to a non-null value
+func (c Config) Has(key string) bool {
+	return c[key] != nil
+}
+
+// Require returns an error naming every key that is missing, null or an empty string
+func (c Config) Require(keys ...string) error {
+	missing := []string{}
+	for _, key := range keys {
+		if s, ok := c[key].(string); !c.Has(key) || (ok && s == "") {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required config: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// String returns a string value
+func (c Config) String(key, defaultValue string) string {
+	if val, ok := c[key].(string); ok {
+		return val
+	}
+	return defaultValue
+}
+
+// Int returns an integer value. Fractional numbers are truncated.
+func (c Config) Int(key string, defaultValue int) int {
+	if val, ok := toFloat(c[key]); ok {
+		return int(val)
+	}
+	return defaultValue
+}
+
+// Float returns a numeric value
+func (c Config) Float(key string, defaultValue float64) float64 {
+	if val, ok := toFloat(c[key]); ok {
+		return val
+	}
+	return defaultValue
+}
+
+// Bool returns a boolean value. The strings "true" and "false" are accepted,
+// since parameters are often passed as strings.
+func (c Config) Bool(key string, defaultValue bool) bool {
+	switch val := c[key].(type) {
+	case bool:
+		return val
+	case string:
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// Duration returns a duration given as a string such as "90s" or "5m", or as
+// a number of seconds
+func (c Config) Duration(key string, defaultValue time.Duration) time.Duration {
+	if s, ok := c[key].(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d
+		}
+		return defaultValue
+	}
+	if val, ok := toFloat(c[key]); ok {
+		return time.Duration(val * float64(time.Second))
+	}
+	return defaultValue
+}
+
+// StringSlice returns the strings in a list, skipping other elements. It
+// returns an empty slice if the key is missing.
+func (c Config) StringSlice(key string) []string {
+	values := []string{}
+	switch list := c[key].(type) {
+	case []string:
+		values = append(values, list...)
+	case []interface{}:
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// StringMap returns an object with every value formatted as a string. It
+// returns an empty map if the key is missing.
+func (c Config) StringMap(key string) map[string]string {
+	values := make(map[string]string)
+	for k, v := range c.Map(key) {
+		if s, ok := v.(string); ok {
+			values[k] = s
+		} else {
+			values[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return values
+}
+
+// Map returns a nested object as a Config, or an empty Config if the key is
+// missing, so lookups can be chained: cfg.Map("auth").String("user", "")
+func (c Config) Map(key string) Config {
+	switch val := c[key].(type) {
+	case map[string]interface{}:
+		return Config(val)
+	case Config:
+		return val
+	}
+	return Config{}
+}
+
+// Maps returns the objects in a list as Configs, skipping other elements
+func (c Config) Maps(key string) []Config {
+	values := []Config{}
+	if list, ok := c[key].([]interface{}); ok {
+		for _, v := range list {
+			if m, ok := v.(map[string]interface{}); ok {
+				values = append(values, Config(m))
+			}
+		}
+	}
+	return values
+}
+
+// Slice returns a list value, or nil if the key is missing or not a list
+func (c Config) Slice(key string) []interface{} {
+	list, _ := c[key].([]interface{})
+	return list
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,49 @@
+package sdk
+
+// Coverage is the code coverage measured by a build's tests. Coverage plugins
+// return it in Result.Coverage; the worker agent uploads it so the API server
+// can track coverage across a job's builds.
+type Coverage struct {
+	Format          string // report format, such as "cobertura" or "lcov"
+	LinesCovered    int
+	LinesTotal      int
+	BranchesCovered int
+	BranchesTotal   int // 0 if the report has no branch data
+}
+
+// LinePercent returns the percentage of lines covered, or 0 without lines
+func (c Coverage) LinePercent() float64 {
+	return percent(c.LinesCovered, c.LinesTotal)
+}
+
+// BranchPercent returns the percentage of branches covered, or 0 without branches
+func (c Coverage) BranchPercent() float64 {
+	return percent(c.BranchesCovered, c.BranchesTotal)
+}
+
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// CoverageBaseline returns the coverage a build is compared against, passed
+// by the worker agent in the coverage_baseline parameter: that of the job's
+// last successful build on the same branch, or else on the job's default
+// branch. ok is false if no such build reported coverage.
+func CoverageBaseline(ctx *ExecutionContext) (c Coverage, ok bool) {
+	m, isMap := ctx.Parameters["coverage_baseline"].(map[string]interface{})
+	if !isMap {
+		return c, false
+	}
+	cfg := Config(m)
+	c = Coverage{
+		Format:          cfg.String("format", ""),
+		LinesCovered:    cfg.Int("lines_covered", 0),
+		LinesTotal:      cfg.Int("lines_total", 0),
+		BranchesCovered: cfg.Int("branches_covered", 0),
+		BranchesTotal:   cfg.Int("branches_total", 0),
+	}
+	return c, true
+}
@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Finding severities, from most to least severe
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+	SeverityInfo     = "info"
+)
+
+// Finding is a security issue reported by a scanner. Plugins return findings
+// in Result.Findings; the worker agent uploads them with the build so they
+// can be queried and filtered regardless of which tool reported them.
+type Finding struct {
+	Tool        string // scanner that reported the finding; defaults to the step's plugin
+	RuleID      string // scanner rule or vulnerability ID, such as "CVE-2023-1234"
+	Title       string
+	Description string
+	Severity    string // one of the Severity constants
+	Location    Location
+	CWEs        []string // such as "CWE-79"
+	CVEs        []string // such as "CVE-2023-1234"
+	HelpURL     string
+	Fingerprint string // stable ID across builds; derived from the tool, rule and location if empty
+}
+
+// Location is where a finding was found. Source findings set Path and
+// lines, dependency findings Package and Version, and DAST findings URI.
+type Location struct {
+	Path      string
+	StartLine int
+	EndLine   int
+	URI       string
+	Package   string
+	Version   string
+}
+
+var severityRank = map[string]int{
+	SeverityInfo:     0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// NormalizeSeverity maps a scanner's severity, either a name such as
+// "HIGH", "Moderate" or "Informational", or a CVSS score such as "7.5", to
+// one of the Severity constants. Unknown severities are SeverityInfo.
+func NormalizeSeverity(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if score, err := strconv.ParseFloat(s, 64); err == nil {
+		return SeverityFromScore(score)
+	}
+	switch s {
+	case "critical", "blocker":
+		return SeverityCritical
+	case "high", "error", "major":
+		return SeverityHigh
+	case "medium", "moderate", "warning":
+		return SeverityMedium
+	case "low", "minor", "note":
+		return SeverityLow
+	}
+	return SeverityInfo
+}
+
+// SeverityFromScore maps a CVSS score to a severity
+func SeverityFromScore(score float64) string {
+	switch {
+	case score >= 9:
+		return SeverityCritical
+	case score >= 7:
+		return SeverityHigh
+	case score >= 4:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	}
+	return SeverityInfo
+}
+
+// SeverityAtLeast reports whether severity is at least as severe as min
+func SeverityAtLeast(severity, min string) bool {
+	return severityRank[NormalizeSeverity(severity)] >= severityRank[NormalizeSeverity(min)]
+}
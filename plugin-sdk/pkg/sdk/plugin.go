@@ -1,5 +1,11 @@
 package sdk
 
+import (
+	"context"
+	"io"
+	"sync"
+)
+
 // Plugin is the base interface all plugins must implement
 type Plugin interface {
 	// Name returns the plugin name
@@ -8,30 +14,80 @@ type Plugin interface {
 	// Version returns the plugin version
 	Version() string
 
-	// Type returns the plugin type (scm, build, artifact, notification, deployment)
+	// Type returns the plugin type (scm, build, artifact, notification,
+	// deployment, secret, cache)
 	Type() string
 
+	// Capabilities lists what the plugin needs from the worker agent (see the
+	// Capability constants). A step fails before the build starts if the
+	// plugin's sandbox policy or step grants cannot provide one of them.
+	Capabilities() []string
+
 	// Initialize initializes the plugin with configuration
 	Initialize(config map[string]interface{}) error
 
-	// Execute executes the plugin
+	// Health reports whether the plugin can run with its configuration, for
+	// example that an external tool is installed or a server is reachable.
+	// It is called after Initialize, before the build starts.
+	Health() error
+
+	// Execute executes the plugin. It should return promptly once the
+	// ExecutionContext is done, which happens when the build is cancelled or
+	// the step's deadline passes.
 	Execute(context *ExecutionContext) (*Result, error)
 
 	// Cleanup performs cleanup after execution
 	Cleanup() error
 }
 
-// ExecutionContext provides context for plugin execution
+// Capabilities a plugin can require
+const (
+	// CapabilityNetwork is network access beyond Unix domain sockets. WASM
+	// plugins never have it.
+	CapabilityNetwork = "network"
+	// CapabilityWorkspace is write access to the build workspace
+	CapabilityWorkspace = "workspace"
+	// CapabilityWorkspaceReadOnly is read access to the build workspace
+	CapabilityWorkspaceReadOnly = "workspace:ro"
+	// CapabilityEnv is access to the build environment variables
+	CapabilityEnv = "env"
+	// CapabilitySecrets is access to build secrets
+	CapabilitySecrets = "secrets"
+)
+
+// ExecutionContext provides context for plugin execution. It is a
+// context.Context that is done when the build is cancelled or the step's
+// deadline passes; pass it to exec.CommandContext, HTTP requests and other
+// blocking calls.
 type ExecutionContext struct {
+	context.Context
+
 	BuildID    string
 	JobID      string
 	WorkDir    string
 	EnvVars    map[string]string
 	Parameters map[string]interface{}
-	Secrets    map[string]string
 	Logger     Logger
+
+	// Secrets holds the step's static secrets. Use Secret and SecretNames to
+	// also reach secrets the step references through a secret provider.
+	Secrets map[string]string
+
+	// Output receives console output, such as the stdout and stderr of a tool
+	// the plugin runs. Each line appears in the build log as it is written.
+	Output io.Writer
+
+	// Secrets resolved through the worker agent on first use
+	resolvable    []string
+	resolveSecret func(ctx context.Context, name string) (string, error)
+	secretMu      sync.Mutex
+	resolved      map[string]string
 }
 
+// MetadataTags is the Result.Metadata key of the tags a plugin adds to its
+// build, as a []string, e.g. "release-candidate"
+const MetadataTags = "tags"
+
 // Result contains the result of plugin execution
 type Result struct {
 	Success      bool
@@ -40,6 +96,11 @@ type Result struct {
 	Output       string
 	Artifacts    []Artifact
 	Metadata     map[string]interface{}
+	Findings     []Finding    // security findings, for scanner plugins
+	TestCases    []TestCase   // test outcomes, for test plugins
+	Coverage     *Coverage    // code coverage, for coverage plugins
+	Commit       *CommitInfo  // the checked out commit, for SCM plugins
+	Annotations  []Annotation // problems at lines of files, for lint and test plugins
 }
 
 // Artifact represents a build artifact
@@ -51,7 +112,8 @@ type Artifact struct {
 	Metadata       map[string]string
 }
 
-// Logger interface for plugin logging
+// Logger interface for plugin logging. Messages appear in the build log as
+// they are logged.
 type Logger interface {
 	Debug(msg string, fields ...interface{})
 	Info(msg string, fields ...interface{})
@@ -62,38 +124,39 @@ type Logger interface {
 // SCMPlugin interface for source control plugins
 type SCMPlugin interface {
 	Plugin
-	Clone(url, branch, commitSHA string, dest string) error
-	GetCommitInfo(commitSHA string) (*CommitInfo, error)
+	Clone(ctx context.Context, url, branch, commitSHA string, dest string) error
+	GetCommitInfo(ctx context.Context, commitSHA string) (*CommitInfo, error)
 }
 
 // CommitInfo contains commit metadata
 type CommitInfo struct {
-	SHA       string
-	Message   string
-	Author    string
-	Email     string
-	Timestamp string
+	SHA          string
+	Message      string
+	Author       string
+	Email        string
+	Timestamp    string   // RFC 3339
+	ChangedFiles []string // paths changed since the previous build's commit
 }
 
 // BuildPlugin interface for build tool plugins
 type BuildPlugin interface {
 	Plugin
-	Build() error
-	Test() error
+	Build(ctx context.Context) error
+	Test(ctx context.Context) error
 }
 
 // ArtifactPlugin interface for artifact storage plugins
 type ArtifactPlugin interface {
 	Plugin
-	Upload(artifact *Artifact) (string, error)
-	Download(url string, dest string) error
-	Promote(artifactID, fromEnv, toEnv string) error
+	Upload(ctx context.Context, artifact *Artifact) (string, error)
+	Download(ctx context.Context, url string, dest string) error
+	Promote(ctx context.Context, artifactID, fromEnv, toEnv string) error
 }
 
 // NotificationPlugin interface for notification plugins
 type NotificationPlugin interface {
 	Plugin
-	Notify(message *NotificationMessage) error
+	Notify(ctx context.Context, message *NotificationMessage) error
 }
 
 // NotificationMessage contains notification details
@@ -123,12 +186,36 @@ type Field struct {
 	Short bool
 }
 
+// SecretProviderPlugin resolves secret references from an external store such
+// as Vault or a cloud secret manager. Steps reference secrets as
+// "<provider>:<reference>", where the provider is registered with the worker
+// agent, and the agent calls ResolveSecret with the reference the first time a
+// plugin asks for the secret. Secret providers are not run as build steps, so
+// Execute is never called.
+type SecretProviderPlugin interface {
+	Plugin
+	ResolveSecret(ctx context.Context, ref string) (string, error)
+}
+
+// CachePlugin stores dependency caches, such as module downloads or package
+// manager stores, so builds can reuse them. An entry is a directory stored
+// under a key: Save stores the directory src, Restore fills dest with the
+// entry and reports whether there was one, and Exists checks for an entry
+// without fetching it. WriteCacheArchive and ReadCacheArchive help backends
+// that store entries as blobs.
+type CachePlugin interface {
+	Plugin
+	Save(ctx context.Context, key string, src string) error
+	Restore(ctx context.Context, key string, dest string) (bool, error)
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
 // DeploymentPlugin interface for deployment plugins
 type DeploymentPlugin interface {
 	Plugin
-	Deploy(deployment *DeploymentRequest) (*DeploymentResult, error)
-	Rollback(deploymentID string) error
-	GetStatus(deploymentID string) (*DeploymentStatus, error)
+	Deploy(ctx context.Context, deployment *DeploymentRequest) (*DeploymentResult, error)
+	Rollback(ctx context.Context, deploymentID string) error
+	GetStatus(ctx context.Context, deploymentID string) (*DeploymentStatus, error)
 }
 
 // DeploymentRequest contains deployment details
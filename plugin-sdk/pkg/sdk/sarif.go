@@ -0,0 +1,174 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	cwePattern = regexp.MustCompile(`(?i)\bcwe[-/:]?(\d+)\b`)
+	cvePattern = regexp.MustCompile(`(?i)\bCVE-\d{4}-\d{4,}\b`)
+)
+
+// sarifLog is the subset of a SARIF 2.1.0 log that findings are read from
+type sarifLog struct {
+	Version string `json:"version"`
+	Runs    []struct {
+		Tool struct {
+			Driver struct {
+				Name  string      `json:"name"`
+				Rules []sarifRule `json:"rules"`
+			} `json:"driver"`
+		} `json:"tool"`
+		Results []struct {
+			RuleID    string `json:"ruleId"`
+			RuleIndex *int   `json:"ruleIndex"`
+			Level     string `json:"level"`
+			Message   struct {
+				Text string `json:"text"`
+			} `json:"message"`
+			Locations []struct {
+				PhysicalLocation struct {
+					ArtifactLocation struct {
+						URI string `json:"uri"`
+					} `json:"artifactLocation"`
+					Region struct {
+						StartLine int `json:"startLine"`
+						EndLine   int `json:"endLine"`
+					} `json:"region"`
+				} `json:"physicalLocation"`
+			} `json:"locations"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"results"`
+	} `json:"runs"`
+}
+
+type sarifRule struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	ShortDescription struct {
+		Text string `json:"text"`
+	} `json:"shortDescription"`
+	FullDescription struct {
+		Text string `json:"text"`
+	} `json:"fullDescription"`
+	HelpURI              string `json:"helpUri"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// ParseSARIF reads findings from a SARIF 2.1.0 log, as written by most
+// static analysis and dependency scanners. Severities come from the
+// "security-severity" property GitHub code scanning uses, falling back to
+// the result's level; CWEs come from rule tags such as "external/cwe/cwe-79".
+func ParseSARIF(r io.Reader) ([]Finding, error) {
+	var log sarifLog
+	if err := json.NewDecoder(r).Decode(&log); err != nil {
+		return nil, fmt.Errorf("invalid SARIF log: %w", err)
+	}
+	if log.Version != "" && !strings.HasPrefix(log.Version, "2.") {
+		return nil, fmt.Errorf("unsupported SARIF version %s", log.Version)
+	}
+
+	var findings []Finding
+	for _, run := range log.Runs {
+		rules := make(map[string]sarifRule, len(run.Tool.Driver.Rules))
+		for _, rule := range run.Tool.Driver.Rules {
+			rules[rule.ID] = rule
+		}
+
+		for _, result := range run.Results {
+			var rule sarifRule
+			if result.RuleIndex != nil && *result.RuleIndex >= 0 && *result.RuleIndex < len(run.Tool.Driver.Rules) {
+				rule = run.Tool.Driver.Rules[*result.RuleIndex]
+			} else {
+				rule = rules[result.RuleID]
+			}
+			ruleID := result.RuleID
+			if ruleID == "" {
+				ruleID = rule.ID
+			}
+
+			f := Finding{
+				Tool:        run.Tool.Driver.Name,
+				RuleID:      ruleID,
+				Title:       firstNonEmpty(rule.ShortDescription.Text, rule.Name, result.Message.Text),
+				Description: firstNonEmpty(result.Message.Text, rule.FullDescription.Text),
+				Severity:    sarifSeverity(result.Level, result.Properties, rule),
+				HelpURL:     rule.HelpURI,
+			}
+			if len(result.Locations) > 0 {
+				loc := result.Locations[0].PhysicalLocation
+				f.Location.Path = loc.ArtifactLocation.URI
+				f.Location.StartLine = loc.Region.StartLine
+				f.Location.EndLine = loc.Region.EndLine
+			}
+			for _, tag := range stringList(rule.Properties["tags"]) {
+				if m := cwePattern.FindStringSubmatch(tag); m != nil {
+					n, _ := strconv.Atoi(m[1]) // tags may be zero-padded, as in "cwe-089"
+					f.CWEs = appendUnique(f.CWEs, "CWE-"+strconv.Itoa(n))
+				}
+			}
+			for _, id := range cvePattern.FindAllString(ruleID+" "+f.Title, -1) {
+				f.CVEs = appendUnique(f.CVEs, strings.ToUpper(id))
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// sarifSeverity prefers a security-severity score on the result or rule,
+// then the result's level, then the rule's default level
+func sarifSeverity(level string, properties map[string]interface{}, rule sarifRule) string {
+	for _, props := range []map[string]interface{}{properties, rule.Properties} {
+		if score, ok := props["security-severity"]; ok {
+			return NormalizeSeverity(fmt.Sprint(score))
+		}
+	}
+	if level == "" {
+		level = rule.DefaultConfiguration.Level
+	}
+	switch level {
+	case "error":
+		return SeverityHigh
+	case "", "warning":
+		return SeverityMedium // "warning" is SARIF's default level
+	case "note":
+		return SeverityLow
+	}
+	return SeverityInfo
+}
+
+func stringList(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func appendUnique(list []string, s string) []string {
+	if containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
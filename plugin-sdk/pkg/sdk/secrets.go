@@ -0,0 +1,65 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Secret returns a secret by name. Static secrets are returned directly;
+// secrets the step references through a secret provider are resolved by the
+// worker agent the first time they are requested and then cached. Secrets
+// are only available to plugins granted the secrets capability.
+func (c *ExecutionContext) Secret(name string) (string, error) {
+	if value, ok := c.Secrets[name]; ok {
+		return value, nil
+	}
+
+	c.secretMu.Lock()
+	defer c.secretMu.Unlock()
+	if value, ok := c.resolved[name]; ok {
+		return value, nil
+	}
+	if c.resolveSecret == nil || !containsString(c.resolvable, name) {
+		return "", fmt.Errorf("secret %s is not available to this step", name)
+	}
+
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	value, err := c.resolveSecret(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %s: %w", name, err)
+	}
+	if c.resolved == nil {
+		c.resolved = make(map[string]string)
+	}
+	c.resolved[name] = value
+	return value, nil
+}
+
+// SecretNames returns the sorted names of every secret available to the step,
+// including those not resolved yet
+func (c *ExecutionContext) SecretNames() []string {
+	names := make([]string, 0, len(c.Secrets)+len(c.resolvable))
+	for name := range c.Secrets {
+		names = append(names, name)
+	}
+	for _, name := range c.resolvable {
+		if _, ok := c.Secrets[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
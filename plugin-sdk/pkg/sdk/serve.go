@@ -0,0 +1,407 @@
+//go:build !wasip1
+
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Handshake is shared with the worker agent. Bump ProtocolVersion on any
+// breaking change to the wire types in wire.go.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "SOLVYD_PLUGIN",
+	MagicCookieValue: "8f6d2c1e-solvyd-plugin",
+}
+
+// PluginName is the name the plugin is dispensed under
+const PluginName = "plugin"
+
+// serviceName is the gRPC service implemented by every plugin. Messages are
+// google.protobuf.Struct values holding the JSON wire types in wire.go, so no
+// generated code is required on either side.
+const serviceName = "solvyd.plugin.v1.PluginService"
+
+// secretServiceName is the gRPC service the worker agent serves over the
+// go-plugin broker while a step runs, through which plugins resolve secrets
+// that come from a secret provider
+const secretServiceName = "solvyd.plugin.v1.SecretService"
+
+// Serve runs p as a plugin process. Call it from the plugin's main function;
+// it blocks until the worker agent disconnects.
+func Serve(p Plugin) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         plugin.PluginSet{PluginName: &grpcPlugin{impl: p}},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
+
+// grpcPlugin adapts a Plugin to go-plugin's gRPC transport
+type grpcPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	impl Plugin
+}
+
+func (p *grpcPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&pluginServiceDesc, &grpcServer{impl: p.impl, broker: broker})
+	return nil
+}
+
+func (p *grpcPlugin) GRPCClient(context.Context, *plugin.GRPCBroker, *grpc.ClientConn) (interface{}, error) {
+	return nil, fmt.Errorf("the plugin SDK only implements the plugin side")
+}
+
+// grpcServer dispatches RPCs to the plugin implementation
+type grpcServer struct {
+	impl   Plugin
+	broker *plugin.GRPCBroker
+}
+
+func (s *grpcServer) info(_ context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(wireInfo{Name: s.impl.Name(), Version: s.impl.Version(), Type: s.impl.Type()})
+}
+
+func (s *grpcServer) capabilities(_ context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(wireCapabilities{Capabilities: s.impl.Capabilities()})
+}
+
+func (s *grpcServer) initialize(_ context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var req wireConfig
+	if err := fromStruct(in, &req); err != nil {
+		return nil, err
+	}
+	if req.Config == nil {
+		req.Config = map[string]interface{}{}
+	}
+	return toStruct(wireError{Error: errorString(s.impl.Initialize(req.Config))})
+}
+
+func (s *grpcServer) health(_ context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(wireError{Error: errorString(s.impl.Health())})
+}
+
+// execute runs the plugin and returns its logs with the result. Agents that
+// support ExecuteStream only use it for plugins built before streaming.
+func (s *grpcServer) execute(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var req wireContext
+	if err := fromStruct(in, &req); err != nil {
+		return nil, err
+	}
+
+	logger := &collectingLogger{}
+	output := logger.output()
+	secrets := s.secretClient(req)
+	defer secrets.close()
+	result, err := s.impl.Execute(newExecutionContext(ctx, req, logger, output, secrets))
+	output.flush()
+	return toStruct(newWireResult(result, err, logger.entries()))
+}
+
+// executeStream runs the plugin, sending each log entry and output line to
+// the agent as it happens and the result last
+func executeStream(srv interface{}, stream grpc.ServerStream) error {
+	s := srv.(*grpcServer)
+	in := new(structpb.Struct)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	var req wireContext
+	if err := fromStruct(in, &req); err != nil {
+		return err
+	}
+
+	logger := &streamingLogger{stream: stream}
+	output := &lineWriter{emit: func(line string) {
+		logger.send(wireLogEntry{Level: outputLevel, Message: line})
+	}}
+	secrets := s.secretClient(req)
+	defer secrets.close()
+	result, err := s.impl.Execute(newExecutionContext(stream.Context(), req, logger, output, secrets))
+	output.flush()
+
+	if err := logger.sendErr(); err != nil {
+		return err
+	}
+	out := newWireResult(result, err, nil)
+	msg, err := toStruct(wireStreamMessage{Result: &out})
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(msg)
+}
+
+// newExecutionContext builds the plugin's view of a request. ctx is the RPC's
+// context, which the agent cancels when the build is cancelled and which
+// carries the step's deadline.
+func newExecutionContext(ctx context.Context, req wireContext, logger Logger, output io.Writer, secrets *secretClient) *ExecutionContext {
+	execCtx := &ExecutionContext{
+		Context:    ctx,
+		BuildID:    req.BuildID,
+		JobID:      req.JobID,
+		WorkDir:    req.WorkDir,
+		EnvVars:    orEmptyStrings(req.EnvVars),
+		Parameters: orEmptyParams(req.Parameters),
+		Secrets:    orEmptyStrings(req.Secrets),
+		Logger:     logger,
+		Output:     output,
+	}
+	if secrets != nil {
+		execCtx.resolvable = req.ResolvableSecrets
+		execCtx.resolveSecret = secrets.get
+	}
+	return execCtx
+}
+
+// resolveSecret resolves a secret reference for the agent when the plugin is
+// a secret provider
+func (s *grpcServer) resolveSecret(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	provider, ok := s.impl.(SecretProviderPlugin)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin is not a secret provider")
+	}
+	var req wireSecretRequest
+	if err := fromStruct(in, &req); err != nil {
+		return nil, err
+	}
+	value, err := provider.ResolveSecret(ctx, req.Ref)
+	return toStruct(wireSecret{Value: value, Error: errorString(err)})
+}
+
+// cache returns the plugin as a CachePlugin and decodes a cache request
+func (s *grpcServer) cache(in *structpb.Struct) (CachePlugin, wireCacheRequest, error) {
+	var req wireCacheRequest
+	cache, ok := s.impl.(CachePlugin)
+	if !ok {
+		return nil, req, status.Error(codes.Unimplemented, "plugin is not a cache plugin")
+	}
+	return cache, req, fromStruct(in, &req)
+}
+
+func (s *grpcServer) cacheSave(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	cache, req, err := s.cache(in)
+	if err != nil {
+		return nil, err
+	}
+	return toStruct(wireCacheResponse{Error: errorString(cache.Save(ctx, req.Key, req.Path))})
+}
+
+func (s *grpcServer) cacheRestore(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	cache, req, err := s.cache(in)
+	if err != nil {
+		return nil, err
+	}
+	found, err := cache.Restore(ctx, req.Key, req.Path)
+	return toStruct(wireCacheResponse{Found: found, Error: errorString(err)})
+}
+
+func (s *grpcServer) cacheExists(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	cache, req, err := s.cache(in)
+	if err != nil {
+		return nil, err
+	}
+	found, err := cache.Exists(ctx, req.Key)
+	return toStruct(wireCacheResponse{Found: found, Error: errorString(err)})
+}
+
+func (s *grpcServer) cleanup(_ context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	return toStruct(wireError{Error: errorString(s.impl.Cleanup())})
+}
+
+var pluginServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Info", (*grpcServer).info),
+		unaryMethod("Capabilities", (*grpcServer).capabilities),
+		unaryMethod("Initialize", (*grpcServer).initialize),
+		unaryMethod("Health", (*grpcServer).health),
+		unaryMethod("Execute", (*grpcServer).execute),
+		unaryMethod("Cleanup", (*grpcServer).cleanup),
+		unaryMethod("ResolveSecret", (*grpcServer).resolveSecret),
+		unaryMethod("CacheSave", (*grpcServer).cacheSave),
+		unaryMethod("CacheRestore", (*grpcServer).cacheRestore),
+		unaryMethod("CacheExists", (*grpcServer).cacheExists),
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "ExecuteStream", Handler: executeStream, ServerStreams: true},
+	},
+	Metadata: "solvyd/plugin/v1",
+}
+
+func unaryMethod(name string, call func(*grpcServer, context.Context, *structpb.Struct) (*structpb.Struct, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(structpb.Struct)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(*grpcServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+			return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(*grpcServer), ctx, req.(*structpb.Struct))
+			})
+		},
+	}
+}
+
+// secretClient fetches secrets from the agent's SecretService, connecting over
+// the broker the first time a secret is requested
+type secretClient struct {
+	broker *plugin.GRPCBroker
+	id     uint32
+
+	once sync.Once
+	conn *grpc.ClientConn
+	err  error
+}
+
+// secretClient returns a client for the request's SecretService, or nil if the
+// step has no secrets to resolve
+func (s *grpcServer) secretClient(req wireContext) *secretClient {
+	if s.broker == nil || req.SecretBroker == 0 || len(req.ResolvableSecrets) == 0 {
+		return nil
+	}
+	return &secretClient{broker: s.broker, id: req.SecretBroker}
+}
+
+func (c *secretClient) get(ctx context.Context, name string) (string, error) {
+	c.once.Do(func() {
+		c.conn, c.err = c.broker.Dial(c.id)
+	})
+	if c.err != nil {
+		return "", fmt.Errorf("failed to connect to the worker agent: %w", c.err)
+	}
+
+	in, err := toStruct(wireSecretRequest{Name: name})
+	if err != nil {
+		return "", err
+	}
+	out := new(structpb.Struct)
+	if err := c.conn.Invoke(ctx, "/"+secretServiceName+"/GetSecret", in, out); err != nil {
+		return "", err
+	}
+	var resp wireSecret
+	if err := fromStruct(out, &resp); err != nil {
+		return "", err
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.Value, nil
+}
+
+func (c *secretClient) close() {
+	if c != nil && c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// collectingLogger buffers plugin log calls and returns them with the result
+type collectingLogger struct {
+	mu  sync.Mutex
+	log []wireLogEntry
+}
+
+func (l *collectingLogger) Debug(msg string, fields ...interface{}) { l.add("debug", msg, fields) }
+func (l *collectingLogger) Info(msg string, fields ...interface{})  { l.add("info", msg, fields) }
+func (l *collectingLogger) Warn(msg string, fields ...interface{})  { l.add("warn", msg, fields) }
+func (l *collectingLogger) Error(msg string, fields ...interface{}) { l.add("error", msg, fields) }
+
+func (l *collectingLogger) add(level, msg string, fields []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log = append(l.log, wireLogEntry{Level: level, Message: msg, Fields: fieldMap(fields)})
+}
+
+func (l *collectingLogger) entries() []wireLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]wireLogEntry{}, l.log...)
+}
+
+// output collects lines written to ExecutionContext.Output as log entries
+func (l *collectingLogger) output() *lineWriter {
+	return &lineWriter{emit: func(line string) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.log = append(l.log, wireLogEntry{Level: outputLevel, Message: line})
+	}}
+}
+
+// streamingLogger sends log calls to the agent as they happen
+type streamingLogger struct {
+	mu     sync.Mutex
+	stream grpc.ServerStream
+	err    error // first send error; later messages are dropped
+}
+
+func (l *streamingLogger) Debug(msg string, fields ...interface{}) { l.add("debug", msg, fields) }
+func (l *streamingLogger) Info(msg string, fields ...interface{})  { l.add("info", msg, fields) }
+func (l *streamingLogger) Warn(msg string, fields ...interface{})  { l.add("warn", msg, fields) }
+func (l *streamingLogger) Error(msg string, fields ...interface{}) { l.add("error", msg, fields) }
+
+func (l *streamingLogger) add(level, msg string, fields []interface{}) {
+	l.send(wireLogEntry{Level: level, Message: msg, Fields: fieldMap(fields)})
+}
+
+func (l *streamingLogger) send(entry wireLogEntry) {
+	msg, err := toStruct(wireStreamMessage{Log: &entry})
+	if err != nil {
+		// Fields that cannot be encoded are dropped rather than losing the message
+		entry.Fields = nil
+		msg, err = toStruct(wireStreamMessage{Log: &entry})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	if err == nil {
+		err = l.stream.SendMsg(msg)
+	}
+	l.err = err
+}
+
+func (l *streamingLogger) sendErr() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// toStruct converts a JSON-serializable value to a protobuf Struct
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+// fromStruct decodes a protobuf Struct into a JSON-tagged value
+func fromStruct(s *structpb.Struct, v interface{}) error {
+	data, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
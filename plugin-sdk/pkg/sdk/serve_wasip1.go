@@ -0,0 +1,155 @@
+//go:build wasip1
+
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+// Host functions provided by the worker agent's WASM runtime. get_secret is
+// only granted to steps with the "secrets" capability; it returns static
+// secrets and resolves secrets that come from a secret provider.
+
+//go:wasmimport solvyd log
+func hostLog(ptr unsafe.Pointer, size uint32)
+
+//go:wasmimport solvyd set_result
+func hostSetResult(ptr unsafe.Pointer, size uint32)
+
+//go:wasmimport solvyd get_secret
+func hostGetSecret(namePtr unsafe.Pointer, nameLen uint32, bufPtr unsafe.Pointer, bufLen uint32) int32
+
+// Serve runs p as a WASM plugin. The request is read from stdin, the plugin is
+// initialized, health-checked, executed and cleaned up, and the outcome is
+// handed to the host.
+func Serve(p Plugin) {
+	var req wasmRequest
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, "This binary is a Solvyd plugin and must be run by the worker agent")
+		os.Exit(1)
+	}
+
+	resp := wasmResponse{
+		Info:         wireInfo{Name: p.Name(), Version: p.Version(), Type: p.Type()},
+		Capabilities: p.Capabilities(),
+	}
+	defer setResult(&resp)
+
+	if resp.MissingCapabilities = missingCapabilities(resp.Capabilities, req.Capabilities); len(resp.MissingCapabilities) > 0 {
+		return
+	}
+
+	config := req.Config
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	if err := p.Initialize(config); err != nil {
+		resp.InitializeError = err.Error()
+		return
+	}
+	if err := p.Health(); err != nil {
+		resp.HealthError = err.Error()
+		resp.CleanupError = errorString(p.Cleanup())
+		return
+	}
+
+	secrets := map[string]string{}
+	for _, name := range req.SecretNames {
+		if value, ok := getSecret(name); ok {
+			secrets[name] = value
+		}
+	}
+
+	// The host stops the module when the build is cancelled, so there is
+	// nothing to cancel inside it
+	// Secrets from a secret provider are resolved by the host on first use
+	result, err := p.Execute(&ExecutionContext{
+		Context:    context.Background(),
+		BuildID:    req.Context.BuildID,
+		JobID:      req.Context.JobID,
+		WorkDir:    req.Context.WorkDir,
+		EnvVars:    orEmptyStrings(req.Context.EnvVars),
+		Parameters: orEmptyParams(req.Context.Parameters),
+		Secrets:    secrets,
+		Logger:     hostLogger{},
+		Output:     os.Stdout, // streamed to the build log by the host
+		resolvable: req.Context.ResolvableSecrets,
+		resolveSecret: func(_ context.Context, name string) (string, error) {
+			value, ok := getSecret(name)
+			if !ok {
+				return "", fmt.Errorf("the worker agent could not resolve it")
+			}
+			return value, nil
+		},
+	})
+	out := newWireResult(result, err, nil)
+	resp.Result = &out
+
+	resp.CleanupError = errorString(p.Cleanup())
+}
+
+// missingCapabilities returns the required capabilities the step does not
+// grant. Network access is never granted to WASM plugins.
+func missingCapabilities(required, granted []string) []string {
+	var missing []string
+	for _, c := range required {
+		found := false
+		for _, g := range granted {
+			if g == c || (c == CapabilityWorkspaceReadOnly && g == CapabilityWorkspace) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+func setResult(resp *wasmResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(wasmResponse{Info: resp.Info, InitializeError: err.Error()})
+	}
+	hostSetResult(unsafe.Pointer(&data[0]), uint32(len(data)))
+}
+
+// getSecret asks the host for a secret, growing the buffer if it is too small
+func getSecret(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+	nameBytes := []byte(name)
+	buf := make([]byte, 256)
+	for {
+		n := hostGetSecret(unsafe.Pointer(&nameBytes[0]), uint32(len(nameBytes)), unsafe.Pointer(&buf[0]), uint32(len(buf)))
+		if n < 0 {
+			return "", false
+		}
+		if int(n) <= len(buf) {
+			return string(buf[:n]), true
+		}
+		buf = make([]byte, n)
+	}
+}
+
+// hostLogger forwards log calls to the host as they happen
+type hostLogger struct{}
+
+func (hostLogger) Debug(msg string, fields ...interface{}) { sendLog("debug", msg, fields) }
+func (hostLogger) Info(msg string, fields ...interface{})  { sendLog("info", msg, fields) }
+func (hostLogger) Warn(msg string, fields ...interface{})  { sendLog("warn", msg, fields) }
+func (hostLogger) Error(msg string, fields ...interface{}) { sendLog("error", msg, fields) }
+
+func sendLog(level, msg string, fields []interface{}) {
+	data, err := json.Marshal(wireLogEntry{Level: level, Message: msg, Fields: fieldMap(fields)})
+	if err != nil {
+		data, _ = json.Marshal(wireLogEntry{Level: level, Message: msg})
+	}
+	hostLog(unsafe.Pointer(&data[0]), uint32(len(data)))
+}
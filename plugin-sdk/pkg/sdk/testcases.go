@@ -0,0 +1,25 @@
+package sdk
+
+import "time"
+
+// Test case outcomes
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"  // an assertion failed
+	TestError   = "error"   // the test could not run to completion
+	TestSkipped = "skipped" // disabled or skipped by the test itself
+)
+
+// TestCase is the outcome of one test. Test plugins return test cases in
+// Result.TestCases, usually only those that did not pass; the worker agent
+// uploads them with the build so the failing tests can be listed without
+// reading the build log.
+type TestCase struct {
+	Suite     string
+	ClassName string
+	Name      string
+	Status    string // one of the Test outcome constants
+	Message   string // short failure message
+	Details   string // failure output, such as a stack trace
+	Duration  time.Duration
+}
@@ -0,0 +1,275 @@
+package sdk
+
+import "fmt"
+
+// Wire types exchanged with the worker agent over gRPC or, for WASM plugins,
+// through the host functions in serve_wasip1.go
+
+type wireInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+}
+
+type wireContext struct {
+	BuildID    string                 `json:"build_id"`
+	JobID      string                 `json:"job_id"`
+	WorkDir    string                 `json:"work_dir"`
+	EnvVars    map[string]string      `json:"env_vars"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Secrets    map[string]string      `json:"secrets"`
+
+	// Secrets referenced through a secret provider, resolved on first use
+	ResolvableSecrets []string `json:"resolvable_secrets,omitempty"`
+	SecretBroker      uint32   `json:"secret_broker,omitempty"` // go-plugin broker ID of the agent's SecretService
+}
+
+type wireArtifact struct {
+	Name           string            `json:"name"`
+	Path           string            `json:"path"`
+	SizeBytes      int64             `json:"size_bytes"`
+	ChecksumSHA256 string            `json:"checksum_sha256"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+type wireFinding struct {
+	Tool        string       `json:"tool,omitempty"`
+	RuleID      string       `json:"rule_id"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Severity    string       `json:"severity"`
+	Location    wireLocation `json:"location"`
+	CWEs        []string     `json:"cwes,omitempty"`
+	CVEs        []string     `json:"cves,omitempty"`
+	HelpURL     string       `json:"help_url,omitempty"`
+	Fingerprint string       `json:"fingerprint,omitempty"`
+}
+
+type wireLocation struct {
+	Path      string `json:"path,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	URI       string `json:"uri,omitempty"`
+	Package   string `json:"package,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+type wireTestCase struct {
+	Suite      string `json:"suite,omitempty"`
+	ClassName  string `json:"class_name,omitempty"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	Details    string `json:"details,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type wireCoverage struct {
+	Format          string `json:"format,omitempty"`
+	LinesCovered    int    `json:"lines_covered"`
+	LinesTotal      int    `json:"lines_total"`
+	BranchesCovered int    `json:"branches_covered"`
+	BranchesTotal   int    `json:"branches_total"`
+}
+
+type wireAnnotation struct {
+	Path      string `json:"path,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	Severity  string `json:"severity"`
+	Title     string `json:"title,omitempty"`
+	Message   string `json:"message"`
+}
+
+type wireCommit struct {
+	SHA          string   `json:"sha"`
+	Message      string   `json:"message,omitempty"`
+	Author       string   `json:"author,omitempty"`
+	Email        string   `json:"email,omitempty"`
+	Timestamp    string   `json:"timestamp,omitempty"`
+	ChangedFiles []string `json:"changed_files"`
+}
+
+type wireLogEntry struct {
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// outputLevel marks log entries holding a line written to ExecutionContext.Output
+const outputLevel = "output"
+
+// wireStreamMessage is one message of the ExecuteStream RPC: a log entry or
+// output line as it happens, and finally the result
+type wireStreamMessage struct {
+	Log    *wireLogEntry `json:"log,omitempty"`
+	Result *wireResult   `json:"result,omitempty"`
+}
+
+type wireResult struct {
+	Success      bool                   `json:"success"`
+	ExitCode     int                    `json:"exit_code"`
+	ErrorMessage string                 `json:"error_message"`
+	Output       string                 `json:"output"`
+	Artifacts    []wireArtifact         `json:"artifacts"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	Findings     []wireFinding          `json:"findings,omitempty"`
+	TestCases    []wireTestCase         `json:"test_cases,omitempty"`
+	Coverage     *wireCoverage          `json:"coverage,omitempty"`
+	Commit       *wireCommit            `json:"commit,omitempty"`
+	Annotations  []wireAnnotation       `json:"annotations,omitempty"`
+	Error        string                 `json:"error,omitempty"` // error returned by Execute
+	Logs         []wireLogEntry         `json:"logs"`            // empty when streamed
+}
+
+type wireError struct {
+	Error string `json:"error,omitempty"`
+}
+
+// wireSecretRequest asks the agent's SecretService for a secret by name, or a
+// secret provider plugin to resolve a reference
+type wireSecretRequest struct {
+	Name string `json:"name,omitempty"`
+	Ref  string `json:"ref,omitempty"`
+}
+
+type wireSecret struct {
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// wireCacheRequest is the request of the CacheSave, CacheRestore and
+// CacheExists RPCs. Path is the directory saved or restored into.
+type wireCacheRequest struct {
+	Key  string `json:"key"`
+	Path string `json:"path,omitempty"`
+}
+
+type wireCacheResponse struct {
+	Found bool   `json:"found,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type wireCapabilities struct {
+	Capabilities []string `json:"capabilities"`
+}
+
+type wireConfig struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+// newWireResult converts the outcome of Execute to its wire form
+func newWireResult(result *Result, err error, logs []wireLogEntry) wireResult {
+	out := wireResult{Error: errorString(err), Logs: logs}
+	if result != nil {
+		out.Success = result.Success
+		out.ExitCode = result.ExitCode
+		out.ErrorMessage = result.ErrorMessage
+		out.Output = result.Output
+		out.Metadata = result.Metadata
+		for _, a := range result.Artifacts {
+			out.Artifacts = append(out.Artifacts, wireArtifact(a))
+		}
+		for _, f := range result.Findings {
+			out.Findings = append(out.Findings, wireFinding{
+				Tool:        f.Tool,
+				RuleID:      f.RuleID,
+				Title:       f.Title,
+				Description: f.Description,
+				Severity:    NormalizeSeverity(f.Severity),
+				Location:    wireLocation(f.Location),
+				CWEs:        f.CWEs,
+				CVEs:        f.CVEs,
+				HelpURL:     f.HelpURL,
+				Fingerprint: f.Fingerprint,
+			})
+		}
+		for _, t := range result.TestCases {
+			out.TestCases = append(out.TestCases, wireTestCase{
+				Suite:      t.Suite,
+				ClassName:  t.ClassName,
+				Name:       t.Name,
+				Status:     t.Status,
+				Message:    t.Message,
+				Details:    t.Details,
+				DurationMs: t.Duration.Milliseconds(),
+			})
+		}
+		if result.Coverage != nil {
+			c := wireCoverage(*result.Coverage)
+			out.Coverage = &c
+		}
+		if result.Commit != nil {
+			c := wireCommit(*result.Commit)
+			out.Commit = &c
+		}
+		for _, a := range result.Annotations {
+			w := wireAnnotation(a)
+			if w.Severity == "" {
+				w.Severity = AnnotationNotice
+			}
+			if w.EndLine == 0 {
+				w.EndLine = w.StartLine
+			}
+			out.Annotations = append(out.Annotations, w)
+		}
+	}
+	return out
+}
+
+// fieldMap turns key/value pairs into a map; a trailing key without value is kept as-is
+func fieldMap(fields []interface{}) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{})
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if i+1 < len(fields) {
+			m[key] = fields[i+1]
+		} else {
+			m[key] = nil
+		}
+	}
+	return m
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func orEmptyStrings(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+func orEmptyParams(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
+
+// wasmRequest is written to a WASM plugin's stdin
+type wasmRequest struct {
+	Config       map[string]interface{} `json:"config"`
+	Context      wireContext            `json:"context"`
+	SecretNames  []string               `json:"secret_names"` // fetched through get_secret
+	Capabilities []string               `json:"capabilities"` // granted by the step
+}
+
+// wasmResponse is passed back to the host through set_result
+type wasmResponse struct {
+	Info                wireInfo    `json:"info"`
+	Capabilities        []string    `json:"capabilities,omitempty"`
+	MissingCapabilities []string    `json:"missing_capabilities,omitempty"` // the plugin is not run
+	InitializeError     string      `json:"initialize_error,omitempty"`
+	HealthError         string      `json:"health_error,omitempty"` // Execute is skipped
+	Result              *wireResult `json:"result,omitempty"`
+	CleanupError        string      `json:"cleanup_error,omitempty"`
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// ArtifactoryPublishPlugin uploads build outputs to a JFrog Artifactory
+// repository, tagging each file with the build's properties, and promotes
+// published builds between repositories.
+//
+// Files are laid out by the repository's package type: generic files under
+// path, Maven files under group_id/artifact_id/version, and an npm tarball
+// under package/-/. Every file gets the build.name, build.number,
+// vcs.revision and vcs.branch properties, plus those configured. With
+// action: promote the step uploads nothing and instead copies or moves the
+// path the build published to from one repository to another; from and to
+// name repositories directly or through the repositories map.
+//
+//	plugins:
+//	  - name: artifactory-publish
+//	    secrets: {ARTIFACTORY_TOKEN: "vault:secret/data/ci/artifactory#token"}
+//	    config:
+//	      url: https://acme.jfrog.io/artifactory
+//	      token_secret: ARTIFACTORY_TOKEN
+//	      repository: libs-snapshot-local
+//	      layout: maven
+//	      files: ["target/*.jar", "target/*.pom"]
+//	      group_id: com.acme
+//	      artifact_id: billing
+//	      version: "1.4.{{.BuildNumber}}"
+//	      properties: {team: payments}
+//	  - name: artifactory-publish
+//	    config:
+//	      url: https://acme.jfrog.io/artifactory
+//	      token_secret: ARTIFACTORY_TOKEN
+//	      action: promote
+//	      layout: maven
+//	      group_id: com.acme
+//	      artifact_id: billing
+//	      version: "1.4.{{.BuildNumber}}"
+//	      repositories: {staging: libs-snapshot-local, production: libs-release-local}
+//	      from: staging
+//	      to: production
+type ArtifactoryPublishPlugin struct {
+	url            string
+	tokenSecret    string // name of the secret holding an access token
+	username       string
+	passwordSecret string // name of the secret holding the password of username
+	repository     string
+	repositories   map[string]string // environment -> repository
+	layout         string
+	files          []string
+	path           *template.Template
+	groupID        string
+	artifactID     string
+	npmPackage     string
+	version        *template.Template
+	properties     map[string]*template.Template
+	action         string
+	from           string
+	to             string
+	copy           bool
+
+	// resolved during Execute
+	token    string
+	password string
+	dir      string // repository path the build's files are published under
+	build    buildInfo
+	matrix   string // properties as matrix parameters
+	client   *http.Client
+}
+
+// buildInfo is the data path, version and property templates are rendered with
+type buildInfo struct {
+	Job         string
+	BuildNumber int
+	Branch      string
+	Commit      string
+	ShortCommit string
+}
+
+func (p *ArtifactoryPublishPlugin) Name() string {
+	return "artifactory-publish"
+}
+
+func (p *ArtifactoryPublishPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *ArtifactoryPublishPlugin) Type() string {
+	return "artifact"
+}
+
+func (p *ArtifactoryPublishPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspaceReadOnly, sdk.CapabilitySecrets}
+}
+
+func (p *ArtifactoryPublishPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	if err := cfg.Require("url"); err != nil {
+		return err
+	}
+	p.url = strings.TrimSuffix(cfg.String("url", ""), "/")
+	p.tokenSecret = cfg.String("token_secret", "")
+	p.username = cfg.String("username", "")
+	p.passwordSecret = cfg.String("password_secret", "")
+	if p.tokenSecret == "" && (p.username == "" || p.passwordSecret == "") {
+		return fmt.Errorf("token_secret, or username and password_secret, is required")
+	}
+
+	p.repository = cfg.String("repository", "")
+	p.repositories = cfg.StringMap("repositories")
+	p.files = cfg.StringSlice("files")
+	if file := cfg.String("files", ""); file != "" {
+		p.files = []string{file}
+	}
+	p.groupID = cfg.String("group_id", "")
+	p.artifactID = cfg.String("artifact_id", "")
+	p.npmPackage = cfg.String("package", "")
+	p.copy = cfg.Bool("copy", false)
+
+	var err error
+	if p.path, err = template.New("path").Parse(cfg.String("path", "{{.Job}}/{{.BuildNumber}}")); err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+	if p.version, err = template.New("version").Parse(cfg.String("version", "")); err != nil {
+		return fmt.Errorf("invalid version: %w", err)
+	}
+	p.properties = map[string]*template.Template{}
+	for k, v := range cfg.StringMap("properties") {
+		if p.properties[k], err = template.New(k).Parse(v); err != nil {
+			return fmt.Errorf("invalid property %s: %w", k, err)
+		}
+	}
+
+	p.layout = cfg.String("layout", "generic")
+	switch p.layout {
+	case "generic":
+	case "maven":
+		if p.groupID == "" || p.artifactID == "" || !cfg.Has("version") {
+			return fmt.Errorf("the maven layout requires group_id, artifact_id and version")
+		}
+	case "npm":
+		if p.npmPackage == "" || !cfg.Has("version") {
+			return fmt.Errorf("the npm layout requires package and version")
+		}
+	default:
+		return fmt.Errorf("unknown layout %q: must be generic, maven or npm", p.layout)
+	}
+
+	p.action = cfg.String("action", "publish")
+	switch p.action {
+	case "publish":
+		if p.repository == "" {
+			return fmt.Errorf("repository is required")
+		}
+		if len(p.files) == 0 {
+			return fmt.Errorf("files is required")
+		}
+	case "promote":
+		p.from = cfg.String("from", "")
+		p.to = cfg.String("to", "")
+		if p.from == "" || p.to == "" {
+			return fmt.Errorf("promote requires from and to")
+		}
+	default:
+		return fmt.Errorf("unknown action %q: must be publish or promote", p.action)
+	}
+
+	p.client = &http.Client{Timeout: cfg.Duration("timeout", 10*time.Minute)}
+	return nil
+}
+
+func (p *ArtifactoryPublishPlugin) Health() error {
+	req, err := http.NewRequest(http.MethodGet, p.url+"/api/system/ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Artifactory is not reachable: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Artifactory ping returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *ArtifactoryPublishPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	if err := p.resolveCredentials(ctx); err != nil {
+		return failure(err.Error()), err
+	}
+
+	params := sdk.Config(ctx.Parameters)
+	p.build = buildInfo{
+		Job:         params.String("job_name", ""),
+		BuildNumber: params.Int("build_number", 0),
+		Branch:      params.String("branch", ""),
+		Commit:      params.String("commit_sha", ""),
+	}
+	p.build.ShortCommit = p.build.Commit
+	if len(p.build.ShortCommit) > 8 {
+		p.build.ShortCommit = p.build.ShortCommit[:8]
+	}
+	dir, err := p.publishDir()
+	if err != nil {
+		return failure(err.Error()), err
+	}
+	p.dir = dir
+
+	if p.action == "promote" {
+		// The npm layout shares a directory across versions, so only the
+		// build's tarball is promoted
+		promoted := p.dir
+		if p.layout == "npm" {
+			if promoted, err = p.target(".tgz"); err != nil {
+				return failure(err.Error()), err
+			}
+		}
+		ctx.Logger.Info(fmt.Sprintf("Promoting %s from %s to %s", promoted, p.from, p.to))
+		if err := p.Promote(ctx, promoted, p.from, p.to); err != nil {
+			return failure(fmt.Sprintf("Promotion failed: %v", err)), err
+		}
+		return &sdk.Result{
+			Success: true,
+			Metadata: map[string]interface{}{
+				"path":     promoted,
+				"from":     p.repositoryFor(p.from),
+				"to":       p.repositoryFor(p.to),
+				"promoted": true,
+			},
+		}, nil
+	}
+
+	if p.matrix, err = p.matrixParams(); err != nil {
+		return failure(err.Error()), err
+	}
+	paths, err := matchFiles(ctx.WorkDir, p.files)
+	if err != nil {
+		return failure(err.Error()), err
+	}
+
+	result := &sdk.Result{Success: true}
+	published := []string{}
+	for _, file := range paths {
+		artifact, err := newArtifact(file)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to read %s: %v", file, err)), err
+		}
+		location, err := p.Upload(ctx, &artifact)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to upload %s: %v", artifact.Name, err)), err
+		}
+		ctx.Logger.Info(fmt.Sprintf("Published %s to %s", artifact.Name, location))
+		artifact.Metadata["url"] = location
+		artifact.Metadata["repository"] = p.repository
+		result.Artifacts = append(result.Artifacts, artifact)
+		published = append(published, location)
+	}
+	result.Metadata = map[string]interface{}{
+		"repository": p.repository,
+		"path":       p.dir,
+		"published":  published,
+	}
+	return result, nil
+}
+
+// resolveCredentials reads the token or password from the step's secrets
+func (p *ArtifactoryPublishPlugin) resolveCredentials(ctx *sdk.ExecutionContext) error {
+	var err error
+	if p.tokenSecret != "" {
+		p.token, err = ctx.Secret(p.tokenSecret)
+		return err
+	}
+	p.password, err = ctx.Secret(p.passwordSecret)
+	return err
+}
+
+// Upload deploys a file under the build's path, with its properties, and
+// returns its URL. Artifactory verifies the upload against its checksum.
+func (p *ArtifactoryPublishPlugin) Upload(ctx context.Context, artifact *sdk.Artifact) (string, error) {
+	target, err := p.target(artifact.Name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(artifact.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	location := p.url + "/" + p.repository + "/" + target
+	req, err := p.newRequest(ctx, http.MethodPut, location+p.matrix, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = artifact.SizeBytes
+	req.Header.Set("X-Checksum-Sha256", artifact.ChecksumSHA256)
+	if err := p.do(req); err != nil {
+		return "", err
+	}
+	return location, nil
+}
+
+// Download fetches a file from Artifactory to dest
+func (p *ArtifactoryPublishPlugin) Download(ctx context.Context, url, dest string) error {
+	req, err := p.newRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Promote copies or moves a published path, with its properties, from the
+// repository of one environment to that of another
+func (p *ArtifactoryPublishPlugin) Promote(ctx context.Context, artifactID, fromEnv, toEnv string) error {
+	op := "move"
+	if p.copy {
+		op = "copy"
+	}
+	from, to := p.repositoryFor(fromEnv), p.repositoryFor(toEnv)
+	endpoint := fmt.Sprintf("%s/api/%s/%s/%s?to=%s&failFast=1",
+		p.url, op, from, artifactID, url.QueryEscape("/"+to+"/"+artifactID))
+	req, err := p.newRequest(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return p.do(req)
+}
+
+func (p *ArtifactoryPublishPlugin) Cleanup() error {
+	return nil
+}
+
+// repositoryFor returns the repository of an environment, or name itself if
+// it is not an environment
+func (p *ArtifactoryPublishPlugin) repositoryFor(name string) string {
+	if repo, ok := p.repositories[name]; ok {
+		return repo
+	}
+	return name
+}
+
+// publishDir returns the repository path the layout puts the build's files under
+func (p *ArtifactoryPublishPlugin) publishDir() (string, error) {
+	switch p.layout {
+	case "maven":
+		version, err := p.render(p.version)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(strings.ReplaceAll(p.groupID, ".", "/"), p.artifactID, version), nil
+	case "npm":
+		return p.npmPackage + "/-", nil
+	}
+	dir, err := p.render(p.path)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(path.Clean("/"+dir), "/"), nil
+}
+
+// target returns the repository path of a file. Maven files must be named
+// <artifact_id>-<version>[-<classifier>].<extension>, and the npm tarball is
+// renamed to <package>-<version>.tgz.
+func (p *ArtifactoryPublishPlugin) target(name string) (string, error) {
+	switch p.layout {
+	case "maven":
+		version := path.Base(p.dir)
+		prefix := p.artifactID + "-" + version
+		if !strings.HasPrefix(name, prefix+".") && !strings.HasPrefix(name, prefix+"-") {
+			return "", fmt.Errorf("%s does not match the Maven layout: expected %s[-<classifier>].<extension>", name, prefix)
+		}
+	case "npm":
+		if !strings.HasSuffix(name, ".tgz") {
+			return "", fmt.Errorf("%s is not an npm tarball", name)
+		}
+		version, err := p.render(p.version)
+		if err != nil {
+			return "", err
+		}
+		name = p.npmPackage + "-" + version + ".tgz"
+	}
+	return p.dir + "/" + name, nil
+}
+
+// matrixParams returns the build's properties as the matrix parameters of
+// an upload
+func (p *ArtifactoryPublishPlugin) matrixParams() (string, error) {
+	props := map[string]string{
+		"build.name":   p.build.Job,
+		"build.number": fmt.Sprint(p.build.BuildNumber),
+		"vcs.revision": p.build.Commit,
+		"vcs.branch":   p.build.Branch,
+	}
+	for k, tmpl := range p.properties {
+		v, err := p.render(tmpl)
+		if err != nil {
+			return "", err
+		}
+		props[k] = v
+	}
+
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		if props[k] == "" {
+			continue
+		}
+		b.WriteString(";" + matrixEscape(k) + "=" + matrixEscape(props[k]))
+	}
+	return b.String(), nil
+}
+
+// render executes a template with the build's details
+func (p *ArtifactoryPublishPlugin) render(tmpl *template.Template) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, p.build); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
+
+func (p *ArtifactoryPublishPlugin) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	} else {
+		req.SetBasicAuth(p.username, p.password)
+	}
+	return req, nil
+}
+
+// do sends a request and returns Artifactory's error if it fails
+func (p *ArtifactoryPublishPlugin) do(req *http.Request) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s returned status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// matrixEscape escapes a property key or value for a matrix parameter
+func matrixEscape(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "=", "%3D")
+}
+
+// matchFiles returns the workspace files matching the patterns, which are
+// relative to the workspace
+func matchFiles(workDir string, patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	files := []string{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(workDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid files pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil || info.IsDir() || seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", strings.Join(patterns, ", "))
+	}
+	return files, nil
+}
+
+func newArtifact(path string) (sdk.Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sdk.Artifact{}, err
+	}
+	return sdk.Artifact{
+		Name:           filepath.Base(path),
+		Path:           path,
+		SizeBytes:      info.Size(),
+		ChecksumSHA256: hex.EncodeToString(h.Sum(nil)),
+		Metadata:       map[string]string{"kind": "published"},
+	}, nil
+}
+
+func failure(message string) *sdk.Result {
+	return &sdk.Result{Success: false, ExitCode: 1, ErrorMessage: message}
+}
+
+// Export the plugin
+var Plugin ArtifactoryPublishPlugin
+
+// Serve the plugin to the worker agent
+func main() {
+	sdk.Serve(&Plugin)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// CommitStatusPlugin reports the build's status on its commit to GitHub or
+// GitLab, where it shows on the commit and on pull requests. A pull request
+// build reports on the head commit of the pull request (the head_sha
+// parameter) rather than the merge commit it built. Run it as the first step
+// to mark the commit pending, and as a notification for the final status:
+//
+//	plugins:
+//	  - name: commit-status
+//	    secrets: {GITHUB_TOKEN: "github:acme/widgets"}
+//	    config: {provider: github, token_secret: GITHUB_TOKEN}
+//	notifications:
+//	  - name: commit-status
+//	    on: [always]
+//	    secrets: {GITHUB_TOKEN: "github:acme/widgets"}
+//	    config: {provider: github, token_secret: GITHUB_TOKEN, review_comments: true}
+//
+// With review_comments, the notification also posts the annotations the
+// build's plugin steps reported, such as lint errors, as a review of the
+// pull request on GitHub.
+type CommitStatusPlugin struct {
+	provider    string // github or gitlab
+	apiURL      string
+	tokenSecret string // name of the secret holding the API token
+	repository  string // owner/repo or GitLab project path, from the SCM URL if empty
+	statusName  string // the status context, shown as the check's name
+	targetURL   string // linked from the status; {build_id} and {job_id} are replaced
+	reviews     bool   // post the build's annotations as a pull request review
+	client      *http.Client
+}
+
+func (p *CommitStatusPlugin) Name() string {
+	return "commit-status"
+}
+
+func (p *CommitStatusPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *CommitStatusPlugin) Type() string {
+	return "notification"
+}
+
+func (p *CommitStatusPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilitySecrets}
+}
+
+func (p *CommitStatusPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	p.provider = cfg.String("provider", "github")
+	switch p.provider {
+	case "github":
+		p.apiURL = cfg.String("api_url", "https://api.github.com")
+	case "gitlab":
+		p.apiURL = cfg.String("api_url", "https://gitlab.com/api/v4")
+	default:
+		return fmt.Errorf("unknown provider %q: must be github or gitlab", p.provider)
+	}
+	p.apiURL = strings.TrimSuffix(p.apiURL, "/")
+
+	p.tokenSecret = cfg.String("token_secret", "")
+	if p.tokenSecret == "" {
+		return fmt.Errorf("missing required config: token_secret")
+	}
+	p.repository = strings.Trim(cfg.String("repository", ""), "/")
+	p.statusName = cfg.String("context", "solvyd")
+	p.targetURL = cfg.String("target_url", "")
+	p.reviews = cfg.Bool("review_comments", false)
+	if p.reviews && p.provider != "github" {
+		return fmt.Errorf("review_comments is only supported with the github provider")
+	}
+	p.client = &http.Client{Timeout: cfg.Duration("timeout", 30*time.Second)}
+	return nil
+}
+
+func (p *CommitStatusPlugin) Health() error {
+	u, err := url.Parse(p.apiURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid api_url %q", p.apiURL)
+	}
+	return nil
+}
+
+// Execute sets the status of the build's commit from build_status: pending
+// while the build runs, then success or failure
+func (p *CommitStatusPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	params := sdk.Config(ctx.Parameters)
+	sha := params.String("head_sha", params.String("commit_sha", ""))
+	if sha == "" {
+		err := fmt.Errorf("no commit to report the status on")
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+	repo := p.repository
+	if repo == "" {
+		repo = repositoryPath(params.String("scm_url", ""))
+	}
+	if repo == "" {
+		err := fmt.Errorf("no repository: set repository in the config or the job's SCM URL")
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+	token, err := ctx.Secret(p.tokenSecret)
+	if err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: fmt.Sprintf("Failed to get token: %v", err)}, err
+	}
+
+	s := status{
+		state:       buildState(params.String("build_status", "running")),
+		name:        p.statusName,
+		description: description(params),
+		targetURL:   strings.NewReplacer("{build_id}", ctx.BuildID, "{job_id}", ctx.JobID).Replace(p.targetURL),
+	}
+	if err := p.report(ctx, token, repo, sha, s); err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+
+	short := sha
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	ctx.Logger.Info(fmt.Sprintf("Set %s status of %s@%s to %s", p.provider, repo, short, s.state))
+
+	// A finished pull request build's annotations are posted once, with
+	// its final status
+	number, _ := strconv.Atoi(params.String("pull_request_number", ""))
+	if p.reviews && s.state != statePending && number > 0 {
+		if annotations := sdk.BuildAnnotations(ctx); len(annotations) > 0 {
+			if err := p.review(ctx, token, repo, sha, number, annotations); err != nil {
+				return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+			}
+			ctx.Logger.Info(fmt.Sprintf("Posted %d annotations as a review of %s#%d", len(annotations), repo, number))
+		}
+	}
+
+	return &sdk.Result{
+		Success: true,
+		Output:  fmt.Sprintf("Commit status set to %s", s.state),
+		Metadata: map[string]interface{}{
+			"repository": repo,
+			"sha":        sha,
+			"state":      s.state,
+			"context":    s.name,
+		},
+	}, nil
+}
+
+// buildState maps the build's status to a status state
+func buildState(buildStatus string) string {
+	switch buildStatus {
+	case "success":
+		return stateSuccess
+	case "failed":
+		return stateFailure
+	}
+	return statePending
+}
+
+// description summarises the build for the status
+func description(params sdk.Config) string {
+	build := params.String("job_name", "Build")
+	if n := params.Int("build_number", 0); n > 0 {
+		build = fmt.Sprintf("%s #%d", build, n)
+	}
+	switch params.String("build_status", "running") {
+	case "success":
+		return build + " passed"
+	case "failed":
+		return build + " failed"
+	}
+	return build + " is running"
+}
+
+// repositoryPath returns the owner/repo path of an HTTPS or SSH clone URL
+func repositoryPath(scmURL string) string {
+	path := scmURL
+	if u, err := url.Parse(scmURL); err == nil && u.Host != "" {
+		path = u.Path
+	} else if _, after, ok := strings.Cut(scmURL, ":"); ok && strings.Contains(scmURL, "@") {
+		path = after // git@github.com:owner/repo.git
+	}
+	return strings.Trim(strings.TrimSuffix(path, ".git"), "/")
+}
+
+func (p *CommitStatusPlugin) Cleanup() error {
+	return nil
+}
+
+// Serve the plugin to the worker agent
+func main() {
+	sdk.Serve(&CommitStatusPlugin{})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// maxReviewComments is the most annotations posted as comments of a review;
+// the others are counted in its body
+const maxReviewComments = 50
+
+// maxReviewBody bounds the body of a review below the length GitHub accepts
+const maxReviewBody = 60000
+
+// reviewComment is a comment at lines of a file of a pull request review
+type reviewComment struct {
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	StartLine int    `json:"start_line,omitempty"`
+	Side      string `json:"side"`
+	StartSide string `json:"start_side,omitempty"`
+	Body      string `json:"body"`
+}
+
+// review posts the annotations of a pull request build as a review of the
+// pull request on GitHub, with a comment at the lines of each annotation.
+// GitHub rejects a review with comments outside the pull request's diff, so
+// then the annotations are listed in the body of the review instead.
+func (p *CommitStatusPlugin) review(ctx context.Context, token, repo, sha string, number int, annotations []sdk.Annotation) error {
+	var comments []reviewComment
+	var general []sdk.Annotation
+	for _, a := range annotations {
+		if a.Path == "" || a.StartLine == 0 || len(comments) == maxReviewComments {
+			general = append(general, a)
+			continue
+		}
+		c := reviewComment{Path: a.Path, Line: a.StartLine, Side: "RIGHT", Body: annotationText(a)}
+		if a.EndLine > a.StartLine {
+			c.StartLine, c.Line, c.StartSide = a.StartLine, a.EndLine, "RIGHT"
+		}
+		comments = append(comments, c)
+	}
+
+	err := p.postReview(ctx, token, repo, sha, number, reviewBody(len(annotations), general), comments)
+	if err == errOutsideDiff {
+		err = p.postReview(ctx, token, repo, sha, number, reviewBody(len(annotations), annotations), nil)
+	}
+	return err
+}
+
+// errOutsideDiff is returned when GitHub rejects review comments, usually
+// because their lines are not part of the pull request's diff
+var errOutsideDiff = errors.New("review comments are outside the pull request's diff")
+
+// postReview creates a review that comments on the pull request
+func (p *CommitStatusPlugin) postReview(ctx context.Context, token, repo, sha string, number int, body string, comments []reviewComment) error {
+	review := map[string]interface{}{"commit_id": sha, "body": body, "event": "COMMENT"}
+	if len(comments) > 0 {
+		review["comments"] = comments
+	}
+	data, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/repos/%s/pulls/%d/reviews", p.apiURL, repo, number)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post review: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnprocessableEntity && len(comments) > 0 {
+		return errOutsideDiff
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to post review: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// reviewBody summarises the annotations of a build, listing those that are
+// not comments at their lines
+func reviewBody(total int, listed []sdk.Annotation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The build reported %d annotations.", total)
+	for i, a := range listed {
+		entry := "\n\n" + annotationText(a)
+		if a.Path != "" {
+			location := a.Path
+			if a.StartLine > 0 {
+				location = fmt.Sprintf("%s:%d", a.Path, a.StartLine)
+			}
+			entry = fmt.Sprintf("\n\n`%s` %s", location, annotationText(a))
+		}
+		if b.Len()+len(entry) > maxReviewBody {
+			fmt.Fprintf(&b, "\n\n...and %d more", len(listed)-i)
+			break
+		}
+		b.WriteString(entry)
+	}
+	return b.String()
+}
+
+// annotationText is the text of a comment on an annotation
+func annotationText(a sdk.Annotation) string {
+	severity := a.Severity
+	if severity == "" {
+		severity = sdk.AnnotationNotice
+	}
+	heading := strings.ToUpper(severity[:1]) + severity[1:]
+	if a.Title != "" {
+		heading += ": " + a.Title
+	}
+	return fmt.Sprintf("**%s**\n\n%s", heading, a.Message)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Status states, as GitHub names them
+const (
+	statePending = "pending"
+	stateSuccess = "success"
+	stateFailure = "failure"
+)
+
+// maxDescription is the longest description GitHub accepts
+const maxDescription = 140
+
+// status is what is reported on a commit
+type status struct {
+	state       string
+	name        string
+	description string
+	targetURL   string
+}
+
+// report sets the status of the commit sha of the repository
+func (p *CommitStatusPlugin) report(ctx context.Context, token, repo, sha string, s status) error {
+	if len(s.description) > maxDescription {
+		s.description = s.description[:maxDescription-3] + "..."
+	}
+
+	var endpoint string
+	body := map[string]interface{}{"description": s.description}
+	header := http.Header{}
+	switch p.provider {
+	case "github":
+		endpoint = fmt.Sprintf("%s/repos/%s/statuses/%s", p.apiURL, repo, sha)
+		body["state"] = s.state
+		body["context"] = s.name
+		if s.targetURL != "" {
+			body["target_url"] = s.targetURL
+		}
+		header.Set("Authorization", "Bearer "+token)
+		header.Set("Accept", "application/vnd.github+json")
+	case "gitlab":
+		endpoint = fmt.Sprintf("%s/projects/%s/statuses/%s", p.apiURL, url.PathEscape(repo), sha)
+		body["state"] = gitlabState(s.state)
+		body["name"] = s.name
+		if s.targetURL != "" {
+			body["target_url"] = s.targetURL
+		}
+		header.Set("PRIVATE-TOKEN", token)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to set commit status: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to set commit status: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// gitlabState maps a state to GitLab's name for it; GitLab's pending means
+// not started, so a build that is running is reported as running
+func gitlabState(state string) string {
+	switch state {
+	case stateSuccess:
+		return "success"
+	case stateFailure:
+		return "failed"
+	}
+	return "running"
+}
@@ -0,0 +1,27 @@
+module github.com/solvyd/solvyd/plugin-sdk/plugins/coverage-report
+
+go 1.21
+
+replace github.com/solvyd/solvyd/plugin-sdk => ../..
+
+require (
+	github.com/bmatcuk/doublestar/v4 v4.10.2
+	github.com/solvyd/solvyd/plugin-sdk v0.0.0
+)
+
+require (
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/hashicorp/go-hclog v0.14.1 // indirect
+	github.com/hashicorp/go-plugin v1.6.3 // indirect
+	github.com/hashicorp/yamux v0.1.1 // indirect
+	github.com/mattn/go-colorable v0.1.4 // indirect
+	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/oklog/run v1.0.0 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20231106174013-bbf56f31fb17 // indirect
+	google.golang.org/grpc v1.61.0 // indirect
+	google.golang.org/protobuf v1.36.1 // indirect
+)
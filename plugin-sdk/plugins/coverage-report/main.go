@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// defaultReportPath matches the default report names of common coverage tools
+const defaultReportPath = "**/{coverage.xml,cobertura.xml,cobertura-coverage.xml,jacoco.xml,jacocoTestReport.xml,lcov.info,coverage.out,cover.out}"
+
+// CoverageReportPlugin parses code coverage reports, checks the coverage
+// against minimums and against the coverage of the job's last successful
+// build, and reports it for tracking across builds
+type CoverageReportPlugin struct {
+	reportPaths       []string
+	format            string  // one of the format constants, or "" to detect it
+	minLineCoverage   float64 // percent, 0 to disable
+	minBranchCoverage float64 // percent, 0 to disable
+	maxLineDecrease   float64 // percentage points, negative to disable
+	maxBranchDecrease float64 // percentage points, negative to disable
+}
+
+func (p *CoverageReportPlugin) Name() string {
+	return "coverage-report"
+}
+
+func (p *CoverageReportPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *CoverageReportPlugin) Type() string {
+	return "test"
+}
+
+func (p *CoverageReportPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityWorkspaceReadOnly}
+}
+
+func (p *CoverageReportPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+
+	// report_path is a pattern or a list of them
+	if cfg.Slice("report_path") != nil {
+		p.reportPaths = cfg.StringSlice("report_path")
+	} else {
+		p.reportPaths = []string{cfg.String("report_path", defaultReportPath)}
+	}
+	if len(p.reportPaths) == 0 {
+		return fmt.Errorf("report_path must not be empty")
+	}
+	for _, pattern := range p.reportPaths {
+		if filepath.IsAbs(pattern) || !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid report_path pattern %q: patterns are relative to the workspace", pattern)
+		}
+	}
+
+	p.format = strings.ToLower(cfg.String("format", "auto"))
+	switch p.format {
+	case "auto":
+		p.format = ""
+	case formatCobertura, formatLcov, formatJaCoCo, formatGo:
+	default:
+		return fmt.Errorf("invalid format %q (expected auto, cobertura, lcov, jacoco or go)", p.format)
+	}
+
+	p.minLineCoverage = cfg.Float("min_line_coverage", 0)
+	p.minBranchCoverage = cfg.Float("min_branch_coverage", 0)
+	p.maxLineDecrease = cfg.Float("max_line_decrease", -1)
+	p.maxBranchDecrease = cfg.Float("max_branch_decrease", -1)
+	for key, v := range map[string]float64{"min_line_coverage": p.minLineCoverage, "min_branch_coverage": p.minBranchCoverage} {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", key)
+		}
+	}
+	return nil
+}
+
+func (p *CoverageReportPlugin) Health() error {
+	return nil
+}
+
+func (p *CoverageReportPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	files, err := findReports(ctx.WorkDir, p.reportPaths)
+	if err != nil {
+		return failure(fmt.Sprintf("Failed to find coverage reports: %v", err)), err
+	}
+	if len(files) == 0 {
+		err := fmt.Errorf("no coverage reports match report_path %s in the workspace", strings.Join(p.reportPaths, ", "))
+		return failure(err.Error()), err
+	}
+
+	var total counts
+	blocks := goBlocks{}
+	formats := map[string]bool{}
+	var reports []string
+	for _, file := range files {
+		rel, _ := filepath.Rel(ctx.WorkDir, file)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to read %s: %v", rel, err)), err
+		}
+		format := p.format
+		if format == "" {
+			if format, err = detectFormat(data); err != nil {
+				return failure(fmt.Sprintf("%s: %v", rel, err)), err
+			}
+		}
+
+		var c counts
+		switch format {
+		case formatCobertura:
+			c, err = parseCobertura(data)
+		case formatJaCoCo:
+			c, err = parseJaCoCo(data)
+		case formatLcov:
+			c, err = parseLcov(data)
+		case formatGo:
+			err = blocks.parseGo(data)
+		}
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to parse %s as %s: %v", rel, format, err)), err
+		}
+		total.add(c)
+		formats[format] = true
+		reports = append(reports, rel)
+		ctx.Logger.Info(fmt.Sprintf("Parsed %s coverage report %s", format, rel))
+	}
+	total.add(blocks.counts())
+
+	coverage := &sdk.Coverage{
+		LinesCovered:    total.linesCovered,
+		LinesTotal:      total.linesTotal,
+		BranchesCovered: total.branchesCovered,
+		BranchesTotal:   total.branchesTotal,
+	}
+	if len(formats) == 1 {
+		for format := range formats {
+			coverage.Format = format
+		}
+	}
+
+	result := &sdk.Result{
+		Success:  true,
+		Coverage: coverage,
+		Metadata: map[string]interface{}{
+			"reports":          reports,
+			"lines_covered":    coverage.LinesCovered,
+			"lines_total":      coverage.LinesTotal,
+			"line_coverage":    coverage.LinePercent(),
+			"branches_covered": coverage.BranchesCovered,
+			"branches_total":   coverage.BranchesTotal,
+		},
+		Output: fmt.Sprintf("Line coverage: %.2f%% (%d/%d)", coverage.LinePercent(), coverage.LinesCovered, coverage.LinesTotal),
+	}
+	if coverage.BranchesTotal > 0 {
+		result.Metadata["branch_coverage"] = coverage.BranchPercent()
+		result.Output += fmt.Sprintf(", branch coverage: %.2f%% (%d/%d)", coverage.BranchPercent(), coverage.BranchesCovered, coverage.BranchesTotal)
+	}
+
+	var problems []string
+	if coverage.LinePercent() < p.minLineCoverage {
+		problems = append(problems, fmt.Sprintf("line coverage %.2f%% is below the minimum of %.2f%%", coverage.LinePercent(), p.minLineCoverage))
+	}
+	if coverage.BranchesTotal > 0 && coverage.BranchPercent() < p.minBranchCoverage {
+		problems = append(problems, fmt.Sprintf("branch coverage %.2f%% is below the minimum of %.2f%%", coverage.BranchPercent(), p.minBranchCoverage))
+	}
+
+	if baseline, ok := sdk.CoverageBaseline(ctx); ok {
+		lineDelta := coverage.LinePercent() - baseline.LinePercent()
+		result.Metadata["baseline_line_coverage"] = baseline.LinePercent()
+		result.Metadata["line_delta"] = lineDelta
+		result.Output += fmt.Sprintf("; line coverage %+.2f%% since the last successful build", lineDelta)
+		if p.maxLineDecrease >= 0 && -lineDelta > p.maxLineDecrease {
+			problems = append(problems, fmt.Sprintf("line coverage dropped by %.2f%%, more than the allowed %.2f%%", -lineDelta, p.maxLineDecrease))
+		}
+
+		if coverage.BranchesTotal > 0 && baseline.BranchesTotal > 0 {
+			branchDelta := coverage.BranchPercent() - baseline.BranchPercent()
+			result.Metadata["baseline_branch_coverage"] = baseline.BranchPercent()
+			result.Metadata["branch_delta"] = branchDelta
+			if p.maxBranchDecrease >= 0 && -branchDelta > p.maxBranchDecrease {
+				problems = append(problems, fmt.Sprintf("branch coverage dropped by %.2f%%, more than the allowed %.2f%%", -branchDelta, p.maxBranchDecrease))
+			}
+		}
+	} else if p.maxLineDecrease >= 0 || p.maxBranchDecrease >= 0 {
+		ctx.Logger.Info("No earlier coverage to compare against")
+	}
+
+	ctx.Logger.Info(result.Output)
+	if len(problems) > 0 {
+		result.Success = false
+		result.ExitCode = 1
+		result.ErrorMessage = strings.Join(problems, "; ")
+	}
+	return result, nil
+}
+
+func (p *CoverageReportPlugin) Cleanup() error {
+	return nil
+}
+
+// findReports returns the files in dir matching any of the patterns, which
+// are slash-separated and may use ** to match any number of directories
+func findReports(dir string, patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := doublestar.Glob(os.DirFS(dir), filepath.ToSlash(pattern), doublestar.WithFilesOnly())
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, filepath.Join(dir, filepath.FromSlash(m)))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func failure(message string) *sdk.Result {
+	return &sdk.Result{Success: false, ExitCode: 1, ErrorMessage: message}
+}
+
+// Export the plugin
+var Plugin CoverageReportPlugin
+
+func main() {
+	sdk.Serve(&Plugin)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Report formats
+const (
+	formatCobertura = "cobertura"
+	formatLcov      = "lcov"
+	formatJaCoCo    = "jacoco"
+	formatGo        = "go"
+)
+
+// counts is the coverage measured by one or more reports
+type counts struct {
+	linesCovered, linesTotal       int
+	branchesCovered, branchesTotal int
+}
+
+func (c *counts) add(o counts) {
+	c.linesCovered += o.linesCovered
+	c.linesTotal += o.linesTotal
+	c.branchesCovered += o.branchesCovered
+	c.branchesTotal += o.branchesTotal
+}
+
+// detectFormat guesses the format of a report from its content
+func detectFormat(data []byte) (string, error) {
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	switch {
+	case bytes.HasPrefix(bytes.TrimSpace(head), []byte("mode:")):
+		return formatGo, nil
+	case bytes.Contains(head, []byte("<coverage")):
+		return formatCobertura, nil
+	case bytes.Contains(head, []byte("<report")):
+		return formatJaCoCo, nil
+	case bytes.Contains(head, []byte("SF:")) || bytes.HasPrefix(head, []byte("TN:")):
+		return formatLcov, nil
+	}
+	return "", fmt.Errorf("unrecognized coverage report format")
+}
+
+// parseCobertura reads the totals of a Cobertura XML report. Reports that
+// leave the totals out are counted line by line.
+func parseCobertura(data []byte) (counts, error) {
+	var report struct {
+		LinesValid      int `xml:"lines-valid,attr"`
+		LinesCovered    int `xml:"lines-covered,attr"`
+		BranchesValid   int `xml:"branches-valid,attr"`
+		BranchesCovered int `xml:"branches-covered,attr"`
+		Classes         []struct {
+			Lines []struct {
+				Hits              int    `xml:"hits,attr"`
+				Branch            bool   `xml:"branch,attr"`
+				ConditionCoverage string `xml:"condition-coverage,attr"` // "50% (1/2)"
+			} `xml:"lines>line"`
+		} `xml:"packages>package>classes>class"`
+	}
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return counts{}, err
+	}
+	if report.LinesValid > 0 {
+		return counts{report.LinesCovered, report.LinesValid, report.BranchesCovered, report.BranchesValid}, nil
+	}
+
+	var c counts
+	for _, class := range report.Classes {
+		for _, line := range class.Lines {
+			c.linesTotal++
+			if line.Hits > 0 {
+				c.linesCovered++
+			}
+			if !line.Branch {
+				continue
+			}
+			var covered, total int
+			if i := strings.IndexByte(line.ConditionCoverage, '('); i >= 0 {
+				fmt.Sscanf(line.ConditionCoverage[i:], "(%d/%d)", &covered, &total)
+			}
+			c.branchesCovered += covered
+			c.branchesTotal += total
+		}
+	}
+	return c, nil
+}
+
+// parseJaCoCo reads the report-level LINE and BRANCH counters of a JaCoCo XML
+// report
+func parseJaCoCo(data []byte) (counts, error) {
+	var report struct {
+		Counters []struct {
+			Type    string `xml:"type,attr"`
+			Missed  int    `xml:"missed,attr"`
+			Covered int    `xml:"covered,attr"`
+		} `xml:"counter"`
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false // reports reference report.dtd, which is not resolved
+	if err := decoder.Decode(&report); err != nil {
+		return counts{}, err
+	}
+
+	var c counts
+	for _, counter := range report.Counters {
+		switch counter.Type {
+		case "LINE":
+			c.linesCovered, c.linesTotal = counter.Covered, counter.Covered+counter.Missed
+		case "BRANCH":
+			c.branchesCovered, c.branchesTotal = counter.Covered, counter.Covered+counter.Missed
+		}
+	}
+	return c, nil
+}
+
+// parseLcov reads an lcov tracefile. The LF/LH and BRF/BRH summaries of each
+// record are used, or its DA and BRDA lines if it has none.
+func parseLcov(data []byte) (counts, error) {
+	var total, record, detail counts
+	var hasLines, hasBranches bool
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, _ := strings.Cut(line, ":")
+		switch key {
+		case "LF":
+			record.linesTotal, _ = strconv.Atoi(value)
+			hasLines = true
+		case "LH":
+			record.linesCovered, _ = strconv.Atoi(value)
+		case "BRF":
+			record.branchesTotal, _ = strconv.Atoi(value)
+			hasBranches = true
+		case "BRH":
+			record.branchesCovered, _ = strconv.Atoi(value)
+		case "DA": // line,hits
+			fields := strings.Split(value, ",")
+			detail.linesTotal++
+			if len(fields) > 1 && fields[1] != "0" {
+				detail.linesCovered++
+			}
+		case "BRDA": // line,block,branch,taken ("-" if never evaluated)
+			fields := strings.Split(value, ",")
+			detail.branchesTotal++
+			if len(fields) > 3 && fields[3] != "-" && fields[3] != "0" {
+				detail.branchesCovered++
+			}
+		case "end_of_record":
+			if !hasLines {
+				record.linesCovered, record.linesTotal = detail.linesCovered, detail.linesTotal
+			}
+			if !hasBranches {
+				record.branchesCovered, record.branchesTotal = detail.branchesCovered, detail.branchesTotal
+			}
+			total.add(record)
+			record, detail = counts{}, counts{}
+			hasLines, hasBranches = false, false
+		}
+	}
+	return total, scanner.Err()
+}
+
+// goBlocks holds the statement blocks of Go coverage profiles by position, so
+// blocks that appear in several profiles, as with -coverpkg, count once and
+// are covered if any profile covered them
+type goBlocks map[string]struct {
+	statements int
+	covered    bool
+}
+
+// parseGo adds the blocks of a Go coverage profile
+func (b goBlocks) parseGo(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// file.go:startLine.startCol,endLine.endCol numStatements count
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("invalid coverage profile line %q", line)
+		}
+		block := b[fields[0]]
+		block.statements = statements
+		block.covered = block.covered || count > 0
+		b[fields[0]] = block
+	}
+	return scanner.Err()
+}
+
+// counts returns the statements of the blocks as lines; Go profiles have no
+// branch data
+func (b goBlocks) counts() counts {
+	var c counts
+	for _, block := range b {
+		c.linesTotal += block.statements
+		if block.covered {
+			c.linesCovered += block.statements
+		}
+	}
+	return c
+}
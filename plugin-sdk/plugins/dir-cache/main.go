@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// DirCachePlugin stores dependency cache entries as archives in a directory,
+// typically an NFS or other network share mounted on every worker. Entries are
+// written to a temporary file and renamed into place, so workers sharing the
+// directory never see partial entries.
+type DirCachePlugin struct {
+	dir    string
+	maxAge time.Duration
+}
+
+func (p *DirCachePlugin) Name() string {
+	return "dir-cache"
+}
+
+func (p *DirCachePlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *DirCachePlugin) Type() string {
+	return "cache"
+}
+
+func (p *DirCachePlugin) Capabilities() []string {
+	return []string{sdk.CapabilityWorkspace}
+}
+
+func (p *DirCachePlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	if err := cfg.Require("dir"); err != nil {
+		return err
+	}
+	p.dir = cfg.String("dir", "")
+	p.maxAge = cfg.Duration("max_age", 0)
+	return nil
+}
+
+func (p *DirCachePlugin) Health() error {
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return fmt.Errorf("cache directory is not writable: %w", err)
+	}
+	f, err := os.CreateTemp(p.dir, ".health-*")
+	if err != nil {
+		return fmt.Errorf("cache directory is not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Execute is never called for cache plugins
+func (p *DirCachePlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	err := fmt.Errorf("dir-cache is a cache plugin and cannot run as a build step")
+	return &sdk.Result{Success: false, ExitCode: 1, ErrorMessage: err.Error()}, err
+}
+
+// Save archives src under key, replacing an existing entry
+func (p *DirCachePlugin) Save(ctx context.Context, key string, src string) error {
+	f, err := os.CreateTemp(p.dir, ".save-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := sdk.WriteCacheArchive(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p.path(key))
+}
+
+// Restore extracts the entry stored under key into dest
+func (p *DirCachePlugin) Restore(ctx context.Context, key string, dest string) (bool, error) {
+	if found, err := p.Exists(ctx, key); !found || err != nil {
+		return false, err
+	}
+	f, err := os.Open(p.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil // removed since Exists
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if err := sdk.ReadCacheArchive(f, dest); err != nil {
+		return false, err
+	}
+	// Keep entries in use from expiring
+	now := time.Now()
+	os.Chtimes(p.path(key), now, now)
+	return true, nil
+}
+
+// Exists reports whether an unexpired entry is stored under key
+func (p *DirCachePlugin) Exists(ctx context.Context, key string) (bool, error) {
+	info, err := os.Stat(p.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if p.maxAge > 0 && time.Since(info.ModTime()) > p.maxAge {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (p *DirCachePlugin) Cleanup() error {
+	return nil
+}
+
+// path returns the archive of an entry. Keys are hashed, since they may
+// contain characters that are not valid in file names.
+func (p *DirCachePlugin) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(p.dir, hex.EncodeToString(sum[:])+".tar.gz")
+}
+
+func main() {
+	sdk.Serve(&DirCachePlugin{})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// commitInfo reads the metadata of a checked out commit
+func (p *GitSCMPlugin) commitInfo(ctx context.Context, c *checkout, sha string) (*sdk.CommitInfo, error) {
+	out, err := runGit(ctx, c.dest, c.env, nil, "log", "-1", "--format=%H%x00%an%x00%ae%x00%aI%x00%B", sha, "--")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(out, "\x00", 5)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("unexpected git log output for %s", sha)
+	}
+	return &sdk.CommitInfo{
+		SHA:       fields[0],
+		Author:    fields[1],
+		Email:     fields[2],
+		Timestamp: fields[3],
+		Message:   strings.TrimSpace(fields[4]),
+	}, nil
+}
+
+// changedFiles returns the paths that differ between base and sha. Without a
+// base, or if base cannot be fetched, sha is compared with its first parent;
+// a commit without parents in the clone, such as the root commit or the
+// boundary of a shallow clone, changes all of its files. Renames are listed as
+// the deleted and the added path.
+func (p *GitSCMPlugin) changedFiles(ctx context.Context, c *checkout, base, sha string) (files []string, from string, err error) {
+	git := func(args ...string) (string, error) {
+		return runGit(ctx, c.dest, c.env, nil, append([]string{"-c", "core.quotePath=false"}, args...)...)
+	}
+
+	if base != "" && base != sha {
+		if _, err := git("cat-file", "-e", base+"^{commit}"); err != nil {
+			// Comparing trees needs neither commit's history, so a shallow
+			// clone only fetches the base commit itself
+			fetch := []string{"fetch", "--no-tags", "origin", base}
+			if p.depth > 0 {
+				fetch = []string{"fetch", "--no-tags", "--depth", strconv.Itoa(p.depth), "origin", base}
+			}
+			if _, err := git(fetch...); err != nil {
+				base = ""
+			}
+		}
+	}
+
+	var out string
+	switch {
+	case base == sha:
+		return []string{}, base, nil
+	case base != "":
+		out, err = git("diff", "--name-only", "--no-renames", "-z", base, sha, "--")
+	default:
+		if _, perr := git("rev-parse", "--verify", "--quiet", sha+"^1^{commit}"); perr == nil {
+			base = sha + "^1"
+			out, err = git("diff", "--name-only", "--no-renames", "-z", base, sha, "--")
+		} else {
+			out, err = git("diff-tree", "--root", "-r", "--name-only", "--no-commit-id", "-z", sha)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	files = []string{}
+	for _, f := range strings.Split(out, "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, base, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkout is what to check out and where
+type checkout struct {
+	url    string
+	branch string
+	tag    string
+	ref    string // a full ref such as refs/pull/42/head
+	commit string // checked out instead of the head of branch, tag or ref
+	dest   string
+	env    []string // credentials for git
+	out    io.Writer
+}
+
+// target names what is checked out
+func (c *checkout) target() string {
+	switch {
+	case c.commit != "":
+		return c.commit
+	case c.tag != "":
+		return "tag " + c.tag
+	case c.ref != "":
+		return c.ref
+	}
+	return "branch " + c.branch
+}
+
+// refspec returns the refspec that fetches the branch, tag or ref
+func (c *checkout) refspec() string {
+	switch {
+	case c.tag != "":
+		return "+refs/tags/" + c.tag + ":refs/tags/" + c.tag
+	case c.ref != "":
+		return "+" + c.ref + ":" + c.ref
+	}
+	return "+refs/heads/" + c.branch + ":refs/remotes/origin/" + c.branch
+}
+
+// sync brings dest to the checkout, cloning into it if it is not a repository
+// yet, and returns the commit checked out
+func (p *GitSCMPlugin) sync(ctx context.Context, c *checkout) (string, error) {
+	git := func(args ...string) (string, error) {
+		return runGit(ctx, c.dest, c.env, c.out, args...)
+	}
+
+	if _, err := os.Stat(filepath.Join(c.dest, ".git")); err != nil {
+		if err := os.MkdirAll(c.dest, 0755); err != nil {
+			return "", err
+		}
+		if _, err := git("init", "--quiet"); err != nil {
+			return "", err
+		}
+		if _, err := git("remote", "add", "origin", c.url); err != nil {
+			return "", err
+		}
+	} else {
+		// A reused workspace: point it at the URL, which may have changed,
+		// and drop whatever a previous build left in it
+		if _, err := git("remote", "set-url", "origin", c.url); err != nil {
+			return "", err
+		}
+		if p.clean {
+			if _, err := git("reset", "--hard", "--quiet"); err != nil {
+				return "", err
+			}
+			if _, err := git("clean", "-ffdx", "--quiet"); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if len(p.sparseCheckout) > 0 {
+		if _, err := git(append([]string{"sparse-checkout", "set", "--cone"}, p.sparseCheckout...)...); err != nil {
+			return "", err
+		}
+	} else if sparse, _ := git("config", "--get", "core.sparseCheckout"); strings.TrimSpace(sparse) == "true" {
+		if _, err := git("sparse-checkout", "disable"); err != nil {
+			return "", err
+		}
+	}
+
+	fetch := []string{"fetch", "--no-tags", "--prune", "--force"}
+	if p.depth > 0 {
+		fetch = append(fetch, "--depth", strconv.Itoa(p.depth))
+	}
+	if _, err := git(append(fetch, "origin", c.refspec())...); err != nil {
+		return "", err
+	}
+
+	// A commit beyond the shallow history of the branch is fetched by itself
+	target := "FETCH_HEAD"
+	if c.commit != "" {
+		target = c.commit
+		if _, err := git("cat-file", "-e", c.commit+"^{commit}"); err != nil {
+			if _, err := git(append(fetch, "origin", c.commit)...); err != nil {
+				return "", fmt.Errorf("commit %s not found: %w", c.commit, err)
+			}
+		}
+	}
+
+	args := []string{"checkout", "--force", "--detach", target}
+	if c.branch != "" && c.commit == "" {
+		args = []string{"checkout", "--force", "-B", c.branch, target}
+	}
+	if _, err := git(args...); err != nil {
+		return "", err
+	}
+
+	if p.submodules {
+		if _, err := git("submodule", "sync", "--recursive"); err != nil {
+			return "", err
+		}
+		update := []string{"submodule", "update", "--init", "--recursive", "--force"}
+		if p.depth > 0 {
+			update = append(update, "--depth", strconv.Itoa(p.depth))
+		}
+		if _, err := git(update...); err != nil {
+			return "", err
+		}
+	}
+
+	if p.lfs {
+		if _, err := git("lfs", "install", "--local"); err != nil {
+			return "", err
+		}
+		if _, err := git("lfs", "pull"); err != nil {
+			return "", err
+		}
+		if p.submodules {
+			if _, err := git("submodule", "foreach", "--recursive", "git lfs install --local && git lfs pull"); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	head, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(head), nil
+}
+
+// runGit runs git in dir and returns its standard output. Its standard error
+// goes to out. LFS files are only downloaded by an explicit git lfs pull.
+func runGit(ctx context.Context, dir string, env []string, out io.Writer, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_LFS_SKIP_SMUDGE=1")
+	cmd.Env = append(cmd.Env, env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if out != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, out)
+	}
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", fmt.Errorf("git %s failed: %w", args[0], err)
+		}
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, lastLine(msg))
+	}
+	return stdout.String(), nil
+}
+
+// credentialEnv returns the environment that makes git, and the git processes
+// of submodules, send the credentials to the repository's host over HTTPS.
+// The secret is a token, sent with the username, or "user:token". It is
+// passed through GIT_CONFIG_* variables so it appears in neither the command
+// line nor the repository's config.
+func credentialEnv(repoURL, username, secret string) ([]string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("credentials are only supported for HTTP(S) repository URLs")
+	}
+	if !strings.Contains(secret, ":") {
+		secret = username + ":" + secret
+	}
+	header := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(secret))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http." + u.Scheme + "://" + u.Host + "/.extraHeader",
+		"GIT_CONFIG_VALUE_0=" + header,
+	}, nil
+}
+
+// redactURL drops the user info of a URL, which may hold a token
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
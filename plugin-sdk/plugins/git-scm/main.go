@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"os/exec"
+	"path"
+	"strings"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
 )
 
 // GitSCMPlugin implements SCM plugin for Git
 type GitSCMPlugin struct {
-	depth       int
-	submodules  bool
-	credentials string
+	depth          int
+	submodules     bool
+	lfs            bool
+	sparseCheckout []string // directories to check out, all if empty
+	clean          bool     // remove untracked files from a reused workspace
+	credentials    string   // name of the secret holding a token or "user:token"
+	username       string   // user for a token without one
+	tag            string
+	ref            string
+	last           *checkout // the last checkout, read by GetCommitInfo
 }
 
 func (p *GitSCMPlugin) Name() string {
@@ -20,103 +29,165 @@ func (p *GitSCMPlugin) Name() string {
 }
 
 func (p *GitSCMPlugin) Version() string {
-	return "1.0.0"
+	return "1.1.0"
 }
 
 func (p *GitSCMPlugin) Type() string {
 	return "scm"
 }
 
+func (p *GitSCMPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace, sdk.CapabilitySecrets}
+}
+
 func (p *GitSCMPlugin) Initialize(config map[string]interface{}) error {
-	if depth, ok := config["depth"].(float64); ok {
-		p.depth = int(depth)
-	} else {
-		p.depth = 0 // full clone
+	cfg := sdk.Config(config)
+	p.depth = cfg.Int("depth", 0) // 0 is a full clone
+	if p.depth < 0 {
+		return fmt.Errorf("depth must not be negative")
 	}
-
-	if submodules, ok := config["submodules"].(bool); ok {
-		p.submodules = submodules
+	p.submodules = cfg.Bool("submodules", false)
+	p.lfs = cfg.Bool("lfs", false)
+	p.clean = cfg.Bool("clean", true)
+	p.credentials = cfg.String("credentials", "")
+	p.username = cfg.String("username", "x-access-token")
+
+	p.sparseCheckout = cfg.StringSlice("sparse_checkout")
+	for _, dir := range p.sparseCheckout {
+		if dir == "" || path.IsAbs(dir) || strings.HasPrefix(path.Clean(dir), "..") {
+			return fmt.Errorf("invalid sparse_checkout path %q: paths are directories relative to the repository", dir)
+		}
 	}
 
-	if creds, ok := config["credentials"].(string); ok {
-		p.credentials = creds
+	p.tag = cfg.String("tag", "")
+	p.ref = cfg.String("ref", "")
+	if p.tag != "" && p.ref != "" {
+		return fmt.Errorf("tag and ref are mutually exclusive")
+	}
+	if p.ref != "" && !strings.HasPrefix(p.ref, "refs/") {
+		return fmt.Errorf("ref %q must be a full ref such as refs/pull/42/head", p.ref)
 	}
 
 	return nil
 }
 
+func (p *GitSCMPlugin) Health() error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git is not installed: %w", err)
+	}
+	if p.lfs {
+		if err := exec.Command("git", "lfs", "version").Run(); err != nil {
+			return fmt.Errorf("git-lfs is not installed: %w", err)
+		}
+	}
+	return nil
+}
+
+// Execute checks out the repository into the workspace. A workspace that
+// already holds a clone, as with a reused workspace, is fetched into and
+// reset instead of cloned again. The checkout is the build's commit, or the
+// head of the configured tag, ref or build branch.
+// The result holds the commit's metadata and the files it changes since the
+// previous build's commit, or since its parent for a job's first build.
 func (p *GitSCMPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
-	ctx.Logger.Info("Starting Git clone operation")
+	ctx.Logger.Info("Starting Git checkout")
 
 	// Get repository URL from parameters
-	url, ok := ctx.Parameters["url"].(string)
-	if !ok {
+	params := sdk.Config(ctx.Parameters)
+	url := params.String("url", params.String("scm_url", ""))
+	if url == "" {
 		return &sdk.Result{
 			Success:      false,
 			ErrorMessage: "Repository URL not provided",
 		}, fmt.Errorf("missing repository URL")
 	}
 
-	branch := "main"
-	if b, ok := ctx.Parameters["branch"].(string); ok {
-		branch = b
+	co := &checkout{
+		url:    url,
+		branch: params.String("branch", "main"),
+		tag:    params.String("tag", p.tag),
+		ref:    params.String("ref", p.ref),
+		commit: params.String("commit_sha", ""),
+		dest:   ctx.WorkDir,
+		out:    ctx.Output,
+	}
+	if co.tag != "" || co.ref != "" {
+		co.branch = ""
+	}
+
+	if p.credentials != "" {
+		secret, err := ctx.Secret(p.credentials)
+		if err != nil {
+			return &sdk.Result{Success: false, ErrorMessage: fmt.Sprintf("Failed to get credentials: %v", err)}, err
+		}
+		if co.env, err = credentialEnv(url, p.username, secret); err != nil {
+			return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+		}
 	}
 
-	// Clone the repository
-	if err := p.Clone(url, branch, "", ctx.WorkDir); err != nil {
+	head, err := p.sync(ctx, co)
+	if err != nil {
 		return &sdk.Result{
 			Success:      false,
 			ErrorMessage: err.Error(),
 		}, err
 	}
 
-	ctx.Logger.Info("Git clone completed successfully")
+	p.last = co
+
+	ctx.Logger.Info(fmt.Sprintf("Checked out %s at %s", co.target(), head))
+
+	commit, err := p.commitInfo(ctx, co, head)
+	if err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+	previous := params.String("previous_commit_sha", "")
+	files, base, err := p.changedFiles(ctx, co, previous, head)
+	if err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+	if previous != "" && base != previous {
+		ctx.Logger.Warn(fmt.Sprintf("Previous build's commit %s not found, listing the changes of %s alone", previous, head))
+	}
+	commit.ChangedFiles = files
+	ctx.Logger.Info(fmt.Sprintf("%d files changed", len(files)))
 
 	return &sdk.Result{
 		Success:  true,
 		ExitCode: 0,
-		Output:   fmt.Sprintf("Cloned %s (branch: %s)", url, branch),
+		Output:   fmt.Sprintf("Checked out %s (%s) at %s", redactURL(url), co.target(), head),
+		Metadata: map[string]interface{}{
+			"commit_sha":         head,
+			"ref":                co.target(),
+			"changed_files_base": base,
+		},
+		Commit: commit,
 	}, nil
 }
 
-func (p *GitSCMPlugin) Clone(url, branch, commitSHA, dest string) error {
-	args := []string{"clone"}
-
-	if p.depth > 0 {
-		args = append(args, "--depth", fmt.Sprintf("%d", p.depth))
+func (p *GitSCMPlugin) Clone(ctx context.Context, url, branch, commitSHA, dest string) error {
+	co := &checkout{url: url, branch: branch, commit: commitSHA, dest: dest}
+	if _, err := p.sync(ctx, co); err != nil {
+		return err
 	}
+	p.last = co
+	return nil
+}
 
-	if !p.submodules {
-		args = append(args, "--no-recurse-submodules")
+// GetCommitInfo returns the metadata of a commit of the last checkout, and the
+// files it changes since its first parent
+func (p *GitSCMPlugin) GetCommitInfo(ctx context.Context, commitSHA string) (*sdk.CommitInfo, error) {
+	if p.last == nil {
+		return nil, fmt.Errorf("no repository checked out")
 	}
-
-	args = append(args, "--branch", branch, url, dest)
-
-	cmd := exec.Command("git", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("git clone failed: %w", err)
+	info, err := p.commitInfo(ctx, p.last, commitSHA)
+	if err != nil {
+		return nil, err
 	}
-
-	// Checkout specific commit if provided
-	if commitSHA != "" {
-		cmd := exec.Command("git", "checkout", commitSHA)
-		cmd.Dir = dest
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("git checkout failed: %w", err)
-		}
+	if info.ChangedFiles, _, err = p.changedFiles(ctx, p.last, "", info.SHA); err != nil {
+		return nil, err
 	}
-
-	return nil
-}
-
-func (p *GitSCMPlugin) GetCommitInfo(commitSHA string) (*sdk.CommitInfo, error) {
-	// TODO: Implement commit info retrieval
-	return &sdk.CommitInfo{
-		SHA: commitSHA,
-	}, nil
+	return info, nil
 }
 
 func (p *GitSCMPlugin) Cleanup() error {
@@ -126,9 +197,7 @@ func (p *GitSCMPlugin) Cleanup() error {
 // Export the plugin
 var Plugin GitSCMPlugin
 
-// Main function for standalone testing
+// Serve the plugin to the worker agent
 func main() {
-	fmt.Println("Git SCM Plugin v1.0.0")
-	fmt.Println("This is a plugin and should be loaded by the Ritmo system")
-	fmt.Println("To build as a plugin: go build -buildmode=plugin -o git-scm.so")
+	sdk.Serve(&Plugin)
 }
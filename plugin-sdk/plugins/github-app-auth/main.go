@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// GitHubAppAuthPlugin is a secret provider that mints short-lived
+// installation tokens for a GitHub App, so clones and API calls such as
+// commit status updates need no long-lived personal access token. A
+// reference names what the token is for:
+//
+//	token                   the configured installation_id
+//	installation/<id>       an installation of the App
+//	<owner>/<repo>          the installation on the repository, with the token limited to it
+//
+// A step references one as "<provider>:<reference>", for example
+//
+//	{"secrets": {"GIT_TOKEN": "github:acme/widgets"}}
+//
+// with git-scm's credentials set to GIT_TOKEN. Installation tokens are used
+// with the username x-access-token, git-scm's default.
+type GitHubAppAuthPlugin struct {
+	appID          string
+	installationID string
+	key            *rsa.PrivateKey
+	apiURL         string
+	permissions    map[string]string // narrows the token to these permissions
+	client         *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]installationToken // by reference
+}
+
+// installationToken is a minted token and when it expires
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// tokenRefreshMargin is how long before it expires a cached token is replaced
+const tokenRefreshMargin = 5 * time.Minute
+
+func (p *GitHubAppAuthPlugin) Name() string {
+	return "github-app-auth"
+}
+
+func (p *GitHubAppAuthPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *GitHubAppAuthPlugin) Type() string {
+	return "secret"
+}
+
+func (p *GitHubAppAuthPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork}
+}
+
+func (p *GitHubAppAuthPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	p.appID = idString(cfg, "app_id")
+	if p.appID == "" {
+		return fmt.Errorf("missing required config: app_id")
+	}
+	p.installationID = idString(cfg, "installation_id")
+	p.apiURL = strings.TrimSuffix(cfg.String("api_url", "https://api.github.com"), "/")
+	p.permissions = cfg.StringMap("permissions")
+	p.client = &http.Client{Timeout: cfg.Duration("timeout", 30*time.Second)}
+	p.tokens = make(map[string]installationToken)
+
+	// The key is given inline, as a file or in the environment, in that order
+	pemData := []byte(cfg.String("private_key", ""))
+	if len(pemData) == 0 {
+		if file := cfg.String("private_key_file", ""); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read private_key_file: %w", err)
+			}
+			pemData = data
+		} else {
+			pemData = []byte(os.Getenv("GITHUB_APP_PRIVATE_KEY"))
+		}
+	}
+	if len(pemData) == 0 {
+		return fmt.Errorf("no private key: set private_key, private_key_file or GITHUB_APP_PRIVATE_KEY")
+	}
+	key, err := parsePrivateKey(pemData)
+	if err != nil {
+		return err
+	}
+	p.key = key
+	return nil
+}
+
+// Health checks that GitHub accepts the App's credentials
+func (p *GitHubAppAuthPlugin) Health() error {
+	var app struct {
+		Slug string `json:"slug"`
+	}
+	if err := p.appRequest(context.Background(), http.MethodGet, "/app", nil, &app); err != nil {
+		return fmt.Errorf("GitHub App authentication failed: %w", err)
+	}
+	return nil
+}
+
+// Execute is never called for secret providers
+func (p *GitHubAppAuthPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	err := fmt.Errorf("github-app-auth is a secret provider and cannot run as a build step")
+	return &sdk.Result{Success: false, ExitCode: 1, ErrorMessage: err.Error()}, err
+}
+
+// ResolveSecret returns an installation token for the reference, minting one
+// if there is no cached token that is valid for a while yet
+func (p *GitHubAppAuthPlugin) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.tokens[ref]; ok && time.Until(t.ExpiresAt) > tokenRefreshMargin {
+		return t.Token, nil
+	}
+
+	body := map[string]interface{}{}
+	if len(p.permissions) > 0 {
+		body["permissions"] = p.permissions
+	}
+
+	var installationID string
+	switch {
+	case ref == "token":
+		if p.installationID == "" {
+			return "", fmt.Errorf("reference \"token\" needs installation_id in the config")
+		}
+		installationID = p.installationID
+	case strings.HasPrefix(ref, "installation/"):
+		installationID = strings.TrimPrefix(ref, "installation/")
+		if _, err := strconv.ParseInt(installationID, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid installation ID in reference %q", ref)
+		}
+	default:
+		owner, repo, ok := strings.Cut(ref, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return "", fmt.Errorf("reference must be token, installation/<id> or <owner>/<repo>, got %q", ref)
+		}
+		var installation struct {
+			ID int64 `json:"id"`
+		}
+		if err := p.appRequest(ctx, http.MethodGet, "/repos/"+owner+"/"+repo+"/installation", nil, &installation); err != nil {
+			return "", fmt.Errorf("App is not installed on %s: %w", ref, err)
+		}
+		installationID = strconv.FormatInt(installation.ID, 10)
+		body["repositories"] = []string{repo}
+	}
+
+	var token installationToken
+	if err := p.appRequest(ctx, http.MethodPost, "/app/installations/"+installationID+"/access_tokens", body, &token); err != nil {
+		return "", fmt.Errorf("failed to create installation token: %w", err)
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("GitHub returned no installation token")
+	}
+	p.tokens[ref] = token
+	return token.Token, nil
+}
+
+// appRequest calls the GitHub API authenticated as the App and decodes the
+// JSON response into out
+func (p *GitHubAppAuthPlugin) appRequest(ctx context.Context, method, path string, body, out interface{}) error {
+	jwt, err := appJWT(p.appID, p.key, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("GitHub returned status %d: %s", resp.StatusCode, apiErr.Message)
+		}
+		return fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Cleanup revokes the tokens minted for the step. The worker agent cleans up
+// secret providers when the step ends, so the tokens do not outlive it.
+func (p *GitHubAppAuthPlugin) Cleanup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var failed []string
+	for ref, t := range p.tokens {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, p.apiURL+"/installation/token", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		resp, err := p.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		if err != nil {
+			failed = append(failed, ref)
+		}
+		delete(p.tokens, ref)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to revoke installation tokens for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// idString reads a numeric ID given as a number or a string
+func idString(cfg sdk.Config, key string) string {
+	if n := cfg.Int(key, 0); n > 0 {
+		return strconv.Itoa(n)
+	}
+	return cfg.String(key, "")
+}
+
+func main() {
+	sdk.Serve(&GitHubAppAuthPlugin{})
+}
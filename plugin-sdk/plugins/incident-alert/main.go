@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// Build severities, from the most to the least urgent
+const (
+	severityCritical = "critical"
+	severityError    = "error"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+)
+
+// defaultPriorities maps build severities to Opsgenie priorities. PagerDuty
+// takes the severity itself.
+var defaultPriorities = map[string]string{
+	severityCritical: "P1",
+	severityError:    "P2",
+	severityWarning:  "P3",
+	severityInfo:     "P5",
+}
+
+// IncidentAlertPlugin opens a PagerDuty or Opsgenie alert when a build of a
+// protected job fails and resolves it when a later build succeeds. The alert
+// is keyed by job and branch, so repeated failures update the open alert
+// instead of opening new ones. Run it as the last step with always_run so it
+// sees the outcome of every step before it.
+//
+// The build's severity is the job's severity label, or else the configured
+// severity. PagerDuty takes it as the event severity and Opsgenie as a
+// priority through priorities.
+//
+//	plugins:
+//	  - name: incident-alert
+//	    always_run: true
+//	    secrets: {PAGERDUTY_KEY: "vault:secret/data/ci/pagerduty#routing_key"}
+//	    config:
+//	      provider: pagerduty
+//	      api_key_secret: PAGERDUTY_KEY
+//	      branches: [main, "release/*"]
+//	      severity: error
+type IncidentAlertPlugin struct {
+	provider      provider
+	apiKeySecret  string // name of the secret holding the routing or API key
+	branches      []string
+	severity      string // default build severity
+	severityLabel string // job label that overrides the severity
+	priorities    map[string]string
+	dedupKey      string // overrides the job and branch key
+	source        string
+
+	client *http.Client
+}
+
+func (p *IncidentAlertPlugin) Name() string {
+	return "incident-alert"
+}
+
+func (p *IncidentAlertPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *IncidentAlertPlugin) Type() string {
+	return "notification"
+}
+
+func (p *IncidentAlertPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilitySecrets}
+}
+
+func (p *IncidentAlertPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+
+	p.apiKeySecret = cfg.String("api_key_secret", "")
+	if p.apiKeySecret == "" {
+		return fmt.Errorf("api_key_secret is required")
+	}
+
+	switch name := strings.ToLower(cfg.String("provider", "")); name {
+	case "pagerduty":
+		p.provider = &pagerDuty{url: cfg.String("api_url", "https://events.pagerduty.com/v2/enqueue")}
+	case "opsgenie":
+		p.provider = &opsgenie{url: strings.TrimSuffix(cfg.String("api_url", "https://api.opsgenie.com"), "/")}
+	case "":
+		return fmt.Errorf("provider is required (pagerduty or opsgenie)")
+	default:
+		return fmt.Errorf("invalid provider %q (expected pagerduty or opsgenie)", name)
+	}
+
+	// branches is a pattern or a list of them
+	if cfg.Slice("branches") != nil {
+		p.branches = cfg.StringSlice("branches")
+	} else if b := cfg.String("branches", ""); b != "" {
+		p.branches = []string{b}
+	} else {
+		p.branches = nil
+	}
+	for _, pattern := range p.branches {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid branches pattern %q", pattern)
+		}
+	}
+
+	p.severity = strings.ToLower(cfg.String("severity", severityCritical))
+	if _, ok := defaultPriorities[p.severity]; !ok {
+		return fmt.Errorf("invalid severity %q (expected critical, error, warning or info)", p.severity)
+	}
+	p.severityLabel = cfg.String("severity_label", "severity")
+
+	p.priorities = map[string]string{}
+	for severity, priority := range defaultPriorities {
+		p.priorities[severity] = priority
+	}
+	for severity, priority := range cfg.StringMap("priorities") {
+		severity = strings.ToLower(severity)
+		if _, ok := defaultPriorities[severity]; !ok {
+			return fmt.Errorf("priorities: invalid severity %q", severity)
+		}
+		switch priority = strings.ToUpper(priority); priority {
+		case "P1", "P2", "P3", "P4", "P5":
+			p.priorities[severity] = priority
+		default:
+			return fmt.Errorf("priorities: invalid priority %q for %s (expected P1 to P5)", priority, severity)
+		}
+	}
+
+	p.dedupKey = cfg.String("dedup_key", "")
+	p.source = cfg.String("source", "solvyd")
+
+	p.client = &http.Client{Timeout: 30 * time.Second}
+	return nil
+}
+
+func (p *IncidentAlertPlugin) Health() error {
+	u, err := url.Parse(p.provider.apiURL())
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("api_url must be an https URL")
+	}
+	return nil
+}
+
+func (p *IncidentAlertPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	params := sdk.Config(ctx.Parameters)
+	labels := params.StringMap("job_labels")
+	a := alert{
+		key:         p.dedupKey,
+		jobName:     params.String("job_name", ""),
+		buildNumber: params.Int("build_number", 0),
+		buildID:     ctx.BuildID,
+		branch:      params.String("branch", ""),
+		commit:      params.String("commit_sha", ""),
+		source:      p.source,
+		severity:    p.severity,
+		labels:      labels,
+	}
+	if a.key == "" {
+		a.key = alertKey(a.jobName, ctx.JobID, a.branch)
+	}
+	if s := strings.ToLower(labels[p.severityLabel]); s != "" {
+		if _, ok := defaultPriorities[s]; ok {
+			a.severity = s
+		} else {
+			ctx.Logger.Warn(fmt.Sprintf("Ignoring unknown severity %q in job label %s", s, p.severityLabel))
+		}
+	}
+	a.priority = p.priorities[a.severity]
+
+	if !p.protected(a.branch) {
+		ctx.Logger.Info(fmt.Sprintf("Branch %s is not protected, not alerting", a.branch))
+		return &sdk.Result{Success: true, Output: "Branch not protected", Metadata: map[string]interface{}{"action": "none"}}, nil
+	}
+
+	for _, s := range params.Maps("steps") {
+		if s.String("status", "") == "failed" {
+			a.failedSteps = append(a.failedSteps, failedStep{name: s.String("name", ""), message: s.String("error_message", "")})
+		}
+	}
+
+	key, err := ctx.Secret(p.apiKeySecret)
+	if err != nil {
+		return failure(fmt.Sprintf("Failed to get API key: %v", err)), err
+	}
+
+	metadata := map[string]interface{}{"dedup_key": a.key, "provider": p.provider.name()}
+	if params.String("build_status", "") == "failed" {
+		if err := p.provider.trigger(ctx, p.client, key, a); err != nil {
+			return failure(fmt.Sprintf("Failed to open %s alert: %v", p.provider.name(), err)), err
+		}
+		metadata["action"] = "trigger"
+		metadata["severity"] = a.severity
+		ctx.Logger.Info(fmt.Sprintf("Opened %s alert %s with severity %s", p.provider.name(), a.key, a.severity))
+		return &sdk.Result{Success: true, Output: "Alert opened: " + a.summary(), Metadata: metadata}, nil
+	}
+
+	if err := p.provider.resolve(ctx, p.client, key, a); err != nil {
+		return failure(fmt.Sprintf("Failed to resolve %s alert: %v", p.provider.name(), err)), err
+	}
+	metadata["action"] = "resolve"
+	ctx.Logger.Info(fmt.Sprintf("Resolved %s alert %s", p.provider.name(), a.key))
+	return &sdk.Result{Success: true, Output: "Alert resolved", Metadata: metadata}, nil
+}
+
+// protected reports whether builds of the branch raise alerts
+func (p *IncidentAlertPlugin) protected(branch string) bool {
+	if len(p.branches) == 0 {
+		return true
+	}
+	for _, pattern := range p.branches {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *IncidentAlertPlugin) Cleanup() error {
+	return nil
+}
+
+// alertKey identifies the alert of a job's branch across builds
+func alertKey(jobName, jobID, branch string) string {
+	job := jobName
+	if job == "" {
+		job = jobID
+	}
+	key := "solvyd/" + job
+	if branch != "" {
+		key += "/" + branch
+	}
+	return key
+}
+
+func failure(message string) *sdk.Result {
+	return &sdk.Result{Success: false, ErrorMessage: message}
+}
+
+// Export the plugin
+var Plugin IncidentAlertPlugin
+
+// Serve the plugin to the worker agent
+func main() {
+	sdk.Serve(&Plugin)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// provider opens and resolves alerts in an incident management service
+type provider interface {
+	name() string
+	apiURL() string
+	trigger(ctx context.Context, client *http.Client, key string, a alert) error
+	resolve(ctx context.Context, client *http.Client, key string, a alert) error
+}
+
+// alert describes a failed build
+type alert struct {
+	key         string // identifies the alert across builds
+	jobName     string
+	buildNumber int
+	buildID     string
+	branch      string
+	commit      string
+	source      string
+	severity    string
+	priority    string
+	labels      map[string]string
+	failedSteps []failedStep
+}
+
+type failedStep struct {
+	name    string
+	message string
+}
+
+func (a alert) summary() string {
+	job := a.jobName
+	if job == "" {
+		job = "Build"
+	}
+	s := job
+	if a.buildNumber > 0 {
+		s += fmt.Sprintf(" #%d", a.buildNumber)
+	}
+	s += " failed"
+	if a.branch != "" {
+		s += " on " + a.branch
+	}
+	if len(a.failedSteps) > 0 {
+		s += " in step " + a.failedSteps[0].name
+	}
+	return s
+}
+
+// details are the alert's fields beyond its summary
+func (a alert) details() map[string]interface{} {
+	d := map[string]interface{}{"build_id": a.buildID}
+	if a.branch != "" {
+		d["branch"] = a.branch
+	}
+	if a.commit != "" {
+		d["commit"] = a.commit
+	}
+	if a.buildNumber > 0 {
+		d["build_number"] = a.buildNumber
+	}
+	if len(a.failedSteps) > 0 {
+		steps := map[string]string{}
+		for _, s := range a.failedSteps {
+			steps[s.name] = truncate(s.message, 1000)
+		}
+		d["failed_steps"] = steps
+	}
+	return d
+}
+
+// pagerDuty sends events to the PagerDuty Events API v2 with an
+// integration's routing key
+type pagerDuty struct {
+	url string
+}
+
+func (p *pagerDuty) name() string   { return "pagerduty" }
+func (p *pagerDuty) apiURL() string { return p.url }
+
+func (p *pagerDuty) trigger(ctx context.Context, client *http.Client, key string, a alert) error {
+	return p.send(ctx, client, map[string]interface{}{
+		"routing_key":  key,
+		"event_action": "trigger",
+		"dedup_key":    truncate(a.key, 255),
+		"payload": map[string]interface{}{
+			"summary":        truncate(a.summary(), 1024),
+			"source":         a.source,
+			"severity":       a.severity,
+			"component":      a.jobName,
+			"group":          a.branch,
+			"class":          "build failure",
+			"custom_details": a.details(),
+		},
+	})
+}
+
+func (p *pagerDuty) resolve(ctx context.Context, client *http.Client, key string, a alert) error {
+	return p.send(ctx, client, map[string]interface{}{
+		"routing_key":  key,
+		"event_action": "resolve",
+		"dedup_key":    truncate(a.key, 255),
+	})
+}
+
+func (p *pagerDuty) send(ctx context.Context, client *http.Client, event map[string]interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(client, req)
+}
+
+// opsgenie creates and closes alerts through the Opsgenie Alert API with an
+// API integration's key. The alert key is the alert's alias.
+type opsgenie struct {
+	url string
+}
+
+func (o *opsgenie) name() string   { return "opsgenie" }
+func (o *opsgenie) apiURL() string { return o.url }
+
+func (o *opsgenie) trigger(ctx context.Context, client *http.Client, key string, a alert) error {
+	tags := []string{"solvyd"}
+	for k, v := range a.labels {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags[1:])
+
+	details := map[string]string{}
+	for k, v := range a.details() {
+		if k == "failed_steps" {
+			continue
+		}
+		details[k] = fmt.Sprint(v)
+	}
+
+	var description strings.Builder
+	for _, s := range a.failedSteps {
+		fmt.Fprintf(&description, "%s failed", s.name)
+		if s.message != "" {
+			fmt.Fprintf(&description, ": %s", truncate(s.message, 1000))
+		}
+		description.WriteString("\n")
+	}
+
+	return o.send(ctx, client, key, "/v2/alerts", map[string]interface{}{
+		"message":     truncate(a.summary(), 130),
+		"alias":       truncate(a.key, 512),
+		"description": truncate(description.String(), 15000),
+		"tags":        tags,
+		"details":     details,
+		"entity":      a.jobName,
+		"source":      a.source,
+		"priority":    a.priority,
+	})
+}
+
+func (o *opsgenie) resolve(ctx context.Context, client *http.Client, key string, a alert) error {
+	path := "/v2/alerts/" + url.PathEscape(truncate(a.key, 512)) + "/close?identifierType=alias"
+	note := "Build succeeded"
+	if a.buildNumber > 0 {
+		note = fmt.Sprintf("Build #%d succeeded", a.buildNumber)
+	}
+	return o.send(ctx, client, key, path, map[string]interface{}{"source": a.source, "note": note})
+}
+
+func (o *opsgenie) send(ctx context.Context, client *http.Client, key, path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", o.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+key)
+	return do(client, req)
+}
+
+// do sends the request and returns the service's error message for
+// unsuccessful responses
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Message string   `json:"message"`
+		Errors  []string `json:"errors"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Message != "" {
+		if len(body.Errors) > 0 {
+			return fmt.Errorf("status %d: %s: %s", resp.StatusCode, body.Message, strings.Join(body.Errors, "; "))
+		}
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body.Message)
+	}
+	return fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -5,13 +5,22 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
 )
 
+// defaultReportPath matches the reports of Gradle, and of most tools writing
+// into a test-results directory
+const defaultReportPath = "**/test-results/**/*.xml"
+
 // JUnitTestReporterPlugin processes JUnit XML test reports
 type JUnitTestReporterPlugin struct {
-	reportPath     string
+	reportPaths    []string
 	coverageMin    float64
 	failOnError    bool
 	includeSkipped bool
@@ -58,6 +67,45 @@ type Skipped struct {
 	Message string `xml:"message,attr"`
 }
 
+// maxFailedTests is the most failed tests listed in the failed_tests metadata;
+// all of them are still reported as test cases
+const maxFailedTests = 100
+
+// maxTestDetails is the most bytes of a failure's stack trace that are kept
+const maxTestDetails = 16 << 10
+
+// testCase converts a test case to its reported form. ok is false for passed
+// tests, and for skipped ones unless include_skipped is set.
+func (p *JUnitTestReporterPlugin) testCase(suite string, tc TestCase) (c sdk.TestCase, ok bool) {
+	c = sdk.TestCase{
+		Suite:     suite,
+		ClassName: tc.ClassName,
+		Name:      tc.Name,
+		Duration:  time.Duration(tc.Time * float64(time.Second)),
+	}
+	switch {
+	case tc.Error != nil:
+		c.Status, c.Message, c.Details = sdk.TestError, tc.Error.Message, tc.Error.Content
+		if c.Message == "" {
+			c.Message = tc.Error.Type
+		}
+	case tc.Failure != nil:
+		c.Status, c.Message, c.Details = sdk.TestFailed, tc.Failure.Message, tc.Failure.Content
+		if c.Message == "" {
+			c.Message = tc.Failure.Type
+		}
+	case tc.Skipped != nil && p.includeSkipped:
+		c.Status, c.Message = sdk.TestSkipped, tc.Skipped.Message
+	default:
+		return c, false
+	}
+	c.Details = strings.TrimSpace(c.Details)
+	if len(c.Details) > maxTestDetails {
+		c.Details = c.Details[:maxTestDetails] + "\n... (truncated)"
+	}
+	return c, true
+}
+
 func (p *JUnitTestReporterPlugin) Name() string {
 	return "junit-test-reporter"
 }
@@ -70,20 +118,42 @@ func (p *JUnitTestReporterPlugin) Type() string {
 	return "test"
 }
 
+func (p *JUnitTestReporterPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityWorkspaceReadOnly}
+}
+
 func (p *JUnitTestReporterPlugin) Initialize(config map[string]interface{}) error {
-	p.reportPath = getStringConfig(config, "report_path", "**/test-results/**/*.xml")
-	p.coverageMin = getFloatConfig(config, "coverage_min", 0.0)
-	p.failOnError = getBoolConfig(config, "fail_on_error", true)
-	p.includeSkipped = getBoolConfig(config, "include_skipped", false)
+	cfg := sdk.Config(config)
+	// report_path is a pattern or a list of them
+	if cfg.Slice("report_path") != nil {
+		p.reportPaths = cfg.StringSlice("report_path")
+	} else {
+		p.reportPaths = []string{cfg.String("report_path", defaultReportPath)}
+	}
+	if len(p.reportPaths) == 0 {
+		return fmt.Errorf("report_path must not be empty")
+	}
+	for _, pattern := range p.reportPaths {
+		if filepath.IsAbs(pattern) || !doublestar.ValidatePattern(pattern) {
+			return fmt.Errorf("invalid report_path pattern %q: patterns are relative to the workspace", pattern)
+		}
+	}
+	p.coverageMin = cfg.Float("coverage_min", 0.0)
+	p.failOnError = cfg.Bool("fail_on_error", true)
+	p.includeSkipped = cfg.Bool("include_skipped", false)
 
 	return nil
 }
 
+func (p *JUnitTestReporterPlugin) Health() error {
+	return nil
+}
+
 func (p *JUnitTestReporterPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
 	ctx.Logger.Info("Processing JUnit test reports")
 
 	// Find all test report files
-	files, err := filepath.Glob(filepath.Join(ctx.WorkDir, p.reportPath))
+	files, err := findReports(ctx.WorkDir, p.reportPaths)
 	if err != nil {
 		return &sdk.Result{
 			Success:      false,
@@ -95,7 +165,7 @@ func (p *JUnitTestReporterPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resul
 		return &sdk.Result{
 			Success:      false,
 			ErrorMessage: "No test report files found",
-		}, fmt.Errorf("no test reports found at %s", p.reportPath)
+		}, fmt.Errorf("no test reports match report_path %s in the workspace", strings.Join(p.reportPaths, ", "))
 	}
 
 	ctx.Logger.Info(fmt.Sprintf("Found %d test report files", len(files)))
@@ -106,6 +176,7 @@ func (p *JUnitTestReporterPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resul
 	totalErrors := 0
 	totalSkipped := 0
 	totalTime := 0.0
+	var testCases []sdk.TestCase
 
 	for _, file := range files {
 		data, err := os.ReadFile(file)
@@ -132,6 +203,11 @@ func (p *JUnitTestReporterPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resul
 			totalErrors += suite.Errors
 			totalSkipped += suite.Skipped
 			totalTime += suite.Time
+			for _, tc := range suite.TestCases {
+				if c, ok := p.testCase(suite.Name, tc); ok {
+					testCases = append(testCases, c)
+				}
+			}
 		}
 	}
 
@@ -144,10 +220,11 @@ func (p *JUnitTestReporterPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resul
 
 	// Build result
 	result := &sdk.Result{
-		Success:  totalFailures == 0 && totalErrors == 0,
-		ExitCode: 0,
-		Metadata: make(map[string]interface{}),
-		Output:   fmt.Sprintf("Tests: %d, Passed: %d, Failed: %d, Errors: %d, Skipped: %d, Pass Rate: %.2f%%", totalTests, totalPassed, totalFailures, totalErrors, totalSkipped, passRate),
+		Success:   totalFailures == 0 && totalErrors == 0,
+		ExitCode:  0,
+		Metadata:  make(map[string]interface{}),
+		TestCases: testCases,
+		Output:    fmt.Sprintf("Tests: %d, Passed: %d, Failed: %d, Errors: %d, Skipped: %d, Pass Rate: %.2f%%", totalTests, totalPassed, totalFailures, totalErrors, totalSkipped, passRate),
 	}
 
 	if (totalFailures > 0 || totalErrors > 0) && p.failOnError {
@@ -163,42 +240,61 @@ func (p *JUnitTestReporterPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resul
 	result.Metadata["pass_rate"] = passRate
 	result.Metadata["total_time"] = totalTime
 
+	var failed []map[string]interface{}
+	for _, c := range testCases {
+		if c.Status == sdk.TestSkipped {
+			continue
+		}
+		if len(failed) == maxFailedTests {
+			result.Metadata["failed_tests_truncated"] = true
+			break
+		}
+		failed = append(failed, map[string]interface{}{
+			"class_name":  c.ClassName,
+			"name":        c.Name,
+			"status":      c.Status,
+			"message":     c.Message,
+			"duration_ms": c.Duration.Milliseconds(),
+		})
+		ctx.Logger.Warn(fmt.Sprintf("%s %s.%s: %s", c.Status, c.ClassName, c.Name, c.Message))
+	}
+	if len(failed) > 0 {
+		result.Metadata["failed_tests"] = failed
+	}
+
 	ctx.Logger.Info(result.Output)
 
 	return result, nil
 }
 
-func (p *JUnitTestReporterPlugin) Cleanup() error {
-	return nil
-}
-
-// Helper functions
-func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
-	if val, ok := config[key].(string); ok {
-		return val
-	}
-	return defaultValue
-}
-
-func getFloatConfig(config map[string]interface{}, key string, defaultValue float64) float64 {
-	if val, ok := config[key].(float64); ok {
-		return val
+// findReports returns the files in dir matching any of the patterns, which
+// are slash-separated and may use ** to match any number of directories
+func findReports(dir string, patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := doublestar.Glob(os.DirFS(dir), filepath.ToSlash(pattern), doublestar.WithFilesOnly())
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, filepath.Join(dir, filepath.FromSlash(m)))
+			}
+		}
 	}
-	return defaultValue
+	sort.Strings(files)
+	return files, nil
 }
 
-func getBoolConfig(config map[string]interface{}, key string, defaultValue bool) bool {
-	if val, ok := config[key].(bool); ok {
-		return val
-	}
-	return defaultValue
+func (p *JUnitTestReporterPlugin) Cleanup() error {
+	return nil
 }
 
 // Export the plugin
 var Plugin JUnitTestReporterPlugin
 
 func main() {
-	fmt.Println("JUnit Test Reporter Plugin v1.0.0")
-	fmt.Println("This plugin processes and reports JUnit XML test results")
-	fmt.Println("To build: go build -o junit-test-reporter")
+	sdk.Serve(&Plugin)
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/plugintest"
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+const gradleReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuite name="com.example.AppTest" tests="4" failures="1" errors="1" skipped="1" time="1.5">
+  <testcase name="passes" classname="com.example.AppTest" time="0.25"/>
+  <testcase name="fails" classname="com.example.AppTest" time="0.5">
+    <failure message="expected 2 but was 3" type="AssertionError">
+      at com.example.AppTest.fails(AppTest.java:12)
+    </failure>
+  </testcase>
+  <testcase name="breaks" classname="com.example.AppTest" time="0.75">
+    <error type="NullPointerException"/>
+  </testcase>
+  <testcase name="ignored" classname="com.example.AppTest">
+    <skipped message="flaky"/>
+  </testcase>
+</testsuite>
+`
+
+const passingReport = `<testsuites>
+  <testsuite name="lib" tests="2" time="0.5">
+    <testcase name="a" classname="lib"/>
+    <testcase name="b" classname="lib"/>
+  </testsuite>
+</testsuites>
+`
+
+func TestExecute(t *testing.T) {
+	ctx := plugintest.NewContext(t)
+	ctx.WriteFiles(map[string]string{
+		"build/test-results/test/TEST-com.example.AppTest.xml": gradleReport,
+		"lib/test-results/junit.xml":                           passingReport,
+		"build/test-results/test/notes.txt":                    "not a report",
+	})
+
+	result, err := plugintest.Run(t, &JUnitTestReporterPlugin{}, map[string]interface{}{
+		"include_skipped": true,
+	}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx.Log.AssertLogged("info", "Found 2 test report files")
+	ctx.Log.AssertLogged("warn", "failed com.example.AppTest.fails: expected 2 but was 3")
+	ctx.Log.AssertLogged("warn", "error com.example.AppTest.breaks: NullPointerException")
+	ctx.Log.AssertNotLogged("warn", "ignored")
+	ctx.Log.AssertNoErrors()
+	ctx.AssertGolden("testdata/execute.golden.json", result)
+}
+
+func TestExecuteNoReports(t *testing.T) {
+	ctx := plugintest.NewContext(t)
+	ctx.WriteFiles(map[string]string{"reports/junit.xml": passingReport})
+
+	result, err := plugintest.Run(t, &JUnitTestReporterPlugin{}, nil, ctx)
+	if err == nil {
+		t.Fatal("expected an error when no reports match")
+	}
+	if result.Success {
+		t.Error("expected the step to fail")
+	}
+}
+
+func TestExecuteReportPaths(t *testing.T) {
+	ctx := plugintest.NewContext(t)
+	ctx.WriteFiles(map[string]string{
+		"reports/junit.xml":     gradleReport,
+		"reports/lib/junit.xml": passingReport,
+	})
+
+	result, err := plugintest.Run(t, &JUnitTestReporterPlugin{}, map[string]interface{}{
+		"report_path":   []interface{}{"reports/lib/*.xml"},
+		"fail_on_error": false,
+	}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Success || result.Metadata["total_tests"] != 2 {
+		t.Errorf("result = %+v, want only the passing report", result)
+	}
+}
+
+func TestTestCase(t *testing.T) {
+	tests := []struct {
+		name           string
+		includeSkipped bool
+		tc             TestCase
+		want           sdk.TestCase
+		wantOK         bool
+	}{
+		{
+			name: "passed",
+			tc:   TestCase{Name: "a"},
+		},
+		{
+			name: "skipped",
+			tc:   TestCase{Name: "a", Skipped: &Skipped{Message: "later"}},
+		},
+		{
+			name:           "skipped included",
+			includeSkipped: true,
+			tc:             TestCase{Name: "a", Skipped: &Skipped{Message: "later"}},
+			want:           sdk.TestCase{Suite: "s", Name: "a", Status: sdk.TestSkipped, Message: "later"},
+			wantOK:         true,
+		},
+		{
+			name:   "failure type as message",
+			tc:     TestCase{Name: "a", Time: 0.5, Failure: &Failure{Type: "AssertionError", Content: "\n  trace\n"}},
+			want:   sdk.TestCase{Suite: "s", Name: "a", Duration: 500e6, Status: sdk.TestFailed, Message: "AssertionError", Details: "trace"},
+			wantOK: true,
+		},
+		{
+			name:   "error wins over failure",
+			tc:     TestCase{Name: "a", Failure: &Failure{Message: "f"}, Error: &Error{Message: "e"}},
+			want:   sdk.TestCase{Suite: "s", Name: "a", Status: sdk.TestError, Message: "e"},
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &JUnitTestReporterPlugin{includeSkipped: tt.includeSkipped}
+			got, ok := p.testCase("s", tt.tc)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && got != tt.want {
+				t.Errorf("testCase = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
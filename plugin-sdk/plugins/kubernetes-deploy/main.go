@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+const (
+	serviceAccountDir    = "/var/run/secrets/kubernetes.io/serviceaccount"
+	revisionAnnotation   = "deployment.kubernetes.io/revision"
+	rolloutSuccessMarker = "successfully rolled out"
+)
+
+// KubernetesDeployPlugin deploys workloads to a Kubernetes cluster using kubectl
+type KubernetesDeployPlugin struct {
+	kubeconfig       string
+	kubeconfigSecret string
+	kubeContext      string
+	inCluster        bool
+	namespace        string
+	manifests        []string
+	deployment       string
+	container        string
+	image            string
+	timeout          time.Duration
+
+	workDir        string
+	tempKubeconfig string
+}
+
+// workload identifies a rolled out resource, encoded as namespace/kind/name@revision
+type workload struct {
+	Namespace string
+	Kind      string
+	Name      string
+	Revision  int
+}
+
+func (w workload) String() string {
+	return fmt.Sprintf("%s/%s/%s@%d", w.Namespace, w.Kind, w.Name, w.Revision)
+}
+
+func (w workload) resource() string {
+	return w.Kind + "/" + w.Name
+}
+
+func (p *KubernetesDeployPlugin) Name() string {
+	return "kubernetes-deploy"
+}
+
+func (p *KubernetesDeployPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *KubernetesDeployPlugin) Type() string {
+	return "deployment"
+}
+
+func (p *KubernetesDeployPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspaceReadOnly, sdk.CapabilitySecrets}
+}
+
+func (p *KubernetesDeployPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	p.kubeconfig = cfg.String("kubeconfig", "")
+	p.kubeconfigSecret = cfg.String("kubeconfig_secret", "")
+	p.kubeContext = cfg.String("context", "")
+	p.inCluster = cfg.Bool("in_cluster", false)
+	p.namespace = cfg.String("namespace", "default")
+	p.deployment = cfg.String("deployment", "")
+	p.container = cfg.String("container", "")
+	p.image = cfg.String("image", "")
+	p.timeout = cfg.Duration("timeout", 5*time.Minute)
+
+	p.manifests = cfg.StringSlice("manifests")
+	if manifest := cfg.String("manifests", ""); manifest != "" {
+		p.manifests = []string{manifest}
+	}
+
+	if len(p.manifests) == 0 && p.deployment == "" {
+		return fmt.Errorf("either manifests or deployment must be configured")
+	}
+
+	if p.inCluster && (p.kubeconfig != "" || p.kubeconfigSecret != "") {
+		return fmt.Errorf("in_cluster is mutually exclusive with kubeconfig and kubeconfig_secret")
+	}
+
+	return nil
+}
+
+func (p *KubernetesDeployPlugin) Health() error {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return fmt.Errorf("kubectl is not installed: %w", err)
+	}
+	if p.inCluster {
+		if _, err := os.Stat(filepath.Join(serviceAccountDir, "token")); err != nil {
+			return fmt.Errorf("in_cluster is set but no service account token is mounted: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *KubernetesDeployPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	p.workDir = ctx.WorkDir
+	params := sdk.Config(ctx.Parameters)
+
+	req := &sdk.DeploymentRequest{
+		Environment: params.String("environment", ""),
+		ArtifactURL: params.String("image", ""),
+		Config:      ctx.Parameters,
+		Secrets:     make(map[string]string),
+	}
+	if p.kubeconfigSecret != "" {
+		kubeconfig, err := ctx.Secret(p.kubeconfigSecret)
+		if err != nil {
+			return &sdk.Result{Success: false, ExitCode: 1, ErrorMessage: err.Error()}, err
+		}
+		req.Secrets[p.kubeconfigSecret] = kubeconfig
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Starting Kubernetes deployment to namespace %s", p.namespace))
+
+	deployResult, err := p.Deploy(ctx, req)
+	if err != nil {
+		return &sdk.Result{
+			Success:      false,
+			ExitCode:     1,
+			ErrorMessage: fmt.Sprintf("Kubernetes deployment failed: %v", err),
+		}, err
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Kubernetes deployment complete: %s", deployResult.DeploymentID))
+
+	return &sdk.Result{
+		Success:  true,
+		ExitCode: 0,
+		Output:   fmt.Sprintf("Rolled out %s", deployResult.DeploymentID),
+		Metadata: deployResult.Metadata,
+	}, nil
+}
+
+// Deploy applies manifests and/or patches the image of a deployment, then waits for the rollout
+func (p *KubernetesDeployPlugin) Deploy(ctx context.Context, req *sdk.DeploymentRequest) (*sdk.DeploymentResult, error) {
+	if err := p.prepareCredentials(req.Secrets); err != nil {
+		return nil, err
+	}
+
+	workloads := []workload{}
+
+	// Apply manifests and track every rollout-capable resource they create
+	for _, manifest := range p.manifests {
+		path := manifest
+		if !filepath.IsAbs(path) && p.workDir != "" {
+			path = filepath.Join(p.workDir, path)
+		}
+
+		output, err := p.kubectl(ctx, "apply", "-n", p.namespace, "-f", path, "-o", "json")
+		if err != nil {
+			return nil, fmt.Errorf("kubectl apply %s failed: %w", manifest, err)
+		}
+
+		applied, err := parseAppliedWorkloads(p.namespace, output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse kubectl apply output of %s: %w", manifest, err)
+		}
+		workloads = append(workloads, applied...)
+	}
+
+	// Patch the image tag of the configured deployment
+	image := p.image
+	if req.ArtifactURL != "" {
+		image = req.ArtifactURL
+	}
+
+	if p.deployment != "" {
+		if image != "" {
+			container := p.container
+			if container == "" {
+				container = "*"
+			}
+			if _, err := p.kubectl(ctx, "set", "image", "-n", p.namespace, "deployment/"+p.deployment, container+"="+image); err != nil {
+				return nil, fmt.Errorf("failed to update image: %w", err)
+			}
+		}
+
+		if !containsWorkload(workloads, p.namespace, "deployment", p.deployment) {
+			workloads = append(workloads, workload{Namespace: p.namespace, Kind: "deployment", Name: p.deployment})
+		}
+	}
+
+	for i := range workloads {
+		if err := p.waitForRollout(ctx, workloads[i]); err != nil {
+			return nil, err
+		}
+
+		if workloads[i].Kind == "deployment" {
+			revision, err := p.currentRevision(ctx, workloads[i])
+			if err != nil {
+				return nil, err
+			}
+			workloads[i].Revision = revision
+		}
+	}
+
+	ids := make([]string, len(workloads))
+	for i, w := range workloads {
+		ids[i] = w.String()
+	}
+
+	return &sdk.DeploymentResult{
+		DeploymentID: strings.Join(ids, ","),
+		Status:       "success",
+		Metadata: map[string]interface{}{
+			"namespace":   p.namespace,
+			"image":       image,
+			"workloads":   ids,
+			"environment": req.Environment,
+		},
+	}, nil
+}
+
+// Rollback reverts every workload of a deployment to the revision preceding it
+func (p *KubernetesDeployPlugin) Rollback(ctx context.Context, deploymentID string) error {
+	workloads, err := parseDeploymentID(deploymentID)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range workloads {
+		args := []string{"rollout", "undo", "-n", w.Namespace, w.resource()}
+
+		if w.Kind == "deployment" && w.Revision > 0 {
+			previous, err := p.previousRevision(ctx, w)
+			if err != nil {
+				return err
+			}
+			if previous == 0 {
+				return fmt.Errorf("no revision before %d found for %s", w.Revision, w.resource())
+			}
+			args = append(args, fmt.Sprintf("--to-revision=%d", previous))
+		}
+
+		if _, err := p.kubectl(ctx, args...); err != nil {
+			return fmt.Errorf("rollback of %s failed: %w", w.resource(), err)
+		}
+
+		if err := p.waitForRollout(ctx, w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetStatus reports whether all workloads of a deployment have finished rolling out
+func (p *KubernetesDeployPlugin) GetStatus(ctx context.Context, deploymentID string) (*sdk.DeploymentStatus, error) {
+	workloads, err := parseDeploymentID(deploymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := "success"
+	messages := []string{}
+
+	for _, w := range workloads {
+		output, err := p.kubectl(ctx, "rollout", "status", "-n", w.Namespace, w.resource(), "--watch=false")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rollout status of %s: %w", w.resource(), err)
+		}
+
+		output = strings.TrimSpace(output)
+		if !strings.Contains(output, rolloutSuccessMarker) {
+			status = "in_progress"
+		}
+		messages = append(messages, output)
+	}
+
+	return &sdk.DeploymentStatus{
+		DeploymentID: deploymentID,
+		Status:       status,
+		Message:      strings.Join(messages, "\n"),
+		UpdatedAt:    time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+func (p *KubernetesDeployPlugin) waitForRollout(ctx context.Context, w workload) error {
+	_, err := p.kubectl(ctx, "rollout", "status", "-n", w.Namespace, w.resource(),
+		"--timeout="+p.timeout.String())
+	if err != nil {
+		return fmt.Errorf("rollout of %s did not complete: %w", w.resource(), err)
+	}
+	return nil
+}
+
+func (p *KubernetesDeployPlugin) currentRevision(ctx context.Context, w workload) (int, error) {
+	output, err := p.kubectl(ctx, "get", "-n", w.Namespace, w.resource(),
+		"-o", `jsonpath={.metadata.annotations.deployment\.kubernetes\.io/revision}`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read revision of %s: %w", w.resource(), err)
+	}
+	return strconv.Atoi(strings.TrimSpace(output))
+}
+
+// previousRevision finds the highest ReplicaSet revision owned by the deployment below w.Revision
+func (p *KubernetesDeployPlugin) previousRevision(ctx context.Context, w workload) (int, error) {
+	output, err := p.kubectl(ctx, "get", "replicasets", "-n", w.Namespace, "-o", "json")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list replicasets: %w", err)
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Annotations     map[string]string `json:"annotations"`
+				OwnerReferences []struct {
+					Kind string `json:"kind"`
+					Name string `json:"name"`
+				} `json:"ownerReferences"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(output), &list); err != nil {
+		return 0, fmt.Errorf("failed to parse replicasets: %w", err)
+	}
+
+	revisions := []int{}
+	for _, rs := range list.Items {
+		owned := false
+		for _, ref := range rs.Metadata.OwnerReferences {
+			if ref.Kind == "Deployment" && ref.Name == w.Name {
+				owned = true
+				break
+			}
+		}
+		if !owned {
+			continue
+		}
+		if rev, err := strconv.Atoi(rs.Metadata.Annotations[revisionAnnotation]); err == nil && rev < w.Revision {
+			revisions = append(revisions, rev)
+		}
+	}
+
+	if len(revisions) == 0 {
+		return 0, nil
+	}
+	sort.Ints(revisions)
+	return revisions[len(revisions)-1], nil
+}
+
+// prepareCredentials writes a kubeconfig provided through secrets to a temporary file
+func (p *KubernetesDeployPlugin) prepareCredentials(secrets map[string]string) error {
+	if p.kubeconfigSecret == "" {
+		return nil
+	}
+
+	data, ok := secrets[p.kubeconfigSecret]
+	if !ok || data == "" {
+		return fmt.Errorf("kubeconfig secret %q not found", p.kubeconfigSecret)
+	}
+	return p.writeKubeconfig([]byte(data))
+}
+
+// prepareInCluster writes a kubeconfig for the pod's service account to a
+// temporary file. It names the mounted token file rather than holding the
+// token, which never appears on kubectl's command line.
+func (p *KubernetesDeployPlugin) prepareInCluster() error {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("in_cluster is set but KUBERNETES_SERVICE_HOST/PORT are not defined")
+	}
+
+	kubeconfig := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Config",
+		"clusters": []map[string]interface{}{{
+			"name": "in-cluster",
+			"cluster": map[string]string{
+				"server":                "https://" + net.JoinHostPort(host, port),
+				"certificate-authority": filepath.Join(serviceAccountDir, "ca.crt"),
+			},
+		}},
+		"users": []map[string]interface{}{{
+			"name": "in-cluster",
+			"user": map[string]string{"tokenFile": filepath.Join(serviceAccountDir, "token")},
+		}},
+		"contexts": []map[string]interface{}{{
+			"name":    "in-cluster",
+			"context": map[string]string{"cluster": "in-cluster", "user": "in-cluster"},
+		}},
+		"current-context": "in-cluster",
+	}
+	// kubectl reads JSON kubeconfigs as well as YAML ones
+	data, err := json.Marshal(kubeconfig)
+	if err != nil {
+		return err
+	}
+	return p.writeKubeconfig(data)
+}
+
+// writeKubeconfig writes data to a temporary kubeconfig, readable only by the
+// plugin, that kubectl runs with until Cleanup
+func (p *KubernetesDeployPlugin) writeKubeconfig(data []byte) error {
+	f, err := os.CreateTemp("", "kubeconfig-*")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	p.tempKubeconfig = f.Name()
+	return nil
+}
+
+// kubectl runs kubectl with the configured cluster connection flags
+func (p *KubernetesDeployPlugin) kubectl(ctx context.Context, args ...string) (string, error) {
+	if p.inCluster && p.tempKubeconfig == "" {
+		if err := p.prepareInCluster(); err != nil {
+			return "", err
+		}
+	}
+
+	base := []string{}
+
+	switch {
+	case p.tempKubeconfig != "":
+		base = append(base, "--kubeconfig", p.tempKubeconfig)
+	case p.kubeconfig != "":
+		base = append(base, "--kubeconfig", p.kubeconfig)
+	}
+
+	if p.kubeContext != "" {
+		base = append(base, "--context", p.kubeContext)
+	}
+
+	// Only stdout is returned, so that warnings kubectl writes to stderr do
+	// not end up in the JSON it prints
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "kubectl", append(base, args...)...)
+	cmd.Dir = p.workDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+func (p *KubernetesDeployPlugin) Cleanup() error {
+	if p.tempKubeconfig != "" {
+		os.Remove(p.tempKubeconfig)
+		p.tempKubeconfig = ""
+	}
+	return nil
+}
+
+// parseAppliedWorkloads extracts the rollout-capable resources from kubectl
+// apply "-o json" output: the object applied, or a List of the objects when a
+// manifest holds several. Each keeps the namespace it was applied in, which a
+// manifest's metadata.namespace may set, and namespace otherwise.
+func parseAppliedWorkloads(namespace, output string) ([]workload, error) {
+	type object struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Items []json.RawMessage `json:"items"`
+	}
+
+	var applied object
+	if err := json.Unmarshal([]byte(output), &applied); err != nil {
+		return nil, err
+	}
+	objects := []object{applied}
+	if applied.Kind == "List" {
+		objects = make([]object, len(applied.Items))
+		for i, item := range applied.Items {
+			if err := json.Unmarshal(item, &objects[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	workloads := []workload{}
+	for _, obj := range objects {
+		kind := strings.ToLower(obj.Kind)
+		switch kind {
+		case "deployment", "statefulset", "daemonset":
+		default:
+			continue
+		}
+		ns := obj.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		workloads = append(workloads, workload{Namespace: ns, Kind: kind, Name: obj.Metadata.Name})
+	}
+	return workloads, nil
+}
+
+// parseDeploymentID decodes a comma-separated list of namespace/kind/name@revision references
+func parseDeploymentID(deploymentID string) ([]workload, error) {
+	workloads := []workload{}
+
+	for _, ref := range strings.Split(deploymentID, ",") {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+
+		path, rev, _ := strings.Cut(ref, "@")
+		parts := strings.Split(path, "/")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid deployment reference %q", ref)
+		}
+
+		w := workload{Namespace: parts[0], Kind: parts[1], Name: parts[2]}
+		if rev != "" {
+			revision, err := strconv.Atoi(rev)
+			if err != nil {
+				return nil, fmt.Errorf("invalid revision in %q", ref)
+			}
+			w.Revision = revision
+		}
+		workloads = append(workloads, w)
+	}
+
+	if len(workloads) == 0 {
+		return nil, fmt.Errorf("empty deployment ID")
+	}
+	return workloads, nil
+}
+
+func containsWorkload(workloads []workload, namespace, kind, name string) bool {
+	for _, w := range workloads {
+		if w.Namespace == namespace && w.Kind == kind && w.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Export the plugin
+var Plugin KubernetesDeployPlugin
+
+func main() {
+	sdk.Serve(&Plugin)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// licensePattern identifies a license by phrases that all appear in its text
+// and, optionally, phrases that must not
+type licensePattern struct {
+	id      string
+	all     []string
+	without []string
+}
+
+// licensePatterns are checked in order, so licenses that quote or extend
+// another's text, such as the LGPL and AGPL, come before it
+var licensePatterns = []licensePattern{
+	{id: "AGPL-3.0", all: []string{"gnu affero general public license", "version 3"}},
+	{id: "LGPL-3.0", all: []string{"gnu lesser general public license", "version 3"}},
+	{id: "LGPL-2.1", all: []string{"gnu lesser general public license", "version 2.1"}},
+	{id: "LGPL-2.0", all: []string{"gnu library general public license", "version 2"}},
+	{id: "GPL-3.0", all: []string{"gnu general public license", "version 3"}},
+	{id: "GPL-2.0", all: []string{"gnu general public license", "version 2"}},
+	{id: "MPL-2.0", all: []string{"mozilla public license", "version 2.0"}},
+	{id: "EPL-2.0", all: []string{"eclipse public license", "v 2.0"}},
+	{id: "EPL-1.0", all: []string{"eclipse public license", "v 1.0"}},
+	{id: "Apache-2.0", all: []string{"apache license", "version 2.0"}},
+	{id: "BSL-1.0", all: []string{"boost software license", "version 1.0"}},
+	{id: "CC0-1.0", all: []string{"cc0 1.0 universal"}},
+	{id: "Unlicense", all: []string{"this is free and unencumbered software released into the public domain"}},
+	{id: "Zlib", all: []string{"altered source versions must be plainly marked as such"}},
+	{id: "ISC", all: []string{"permission to use copy modify and or distribute this software for any purpose with or without fee is hereby granted"}},
+	{id: "MIT", all: []string{"permission is hereby granted free of charge to any person obtaining a copy"}},
+	{id: "BSD-3-Clause", all: []string{"redistribution and use in source and binary forms", "endorse or promote products"}},
+	{id: "BSD-2-Clause", all: []string{"redistribution and use in source and binary forms"}, without: []string{"endorse or promote products"}},
+}
+
+// classifyLicense returns the SPDX ID of the license a license file's text
+// matches, or "" if it matches none
+func classifyLicense(text string) string {
+	normalized := normalizeLicenseText(text)
+	for _, pattern := range licensePatterns {
+		if containsAll(normalized, pattern.all) && !containsAny(normalized, pattern.without) {
+			return pattern.id
+		}
+	}
+	return ""
+}
+
+// normalizeLicenseText lower-cases text and reduces punctuation and line
+// breaks to single spaces, so wrapping and quoting do not affect matching.
+// Dots stay, as they separate version numbers.
+func normalizeLicenseText(text string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	// A dot ending a sentence is not part of a version number
+	return strings.ReplaceAll(b.String()+" ", ". ", " ")
+}
+
+func containsAll(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if !strings.Contains(text, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
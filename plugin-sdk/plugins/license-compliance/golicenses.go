@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// licenseFileNames are the prefixes of files that hold a module's license
+var licenseFileNames = []string{"license", "licence", "copying", "unlicense"}
+
+// goLicense is the license detected for a module version
+type goLicense struct {
+	License string   `json:"license"`
+	Files   []string `json:"files,omitempty"` // license files, relative to the module
+}
+
+// licenseCache stores the licenses detected for module versions, which never
+// change, so later builds on the worker skip reading and matching license
+// files
+type licenseCache struct {
+	path    string
+	entries map[string]goLicense // module@version -> license
+	dirty   bool
+}
+
+// loadLicenseCache reads the cache at path. A missing or corrupt cache is
+// treated as empty, and an empty path disables caching.
+func loadLicenseCache(path string) *licenseCache {
+	c := &licenseCache{path: path, entries: make(map[string]goLicense)}
+	if path == "" {
+		return c
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+	return c
+}
+
+func (c *licenseCache) get(module, version string) (goLicense, bool) {
+	l, ok := c.entries[module+"@"+version]
+	return l, ok
+}
+
+func (c *licenseCache) put(module, version string, l goLicense) {
+	c.entries[module+"@"+version] = l
+	c.dirty = true
+}
+
+// save writes the cache back if anything was added
+func (c *licenseCache) save() error {
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	// Write and rename, so concurrent builds never read a partial cache
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// defaultLicenseCache returns the cache location under the user's cache
+// directory, or "" if there is none
+func defaultLicenseCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "solvyd", "license-compliance", "go-licenses.json")
+}
+
+// detectModuleLicense classifies the license files in the root of a module's
+// directory. A module with several licenses, such as LICENSE-MIT and
+// LICENSE-APACHE, gets an AND expression of all of them. It returns UNKNOWN
+// if no license file is recognized.
+func detectModuleLicense(dir string) goLicense {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return goLicense{License: "UNKNOWN"}
+	}
+
+	ids := []string{}
+	files := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isLicenseFile(entry.Name()) {
+			continue
+		}
+		text, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		id := classifyLicense(string(text))
+		if id == "" {
+			continue
+		}
+		files = append(files, entry.Name())
+		if !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return goLicense{License: "UNKNOWN"}
+	}
+	sort.Strings(ids)
+	return goLicense{License: strings.Join(ids, " AND "), Files: files}
+}
+
+func isLicenseFile(name string) bool {
+	lower := strings.ToLower(name)
+	for _, prefix := range licenseFileNames {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
 )
@@ -19,6 +24,11 @@ type LicenseCompliancePlugin struct {
 	failOnDenied    bool
 	failOnUnknown   bool
 	generateSBOM    bool
+	sbomFormats     []string
+	licenseCache    string // file caching detected Go module licenses, or "" for none
+	policyFile      string // relative to the workspace
+	policyRequired  bool   // policy_file was set, so it must exist
+	exceptions      []policyException
 }
 
 type License struct {
@@ -28,6 +38,11 @@ type License struct {
 	License    string `json:"license"`
 	Repository string `json:"repository"`
 	Approved   bool   `json:"approved"`
+
+	Exception     string `json:"exception,omitempty"`      // reason of the policy exception approving it
+	Ecosystem     string `json:"ecosystem"`                // npm, golang or maven
+	LicenseSource string `json:"license_source,omitempty"` // where the license was found
+	Hashes        []Hash `json:"hashes,omitempty"`
 }
 
 func (p *LicenseCompliancePlugin) Name() string {
@@ -42,38 +57,72 @@ func (p *LicenseCompliancePlugin) Type() string {
 	return "compliance"
 }
 
+func (p *LicenseCompliancePlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace}
+}
+
 func (p *LicenseCompliancePlugin) Initialize(config map[string]interface{}) error {
-	p.scanPath = getStringConfig(config, "scan_path", ".")
-	p.failOnDenied = getBoolConfig(config, "fail_on_denied", true)
-	p.failOnUnknown = getBoolConfig(config, "fail_on_unknown", false)
-	p.generateSBOM = getBoolConfig(config, "generate_sbom", true)
-
-	// Parse allowed licenses
-	if allowed, ok := config["allowed_licenses"].([]interface{}); ok {
-		p.allowedLicenses = make([]string, len(allowed))
-		for i, l := range allowed {
-			p.allowedLicenses[i] = l.(string)
+	cfg := sdk.Config(config)
+	p.scanPath = cfg.String("scan_path", ".")
+	p.failOnDenied = cfg.Bool("fail_on_denied", true)
+	p.failOnUnknown = cfg.Bool("fail_on_unknown", false)
+	p.generateSBOM = cfg.Bool("generate_sbom", true)
+	p.licenseCache = cfg.String("license_cache", defaultLicenseCache())
+	p.policyFile = cfg.String("policy_file", defaultPolicyFile)
+	p.policyRequired = cfg.Has("policy_file")
+
+	p.sbomFormats = cfg.StringSlice("sbom_formats")
+	if !cfg.Has("sbom_formats") {
+		p.sbomFormats = []string{formatCycloneDX}
+	}
+	for i, format := range p.sbomFormats {
+		p.sbomFormats[i] = strings.ToLower(format)
+		if _, ok := sbomFiles[p.sbomFormats[i]]; !ok {
+			return fmt.Errorf("invalid sbom_formats entry %q (expected cyclonedx or spdx)", format)
 		}
-	} else {
+	}
+
+	p.allowedLicenses = cfg.StringSlice("allowed_licenses")
+	if !cfg.Has("allowed_licenses") {
 		p.allowedLicenses = []string{"MIT", "Apache-2.0", "BSD-3-Clause", "BSD-2-Clause", "ISC"}
 	}
 
-	// Parse denied licenses
-	if denied, ok := config["denied_licenses"].([]interface{}); ok {
-		p.deniedLicenses = make([]string, len(denied))
-		for i, l := range denied {
-			p.deniedLicenses[i] = l.(string)
-		}
-	} else {
+	p.deniedLicenses = cfg.StringSlice("denied_licenses")
+	if !cfg.Has("denied_licenses") {
 		p.deniedLicenses = []string{"GPL-2.0", "GPL-3.0", "AGPL-3.0"}
 	}
 
 	return nil
 }
 
+func (p *LicenseCompliancePlugin) Health() error {
+	return nil
+}
+
 func (p *LicenseCompliancePlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
 	ctx.Logger.Info("Starting license compliance scan")
 
+	policy, policyErr := loadPolicyFile(filepath.Join(ctx.WorkDir, p.policyFile), p.policyRequired)
+	if policyErr != nil {
+		return failure(fmt.Sprintf("Failed to load license policy %s: %v", p.policyFile, policyErr)), policyErr
+	}
+	p.exceptions = nil
+	if policy != nil {
+		ctx.Logger.Info(fmt.Sprintf("Using license policy %s", p.policyFile))
+		if policy.Allowed != nil {
+			p.allowedLicenses = policy.Allowed
+		}
+		if policy.Denied != nil {
+			p.deniedLicenses = policy.Denied
+		}
+		p.exceptions = policy.Exceptions
+	}
+	// Exceptions approved on the server apply on top of the repository's
+	if approved := serverExceptions(sdk.Config(ctx.Parameters)); len(approved) > 0 {
+		ctx.Logger.Info(fmt.Sprintf("Using %d license exceptions approved on the server", len(approved)))
+		p.exceptions = append(p.exceptions, approved...)
+	}
+
 	licenses := make([]License, 0)
 
 	// Scan different package managers
@@ -105,36 +154,33 @@ func (p *LicenseCompliancePlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resul
 	deniedCount := 0
 	unknownCount := 0
 	approvedCount := 0
+	exceptedCount := 0
 
+	now := time.Now()
 	for i := range licenses {
 		license := &licenses[i]
 
-		// Check if denied
-		if p.isDenied(license.License) {
-			license.Approved = false
-			deniedCount++
-			continue
+		verdict := p.checkLicense(license.License)
+		if verdict != verdictAllowed {
+			if e, ok := p.exception(*license, now, ctx.Logger); ok {
+				license.Approved = true
+				license.Exception = e.Reason
+				approvedCount++
+				exceptedCount++
+				continue
+			}
 		}
 
-		// Check if allowed
-		if p.isAllowed(license.License) {
+		switch verdict {
+		case verdictDenied:
+			license.Approved = false
+			deniedCount++
+		case verdictAllowed:
 			license.Approved = true
 			approvedCount++
-			continue
-		}
-
-		// Unknown license
-		unknownCount++
-		license.Approved = false
-	}
-
-	// Generate SBOM if requested
-	if p.generateSBOM {
-		sbomPath := filepath.Join(ctx.WorkDir, "sbom.json")
-		if err := p.generateSBOMFile(licenses, sbomPath); err != nil {
-			ctx.Logger.Error(fmt.Sprintf("Failed to generate SBOM: %v", err))
-		} else {
-			ctx.Logger.Info(fmt.Sprintf("SBOM generated: %s", sbomPath))
+		default:
+			unknownCount++
+			license.Approved = false
 		}
 	}
 
@@ -158,6 +204,32 @@ func (p *LicenseCompliancePlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resul
 	result.Metadata["approved_count"] = approvedCount
 	result.Metadata["denied_count"] = deniedCount
 	result.Metadata["unknown_count"] = unknownCount
+	result.Metadata["excepted_count"] = exceptedCount
+
+	// Generate SBOMs if requested
+	if p.generateSBOM {
+		doc := sbomDocument{
+			Name:      p.projectName(ctx),
+			Licenses:  licenses,
+			Created:   time.Now(),
+			ToolName:  "solvyd-license-compliance",
+			ToolVer:   p.Version(),
+			Namespace: fmt.Sprintf("https://solvyd.dev/spdx/%s-%s", ctx.BuildID, newUUID()),
+		}
+		files := []string{}
+		for _, format := range p.sbomFormats {
+			sbomPath := filepath.Join(ctx.WorkDir, sbomFiles[format])
+			artifact, err := p.generateSBOMFile(doc, format, sbomPath)
+			if err != nil {
+				ctx.Logger.Error(fmt.Sprintf("Failed to generate %s SBOM: %v", format, err))
+				continue
+			}
+			ctx.Logger.Info(fmt.Sprintf("SBOM generated: %s", sbomPath))
+			result.Artifacts = append(result.Artifacts, artifact)
+			files = append(files, sbomFiles[format])
+		}
+		result.Metadata["sbom_files"] = files
+	}
 
 	ctx.Logger.Info(result.Output)
 
@@ -170,7 +242,7 @@ func (p *LicenseCompliancePlugin) scanNPM(ctx *sdk.ExecutionContext) ([]License,
 		return nil, fmt.Errorf("no package.json found")
 	}
 
-	cmd := exec.Command("npm", "list", "--json", "--all")
+	cmd := exec.CommandContext(ctx, "npm", "list", "--json", "--all")
 	cmd.Dir = filepath.Join(ctx.WorkDir, p.scanPath)
 	output, err := cmd.Output()
 	if err != nil {
@@ -191,15 +263,27 @@ func (p *LicenseCompliancePlugin) scanNPM(ctx *sdk.ExecutionContext) ([]License,
 		return nil, err
 	}
 
+	integrity := readNPMIntegrity(filepath.Join(ctx.WorkDir, p.scanPath, "package-lock.json"))
+
 	licenses := make([]License, 0)
 	for name, dep := range npmList.Dependencies {
-		licenses = append(licenses, License{
-			Name:    name,
-			Package: name,
-			Version: dep.Version,
-			License: dep.License,
-		})
+		l := License{
+			Name:      name,
+			Package:   name,
+			Version:   dep.Version,
+			License:   dep.License,
+			Ecosystem: "npm",
+		}
+		if dep.License != "" {
+			l.LicenseSource = "node_modules/" + name + "/package.json"
+		}
+		if h, ok := integrityHash(integrity[name]); ok {
+			l.Hashes = []Hash{h}
+		}
+		licenses = append(licenses, l)
 	}
+	// Keep SBOMs stable from build to build
+	sort.Slice(licenses, func(i, j int) bool { return licenses[i].Package < licenses[j].Package })
 
 	return licenses, nil
 }
@@ -211,7 +295,7 @@ func (p *LicenseCompliancePlugin) scanMaven(ctx *sdk.ExecutionContext) ([]Licens
 	}
 
 	// Use maven license plugin
-	cmd := exec.Command("mvn", "license:aggregate-third-party-report", "-DoutputDirectory=target")
+	cmd := exec.CommandContext(ctx, "mvn", "license:aggregate-third-party-report", "-DoutputDirectory=target")
 	cmd.Dir = filepath.Join(ctx.WorkDir, p.scanPath)
 	if err := cmd.Run(); err != nil {
 		return nil, err
@@ -227,89 +311,241 @@ func (p *LicenseCompliancePlugin) scanGo(ctx *sdk.ExecutionContext) ([]License,
 		return nil, fmt.Errorf("no go.mod found")
 	}
 
-	cmd := exec.Command("go", "list", "-m", "-json", "all")
+	cmd := exec.CommandContext(ctx, "go", "list", "-m", "-e", "-json", "all")
 	cmd.Dir = filepath.Join(ctx.WorkDir, p.scanPath)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, err
 	}
 
-	lines := strings.Split(string(output), "\n")
-	licenses := make([]License, 0)
+	modCache := ""
+	if out, err := exec.CommandContext(ctx, "go", "env", "GOMODCACHE").Output(); err == nil {
+		modCache = strings.TrimSpace(string(out))
+	}
 
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" {
-			continue
+	// go list -m -json prints a stream of indented objects, not one per line
+	type module struct {
+		Path    string `json:"Path"`
+		Version string `json:"Version"`
+		Main    bool   `json:"Main"`
+		Dir     string `json:"Dir"` // empty if not in the module cache
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(output)))
+	modules := []module{}
+	for decoder.More() {
+		var mod module
+		if err := decoder.Decode(&mod); err != nil {
+			return nil, err
+		}
+		if !mod.Main {
+			modules = append(modules, mod)
 		}
+	}
 
-		var mod struct {
-			Path    string `json:"Path"`
-			Version string `json:"Version"`
+	cache := loadLicenseCache(p.licenseCache)
+
+	// Download the modules whose licenses are not cached and that the build
+	// has not fetched, such as test dependencies of dependencies
+	missing := []string{}
+	for _, mod := range modules {
+		if _, ok := cache.get(mod.Path, mod.Version); !ok && mod.Dir == "" && mod.Version != "" {
+			missing = append(missing, mod.Path+"@"+mod.Version)
+		}
+	}
+	dirs := map[string]string{}
+	if len(missing) > 0 {
+		ctx.Logger.Info(fmt.Sprintf("Downloading %d Go modules to read their licenses", len(missing)))
+		cmd := exec.CommandContext(ctx, "go", append([]string{"mod", "download", "-json"}, missing...)...)
+		cmd.Dir = filepath.Join(ctx.WorkDir, p.scanPath)
+		// Modules that fail to download are reported in the output and stay UNKNOWN
+		out, _ := cmd.Output()
+		downloads := json.NewDecoder(strings.NewReader(string(out)))
+		for downloads.More() {
+			var mod module
+			if err := downloads.Decode(&mod); err != nil {
+				break
+			}
+			dirs[mod.Path+"@"+mod.Version] = mod.Dir
 		}
+	}
 
-		if err := json.Unmarshal([]byte(line), &mod); err == nil {
-			licenses = append(licenses, License{
-				Name:    mod.Path,
-				Package: mod.Path,
-				Version: mod.Version,
-				License: "UNKNOWN", // Would need additional lookup
-			})
+	licenses := make([]License, 0, len(modules))
+	cached := 0
+	for _, mod := range modules {
+		detected, ok := cache.get(mod.Path, mod.Version)
+		if ok {
+			cached++
+		} else {
+			dir := mod.Dir
+			if dir == "" {
+				dir = dirs[mod.Path+"@"+mod.Version]
+			}
+			if dir != "" {
+				detected = detectModuleLicense(dir)
+				// Local replacements have no version and may change
+				if mod.Version != "" {
+					cache.put(mod.Path, mod.Version, detected)
+				}
+			} else {
+				detected = goLicense{License: "UNKNOWN"}
+			}
+		}
+
+		l := License{
+			Name:      mod.Path,
+			Package:   mod.Path,
+			Version:   mod.Version,
+			License:   detected.License,
+			Ecosystem: "golang",
+		}
+		for i, file := range detected.Files {
+			if i > 0 {
+				l.LicenseSource += ", "
+			}
+			l.LicenseSource += mod.Path + "@" + mod.Version + "/" + file
 		}
+		if modCache != "" {
+			if h, ok := moduleZipHash(modCache, mod.Path, mod.Version); ok {
+				l.Hashes = []Hash{h}
+			}
+		}
+		licenses = append(licenses, l)
+	}
+
+	if err := cache.save(); err != nil {
+		ctx.Logger.Warn(fmt.Sprintf("Failed to save license cache: %v", err))
 	}
+	ctx.Logger.Info(fmt.Sprintf("Resolved licenses of %d Go modules (%d from cache)", len(licenses), cached))
 
 	return licenses, nil
 }
 
-func (p *LicenseCompliancePlugin) isAllowed(license string) bool {
-	for _, allowed := range p.allowedLicenses {
-		if strings.EqualFold(license, allowed) {
-			return true
+// readNPMIntegrity returns the integrity of each top-level dependency in a
+// package-lock.json, or nil if there is none
+func readNPMIntegrity(path string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	type entry struct {
+		Integrity string `json:"integrity"`
+	}
+	var lock struct {
+		Packages     map[string]entry `json:"packages"`     // lockfileVersion 2 and 3
+		Dependencies map[string]entry `json:"dependencies"` // lockfileVersion 1
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil
+	}
+
+	integrity := make(map[string]string)
+	for name, dep := range lock.Dependencies {
+		integrity[name] = dep.Integrity
+	}
+	for path, dep := range lock.Packages {
+		if name, ok := strings.CutPrefix(path, "node_modules/"); ok && !strings.Contains(name, "/node_modules/") {
+			integrity[name] = dep.Integrity
+		}
+	}
+	return integrity
+}
+
+// moduleZipHash returns the SHA-256 of a Go module's zip in the module cache
+func moduleZipHash(modCache, path, version string) (Hash, bool) {
+	zip := filepath.Join(modCache, "cache", "download", escapeModulePath(path), "@v", escapeModulePath(version)+".zip")
+	f, err := os.Open(zip)
+	if err != nil {
+		return Hash{}, false
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Hash{}, false
+	}
+	return Hash{Algorithm: "SHA-256", Value: hex.EncodeToString(h.Sum(nil))}, true
+}
+
+// escapeModulePath applies the module cache's case encoding, in which each
+// upper-case letter becomes "!" followed by the lower-case letter
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('!')
+			r += 'a' - 'A'
 		}
+		b.WriteRune(r)
 	}
-	return false
+	return b.String()
 }
 
-func (p *LicenseCompliancePlugin) isDenied(license string) bool {
-	for _, denied := range p.deniedLicenses {
-		if strings.EqualFold(license, denied) {
-			return true
+// exception returns the policy exception that approves a dependency. Expired
+// exceptions are skipped with a warning, so the build shows why a package that
+// used to pass now fails.
+func (p *LicenseCompliancePlugin) exception(l License, now time.Time, logger sdk.Logger) (policyException, bool) {
+	for _, e := range p.exceptions {
+		if !e.matches(l) {
+			continue
+		}
+		if e.expired(now) {
+			logger.Warn(fmt.Sprintf("License exception for %s expired on %s", l.Package, e.Expires))
+			continue
 		}
+		return e, true
 	}
-	return false
+	return policyException{}, false
 }
 
-func (p *LicenseCompliancePlugin) generateSBOMFile(licenses []License, path string) error {
-	data, err := json.MarshalIndent(licenses, "", "  ")
+// generateSBOMFile writes an SBOM in the given format and returns it as an
+// artifact
+func (p *LicenseCompliancePlugin) generateSBOMFile(doc sbomDocument, format, path string) (sdk.Artifact, error) {
+	write := writeCycloneDX
+	if format == formatSPDX {
+		write = writeSPDX
+	}
+	if err := write(doc, path); err != nil {
+		return sdk.Artifact{}, err
+	}
+
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return sdk.Artifact{}, err
 	}
-	return os.WriteFile(path, data, 0644)
+	sum := sha256.Sum256(data)
+	return sdk.Artifact{
+		Name:           filepath.Base(path),
+		Path:           path,
+		SizeBytes:      int64(len(data)),
+		ChecksumSHA256: hex.EncodeToString(sum[:]),
+		Metadata:       map[string]string{"kind": "sbom", "format": format + "-json"},
+	}, nil
 }
 
-func (p *LicenseCompliancePlugin) Cleanup() error {
-	return nil
+// projectName names the scanned project in SBOMs after its directory
+func (p *LicenseCompliancePlugin) projectName(ctx *sdk.ExecutionContext) string {
+	dir, err := filepath.Abs(filepath.Join(ctx.WorkDir, p.scanPath))
+	if err != nil {
+		return p.scanPath
+	}
+	return filepath.Base(dir)
 }
 
-// Helper functions
-func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
-	if val, ok := config[key].(string); ok {
-		return val
+func failure(msg string) *sdk.Result {
+	return &sdk.Result{
+		Success:      false,
+		ExitCode:     1,
+		ErrorMessage: msg,
 	}
-	return defaultValue
 }
 
-func getBoolConfig(config map[string]interface{}, key string, defaultValue bool) bool {
-	if val, ok := config[key].(bool); ok {
-		return val
-	}
-	return defaultValue
+func (p *LicenseCompliancePlugin) Cleanup() error {
+	return nil
 }
 
 // Export the plugin
 var Plugin LicenseCompliancePlugin
 
 func main() {
-	fmt.Println("License Compliance Plugin v1.0.0")
-	fmt.Println("This plugin scans and validates software licenses")
-	fmt.Println("To build: go build -o license-compliance")
+	sdk.Serve(&Plugin)
 }
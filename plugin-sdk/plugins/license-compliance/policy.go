@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// defaultPolicyFile is loaded from the workspace when policy_file is not set
+const defaultPolicyFile = ".solvyd/license-policy.yml"
+
+// policyFile is a license policy kept in the repository, so changes to it are
+// reviewed like code. Its allowed and denied lists replace the job's.
+type policyFile struct {
+	Allowed    []string          `yaml:"allowed"`
+	Denied     []string          `yaml:"denied"`
+	Exceptions []policyException `yaml:"exceptions"`
+}
+
+// policyException approves a package whatever its license, until it expires
+type policyException struct {
+	Package   string `yaml:"package"`   // name, or a path.Match pattern such as "@acme/*"
+	Version   string `yaml:"version"`   // optional; every version if empty
+	License   string `yaml:"license"`   // optional; any license if empty
+	Ecosystem string `yaml:"ecosystem"` // optional; npm, golang or maven
+	Reason    string `yaml:"reason"`
+	Expires   string `yaml:"expires"` // optional, YYYY-MM-DD
+
+	expires time.Time // end of the expiry day, or zero for never
+}
+
+// serverExceptions returns the license exceptions approved through the API
+// server, which the worker agent passes in the license_exceptions parameter
+func serverExceptions(params sdk.Config) []policyException {
+	exceptions := []policyException{}
+	for _, e := range params.Maps("license_exceptions") {
+		exception := policyException{
+			Package:   e.String("package", ""),
+			Version:   e.String("version", ""),
+			License:   e.String("license", ""),
+			Ecosystem: e.String("ecosystem", ""),
+			Reason:    e.String("justification", ""),
+		}
+		if exception.Package == "" || exception.Version == "" {
+			continue
+		}
+		if expires, err := time.Parse(time.RFC3339, e.String("expires_at", "")); err == nil {
+			exception.expires = expires
+			exception.Expires = expires.UTC().Format("2006-01-02")
+		}
+		exceptions = append(exceptions, exception)
+	}
+	return exceptions
+}
+
+// loadPolicyFile reads and validates a policy file. It returns nil, nil if the
+// file does not exist and is not required.
+func loadPolicyFile(file string, required bool) (*policyFile, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var policy policyFile
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy file: %w", err)
+	}
+
+	problems := []string{}
+	for i := range policy.Exceptions {
+		e := &policy.Exceptions[i]
+		if e.Package == "" {
+			problems = append(problems, fmt.Sprintf("exceptions[%d]: package is required", i))
+		} else if _, err := path.Match(e.Package, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("exceptions[%d]: invalid package pattern %q", i, e.Package))
+		}
+		if strings.TrimSpace(e.Reason) == "" {
+			problems = append(problems, fmt.Sprintf("exceptions[%d]: reason is required", i))
+		}
+		if e.Expires != "" {
+			day, err := time.Parse("2006-01-02", e.Expires)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("exceptions[%d]: expires must be a date such as 2025-12-31", i))
+				continue
+			}
+			e.expires = day.AddDate(0, 0, 1)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid policy file: %s", strings.Join(problems, "; "))
+	}
+	return &policy, nil
+}
+
+// matches reports whether an exception covers a dependency
+func (e policyException) matches(l License) bool {
+	if ok, _ := path.Match(e.Package, l.Package); !ok && e.Package != l.Package {
+		return false
+	}
+	if e.Version != "" && e.Version != l.Version {
+		return false
+	}
+	if e.Ecosystem != "" && e.Ecosystem != l.Ecosystem {
+		return false
+	}
+	return e.License == "" || strings.EqualFold(e.License, l.License)
+}
+
+// expired reports whether an exception no longer applies at now
+func (e policyException) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SBOM formats
+const (
+	formatCycloneDX = "cyclonedx"
+	formatSPDX      = "spdx"
+)
+
+// sbomFiles are the file names each format is written to
+var sbomFiles = map[string]string{
+	formatCycloneDX: "sbom.cdx.json",
+	formatSPDX:      "sbom.spdx.json",
+}
+
+var (
+	licenseIDPattern  = regexp.MustCompile(`^[A-Za-z0-9.+-]+$`)
+	licenseRefInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+)
+
+// Hash is a checksum of a dependency's distributed archive
+type Hash struct {
+	Algorithm string `json:"alg"`     // SHA-256, SHA-512 or SHA-1
+	Value     string `json:"content"` // hex
+}
+
+// sbomDocument describes the scanned project for an SBOM
+type sbomDocument struct {
+	Name      string // project name
+	Licenses  []License
+	Created   time.Time
+	ToolName  string
+	ToolVer   string
+	Namespace string // unique per document, for SPDX
+}
+
+// purl returns a dependency's package URL, or "" for unknown ecosystems
+func (l License) purl() string {
+	switch l.Ecosystem {
+	case "npm":
+		// Scoped packages keep the scope as the namespace, with @ encoded
+		return "pkg:npm/" + strings.Replace(l.Package, "@", "%40", 1) + "@" + url.PathEscape(l.Version)
+	case "golang":
+		return "pkg:golang/" + l.Package + "@" + url.PathEscape(l.Version)
+	case "maven":
+		group, artifact, ok := strings.Cut(l.Package, ":")
+		if ok {
+			return "pkg:maven/" + group + "/" + artifact + "@" + url.PathEscape(l.Version)
+		}
+	}
+	return ""
+}
+
+// knownLicense reports whether a dependency's license was detected
+func (l License) knownLicense() bool {
+	return l.License != "" && !strings.EqualFold(l.License, "UNKNOWN")
+}
+
+// isExpression reports whether a license is an SPDX expression rather than a
+// single identifier
+func isExpression(license string) bool {
+	for _, op := range []string{" OR ", " AND ", " WITH "} {
+		if strings.Contains(strings.ToUpper(license), op) {
+			return true
+		}
+	}
+	return false
+}
+
+// cycloneDXLicenses returns a dependency's license as a CycloneDX license
+// choice: an SPDX expression, an SPDX ID, or a free-form name
+func cycloneDXLicenses(l License) []map[string]interface{} {
+	if !l.knownLicense() {
+		return nil
+	}
+	if isExpression(l.License) {
+		return []map[string]interface{}{{"expression": l.License}}
+	}
+	if licenseIDPattern.MatchString(l.License) {
+		return []map[string]interface{}{{"license": map[string]interface{}{"id": l.License}}}
+	}
+	return []map[string]interface{}{{"license": map[string]interface{}{"name": l.License}}}
+}
+
+// writeCycloneDX writes a CycloneDX 1.5 JSON SBOM
+func writeCycloneDX(doc sbomDocument, path string) error {
+	components := make([]map[string]interface{}, 0, len(doc.Licenses))
+	for i, l := range doc.Licenses {
+		c := map[string]interface{}{
+			"type":       "library",
+			"bom-ref":    fmt.Sprintf("component-%d", i+1),
+			"name":       l.Package,
+			"version":    l.Version,
+			"properties": []map[string]string{{"name": "solvyd:license:approved", "value": fmt.Sprint(l.Approved)}},
+		}
+		if purl := l.purl(); purl != "" {
+			c["purl"] = purl
+			c["bom-ref"] = purl
+		}
+		if l.Repository != "" {
+			c["externalReferences"] = []map[string]string{{"type": "vcs", "url": l.Repository}}
+		}
+		if len(l.Hashes) > 0 {
+			hashes := make([]map[string]string, 0, len(l.Hashes))
+			for _, h := range l.Hashes {
+				hashes = append(hashes, map[string]string{"alg": h.Algorithm, "content": h.Value})
+			}
+			c["hashes"] = hashes
+		}
+		if licenses := cycloneDXLicenses(l); licenses != nil {
+			c["licenses"] = licenses
+			// Evidence records where the license was found, so reviewers
+			// can tell declared metadata from detected license texts
+			evidence := map[string]interface{}{"licenses": licenses}
+			if l.LicenseSource != "" {
+				evidence["occurrences"] = []map[string]string{{"location": l.LicenseSource}}
+			}
+			c["evidence"] = evidence
+		}
+		components = append(components, c)
+	}
+
+	bom := map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + newUUID(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": doc.Created.UTC().Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []map[string]string{{"type": "application", "name": doc.ToolName, "version": doc.ToolVer}},
+			},
+			"component": map[string]string{"type": "application", "name": doc.Name},
+		},
+		"components": components,
+	}
+	return writeJSON(path, bom)
+}
+
+// writeSPDX writes an SPDX 2.3 JSON document
+func writeSPDX(doc sbomDocument, path string) error {
+	extracted := map[string]string{} // LicenseRef ID -> license name
+	packages := []map[string]interface{}{{
+		"SPDXID":           "SPDXRef-Project",
+		"name":             doc.Name,
+		"downloadLocation": "NOASSERTION",
+		"filesAnalyzed":    false,
+		"licenseConcluded": "NOASSERTION",
+		"licenseDeclared":  "NOASSERTION",
+		"copyrightText":    "NOASSERTION",
+	}}
+	relationships := []map[string]string{{
+		"spdxElementId":      "SPDXRef-DOCUMENT",
+		"relationshipType":   "DESCRIBES",
+		"relatedSpdxElement": "SPDXRef-Project",
+	}}
+
+	for i, l := range doc.Licenses {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		license := spdxLicense(l, extracted)
+		pkg := map[string]interface{}{
+			"SPDXID":           id,
+			"name":             l.Package,
+			"versionInfo":      l.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseConcluded": license,
+			"licenseDeclared":  license,
+			"copyrightText":    "NOASSERTION",
+		}
+		if l.Repository != "" {
+			pkg["homepage"] = l.Repository
+		}
+		if l.LicenseSource != "" {
+			pkg["licenseComments"] = "License found in " + l.LicenseSource
+		}
+		if purl := l.purl(); purl != "" {
+			pkg["externalRefs"] = []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  purl,
+			}}
+		}
+		if len(l.Hashes) > 0 {
+			checksums := make([]map[string]string, 0, len(l.Hashes))
+			for _, h := range l.Hashes {
+				checksums = append(checksums, map[string]string{
+					"algorithm":     strings.ReplaceAll(h.Algorithm, "-", ""),
+					"checksumValue": h.Value,
+				})
+			}
+			pkg["checksums"] = checksums
+		}
+		packages = append(packages, pkg)
+		relationships = append(relationships, map[string]string{
+			"spdxElementId":      "SPDXRef-Project",
+			"relationshipType":   "DEPENDS_ON",
+			"relatedSpdxElement": id,
+		})
+	}
+
+	document := map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              doc.Name,
+		"documentNamespace": doc.Namespace,
+		"creationInfo": map[string]interface{}{
+			"created":  doc.Created.UTC().Format(time.RFC3339),
+			"creators": []string{fmt.Sprintf("Tool: %s-%s", doc.ToolName, doc.ToolVer)},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+	if len(extracted) > 0 {
+		infos := make([]map[string]string, 0, len(extracted))
+		for _, ref := range sortedKeys(extracted) {
+			infos = append(infos, map[string]string{
+				"licenseId":     ref,
+				"name":          extracted[ref],
+				"extractedText": extracted[ref],
+			})
+		}
+		document["hasExtractedLicensingInfos"] = infos
+	}
+	return writeJSON(path, document)
+}
+
+// spdxLicense returns a dependency's license as an SPDX license expression.
+// Licenses that are not SPDX identifiers become LicenseRefs, recorded in
+// extracted.
+func spdxLicense(l License, extracted map[string]string) string {
+	if !l.knownLicense() {
+		return "NOASSERTION"
+	}
+	if isExpression(l.License) || licenseIDPattern.MatchString(l.License) {
+		return l.License
+	}
+	ref := "LicenseRef-" + strings.Trim(licenseRefInvalid.ReplaceAllString(l.License, "-"), "-")
+	extracted[ref] = l.License
+	return ref
+}
+
+// integrityHash converts an npm Subresource Integrity string such as
+// "sha512-<base64>" to a hash
+func integrityHash(integrity string) (Hash, bool) {
+	alg, encoded, ok := strings.Cut(integrity, "-")
+	if !ok {
+		return Hash{}, false
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Hash{}, false
+	}
+	names := map[string]string{"sha1": "SHA-1", "sha256": "SHA-256", "sha512": "SHA-512"}
+	name, ok := names[alg]
+	if !ok {
+		return Hash{}, false
+	}
+	return Hash{Algorithm: name, Value: hex.EncodeToString(sum)}, true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// expression is a parsed SPDX license expression: a license, optionally
+// with an exception, or two expressions joined by AND or OR
+type expression struct {
+	op          string // "AND", "OR", or "" for a license
+	left, right *expression
+
+	license   string // license ID, possibly with a "+" suffix
+	exception string // WITH exception ID
+}
+
+// parseExpression parses an SPDX license expression. WITH binds tighter than
+// AND, which binds tighter than OR; parentheses override both.
+func parseExpression(s string) (*expression, error) {
+	p := &exprParser{tokens: tokenize(s)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty license expression")
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in license expression", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// tokenize splits an expression into parentheses and words
+func tokenize(s string) []string {
+	s = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(s)
+	return strings.Fields(s)
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// operator reports whether the next token is the given operator; operators
+// are case-insensitive, as npm and Maven metadata often use lower case
+func (p *exprParser) operator(op string) bool {
+	if strings.EqualFold(p.peek(), op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (*expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.operator("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &expression{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (*expression, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.operator("AND") {
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &expression{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseTerm() (*expression, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of license expression")
+	case tok == "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) in license expression")
+		}
+		p.pos++
+		return expr, nil
+	case tok == ")" || isOperator(tok):
+		return nil, fmt.Errorf("unexpected %q in license expression", tok)
+	}
+
+	p.pos++
+	expr := &expression{license: tok}
+	if p.operator("WITH") {
+		exception := p.peek()
+		if exception == "" || exception == "(" || exception == ")" || isOperator(exception) {
+			return nil, fmt.Errorf("missing exception after WITH in license expression")
+		}
+		p.pos++
+		expr.exception = exception
+	}
+	return expr, nil
+}
+
+func isOperator(tok string) bool {
+	return strings.EqualFold(tok, "AND") || strings.EqualFold(tok, "OR") || strings.EqualFold(tok, "WITH")
+}
+
+// verdict is the outcome of checking a license against the policy. Verdicts
+// are ordered from worst to best.
+type verdict int
+
+const (
+	verdictDenied verdict = iota
+	verdictUnknown
+	verdictAllowed
+)
+
+// evaluate checks an expression against the policy. A choice (OR) takes the
+// best of its options, since the project may pick either license; a
+// conjunction (AND) takes the worst, since both licenses apply.
+func (p *LicenseCompliancePlugin) evaluate(expr *expression) verdict {
+	switch expr.op {
+	case "OR":
+		return max(p.evaluate(expr.left), p.evaluate(expr.right))
+	case "AND":
+		return min(p.evaluate(expr.left), p.evaluate(expr.right))
+	}
+
+	// A policy entry may name the license with its exception, such as
+	// "GPL-2.0-only WITH Classpath-exception-2.0", to allow only that
+	// combination; otherwise the exception does not change the verdict
+	if expr.exception != "" {
+		withException := expr.license + " WITH " + expr.exception
+		if matchesAny(p.deniedLicenses, withException) {
+			return verdictDenied
+		}
+		if matchesAny(p.allowedLicenses, withException) {
+			return verdictAllowed
+		}
+	}
+	if matchesAny(p.deniedLicenses, expr.license) {
+		return verdictDenied
+	}
+	if matchesAny(p.allowedLicenses, expr.license) {
+		return verdictAllowed
+	}
+	return verdictUnknown
+}
+
+// checkLicense checks a dependency's license against the policy. Licenses
+// that are not valid SPDX expressions are matched as a whole.
+func (p *LicenseCompliancePlugin) checkLicense(license string) verdict {
+	if strings.TrimSpace(license) == "" || strings.EqualFold(license, "UNKNOWN") {
+		return verdictUnknown
+	}
+	expr, err := parseExpression(license)
+	if err != nil {
+		expr = &expression{license: strings.TrimSpace(license)}
+	}
+	return p.evaluate(expr)
+}
+
+// matchesAny reports whether a license matches an entry of a policy list.
+// Entries match case-insensitively and regardless of the "-only" and
+// "-or-later" suffixes, so "GPL-2.0" covers "GPL-2.0-only",
+// "GPL-2.0-or-later" and "GPL-2.0+".
+func matchesAny(list []string, license string) bool {
+	family := licenseFamily(license)
+	for _, entry := range list {
+		if strings.EqualFold(licenseFamily(entry), family) {
+			return true
+		}
+	}
+	return false
+}
+
+// licenseFamily strips the version range suffixes of a license ID
+func licenseFamily(license string) string {
+	license = strings.TrimSuffix(license, "+")
+	for _, suffix := range []string{"-only", "-or-later"} {
+		if len(license) > len(suffix) && strings.EqualFold(license[len(license)-len(suffix):], suffix) {
+			return license[:len(license)-len(suffix)]
+		}
+	}
+	return license
+}
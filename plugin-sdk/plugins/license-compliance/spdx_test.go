@@ -0,0 +1,112 @@
+package main
+
+import "testing"
+
+// format writes an expression fully parenthesized, to compare parse trees
+func format(e *expression) string {
+	if e.op != "" {
+		return "(" + format(e.left) + " " + e.op + " " + format(e.right) + ")"
+	}
+	if e.exception != "" {
+		return e.license + " WITH " + e.exception
+	}
+	return e.license
+}
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "MIT", want: "MIT"},
+		{in: "  GPL-2.0+ ", want: "GPL-2.0+"},
+		{in: "MIT OR Apache-2.0", want: "(MIT OR Apache-2.0)"},
+		{in: "MIT or Apache-2.0", want: "(MIT OR Apache-2.0)"},
+		{in: "MIT AND BSD-3-Clause OR ISC", want: "((MIT AND BSD-3-Clause) OR ISC)"},
+		{in: "MIT OR BSD-3-Clause AND ISC", want: "(MIT OR (BSD-3-Clause AND ISC))"},
+		{in: "(MIT OR BSD-3-Clause) AND ISC", want: "((MIT OR BSD-3-Clause) AND ISC)"},
+		{in: "GPL-2.0-only WITH Classpath-exception-2.0", want: "GPL-2.0-only WITH Classpath-exception-2.0"},
+		{in: "MIT OR GPL-2.0-only with Classpath-exception-2.0 AND ISC", want: "(MIT OR (GPL-2.0-only WITH Classpath-exception-2.0 AND ISC))"},
+		{in: "((MIT))", want: "MIT"},
+		{in: "", wantErr: true},
+		{in: "MIT OR", wantErr: true},
+		{in: "AND MIT", wantErr: true},
+		{in: "(MIT OR ISC", wantErr: true},
+		{in: "MIT)", wantErr: true},
+		{in: "MIT ISC", wantErr: true},
+		{in: "GPL-2.0 WITH", wantErr: true},
+		{in: "GPL-2.0 WITH OR MIT", wantErr: true},
+		{in: "()", wantErr: true},
+	}
+	for _, tt := range tests {
+		expr, err := parseExpression(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseExpression(%q) = %s, want an error", tt.in, format(expr))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseExpression(%q): %v", tt.in, err)
+			continue
+		}
+		if got := format(expr); got != tt.want {
+			t.Errorf("parseExpression(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckLicense(t *testing.T) {
+	p := &LicenseCompliancePlugin{
+		allowedLicenses: []string{"MIT", "Apache-2.0", "BSD-3-Clause", "GPL-2.0-only WITH Classpath-exception-2.0"},
+		deniedLicenses:  []string{"GPL-2.0", "AGPL-3.0"},
+	}
+	tests := []struct {
+		license string
+		want    verdict
+	}{
+		{"MIT", verdictAllowed},
+		{"mit", verdictAllowed},
+		{"GPL-2.0-only", verdictDenied},
+		{"GPL-2.0-or-later", verdictDenied},
+		{"GPL-2.0+", verdictDenied},
+		{"AGPL-3.0-only", verdictDenied},
+		{"LGPL-2.1", verdictUnknown},
+		{"", verdictUnknown},
+		{"UNKNOWN", verdictUnknown},
+		{"MIT OR GPL-2.0-only", verdictAllowed},
+		{"MIT AND GPL-2.0-only", verdictDenied},
+		{"MIT AND LGPL-2.1", verdictUnknown},
+		{"LGPL-2.1 OR GPL-2.0-only", verdictUnknown},
+		{"(MIT OR GPL-2.0-only) AND Apache-2.0", verdictAllowed},
+		{"GPL-2.0-only WITH Classpath-exception-2.0", verdictAllowed},
+		{"GPL-2.0-only WITH GCC-exception-2.0", verdictDenied},
+		{"MIT WITH Some-exception", verdictAllowed},
+		// Not valid expressions, so matched as a whole
+		{"Apache-2.0 OR", verdictUnknown},
+		{" BSD-3-Clause ", verdictAllowed},
+	}
+	for _, tt := range tests {
+		if got := p.checkLicense(tt.license); got != tt.want {
+			t.Errorf("checkLicense(%q) = %d, want %d", tt.license, got, tt.want)
+		}
+	}
+}
+
+func TestLicenseFamily(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"GPL-2.0", "GPL-2.0"},
+		{"GPL-2.0-only", "GPL-2.0"},
+		{"GPL-2.0-or-later", "GPL-2.0"},
+		{"GPL-2.0+", "GPL-2.0"},
+		{"LGPL-2.1-ONLY", "LGPL-2.1"},
+		{"-only", "-only"},
+		{"MIT", "MIT"},
+	}
+	for _, tt := range tests {
+		if got := licenseFamily(tt.in); got != tt.want {
+			t.Errorf("licenseFamily(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// NexusPublishPlugin uploads build outputs to a Sonatype Nexus Repository
+// through its components API, tags them, and promotes tagged builds between
+// repositories.
+//
+// The layout picks the repository format: generic uploads to a raw
+// repository under path, maven to a maven2 repository as
+// group_id:artifact_id:version, and npm publishes a tarball, whose
+// package.json names the package, to an npm repository. With a tag, the
+// uploaded components are associated with a tag carrying the build's
+// details and the configured properties as attributes. With action: promote
+// the step uploads nothing and instead moves the components of the tag from
+// one repository to another through the staging API; from and to name
+// repositories directly or through the repositories map. Tags and staging
+// require Nexus Repository Pro.
+//
+//	plugins:
+//	  - name: nexus-publish
+//	    secrets: {NEXUS_PASSWORD: "vault:secret/data/ci/nexus#password"}
+//	    config:
+//	      url: https://nexus.acme.com
+//	      username: ci
+//	      password_secret: NEXUS_PASSWORD
+//	      repository: maven-staging
+//	      layout: maven
+//	      files: ["target/*.jar"]
+//	      group_id: com.acme
+//	      artifact_id: billing
+//	      version: "1.4.{{.BuildNumber}}"
+//	      generate_pom: true
+//	      tag: "billing-{{.BuildNumber}}"
+//	      properties: {team: payments}
+//	  - name: nexus-publish
+//	    config:
+//	      url: https://nexus.acme.com
+//	      username: ci
+//	      password_secret: NEXUS_PASSWORD
+//	      action: promote
+//	      tag: "billing-{{.BuildNumber}}"
+//	      repositories: {staging: maven-staging, production: maven-releases}
+//	      from: staging
+//	      to: production
+type NexusPublishPlugin struct {
+	url            string
+	username       string
+	passwordSecret string // name of the secret holding the password of username
+	repository     string
+	repositories   map[string]string // environment -> repository
+	layout         string
+	files          []string
+	path           *template.Template
+	groupID        string
+	artifactID     string
+	version        *template.Template
+	generatePOM    bool
+	tag            *template.Template
+	properties     map[string]*template.Template
+	action         string
+	from           string
+	to             string
+
+	// resolved during Execute
+	password   string
+	dir        string // raw directory the build's files are published under
+	build      buildInfo
+	pomPending bool // generate a POM with the next main Maven asset
+	client     *http.Client
+}
+
+// buildInfo is the data path, version, tag and property templates are
+// rendered with
+type buildInfo struct {
+	Job         string
+	BuildNumber int
+	Branch      string
+	Commit      string
+	ShortCommit string
+}
+
+func (p *NexusPublishPlugin) Name() string {
+	return "nexus-publish"
+}
+
+func (p *NexusPublishPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *NexusPublishPlugin) Type() string {
+	return "artifact"
+}
+
+func (p *NexusPublishPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspaceReadOnly, sdk.CapabilitySecrets}
+}
+
+func (p *NexusPublishPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	if err := cfg.Require("url", "username", "password_secret"); err != nil {
+		return err
+	}
+	p.url = strings.TrimSuffix(cfg.String("url", ""), "/")
+	p.username = cfg.String("username", "")
+	p.passwordSecret = cfg.String("password_secret", "")
+
+	p.repository = cfg.String("repository", "")
+	p.repositories = cfg.StringMap("repositories")
+	p.files = cfg.StringSlice("files")
+	if file := cfg.String("files", ""); file != "" {
+		p.files = []string{file}
+	}
+	p.groupID = cfg.String("group_id", "")
+	p.artifactID = cfg.String("artifact_id", "")
+	p.generatePOM = cfg.Bool("generate_pom", false)
+
+	var err error
+	if p.path, err = template.New("path").Parse(cfg.String("path", "{{.Job}}/{{.BuildNumber}}")); err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+	if p.version, err = template.New("version").Parse(cfg.String("version", "")); err != nil {
+		return fmt.Errorf("invalid version: %w", err)
+	}
+	p.tag = nil
+	if tag := cfg.String("tag", ""); tag != "" {
+		if p.tag, err = template.New("tag").Parse(tag); err != nil {
+			return fmt.Errorf("invalid tag: %w", err)
+		}
+	}
+	p.properties = map[string]*template.Template{}
+	for k, v := range cfg.StringMap("properties") {
+		if p.properties[k], err = template.New(k).Parse(v); err != nil {
+			return fmt.Errorf("invalid property %s: %w", k, err)
+		}
+	}
+	if len(p.properties) > 0 && p.tag == nil {
+		return fmt.Errorf("properties are set as attributes of the tag, so tag is required")
+	}
+
+	p.layout = cfg.String("layout", "generic")
+	switch p.layout {
+	case "generic", "npm":
+	case "maven":
+		if p.groupID == "" || p.artifactID == "" || !cfg.Has("version") {
+			return fmt.Errorf("the maven layout requires group_id, artifact_id and version")
+		}
+	default:
+		return fmt.Errorf("unknown layout %q: must be generic, maven or npm", p.layout)
+	}
+
+	p.action = cfg.String("action", "publish")
+	switch p.action {
+	case "publish":
+		if p.repository == "" {
+			return fmt.Errorf("repository is required")
+		}
+		if len(p.files) == 0 {
+			return fmt.Errorf("files is required")
+		}
+	case "promote":
+		p.from = cfg.String("from", "")
+		p.to = cfg.String("to", "")
+		if p.from == "" || p.to == "" {
+			return fmt.Errorf("promote requires from and to")
+		}
+		if p.tag == nil {
+			return fmt.Errorf("promote moves the components of a tag, so tag is required")
+		}
+	default:
+		return fmt.Errorf("unknown action %q: must be publish or promote", p.action)
+	}
+
+	p.client = &http.Client{Timeout: cfg.Duration("timeout", 10*time.Minute)}
+	return nil
+}
+
+func (p *NexusPublishPlugin) Health() error {
+	req, err := http.NewRequest(http.MethodGet, p.url+"/service/rest/v1/status", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Nexus is not reachable: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Nexus status check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *NexusPublishPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	password, err := ctx.Secret(p.passwordSecret)
+	if err != nil {
+		return failure(err.Error()), err
+	}
+	p.password = password
+
+	params := sdk.Config(ctx.Parameters)
+	p.build = buildInfo{
+		Job:         params.String("job_name", ""),
+		BuildNumber: params.Int("build_number", 0),
+		Branch:      params.String("branch", ""),
+		Commit:      params.String("commit_sha", ""),
+	}
+	p.build.ShortCommit = p.build.Commit
+	if len(p.build.ShortCommit) > 8 {
+		p.build.ShortCommit = p.build.ShortCommit[:8]
+	}
+	tag := ""
+	if p.tag != nil {
+		if tag, err = p.render(p.tag); err != nil {
+			return failure(err.Error()), err
+		}
+	}
+
+	if p.action == "promote" {
+		ctx.Logger.Info(fmt.Sprintf("Promoting tag %s from %s to %s", tag, p.from, p.to))
+		if err := p.Promote(ctx, tag, p.from, p.to); err != nil {
+			return failure(fmt.Sprintf("Promotion failed: %v", err)), err
+		}
+		return &sdk.Result{
+			Success: true,
+			Metadata: map[string]interface{}{
+				"tag":      tag,
+				"from":     p.repositoryFor(p.from),
+				"to":       p.repositoryFor(p.to),
+				"promoted": true,
+			},
+		}, nil
+	}
+
+	if p.dir, err = p.render(p.path); err != nil {
+		return failure(err.Error()), err
+	}
+	p.dir = strings.Trim(path.Clean("/"+p.dir), "/")
+	paths, err := matchFiles(ctx.WorkDir, p.files)
+	if err != nil {
+		return failure(err.Error()), err
+	}
+	p.pomPending = p.layout == "maven" && p.generatePOM
+	for _, file := range paths {
+		if strings.HasSuffix(file, ".pom") {
+			p.pomPending = false
+		}
+	}
+
+	if tag != "" {
+		if err := p.saveTag(ctx, tag); err != nil {
+			return failure(fmt.Sprintf("Failed to create tag %s: %v", tag, err)), err
+		}
+	}
+
+	result := &sdk.Result{Success: true}
+	published := []string{}
+	for _, file := range paths {
+		artifact, err := newArtifact(file)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to read %s: %v", file, err)), err
+		}
+		location, err := p.Upload(ctx, &artifact)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to upload %s: %v", artifact.Name, err)), err
+		}
+		ctx.Logger.Info(fmt.Sprintf("Published %s to %s", artifact.Name, location))
+		if tag != "" {
+			if err := p.associate(ctx, tag, artifact.ChecksumSHA256); err != nil {
+				return failure(fmt.Sprintf("Failed to tag %s with %s: %v", artifact.Name, tag, err)), err
+			}
+		}
+		artifact.Metadata["url"] = location
+		artifact.Metadata["repository"] = p.repository
+		result.Artifacts = append(result.Artifacts, artifact)
+		published = append(published, location)
+	}
+
+	result.Metadata = map[string]interface{}{
+		"repository": p.repository,
+		"published":  published,
+	}
+	if tag != "" {
+		result.Metadata["tag"] = tag
+	}
+	return result, nil
+}
+
+// Upload uploads a file as a component, or a Maven asset of the build's
+// component, and returns its URL
+func (p *NexusPublishPlugin) Upload(ctx context.Context, artifact *sdk.Artifact) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	var field, location string
+	switch p.layout {
+	case "maven":
+		version, err := p.render(p.version)
+		if err != nil {
+			return "", err
+		}
+		classifier, extension, err := p.mavenAsset(artifact.Name, version)
+		if err != nil {
+			return "", err
+		}
+		form.WriteField("maven2.groupId", p.groupID)
+		form.WriteField("maven2.artifactId", p.artifactID)
+		form.WriteField("maven2.version", version)
+		form.WriteField("maven2.asset1.extension", extension)
+		if classifier != "" {
+			form.WriteField("maven2.asset1.classifier", classifier)
+		}
+		if p.pomPending && classifier == "" {
+			form.WriteField("maven2.generate-pom", "true")
+			p.pomPending = false
+		}
+		field = "maven2.asset1"
+		location = path.Join(strings.ReplaceAll(p.groupID, ".", "/"), p.artifactID, version, artifact.Name)
+	case "npm":
+		if !strings.HasSuffix(artifact.Name, ".tgz") {
+			return "", fmt.Errorf("%s is not an npm tarball", artifact.Name)
+		}
+		field = "npm.asset"
+	default:
+		form.WriteField("raw.directory", p.dir)
+		form.WriteField("raw.asset1.filename", artifact.Name)
+		field = "raw.asset1"
+		location = p.dir + "/" + artifact.Name
+	}
+
+	f, err := os.Open(artifact.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	part, err := form.CreateFormFile(field, artifact.Name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	endpoint := p.url + "/service/rest/v1/components?repository=" + url.QueryEscape(p.repository)
+	req, err := p.newRequest(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if _, err := p.do(req); err != nil {
+		return "", err
+	}
+
+	// Nexus reads the npm package and version from the tarball, so its
+	// URL is looked up
+	if location == "" {
+		return p.assetURL(ctx, artifact.ChecksumSHA256)
+	}
+	return p.url + "/repository/" + p.repository + "/" + location, nil
+}
+
+// Download fetches a file from Nexus to dest
+func (p *NexusPublishPlugin) Download(ctx context.Context, url, dest string) error {
+	req, err := p.newRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Promote moves the components of a tag from the repository of one
+// environment to that of another
+func (p *NexusPublishPlugin) Promote(ctx context.Context, artifactID, fromEnv, toEnv string) error {
+	query := url.Values{}
+	query.Set("repository", p.repositoryFor(fromEnv))
+	query.Set("tag", artifactID)
+	endpoint := p.url + "/service/rest/v1/staging/move/" + url.PathEscape(p.repositoryFor(toEnv)) + "?" + query.Encode()
+	req, err := p.newRequest(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	_, err = p.do(req)
+	return err
+}
+
+func (p *NexusPublishPlugin) Cleanup() error {
+	return nil
+}
+
+// saveTag creates the tag, or updates its attributes if it exists, with the
+// build's details and the configured properties
+func (p *NexusPublishPlugin) saveTag(ctx context.Context, tag string) error {
+	attributes := map[string]string{
+		"build.name":   p.build.Job,
+		"build.number": fmt.Sprint(p.build.BuildNumber),
+		"vcs.revision": p.build.Commit,
+		"vcs.branch":   p.build.Branch,
+	}
+	for k, tmpl := range p.properties {
+		v, err := p.render(tmpl)
+		if err != nil {
+			return err
+		}
+		attributes[k] = v
+	}
+
+	req, err := p.newRequest(ctx, http.MethodGet, p.url+"/service/rest/v1/tags/"+url.PathEscape(tag), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	method, endpoint := http.MethodPost, p.url+"/service/rest/v1/tags"
+	payload := map[string]interface{}{"name": tag, "attributes": attributes}
+	if resp.StatusCode == http.StatusOK {
+		method, endpoint = http.MethodPut, endpoint+"/"+url.PathEscape(tag)
+		payload = map[string]interface{}{"attributes": attributes}
+	}
+	body, _ := json.Marshal(payload)
+	req, err = p.newRequest(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = p.do(req)
+	return err
+}
+
+// associate tags the component of an uploaded file, found by its checksum
+func (p *NexusPublishPlugin) associate(ctx context.Context, tag, checksum string) error {
+	query := url.Values{}
+	query.Set("repository", p.repository)
+	query.Set("sha256", checksum)
+	endpoint := p.url + "/service/rest/v1/tags/associate/" + url.PathEscape(tag) + "?" + query.Encode()
+	req, err := p.newRequest(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	_, err = p.do(req)
+	return err
+}
+
+// assetURL returns the download URL of the asset with a checksum
+func (p *NexusPublishPlugin) assetURL(ctx context.Context, checksum string) (string, error) {
+	query := url.Values{}
+	query.Set("repository", p.repository)
+	query.Set("sha256", checksum)
+	req, err := p.newRequest(ctx, http.MethodGet, p.url+"/service/rest/v1/search/assets?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	body, err := p.do(req)
+	if err != nil {
+		return "", err
+	}
+	var page struct {
+		Items []struct {
+			DownloadURL string `json:"downloadUrl"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return "", err
+	}
+	if len(page.Items) == 0 {
+		return "", fmt.Errorf("no asset with checksum %s", checksum)
+	}
+	return page.Items[0].DownloadURL, nil
+}
+
+// mavenAsset returns the classifier and extension of a file named
+// <artifact_id>-<version>[-<classifier>].<extension>
+func (p *NexusPublishPlugin) mavenAsset(name, version string) (classifier, extension string, err error) {
+	prefix := p.artifactID + "-" + version
+	rest := strings.TrimPrefix(name, prefix)
+	if rest == name || (!strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "-")) {
+		return "", "", fmt.Errorf("%s does not match the Maven layout: expected %s[-<classifier>].<extension>", name, prefix)
+	}
+	if strings.HasPrefix(rest, "-") {
+		var ok bool
+		classifier, rest, ok = strings.Cut(rest[1:], ".")
+		if !ok || classifier == "" {
+			return "", "", fmt.Errorf("%s has no extension", name)
+		}
+		return classifier, rest, nil
+	}
+	return "", rest[1:], nil
+}
+
+// repositoryFor returns the repository of an environment, or name itself if
+// it is not an environment
+func (p *NexusPublishPlugin) repositoryFor(name string) string {
+	if repo, ok := p.repositories[name]; ok {
+		return repo
+	}
+	return name
+}
+
+// render executes a template with the build's details
+func (p *NexusPublishPlugin) render(tmpl *template.Template) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, p.build); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
+
+func (p *NexusPublishPlugin) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.username, p.password)
+	return req, nil
+}
+
+// do sends a request and returns its body, or Nexus's error if it fails
+func (p *NexusPublishPlugin) do(req *http.Request) ([]byte, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s returned status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// matchFiles returns the workspace files matching the patterns, which are
+// relative to the workspace
+func matchFiles(workDir string, patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	files := []string{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(workDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid files pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil || info.IsDir() || seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", strings.Join(patterns, ", "))
+	}
+	return files, nil
+}
+
+func newArtifact(path string) (sdk.Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sdk.Artifact{}, err
+	}
+	return sdk.Artifact{
+		Name:           filepath.Base(path),
+		Path:           path,
+		SizeBytes:      info.Size(),
+		ChecksumSHA256: hex.EncodeToString(h.Sum(nil)),
+		Metadata:       map[string]string{"kind": "published"},
+	}, nil
+}
+
+func failure(message string) *sdk.Result {
+	return &sdk.Result{Success: false, ExitCode: 1, ErrorMessage: message}
+}
+
+// Export the plugin
+var Plugin NexusPublishPlugin
+
+// Serve the plugin to the worker agent
+func main() {
+	sdk.Serve(&Plugin)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// defaultCLIVersion is the dependency-check release downloaded when the CLI
+// is not installed and cli_version is not set
+const defaultCLIVersion = "12.1.0"
+
+// cliReleaseURL is where dependency-check releases are downloaded from by
+// default, by version
+const cliReleaseURL = "https://github.com/dependency-check/DependencyCheck/releases/download/v%[1]s/dependency-check-%[1]s-release.zip"
+
+// launcher is the name of the dependency-check start script
+func launcher() string {
+	if runtime.GOOS == "windows" {
+		return "dependency-check.bat"
+	}
+	return "dependency-check.sh"
+}
+
+// findCLI returns the dependency-check start script: cli_path if set, the
+// script on PATH, or the release installed in cli_dir, downloading it first
+// if needed
+func (p *OWASPDependencyCheckPlugin) findCLI(ctx *sdk.ExecutionContext) (string, error) {
+	if p.cliPath != "" {
+		return p.cliPath, nil
+	}
+	if path, err := exec.LookPath(launcher()); err == nil {
+		return path, nil
+	}
+
+	installDir := filepath.Join(p.cliDir, p.cliVersion)
+	path := filepath.Join(installDir, "dependency-check", "bin", launcher())
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if p.cliSHA256 == "" {
+		return "", fmt.Errorf("dependency-check is not installed, and cli_sha256 is required to download it")
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Downloading dependency-check %s from %s", p.cliVersion, p.cliURL))
+	if err := installRelease(ctx, p.cliURL, p.cliSHA256, installDir); err != nil {
+		return "", fmt.Errorf("failed to install dependency-check %s: %w", p.cliVersion, err)
+	}
+	return path, nil
+}
+
+// installRelease downloads a release archive, checks it against the expected
+// SHA-256 and extracts it into dir. The archive is extracted next to dir and
+// renamed into place, so concurrent builds never see a partial install.
+func installRelease(ctx context.Context, url, checksum, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	archive, err := os.CreateTemp(filepath.Dir(dir), ".download-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, h), resp.Body); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, checksum) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", checksum, got)
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractZip(archive.Name(), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil // installed by another build meanwhile
+		}
+		return err
+	}
+	return nil
+}
+
+// extractZip extracts a zip archive into dest, rejecting entries that would
+// land outside it
+func extractZip(path, dest string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		target := filepath.Join(dest, f.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dest)+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %s is outside the destination", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, target string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	mode := f.Mode().Perm()
+	if strings.HasSuffix(f.Name, ".sh") {
+		// Some archivers drop the executable bit
+		mode |= 0111
+	}
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
 )
@@ -18,19 +20,36 @@ type OWASPDependencyCheckPlugin struct {
 	format             string
 	suppressionFile    string
 	enableExperimental bool
-	timeout            int
+	timeout            time.Duration
+
+	mode    string // "docker" or "cli"
+	dataDir string // NVD data directory, relative to the workspace unless absolute
+
+	// cli mode
+	cliPath    string // start script to run, instead of finding or installing one
+	cliVersion string
+	cliURL     string // release archive to download, such as an internal mirror
+	cliSHA256  string // of the release archive, required to download it
+	cliDir     string // where downloaded releases are installed
 }
 
+// Run modes
+const (
+	modeDocker = "docker" // run the owasp/dependency-check image
+	modeCLI    = "cli"    // run an installed or downloaded dependency-check CLI
+)
+
 type DependencyCheckReport struct {
 	Dependencies []struct {
 		FileName        string `json:"fileName"`
 		FilePath        string `json:"filePath"`
 		Vulnerabilities []struct {
-			Name        string  `json:"name"`
-			CVSSV2      float64 `json:"cvssv2"`
-			CVSSV3      float64 `json:"cvssv3"`
-			Severity    string  `json:"severity"`
-			Description string  `json:"description"`
+			Name        string   `json:"name"`
+			CVSSV2      float64  `json:"cvssv2"`
+			CVSSV3      float64  `json:"cvssv3"`
+			Severity    string   `json:"severity"`
+			Description string   `json:"description"`
+			CWEs        []string `json:"cwes"`
 			References  []struct {
 				Source string `json:"source"`
 				URL    string `json:"url"`
@@ -51,15 +70,58 @@ func (p *OWASPDependencyCheckPlugin) Type() string {
 	return "security"
 }
 
+func (p *OWASPDependencyCheckPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace}
+}
+
 func (p *OWASPDependencyCheckPlugin) Initialize(config map[string]interface{}) error {
-	p.projectPath = getStringConfig(config, "project_path", ".")
-	p.scanPath = getStringConfig(config, "scan_path", ".")
-	p.failOnCVSS = getFloatConfig(config, "fail_on_cvss", 7.0)
-	p.format = getStringConfig(config, "format", "JSON")
-	p.suppressionFile = getStringConfig(config, "suppression_file", "")
-	p.enableExperimental = getBoolConfig(config, "enable_experimental", false)
-	p.timeout = getIntConfig(config, "timeout", 600)
+	cfg := sdk.Config(config)
+	p.projectPath = cfg.String("project_path", ".")
+	p.scanPath = cfg.String("scan_path", ".")
+	p.failOnCVSS = cfg.Float("fail_on_cvss", 7.0)
+	p.format = cfg.String("format", "JSON")
+	p.suppressionFile = cfg.String("suppression_file", "")
+	p.enableExperimental = cfg.Bool("enable_experimental", false)
+	p.timeout = cfg.Duration("timeout", 10*time.Minute)
+
+	p.mode = cfg.String("mode", modeDocker)
+	if p.mode != modeDocker && p.mode != modeCLI {
+		return fmt.Errorf("invalid mode %q (expected docker or cli)", p.mode)
+	}
+	p.dataDir = cfg.String("data_dir", ".dependency-check-data")
+	p.cliPath = cfg.String("cli_path", "")
+	p.cliVersion = cfg.String("cli_version", defaultCLIVersion)
+	p.cliURL = cfg.String("cli_url", fmt.Sprintf(cliReleaseURL, p.cliVersion))
+	p.cliSHA256 = cfg.String("cli_sha256", "")
+
+	defaultCLIDir := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		defaultCLIDir = filepath.Join(dir, "solvyd", "dependency-check")
+	}
+	p.cliDir = cfg.String("cli_dir", defaultCLIDir)
+	if p.mode == modeCLI && p.cliPath == "" && p.cliDir == "" {
+		return fmt.Errorf("cli_dir is required when the user cache directory is unknown")
+	}
+
+	return nil
+}
 
+func (p *OWASPDependencyCheckPlugin) Health() error {
+	if p.mode == modeCLI {
+		if _, err := exec.LookPath("java"); err != nil {
+			return fmt.Errorf("java is required to run the dependency-check CLI: %w", err)
+		}
+		if p.cliPath != "" && !isFile(p.cliPath) {
+			return fmt.Errorf("cli_path %s does not exist", p.cliPath)
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker is not installed: %w", err)
+	}
+	if out, err := exec.Command("docker", "version", "--format", "{{.Server.Version}}").CombinedOutput(); err != nil {
+		return fmt.Errorf("docker daemon is not reachable: %s", strings.TrimSpace(string(out)))
+	}
 	return nil
 }
 
@@ -74,32 +136,52 @@ func (p *OWASPDependencyCheckPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Re
 		}, err
 	}
 
-	// Build dependency-check command
-	args := []string{
-		"run", "--rm",
-		"-v", fmt.Sprintf("%s:/src:ro", filepath.Join(ctx.WorkDir, p.scanPath)),
-		"-v", fmt.Sprintf("%s:/report", outputDir),
-		"owasp/dependency-check",
-		"--scan", "/src",
-		"--format", p.format,
-		"--out", "/report",
-		"--project", ctx.JobID,
+	dataDir := p.dataDir
+	if dataDir != "" && !filepath.IsAbs(dataDir) {
+		dataDir = filepath.Join(ctx.WorkDir, dataDir)
 	}
-
-	if p.suppressionFile != "" {
-		args = append(args, "--suppression", p.suppressionFile)
+	if dataDir != "" {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return &sdk.Result{
+				Success:      false,
+				ErrorMessage: fmt.Sprintf("Failed to create data directory: %v", err),
+			}, err
+		}
 	}
 
-	if p.enableExperimental {
-		args = append(args, "--enableExperimental")
+	var cmd *exec.Cmd
+	if p.mode == modeCLI {
+		cli, err := p.findCLI(ctx)
+		if err != nil {
+			return &sdk.Result{
+				Success:      false,
+				ErrorMessage: err.Error(),
+			}, err
+		}
+		args := p.scanArgs(filepath.Join(ctx.WorkDir, p.scanPath), outputDir, ctx.JobID)
+		if dataDir != "" {
+			args = append(args, "--data", dataDir)
+		}
+		cmd = exec.CommandContext(ctx, cli, args...)
+		ctx.Logger.Info(fmt.Sprintf("Running dependency-check CLI %s...", cli))
+	} else {
+		args := []string{
+			"run", "--rm",
+			"-v", fmt.Sprintf("%s:/src:ro", filepath.Join(ctx.WorkDir, p.scanPath)),
+			"-v", fmt.Sprintf("%s:/report", outputDir),
+		}
+		if dataDir != "" {
+			args = append(args, "-v", fmt.Sprintf("%s:/usr/share/dependency-check/data", dataDir))
+		}
+		args = append(args, "owasp/dependency-check")
+		args = append(args, p.scanArgs("/src", "/report", ctx.JobID)...)
+		cmd = exec.CommandContext(ctx, "docker", args...)
+		ctx.Logger.Info("Running dependency-check in Docker container...")
 	}
-
-	cmd := exec.Command("docker", args...)
 	cmd.Dir = ctx.WorkDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = ctx.Output
+	cmd.Stderr = ctx.Output
 
-	ctx.Logger.Info("Running dependency-check in Docker container...")
 	if err := cmd.Run(); err != nil {
 		return &sdk.Result{
 			Success:      false,
@@ -129,6 +211,7 @@ func (p *OWASPDependencyCheckPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Re
 	totalVulns := 0
 	highSeverityVulns := 0
 	vulnsByCVSS := make(map[string]int)
+	var findings []sdk.Finding
 
 	for _, dep := range report.Dependencies {
 		for _, vuln := range dep.Vulnerabilities {
@@ -152,6 +235,27 @@ func (p *OWASPDependencyCheckPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Re
 			} else {
 				vulnsByCVSS["LOW"]++
 			}
+
+			severity := sdk.NormalizeSeverity(vuln.Severity)
+			if cvss > 0 {
+				severity = sdk.SeverityFromScore(cvss)
+			}
+			f := sdk.Finding{
+				Tool:        "dependency-check",
+				RuleID:      vuln.Name,
+				Title:       vuln.Name,
+				Description: vuln.Description,
+				Severity:    severity,
+				Location:    sdk.Location{Package: dep.FileName},
+				CWEs:        vuln.CWEs,
+			}
+			if strings.HasPrefix(vuln.Name, "CVE-") {
+				f.CVEs = []string{vuln.Name}
+			}
+			if len(vuln.References) > 0 {
+				f.HelpURL = vuln.References[0].URL
+			}
+			findings = append(findings, f)
 		}
 	}
 
@@ -161,6 +265,7 @@ func (p *OWASPDependencyCheckPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Re
 		ExitCode: 0,
 		Metadata: make(map[string]interface{}),
 		Output:   fmt.Sprintf("Found %d vulnerabilities (%d above CVSS %.1f)", totalVulns, highSeverityVulns, p.failOnCVSS),
+		Findings: findings,
 	}
 
 	if highSeverityVulns > 0 {
@@ -172,6 +277,10 @@ func (p *OWASPDependencyCheckPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Re
 	result.Metadata["high_severity_count"] = highSeverityVulns
 	result.Metadata["vulnerabilities_by_severity"] = vulnsByCVSS
 	result.Metadata["cvss_threshold"] = p.failOnCVSS
+	result.Metadata["mode"] = p.mode
+	if p.dataDir != "" {
+		result.Metadata["data_dir"] = p.dataDir
+	}
 
 	ctx.Logger.Info(result.Output)
 	for severity, count := range vulnsByCVSS {
@@ -181,44 +290,41 @@ func (p *OWASPDependencyCheckPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Re
 	return result, nil
 }
 
-func (p *OWASPDependencyCheckPlugin) Cleanup() error {
-	return nil
-}
+// scanArgs returns the dependency-check arguments common to both modes
+func (p *OWASPDependencyCheckPlugin) scanArgs(scanDir, outputDir, project string) []string {
+	args := []string{
+		"--scan", scanDir,
+		"--format", p.format,
+		"--out", outputDir,
+		"--project", project,
+	}
+	if p.dataDir != "" && !filepath.IsAbs(p.dataDir) {
+		// Keep a data directory inside the workspace out of the scan
+		args = append(args, "--exclude", "**/"+filepath.ToSlash(filepath.Clean(p.dataDir))+"/**")
+	}
 
-// Helper functions
-func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
-	if val, ok := config[key].(string); ok {
-		return val
+	if p.suppressionFile != "" {
+		args = append(args, "--suppression", p.suppressionFile)
 	}
-	return defaultValue
-}
 
-func getFloatConfig(config map[string]interface{}, key string, defaultValue float64) float64 {
-	if val, ok := config[key].(float64); ok {
-		return val
+	if p.enableExperimental {
+		args = append(args, "--enableExperimental")
 	}
-	return defaultValue
+	return args
 }
 
-func getIntConfig(config map[string]interface{}, key string, defaultValue int) int {
-	if val, ok := config[key].(float64); ok {
-		return int(val)
-	}
-	return defaultValue
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
 }
 
-func getBoolConfig(config map[string]interface{}, key string, defaultValue bool) bool {
-	if val, ok := config[key].(bool); ok {
-		return val
-	}
-	return defaultValue
+func (p *OWASPDependencyCheckPlugin) Cleanup() error {
+	return nil
 }
 
 // Export the plugin
 var Plugin OWASPDependencyCheckPlugin
 
 func main() {
-	fmt.Println("OWASP Dependency-Check Plugin v1.0.0")
-	fmt.Println("This plugin scans project dependencies for known vulnerabilities")
-	fmt.Println("To build: go build -o owasp-dependency-check")
+	sdk.Serve(&Plugin)
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// API definition formats
+const (
+	formatOpenAPI = "openapi"
+	formatGraphQL = "graphql"
+)
+
+// apiEndpoint is an operation imported from an API definition
+type apiEndpoint struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"` // of ZAP's request while importing
+}
+
+// importDefinition imports the API definition into ZAP, which requests every
+// operation it defines so they appear in the site tree for the active scan.
+// A definition that is not a URL is a file in the workspace, which ZAP reads
+// itself, so it must share the workspace with the worker.
+func (p *OWASPZAPDASTPlugin) importDefinition(ctx *sdk.ExecutionContext, client *http.Client, session *zapSession) error {
+	source := p.apiDefinition
+	isURL := strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+	if !isURL {
+		source = filepath.Join(ctx.WorkDir, source)
+		if _, err := os.Stat(source); err != nil {
+			return fmt.Errorf("API definition %s not found: %w", p.apiDefinition, err)
+		}
+	}
+
+	var endpoint string
+	params := url.Values{}
+	switch {
+	case p.apiFormat == formatGraphQL && isURL:
+		endpoint = "graphql/action/importUrl"
+		params.Set("url", source)
+		params.Set("endurl", p.targetURL)
+	case p.apiFormat == formatGraphQL:
+		endpoint = "graphql/action/importFile"
+		params.Set("file", source)
+		params.Set("endurl", p.targetURL)
+	case isURL:
+		endpoint = "openapi/action/importUrl"
+		params.Set("url", source)
+		params.Set("hostOverride", hostOf(p.targetURL))
+	default:
+		endpoint = "openapi/action/importFile"
+		params.Set("file", source)
+		params.Set("target", p.targetURL)
+	}
+	if session.contextID != "" && p.apiFormat == formatOpenAPI {
+		params.Set("contextId", session.contextID)
+	}
+
+	// OpenAPI imports report definitions ZAP could not use as warnings
+	var result struct {
+		ImportURL  []string `json:"importUrl"`
+		ImportFile []string `json:"importFile"`
+	}
+	if err := p.call(ctx, client, endpoint, params, &result); err != nil {
+		return err
+	}
+	for _, warning := range append(result.ImportURL, result.ImportFile...) {
+		ctx.Logger.Warn(fmt.Sprintf("API import: %s", warning))
+	}
+	return nil
+}
+
+// apiEndpoints returns the operations ZAP requested under the target URL,
+// with the status of each. Requests repeated for the same operation keep the
+// best status.
+func (p *OWASPZAPDASTPlugin) apiEndpoints(ctx context.Context, client *http.Client) ([]apiEndpoint, error) {
+	var result struct {
+		Messages []struct {
+			RequestHeader  string `json:"requestHeader"`
+			ResponseHeader string `json:"responseHeader"`
+		} `json:"messages"`
+	}
+	if err := p.call(ctx, client, "core/view/messages", url.Values{"baseurl": {p.targetURL}}, &result); err != nil {
+		return nil, err
+	}
+
+	byOperation := map[string]*apiEndpoint{}
+	for _, msg := range result.Messages {
+		// "GET https://api.example.com/pets?limit=1 HTTP/1.1"
+		request := strings.Fields(firstLine(msg.RequestHeader))
+		if len(request) < 2 {
+			continue
+		}
+		u, err := url.Parse(request[1])
+		if err != nil {
+			continue
+		}
+		// "HTTP/1.1 200 OK"
+		status := 0
+		if response := strings.Fields(firstLine(msg.ResponseHeader)); len(response) >= 2 {
+			status, _ = strconv.Atoi(response[1])
+		}
+
+		key := request[0] + " " + u.Path
+		e, ok := byOperation[key]
+		if !ok {
+			e = &apiEndpoint{Method: request[0], Path: u.Path, Status: status}
+			byOperation[key] = e
+		}
+		if reached(status) && !reached(e.Status) {
+			e.Status = status
+		}
+	}
+
+	endpoints := make([]apiEndpoint, 0, len(byOperation))
+	for _, e := range byOperation {
+		endpoints = append(endpoints, *e)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+	return endpoints, nil
+}
+
+// apiCoverage summarizes how much of the API the scan reached. An endpoint
+// that answered with an error status, often because the scan lacked
+// credentials or valid parameters, was barely tested.
+func apiCoverage(endpoints []apiEndpoint, alerts []ZAPAlert) map[string]interface{} {
+	alerted := map[string]bool{}
+	for _, alert := range alerts {
+		if u, err := url.Parse(alert.URL); err == nil {
+			alerted[u.Path] = true
+		}
+	}
+
+	reachedCount := 0
+	withAlerts := 0
+	unreached := []string{}
+	for _, e := range endpoints {
+		if reached(e.Status) {
+			reachedCount++
+		} else {
+			unreached = append(unreached, fmt.Sprintf("%s %s (%d)", e.Method, e.Path, e.Status))
+		}
+		if alerted[e.Path] {
+			withAlerts++
+		}
+	}
+	percent := 0.0
+	if len(endpoints) > 0 {
+		percent = float64(reachedCount) * 100 / float64(len(endpoints))
+	}
+	return map[string]interface{}{
+		"endpoints_total":       len(endpoints),
+		"endpoints_reached":     reachedCount,
+		"endpoints_with_alerts": withAlerts,
+		"coverage_percent":      percent,
+		"unreached":             unreached,
+	}
+}
+
+// reached reports whether a status shows the request got past routing and
+// authentication
+func reached(status int) bool {
+	return status >= 200 && status < 400
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSpace(line)
+}
+
+// hostOf returns the host and port of a URL, for pointing an imported
+// definition at the scanned deployment
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// Authentication methods
+const (
+	authForm   = "form"   // log in by posting a login form
+	authHeader = "header" // send a token header with every request
+	authScript = "script" // log in with a ZAP authentication script
+)
+
+// zapAuth configures how ZAP logs in to the target, so the spider and active
+// scan reach pages behind login
+type zapAuth struct {
+	method string
+
+	// form and script
+	username       string
+	passwordSecret string // name of the secret holding the password
+	loggedIn       string // regex matching responses of a logged-in session
+	loggedOut      string // regex matching responses of a logged-out session
+
+	// form
+	loginURL  string
+	loginData string // POST body, with {%username%} and {%password%} placeholders
+
+	// header
+	headerName  string
+	tokenSecret string // name of the secret holding the header value
+	tokenPrefix string // such as "Bearer "
+
+	// script
+	scriptName   string            // a script already loaded in ZAP, or the name to load scriptFile as
+	scriptFile   string            // path on the ZAP server
+	scriptEngine string            // such as "Graal.js"
+	scriptParams map[string]string // the script's required and optional parameters
+}
+
+// parseAuth reads the auth config, returning nil if there is none
+func parseAuth(cfg sdk.Config) (*zapAuth, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	a := &zapAuth{
+		method:         cfg.String("type", ""),
+		username:       cfg.String("username", ""),
+		passwordSecret: cfg.String("password_secret", ""),
+		loggedIn:       cfg.String("logged_in_indicator", ""),
+		loggedOut:      cfg.String("logged_out_indicator", ""),
+		loginURL:       cfg.String("login_url", ""),
+		loginData:      cfg.String("login_request_data", "username={%username%}&password={%password%}"),
+		headerName:     cfg.String("header_name", "Authorization"),
+		tokenSecret:    cfg.String("token_secret", ""),
+		tokenPrefix:    cfg.String("token_prefix", ""),
+		scriptName:     cfg.String("script_name", ""),
+		scriptFile:     cfg.String("script_file", ""),
+		scriptEngine:   cfg.String("script_engine", "Graal.js"),
+		scriptParams:   cfg.StringMap("script_params"),
+	}
+
+	switch a.method {
+	case authForm:
+		if err := cfg.Require("login_url", "username", "password_secret"); err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	case authHeader:
+		if err := cfg.Require("token_secret"); err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	case authScript:
+		if err := cfg.Require("script_name", "username", "password_secret"); err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("auth: invalid type %q (expected form, header or script)", a.method)
+	}
+	if a.method != authHeader && a.loggedIn == "" && a.loggedOut == "" {
+		// Without an indicator ZAP cannot tell when a session has expired,
+		// and scans the rest of the site logged out
+		return nil, fmt.Errorf("auth: logged_in_indicator or logged_out_indicator is required")
+	}
+	return a, nil
+}
+
+// zapSession is the ZAP context, and user if any, a scan runs in
+type zapSession struct {
+	contextName string
+	contextID   string
+	userID      string // empty unless logging in as a user
+	replacerID  string // description of the header rule, if any
+}
+
+// setupSession creates a ZAP context for the scan, with the include and
+// exclude regexes and the authentication. Remove it with teardownSession.
+func (p *OWASPZAPDASTPlugin) setupSession(ctx *sdk.ExecutionContext, client *http.Client) (*zapSession, error) {
+	s := &zapSession{contextName: "solvyd-" + ctx.BuildID}
+	var result struct {
+		ContextID string `json:"contextId"`
+		UserID    string `json:"userId"`
+	}
+	if err := p.call(ctx, client, "context/action/newContext", url.Values{"contextName": {s.contextName}}, &result); err != nil {
+		return nil, fmt.Errorf("failed to create context: %w", err)
+	}
+	s.contextID = result.ContextID
+
+	include := p.include
+	if len(include) == 0 {
+		include = []string{regexp.QuoteMeta(p.targetURL) + ".*"}
+	}
+	for _, regex := range include {
+		if err := p.call(ctx, client, "context/action/includeInContext", url.Values{"contextName": {s.contextName}, "regex": {regex}}, nil); err != nil {
+			return s, fmt.Errorf("failed to include %s: %w", regex, err)
+		}
+	}
+	for _, regex := range p.exclude {
+		if err := p.call(ctx, client, "context/action/excludeFromContext", url.Values{"contextName": {s.contextName}, "regex": {regex}}, nil); err != nil {
+			return s, fmt.Errorf("failed to exclude %s: %w", regex, err)
+		}
+	}
+
+	if p.auth == nil {
+		return s, nil
+	}
+	a := p.auth
+
+	if a.method == authHeader {
+		token, err := ctx.Secret(a.tokenSecret)
+		if err != nil {
+			return s, err
+		}
+		// The replacer adds the header to every request ZAP sends to the
+		// target, from the spider and the active scanner alike
+		s.replacerID = s.contextName + "-auth"
+		err = p.call(ctx, client, "replacer/action/addRule", url.Values{
+			"description": {s.replacerID},
+			"enabled":     {"true"},
+			"matchType":   {"REQ_HEADER"},
+			"matchRegex":  {"false"},
+			"matchString": {a.headerName},
+			"replacement": {a.tokenPrefix + token},
+			"url":         {include[0]},
+		}, nil)
+		if err != nil {
+			s.replacerID = ""
+			return s, fmt.Errorf("failed to add %s header: %w", a.headerName, err)
+		}
+		ctx.Logger.Info(fmt.Sprintf("Sending %s header with every request", a.headerName))
+		return s, nil
+	}
+
+	password, err := ctx.Secret(a.passwordSecret)
+	if err != nil {
+		return s, err
+	}
+
+	var method string
+	var methodParams, credentials url.Values
+	switch a.method {
+	case authForm:
+		method = "formBasedAuthentication"
+		methodParams = url.Values{"loginUrl": {a.loginURL}, "loginRequestData": {a.loginData}}
+		credentials = url.Values{"username": {a.username}, "password": {password}}
+	case authScript:
+		if a.scriptFile != "" {
+			err := p.call(ctx, client, "script/action/load", url.Values{
+				"scriptName":   {a.scriptName},
+				"scriptType":   {"authentication"},
+				"scriptEngine": {a.scriptEngine},
+				"fileName":     {a.scriptFile},
+			}, nil)
+			if err != nil {
+				return s, fmt.Errorf("failed to load script %s: %w", a.scriptFile, err)
+			}
+		}
+		method = "scriptBasedAuthentication"
+		methodParams = url.Values{"scriptName": {a.scriptName}}
+		keys := make([]string, 0, len(a.scriptParams))
+		for k := range a.scriptParams {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			methodParams.Set(k, a.scriptParams[k])
+		}
+		credentials = url.Values{"Username": {a.username}, "Password": {password}}
+	}
+
+	err = p.call(ctx, client, "authentication/action/setAuthenticationMethod", url.Values{
+		"contextId":              {s.contextID},
+		"authMethodName":         {method},
+		"authMethodConfigParams": {methodParams.Encode()},
+	}, nil)
+	if err != nil {
+		return s, fmt.Errorf("failed to set authentication method: %w", err)
+	}
+	if a.loggedIn != "" {
+		err := p.call(ctx, client, "authentication/action/setLoggedInIndicator", url.Values{"contextId": {s.contextID}, "loggedInIndicatorRegex": {a.loggedIn}}, nil)
+		if err != nil {
+			return s, fmt.Errorf("failed to set logged in indicator: %w", err)
+		}
+	}
+	if a.loggedOut != "" {
+		err := p.call(ctx, client, "authentication/action/setLoggedOutIndicator", url.Values{"contextId": {s.contextID}, "loggedOutIndicatorRegex": {a.loggedOut}}, nil)
+		if err != nil {
+			return s, fmt.Errorf("failed to set logged out indicator: %w", err)
+		}
+	}
+
+	if err := p.call(ctx, client, "users/action/newUser", url.Values{"contextId": {s.contextID}, "name": {a.username}}, &result); err != nil {
+		return s, fmt.Errorf("failed to create user: %w", err)
+	}
+	s.userID = result.UserID
+	err = p.call(ctx, client, "users/action/setAuthenticationCredentials", url.Values{
+		"contextId":                   {s.contextID},
+		"userId":                      {s.userID},
+		"authCredentialsConfigParams": {credentials.Encode()},
+	}, nil)
+	if err != nil {
+		return s, fmt.Errorf("failed to set credentials: %w", err)
+	}
+	if err := p.call(ctx, client, "users/action/setUserEnabled", url.Values{"contextId": {s.contextID}, "userId": {s.userID}, "enabled": {"true"}}, nil); err != nil {
+		return s, fmt.Errorf("failed to enable user: %w", err)
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Scanning as %s with %s authentication", a.username, a.method))
+	return s, nil
+}
+
+// teardownSession removes the context and header rule from the ZAP server,
+// which outlives the build
+func (p *OWASPZAPDASTPlugin) teardownSession(client *http.Client, s *zapSession) {
+	ctx := context.Background()
+	if s.replacerID != "" {
+		p.call(ctx, client, "replacer/action/removeRule", url.Values{"description": {s.replacerID}}, nil)
+	}
+	if s.contextID != "" {
+		p.call(ctx, client, "context/action/removeContext", url.Values{"contextName": {s.contextName}}, nil)
+	}
+}
+
+// call invokes a ZAP API endpoint, such as "context/action/newContext", and
+// decodes the response into result if it is not nil
+func (p *OWASPZAPDASTPlugin) call(ctx context.Context, client *http.Client, endpoint string, params url.Values, result interface{}) error {
+	query := url.Values{"apikey": {p.apiKey}}
+	for k, v := range params {
+		query[k] = v
+	}
+	resp, err := get(ctx, client, fmt.Sprintf("%s/JSON/%s/?%s", p.zapURL, endpoint, query.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Message)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
@@ -16,8 +20,19 @@ type OWASPZAPDASTPlugin struct {
 	zapURL     string
 	apiKey     string
 	scanType   string // baseline, full, api
-	timeout    int
-	alertLevel string // High, Medium, Low
+	timeout    time.Duration
+	alertLevel string   // High, Medium, Low
+	include    []string // regexes of URLs to scan; the target URL and below if empty
+	exclude    []string // regexes of URLs to keep out of the scan, such as logout pages
+	auth       *zapAuth
+
+	// api scans
+	apiDefinition string // URL, or path relative to the workspace
+	apiFormat     string // openapi or graphql
+	scanPolicy    string // active scan policy, or "" for ZAP's default
+
+	reports   []string // report formats to attach as artifacts
+	reportDir string   // relative to the workspace
 }
 
 type ZAPAlert struct {
@@ -28,6 +43,8 @@ type ZAPAlert struct {
 	Description string `json:"description"`
 	Solution    string `json:"solution"`
 	CWE         string `json:"cweid"`
+	PluginID    string `json:"pluginId"`
+	Reference   string `json:"reference"`
 }
 
 func (p *OWASPZAPDASTPlugin) Name() string {
@@ -42,48 +59,138 @@ func (p *OWASPZAPDASTPlugin) Type() string {
 	return "security"
 }
 
+func (p *OWASPZAPDASTPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilitySecrets, sdk.CapabilityWorkspace}
+}
+
 func (p *OWASPZAPDASTPlugin) Initialize(config map[string]interface{}) error {
-	p.targetURL = getStringConfig(config, "target_url", "")
-	p.zapURL = getStringConfig(config, "zap_url", "http://localhost:8081")
-	p.apiKey = getStringConfig(config, "api_key", "ritmo-zap-api-key")
-	p.scanType = getStringConfig(config, "scan_type", "baseline")
-	p.timeout = getIntConfig(config, "timeout", 600)
-	p.alertLevel = getStringConfig(config, "alert_level", "High")
+	cfg := sdk.Config(config)
+	if err := cfg.Require("target_url"); err != nil {
+		return err
+	}
 
-	if p.targetURL == "" {
-		return fmt.Errorf("target_url is required for DAST scanning")
+	p.targetURL = cfg.String("target_url", "")
+	p.zapURL = cfg.String("zap_url", "http://localhost:8081")
+	p.apiKey = cfg.String("api_key", "ritmo-zap-api-key")
+	p.scanType = cfg.String("scan_type", "baseline")
+	p.timeout = cfg.Duration("timeout", 10*time.Minute)
+	p.alertLevel = cfg.String("alert_level", "High")
+	switch p.scanType {
+	case "baseline", "full":
+		p.scanPolicy = cfg.String("scan_policy", "")
+	case "api":
+		if err := cfg.Require("api_definition"); err != nil {
+			return err
+		}
+		p.apiDefinition = cfg.String("api_definition", "")
+		p.apiFormat = cfg.String("api_format", formatOpenAPI)
+		if p.apiFormat != formatOpenAPI && p.apiFormat != formatGraphQL {
+			return fmt.Errorf("invalid api_format %q (expected openapi or graphql)", p.apiFormat)
+		}
+		p.scanPolicy = cfg.String("scan_policy", "API-Minimal")
+	default:
+		return fmt.Errorf("invalid scan_type %q (expected baseline, full or api)", p.scanType)
+	}
+	p.reportDir = cfg.String("report_dir", "zap-report")
+	p.reports = cfg.StringSlice("reports")
+	if !cfg.Has("reports") {
+		p.reports = []string{reportHTML, reportSARIF}
+	}
+	for _, format := range p.reports {
+		if _, ok := reportFiles[format]; !ok {
+			return fmt.Errorf("invalid reports entry %q (expected html, json or sarif)", format)
+		}
 	}
 
+	p.include = cfg.StringSlice("include")
+	p.exclude = cfg.StringSlice("exclude")
+
+	auth, err := parseAuth(cfg.Map("auth"))
+	if err != nil {
+		return err
+	}
+	p.auth = auth
+
+	return nil
+}
+
+func (p *OWASPZAPDASTPlugin) Health() error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("%s/JSON/core/view/version/?apikey=%s", p.zapURL, url.QueryEscape(p.apiKey)))
+	if err != nil {
+		return fmt.Errorf("ZAP is not reachable at %s: %w", p.zapURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ZAP at %s returned %s (check api_key)", p.zapURL, resp.Status)
+	}
 	return nil
 }
 
 func (p *OWASPZAPDASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
 	ctx.Logger.Info(fmt.Sprintf("Starting OWASP ZAP DAST scan on: %s", p.targetURL))
 
-	client := &http.Client{Timeout: time.Duration(p.timeout) * time.Second}
+	client := &http.Client{Timeout: p.timeout}
 
-	// Start spider scan
-	ctx.Logger.Info("Starting ZAP spider scan...")
-	scanID, err := p.startSpiderScan(client)
-	if err != nil {
-		return &sdk.Result{
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("Failed to start spider scan: %v", err),
-		}, err
+	// Scans with authentication or a scope run in a ZAP context of their own
+	session := &zapSession{}
+	if p.auth != nil || len(p.include) > 0 || len(p.exclude) > 0 {
+		var err error
+		session, err = p.setupSession(ctx, client)
+		if session != nil {
+			defer p.teardownSession(client, session)
+		}
+		if err != nil {
+			return &sdk.Result{
+				Success:      false,
+				ErrorMessage: fmt.Sprintf("Failed to configure ZAP context: %v", err),
+			}, err
+		}
 	}
 
-	// Wait for spider to complete
-	if err := p.waitForScan(client, scanID, "spider"); err != nil {
-		return &sdk.Result{
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("Spider scan failed: %v", err),
-		}, err
-	}
+	// API scans find their pages in the API definition instead of crawling
+	var endpoints []apiEndpoint
+	if p.scanType == "api" {
+		ctx.Logger.Info(fmt.Sprintf("Importing %s definition %s...", p.apiFormat, p.apiDefinition))
+		if err := p.importDefinition(ctx, client, session); err != nil {
+			return &sdk.Result{
+				Success:      false,
+				ErrorMessage: fmt.Sprintf("Failed to import API definition: %v", err),
+			}, err
+		}
+		var err error
+		endpoints, err = p.apiEndpoints(ctx, client)
+		if err != nil {
+			return &sdk.Result{
+				Success:      false,
+				ErrorMessage: fmt.Sprintf("Failed to list API endpoints: %v", err),
+			}, err
+		}
+		ctx.Logger.Info(fmt.Sprintf("Imported %d API endpoints. Starting active scan...", len(endpoints)))
+	} else {
+		// Start spider scan
+		ctx.Logger.Info("Starting ZAP spider scan...")
+		scanID, err := p.startSpiderScan(ctx, client, session)
+		if err != nil {
+			return &sdk.Result{
+				Success:      false,
+				ErrorMessage: fmt.Sprintf("Failed to start spider scan: %v", err),
+			}, err
+		}
 
-	ctx.Logger.Info("Spider scan complete. Starting active scan...")
+		// Wait for spider to complete
+		if err := p.waitForScan(ctx, client, scanID, "spider"); err != nil {
+			return &sdk.Result{
+				Success:      false,
+				ErrorMessage: fmt.Sprintf("Spider scan failed: %v", err),
+			}, err
+		}
+
+		ctx.Logger.Info("Spider scan complete. Starting active scan...")
+	}
 
 	// Start active scan
-	activeScanID, err := p.startActiveScan(client)
+	activeScanID, err := p.startActiveScan(ctx, client, session)
 	if err != nil {
 		return &sdk.Result{
 			Success:      false,
@@ -92,7 +199,7 @@ func (p *OWASPZAPDASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, er
 	}
 
 	// Wait for active scan to complete
-	if err := p.waitForScan(client, activeScanID, "ascan"); err != nil {
+	if err := p.waitForScan(ctx, client, activeScanID, "ascan"); err != nil {
 		return &sdk.Result{
 			Success:      false,
 			ErrorMessage: fmt.Sprintf("Active scan failed: %v", err),
@@ -102,7 +209,7 @@ func (p *OWASPZAPDASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, er
 	ctx.Logger.Info("Active scan complete. Retrieving alerts...")
 
 	// Get alerts
-	alerts, err := p.getAlerts(client)
+	alerts, err := p.getAlerts(ctx, client)
 	if err != nil {
 		return &sdk.Result{
 			Success:      false,
@@ -114,19 +221,30 @@ func (p *OWASPZAPDASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, er
 	alertCounts := make(map[string]int)
 	highRiskAlerts := 0
 
+	var findings []sdk.Finding
+
 	for _, alert := range alerts {
 		alertCounts[alert.Risk]++
 		if alert.Risk == "High" {
 			highRiskAlerts++
 		}
+		findings = append(findings, alertFinding(alert))
+	}
+
+	// Reports are attached before the verdict, so a failed scan can be reviewed
+	artifacts, err := p.writeReports(ctx, client, alerts)
+	if err != nil {
+		ctx.Logger.Error(fmt.Sprintf("Failed to write reports: %v", err))
 	}
 
 	// Build result
 	result := &sdk.Result{
-		Success:  highRiskAlerts == 0,
-		ExitCode: 0,
-		Metadata: make(map[string]interface{}),
-		Output:   fmt.Sprintf("Found %d total alerts (%d high risk)", len(alerts), highRiskAlerts),
+		Artifacts: artifacts,
+		Success:   highRiskAlerts == 0,
+		ExitCode:  0,
+		Metadata:  make(map[string]interface{}),
+		Output:    fmt.Sprintf("Found %d total alerts (%d high risk)", len(alerts), highRiskAlerts),
+		Findings:  findings,
 	}
 
 	if highRiskAlerts > 0 {
@@ -137,62 +255,114 @@ func (p *OWASPZAPDASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, er
 	result.Metadata["total_alerts"] = len(alerts)
 	result.Metadata["alerts_by_risk"] = alertCounts
 	result.Metadata["high_risk_count"] = highRiskAlerts
+	written := []string{}
+	for _, artifact := range artifacts {
+		written = append(written, filepath.Join(p.reportDir, artifact.Name))
+	}
+	result.Metadata["report_files"] = written
+	if p.scanType == "api" {
+		coverage := apiCoverage(endpoints, alerts)
+		result.Metadata["api_coverage"] = coverage
+		result.Output += fmt.Sprintf("; reached %d of %d API endpoints", coverage["endpoints_reached"], coverage["endpoints_total"])
+		ctx.Logger.Info(fmt.Sprintf("API coverage: %d of %d endpoints reached, %d with alerts",
+			coverage["endpoints_reached"], coverage["endpoints_total"], coverage["endpoints_with_alerts"]))
+	}
 
 	ctx.Logger.Info(fmt.Sprintf("DAST scan complete. Total alerts: %d, High risk: %d", len(alerts), highRiskAlerts))
 
 	return result, nil
 }
 
-func (p *OWASPZAPDASTPlugin) startSpiderScan(client *http.Client) (string, error) {
-	zapURL := fmt.Sprintf("%s/JSON/spider/action/scan/?apikey=%s&url=%s", p.zapURL, p.apiKey, url.QueryEscape(p.targetURL))
+// alertFinding converts a ZAP alert to a finding. ZAP reports a CWE ID of 0
+// or -1 for alerts without one.
+func alertFinding(alert ZAPAlert) sdk.Finding {
+	f := sdk.Finding{
+		Tool:        "owasp-zap",
+		RuleID:      alert.PluginID,
+		Title:       alert.Alert,
+		Description: alert.Description,
+		Severity:    sdk.NormalizeSeverity(alert.Risk),
+		Location:    sdk.Location{URI: alert.URL},
+	}
+	if f.RuleID == "" {
+		f.RuleID = alert.Alert
+	}
+	if n, err := strconv.Atoi(alert.CWE); err == nil && n > 0 {
+		f.CWEs = []string{"CWE-" + alert.CWE}
+	}
+	if ref, _, _ := strings.Cut(strings.TrimSpace(alert.Reference), "\n"); strings.HasPrefix(ref, "http") {
+		f.HelpURL = ref
+	}
+	return f
+}
 
-	resp, err := client.Get(zapURL)
-	if err != nil {
-		return "", err
+// startSpiderScan starts crawling the target, as the session's user if it
+// has one
+func (p *OWASPZAPDASTPlugin) startSpiderScan(ctx context.Context, client *http.Client, session *zapSession) (string, error) {
+	endpoint := "spider/action/scan"
+	params := url.Values{"url": {p.targetURL}}
+	if session.userID != "" {
+		endpoint = "spider/action/scanAsUser"
+		params.Set("contextId", session.contextID)
+		params.Set("userId", session.userID)
+	} else if session.contextName != "" {
+		params.Set("contextName", session.contextName)
 	}
-	defer resp.Body.Close()
 
 	var result struct {
 		Scan string `json:"scan"`
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := p.call(ctx, client, endpoint, params, &result); err != nil {
 		return "", err
 	}
-
 	return result.Scan, nil
 }
 
-func (p *OWASPZAPDASTPlugin) startActiveScan(client *http.Client) (string, error) {
-	zapURL := fmt.Sprintf("%s/JSON/ascan/action/scan/?apikey=%s&url=%s", p.zapURL, p.apiKey, url.QueryEscape(p.targetURL))
-
-	resp, err := client.Get(zapURL)
-	if err != nil {
-		return "", err
+// startActiveScan starts attacking the pages the spider found, as the
+// session's user if it has one
+func (p *OWASPZAPDASTPlugin) startActiveScan(ctx *sdk.ExecutionContext, client *http.Client, session *zapSession) (string, error) {
+	endpoint := "ascan/action/scan"
+	params := url.Values{"url": {p.targetURL}, "recurse": {"true"}}
+	if session.userID != "" {
+		endpoint = "ascan/action/scanAsUser"
+		params.Set("contextId", session.contextID)
+		params.Set("userId", session.userID)
+	} else if session.contextID != "" {
+		params.Set("contextId", session.contextID)
 	}
-	defer resp.Body.Close()
 
 	var result struct {
 		Scan string `json:"scan"`
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if p.scanPolicy != "" {
+		policyParams := url.Values{"scanPolicyName": {p.scanPolicy}}
+		for k, v := range params {
+			policyParams[k] = v
+		}
+		err := p.call(ctx, client, endpoint, policyParams, &result)
+		if err == nil {
+			return result.Scan, nil
+		}
+		// Policies such as API-Minimal ship with ZAP's Docker images, not
+		// every installation
+		ctx.Logger.Warn(fmt.Sprintf("Scan policy %s is not available (%v), using the default policy", p.scanPolicy, err))
+	}
+	if err := p.call(ctx, client, endpoint, params, &result); err != nil {
 		return "", err
 	}
-
 	return result.Scan, nil
 }
 
-func (p *OWASPZAPDASTPlugin) waitForScan(client *http.Client, scanID, scanType string) error {
-	var statusURL string
+func (p *OWASPZAPDASTPlugin) waitForScan(ctx *sdk.ExecutionContext, client *http.Client, scanID, scanType string) error {
+	name := "Active"
 	if scanType == "spider" {
-		statusURL = fmt.Sprintf("%s/JSON/spider/view/status/?apikey=%s&scanId=%s", p.zapURL, p.apiKey, scanID)
-	} else {
-		statusURL = fmt.Sprintf("%s/JSON/ascan/view/status/?apikey=%s&scanId=%s", p.zapURL, p.apiKey, scanID)
+		name = "Spider"
 	}
+	statusURL := fmt.Sprintf("%s/JSON/%s/view/status/?apikey=%s&scanId=%s", p.zapURL, scanType, p.apiKey, scanID)
 
-	for i := 0; i < p.timeout/5; i++ {
-		resp, err := client.Get(statusURL)
+	lastStatus := ""
+	for i := 0; i < int(p.timeout/(5*time.Second)); i++ {
+		resp, err := get(ctx, client, statusURL)
 		if err != nil {
 			return err
 		}
@@ -210,17 +380,36 @@ func (p *OWASPZAPDASTPlugin) waitForScan(client *http.Client, scanID, scanType s
 		if result.Status == "100" {
 			return nil
 		}
+		if result.Status != lastStatus {
+			ctx.Logger.Info(fmt.Sprintf("%s scan %s%% complete", name, result.Status))
+			lastStatus = result.Status
+		}
 
-		time.Sleep(5 * time.Second)
+		select {
+		case <-ctx.Done():
+			p.stopScan(client, scanID, scanType)
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
 	}
 
 	return fmt.Errorf("scan timeout")
 }
 
-func (p *OWASPZAPDASTPlugin) getAlerts(client *http.Client) ([]ZAPAlert, error) {
+// stopScan stops a scan left running on the ZAP server when the build is cancelled
+func (p *OWASPZAPDASTPlugin) stopScan(client *http.Client, scanID, scanType string) {
+	stopURL := fmt.Sprintf("%s/JSON/%s/action/stop/?apikey=%s&scanId=%s", p.zapURL, scanType, p.apiKey, scanID)
+	resp, err := client.Get(stopURL)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func (p *OWASPZAPDASTPlugin) getAlerts(ctx context.Context, client *http.Client) ([]ZAPAlert, error) {
 	alertsURL := fmt.Sprintf("%s/JSON/core/view/alerts/?apikey=%s&baseurl=%s", p.zapURL, p.apiKey, url.QueryEscape(p.targetURL))
 
-	resp, err := client.Get(alertsURL)
+	resp, err := get(ctx, client, alertsURL)
 	if err != nil {
 		return nil, err
 	}
@@ -237,30 +426,22 @@ func (p *OWASPZAPDASTPlugin) getAlerts(client *http.Client) ([]ZAPAlert, error)
 	return result.Alerts, nil
 }
 
-func (p *OWASPZAPDASTPlugin) Cleanup() error {
-	return nil
-}
-
-// Helper functions
-func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
-	if val, ok := config[key].(string); ok {
-		return val
+// get sends a GET request that is abandoned when ctx is done
+func get(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
 	}
-	return defaultValue
+	return client.Do(req)
 }
 
-func getIntConfig(config map[string]interface{}, key string, defaultValue int) int {
-	if val, ok := config[key].(float64); ok {
-		return int(val)
-	}
-	return defaultValue
+func (p *OWASPZAPDASTPlugin) Cleanup() error {
+	return nil
 }
 
 // Export the plugin
 var Plugin OWASPZAPDASTPlugin
 
 func main() {
-	fmt.Println("OWASP ZAP DAST Plugin v1.0.0")
-	fmt.Println("This plugin performs dynamic application security testing using OWASP ZAP")
-	fmt.Println("To build: go build -o owasp-zap-dast")
+	sdk.Serve(&Plugin)
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// Report formats
+const (
+	reportHTML  = "html"
+	reportJSON  = "json"
+	reportSARIF = "sarif"
+)
+
+// reportFiles are the file names each report format is written to
+var reportFiles = map[string]string{
+	reportHTML:  "zap-report.html",
+	reportJSON:  "zap-report.json",
+	reportSARIF: "zap-report.sarif",
+}
+
+// writeReports writes the requested reports to the report directory and
+// returns them as artifacts. The HTML and JSON reports come from ZAP and
+// cover every site in its session; the SARIF report is built from the scan's
+// alerts.
+func (p *OWASPZAPDASTPlugin) writeReports(ctx *sdk.ExecutionContext, client *http.Client, alerts []ZAPAlert) ([]sdk.Artifact, error) {
+	dir := filepath.Join(ctx.WorkDir, p.reportDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	artifacts := []sdk.Artifact{}
+	for _, format := range p.reports {
+		path := filepath.Join(dir, reportFiles[format])
+		var err error
+		switch format {
+		case reportHTML:
+			err = p.downloadReport(ctx, client, "core/other/htmlreport", path)
+		case reportJSON:
+			err = p.downloadReport(ctx, client, "core/other/jsonreport", path)
+		case reportSARIF:
+			err = p.writeSARIF(alerts, path)
+		}
+		if err != nil {
+			return artifacts, fmt.Errorf("failed to write %s report: %w", format, err)
+		}
+		artifact, err := newArtifact(path, format)
+		if err != nil {
+			return artifacts, err
+		}
+		artifacts = append(artifacts, artifact)
+		ctx.Logger.Info(fmt.Sprintf("Report written: %s", filepath.Join(p.reportDir, reportFiles[format])))
+	}
+	return artifacts, nil
+}
+
+// downloadReport saves a report from one of ZAP's OTHER endpoints, which
+// return the report itself rather than JSON
+func (p *OWASPZAPDASTPlugin) downloadReport(ctx context.Context, client *http.Client, endpoint, path string) error {
+	resp, err := get(ctx, client, fmt.Sprintf("%s/OTHER/%s/?apikey=%s", p.zapURL, endpoint, url.QueryEscape(p.apiKey)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ZAP returned %s", resp.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeSARIF writes the alerts as a SARIF 2.1.0 log, for code scanning UIs
+// and the findings ingestion of other tools
+func (p *OWASPZAPDASTPlugin) writeSARIF(alerts []ZAPAlert, path string) error {
+	rules := []map[string]interface{}{}
+	ruleIndex := map[string]int{}
+	results := []map[string]interface{}{}
+
+	for _, alert := range alerts {
+		f := alertFinding(alert)
+		index, ok := ruleIndex[f.RuleID]
+		if !ok {
+			rule := map[string]interface{}{
+				"id":               f.RuleID,
+				"name":             alert.Alert,
+				"shortDescription": map[string]string{"text": alert.Alert},
+				"fullDescription":  map[string]string{"text": alert.Description},
+				"help":             map[string]string{"text": alert.Solution},
+				"properties": map[string]interface{}{
+					"tags":              append([]string{"security"}, f.CWEs...),
+					"security-severity": securitySeverity(f.Severity),
+				},
+			}
+			if f.HelpURL != "" {
+				rule["helpUri"] = f.HelpURL
+			}
+			index = len(rules)
+			ruleIndex[f.RuleID] = index
+			rules = append(rules, rule)
+		}
+
+		results = append(results, map[string]interface{}{
+			"ruleId":    f.RuleID,
+			"ruleIndex": index,
+			"level":     sarifLevel(f.Severity),
+			"message":   map[string]string{"text": fmt.Sprintf("%s (confidence: %s)", alert.Alert, alert.Confidence)},
+			"locations": []map[string]interface{}{{
+				"physicalLocation": map[string]interface{}{
+					"artifactLocation": map[string]string{"uri": alert.URL},
+				},
+			}},
+		})
+	}
+
+	log := map[string]interface{}{
+		"version": "2.1.0",
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "OWASP ZAP",
+					"informationUri": "https://www.zaproxy.org/",
+					"rules":          rules,
+				},
+			},
+			"results": results,
+		}},
+	}
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// sarifLevel maps a finding severity to a SARIF result level
+func sarifLevel(severity string) string {
+	switch severity {
+	case sdk.SeverityCritical, sdk.SeverityHigh:
+		return "error"
+	case sdk.SeverityMedium:
+		return "warning"
+	}
+	return "note"
+}
+
+// securitySeverity is the CVSS-like score code scanning UIs rank rules by
+func securitySeverity(severity string) string {
+	scores := map[string]float64{
+		sdk.SeverityCritical: 9.5,
+		sdk.SeverityHigh:     8.0,
+		sdk.SeverityMedium:   5.5,
+		sdk.SeverityLow:      2.0,
+	}
+	return strconv.FormatFloat(scores[severity], 'f', 1, 64)
+}
+
+func newArtifact(path, format string) (sdk.Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sdk.Artifact{}, err
+	}
+	return sdk.Artifact{
+		Name:           filepath.Base(path),
+		Path:           path,
+		SizeBytes:      info.Size(),
+		ChecksumSHA256: hex.EncodeToString(h.Sum(nil)),
+		Metadata:       map[string]string{"kind": "report", "format": format},
+	}, nil
+}
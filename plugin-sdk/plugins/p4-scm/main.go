@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// P4SCMPlugin implements SCM plugin for Perforce Helix Core
+type P4SCMPlugin struct {
+	port        string
+	user        string
+	credentials string // name of the secret holding the password or a ticket
+	client      string // client workspace name, derived from the host and workspace if empty
+	stream      string
+	view        []string
+	charset     string
+	fingerprint string // trusted SSL fingerprint of the server
+	parallel    int    // threads for parallel sync, 0 to sync serially
+	clean       bool   // restore files a previous build changed in a reused workspace
+	force       bool   // sync -f, rewriting every file
+	last        *workspace
+}
+
+// clientName restricts client names to characters Perforce accepts everywhere
+var clientName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (p *P4SCMPlugin) Name() string {
+	return "p4-scm"
+}
+
+func (p *P4SCMPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *P4SCMPlugin) Type() string {
+	return "scm"
+}
+
+func (p *P4SCMPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace, sdk.CapabilitySecrets}
+}
+
+func (p *P4SCMPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	p.port = cfg.String("port", "")
+	p.user = cfg.String("user", "")
+	p.credentials = cfg.String("credentials", "")
+	p.client = cfg.String("client", "")
+	p.stream = cfg.String("stream", "")
+	p.view = cfg.StringSlice("view")
+	p.charset = cfg.String("charset", "")
+	p.fingerprint = cfg.String("fingerprint", "")
+	p.parallel = cfg.Int("parallel", 0)
+	p.clean = cfg.Bool("clean", true)
+	p.force = cfg.Bool("force", false)
+
+	if p.client != "" && !clientName.MatchString(p.client) {
+		return fmt.Errorf("invalid client name %q", p.client)
+	}
+	if p.stream != "" && len(p.view) > 0 {
+		return fmt.Errorf("stream and view are mutually exclusive")
+	}
+	for _, line := range p.view {
+		if !strings.HasPrefix(strings.TrimLeft(strings.TrimSpace(line), "-+"), "//") {
+			return fmt.Errorf("invalid view line %q: views map depot paths such as //depot/main/...", line)
+		}
+	}
+	if p.parallel < 0 {
+		return fmt.Errorf("parallel must not be negative")
+	}
+	return nil
+}
+
+func (p *P4SCMPlugin) Health() error {
+	if _, err := exec.LookPath("p4"); err != nil {
+		return fmt.Errorf("p4 is not installed: %w", err)
+	}
+	return nil
+}
+
+// Execute syncs the client workspace in the build's workspace. The client is
+// created or updated to the configured stream or view, so a build of another
+// stream switches it, and a reused workspace is only synced with what
+// changed. The changelist is the build's commit_sha or changelist parameter,
+// or the latest change submitted to the client's view.
+// The result holds the change as a commit, with the files submitted since the
+// previous build's changelist.
+func (p *P4SCMPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	params := sdk.Config(ctx.Parameters)
+	w, err := p.workspace(ctx, params)
+	if err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+	w.p4.out = ctx.Output
+
+	if p.credentials != "" {
+		if w.p4.password, err = ctx.Secret(p.credentials); err != nil {
+			return &sdk.Result{Success: false, ErrorMessage: fmt.Sprintf("Failed to get credentials: %v", err)}, err
+		}
+	}
+	if err := p.connect(ctx, w.p4); err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+
+	target := "view"
+	if w.stream != "" {
+		target = "stream " + w.stream
+	}
+	ctx.Logger.Info(fmt.Sprintf("Syncing client %s (%s) from %s", w.p4.client, target, w.p4.port))
+
+	changelist, err := p.sync(ctx, w)
+	if err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+	p.last = w
+	ctx.Logger.Info(fmt.Sprintf("Synced %s at change %s", target, changelist))
+
+	commit, err := p.commitInfo(ctx, w, changelist, params.String("previous_commit_sha", ""))
+	if err != nil {
+		return &sdk.Result{Success: false, ErrorMessage: err.Error()}, err
+	}
+	ctx.Logger.Info(fmt.Sprintf("%d files changed", len(commit.ChangedFiles)))
+
+	return &sdk.Result{
+		Success:  true,
+		ExitCode: 0,
+		Output:   fmt.Sprintf("Synced %s (%s) at change %s", w.p4.port, target, changelist),
+		Metadata: map[string]interface{}{
+			"changelist": changelist,
+			"client":     w.p4.client,
+			"stream":     w.stream,
+		},
+		Commit: commit,
+	}, nil
+}
+
+// workspace reads what to sync from the config and the build's parameters.
+// A stream parameter, or a branch that is a depot path, switches the stream.
+func (p *P4SCMPlugin) workspace(ctx *sdk.ExecutionContext, params sdk.Config) (*workspace, error) {
+	port := p.port
+	if port == "" {
+		port = params.String("scm_url", "")
+	}
+	if port == "" {
+		return nil, fmt.Errorf("no Perforce server: set port in the config or the job's SCM URL")
+	}
+
+	w := &workspace{
+		p4: &p4{
+			port:    port,
+			user:    p.user,
+			client:  p.client,
+			charset: p.charset,
+			dir:     ctx.WorkDir,
+		},
+		stream:     p.stream,
+		view:       p.view,
+		changelist: params.String("changelist", params.String("commit_sha", "")),
+	}
+	if branch := params.String("branch", ""); strings.HasPrefix(branch, "//") {
+		w.stream = branch
+	}
+	w.stream = params.String("stream", w.stream)
+	if w.stream == "" && len(w.view) == 0 {
+		return nil, fmt.Errorf("no stream or view to sync")
+	}
+	if w.stream != "" {
+		w.view = nil
+	}
+	w.changelist = strings.TrimPrefix(w.changelist, "@")
+	if w.changelist != "" {
+		if _, err := strconv.Atoi(w.changelist); err != nil {
+			return nil, fmt.Errorf("invalid changelist %q", w.changelist)
+		}
+	}
+	if w.p4.client == "" {
+		w.p4.client = defaultClient(ctx.WorkDir)
+	}
+	return w, nil
+}
+
+// connect trusts the server's fingerprint and logs in, if configured to
+func (p *P4SCMPlugin) connect(ctx context.Context, c *p4) error {
+	if p.fingerprint != "" {
+		if _, err := c.run(ctx, nil, "trust", "-i", p.fingerprint); err != nil {
+			return err
+		}
+	}
+	if c.password == "" {
+		return nil
+	}
+	if err := c.login(ctx); err != nil {
+		// The secret may hold a ticket already
+		c.ticket = c.password
+		if _, terr := c.run(ctx, nil, "login", "-s"); terr != nil {
+			c.ticket = ""
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultClient names the client after the worker's host and the workspace,
+// so each workspace has its own client and a reused one keeps it
+func defaultClient(dir string) string {
+	host, _ := os.Hostname()
+	host = strings.Map(func(r rune) rune {
+		if strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_.-", r) {
+			return r
+		}
+		return '-'
+	}, host)
+	sum := sha256.Sum256([]byte(dir))
+	return "solvyd-" + host + "-" + hex.EncodeToString(sum[:6])
+}
+
+// Clone syncs dest to the changelist commitSHA, or the latest change, with
+// the server at url. A branch that is a depot path is the stream to sync.
+func (p *P4SCMPlugin) Clone(ctx context.Context, url, branch, commitSHA, dest string) error {
+	client := p.client
+	if client == "" {
+		client = defaultClient(dest)
+	}
+	w := &workspace{
+		p4:         &p4{port: url, user: p.user, client: client, charset: p.charset, dir: dest},
+		stream:     p.stream,
+		view:       p.view,
+		changelist: commitSHA,
+	}
+	if strings.HasPrefix(branch, "//") {
+		w.stream, w.view = branch, nil
+	}
+	if err := p.connect(ctx, w.p4); err != nil {
+		return err
+	}
+	if _, err := p.sync(ctx, w); err != nil {
+		return err
+	}
+	p.last = w
+	return nil
+}
+
+// GetCommitInfo returns the changelist commitSHA of the last synced client
+// as a commit, with the files it changes
+func (p *P4SCMPlugin) GetCommitInfo(ctx context.Context, commitSHA string) (*sdk.CommitInfo, error) {
+	if p.last == nil {
+		return nil, fmt.Errorf("no client synced")
+	}
+	return p.commitInfo(ctx, p.last, commitSHA, "")
+}
+
+func (p *P4SCMPlugin) Cleanup() error {
+	return nil
+}
+
+// Serve the plugin to the worker agent
+func main() {
+	sdk.Serve(&P4SCMPlugin{})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// p4 runs the p4 command line client against one server and client workspace
+type p4 struct {
+	port     string
+	user     string
+	client   string
+	ticket   string // from p4 login -p, passed as P4PASSWD
+	charset  string
+	dir      string
+	out      io.Writer // receives standard error
+	password string    // only used to log in
+}
+
+// run runs p4 with the global options and returns its standard output. input,
+// if not nil, is its standard input.
+func (c *p4) run(ctx context.Context, input []byte, args ...string) (string, error) {
+	global := []string{"-p", c.port}
+	if c.user != "" {
+		global = append(global, "-u", c.user)
+	}
+	if c.client != "" {
+		global = append(global, "-c", c.client)
+	}
+	if c.charset != "" {
+		global = append(global, "-C", c.charset)
+	}
+
+	cmd := exec.CommandContext(ctx, "p4", append(global, args...)...)
+	cmd.Dir = c.dir
+	// P4CONFIG and P4ENVIRO are unset so nothing on the worker overrides
+	// the configured server and user
+	cmd.Env = append(os.Environ(), "P4CONFIG=", "P4ENVIRO=/dev/null")
+	if c.ticket != "" {
+		cmd.Env = append(cmd.Env, "P4PASSWD="+c.ticket)
+	}
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if c.out != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, c.out)
+	}
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		if msg == "" {
+			return "", fmt.Errorf("p4 %s failed: %w", command(args), err)
+		}
+		return "", fmt.Errorf("p4 %s failed: %w: %s", command(args), err, lastLine(msg))
+	}
+	return stdout.String(), nil
+}
+
+// tagged runs p4 with tagged JSON output (-ztag -Mj), one record per line,
+// and returns its records. Errors reported as records fail the command.
+func (c *p4) tagged(ctx context.Context, input []byte, args ...string) ([]map[string]string, error) {
+	out, err := c.run(ctx, input, append([]string{"-ztag", "-Mj"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	records, err := parseTagged(out)
+	if err != nil {
+		return nil, fmt.Errorf("p4 %s: %w", command(args), err)
+	}
+	return records, nil
+}
+
+// login exchanges the password for a ticket, which is used for the rest of
+// the checkout without being stored on the worker
+func (c *p4) login(ctx context.Context) error {
+	out, err := c.run(ctx, []byte(c.password+"\n"), "login", "-p")
+	if err != nil {
+		return err
+	}
+	// The ticket is the last line; an earlier one prompts for the password
+	c.ticket = strings.TrimSpace(lastLine(strings.TrimSpace(out)))
+	if c.ticket == "" {
+		return fmt.Errorf("p4 login returned no ticket")
+	}
+	return nil
+}
+
+// parseTagged reads the records of -ztag -Mj output
+func parseTagged(out string) ([]map[string]string, error) {
+	var records []map[string]string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var raw map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("invalid output: %w", err)
+		}
+		record := make(map[string]string, len(raw))
+		for k, v := range raw {
+			record[k] = fmt.Sprint(v)
+		}
+		if record["code"] == "error" {
+			return nil, fmt.Errorf("%s", strings.TrimSpace(record["data"]))
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// clientSpec returns the form p4 client -i reads. A stream client's view
+// comes from the stream. Each line of view is a depot path, mapped to the same
+// path under the client root, or a depot path and a path relative to the root.
+// Depot paths starting with - are excluded.
+func clientSpec(name, owner, root, stream string, view []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Client: %s\n", name)
+	if owner != "" {
+		fmt.Fprintf(&b, "Owner: %s\n", owner)
+	}
+	fmt.Fprintf(&b, "Root: %s\n", root)
+	b.WriteString("Options: allwrite clobber nocompress unlocked nomodtime rmdir\n")
+	b.WriteString("SubmitOptions: revertunchanged\n")
+	b.WriteString("LineEnd: local\n")
+	if stream != "" {
+		fmt.Fprintf(&b, "Stream: %s\n", stream)
+		return b.String()
+	}
+	b.WriteString("View:\n")
+	for _, line := range view {
+		depotPath, clientPath, _ := strings.Cut(strings.TrimSpace(line), " ")
+		clientPath = strings.TrimSpace(clientPath)
+		if clientPath == "" {
+			clientPath = strings.TrimPrefix(strings.TrimLeft(depotPath, "-+"), "//")
+		}
+		fmt.Fprintf(&b, "\t\"%s\" \"//%s/%s\"\n", depotPath, name, strings.TrimPrefix(clientPath, "/"))
+	}
+	return b.String()
+}
+
+// command returns the p4 command of args, after any global options
+func command(args []string) string {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") && (i == 0 || args[i-1] != "-x") {
+			return arg
+		}
+	}
+	return "command"
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
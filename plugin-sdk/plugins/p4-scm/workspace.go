@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// p4ConfigFile is written to the workspace root so later steps can run p4
+// against the client, and marks a workspace the plugin has synced before
+const p4ConfigFile = ".p4config"
+
+// workspace is what to sync and where
+type workspace struct {
+	p4         *p4
+	stream     string   // the stream of a stream client
+	view       []string // the view of a classic client
+	changelist string   // synced instead of the latest submitted change
+}
+
+// root is the client's path for all of its files
+func (w *workspace) root() string {
+	return "//" + w.p4.client + "/..."
+}
+
+// sync creates or updates the client and syncs it to the changelist, or to
+// the latest change submitted to its view, and returns the changelist
+func (p *P4SCMPlugin) sync(ctx context.Context, w *workspace) (string, error) {
+	c := w.p4
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", err
+	}
+	_, err := os.Stat(filepath.Join(c.dir, p4ConfigFile))
+	reused := err == nil
+
+	// Files left open by a previous build would keep the client from
+	// switching streams
+	if reused {
+		if _, err := c.run(ctx, nil, "-q", "revert", "-k", w.root()); err != nil {
+			return "", err
+		}
+	}
+
+	spec := clientSpec(c.client, c.user, c.dir, w.stream, w.view)
+	if _, err := c.run(ctx, []byte(spec), "client", "-i"); err != nil {
+		return "", err
+	}
+
+	if !reused {
+		// The client may exist already with files the server believes are
+		// here; clear its have list so everything is synced
+		if _, err := c.run(ctx, nil, "-q", "flush", w.root()+"#none"); err != nil {
+			return "", err
+		}
+	} else if p.clean {
+		if _, err := c.run(ctx, nil, "-q", "clean", w.root()); err != nil {
+			return "", err
+		}
+	}
+
+	changelist := w.changelist
+	if changelist == "" {
+		changes, err := c.tagged(ctx, nil, "changes", "-m1", "-s", "submitted", w.root())
+		if err != nil {
+			return "", err
+		}
+		if len(changes) == 0 || changes[0]["change"] == "" {
+			return "", fmt.Errorf("no submitted changes in the client's view")
+		}
+		changelist = changes[0]["change"]
+	}
+
+	args := []string{"-q", "sync"}
+	if p.force {
+		args = append(args, "-f")
+	}
+	if p.parallel > 0 {
+		args = append(args, "--parallel=threads="+strconv.Itoa(p.parallel))
+	}
+	if _, err := c.run(ctx, nil, append(args, w.root()+"@"+changelist)...); err != nil {
+		return "", err
+	}
+
+	config := fmt.Sprintf("P4PORT=%s\nP4USER=%s\nP4CLIENT=%s\n", c.port, c.user, c.client)
+	if c.charset != "" {
+		config += "P4CHARSET=" + c.charset + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, p4ConfigFile), []byte(config), 0644); err != nil {
+		return "", err
+	}
+	return changelist, nil
+}
+
+// commitInfo maps a submitted changelist to a commit: its number is the SHA
+// and its description the message. Changed files are the workspace paths of
+// the files in the client's view submitted after previous up to the
+// changelist, or in the changelist alone without a previous one.
+func (p *P4SCMPlugin) commitInfo(ctx context.Context, w *workspace, changelist, previous string) (*sdk.CommitInfo, error) {
+	c := w.p4
+	described, err := c.tagged(ctx, nil, "describe", "-s", changelist)
+	if err != nil {
+		return nil, err
+	}
+	if len(described) == 0 {
+		return nil, fmt.Errorf("changelist %s not found", changelist)
+	}
+	change := described[0]
+	info := &sdk.CommitInfo{
+		SHA:     change["change"],
+		Message: strings.TrimSpace(change["desc"]),
+		Author:  change["user"],
+	}
+	if secs, err := strconv.ParseInt(change["time"], 10, 64); err == nil {
+		info.Timestamp = time.Unix(secs, 0).UTC().Format(time.RFC3339)
+	}
+	if users, err := c.tagged(ctx, nil, "users", info.Author); err == nil && len(users) > 0 {
+		info.Email = users[0]["Email"]
+	}
+
+	from := changelist
+	if n, err := strconv.Atoi(previous); err == nil {
+		if cl, _ := strconv.Atoi(changelist); n < cl {
+			from = strconv.Itoa(n + 1)
+		}
+	}
+	files, err := c.tagged(ctx, nil, "files", w.root()+"@"+from+",@"+changelist)
+	if err != nil {
+		return nil, err
+	}
+
+	info.ChangedFiles = []string{}
+	if len(files) == 0 {
+		return info, nil
+	}
+	var depotFiles strings.Builder
+	for _, f := range files {
+		depotFiles.WriteString(f["depotFile"] + "\n")
+	}
+	where, err := c.tagged(ctx, []byte(depotFiles.String()), "-x", "-", "where")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range where {
+		if f["path"] == "" || f["unmap"] != "" {
+			continue
+		}
+		rel, err := filepath.Rel(c.dir, f["path"])
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		info.ChangedFiles = append(info.ChangedFiles, filepath.ToSlash(rel))
+	}
+	return info, nil
+}
@@ -1,20 +1,50 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
 )
 
-// SlackNotifyPlugin implements notification plugin for Slack
+// SlackNotifyPlugin posts a Block Kit summary of the build to Slack, with a
+// line for each plugin step before it. With a bot token it posts through
+// chat.postMessage, and later steps of the same build update that message
+// instead of posting a new one, replying in its thread with what changed.
+// With only a webhook URL every step posts a new message. Run for an
+// operational alert, it posts the alert to the default channel.
+//
+//	plugins:
+//	  - name: slack-notify
+//	    always_run: true
+//	    secrets: {SLACK_BOT_TOKEN: "vault:secret/data/ci/slack#bot_token"}
+//	    config:
+//	      bot_token_secret: SLACK_BOT_TOKEN
+//	      channel: "#builds"
+//	      routes:
+//	        - labels: {team: payments}
+//	          channel: "#payments-ci"
 type SlackNotifyPlugin struct {
-	webhookURL string
-	channel    string
-	username   string
+	webhookURL     string
+	botTokenSecret string // name of the secret holding the bot token
+	apiURL         string // Slack Web API base URL
+	channel        string
+	username       string
+	routes         []route
+	threadReplies  bool
+
+	token  string // resolved from botTokenSecret during Execute
+	client *http.Client
+}
+
+// route sends builds of jobs with all of the labels to a channel
+type route struct {
+	labels  map[string]string
+	channel string
 }
 
 func (p *SlackNotifyPlugin) Name() string {
@@ -22,133 +52,191 @@ func (p *SlackNotifyPlugin) Name() string {
 }
 
 func (p *SlackNotifyPlugin) Version() string {
-	return "1.0.0"
+	return "1.1.0"
 }
 
 func (p *SlackNotifyPlugin) Type() string {
 	return "notification"
 }
 
+func (p *SlackNotifyPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilitySecrets}
+}
+
 func (p *SlackNotifyPlugin) Initialize(config map[string]interface{}) error {
-	if url, ok := config["webhook_url"].(string); ok {
-		p.webhookURL = url
-	} else {
-		return fmt.Errorf("webhook_url is required")
+	cfg := sdk.Config(config)
+	p.webhookURL = cfg.String("webhook_url", "")
+	p.botTokenSecret = cfg.String("bot_token_secret", "")
+	if p.webhookURL == "" && p.botTokenSecret == "" {
+		return fmt.Errorf("webhook_url or bot_token_secret is required")
 	}
 
-	if channel, ok := config["channel"].(string); ok {
-		p.channel = channel
+	p.apiURL = strings.TrimSuffix(cfg.String("api_url", "https://slack.com/api"), "/")
+	p.channel = cfg.String("channel", "")
+	p.username = cfg.String("username", "Ritmo CI")
+	p.threadReplies = cfg.Bool("thread_replies", true)
+
+	p.routes = nil
+	for i, r := range cfg.Maps("routes") {
+		rt := route{labels: r.StringMap("labels"), channel: r.String("channel", "")}
+		if rt.channel == "" {
+			return fmt.Errorf("routes[%d]: channel is required", i)
+		}
+		p.routes = append(p.routes, rt)
 	}
-
-	if username, ok := config["username"].(string); ok {
-		p.username = username
-	} else {
-		p.username = "Ritmo CI"
+	if p.botTokenSecret != "" && p.channel == "" && len(p.routes) == 0 {
+		return fmt.Errorf("channel or routes is required with bot_token_secret")
 	}
 
+	p.client = &http.Client{Timeout: 30 * time.Second}
+	return nil
+}
+
+func (p *SlackNotifyPlugin) Health() error {
+	for _, raw := range []string{p.webhookURL, p.apiURL} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%s must be an https URL", raw)
+		}
+	}
 	return nil
 }
 
 func (p *SlackNotifyPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
-	// Build notification message from context
-	message := &sdk.NotificationMessage{
-		Title:   fmt.Sprintf("Build %s", ctx.Parameters["status"]),
-		Body:    fmt.Sprintf("Job: %s", ctx.Parameters["job_name"]),
-		Level:   ctx.Parameters["level"].(string),
-		BuildID: ctx.BuildID,
+	if p.botTokenSecret != "" {
+		token, err := ctx.Secret(p.botTokenSecret)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to get bot token: %v", err)), err
+		}
+		p.token = token
 	}
+	if alert := ctx.Alert(); alert != nil {
+		return p.notifyAlert(ctx, alert)
+	}
+
+	build := newBuildSummary(ctx)
+	channel := p.routeChannel(sdk.Config(ctx.Parameters).StringMap("job_labels"))
 
-	if err := p.Notify(message); err != nil {
-		return &sdk.Result{
-			Success:      false,
-			ErrorMessage: err.Error(),
-		}, err
+	metadata := map[string]interface{}{"status": build.status, "channel": channel}
+	if p.token == "" {
+		if err := p.postWebhook(ctx, channel, build.blocks(), build.text()); err != nil {
+			return failure(err.Error()), err
+		}
+		ctx.Logger.Info(fmt.Sprintf("Posted build %s to Slack", build.status))
+		return &sdk.Result{Success: true, Output: "Slack notification sent successfully", Metadata: metadata}, nil
 	}
 
+	// Update the message an earlier step of this build posted to the channel
+	if prev := build.previousMessage(channel); prev != nil {
+		if err := p.updateMessage(ctx, prev.channelID, prev.ts, build.blocks(), build.text()); err != nil {
+			return failure(err.Error()), err
+		}
+		if p.threadReplies && build.status != prev.status {
+			if _, _, err := p.postMessage(ctx, prev.channelID, prev.ts, nil, build.change()); err != nil {
+				ctx.Logger.Warn(fmt.Sprintf("Failed to reply in the thread: %v", err))
+			}
+		}
+		metadata["slack_channel_id"], metadata["slack_ts"] = prev.channelID, prev.ts
+		ctx.Logger.Info(fmt.Sprintf("Updated the Slack message in %s: build %s", channel, build.status))
+		return &sdk.Result{Success: true, Output: "Slack message updated", Metadata: metadata}, nil
+	}
+
+	channelID, ts, err := p.postMessage(ctx, channel, "", build.blocks(), build.text())
+	if err != nil {
+		return failure(err.Error()), err
+	}
+	metadata["slack_channel_id"], metadata["slack_ts"] = channelID, ts
+	ctx.Logger.Info(fmt.Sprintf("Posted build %s to Slack in %s", build.status, channel))
+	return &sdk.Result{Success: true, Output: "Slack notification sent successfully", Metadata: metadata}, nil
+}
+
+// notifyAlert posts an alert that fired or resolved to the default channel
+func (p *SlackNotifyPlugin) notifyAlert(ctx *sdk.ExecutionContext, alert *sdk.Alert) (*sdk.Result, error) {
+	msg := &sdk.NotificationMessage{
+		Title:  ":rotating_light: Alert: " + strings.ReplaceAll(alert.Kind, "_", " "),
+		Body:   alert.Message,
+		Level:  "error",
+		Status: alert.Event,
+	}
+	if alert.Event == sdk.AlertResolved {
+		msg.Title = ":white_check_mark: Resolved: " + strings.ReplaceAll(alert.Kind, "_", " ")
+		msg.Level = "success"
+	}
+	if err := p.Notify(ctx, msg); err != nil {
+		return failure(err.Error()), err
+	}
+	ctx.Logger.Info(fmt.Sprintf("Posted alert %s (%s) to Slack", alert.Kind, alert.Event))
 	return &sdk.Result{
 		Success:  true,
-		ExitCode: 0,
 		Output:   "Slack notification sent successfully",
+		Metadata: map[string]interface{}{"alert_id": alert.ID, "event": alert.Event, "channel": p.channel},
 	}, nil
 }
 
-func (p *SlackNotifyPlugin) Notify(msg *sdk.NotificationMessage) error {
-	color := p.getColor(msg.Level)
-
-	payload := map[string]interface{}{
-		"username": p.username,
-		"attachments": []map[string]interface{}{
-			{
-				"color":  color,
-				"title":  msg.Title,
-				"text":   msg.Body,
-				"footer": "Ritmo CI",
-				"ts":     time.Now().Unix(),
-				"fields": []map[string]interface{}{
-					{
-						"title": "Build ID",
-						"value": msg.BuildID,
-						"short": true,
-					},
-					{
-						"title": "Status",
-						"value": msg.Status,
-						"short": true,
-					},
-				},
-			},
-		},
-	}
-
-	if p.channel != "" {
-		payload["channel"] = p.channel
+// routeChannel returns the channel of the first route whose labels the job
+// has, or the default channel
+func (p *SlackNotifyPlugin) routeChannel(labels map[string]string) string {
+	for _, r := range p.routes {
+		matched := true
+		for k, v := range r.labels {
+			if labels[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return r.channel
+		}
 	}
+	return p.channel
+}
 
-	if msg.URL != "" {
-		payload["attachments"].([]map[string]interface{})[0]["title_link"] = msg.URL
+// Notify posts a message to the default channel
+func (p *SlackNotifyPlugin) Notify(ctx context.Context, msg *sdk.NotificationMessage) error {
+	blocks := []map[string]interface{}{
+		headerBlock(msg.Title),
+		textSection(msg.Body),
 	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return err
+	var fields []string
+	if msg.Status != "" {
+		fields = append(fields, fmt.Sprintf("*Status*\n%s", msg.Status))
 	}
-
-	resp, err := http.Post(p.webhookURL, "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		return err
+	if msg.BuildID != "" {
+		fields = append(fields, fmt.Sprintf("*Build ID*\n%s", msg.BuildID))
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("slack notification failed with status %d", resp.StatusCode)
+	if len(fields) > 0 {
+		blocks = append(blocks, fieldsSection(fields))
+	}
+	if msg.URL != "" {
+		blocks = append(blocks, textSection(fmt.Sprintf("<%s|View build>", msg.URL)))
 	}
 
-	return nil
-}
-
-func (p *SlackNotifyPlugin) getColor(level string) string {
-	switch level {
-	case "success":
-		return "good"
-	case "warning":
-		return "warning"
-	case "error":
-		return "danger"
-	default:
-		return "#36a64f"
+	text := msg.Title
+	if msg.Body != "" {
+		text += ": " + msg.Body
+	}
+	if p.token == "" {
+		return p.postWebhook(ctx, p.channel, blocks, text)
 	}
+	_, _, err := p.postMessage(ctx, p.channel, "", blocks, text)
+	return err
 }
 
 func (p *SlackNotifyPlugin) Cleanup() error {
 	return nil
 }
 
+func failure(message string) *sdk.Result {
+	return &sdk.Result{Success: false, ErrorMessage: message}
+}
+
 // Export the plugin
 var Plugin SlackNotifyPlugin
 
-// Main function for standalone testing
+// Serve the plugin to the worker agent
 func main() {
-	fmt.Println("Slack Notification Plugin v1.0.0")
-	fmt.Println("This is a plugin and should be loaded by the Ritmo system")
-	fmt.Println("To build as a plugin: go build -buildmode=plugin -o slack-notify.so")
+	sdk.Serve(&Plugin)
 }
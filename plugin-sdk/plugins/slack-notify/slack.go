@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// maxSectionText is the most characters Slack accepts in a section block
+const maxSectionText = 3000
+
+// Build statuses shown in messages
+const (
+	statusStarted = "started" // no step ran before this one
+	statusPassed  = "passed"  // every step so far passed
+	statusFailed  = "failed"
+)
+
+// buildSummary is the state of the build when the step runs, from the
+// parameters the worker agent passes
+type buildSummary struct {
+	jobName     string
+	buildNumber int
+	buildID     string
+	branch      string
+	commit      string
+	status      string
+	steps       []stepSummary
+}
+
+type stepSummary struct {
+	name         string
+	status       string // success, failed or skipped
+	errorMessage string
+	duration     time.Duration
+	metadata     sdk.Config
+}
+
+// slackMessage is a message an earlier step of the build posted
+type slackMessage struct {
+	channelID string
+	ts        string
+	status    string
+}
+
+func newBuildSummary(ctx *sdk.ExecutionContext) *buildSummary {
+	params := sdk.Config(ctx.Parameters)
+	b := &buildSummary{
+		jobName:     params.String("job_name", ""),
+		buildNumber: params.Int("build_number", 0),
+		buildID:     ctx.BuildID,
+		branch:      params.String("branch", ""),
+		commit:      params.String("commit_sha", ""),
+	}
+	for _, s := range params.Maps("steps") {
+		b.steps = append(b.steps, stepSummary{
+			name:         s.String("name", ""),
+			status:       s.String("status", ""),
+			errorMessage: s.String("error_message", ""),
+			duration:     time.Duration(s.Int("duration_ms", 0)) * time.Millisecond,
+			metadata:     s.Map("metadata"),
+		})
+	}
+
+	switch params.String("build_status", "") {
+	case "failed":
+		b.status = statusFailed
+	case "success": // a notification run after the build
+		b.status = statusPassed
+	default:
+		if len(b.steps) == 0 {
+			b.status = statusStarted
+		} else {
+			b.status = statusPassed
+		}
+	}
+	return b
+}
+
+// previousMessage returns the latest message an earlier step of the build
+// posted to the channel, or nil
+func (b *buildSummary) previousMessage(channel string) *slackMessage {
+	for i := len(b.steps) - 1; i >= 0; i-- {
+		m := b.steps[i].metadata
+		if m.String("slack_ts", "") != "" && m.String("channel", "") == channel {
+			return &slackMessage{
+				channelID: m.String("slack_channel_id", ""),
+				ts:        m.String("slack_ts", ""),
+				status:    m.String("status", ""),
+			}
+		}
+	}
+	return nil
+}
+
+func (b *buildSummary) title() string {
+	title := b.jobName
+	if title == "" {
+		title = "Build"
+	}
+	if b.buildNumber > 0 {
+		title += fmt.Sprintf(" #%d", b.buildNumber)
+	}
+	return title + " " + b.status
+}
+
+// text is the notification and fallback text of the message
+func (b *buildSummary) text() string {
+	return statusEmoji(b.status) + " " + b.title()
+}
+
+// change is the thread reply announcing the build's new status
+func (b *buildSummary) change() string {
+	for _, s := range b.steps {
+		if s.status == "failed" && s.errorMessage != "" {
+			return fmt.Sprintf("%s Build %s: `%s` failed: %s", statusEmoji(b.status), b.status, s.name, truncate(s.errorMessage, 500))
+		}
+	}
+	return fmt.Sprintf("%s Build %s", statusEmoji(b.status), b.status)
+}
+
+// blocks lays out the message: a header, the build's details and a line for
+// each step that ran before, except the steps that posted to Slack
+func (b *buildSummary) blocks() []map[string]interface{} {
+	blocks := []map[string]interface{}{headerBlock(b.text())}
+
+	var fields []string
+	if b.branch != "" {
+		fields = append(fields, fmt.Sprintf("*Branch*\n%s", b.branch))
+	}
+	if commit := b.commit; commit != "" {
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		fields = append(fields, fmt.Sprintf("*Commit*\n`%s`", commit))
+	}
+	fields = append(fields, fmt.Sprintf("*Status*\n%s", b.status))
+	blocks = append(blocks, fieldsSection(fields))
+
+	var lines []string
+	for _, s := range b.steps {
+		if s.metadata.Has("slack_ts") {
+			continue
+		}
+		switch s.status {
+		case "skipped":
+			lines = append(lines, fmt.Sprintf(":fast_forward: `%s` skipped", s.name))
+		case "failed":
+			line := fmt.Sprintf(":x: `%s` %s", s.name, s.duration.Round(time.Second))
+			if s.errorMessage != "" {
+				line += ": " + truncate(s.errorMessage, 200)
+			}
+			lines = append(lines, line)
+		default:
+			lines = append(lines, fmt.Sprintf(":white_check_mark: `%s` %s", s.name, s.duration.Round(time.Second)))
+		}
+	}
+	if len(lines) > 0 {
+		blocks = append(blocks, textSection(truncate("*Steps*\n"+strings.Join(lines, "\n"), maxSectionText)))
+	}
+
+	if b.buildID != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "context",
+			"elements": []map[string]interface{}{{"type": "mrkdwn", "text": "Build " + b.buildID}},
+		})
+	}
+	return blocks
+}
+
+func statusEmoji(status string) string {
+	switch status {
+	case statusFailed:
+		return ":x:"
+	case statusStarted:
+		return ":arrow_forward:"
+	default:
+		return ":white_check_mark:"
+	}
+}
+
+func headerBlock(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "header",
+		"text": map[string]interface{}{"type": "plain_text", "text": truncate(text, 150), "emoji": true},
+	}
+}
+
+func textSection(text string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "section",
+		"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+	}
+}
+
+func fieldsSection(fields []string) map[string]interface{} {
+	var elements []map[string]interface{}
+	for _, f := range fields {
+		elements = append(elements, map[string]interface{}{"type": "mrkdwn", "text": f})
+	}
+	return map[string]interface{}{"type": "section", "fields": elements}
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// postWebhook posts a message through the incoming webhook
+func (p *SlackNotifyPlugin) postWebhook(ctx context.Context, channel string, blocks []map[string]interface{}, text string) error {
+	payload := map[string]interface{}{
+		"username": p.username,
+		"text":     text,
+		"blocks":   blocks,
+	}
+	if channel != "" {
+		payload["channel"] = channel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack notification failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// postMessage posts a message with chat.postMessage, as a reply if threadTS
+// is set, and returns the ID of the channel and the message's timestamp
+func (p *SlackNotifyPlugin) postMessage(ctx context.Context, channel, threadTS string, blocks []map[string]interface{}, text string) (string, string, error) {
+	payload := map[string]interface{}{"channel": channel, "text": text}
+	if blocks != nil {
+		payload["blocks"] = blocks
+	}
+	if threadTS != "" {
+		payload["thread_ts"] = threadTS
+	}
+	var resp struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := p.call(ctx, "chat.postMessage", payload, &resp); err != nil {
+		return "", "", err
+	}
+	return resp.Channel, resp.TS, nil
+}
+
+// updateMessage replaces a message with chat.update
+func (p *SlackNotifyPlugin) updateMessage(ctx context.Context, channelID, ts string, blocks []map[string]interface{}, text string) error {
+	payload := map[string]interface{}{"channel": channelID, "ts": ts, "text": text, "blocks": blocks}
+	return p.call(ctx, "chat.update", payload, nil)
+}
+
+// call calls a Slack Web API method with the bot token and decodes the
+// response into out. Slack reports errors with "ok": false.
+func (p *SlackNotifyPlugin) call(ctx context.Context, method string, payload interface{}, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed with status %d", method, resp.StatusCode)
+	}
+
+	var data json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("invalid %s response: %w", method, err)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("invalid %s response: %w", method, err)
+	}
+	if !status.OK {
+		return fmt.Errorf("%s failed: %s", method, status.Error)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
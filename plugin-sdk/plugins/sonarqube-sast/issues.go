@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// sonarIssuesPageSize is the largest page /api/issues/search returns
+const sonarIssuesPageSize = 500
+
+// sonarIssuesLimit is the most issues /api/issues/search pages through
+const sonarIssuesLimit = 10000
+
+// issueTypes are the SonarQube issue types that can be extracted as findings
+var issueTypes = []string{"BUG", "VULNERABILITY", "CODE_SMELL"}
+
+// sonarIssue is an issue from /api/issues/search
+type sonarIssue struct {
+	Key       string `json:"key"`
+	Rule      string `json:"rule"`
+	Severity  string `json:"severity"`
+	Type      string `json:"type"`
+	Component string `json:"component"`
+	Line      int    `json:"line"`
+	Message   string `json:"message"`
+	TextRange struct {
+		StartLine int `json:"startLine"`
+		EndLine   int `json:"endLine"`
+	} `json:"textRange"`
+	Impacts []struct {
+		SoftwareQuality string `json:"softwareQuality"`
+		Severity        string `json:"severity"`
+	} `json:"impacts"`
+}
+
+// fetchIssues returns the project's open issues of the configured types as
+// findings, only those in the new code period unless all_issues is set, and
+// counts them by type
+func (p *SonarQubeSASTPlugin) fetchIssues(ctx context.Context) ([]sdk.Finding, map[string]int, error) {
+	client := &http.Client{Timeout: p.timeout}
+
+	var findings []sdk.Finding
+	counts := map[string]int{}
+	for page := 1; ; page++ {
+		query := url.Values{
+			"componentKeys":    {p.projectKey},
+			"types":            {strings.Join(p.issueTypes, ",")},
+			"resolved":         {"false"},
+			"additionalFields": {"rules"},
+			"ps":               {strconv.Itoa(sonarIssuesPageSize)},
+			"p":                {strconv.Itoa(page)},
+		}
+		if !p.allIssues {
+			query.Set("inNewCodePeriod", "true")
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", p.serverURL+"/api/issues/search?"+query.Encode(), nil)
+		if err != nil {
+			return findings, counts, err
+		}
+		req.SetBasicAuth(p.token, "")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return findings, counts, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return findings, counts, fmt.Errorf("issue search returned status %d", resp.StatusCode)
+		}
+
+		var result struct {
+			Total      int          `json:"total"`
+			Issues     []sonarIssue `json:"issues"`
+			Components []struct {
+				Key  string `json:"key"`
+				Path string `json:"path"`
+			} `json:"components"`
+			Rules []struct {
+				Key  string `json:"key"`
+				Name string `json:"name"`
+			} `json:"rules"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return findings, counts, err
+		}
+
+		paths := map[string]string{}
+		for _, c := range result.Components {
+			paths[c.Key] = c.Path
+		}
+		ruleNames := map[string]string{}
+		for _, r := range result.Rules {
+			ruleNames[r.Key] = r.Name
+		}
+		for _, issue := range result.Issues {
+			counts[issue.Type]++
+			findings = append(findings, p.issueFinding(issue, paths, ruleNames[issue.Rule]))
+		}
+
+		if len(result.Issues) == 0 || page*sonarIssuesPageSize >= result.Total {
+			return findings, counts, nil
+		}
+		if page*sonarIssuesPageSize >= sonarIssuesLimit {
+			return findings, counts, fmt.Errorf("only the first %d of %d issues were fetched", sonarIssuesLimit, result.Total)
+		}
+	}
+}
+
+// issueFinding converts an issue to a finding. paths maps component keys to
+// file paths, which components of projects with modules do not end in.
+func (p *SonarQubeSASTPlugin) issueFinding(issue sonarIssue, paths map[string]string, ruleName string) sdk.Finding {
+	path, ok := paths[issue.Component]
+	if !ok {
+		// Components are "<project key>:<path>"
+		path = strings.TrimPrefix(issue.Component, p.projectKey+":")
+	}
+	f := sdk.Finding{
+		Tool:     "sonarqube",
+		RuleID:   issue.Rule,
+		Title:    issue.Message,
+		Severity: issueSeverity(issue),
+		Location: sdk.Location{
+			Path:      path,
+			StartLine: issue.TextRange.StartLine,
+			EndLine:   issue.TextRange.EndLine,
+		},
+		HelpURL: fmt.Sprintf("%s/project/issues?id=%s&open=%s", p.serverURL, url.QueryEscape(p.projectKey), url.QueryEscape(issue.Key)),
+	}
+	if f.Location.StartLine == 0 {
+		f.Location.StartLine = issue.Line
+	}
+	// "CODE_SMELL" -> "Code smell"
+	typeName := strings.ToLower(strings.ReplaceAll(issue.Type, "_", " "))
+	if typeName != "" {
+		typeName = strings.ToUpper(typeName[:1]) + typeName[1:]
+	}
+	if ruleName != "" {
+		f.Description = fmt.Sprintf("%s: %s", typeName, ruleName)
+	} else {
+		f.Description = typeName
+	}
+	return f
+}
+
+// issueSeverity returns the severity of an issue. SonarQube 10.2+ rates an
+// issue's impact on each software quality, replacing the single severity,
+// which is then only kept for compatibility; the highest impact is used.
+func issueSeverity(issue sonarIssue) string {
+	if len(issue.Impacts) == 0 {
+		return sdk.NormalizeSeverity(issue.Severity)
+	}
+	severity := sdk.SeverityInfo
+	for _, impact := range issue.Impacts {
+		if s := sdk.NormalizeSeverity(impact.Severity); sdk.SeverityAtLeast(s, severity) {
+			severity = s
+		}
+	}
+	return severity
+}
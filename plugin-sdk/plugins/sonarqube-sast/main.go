@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
@@ -19,8 +21,10 @@ type SonarQubeSASTPlugin struct {
 	projectKey     string
 	qualityGate    string
 	sources        string
-	timeout        int
+	timeout        time.Duration
 	scannerVersion string
+	issueTypes     []string // issue types extracted as findings
+	allIssues      bool     // extract every open issue, not only those in new code
 }
 
 func (p *SonarQubeSASTPlugin) Name() string {
@@ -35,14 +39,31 @@ func (p *SonarQubeSASTPlugin) Type() string {
 	return "security"
 }
 
+func (p *SonarQubeSASTPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace}
+}
+
 func (p *SonarQubeSASTPlugin) Initialize(config map[string]interface{}) error {
-	p.serverURL = getStringConfig(config, "server_url", "http://localhost:9000")
-	p.token = getStringConfig(config, "token", os.Getenv("SONAR_TOKEN"))
-	p.projectKey = getStringConfig(config, "project_key", "")
-	p.qualityGate = getStringConfig(config, "quality_gate", "Sonar way")
-	p.sources = getStringConfig(config, "sources", ".")
-	p.timeout = getIntConfig(config, "timeout", 300)
-	p.scannerVersion = getStringConfig(config, "scanner_version", "5.0.1.3006")
+	cfg := sdk.Config(config)
+	p.serverURL = cfg.String("server_url", "http://localhost:9000")
+	p.token = cfg.String("token", os.Getenv("SONAR_TOKEN"))
+	p.projectKey = cfg.String("project_key", "")
+	p.qualityGate = cfg.String("quality_gate", "Sonar way")
+	p.sources = cfg.String("sources", ".")
+	p.timeout = cfg.Duration("timeout", 5*time.Minute)
+	p.scannerVersion = cfg.String("scanner_version", "5.0.1.3006")
+	p.allIssues = cfg.Bool("all_issues", false)
+	p.issueTypes = issueTypes
+	if cfg.Has("issue_types") {
+		p.issueTypes = nil
+		for _, t := range cfg.StringSlice("issue_types") {
+			t = strings.ToUpper(t)
+			if !contains(issueTypes, t) {
+				return fmt.Errorf("invalid issue_types entry %q (expected BUG, VULNERABILITY or CODE_SMELL)", t)
+			}
+			p.issueTypes = append(p.issueTypes, t)
+		}
+	}
 
 	if p.token == "" {
 		return fmt.Errorf("sonarqube token is required (set token in config or SONAR_TOKEN env var)")
@@ -51,6 +72,26 @@ func (p *SonarQubeSASTPlugin) Initialize(config map[string]interface{}) error {
 	return nil
 }
 
+func (p *SonarQubeSASTPlugin) Health() error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(p.serverURL + "/api/system/status")
+	if err != nil {
+		return fmt.Errorf("SonarQube is not reachable at %s: %w", p.serverURL, err)
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return fmt.Errorf("unexpected response from SonarQube at %s: %w", p.serverURL, err)
+	}
+	if status.Status != "UP" {
+		return fmt.Errorf("SonarQube at %s is %s", p.serverURL, status.Status)
+	}
+	return nil
+}
+
 func (p *SonarQubeSASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
 	ctx.Logger.Info("Starting SonarQube SAST analysis")
 
@@ -78,7 +119,7 @@ func (p *SonarQubeSASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, e
 
 	// Wait for analysis to complete and check quality gate
 	ctx.Logger.Info("Waiting for SonarQube analysis to complete...")
-	passed, metrics, err := p.waitForAnalysisAndCheckQualityGate()
+	passed, metrics, err := p.waitForAnalysisAndCheckQualityGate(ctx)
 	if err != nil {
 		return &sdk.Result{
 			Success:      false,
@@ -103,7 +144,24 @@ func (p *SonarQubeSASTPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, e
 		result.Metadata[key] = value
 	}
 
+	if len(p.issueTypes) > 0 {
+		findings, counts, err := p.fetchIssues(ctx)
+		if err != nil {
+			ctx.Logger.Warn(fmt.Sprintf("Failed to fetch issues: %v", err))
+		}
+		result.Findings = findings
+		result.Metadata["issue_count"] = len(findings)
+		result.Metadata["issues_by_type"] = counts
+	}
+
 	result.Output = fmt.Sprintf("SonarQube analysis complete. Quality Gate: %s", map[bool]string{true: "PASSED", false: "FAILED"}[passed])
+	if len(p.issueTypes) > 0 {
+		scope := "new"
+		if p.allIssues {
+			scope = "open"
+		}
+		result.Output += fmt.Sprintf(", %d %s issues", len(result.Findings), scope)
+	}
 	ctx.Logger.Info(result.Output)
 
 	return result, nil
@@ -135,24 +193,24 @@ func (p *SonarQubeSASTPlugin) runSonarScan(ctx *sdk.ExecutionContext, scannerPat
 		fmt.Sprintf("-Dsonar.host.url=%s", p.serverURL),
 		fmt.Sprintf("-Dsonar.login=%s", p.token),
 		fmt.Sprintf("-Dsonar.qualitygate.wait=true"),
-		fmt.Sprintf("-Dsonar.qualitygate.timeout=%d", p.timeout),
+		fmt.Sprintf("-Dsonar.qualitygate.timeout=%d", int(p.timeout.Seconds())),
 	}
 
-	cmd := exec.Command(scannerPath, args...)
+	cmd := exec.CommandContext(ctx, scannerPath, args...)
 	cmd.Dir = ctx.WorkDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = ctx.Output
+	cmd.Stderr = ctx.Output
 	cmd.Env = append(os.Environ(), fmt.Sprintf("SONAR_TOKEN=%s", p.token))
 
 	return cmd.Run()
 }
 
-func (p *SonarQubeSASTPlugin) waitForAnalysisAndCheckQualityGate() (bool, map[string]interface{}, error) {
-	client := &http.Client{Timeout: time.Duration(p.timeout) * time.Second}
+func (p *SonarQubeSASTPlugin) waitForAnalysisAndCheckQualityGate(ctx context.Context) (bool, map[string]interface{}, error) {
+	client := &http.Client{Timeout: p.timeout}
 
 	// Get project status
 	url := fmt.Sprintf("%s/api/qualitygates/project_status?projectKey=%s", p.serverURL, p.projectKey)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return false, nil, err
 	}
@@ -160,11 +218,13 @@ func (p *SonarQubeSASTPlugin) waitForAnalysisAndCheckQualityGate() (bool, map[st
 	req.SetBasicAuth(p.token, "")
 
 	// Poll for results
-	maxAttempts := p.timeout / 5
+	maxAttempts := int(p.timeout / (5 * time.Second))
 	for i := 0; i < maxAttempts; i++ {
 		resp, err := client.Do(req)
 		if err != nil {
-			time.Sleep(5 * time.Second)
+			if err := sleep(ctx, 5*time.Second); err != nil {
+				return false, nil, err
+			}
 			continue
 		}
 
@@ -198,7 +258,9 @@ func (p *SonarQubeSASTPlugin) waitForAnalysisAndCheckQualityGate() (bool, map[st
 		}
 
 		resp.Body.Close()
-		time.Sleep(5 * time.Second)
+		if err := sleep(ctx, 5*time.Second); err != nil {
+			return false, nil, err
+		}
 	}
 
 	return false, nil, fmt.Errorf("timeout waiting for analysis results")
@@ -208,26 +270,28 @@ func (p *SonarQubeSASTPlugin) Cleanup() error {
 	return nil
 }
 
-// Helper functions
-func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
-	if val, ok := config[key].(string); ok {
-		return val
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
 	}
-	return defaultValue
+	return false
 }
 
-func getIntConfig(config map[string]interface{}, key string, defaultValue int) int {
-	if val, ok := config[key].(float64); ok {
-		return int(val)
+// sleep waits for d, returning early with the context's error if it is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
 	}
-	return defaultValue
 }
 
 // Export the plugin
 var Plugin SonarQubeSASTPlugin
 
 func main() {
-	fmt.Println("SonarQube SAST Plugin v1.0.0")
-	fmt.Println("This plugin performs static application security testing using SonarQube")
-	fmt.Println("To build: go build -o sonarqube-sast")
+	sdk.Serve(&Plugin)
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// SyftGrypeScanPlugin generates a CycloneDX SBOM of the workspace with syft
+// and scans it for vulnerabilities with grype. Unlike an image scan, it
+// covers the dependencies declared in source, such as go.mod, package-lock.json
+// or requirements.txt, before anything is built.
+type SyftGrypeScanPlugin struct {
+	path      string // directory to scan, relative to the workspace
+	sbomFile  string // relative to the workspace
+	failOn    string // severity, or "" to never fail
+	onlyFixed bool
+	syft      string
+	grype     string
+}
+
+// grypeReport is the subset of `grype -o json` output used for findings
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID          string   `json:"id"`
+			DataSource  string   `json:"dataSource"`
+			Severity    string   `json:"severity"`
+			Description string   `json:"description"`
+			URLs        []string `json:"urls"`
+			Fix         struct {
+				Versions []string `json:"versions"`
+				State    string   `json:"state"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		RelatedVulnerabilities []struct {
+			ID string `json:"id"`
+		} `json:"relatedVulnerabilities"`
+		Artifact struct {
+			Name      string `json:"name"`
+			Version   string `json:"version"`
+			Type      string `json:"type"`
+			Locations []struct {
+				Path string `json:"path"`
+			} `json:"locations"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+func (p *SyftGrypeScanPlugin) Name() string {
+	return "syft-grype-scan"
+}
+
+func (p *SyftGrypeScanPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *SyftGrypeScanPlugin) Type() string {
+	return "security"
+}
+
+func (p *SyftGrypeScanPlugin) Capabilities() []string {
+	// grype downloads its vulnerability database
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace}
+}
+
+func (p *SyftGrypeScanPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	p.path = cfg.String("path", ".")
+	p.sbomFile = cfg.String("sbom_file", "sbom.cdx.json")
+	p.onlyFixed = cfg.Bool("only_fixed", false)
+	p.syft = cfg.String("syft_binary", "syft")
+	p.grype = cfg.String("grype_binary", "grype")
+
+	p.failOn = strings.ToLower(cfg.String("fail_on", sdk.SeverityHigh))
+	switch p.failOn {
+	case "none":
+		p.failOn = ""
+	case sdk.SeverityCritical, sdk.SeverityHigh, sdk.SeverityMedium, sdk.SeverityLow, sdk.SeverityInfo:
+	default:
+		return fmt.Errorf("invalid fail_on %q (expected critical, high, medium, low, info or none)", p.failOn)
+	}
+	return nil
+}
+
+func (p *SyftGrypeScanPlugin) Health() error {
+	for _, binary := range []string{p.syft, p.grype} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return fmt.Errorf("%s is not installed: %w", binary, err)
+		}
+	}
+	return nil
+}
+
+func (p *SyftGrypeScanPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	source := filepath.Join(ctx.WorkDir, p.path)
+	sbomPath := filepath.Join(ctx.WorkDir, p.sbomFile)
+
+	ctx.Logger.Info(fmt.Sprintf("Generating SBOM for %s", p.path))
+	if _, err := p.run(ctx, p.syft, "scan", "dir:"+source, "-o", "cyclonedx-json="+sbomPath); err != nil {
+		return failure(fmt.Sprintf("syft failed: %v", err)), err
+	}
+	components, err := countComponents(sbomPath)
+	if err != nil {
+		return failure(fmt.Sprintf("Failed to read SBOM: %v", err)), err
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Scanning %d components with grype", components))
+	args := []string{"sbom:" + sbomPath, "-o", "json"}
+	if p.onlyFixed {
+		args = append(args, "--only-fixed")
+	}
+	output, err := p.run(ctx, p.grype, args...)
+	if err != nil {
+		return failure(fmt.Sprintf("grype failed: %v", err)), err
+	}
+	var report grypeReport
+	if err := json.Unmarshal(output, &report); err != nil {
+		return failure(fmt.Sprintf("Failed to parse grype output: %v", err)), err
+	}
+
+	artifact, err := newArtifact(sbomPath)
+	if err != nil {
+		return failure(fmt.Sprintf("Failed to attach SBOM: %v", err)), err
+	}
+
+	result := &sdk.Result{
+		Success:   true,
+		Artifacts: []sdk.Artifact{artifact},
+		Metadata:  make(map[string]interface{}),
+	}
+
+	bySeverity := make(map[string]int)
+	failing := 0
+	for _, match := range report.Matches {
+		vuln := match.Vulnerability
+		f := sdk.Finding{
+			Tool:        "grype",
+			RuleID:      vuln.ID,
+			Title:       fmt.Sprintf("%s in %s %s", vuln.ID, match.Artifact.Name, match.Artifact.Version),
+			Description: vuln.Description,
+			Severity:    sdk.NormalizeSeverity(vuln.Severity),
+			Location: sdk.Location{
+				Package: match.Artifact.Name,
+				Version: match.Artifact.Version,
+			},
+			HelpURL: vuln.DataSource,
+		}
+		if len(match.Artifact.Locations) > 0 {
+			f.Location.Path = strings.TrimPrefix(match.Artifact.Locations[0].Path, "/")
+		}
+		if f.HelpURL == "" && len(vuln.URLs) > 0 {
+			f.HelpURL = vuln.URLs[0]
+		}
+		if strings.HasPrefix(vuln.ID, "CVE-") {
+			f.CVEs = append(f.CVEs, vuln.ID)
+		}
+		for _, related := range match.RelatedVulnerabilities {
+			if strings.HasPrefix(related.ID, "CVE-") && !contains(f.CVEs, related.ID) {
+				f.CVEs = append(f.CVEs, related.ID)
+			}
+		}
+		if len(vuln.Fix.Versions) > 0 {
+			f.Description = strings.TrimSpace(f.Description + "\n\nFixed in " + strings.Join(vuln.Fix.Versions, ", "))
+		}
+
+		result.Findings = append(result.Findings, f)
+		bySeverity[f.Severity]++
+		if p.failOn != "" && sdk.SeverityAtLeast(f.Severity, p.failOn) {
+			failing++
+		}
+	}
+
+	result.Metadata["sbom_components"] = components
+	result.Metadata["total_vulnerabilities"] = len(report.Matches)
+	result.Metadata["vulnerabilities_by_severity"] = bySeverity
+	result.Output = fmt.Sprintf("Found %d vulnerabilities in %d components", len(report.Matches), components)
+	if failing > 0 {
+		result.Success = false
+		result.ExitCode = 1
+		result.ErrorMessage = fmt.Sprintf("Found %d vulnerabilities of severity %s or above", failing, p.failOn)
+	}
+
+	ctx.Logger.Info(result.Output)
+	for severity, count := range bySeverity {
+		ctx.Logger.Info(fmt.Sprintf("  %s: %d", severity, count))
+	}
+
+	return result, nil
+}
+
+// run executes a tool in the workspace and returns its standard output.
+// Progress on standard error goes to the build log.
+func (p *SyftGrypeScanPlugin) run(ctx *sdk.ExecutionContext, binary string, args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Dir = ctx.WorkDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = ctx.Output
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func (p *SyftGrypeScanPlugin) Cleanup() error {
+	return nil
+}
+
+// countComponents returns the number of components in a CycloneDX SBOM
+func countComponents(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var bom struct {
+		Components []json.RawMessage `json:"components"`
+	}
+	if err := json.Unmarshal(data, &bom); err != nil {
+		return 0, err
+	}
+	return len(bom.Components), nil
+}
+
+func newArtifact(path string) (sdk.Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sdk.Artifact{}, err
+	}
+	return sdk.Artifact{
+		Name:           filepath.Base(path),
+		Path:           path,
+		SizeBytes:      info.Size(),
+		ChecksumSHA256: hex.EncodeToString(h.Sum(nil)),
+		Metadata:       map[string]string{"kind": "sbom", "format": "cyclonedx-json"},
+	}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func failure(msg string) *sdk.Result {
+	return &sdk.Result{
+		Success:      false,
+		ExitCode:     1,
+		ErrorMessage: msg,
+	}
+}
+
+func main() {
+	sdk.Serve(&SyftGrypeScanPlugin{})
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+const (
+	planFileName  = "solvyd.tfplan"
+	maxPlanOutput = 64 * 1024
+)
+
+// TerraformPlugin runs terraform init/plan/apply, gating apply on the approval of
+// a plan made by an earlier build unless require_approval is off
+type TerraformPlugin struct {
+	workingDir      string
+	action          string // plan, apply, plan-apply
+	requireApproval bool
+	workspace       string
+	varFiles        []string
+	vars            map[string]string
+	backendConfig   []string
+	destroy         bool
+	binary          string
+}
+
+// terraformPlan is the subset of `terraform show -json` output used for summaries
+type terraformPlan struct {
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Change  struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+func (p *TerraformPlugin) Name() string {
+	return "terraform"
+}
+
+func (p *TerraformPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *TerraformPlugin) Type() string {
+	return "deployment"
+}
+
+func (p *TerraformPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace, sdk.CapabilityEnv, sdk.CapabilitySecrets}
+}
+
+func (p *TerraformPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	p.workingDir = cfg.String("working_dir", ".")
+	p.action = cfg.String("action", "plan")
+	p.requireApproval = cfg.Bool("require_approval", true)
+	p.workspace = cfg.String("workspace", "")
+	p.destroy = cfg.Bool("destroy", false)
+	p.binary = cfg.String("terraform_binary", "terraform")
+	p.varFiles = cfg.StringSlice("var_files")
+	p.backendConfig = cfg.StringSlice("backend_config")
+	p.vars = cfg.StringMap("vars")
+
+	switch p.action {
+	case "plan", "apply", "plan-apply":
+	default:
+		return fmt.Errorf("invalid action %q (expected plan, apply or plan-apply)", p.action)
+	}
+	if p.action == "plan-apply" && p.requireApproval {
+		return fmt.Errorf("plan-apply cannot wait for approval of a plan it has not made yet: plan in one build and apply the approved plan in another, or set require_approval to false")
+	}
+
+	return nil
+}
+
+func (p *TerraformPlugin) Health() error {
+	if _, err := exec.LookPath(p.binary); err != nil {
+		return fmt.Errorf("%s is not installed: %w", p.binary, err)
+	}
+	return nil
+}
+
+func (p *TerraformPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	dir := filepath.Join(ctx.WorkDir, p.workingDir)
+	planPath := filepath.Join(dir, planFileName)
+
+	result := &sdk.Result{
+		Success:  true,
+		ExitCode: 0,
+		Metadata: make(map[string]interface{}),
+	}
+
+	if err := p.init(ctx, dir); err != nil {
+		return failure(fmt.Sprintf("terraform init failed: %v", err)), err
+	}
+
+	if p.action == "plan" || p.action == "plan-apply" {
+		if err := p.plan(ctx, dir, planPath, result); err != nil {
+			return failure(fmt.Sprintf("terraform plan failed: %v", err)), err
+		}
+	}
+
+	if p.action == "plan" {
+		return result, nil
+	}
+
+	// Apply is gated on an explicit approval unless the gate is disabled. The
+	// approval names the plan, made by an earlier plan build, by the checksum
+	// of its solvyd.tfplan artifact, and only that plan is applied.
+	params := sdk.Config(ctx.Parameters)
+	expected := params.String("terraform_plan_checksum", "")
+	if p.requireApproval {
+		if !params.Bool("terraform_approved", false) || expected == "" {
+			ctx.Logger.Info("Terraform apply is awaiting approval")
+			err := fmt.Errorf("apply requires approval: set parameters terraform_approved=true and terraform_plan_checksum to the approved plan's checksum")
+			awaiting := failure(err.Error())
+			awaiting.Metadata = map[string]interface{}{"awaiting_approval": true}
+			return awaiting, err
+		}
+	}
+
+	if url := params.String("terraform_plan_url", ""); url != "" {
+		ctx.Logger.Info("Downloading the approved Terraform plan")
+		if err := downloadPlan(ctx, url, planPath); err != nil {
+			return failure(fmt.Sprintf("failed to download plan: %v", err)), err
+		}
+	}
+
+	// Make sure the plan being applied is the one that was reviewed
+	if expected != "" {
+		actual, err := fileChecksum(planPath)
+		if err != nil {
+			return failure(fmt.Sprintf("failed to read plan file: %v", err)), err
+		}
+		if actual != expected {
+			err := fmt.Errorf("plan checksum %s does not match approved checksum %s", actual, expected)
+			return failure(err.Error()), err
+		}
+	}
+
+	if _, err := os.Stat(planPath); err != nil {
+		return failure(fmt.Sprintf("plan file not found at %s", planPath)), err
+	}
+
+	ctx.Logger.Info("Applying Terraform plan")
+	output, err := p.run(ctx, dir, "apply", "-input=false", "-no-color", "-auto-approve", planFileName)
+	if err != nil {
+		return failure(fmt.Sprintf("terraform apply failed: %v", err)), err
+	}
+
+	result.Metadata["applied"] = true
+	result.Output = truncate(output, maxPlanOutput)
+	ctx.Logger.Info("Terraform apply completed successfully")
+
+	return result, nil
+}
+
+func (p *TerraformPlugin) init(ctx *sdk.ExecutionContext, dir string) error {
+	args := []string{"init", "-input=false", "-no-color"}
+	for _, bc := range p.backendConfig {
+		args = append(args, "-backend-config="+bc)
+	}
+
+	ctx.Logger.Info("Running terraform init")
+	if _, err := p.run(ctx, dir, args...); err != nil {
+		return err
+	}
+
+	if p.workspace != "" {
+		ctx.Logger.Info(fmt.Sprintf("Selecting Terraform workspace %s", p.workspace))
+		if _, err := p.run(ctx, dir, "workspace", "select", "-or-create", p.workspace); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// plan creates the plan file, renders it as text and JSON, and attaches all three as artifacts
+func (p *TerraformPlugin) plan(ctx *sdk.ExecutionContext, dir, planPath string, result *sdk.Result) error {
+	args := []string{"plan", "-input=false", "-no-color", "-out=" + planFileName}
+	if p.destroy {
+		args = append(args, "-destroy")
+	}
+	for _, vf := range p.varFiles {
+		args = append(args, "-var-file="+vf)
+	}
+
+	keys := make([]string, 0, len(p.vars))
+	for k := range p.vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "-var", k+"="+p.vars[k])
+	}
+
+	ctx.Logger.Info("Running terraform plan")
+	if _, err := p.run(ctx, dir, args...); err != nil {
+		return err
+	}
+
+	rendered, err := p.run(ctx, dir, "show", "-no-color", planFileName)
+	if err != nil {
+		return err
+	}
+	renderedPath := filepath.Join(dir, "solvyd-plan.txt")
+	if err := os.WriteFile(renderedPath, []byte(rendered), 0644); err != nil {
+		return err
+	}
+
+	planJSON, err := p.run(ctx, dir, "show", "-json", planFileName)
+	if err != nil {
+		return err
+	}
+	jsonPath := filepath.Join(dir, "solvyd-plan.json")
+	if err := os.WriteFile(jsonPath, []byte(planJSON), 0644); err != nil {
+		return err
+	}
+
+	var parsed terraformPlan
+	if err := json.Unmarshal([]byte(planJSON), &parsed); err != nil {
+		return fmt.Errorf("failed to parse plan JSON: %w", err)
+	}
+
+	changes := map[string]int{"add": 0, "change": 0, "destroy": 0, "replace": 0}
+	changed := []string{}
+	for _, rc := range parsed.ResourceChanges {
+		kind := classifyActions(rc.Change.Actions)
+		if kind == "" {
+			continue
+		}
+		changes[kind]++
+		changed = append(changed, fmt.Sprintf("%s (%s)", rc.Address, kind))
+	}
+
+	for _, path := range []string{planPath, renderedPath, jsonPath} {
+		artifact, err := newArtifact(path)
+		if err != nil {
+			return err
+		}
+		result.Artifacts = append(result.Artifacts, artifact)
+	}
+
+	checksum := result.Artifacts[0].ChecksumSHA256
+	hasChanges := len(changed) > 0
+
+	result.Metadata["plan_checksum"] = checksum
+	result.Metadata["has_changes"] = hasChanges
+	result.Metadata["resource_changes"] = changes
+	result.Metadata["changed_resources"] = changed
+	result.Metadata["plan"] = truncate(rendered, maxPlanOutput)
+	result.Output = truncate(rendered, maxPlanOutput)
+
+	ctx.Logger.Info(fmt.Sprintf("Plan: %d to add, %d to change, %d to replace, %d to destroy",
+		changes["add"], changes["change"], changes["replace"], changes["destroy"]))
+
+	return nil
+}
+
+// run executes terraform in dir with the build environment and secrets exported
+func (p *TerraformPlugin) run(ctx *sdk.ExecutionContext, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, p.binary, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "TF_IN_AUTOMATION=1")
+	for k, v := range ctx.EnvVars {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	for _, name := range ctx.SecretNames() {
+		value, err := ctx.Secret(name)
+		if err != nil {
+			return "", err
+		}
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("%w: %s", err, truncate(string(output), 4096))
+	}
+	return string(output), nil
+}
+
+func (p *TerraformPlugin) Cleanup() error {
+	return nil
+}
+
+// classifyActions maps terraform change actions to a summary bucket
+func classifyActions(actions []string) string {
+	joined := strings.Join(actions, ",")
+	switch joined {
+	case "create":
+		return "add"
+	case "update":
+		return "change"
+	case "delete":
+		return "destroy"
+	case "delete,create", "create,delete":
+		return "replace"
+	}
+	return ""
+}
+
+func newArtifact(path string) (sdk.Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	return sdk.Artifact{
+		Name:           filepath.Base(path),
+		Path:           path,
+		SizeBytes:      info.Size(),
+		ChecksumSHA256: checksum,
+		Metadata:       map[string]string{"kind": "terraform-plan"},
+	}, nil
+}
+
+// downloadPlan saves the plan at url, such as the content URL of a plan
+// build's solvyd.tfplan artifact, to path
+func downloadPlan(ctx *sdk.ExecutionContext, url, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func failure(msg string) *sdk.Result {
+	return &sdk.Result{
+		Success:      false,
+		ExitCode:     1,
+		ErrorMessage: msg,
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "\n... (truncated)"
+}
+
+// Export the plugin
+var Plugin TerraformPlugin
+
+func main() {
+	sdk.Serve(&Plugin)
+}
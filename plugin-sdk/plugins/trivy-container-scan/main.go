@@ -1,21 +1,43 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
 )
 
-// TrivyContainerScanPlugin implements container security scanning using Trivy
+// Scan modes
+const (
+	modeImage  = "image"  // vulnerabilities in a container image
+	modeFS     = "fs"     // vulnerabilities in the dependencies of a directory
+	modeConfig = "config" // misconfigurations in IaC such as Terraform, Kubernetes manifests and Dockerfiles
+	modeSecret = "secret" // secrets committed to a directory
+)
+
+// TrivyContainerScanPlugin implements container security scanning using
+// Trivy. Besides images, it scans directories of the workspace for vulnerable
+// dependencies, IaC misconfigurations and secrets.
 type TrivyContainerScanPlugin struct {
+	mode          string
 	image         string
+	path          string // directory to scan in fs, config and secret modes, relative to the workspace
+	ignoreFile    string // relative to the workspace
+	ignoreSet     bool   // ignore_file was set, so it must exist
 	severity      []string
 	trivyServer   string
 	ignoreUnfixed bool
-	timeout       int
+	timeout       time.Duration
 	exitCode      int
+	sbomFile      string // CycloneDX SBOM to write, relative to the workspace, or "" for none
+	vexFile       string // CycloneDX VEX to write alongside the SBOM, or "" for none
 }
 
 type TrivyReport struct {
@@ -24,15 +46,39 @@ type TrivyReport struct {
 		Class           string `json:"Class"`
 		Type            string `json:"Type"`
 		Vulnerabilities []struct {
-			VulnerabilityID  string `json:"VulnerabilityID"`
-			PkgName          string `json:"PkgName"`
-			InstalledVersion string `json:"InstalledVersion"`
-			FixedVersion     string `json:"FixedVersion"`
-			Severity         string `json:"Severity"`
-			Title            string `json:"Title"`
-			Description      string `json:"Description"`
-			PrimaryURL       string `json:"PrimaryURL"`
+			VulnerabilityID  string   `json:"VulnerabilityID"`
+			PkgName          string   `json:"PkgName"`
+			InstalledVersion string   `json:"InstalledVersion"`
+			FixedVersion     string   `json:"FixedVersion"`
+			Severity         string   `json:"Severity"`
+			Title            string   `json:"Title"`
+			Description      string   `json:"Description"`
+			PrimaryURL       string   `json:"PrimaryURL"`
+			CweIDs           []string `json:"CweIDs"`
 		} `json:"Vulnerabilities"`
+		Misconfigurations []struct {
+			ID            string `json:"ID"`
+			AVDID         string `json:"AVDID"`
+			Title         string `json:"Title"`
+			Description   string `json:"Description"`
+			Message       string `json:"Message"`
+			Resolution    string `json:"Resolution"`
+			Severity      string `json:"Severity"`
+			PrimaryURL    string `json:"PrimaryURL"`
+			Status        string `json:"Status"`
+			CauseMetadata struct {
+				StartLine int `json:"StartLine"`
+				EndLine   int `json:"EndLine"`
+			} `json:"CauseMetadata"`
+		} `json:"Misconfigurations"`
+		Secrets []struct {
+			RuleID    string `json:"RuleID"`
+			Category  string `json:"Category"`
+			Severity  string `json:"Severity"`
+			Title     string `json:"Title"`
+			StartLine int    `json:"StartLine"`
+			EndLine   int    `json:"EndLine"`
+		} `json:"Secrets"`
 	} `json:"Results"`
 }
 
@@ -48,41 +94,110 @@ func (p *TrivyContainerScanPlugin) Type() string {
 	return "security"
 }
 
+func (p *TrivyContainerScanPlugin) Capabilities() []string {
+	// The workspace is written to when an SBOM is requested
+	return []string{sdk.CapabilityNetwork, sdk.CapabilityWorkspace}
+}
+
 func (p *TrivyContainerScanPlugin) Initialize(config map[string]interface{}) error {
-	p.image = getStringConfig(config, "image", "")
-	p.trivyServer = getStringConfig(config, "trivy_server", "")
-	p.ignoreUnfixed = getBoolConfig(config, "ignore_unfixed", false)
-	p.timeout = getIntConfig(config, "timeout", 300)
-	p.exitCode = getIntConfig(config, "exit_code", 1)
-
-	// Parse severity levels
-	if sev, ok := config["severity"].([]interface{}); ok {
-		p.severity = make([]string, len(sev))
-		for i, s := range sev {
-			p.severity[i] = s.(string)
+	cfg := sdk.Config(config)
+	p.mode = cfg.String("mode", modeImage)
+	switch p.mode {
+	case modeImage:
+		if err := cfg.Require("image"); err != nil {
+			return err
 		}
-	} else {
-		p.severity = []string{"CRITICAL", "HIGH"}
+	case modeFS, modeConfig, modeSecret:
+	default:
+		return fmt.Errorf("invalid mode %q (expected image, fs, config or secret)", p.mode)
 	}
 
-	if p.image == "" {
-		return fmt.Errorf("image is required for container scanning")
+	p.image = cfg.String("image", "")
+	p.path = cfg.String("path", ".")
+	p.ignoreFile = cfg.String("ignore_file", ".trivyignore")
+	p.ignoreSet = cfg.Has("ignore_file")
+	p.trivyServer = cfg.String("trivy_server", "")
+	p.ignoreUnfixed = cfg.Bool("ignore_unfixed", false)
+	p.timeout = cfg.Duration("timeout", 5*time.Minute)
+	p.exitCode = cfg.Int("exit_code", 1)
+
+	if cfg.Bool("sbom", false) {
+		if p.mode != modeImage && p.mode != modeFS {
+			return fmt.Errorf("sbom is only supported in image and fs modes")
+		}
+		p.sbomFile = cfg.String("sbom_file", "sbom.cdx.json")
+		if cfg.Bool("vex", true) {
+			p.vexFile = cfg.String("vex_file", "vex.cdx.json")
+		}
+	}
+
+	p.severity = cfg.StringSlice("severity")
+	if !cfg.Has("severity") {
+		p.severity = []string{"CRITICAL", "HIGH"}
 	}
 
 	return nil
 }
 
+func (p *TrivyContainerScanPlugin) Health() error {
+	if _, err := exec.LookPath("trivy"); err != nil {
+		return fmt.Errorf("trivy is not installed: %w", err)
+	}
+	if p.trivyServer == "" {
+		return nil
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(p.trivyServer, "/") + "/healthz")
+	if err != nil {
+		return fmt.Errorf("trivy server is not reachable at %s: %w", p.trivyServer, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("trivy server at %s returned %s", p.trivyServer, resp.Status)
+	}
+	return nil
+}
+
 func (p *TrivyContainerScanPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
-	ctx.Logger.Info(fmt.Sprintf("Starting Trivy container scan for image: %s", p.image))
+	target := p.image
+	var args []string
+	switch p.mode {
+	case modeImage:
+		ctx.Logger.Info(fmt.Sprintf("Starting Trivy container scan for image: %s", p.image))
+		args = []string{"image", "--format", "json"}
+	case modeConfig:
+		target = filepath.Join(ctx.WorkDir, p.path)
+		ctx.Logger.Info(fmt.Sprintf("Starting Trivy config scan of %s", p.path))
+		args = []string{"config", "--format", "json"}
+	default:
+		target = filepath.Join(ctx.WorkDir, p.path)
+		scanners := "vuln"
+		if p.mode == modeSecret {
+			scanners = "secret"
+		}
+		ctx.Logger.Info(fmt.Sprintf("Starting Trivy %s scan of %s", p.mode, p.path))
+		args = []string{"fs", "--format", "json", "--scanners", scanners}
+	}
 
-	// Build trivy command
-	args := []string{"image", "--format", "json"}
+	// Findings listed in the repository's .trivyignore are left out
+	ignoreFile := filepath.Join(ctx.WorkDir, p.ignoreFile)
+	if _, err := os.Stat(ignoreFile); err == nil {
+		args = append(args, "--ignorefile", ignoreFile)
+	} else if p.ignoreSet {
+		return failure(fmt.Sprintf("Ignore file %s not found", p.ignoreFile)), err
+	}
 
-	if p.trivyServer != "" {
+	if p.trivyServer != "" && p.mode != modeConfig {
 		args = append(args, "--server", p.trivyServer)
 	}
 
-	if p.ignoreUnfixed {
+	if p.sbomFile != "" {
+		// Include every package, not only vulnerable ones, so the report
+		// converts to a complete SBOM
+		args = append(args, "--list-all-pkgs")
+	}
+
+	if p.ignoreUnfixed && (p.mode == modeImage || p.mode == modeFS) {
 		args = append(args, "--ignore-unfixed")
 	}
 
@@ -98,12 +213,17 @@ func (p *TrivyContainerScanPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resu
 		args = append(args, "--severity", severityStr)
 	}
 
-	args = append(args, p.image)
+	args = append(args, target)
 
-	// Run trivy
-	cmd := exec.Command("trivy", args...)
+	// Run trivy. Progress on standard error goes to the build log, so it
+	// does not corrupt the JSON report.
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "trivy", args...)
 	cmd.Dir = ctx.WorkDir
-	output, err := cmd.CombinedOutput()
+	cmd.Stdout = &stdout
+	cmd.Stderr = ctx.Output
+	err := cmd.Run()
+	output := stdout.Bytes()
 
 	// Parse results even if command failed
 	var report TrivyReport
@@ -113,14 +233,77 @@ func (p *TrivyContainerScanPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resu
 		}
 	}
 
-	// Count vulnerabilities by severity
+	// Count vulnerabilities, misconfigurations and secrets by severity
 	vulnCounts := make(map[string]int)
 	totalVulns := 0
+	var findings []sdk.Finding
 
 	for _, result := range report.Results {
+		for _, misconfig := range result.Misconfigurations {
+			if misconfig.Status != "" && misconfig.Status != "FAIL" {
+				continue
+			}
+			vulnCounts[misconfig.Severity]++
+			totalVulns++
+			ruleID := misconfig.AVDID
+			if ruleID == "" {
+				ruleID = misconfig.ID
+			}
+			description := misconfig.Message
+			if misconfig.Resolution != "" {
+				description = strings.TrimSpace(description + "\n\n" + misconfig.Resolution)
+			}
+			findings = append(findings, sdk.Finding{
+				Tool:        "trivy",
+				RuleID:      ruleID,
+				Title:       misconfig.Title,
+				Description: description,
+				Severity:    sdk.NormalizeSeverity(misconfig.Severity),
+				Location: sdk.Location{
+					Path:      p.findingPath(result.Target),
+					StartLine: misconfig.CauseMetadata.StartLine,
+					EndLine:   misconfig.CauseMetadata.EndLine,
+				},
+				HelpURL: misconfig.PrimaryURL,
+			})
+		}
+		// The matched secret is left out of findings, which are stored in
+		// the database and shown in the UI
+		for _, secret := range result.Secrets {
+			vulnCounts[secret.Severity]++
+			totalVulns++
+			findings = append(findings, sdk.Finding{
+				Tool:        "trivy",
+				RuleID:      secret.RuleID,
+				Title:       secret.Title,
+				Description: fmt.Sprintf("%s secret committed to %s", secret.Category, result.Target),
+				Severity:    sdk.NormalizeSeverity(secret.Severity),
+				Location: sdk.Location{
+					Path:      p.findingPath(result.Target),
+					StartLine: secret.StartLine,
+					EndLine:   secret.EndLine,
+				},
+			})
+		}
+
 		for _, vuln := range result.Vulnerabilities {
 			vulnCounts[vuln.Severity]++
 			totalVulns++
+			findings = append(findings, sdk.Finding{
+				Tool:        "trivy",
+				RuleID:      vuln.VulnerabilityID,
+				Title:       vuln.Title,
+				Description: vuln.Description,
+				Severity:    sdk.NormalizeSeverity(vuln.Severity),
+				Location: sdk.Location{
+					Path:    p.findingPath(result.Target),
+					Package: vuln.PkgName,
+					Version: vuln.InstalledVersion,
+				},
+				CWEs:    vuln.CweIDs,
+				CVEs:    cves(vuln.VulnerabilityID),
+				HelpURL: vuln.PrimaryURL,
+			})
 		}
 	}
 
@@ -130,19 +313,31 @@ func (p *TrivyContainerScanPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resu
 		ExitCode: 0,
 		Metadata: make(map[string]interface{}),
 		Output:   string(output),
+		Findings: findings,
 	}
 
 	if totalVulns > 0 {
 		result.ExitCode = p.exitCode
-		result.ErrorMessage = fmt.Sprintf("Found %d vulnerabilities", totalVulns)
+		result.ErrorMessage = fmt.Sprintf("Found %d %s", totalVulns, p.findingKind())
 	}
 
 	// Add vulnerability counts to metadata
 	result.Metadata["total_vulnerabilities"] = totalVulns
 	result.Metadata["vulnerabilities_by_severity"] = vulnCounts
-	result.Metadata["scanned_image"] = p.image
+	result.Metadata["scan_mode"] = p.mode
+	if p.mode == modeImage {
+		result.Metadata["scanned_image"] = p.image
+	} else {
+		result.Metadata["scanned_path"] = p.path
+	}
 
-	ctx.Logger.Info(fmt.Sprintf("Trivy scan complete. Found %d vulnerabilities", totalVulns))
+	if p.sbomFile != "" && err == nil {
+		if sbomErr := p.attachSBOM(ctx, output, result); sbomErr != nil {
+			return failure(fmt.Sprintf("Failed to generate SBOM: %v", sbomErr)), sbomErr
+		}
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Trivy scan complete. Found %d %s", totalVulns, p.findingKind()))
 	for severity, count := range vulnCounts {
 		ctx.Logger.Info(fmt.Sprintf("  %s: %d", severity, count))
 	}
@@ -150,37 +345,86 @@ func (p *TrivyContainerScanPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Resu
 	return result, nil
 }
 
-func (p *TrivyContainerScanPlugin) Cleanup() error {
+// attachSBOM writes the SBOM, and the VEX if enabled, and attaches them to
+// the result. Artifacts are identified by their digest, which is recorded in
+// the metadata so later steps can sign or promote them.
+func (p *TrivyContainerScanPlugin) attachSBOM(ctx *sdk.ExecutionContext, report []byte, result *sdk.Result) error {
+	sbomPath := filepath.Join(ctx.WorkDir, p.sbomFile)
+	if err := p.writeSBOM(ctx, report, sbomPath); err != nil {
+		return err
+	}
+	artifact, err := newArtifact(sbomPath, "sbom")
+	if err != nil {
+		return err
+	}
+	result.Artifacts = append(result.Artifacts, artifact)
+	result.Metadata["sbom_file"] = p.sbomFile
+	result.Metadata["sbom_artifact_id"] = "sha256:" + artifact.ChecksumSHA256
+	ctx.Logger.Info(fmt.Sprintf("SBOM generated: %s", p.sbomFile))
+
+	if p.vexFile == "" {
+		return nil
+	}
+	vexPath := filepath.Join(ctx.WorkDir, p.vexFile)
+	if err := writeVEX(report, sbomPath, vexPath); err != nil {
+		return fmt.Errorf("failed to write VEX: %w", err)
+	}
+	artifact, err = newArtifact(vexPath, "vex")
+	if err != nil {
+		return err
+	}
+	result.Artifacts = append(result.Artifacts, artifact)
+	result.Metadata["vex_file"] = p.vexFile
+	result.Metadata["vex_artifact_id"] = "sha256:" + artifact.ChecksumSHA256
+	ctx.Logger.Info(fmt.Sprintf("VEX generated: %s", p.vexFile))
 	return nil
 }
 
-// Helper functions
-func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
-	if val, ok := config[key].(string); ok {
-		return val
+// findingKind names what the scan mode finds, for messages
+func (p *TrivyContainerScanPlugin) findingKind() string {
+	switch p.mode {
+	case modeConfig:
+		return "misconfigurations"
+	case modeSecret:
+		return "secrets"
+	}
+	return "vulnerabilities"
+}
+
+// findingPath returns the path of a scanned file relative to the workspace.
+// Trivy reports files relative to the scanned directory, and image targets
+// as the image and layer.
+func (p *TrivyContainerScanPlugin) findingPath(target string) string {
+	if p.mode == modeImage {
+		return target
 	}
-	return defaultValue
+	return filepath.ToSlash(filepath.Join(p.path, target))
 }
 
-func getIntConfig(config map[string]interface{}, key string, defaultValue int) int {
-	if val, ok := config[key].(float64); ok {
-		return int(val)
+// cves returns a vulnerability ID as a CVE list if it is a CVE; Trivy also
+// reports GHSA and distribution advisory IDs
+func cves(id string) []string {
+	if strings.HasPrefix(id, "CVE-") {
+		return []string{id}
 	}
-	return defaultValue
+	return nil
 }
 
-func getBoolConfig(config map[string]interface{}, key string, defaultValue bool) bool {
-	if val, ok := config[key].(bool); ok {
-		return val
+func failure(msg string) *sdk.Result {
+	return &sdk.Result{
+		Success:      false,
+		ExitCode:     1,
+		ErrorMessage: msg,
 	}
-	return defaultValue
+}
+
+func (p *TrivyContainerScanPlugin) Cleanup() error {
+	return nil
 }
 
 // Export the plugin
 var Plugin TrivyContainerScanPlugin
 
 func main() {
-	fmt.Println("Trivy Container Scan Plugin v1.0.0")
-	fmt.Println("This plugin scans container images for vulnerabilities using Trivy")
-	fmt.Println("To build: go build -o trivy-container-scan")
+	sdk.Serve(&Plugin)
 }
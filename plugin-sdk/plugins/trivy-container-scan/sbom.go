@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// vexVulnerability is the subset of a Trivy vulnerability a VEX document needs
+type vexVulnerability struct {
+	VulnerabilityID string `json:"VulnerabilityID"`
+	PkgName         string `json:"PkgName"`
+	PkgIdentifier   struct {
+		PURL   string `json:"PURL"`
+		BOMRef string `json:"BOMRef"`
+	} `json:"PkgIdentifier"`
+	FixedVersion string `json:"FixedVersion"`
+	Severity     string `json:"Severity"`
+	PrimaryURL   string `json:"PrimaryURL"`
+}
+
+// writeSBOM converts a Trivy JSON report, scanned with --list-all-pkgs, to a
+// CycloneDX SBOM without scanning again
+func (p *TrivyContainerScanPlugin) writeSBOM(ctx *sdk.ExecutionContext, report []byte, path string) error {
+	tmp, err := os.CreateTemp("", "trivy-report-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(report); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	cmd := exec.CommandContext(ctx, "trivy", "convert", "--format", "cyclonedx", "--output", path, tmp.Name())
+	cmd.Dir = ctx.WorkDir
+	cmd.Stdout = ctx.Output
+	cmd.Stderr = ctx.Output
+	return cmd.Run()
+}
+
+// writeVEX writes a CycloneDX VEX document for the vulnerabilities in a Trivy
+// report. Each vulnerability refers to its component in the SBOM and starts
+// in the "in_triage" state, for reviewers to mark not_affected or exploitable
+// before the VEX is consumed with trivy --vex.
+func writeVEX(report []byte, sbomPath, path string) error {
+	var parsed struct {
+		Results []struct {
+			Vulnerabilities []vexVulnerability `json:"Vulnerabilities"`
+		} `json:"Results"`
+	}
+	if err := json.Unmarshal(report, &parsed); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(sbomPath)
+	if err != nil {
+		return err
+	}
+	var sbom struct {
+		SerialNumber string `json:"serialNumber"`
+		Version      int    `json:"version"`
+	}
+	if err := json.Unmarshal(data, &sbom); err != nil {
+		return err
+	}
+
+	vulnerabilities := []map[string]interface{}{}
+	for _, result := range parsed.Results {
+		for _, vuln := range result.Vulnerabilities {
+			ref := vuln.PkgIdentifier.BOMRef
+			if ref == "" {
+				ref = vuln.PkgIdentifier.PURL
+			}
+			// A BOM-Link names a component of another CycloneDX document
+			bomLink := fmt.Sprintf("urn:cdx:%s/%d#%s", strings.TrimPrefix(sbom.SerialNumber, "urn:uuid:"), sbom.Version, url.QueryEscape(ref))
+			v := map[string]interface{}{
+				"id":       vuln.VulnerabilityID,
+				"ratings":  []map[string]string{{"severity": sdk.NormalizeSeverity(vuln.Severity)}},
+				"analysis": map[string]string{"state": "in_triage"},
+				"affects":  []map[string]string{{"ref": bomLink}},
+			}
+			if vuln.PrimaryURL != "" {
+				v["source"] = map[string]string{"url": vuln.PrimaryURL}
+			}
+			if vuln.FixedVersion != "" {
+				v["recommendation"] = fmt.Sprintf("Upgrade %s to %s", vuln.PkgName, vuln.FixedVersion)
+			}
+			vulnerabilities = append(vulnerabilities, v)
+		}
+	}
+
+	vex := map[string]interface{}{
+		"bomFormat":   "CycloneDX",
+		"specVersion": "1.5",
+		"version":     1,
+		"metadata": map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		},
+		"vulnerabilities": vulnerabilities,
+	}
+	out, err := json.MarshalIndent(vex, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
+
+func newArtifact(path, kind string) (sdk.Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return sdk.Artifact{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sdk.Artifact{}, err
+	}
+	return sdk.Artifact{
+		Name:           filepath.Base(path),
+		Path:           path,
+		SizeBytes:      info.Size(),
+		ChecksumSHA256: hex.EncodeToString(h.Sum(nil)),
+		Metadata:       map[string]string{"kind": kind, "format": "cyclonedx-json"},
+	}, nil
+}
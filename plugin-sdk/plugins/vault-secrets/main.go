@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// VaultSecretsPlugin resolves secret references from HashiCorp Vault. A
+// reference is "<path>#<key>", such as "secret/data/app#token" for a KV v2
+// engine or "secret/app#token" for KV v1.
+type VaultSecretsPlugin struct {
+	address   string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func (p *VaultSecretsPlugin) Name() string {
+	return "vault-secrets"
+}
+
+func (p *VaultSecretsPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *VaultSecretsPlugin) Type() string {
+	return "secret"
+}
+
+func (p *VaultSecretsPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork}
+}
+
+func (p *VaultSecretsPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+	if err := cfg.Require("address"); err != nil {
+		return err
+	}
+
+	p.address = strings.TrimSuffix(cfg.String("address", ""), "/")
+	p.token = cfg.String("token", os.Getenv("VAULT_TOKEN"))
+	p.namespace = cfg.String("namespace", "")
+	p.client = &http.Client{Timeout: cfg.Duration("timeout", 30*time.Second)}
+
+	if p.token == "" {
+		return fmt.Errorf("no Vault token: set token in the config or VAULT_TOKEN")
+	}
+	return nil
+}
+
+func (p *VaultSecretsPlugin) Health() error {
+	req, err := http.NewRequest(http.MethodGet, p.address+"/v1/sys/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Vault is not reachable: %w", err)
+	}
+	resp.Body.Close()
+
+	// 429 and 473 are healthy standby nodes
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusTooManyRequests, 473:
+		return nil
+	case 503:
+		return fmt.Errorf("Vault is sealed")
+	}
+	return fmt.Errorf("Vault health check returned status %d", resp.StatusCode)
+}
+
+// Execute is never called for secret providers
+func (p *VaultSecretsPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	err := fmt.Errorf("vault-secrets is a secret provider and cannot run as a build step")
+	return &sdk.Result{Success: false, ExitCode: 1, ErrorMessage: err.Error()}, err
+}
+
+// ResolveSecret reads the key of a Vault secret
+func (p *VaultSecretsPlugin) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("reference must be <path>#<key>, got %q", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from Vault: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("Vault returned status %d for %s: %s", resp.StatusCode, path, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode Vault response: %w", err)
+	}
+
+	// KV v2 nests the secret's keys under data.data
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, isMetadata := data["metadata"]; isMetadata {
+			data = nested
+		}
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func (p *VaultSecretsPlugin) Cleanup() error {
+	return nil
+}
+
+func main() {
+	sdk.Serve(&VaultSecretsPlugin{})
+}
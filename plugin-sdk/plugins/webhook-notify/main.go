@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// WebhookNotifyPlugin sends the build's status to any HTTP endpoint. The body
+// is rendered from a Go template, headers can be templated or read from
+// secrets, and the body can be signed with HMAC-SHA256 so the receiver can
+// verify it came from the build. Run for an operational alert, it sends the
+// alert, rendered from alert_template.
+//
+//	plugins:
+//	  - name: webhook-notify
+//	    always_run: true
+//	    secrets: {WEBHOOK_SECRET: "vault:secret/data/ci/hooks#signing"}
+//	    config:
+//	      url: https://deploys.example.com/hooks/ci
+//	      on: [failed]
+//	      signing_secret: WEBHOOK_SECRET
+//	      headers: {X-Build: "{{.BuildID}}"}
+//	      template: |
+//	        {"text": {{json (printf "%s #%d %s" .Job .BuildNumber .Status)}}}
+type WebhookNotifyPlugin struct {
+	urls            []string
+	method          string
+	contentType     string
+	body            *template.Template
+	alertBody       *template.Template
+	headers         map[string]*template.Template
+	secretHeaders   map[string]string // header name to the name of the secret holding its value
+	signingSecret   string            // name of the secret holding the HMAC key
+	signatureHeader string
+	on              map[string]bool // build statuses to send, all if empty
+
+	client *http.Client
+}
+
+func (p *WebhookNotifyPlugin) Name() string {
+	return "webhook-notify"
+}
+
+func (p *WebhookNotifyPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *WebhookNotifyPlugin) Type() string {
+	return "notification"
+}
+
+func (p *WebhookNotifyPlugin) Capabilities() []string {
+	return []string{sdk.CapabilityNetwork, sdk.CapabilitySecrets}
+}
+
+func (p *WebhookNotifyPlugin) Initialize(config map[string]interface{}) error {
+	cfg := sdk.Config(config)
+
+	// url is a URL or a list of them
+	if cfg.Slice("url") != nil {
+		p.urls = cfg.StringSlice("url")
+	} else if u := cfg.String("url", ""); u != "" {
+		p.urls = []string{u}
+	} else {
+		p.urls = nil
+	}
+	if len(p.urls) == 0 {
+		return fmt.Errorf("url is required")
+	}
+	for _, raw := range p.urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url %q", raw)
+		}
+	}
+
+	p.method = strings.ToUpper(cfg.String("method", "POST"))
+	switch p.method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return fmt.Errorf("invalid method %q (expected POST, PUT or PATCH)", p.method)
+	}
+	p.contentType = cfg.String("content_type", "application/json")
+
+	var err error
+	if p.body, err = parseTemplate("template", cfg.String("template", defaultTemplate)); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	if p.alertBody, err = parseTemplate("alert_template", cfg.String("alert_template", defaultAlertTemplate)); err != nil {
+		return fmt.Errorf("invalid alert_template: %w", err)
+	}
+
+	p.headers = map[string]*template.Template{}
+	for name, value := range cfg.StringMap("headers") {
+		if p.headers[name], err = parseTemplate(name, value); err != nil {
+			return fmt.Errorf("invalid template for header %s: %w", name, err)
+		}
+	}
+	p.secretHeaders = cfg.StringMap("secret_headers")
+
+	p.signingSecret = cfg.String("signing_secret", "")
+	p.signatureHeader = cfg.String("signature_header", "X-Solvyd-Signature-256")
+
+	p.on = map[string]bool{}
+	for _, status := range cfg.StringSlice("on") {
+		switch status {
+		case "started", "passed", "failed":
+			p.on[status] = true
+		default:
+			return fmt.Errorf("invalid status %q in on (expected started, passed or failed)", status)
+		}
+	}
+
+	p.client = &http.Client{Timeout: cfg.Duration("timeout", 30*time.Second)}
+	return nil
+}
+
+func (p *WebhookNotifyPlugin) Health() error {
+	return nil
+}
+
+func (p *WebhookNotifyPlugin) Execute(ctx *sdk.ExecutionContext) (*sdk.Result, error) {
+	data := newPayloadData(ctx)
+	tmpl, what := p.body, "build "+data.Status
+	if data.Alert != nil {
+		// on filters build statuses; alerts are always sent
+		tmpl, what = p.alertBody, "alert "+data.Alert.Kind+" "+data.Status
+	} else if len(p.on) > 0 && !p.on[data.Status] {
+		ctx.Logger.Info(fmt.Sprintf("Build %s, not sending the webhook", data.Status))
+		return &sdk.Result{Success: true, Output: "Webhook not sent", Metadata: map[string]interface{}{"status": data.Status, "sent": 0}}, nil
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return failure(fmt.Sprintf("Failed to render the template: %v", err)), err
+	}
+	if strings.Contains(p.contentType, "json") && !json.Valid(body.Bytes()) {
+		err := fmt.Errorf("the template did not render valid JSON: %s", truncate(200, body.String()))
+		return failure(err.Error()), err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", p.contentType)
+	header.Set("User-Agent", "solvyd-webhook-notify/"+p.Version())
+	for name, tmpl := range p.headers {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return failure(fmt.Sprintf("Failed to render header %s: %v", name, err)), err
+		}
+		header.Set(name, value.String())
+	}
+	for name, secret := range p.secretHeaders {
+		value, err := ctx.Secret(secret)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to get secret for header %s: %v", name, err)), err
+		}
+		header.Set(name, value)
+	}
+	if p.signingSecret != "" {
+		key, err := ctx.Secret(p.signingSecret)
+		if err != nil {
+			return failure(fmt.Sprintf("Failed to get signing secret: %v", err)), err
+		}
+		header.Set(p.signatureHeader, sign([]byte(key), body.Bytes()))
+	}
+
+	// Send to every URL, and fail the step if any of them failed
+	var failed []string
+	for _, u := range p.urls {
+		if err := p.send(ctx, u, header, body.Bytes()); err != nil {
+			ctx.Logger.Error(fmt.Sprintf("Webhook %s failed: %v", redact(u), err))
+			failed = append(failed, fmt.Sprintf("%s: %v", redact(u), err))
+			continue
+		}
+		ctx.Logger.Info(fmt.Sprintf("Sent %s to %s", what, redact(u)))
+	}
+
+	metadata := map[string]interface{}{"status": data.Status, "sent": len(p.urls) - len(failed)}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		err := fmt.Errorf("%d of %d webhooks failed: %s", len(failed), len(p.urls), strings.Join(failed, "; "))
+		result := failure(err.Error())
+		result.Metadata = metadata
+		return result, err
+	}
+	return &sdk.Result{Success: true, Output: fmt.Sprintf("Sent %d webhooks", len(p.urls)), Metadata: metadata}, nil
+}
+
+func (p *WebhookNotifyPlugin) send(ctx context.Context, u string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, p.method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			ue.URL = redact(ue.URL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if msg := strings.TrimSpace(string(data)); msg != "" {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+		}
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *WebhookNotifyPlugin) Cleanup() error {
+	return nil
+}
+
+// sign returns the HMAC-SHA256 signature of the body, as "sha256=" and the
+// hex digest
+func sign(key, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// redact drops the query and user info of a URL for logging, as they often
+// carry tokens
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "webhook"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+func failure(message string) *sdk.Result {
+	return &sdk.Result{Success: false, ErrorMessage: message}
+}
+
+// Export the plugin
+var Plugin WebhookNotifyPlugin
+
+// Serve the plugin to the worker agent
+func main() {
+	sdk.Serve(&Plugin)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/solvyd/solvyd/plugin-sdk/pkg/sdk"
+)
+
+// defaultTemplate is the payload sent when no template is configured
+const defaultTemplate = `{
+  "job": {{json .Job}},
+  "build_id": {{json .BuildID}},
+  "build_number": {{.BuildNumber}},
+  "status": {{json .Status}},
+  "branch": {{json .Branch}},
+  "commit": {{json .Commit}},
+  "labels": {{json .Labels}},
+  "steps": {{json .Steps}}
+}`
+
+// defaultAlertTemplate is the payload sent for an alert when no alert
+// template is configured
+const defaultAlertTemplate = `{
+  "alert_id": {{json .Alert.ID}},
+  "kind": {{json .Alert.Kind}},
+  "subject": {{json .Alert.Subject}},
+  "status": {{json .Status}},
+  "message": {{json .Alert.Message}},
+  "details": {{json .Alert.Details}},
+  "timestamp": {{json .Timestamp}}
+}`
+
+// payloadData is what templates render. Field names are the template's
+// names; the JSON names are used when a value is passed through json.
+type payloadData struct {
+	Job         string            `json:"job"`
+	JobID       string            `json:"job_id"`
+	BuildID     string            `json:"build_id"`
+	BuildNumber int               `json:"build_number"`
+	Status      string            `json:"status"` // started, passed or failed; for an alert, fired or resolved
+	Branch      string            `json:"branch"`
+	Commit      string            `json:"commit"`
+	ShortCommit string            `json:"short_commit"`
+	Labels      map[string]string `json:"labels"`
+	Steps       []stepData        `json:"steps"`
+	FailedSteps []stepData        `json:"failed_steps"`
+	Events      []string          `json:"events"`          // for a job notification, the build events it fired for
+	Alert       *alertData        `json:"alert,omitempty"` // set when run for an operational alert
+	Timestamp   string            `json:"timestamp"`       // RFC 3339, UTC
+}
+
+type alertData struct {
+	ID      string                 `json:"id"`
+	Kind    string                 `json:"kind"`    // worker_offline, queue_wait or no_capacity
+	Subject string                 `json:"subject"` // the worker ID or pool
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details"`
+}
+
+type stepData struct {
+	Name         string `json:"name"`
+	Status       string `json:"status"` // success, failed or skipped
+	DurationMs   int    `json:"duration_ms"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// funcs are the functions available to templates besides the built-in ones
+var funcs = template.FuncMap{
+	// json encodes a value as JSON, so strings are quoted and escaped
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"truncate": truncate,
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" || v == 0 {
+			return def
+		}
+		return v
+	},
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// newPayloadData reads the build's state from the parameters the worker agent
+// passes to the step
+func newPayloadData(ctx *sdk.ExecutionContext) payloadData {
+	params := sdk.Config(ctx.Parameters)
+	d := payloadData{
+		Job:         params.String("job_name", ""),
+		JobID:       ctx.JobID,
+		BuildID:     ctx.BuildID,
+		BuildNumber: params.Int("build_number", 0),
+		Branch:      params.String("branch", ""),
+		Commit:      params.String("commit_sha", ""),
+		Labels:      params.StringMap("job_labels"),
+		Steps:       []stepData{},
+		FailedSteps: []stepData{},
+		Events:      params.StringSlice("notification_events"),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if d.Events == nil {
+		d.Events = []string{}
+	}
+	d.ShortCommit = d.Commit
+	if len(d.ShortCommit) > 7 {
+		d.ShortCommit = d.ShortCommit[:7]
+	}
+	if d.Labels == nil {
+		d.Labels = map[string]string{}
+	}
+	for _, s := range params.Maps("steps") {
+		step := stepData{
+			Name:         s.String("name", ""),
+			Status:       s.String("status", ""),
+			DurationMs:   s.Int("duration_ms", 0),
+			ErrorMessage: s.String("error_message", ""),
+		}
+		d.Steps = append(d.Steps, step)
+		if step.Status == "failed" {
+			d.FailedSteps = append(d.FailedSteps, step)
+		}
+	}
+
+	switch params.String("build_status", "") {
+	case "failed":
+		d.Status = "failed"
+	case "success": // a notification run after the build
+		d.Status = "passed"
+	default:
+		if len(d.Steps) == 0 {
+			d.Status = "started"
+		} else {
+			d.Status = "passed"
+		}
+	}
+
+	if alert := ctx.Alert(); alert != nil {
+		d.Alert = &alertData{
+			ID:      alert.ID,
+			Kind:    alert.Kind,
+			Subject: alert.Subject,
+			Message: alert.Message,
+			Details: alert.Details,
+		}
+		d.Status = alert.Event
+	}
+	return d
+}
+
+// truncate shortens s to at most n characters
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
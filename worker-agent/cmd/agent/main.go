@@ -16,55 +16,78 @@ import (
 	"github.com/solvyd/solvyd/worker-agent/internal/agent"
 	"github.com/solvyd/solvyd/worker-agent/internal/config"
 	"github.com/solvyd/solvyd/worker-agent/internal/executor"
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+	"github.com/solvyd/solvyd/worker-agent/internal/tracing"
 )
 
 func main() {
+	// The agent re-executes itself to confine sandboxed plugins
+	if len(os.Args) > 1 && os.Args[1] == plugin.SandboxCommand {
+		plugin.RunSandboxHelper(os.Args[2:])
+	}
+
 	// Initialize logger
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
 
-	// Command-line flags
-	var (
-		apiServer     = flag.String("api-server", getEnv("SOLVYD_API_URL", "http://localhost:8080"), "API server address")
-		workerName    = flag.String("name", getEnv("SOLVYD_WORKER_NAME", ""), "Worker name (defaults to hostname)")
-		maxConcurrent = flag.Int("max-concurrent", getEnvInt("SOLVYD_MAX_CONCURRENT_BUILDS", 2), "Maximum concurrent builds")
-		labels        = flag.StringSlice("label", []string{}, "Worker labels (key=value)")
-		logLevel      = flag.String("log-level", getEnv("SOLVYD_LOG_LEVEL", "info"), "Log level (debug, info, warn, error)")
-		isolationType = flag.String("isolation", getEnv("SOLVYD_ISOLATION", "docker"), "Build isolation type (docker, process, vm)")
-	)
+	// Command-line flags; each can also be set by an environment variable or
+	// in the config file
+	flag.String("config", "", "Config file (YAML; default agent.yaml in ., ./config or /etc/solvyd)")
+	flag.String("api-server", "http://localhost:8080", "API server address")
+	flag.String("log-server", "", "Log ingestion address (default: the API server)")
+	flag.String("name", "", "Worker name (defaults to hostname)")
+	flag.Int("max-concurrent", 2, "Maximum concurrent builds")
+	flag.String("pool", "", "Worker pool to join (default pool if empty)")
+	flag.StringSlice("label", []string{}, "Worker labels (key=value)")
+	flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	flag.String("isolation", "docker", "Build isolation type (docker, process, vm)")
+	flag.String("plugin-dir", "./plugins", "Directory containing plugin binaries")
+	flag.String("plugin-policy", "", "Plugin sandbox policy file (JSON)")
+	flag.String("secret-providers", "", "Secret provider file (JSON)")
+	flag.String("cache-backend", "", "Cache backend file (JSON)")
+	flag.String("tracing-endpoint", "", "OpenTelemetry OTLP/HTTP collector (host:port)")
+	flag.Bool("tracing-insecure", true, "Export traces over HTTP instead of HTTPS")
+	flag.Bool("one-shot", false, "Run a single build, then deregister and exit")
+	flag.String("tool-dir", "./tools", "Directory the tools builds declare are installed in")
+	flag.Int64("build-disk-quota-mb", 0, "Disk quota of each build's workspace in MB (0 for none)")
+	flag.Int64("worker-disk-quota-mb", 0, "Disk quota of all workspaces and the cache directory in MB (0 for none)")
+	flag.String("proxy", "", "Proxy for the API server connection (default: HTTP_PROXY/HTTPS_PROXY)")
+	flag.String("ca-cert", "", "CA bundle (PEM) trusted besides the system CAs")
+	flag.Bool("tls-skip-verify", false, "Do not verify the API server's TLS certificate (insecure)")
 
 	flag.Parse()
 
+	loader, err := config.NewLoader(flag.CommandLine)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up configuration")
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
 	// Set log level
-	level, err := zerolog.ParseLevel(*logLevel)
+	level, err := zerolog.ParseLevel(cfg.LogLevel)
 	if err != nil {
 		level = zerolog.InfoLevel
 	}
 	zerolog.SetGlobalLevel(level)
 
 	log.Info().Msg("Starting Solvyd Worker Agent")
+	if file := loader.ConfigFile(); file != "" {
+		log.Info().Str("file", file).Msg("Loaded config file")
+	}
 
 	// Generate or use worker name
-	if *workerName == "" {
+	if cfg.WorkerName == "" {
 		hostname, _ := os.Hostname()
-		*workerName = fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8])
+		cfg.WorkerName = fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8])
 	}
 
-	// Parse labels
-	labelMap := make(map[string]string)
-	for _, label := range *labels {
-		// Parse label in format key=value
-		// Simplified for now
-		labelMap["custom"] = label
-	}
-
-	// Create config
-	cfg := &config.Config{
-		APIServer:     *apiServer,
-		WorkerName:    *workerName,
-		MaxConcurrent: *maxConcurrent,
-		Labels:        labelMap,
-		IsolationType: *isolationType,
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingEndpoint, cfg.TracingInsecure)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
 	}
 
 	// Create executor
@@ -83,35 +106,64 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go agent.Start(ctx)
-
-	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
 
-	log.Info().Msg("Shutting down worker agent...")
-	cancel()
-	// Give it time to finish current builds
-	time.Sleep(5 * time.Second)
+	// Reload labels and max concurrent builds on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			next, err := loader.Load()
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to reload configuration")
+				continue
+			}
+			if err := agent.Reload(ctx, next); err != nil {
+				log.Error().Err(err).Msg("Failed to apply reloaded configuration")
+			}
+		}
+	}()
+
+	exitCode := 0
+	if cfg.OneShot {
+		// Exit once the build is done, or stop it on an interrupt signal
+		done := make(chan error, 1)
+		go func() { done <- agent.RunOnce(ctx) }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-quit:
+			log.Info().Msg("Shutting down worker agent...")
+			cancel()
+			err = <-done
+		}
+		if err != nil && err != context.Canceled {
+			log.Error().Err(err).Msg("One-shot run failed")
+			exitCode = 1
+		}
+	} else {
+		go agent.Start(ctx)
 
-	log.Info().Msg("Worker agent exited")
-}
+		// Wait for interrupt signal
+		<-quit
 
-// getEnv gets environment variable with a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+		log.Info().Msg("Shutting down worker agent...")
+		cancel()
+		// Give it time to finish current builds
+		time.Sleep(5 * time.Second)
 	}
-	return defaultValue
-}
 
-// getEnvInt gets environment variable as int with a default value
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := fmt.Sscanf(value, "%d", &defaultValue); err == nil && intVal == 1 {
-			return defaultValue
-		}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Warn().Err(err).Msg("Failed to flush traces")
+	}
+
+	log.Info().Msg("Worker agent exited")
+	if exitCode != 0 {
+		cancelFlush()
+		os.Exit(exitCode)
 	}
-	return defaultValue
 }
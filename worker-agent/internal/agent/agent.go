@@ -10,23 +10,46 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/solvyd/solvyd/worker-agent/internal/config"
 	"github.com/solvyd/solvyd/worker-agent/internal/executor"
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+	"github.com/solvyd/solvyd/worker-agent/internal/tools"
+	"github.com/solvyd/solvyd/worker-agent/internal/tracing"
 )
 
 // Agent represents the worker agent
 type Agent struct {
 	config        *config.Config
 	executor      executor.Executor
+	plugins       *plugin.Manager
 	workerID      uuid.UUID
+	credential    string // issued at registration; fetches the builds assigned to the worker
 	client        *http.Client
+	transfers     *http.Client // for stage outputs, which take longer than API calls
 	apiURL        string
+	logURL        string // where build logs are sent, the API server or log ingestion
 	currentBuilds int
+
+	// Directory of the cache backend, counted in the worker's disk quota;
+	// empty if the backend does not keep its entries in a local directory
+	cacheDir string
+
+	// The builds running, whose resource usage heartbeats report, by ID
+	// with the function that stops them, and the disk usage last measured.
+	// mu also guards the config's labels and max concurrent builds, which
+	// are reloaded while the agent runs.
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+	disk    diskUsage
 }
 
 // NewAgent creates a new worker agent
@@ -39,24 +62,82 @@ func NewAgent(cfg *config.Config, exec executor.Executor) (*Agent, error) {
 	// Estimate memory (simplified)
 	cfg.MemoryMB = 8192 // TODO: Actually detect memory
 
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
 	client := &http.Client{
-		Timeout: 30 * time.Second,
+		Timeout:   30 * time.Second,
+		Transport: &tracing.Transport{Base: transport},
 	}
 
-	// Use API server URL directly if it already has a scheme, otherwise add http://
-	apiURL := cfg.APIServer
-	if !strings.HasPrefix(apiURL, "http://") && !strings.HasPrefix(apiURL, "https://") {
-		apiURL = "http://" + apiURL
+	apiURL := serverURL(cfg.APIServer)
+	logURL := apiURL
+	if cfg.LogServer != "" {
+		logURL = serverURL(cfg.LogServer)
+	}
+
+	plugins := plugin.NewManager(cfg.PluginDir)
+	plugins.SetRemote(apiURL, transport)
+	if cfg.PluginPolicy != "" {
+		policies, err := plugin.LoadPolicies(cfg.PluginPolicy)
+		if err != nil {
+			return nil, err
+		}
+		plugins.SetPolicies(policies)
+	}
+	if cfg.SecretProviders != "" {
+		providers, err := plugin.LoadSecretProviders(cfg.SecretProviders)
+		if err != nil {
+			return nil, err
+		}
+		plugins.SetSecretProviders(providers)
+	}
+	cacheDir := ""
+	if cfg.CacheBackend != "" {
+		backend, err := plugin.LoadCacheBackend(cfg.CacheBackend)
+		if err != nil {
+			return nil, err
+		}
+		plugins.SetCacheBackend(backend)
+		cacheDir, _ = backend.Config["dir"].(string)
+	}
+	if toolExec, ok := exec.(executor.ToolInstaller); ok {
+		installer, err := tools.NewInstaller(cfg.ToolDir, transport)
+		if err != nil {
+			return nil, err
+		}
+		toolExec.SetTools(installer)
+	}
+	if err := plugins.Discover(); err != nil {
+		log.Warn().Err(err).Str("dir", cfg.PluginDir).Msg("No plugins available")
 	}
 
 	return &Agent{
 		config:   cfg,
 		executor: exec,
+		plugins:  plugins,
 		client:   client,
+		transfers: &http.Client{
+			Timeout:   30 * time.Minute,
+			Transport: &tracing.Transport{Base: transport},
+		},
 		apiURL:   apiURL,
+		logURL:   logURL,
+		cacheDir: cacheDir,
+		running:  map[string]context.CancelCauseFunc{},
 	}, nil
 }
 
+// serverURL returns a server address as a URL: directly if it already has a
+// scheme, otherwise with http://
+func serverURL(addr string) string {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		return "http://" + addr
+	}
+	return addr
+}
+
 // Start begins the agent main loop
 func (a *Agent) Start(ctx context.Context) {
 	log.Info().
@@ -72,20 +153,37 @@ func (a *Agent) Start(ctx context.Context) {
 	// Start heartbeat
 	go a.heartbeatLoop(ctx)
 
+	// Keep installed plugins up to date
+	go a.pluginSyncLoop(ctx)
+
+	// Enforce the disk quotas
+	go a.diskQuotaLoop(ctx)
+
+	// Deliver the API server's alert notifications; a one-shot agent only
+	// runs its build
+	if !a.config.OneShot {
+		go a.alertLoop(ctx)
+	}
+
 	// Start polling for builds
 	a.pollLoop(ctx)
 }
 
 // register registers the worker with the API server
 func (a *Agent) register(ctx context.Context) error {
+	a.mu.Lock()
+	maxConcurrent, labels := a.config.MaxConcurrent, a.config.Labels
+	a.mu.Unlock()
+
 	payload := map[string]interface{}{
 		"name":                  a.config.WorkerName,
 		"hostname":              a.config.Hostname,
 		"ip_address":            a.config.IPAddress,
-		"max_concurrent_builds": a.config.MaxConcurrent,
+		"max_concurrent_builds": maxConcurrent,
+		"pool":                  a.config.Pool,
 		"cpu_cores":             a.config.CPUCores,
 		"memory_mb":             a.config.MemoryMB,
-		"labels":                a.config.Labels,
+		"labels":                labels,
 		"agent_version":         "1.0.0",
 		"capabilities": map[string]bool{
 			"docker":     a.config.IsolationType == "docker",
@@ -123,6 +221,7 @@ func (a *Agent) register(ctx context.Context) error {
 			a.workerID = workerID
 		}
 	}
+	a.credential, _ = result["credential"].(string)
 
 	log.Info().
 		Str("worker_id", a.workerID.String()).
@@ -149,6 +248,24 @@ func (a *Agent) heartbeatLoop(ctx context.Context) {
 	}
 }
 
+// pluginSyncLoop downloads plugins installed on the API server
+func (a *Agent) pluginSyncLoop(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		if err := a.plugins.Sync(ctx); err != nil {
+			log.Warn().Err(err).Msg("Failed to sync installed plugins")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // sendHeartbeat sends a heartbeat to the API server
 func (a *Agent) sendHeartbeat(ctx context.Context) error {
 	if a.workerID == uuid.Nil {
@@ -158,6 +275,7 @@ func (a *Agent) sendHeartbeat(ctx context.Context) error {
 	payload := map[string]interface{}{
 		"current_builds": a.currentBuilds,
 		"health_status":  "healthy",
+		"resources":      a.resources(ctx),
 	}
 
 	body, _ := json.Marshal(payload)
@@ -186,8 +304,8 @@ func (a *Agent) sendHeartbeat(ctx context.Context) error {
 		return err
 	}
 
-	// Check if there's work available
-	if hasWork, ok := result["has_work"].(bool); ok && hasWork {
+	// Check if there's work available; a one-shot agent takes its build itself
+	if hasWork, ok := result["has_work"].(bool); ok && hasWork && !a.config.OneShot {
 		log.Debug().Msg("Work available for this worker")
 		// Trigger immediate poll
 		go a.checkForBuilds(ctx)
@@ -207,7 +325,7 @@ func (a *Agent) pollLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if a.currentBuilds < a.config.MaxConcurrent {
+			if a.currentBuilds < a.maxConcurrent() && !a.diskFull() {
 				a.checkForBuilds(ctx)
 			}
 		}
@@ -216,36 +334,11 @@ func (a *Agent) pollLoop(ctx context.Context) {
 
 // checkForBuilds checks if there are builds assigned to this worker
 func (a *Agent) checkForBuilds(ctx context.Context) {
-	if a.workerID == uuid.Nil {
-		return
-	}
-
-	// Fetch builds assigned to this worker
-	url := fmt.Sprintf("%s/api/v1/workers/%s/builds", a.apiURL, a.workerID.String())
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to create builds request")
-		return
-	}
-
-	resp, err := a.client.Do(req)
+	builds, err := a.fetchBuilds(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to fetch builds")
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Warn().Int("status", resp.StatusCode).Msg("Failed to fetch builds")
-		return
-	}
-
-	var builds []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&builds); err != nil {
-		log.Error().Err(err).Msg("Failed to decode builds response")
-		return
-	}
 
 	if len(builds) == 0 {
 		log.Debug().Msg("No pending builds")
@@ -256,16 +349,54 @@ func (a *Agent) checkForBuilds(ctx context.Context) {
 
 	// Execute builds (up to max concurrent limit)
 	for _, buildData := range builds {
-		if a.currentBuilds >= a.config.MaxConcurrent {
+		if a.currentBuilds >= a.maxConcurrent() {
 			log.Warn().Msg("Max concurrent builds reached, stopping")
 			break
 		}
+		if a.diskFull() {
+			log.Warn().Msg("Disk quota reached, stopping")
+			break
+		}
 
 		a.currentBuilds++
 		go a.executeBuild(ctx, buildData)
 	}
 }
 
+// fetchBuilds returns the builds assigned to this worker
+func (a *Agent) fetchBuilds(ctx context.Context) ([]map[string]interface{}, error) {
+	if a.workerID == uuid.Nil {
+		return nil, nil
+	}
+
+	url := fmt.Sprintf("%s/api/v1/workers/%s/builds", a.apiURL, a.workerID.String())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.credential)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("builds request rejected: the worker's credential is no longer valid, e.g. after another agent registered with its name")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("builds request failed with status %d", resp.StatusCode)
+	}
+
+	var builds []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&builds); err != nil {
+		return nil, err
+	}
+	return builds, nil
+}
+
 // executeBuild executes a single build
 func (a *Agent) executeBuild(ctx context.Context, buildData map[string]interface{}) {
 	defer func() {
@@ -275,12 +406,23 @@ func (a *Agent) executeBuild(ctx context.Context, buildData map[string]interface
 	buildID := buildData["id"].(string)
 	log.Info().Str("build_id", buildID).Msg("Starting build execution")
 
+	// Continue the trace the build was queued in, so that the API calls made
+	// for the build are part of it too
+	ctx, span := tracing.Tracer().Start(tracing.FromCarrier(ctx, traceCarrier(buildData)), "build.execute",
+		trace.WithAttributes(
+			attribute.String("build.id", buildID),
+			attribute.String("job.name", getStringOrEmpty(buildData, "job_name")),
+		))
+	defer span.End()
+	stageSpans := map[string]trace.Span{}
+
 	// Update build status to running
 	if err := a.updateBuildStatus(ctx, buildID, "running", map[string]interface{}{
 		"started_at": time.Now().Format(time.RFC3339),
 	}); err != nil {
 		log.Error().Err(err).Str("build_id", buildID).Msg("Failed to update build status to running")
 	}
+	a.recordEvent(ctx, buildID, eventStarted, "", "", nil)
 
 	// Extract build information
 	buildConfig := buildData["build_config"].(map[string]interface{})
@@ -291,11 +433,116 @@ func (a *Agent) executeBuild(ctx context.Context, buildData map[string]interface
 		SCMBranch:   getStringOrEmpty(buildData, "branch"),
 		CommitSHA:   getStringOrEmpty(buildData, "commit_sha"),
 		BuildConfig: buildConfig,
-		EnvVars:     make(map[string]string),
+		EnvVars:     getStringMap(buildData, "environment_vars"),
+		// Stage when expressions compare with the previous successful
+		// build and test the build's parameters
+		PreviousCommitSHA: getStringOrEmpty(buildData, "previous_commit_sha"),
+		Parameters:        buildParameters(buildData),
+		OnEvent: func(event, stage, status string) {
+			a.recordEvent(ctx, buildID, event, stage, status, nil)
+			switch event {
+			case executor.StageStarted:
+				_, stageSpans[stage] = tracing.Tracer().Start(ctx, "build.stage",
+					trace.WithAttributes(attribute.String("stage.name", stage)))
+			case executor.StageFinished:
+				if stageSpan, ok := stageSpans[stage]; ok {
+					if status == "failed" {
+						stageSpan.SetStatus(codes.Error, status)
+					}
+					stageSpan.End()
+					delete(stageSpans, stage)
+				}
+			}
+		},
 	}
 
-	// Execute the build
-	result, err := a.executor.Execute(ctx, buildRequest)
+	// Upload the build log to the API server as it is produced, with the
+	// values of secrets the job's configuration references masked
+	buildLog := a.newBuildLog(ctx, buildID, getStringList(buildData, "masked_values"))
+
+	// Stop the build and its plugins if it is cancelled through the API, or
+	// if it exceeds a disk quota
+	buildCtx, stopBuild := context.WithCancelCause(ctx)
+	defer stopBuild(nil)
+	go a.watchCancellation(buildCtx, buildID, func() { stopBuild(nil) })
+
+	a.mu.Lock()
+	a.running[buildID] = stopBuild
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.running, buildID)
+		a.mu.Unlock()
+	}()
+
+	// A rerun from a failed stage resumes in the workspace the original
+	// build left, if this worker kept it
+	a.resumeRerun(buildCtx, buildData, buildRequest, buildLog)
+
+	// Stages store their outputs, for reruns on other workers to restore
+	buildRequest.SaveOutputs = func(stage string, outputs []string, workDir string) error {
+		return a.saveStageOutputs(buildCtx, buildID, stage, outputs, workDir)
+	}
+
+	// Jobs with a pipeline file run the stages and plugin steps it defines at
+	// the build's commit, which are known once the repository is checked out
+	pipelineFile := getStringOrEmpty(buildData, "pipeline_file")
+	if pipelineFile != "" {
+		buildRequest.OnCheckout = func(workDir string) error {
+			buildLog.Add("stdout", "[INFO] Reading pipeline from "+pipelineFile)
+			buildConfig, err := a.loadPipeline(buildCtx, buildData, workDir)
+			if err != nil {
+				return err
+			}
+			buildRequest.BuildConfig = buildConfig
+			return a.checkPluginSteps(buildCtx, buildData)
+		}
+	}
+
+	// Execute the build, unless a plugin step is known to fail
+	var result *executor.BuildResult
+	var err error
+	var checkErr error
+	if pipelineFile == "" {
+		checkErr = a.checkPluginSteps(buildCtx, buildData)
+	}
+	if checkErr != nil {
+		result = &executor.BuildResult{ExitCode: 1, ErrorMessage: checkErr.Error()}
+		buildLog.Add("stderr", "[ERROR] "+checkErr.Error())
+	} else {
+		result, err = a.executor.Execute(buildCtx, buildRequest)
+		buildLog.Add("stdout", result.LogLines...)
+	}
+	// End the spans of stages the build stopped in
+	for _, stageSpan := range stageSpans {
+		stageSpan.SetStatus(codes.Error, "interrupted")
+		stageSpan.End()
+	}
+
+	// Run the job's plugin steps in the build workspace; after a failed build
+	// command only those that set always_run
+	var summaries []interface{}
+	if err == nil && result.WorkDir != "" {
+		summaries = a.runPluginSteps(buildCtx, buildData, result, buildLog)
+	}
+
+	quotaErr := diskQuotaError(buildCtx)
+	cancelled := buildCtx.Err() != nil && ctx.Err() == nil && quotaErr == nil
+	stopBuild(nil)
+	if quotaErr != nil {
+		buildLog.Add("stderr", "[ERROR] "+quotaErr.Error())
+	}
+	if cancelled {
+		buildLog.Add("stderr", "[WARN] Build cancelled")
+		a.recordEvent(ctx, buildID, eventCancelled, "", "", nil)
+	} else if ctx.Err() == nil {
+		outcome := "success"
+		if err != nil || !result.Success || quotaErr != nil {
+			outcome = "failure"
+		}
+		a.runNotifications(ctx, buildData, outcome, result.WorkDir, summaries, buildLog)
+	}
+	buildLog.Close()
 
 	// Update build status based on result
 	status := "success"
@@ -304,7 +551,17 @@ func (a *Agent) executeBuild(ctx context.Context, buildData map[string]interface
 		"duration_seconds": result.Duration,
 	}
 
-	if err != nil || !result.Success {
+	if cancelled {
+		status = "cancelled"
+		log.Info().Str("build_id", buildID).Msg("Build cancelled")
+	} else if quotaErr != nil {
+		status = "failure"
+		result.ErrorMessage = quotaErr.Error()
+		statusData["exit_code"] = result.ExitCode
+		statusData["error_message"] = result.ErrorMessage
+		statusData["failure_reason"] = failureDiskQuota
+		log.Error().Str("build_id", buildID).Msg("Build failed: disk quota exceeded")
+	} else if err != nil || !result.Success {
 		status = "failure"
 		statusData["exit_code"] = result.ExitCode
 		if result.ErrorMessage != "" {
@@ -322,20 +579,370 @@ func (a *Agent) executeBuild(ctx context.Context, buildData map[string]interface
 			Msg("Build completed successfully")
 	}
 
+	span.SetAttributes(attribute.String("build.status", status))
+	if status == "failure" {
+		span.SetStatus(codes.Error, result.ErrorMessage)
+	}
+
 	// Update final build status
 	if err := a.updateBuildStatus(ctx, buildID, status, statusData); err != nil {
 		log.Error().Err(err).Str("build_id", buildID).Msg("Failed to update final build status")
 	}
 
-	// TODO: Upload logs to API server
 	// TODO: Upload artifacts to storage (MinIO/S3)
 
+	// Keep the workspace of a failed pipeline, for a rerun from its failed
+	// stage to resume in
+	if status == "failure" && quotaErr == nil {
+		if stages, ok := buildRequest.BuildConfig["stages"].([]interface{}); ok && len(stages) > 0 {
+			if keeper, ok := a.executor.(executor.WorkspaceKeeper); ok {
+				if err := keeper.KeepWorkspace(buildID); err != nil {
+					log.Warn().Err(err).Str("build_id", buildID).Msg("Failed to keep build workspace")
+				}
+			}
+		}
+	}
+
 	// Cleanup
 	if err := a.executor.Cleanup(ctx, buildID); err != nil {
 		log.Warn().Err(err).Str("build_id", buildID).Msg("Failed to cleanup build resources")
 	}
 }
 
+// resumeRerun restores the workspace of the build a rerun from a failed
+// stage restarts, and limits the rerun to the stages to run again. Without
+// the workspace, e.g. on another worker, the executor restores the outputs
+// of the stages the rerun stages take as inputs instead, or else runs all
+// stages.
+func (a *Agent) resumeRerun(ctx context.Context, buildData map[string]interface{}, buildRequest *executor.BuildRequest, buildLog *buildLog) {
+	rerunOf := getStringOrEmpty(buildData, "rerun_of")
+	stages := getStringList(buildData, "rerun_stages")
+	if rerunOf == "" || len(stages) == 0 {
+		return
+	}
+
+	keeper, ok := a.executor.(executor.WorkspaceKeeper)
+	if !ok {
+		buildLog.Add("stdout", "[WARN] The executor cannot resume builds; running all stages")
+		return
+	}
+	restored, err := keeper.RestoreWorkspace(rerunOf, buildRequest.BuildID)
+	if err != nil {
+		log.Warn().Err(err).Str("build_id", buildRequest.BuildID).Msg("Failed to restore workspace of re-run build")
+	}
+	buildRequest.Stages = stages
+	if !restored {
+		buildLog.Add("stdout", fmt.Sprintf("[INFO] The workspace of build %s is not kept on this worker", rerunOf))
+		buildRequest.RestoreOutputs = func(stages []string, workDir string) error {
+			return a.restoreStageOutputs(ctx, rerunOf, stages, workDir)
+		}
+		return
+	}
+
+	buildRequest.Resume = true
+	buildLog.Add("stdout", fmt.Sprintf("[INFO] Re-running stages %s of build %s", strings.Join(stages, ", "), rerunOf))
+}
+
+// buildCancelPollInterval is how often a running build is checked for cancellation
+const buildCancelPollInterval = 5 * time.Second
+
+// watchCancellation polls the build's status and calls cancel once it has been
+// cancelled through the API. It returns when ctx is done.
+func (a *Agent) watchCancellation(ctx context.Context, buildID string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(buildCancelPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			status, err := a.getBuildStatus(ctx, buildID)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn().Err(err).Str("build_id", buildID).Msg("Failed to check build status")
+				}
+				continue
+			}
+			if status == "cancelled" {
+				log.Info().Str("build_id", buildID).Msg("Build was cancelled, stopping it")
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// getBuildStatus fetches the current status of a build from the API server
+func (a *Agent) getBuildStatus(ctx context.Context, buildID string) (string, error) {
+	url := fmt.Sprintf("%s/api/v1/builds/%s", a.apiURL, buildID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("build status request failed with code %d", resp.StatusCode)
+	}
+
+	var build struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&build); err != nil {
+		return "", err
+	}
+	return build.Status, nil
+}
+
+// checkPluginSteps verifies the job's plugins before the build starts, so a
+// missing tool or unreachable server fails the build immediately rather than
+// after it has run. Steps with continue_on_error only log a warning.
+func (a *Agent) checkPluginSteps(ctx context.Context, buildData map[string]interface{}) error {
+	steps, err := plugin.ParseSteps(buildData["plugins"])
+	if err != nil {
+		return fmt.Errorf("Invalid plugin configuration: %v", err)
+	}
+
+	buildID := getStringOrEmpty(buildData, "id")
+	for _, step := range steps {
+		err := a.plugins.Check(ctx, step)
+		if err == nil {
+			continue
+		}
+		if step.ContinueOnError {
+			log.Warn().Err(err).Str("build_id", buildID).Str("plugin", step.Name).Msg("Plugin check failed, continuing")
+			continue
+		}
+		log.Error().Err(err).Str("build_id", buildID).Str("plugin", step.Name).Msg("Plugin check failed")
+		return fmt.Errorf("Plugin check failed: %v", err)
+	}
+	return nil
+}
+
+// runPluginSteps runs the job's plugins in order. A step that still fails after
+// the retries its policy allows fails the build unless it sets
+// continue_on_error, and once the build has failed only steps that set
+// always_run are run.
+// Plugin logs and output are added to the build log as the plugins produce
+// them. Each plugin receives the build's status so far and a summary of the
+// steps before it in its parameters. It returns the summaries of all steps.
+func (a *Agent) runPluginSteps(ctx context.Context, buildData map[string]interface{}, result *executor.BuildResult, buildLog *buildLog) []interface{} {
+	summaries := []interface{}{}
+	steps, err := plugin.ParseSteps(buildData["plugins"])
+	if err != nil {
+		result.Success = false
+		result.ExitCode = 1
+		result.ErrorMessage = fmt.Sprintf("Invalid plugin configuration: %v", err)
+		return summaries
+	}
+
+	buildID := getStringOrEmpty(buildData, "id")
+	execCtx := newExecutionContext(buildData, result.WorkDir)
+
+	addLine := func(stream, line string) {
+		result.LogLines = append(result.LogLines, line)
+		buildLog.Add(stream, line)
+	}
+
+	failed := !result.Success
+	for _, step := range steps {
+		if failed && !step.AlwaysRun {
+			summaries = append(summaries, map[string]interface{}{"name": step.Name, "status": "skipped"})
+			continue
+		}
+
+		addLine("stdout", fmt.Sprintf("[INFO] Running plugin: %s", step.Name))
+		if failed {
+			execCtx.Parameters["build_status"] = "failed"
+		} else {
+			execCtx.Parameters["build_status"] = "running"
+		}
+		execCtx.Parameters["steps"] = append([]interface{}{}, summaries...)
+
+		started := time.Now()
+		a.recordEvent(ctx, buildID, eventStepStarted, step.Name, "", nil)
+		stepCtx, stepSpan := tracing.Tracer().Start(ctx, "plugin.step",
+			trace.WithAttributes(attribute.String("plugin.name", step.Name)))
+		stepResult, err := a.plugins.Run(stepCtx, step, execCtx, stepLogger(step.Name, addLine))
+		endStepSpan(stepSpan, stepResult, err)
+		summary := map[string]interface{}{
+			"name":        step.Name,
+			"status":      "success",
+			"duration_ms": time.Since(started).Milliseconds(),
+		}
+		summaries = append(summaries, summary)
+		if stepResult != nil {
+			summary["metadata"] = stepResult.Metadata
+			for _, artifact := range stepResult.Artifacts {
+				result.Artifacts = append(result.Artifacts, executor.Artifact{
+					Name:           artifact.Name,
+					Path:           artifact.Path,
+					SizeBytes:      artifact.SizeBytes,
+					ChecksumSHA256: artifact.ChecksumSHA256,
+				})
+				a.recordEvent(ctx, buildID, eventArtifact, artifact.Name, "", map[string]interface{}{
+					"step":       step.Name,
+					"size_bytes": artifact.SizeBytes,
+				})
+			}
+			if len(stepResult.Findings) > 0 {
+				newCounts, uploadErr := a.uploadFindings(ctx, buildID, step.Name, stepResult.Findings)
+				if uploadErr != nil {
+					log.Warn().Err(uploadErr).Str("build_id", buildID).Str("plugin", step.Name).Msg("Failed to upload findings")
+					addLine("stderr", fmt.Sprintf("[WARN] Failed to upload %d findings from %s: %v", len(stepResult.Findings), step.Name, uploadErr))
+				} else {
+					addLine("stdout", fmt.Sprintf("[INFO] Plugin %s reported %d findings", step.Name, len(stepResult.Findings)))
+				}
+
+				if step.FailOnFindings != "" && err == nil {
+					if uploadErr != nil {
+						stepResult.Success = false
+						stepResult.ErrorMessage = fmt.Sprintf("cannot check findings against the baseline: %v", uploadErr)
+					} else {
+						stepResult.Success, stepResult.ErrorMessage = gateFindings(step, newCounts)
+					}
+				}
+			}
+			if len(stepResult.TestCases) > 0 {
+				if uploadErr := a.uploadTestResults(ctx, buildID, step.Name, stepResult.TestCases); uploadErr != nil {
+					log.Warn().Err(uploadErr).Str("build_id", buildID).Str("plugin", step.Name).Msg("Failed to upload test results")
+					addLine("stderr", fmt.Sprintf("[WARN] Failed to upload %d test results from %s: %v", len(stepResult.TestCases), step.Name, uploadErr))
+				}
+			}
+			if len(stepResult.Annotations) > 0 {
+				if uploadErr := a.uploadAnnotations(ctx, buildID, step.Name, stepResult.Annotations); uploadErr != nil {
+					log.Warn().Err(uploadErr).Str("build_id", buildID).Str("plugin", step.Name).Msg("Failed to upload annotations")
+					addLine("stderr", fmt.Sprintf("[WARN] Failed to upload %d annotations from %s: %v", len(stepResult.Annotations), step.Name, uploadErr))
+				}
+				// Kept for the build's notifications
+				annotations, _ := buildData["annotations"].([]interface{})
+				buildData["annotations"] = append(annotations, annotationParameters(stepResult.Annotations)...)
+			}
+			if stepResult.Coverage != nil {
+				if uploadErr := a.uploadCoverage(ctx, buildID, step.Name, stepResult.Coverage); uploadErr != nil {
+					log.Warn().Err(uploadErr).Str("build_id", buildID).Str("plugin", step.Name).Msg("Failed to upload coverage")
+					addLine("stderr", fmt.Sprintf("[WARN] Failed to upload coverage from %s: %v", step.Name, uploadErr))
+				}
+			}
+			if tags := resultTags(stepResult.Metadata); len(tags) > 0 {
+				if uploadErr := a.uploadTags(ctx, buildID, tags); uploadErr != nil {
+					log.Warn().Err(uploadErr).Str("build_id", buildID).Str("plugin", step.Name).Msg("Failed to upload build tags")
+					addLine("stderr", fmt.Sprintf("[WARN] Failed to tag the build with %s from %s: %v", strings.Join(tags, ", "), step.Name, uploadErr))
+				} else {
+					addLine("stdout", fmt.Sprintf("[INFO] Plugin %s tagged the build: %s", step.Name, strings.Join(tags, ", ")))
+				}
+			}
+			if stepResult.Commit != nil {
+				setCommitParameters(execCtx, stepResult.Commit)
+				if uploadErr := a.uploadCommit(ctx, buildID, stepResult.Commit); uploadErr != nil {
+					log.Warn().Err(uploadErr).Str("build_id", buildID).Str("plugin", step.Name).Msg("Failed to upload commit")
+					addLine("stderr", fmt.Sprintf("[WARN] Failed to upload commit from %s: %v", step.Name, uploadErr))
+				}
+			}
+		}
+
+		// Reported with the step's end for the API server's per-plugin metrics
+		stepMetrics := map[string]interface{}{"duration_ms": summary["duration_ms"], "findings": 0}
+		if stepResult != nil {
+			stepMetrics["findings"] = len(stepResult.Findings)
+		}
+
+		if err == nil && stepResult.Success {
+			if n := len(stepResult.Attempts); n > 1 {
+				addLine("stdout", fmt.Sprintf("[INFO] Plugin %s succeeded on attempt %d", step.Name, n))
+			}
+			a.recordEvent(ctx, buildID, eventStepFinished, step.Name, "success", stepMetrics)
+			continue
+		}
+
+		message := ""
+		exitCode := 1
+		if err != nil {
+			message = err.Error()
+		} else {
+			message = stepResult.ErrorMessage
+			if stepResult.ExitCode != 0 {
+				exitCode = stepResult.ExitCode
+			}
+		}
+		summary["status"] = "failed"
+		summary["error_message"] = message
+		a.recordEvent(ctx, buildID, eventStepFinished, step.Name, "failed", stepMetrics)
+
+		if step.ContinueOnError || failed {
+			log.Warn().Str("build_id", buildID).Str("plugin", step.Name).Str("error", message).Msg("Plugin step failed, continuing")
+			addLine("stderr", fmt.Sprintf("[WARN] Plugin %s failed: %s", step.Name, message))
+			continue
+		}
+
+		failed = true
+		result.Success = false
+		result.ExitCode = exitCode
+		result.ErrorMessage = fmt.Sprintf("Plugin %s failed: %s", step.Name, message)
+	}
+	return summaries
+}
+
+// newExecutionContext returns the execution context shared by the plugin steps
+// of a build, with the build's details in its parameters
+func newExecutionContext(buildData map[string]interface{}, workDir string) *plugin.ExecutionContext {
+	execCtx := &plugin.ExecutionContext{
+		BuildID: getStringOrEmpty(buildData, "id"),
+		JobID:   getStringOrEmpty(buildData, "job_id"),
+		WorkDir: workDir,
+		EnvVars: getStringMap(buildData, "environment_vars"),
+		Parameters: map[string]interface{}{
+			"job_name":            getStringOrEmpty(buildData, "job_name"),
+			"build_number":        buildData["build_number"],
+			"scm_url":             getStringOrEmpty(buildData, "scm_url"),
+			"branch":              getStringOrEmpty(buildData, "branch"),
+			"commit_sha":          getStringOrEmpty(buildData, "commit_sha"),
+			"previous_commit_sha": getStringOrEmpty(buildData, "previous_commit_sha"),
+		},
+		Secrets: make(map[string]string),
+	}
+	// Parameters the build was triggered with, such as a tag to check out,
+	// do not replace the build's details
+	if params, ok := buildData["parameters"].(map[string]interface{}); ok {
+		for k, v := range params {
+			if _, set := execCtx.Parameters[k]; !set {
+				execCtx.Parameters[k] = v
+			}
+		}
+	}
+	if labels, ok := buildData["job_labels"].(map[string]interface{}); ok {
+		execCtx.Parameters["job_labels"] = labels
+	}
+	if baseline, ok := buildData["coverage_baseline"].(map[string]interface{}); ok {
+		execCtx.Parameters["coverage_baseline"] = baseline
+	}
+	if exceptions, ok := buildData["license_exceptions"].([]interface{}); ok {
+		execCtx.Parameters["license_exceptions"] = exceptions
+	}
+	return execCtx
+}
+
+// stepLogger adds the log entries and output of a plugin step to the build log
+func stepLogger(name string, addLine func(stream, line string)) func(plugin.LogEntry) {
+	return func(entry plugin.LogEntry) {
+		switch entry.Level {
+		case plugin.LevelOutput:
+			addLine("stdout", fmt.Sprintf("%s: %s", name, entry.Message))
+		case "warn", "error":
+			addLine("stderr", fmt.Sprintf("[%s] %s: %s", strings.ToUpper(entry.Level), name, entry.Message))
+		default:
+			addLine("stdout", fmt.Sprintf("[%s] %s: %s", strings.ToUpper(entry.Level), name, entry.Message))
+		}
+	}
+}
+
 // updateBuildStatus updates the status of a build
 func (a *Agent) updateBuildStatus(ctx context.Context, buildID string, status string, data map[string]interface{}) error {
 	url := fmt.Sprintf("%s/api/v1/builds/%s/status", a.apiURL, buildID)
@@ -382,6 +989,43 @@ func getStringOrEmpty(m map[string]interface{}, key string) string {
 	return ""
 }
 
+// getStringMap extracts the string values of an object in the map
+func getStringMap(m map[string]interface{}, key string) map[string]string {
+	raw, _ := m[key].(map[string]interface{})
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			values[k] = s
+		}
+	}
+	return values
+}
+
+// buildParameters returns the parameters a build was triggered with, as
+// strings
+func buildParameters(buildData map[string]interface{}) map[string]string {
+	raw, _ := buildData["parameters"].(map[string]interface{})
+	params := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if v != nil {
+			params[k] = fmt.Sprint(v)
+		}
+	}
+	return params
+}
+
+// getStringList extracts the strings of a list in the map
+func getStringList(m map[string]interface{}, key string) []string {
+	raw, _ := m[key].([]interface{})
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if s, ok := v.(string); ok && s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
 // getOutboundIP gets the preferred outbound IP of this machine
 func getOutboundIP() string {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+)
+
+// alertPollInterval is how often the agent asks the API server for alert
+// notifications to deliver
+const alertPollInterval = 30 * time.Second
+
+// alert is an operational alert whose notifications the API server handed to
+// this worker to deliver
+type alert struct {
+	ID           string                 `json:"id"`
+	Kind         string                 `json:"kind"`
+	Subject      string                 `json:"subject"`
+	Message      string                 `json:"message"`
+	Details      map[string]interface{} `json:"details"`
+	PendingEvent string                 `json:"pending_event"`
+}
+
+// alertLoop delivers the notifications of the API server's alerts about
+// worker and queue health, which the server cannot run plugins to send itself
+func (a *Agent) alertLoop(ctx context.Context) {
+	ticker := time.NewTicker(alertPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.deliverAlerts(ctx); err != nil {
+				log.Warn().Err(err).Msg("Failed to deliver alert notifications")
+			}
+		}
+	}
+}
+
+// deliverAlerts runs the alert notification steps for each alert the API
+// server hands out and reports it delivered. A notification that fails is
+// logged and not retried, so one broken step does not hold up the others.
+func (a *Agent) deliverAlerts(ctx context.Context) error {
+	url := fmt.Sprintf("%s/api/v1/workers/%s/alerts/claim", a.apiURL, a.workerID.String())
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.credential)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("alerts request failed with code %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Alerts        []alert       `json:"alerts"`
+		Notifications []interface{} `json:"notifications"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	if len(body.Alerts) == 0 {
+		return nil
+	}
+	steps, err := plugin.ParseSteps(body.Notifications)
+	if err != nil {
+		return fmt.Errorf("invalid alert notifications: %w", err)
+	}
+
+	for _, al := range body.Alerts {
+		a.runAlertNotifications(ctx, al, steps)
+		if err := a.alertDelivered(ctx, al); err != nil {
+			log.Warn().Err(err).Str("alert_id", al.ID).Msg("Failed to report alert notifications delivered")
+		}
+	}
+	return nil
+}
+
+// runAlertNotifications runs the notification steps for an alert's pending
+// event. Each receives the alert in its "alert" parameter and the event as
+// alert_fired or alert_resolved in notification_events.
+func (a *Agent) runAlertNotifications(ctx context.Context, al alert, steps []plugin.Step) {
+	details := al.Details
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	execCtx := &plugin.ExecutionContext{
+		WorkDir: os.TempDir(),
+		EnvVars: map[string]string{},
+		Parameters: map[string]interface{}{
+			"alert": map[string]interface{}{
+				"id":      al.ID,
+				"kind":    al.Kind,
+				"subject": al.Subject,
+				"message": al.Message,
+				"event":   al.PendingEvent,
+				"details": details,
+			},
+			"notification_events": []interface{}{"alert_" + al.PendingEvent},
+		},
+		Secrets: make(map[string]string),
+	}
+
+	logger := log.With().Str("alert_id", al.ID).Str("kind", al.Kind).Logger()
+	for _, step := range steps {
+		result, err := a.plugins.Run(ctx, step, execCtx, func(entry plugin.LogEntry) {
+			logger.Debug().Str("plugin", step.Name).Msg(entry.Message)
+		})
+		if err == nil && !result.Success {
+			err = fmt.Errorf("%s", result.ErrorMessage)
+		}
+		if err != nil {
+			logger.Warn().Err(err).Str("plugin", step.Name).Msg("Alert notification failed")
+			continue
+		}
+		logger.Info().Str("plugin", step.Name).Str("event", al.PendingEvent).Msg("Alert notification sent")
+	}
+}
+
+// alertDelivered tells the API server the notifications of the alert's
+// pending event were run
+func (a *Agent) alertDelivered(ctx context.Context, al alert) error {
+	url := fmt.Sprintf("%s/api/v1/workers/%s/alerts/%s/delivered", a.apiURL, a.workerID.String(), al.ID)
+
+	body, err := json.Marshal(map[string]string{"event": al.PendingEvent})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+a.credential)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("alert delivery report failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+)
+
+// uploadAnnotations sends the annotations a plugin step reported to the API
+// server, attributed to the step's plugin
+func (a *Agent) uploadAnnotations(ctx context.Context, buildID, stepName string, annotations []plugin.Annotation) error {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/annotations", a.apiURL, buildID)
+
+	body, err := json.Marshal(map[string]interface{}{"step": stepName, "annotations": annotations})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("annotations upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// annotationParameters returns annotations as the annotations parameter of
+// notifications, in the form the plugin SDK reads
+func annotationParameters(annotations []plugin.Annotation) []interface{} {
+	params := make([]interface{}, len(annotations))
+	for i, an := range annotations {
+		params[i] = map[string]interface{}{
+			"path":       an.Path,
+			"start_line": an.StartLine,
+			"end_line":   an.EndLine,
+			"severity":   an.Severity,
+			"title":      an.Title,
+			"message":    an.Message,
+		}
+	}
+	return params
+}
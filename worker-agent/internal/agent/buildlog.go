@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// logFlushInterval is how often buffered build log lines are sent to the API server
+const logFlushInterval = time.Second
+
+// maxLogBatch caps the number of lines sent in one request
+const maxLogBatch = 500
+
+// logLine is a build log line as stored by the API server
+type logLine struct {
+	SequenceNumber int       `json:"sequence_number"`
+	Timestamp      time.Time `json:"timestamp"`
+	LogLine        string    `json:"log_line"`
+	Stream         string    `json:"stream"`
+}
+
+// buildLog uploads a build's log lines to the API server while the build runs.
+// Lines that fail to upload are retried on the next flush.
+type buildLog struct {
+	agent   *Agent
+	buildID string
+	masks   []string // secret values replaced in every line
+
+	mu      sync.Mutex
+	pending []logLine
+	next    int // sequence number of the next line
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newBuildLog starts uploading log lines for a build until Close is called.
+// Occurrences of the masks in lines are replaced before upload.
+func (a *Agent) newBuildLog(ctx context.Context, buildID string, masks []string) *buildLog {
+	l := &buildLog{
+		agent:   a,
+		buildID: buildID,
+		masks:   masks,
+		next:    1,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.run(ctx)
+	return l
+}
+
+// Add queues lines for upload
+func (l *buildLog) Add(stream string, lines ...string) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range lines {
+		for _, mask := range l.masks {
+			line = strings.ReplaceAll(line, mask, "***")
+		}
+		l.pending = append(l.pending, logLine{SequenceNumber: l.next, Timestamp: now, LogLine: line, Stream: stream})
+		l.next++
+	}
+}
+
+// Close uploads the remaining lines and stops the uploader
+func (l *buildLog) Close() {
+	close(l.stop)
+	<-l.done
+}
+
+func (l *buildLog) run(ctx context.Context) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.flush(ctx)
+		case <-l.stop:
+			l.flush(ctx)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flush sends pending lines in batches, keeping them if the API server is unavailable
+func (l *buildLog) flush(ctx context.Context) {
+	for {
+		l.mu.Lock()
+		batch := l.pending[:min(len(l.pending), maxLogBatch)]
+		l.mu.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := l.agent.uploadLogs(ctx, l.buildID, batch); err != nil {
+			log.Warn().Err(err).Str("build_id", l.buildID).Int("pending", len(batch)).Msg("Failed to upload build logs")
+			return
+		}
+
+		l.mu.Lock()
+		l.pending = l.pending[len(batch):]
+		l.mu.Unlock()
+	}
+}
+
+// uploadLogs sends log lines to the API server, or to log ingestion if the
+// worker is configured with its address
+func (a *Agent) uploadLogs(ctx context.Context, buildID string, lines []logLine) error {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/logs", a.logURL, buildID)
+
+	body, err := json.Marshal(map[string]interface{}{"lines": lines})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("log upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
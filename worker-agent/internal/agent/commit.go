@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+)
+
+// uploadCommit sends the commit an SCM plugin step checked out to the API
+// server, which records its metadata and changed files on the build
+func (a *Agent) uploadCommit(ctx context.Context, buildID string, commit *plugin.CommitInfo) error {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/commit", a.apiURL, buildID)
+
+	body, err := json.Marshal(commit)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("commit upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// setCommitParameters passes the checked out commit to the plugin steps after
+// the SCM step
+func setCommitParameters(execCtx *plugin.ExecutionContext, commit *plugin.CommitInfo) {
+	files := make([]interface{}, len(commit.ChangedFiles))
+	for i, f := range commit.ChangedFiles {
+		files[i] = f
+	}
+	execCtx.Parameters["commit_sha"] = commit.SHA
+	execCtx.Parameters["commit_message"] = commit.Message
+	execCtx.Parameters["commit_author"] = commit.Author
+	execCtx.Parameters["changed_files"] = files
+}
@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+)
+
+// uploadCoverage sends the code coverage a plugin step reported to the API
+// server, which tracks it across the job's builds
+func (a *Agent) uploadCoverage(ctx context.Context, buildID, stepName string, coverage *plugin.Coverage) error {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/coverage", a.apiURL, buildID)
+
+	body, err := json.Marshal(map[string]interface{}{"step": stepName, "coverage": coverage})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("coverage upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+)
+
+// uploadFindings sends the security findings a plugin step reported to the
+// API server and returns the number of new findings of each severity, those
+// neither in the job's baseline nor suppressed. Findings without a tool are
+// attributed to the step's plugin.
+func (a *Agent) uploadFindings(ctx context.Context, buildID, stepName string, findings []plugin.Finding) (map[string]int, error) {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/findings", a.apiURL, buildID)
+
+	for i := range findings {
+		if findings[i].Tool == "" {
+			findings[i].Tool = stepName
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"findings": findings})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("findings upload failed with code %d", resp.StatusCode)
+	}
+
+	var result struct {
+		New map[string]int `json:"new"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid findings upload response: %w", err)
+	}
+	return result.New, nil
+}
+
+// gateFindings decides a step that sets fail_on_findings by its new findings
+// instead of the plugin's own verdict, so existing backlog in the job's
+// baseline and suppressed findings do not fail the build. It returns false
+// with a message if the step fails.
+func gateFindings(step plugin.Step, newCounts map[string]int) (bool, string) {
+	newFindings := 0
+	for i := len(plugin.Severities) - 1; i >= 0; i-- {
+		newFindings += newCounts[plugin.Severities[i]]
+		if plugin.Severities[i] == step.FailOnFindings {
+			break
+		}
+	}
+	if newFindings > 0 {
+		return false, fmt.Sprintf("%d new findings of severity %s or above", newFindings, step.FailOnFindings)
+	}
+	return true, ""
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+	"github.com/solvyd/solvyd/worker-agent/internal/tracing"
+)
+
+// notification is a notification step the API server selected for a build
+type notification struct {
+	Step   interface{} `json:"step"`
+	Events []string    `json:"events"`
+}
+
+// fetchNotifications asks the API server which of the job's notifications
+// fire if the build ends with the status
+func (a *Agent) fetchNotifications(ctx context.Context, buildID, status string) ([]notification, error) {
+	u := fmt.Sprintf("%s/api/v1/builds/%s/notifications?status=%s", a.apiURL, buildID, url.QueryEscape(status))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("notifications request failed with code %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Notifications []notification `json:"notifications"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Notifications, nil
+}
+
+// runNotifications runs the notifications that fire for the build's outcome
+// once it and its plugin steps are done. Each receives the build's final
+// status, the summaries of the plugin steps, the annotations they reported and
+// the events it fires for in its parameters. Notifications that fail are logged and do not change the
+// build's result.
+func (a *Agent) runNotifications(ctx context.Context, buildData map[string]interface{}, status, workDir string, summaries []interface{}, buildLog *buildLog) {
+	buildID := getStringOrEmpty(buildData, "id")
+	selected, err := a.fetchNotifications(ctx, buildID, status)
+	if err != nil {
+		log.Warn().Err(err).Str("build_id", buildID).Msg("Failed to fetch notifications")
+		buildLog.Add("stderr", fmt.Sprintf("[WARN] Failed to fetch notifications: %v", err))
+		return
+	}
+
+	execCtx := newExecutionContext(buildData, workDir)
+	execCtx.Parameters["build_status"] = "success"
+	if status == "failure" {
+		execCtx.Parameters["build_status"] = "failed"
+	}
+	if summaries == nil {
+		summaries = []interface{}{}
+	}
+	execCtx.Parameters["steps"] = summaries
+	if annotations, ok := buildData["annotations"].([]interface{}); ok {
+		execCtx.Parameters["annotations"] = annotations
+	}
+
+	addLine := func(stream, line string) {
+		buildLog.Add(stream, line)
+	}
+	for _, n := range selected {
+		steps, err := plugin.ParseSteps([]interface{}{n.Step})
+		if err != nil {
+			buildLog.Add("stderr", fmt.Sprintf("[WARN] Invalid notification: %v", err))
+			continue
+		}
+		step := steps[0]
+
+		events := make([]interface{}, len(n.Events))
+		for i, e := range n.Events {
+			events[i] = e
+		}
+		execCtx.Parameters["notification_events"] = events
+
+		buildLog.Add("stdout", fmt.Sprintf("[INFO] Running notification: %s", step.Name))
+		a.recordEvent(ctx, buildID, eventNotificationStarted, step.Name, "", nil)
+		stepCtx, stepSpan := tracing.Tracer().Start(ctx, "notification",
+			trace.WithAttributes(attribute.String("plugin.name", step.Name)))
+		stepResult, err := a.plugins.Run(stepCtx, step, execCtx, stepLogger(step.Name, addLine))
+		if err == nil && !stepResult.Success {
+			err = fmt.Errorf("%s", stepResult.ErrorMessage)
+		}
+		tracing.End(stepSpan, err)
+		if err != nil {
+			log.Warn().Err(err).Str("build_id", buildID).Str("plugin", step.Name).Msg("Notification failed")
+			buildLog.Add("stderr", fmt.Sprintf("[WARN] Notification %s failed: %v", step.Name, err))
+			a.recordEvent(ctx, buildID, eventNotificationFinished, step.Name, "failed", nil)
+		} else {
+			a.recordEvent(ctx, buildID, eventNotificationFinished, step.Name, "success", nil)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// oneShotPollInterval is how often a one-shot agent asks for its build
+const oneShotPollInterval = 5 * time.Second
+
+// RunOnce runs the agent in one-shot mode: it registers, takes exactly one
+// build, runs it to completion with its logs and results uploaded, then
+// deregisters. Autoscalers and spot instances use it to give every build a
+// clean machine. It returns when the worker has deregistered, or with an
+// error if it could not register, take its build or deregister.
+func (a *Agent) RunOnce(ctx context.Context) error {
+	// A one-shot worker never runs builds side by side
+	a.mu.Lock()
+	a.config.MaxConcurrent = 1
+	a.mu.Unlock()
+
+	log.Info().
+		Str("worker_name", a.config.WorkerName).
+		Str("api_server", a.config.APIServer).
+		Msg("Worker agent started in one-shot mode")
+
+	if err := a.register(ctx); err != nil {
+		return fmt.Errorf("failed to register with API server: %w", err)
+	}
+
+	heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+	defer stopHeartbeat()
+	go a.heartbeatLoop(heartbeatCtx)
+	go a.diskQuotaLoop(heartbeatCtx)
+
+	if err := a.plugins.Sync(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to sync installed plugins")
+	}
+
+	buildData, err := a.waitForBuild(ctx)
+	if err != nil {
+		// Leave nothing behind when stopped before a build arrived
+		a.deregister(context.Background())
+		return err
+	}
+
+	// Take no other build, not even while deregistering
+	if err := a.drain(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to drain worker")
+	}
+
+	a.currentBuilds++
+	a.executeBuild(ctx, buildData)
+
+	stopHeartbeat()
+	return a.deregister(context.Background())
+}
+
+// waitForBuild polls until a build is assigned to this worker and returns
+// the first one
+func (a *Agent) waitForBuild(ctx context.Context) (map[string]interface{}, error) {
+	ticker := time.NewTicker(oneShotPollInterval)
+	defer ticker.Stop()
+
+	for {
+		builds, err := a.fetchBuilds(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch builds")
+		} else if len(builds) > 0 {
+			return builds[0], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain stops the API server from assigning more builds to this worker
+func (a *Agent) drain(ctx context.Context) error {
+	url := fmt.Sprintf("%s/api/v1/workers/%s/drain", a.apiURL, a.workerID.String())
+	return a.workerRequest(ctx, http.MethodPost, url)
+}
+
+// deregister removes this worker from the API server
+func (a *Agent) deregister(ctx context.Context) error {
+	url := fmt.Sprintf("%s/api/v1/workers/%s", a.apiURL, a.workerID.String())
+	if err := a.workerRequest(ctx, http.MethodDelete, url); err != nil {
+		return fmt.Errorf("failed to deregister: %w", err)
+	}
+
+	log.Info().Str("worker_id", a.workerID.String()).Msg("Worker deregistered")
+	return nil
+}
+
+// workerRequest sends a request without a body about this worker
+func (a *Agent) workerRequest(ctx context.Context, method, url string) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s failed with status %d", method, url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputsURL is where the outputs of a stage of a build are stored
+func (a *Agent) outputsURL(buildID, stage string) string {
+	return fmt.Sprintf("%s/api/v1/builds/%s/stages/%s/outputs", a.apiURL, buildID, url.PathEscape(stage))
+}
+
+// saveStageOutputs stores the files of the workspace that the outputs of a
+// stage, paths or patterns relative to workDir, match as a gzipped tar
+// archive, for a rerun of the build on another worker to restore
+func (a *Agent) saveStageOutputs(ctx context.Context, buildID, stage string, outputs []string, workDir string) error {
+	archive, err := os.CreateTemp("", "solvyd-outputs-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		archive.Close()
+		os.Remove(archive.Name())
+	}()
+
+	hash := sha256.New()
+	if err := writeOutputs(io.MultiWriter(archive, hash), outputs, workDir); err != nil {
+		return err
+	}
+	size, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", a.outputsURL(buildID, stage), archive)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Checksum-Sha256", hex.EncodeToString(hash.Sum(nil)))
+
+	resp, err := a.transfers.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("outputs upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeOutputs writes the files the outputs match, and the files in the
+// directories they match, to w as a gzipped tar archive
+func writeOutputs(w io.Writer, outputs []string, workDir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	written := map[string]bool{}
+
+	add := func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s is outside the workspace", path)
+		}
+		if written[rel] {
+			return nil
+		}
+		written[rel] = true
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	}
+
+	for _, output := range outputs {
+		matches, err := filepath.Glob(filepath.Join(workDir, filepath.FromSlash(output)))
+		if err != nil {
+			return fmt.Errorf("invalid output %s: %w", output, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("output %s matches no files", output)
+		}
+		for _, match := range matches {
+			if err := filepath.WalkDir(match, add); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// restoreStageOutputs restores the outputs stages of a build stored into
+// workDir, failing if one of them is not stored
+func (a *Agent) restoreStageOutputs(ctx context.Context, buildID string, stages []string, workDir string) error {
+	for _, stage := range stages {
+		if err := a.restoreOutputs(ctx, buildID, stage, workDir); err != nil {
+			return fmt.Errorf("stage %s: %w", stage, err)
+		}
+	}
+	return nil
+}
+
+// restoreOutputs unpacks the outputs a stage of a build stored into workDir
+func (a *Agent) restoreOutputs(ctx context.Context, buildID, stage, workDir string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.outputsURL(buildID, stage), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.transfers.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("its outputs are not stored")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("outputs download failed with code %d", resp.StatusCode)
+	}
+
+	hash := sha256.New()
+	if err := readOutputs(io.TeeReader(resp.Body, hash), workDir); err != nil {
+		return err
+	}
+	if expected := resp.Header.Get("X-Checksum-Sha256"); expected != "" {
+		if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, expected) {
+			return fmt.Errorf("outputs checksum mismatch: got %s, expected %s", sum, expected)
+		}
+	}
+	return nil
+}
+
+// readOutputs unpacks a gzipped tar archive of outputs into workDir,
+// replacing the files there and refusing entries and links that lead
+// outside it
+func readOutputs(r io.Reader, workDir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("invalid outputs archive: %w", err)
+	}
+	defer gz.Close()
+
+	inside := func(name string) (string, error) {
+		target := filepath.Join(workDir, filepath.FromSlash(name))
+		if target != workDir && !strings.HasPrefix(target, workDir+string(os.PathSeparator)) {
+			return "", fmt.Errorf("outputs archive entry %s leads outside the workspace", name)
+		}
+		return target, nil
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid outputs archive: %w", err)
+		}
+		target, err := inside(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			// Replace rather than write through a file or link the
+			// checkout left there
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, os.FileMode(hdr.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) {
+				return fmt.Errorf("outputs archive link %s leads outside the workspace", hdr.Name)
+			}
+			if _, err := inside(filepath.Join(filepath.Dir(hdr.Name), hdr.Linkname)); err != nil {
+				return fmt.Errorf("outputs archive link %s leads outside the workspace", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+	// Read to the end, so that the checksum covers the whole archive
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxPipelineFileBytes limits the size of a pipeline definition read from a
+// repository
+const maxPipelineFileBytes = 1 << 20
+
+// loadPipeline reads a build's pipeline definition from the repository
+// checked out in workDir and has the API server validate it. The build runs
+// the stages and plugin steps it defines in place of the job's.
+func (a *Agent) loadPipeline(ctx context.Context, buildData map[string]interface{}, workDir string) (map[string]interface{}, error) {
+	file := getStringOrEmpty(buildData, "pipeline_file")
+	path := filepath.Join(workDir, filepath.FromSlash(file))
+	if rel, err := filepath.Rel(workDir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("pipeline file %s is outside the repository", file)
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("pipeline file %s not found in the repository", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxPipelineFileBytes {
+		return nil, fmt.Errorf("pipeline file %s is larger than %d bytes", file, maxPipelineFileBytes)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/builds/%s/pipeline", a.apiURL, getStringOrEmpty(buildData, "id"))
+	body, err := json.Marshal(map[string]string{"file": file, "content": string(content)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate pipeline file %s: %w", file, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		var invalid struct {
+			Details []string `json:"details"`
+		}
+		json.NewDecoder(resp.Body).Decode(&invalid)
+		return nil, fmt.Errorf("invalid pipeline file %s: %s", file, strings.Join(invalid.Details, "; "))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pipeline validation failed with code %d", resp.StatusCode)
+	}
+
+	var pipeline struct {
+		BuildConfig map[string]interface{} `json:"build_config"`
+		Plugins     []interface{}          `json:"plugins"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pipeline); err != nil {
+		return nil, err
+	}
+	if pipeline.Plugins != nil {
+		buildData["plugins"] = pipeline.Plugins
+	}
+	return pipeline.BuildConfig, nil
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/executor"
+)
+
+const (
+	// diskCheckInterval is how often disk usage is measured against the quotas
+	diskCheckInterval = 15 * time.Second
+	// failureDiskQuota is the failure_reason of builds failed by a disk quota
+	failureDiskQuota = "disk_quota_exceeded"
+)
+
+// DiskQuotaError is the cause of a build stopped for exceeding a disk quota
+type DiskQuotaError struct {
+	UsedMB  int64
+	QuotaMB int64
+	Worker  bool // the worker's quota, rather than the build's
+}
+
+func (e *DiskQuotaError) Error() string {
+	if e.Worker {
+		return fmt.Sprintf("Disk quota exceeded: the worker uses %d MB of its %d MB quota and this build's workspace is the largest", e.UsedMB, e.QuotaMB)
+	}
+	return fmt.Sprintf("Disk quota exceeded: the build's workspace uses %d MB of its %d MB quota", e.UsedMB, e.QuotaMB)
+}
+
+// diskQuotaError returns the disk quota error a build was stopped with, or
+// nil if it was not
+func diskQuotaError(buildCtx context.Context) *DiskQuotaError {
+	var quotaErr *DiskQuotaError
+	if errors.As(context.Cause(buildCtx), &quotaErr) {
+		return quotaErr
+	}
+	return nil
+}
+
+// diskUsage is the disk used by the builds and the cache, as last measured
+type diskUsage struct {
+	WorkspaceUsedMB int64 `json:"workspace_used_mb"`
+	CacheUsedMB     int64 `json:"cache_used_mb"`
+	DiskQuotaMB     int64 `json:"disk_quota_mb"` // of the worker; 0 is unlimited
+	// At or over the worker's quota: no builds are taken until it is not
+	DiskFull bool `json:"disk_full"`
+}
+
+// diskFull reports whether the worker is at or over its disk quota
+func (a *Agent) diskFull() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.disk.DiskFull
+}
+
+// diskQuotaLoop measures disk usage and enforces the quotas until ctx is done
+func (a *Agent) diskQuotaLoop(ctx context.Context) {
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		a.enforceDiskQuotas()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enforceDiskQuotas measures the workspaces of the running builds, the other
+// workspaces and the cache directory. A build over the build quota is
+// stopped; while the worker is over its quota, the build with the largest
+// workspace is stopped at each check, so that the rest can finish.
+func (a *Agent) enforceDiskQuotas() {
+	workDir := executor.WorkDir()
+	totalBytes, err := executor.DirSize(workDir)
+	if err != nil {
+		log.Warn().Err(err).Str("dir", workDir).Msg("Failed to measure workspace disk usage")
+		return
+	}
+	var cacheBytes int64
+	if a.cacheDir != "" {
+		if cacheBytes, err = executor.DirSize(a.cacheDir); err != nil {
+			log.Warn().Err(err).Str("dir", a.cacheDir).Msg("Failed to measure cache disk usage")
+		}
+	}
+
+	a.mu.Lock()
+	builds := make(map[string]context.CancelCauseFunc, len(a.running))
+	for buildID, stop := range a.running {
+		builds[buildID] = stop
+	}
+	a.mu.Unlock()
+
+	buildQuota, workerQuota := a.config.BuildDiskQuotaMB, a.config.WorkerDiskQuotaMB
+	largest, largestMB := "", int64(-1)
+	for buildID, stop := range builds {
+		sizeBytes, err := executor.DirSize(filepath.Join(workDir, buildID))
+		if err != nil {
+			continue
+		}
+		usedMB := sizeBytes / (1 << 20)
+		if buildQuota > 0 && usedMB > buildQuota {
+			log.Warn().Str("build_id", buildID).Int64("used_mb", usedMB).Int64("quota_mb", buildQuota).Msg("Build exceeded its disk quota, stopping it")
+			stop(&DiskQuotaError{UsedMB: usedMB, QuotaMB: buildQuota})
+			continue
+		}
+		if usedMB > largestMB {
+			largest, largestMB = buildID, usedMB
+		}
+	}
+
+	usage := diskUsage{
+		WorkspaceUsedMB: totalBytes / (1 << 20),
+		CacheUsedMB:     cacheBytes / (1 << 20),
+		DiskQuotaMB:     workerQuota,
+	}
+	usedMB := usage.WorkspaceUsedMB + usage.CacheUsedMB
+	usage.DiskFull = workerQuota > 0 && usedMB >= workerQuota
+
+	if workerQuota > 0 && usedMB > workerQuota && largest != "" {
+		log.Warn().Str("build_id", largest).Int64("used_mb", usedMB).Int64("quota_mb", workerQuota).Msg("Worker exceeded its disk quota, stopping its largest build")
+		builds[largest](&DiskQuotaError{UsedMB: usedMB, QuotaMB: workerQuota, Worker: true})
+	}
+
+	a.mu.Lock()
+	if usage.DiskFull && !a.disk.DiskFull {
+		log.Warn().Int64("used_mb", usedMB).Int64("quota_mb", workerQuota).Msg("Worker disk quota reached, not taking builds")
+	}
+	a.disk = usage
+	a.mu.Unlock()
+}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/config"
+)
+
+// maxConcurrent returns how many builds the agent may run side by side
+func (a *Agent) maxConcurrent() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.config.MaxConcurrent
+}
+
+// Reload applies the labels and max concurrent builds of a reloaded
+// configuration and reports them to the API server. Running builds are not
+// affected: lowering max concurrent builds only stops new builds from being
+// taken until enough have finished. Other changed settings are logged as
+// needing a restart.
+func (a *Agent) Reload(ctx context.Context, next *config.Config) error {
+	if changed := a.config.RestartRequired(next); len(changed) > 0 {
+		log.Warn().Strs("settings", changed).Msg("Changed settings are applied on restart")
+	}
+	if next.MaxConcurrent < 1 {
+		return fmt.Errorf("max concurrent builds must be at least 1, got %d", next.MaxConcurrent)
+	}
+
+	a.mu.Lock()
+	maxConcurrent := next.MaxConcurrent
+	if a.config.OneShot {
+		// A one-shot worker never runs builds side by side
+		maxConcurrent = 1
+	}
+	changed := maxConcurrent != a.config.MaxConcurrent || !reflect.DeepEqual(next.Labels, a.config.Labels)
+	a.config.MaxConcurrent = maxConcurrent
+	a.config.Labels = next.Labels
+	a.mu.Unlock()
+
+	if !changed {
+		log.Info().Msg("Configuration reloaded, labels and max concurrent builds unchanged")
+		return nil
+	}
+
+	log.Info().
+		Int("max_concurrent", maxConcurrent).
+		Interface("labels", next.Labels).
+		Msg("Configuration reloaded")
+
+	// Not registered yet; registering reports them
+	if a.workerID == uuid.Nil {
+		return nil
+	}
+	return a.updateWorker(ctx, maxConcurrent, next.Labels)
+}
+
+// updateWorker reports the worker's max concurrent builds and labels to the
+// API server, so that the scheduler assigns builds by them
+func (a *Agent) updateWorker(ctx context.Context, maxConcurrent int, labels map[string]string) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"max_concurrent_builds": maxConcurrent,
+		"labels":                labels,
+	})
+
+	url := fmt.Sprintf("%s/api/v1/workers/%s", a.apiURL, a.workerID.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update worker: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("worker update failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
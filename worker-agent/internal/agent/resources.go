@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/executor"
+	"github.com/solvyd/solvyd/worker-agent/internal/sysinfo"
+)
+
+// workerResources is the resource snapshot sent with each heartbeat
+type workerResources struct {
+	sysinfo.Resources
+	diskUsage
+	Builds []executor.BuildUsage `json:"builds"`
+}
+
+// resources measures the worker's load and free resources and, if the
+// executor can measure them, the usage of each running build
+func (a *Agent) resources(ctx context.Context) workerResources {
+	a.mu.Lock()
+	disk := a.disk
+	a.mu.Unlock()
+
+	snapshot := workerResources{
+		Resources: sysinfo.Collect(executor.WorkDir()),
+		diskUsage: disk,
+		Builds:    []executor.BuildUsage{},
+	}
+
+	reporter, ok := a.executor.(executor.UsageReporter)
+	if !ok {
+		return snapshot
+	}
+
+	a.mu.Lock()
+	buildIDs := make([]string, 0, len(a.running))
+	for buildID := range a.running {
+		buildIDs = append(buildIDs, buildID)
+	}
+	a.mu.Unlock()
+
+	for _, buildID := range buildIDs {
+		usage, err := reporter.Usage(ctx, buildID)
+		if err != nil {
+			log.Debug().Err(err).Str("build_id", buildID).Msg("Failed to measure build resource usage")
+			continue
+		}
+		snapshot.Builds = append(snapshot.Builds, *usage)
+	}
+	return snapshot
+}
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// metadataTags is the key of the tags a plugin step adds to its build in its
+// result's metadata, as a list of strings
+const metadataTags = "tags"
+
+// resultTags returns the tags in a plugin step result's metadata
+func resultTags(metadata map[string]interface{}) []string {
+	raw, _ := metadata[metadataTags].([]interface{})
+	tags := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if tag, ok := v.(string); ok && tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// uploadTags adds tags to a build on the API server
+func (a *Agent) uploadTags(ctx context.Context, buildID string, tags []string) error {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/tags", a.apiURL, buildID)
+
+	body, err := json.Marshal(map[string]interface{}{"tags": tags})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tag upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
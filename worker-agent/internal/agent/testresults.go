@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+)
+
+// uploadTestResults sends the test cases a plugin step reported to the API
+// server, attributed to the step's plugin
+func (a *Agent) uploadTestResults(ctx context.Context, buildID, stepName string, cases []plugin.TestCase) error {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/test-results", a.apiURL, buildID)
+
+	body, err := json.Marshal(map[string]interface{}{"step": stepName, "test_cases": cases})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("test results upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
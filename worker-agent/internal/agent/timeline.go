@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Build lifecycle events the agent records, besides the stage events of the
+// executor
+const (
+	eventStarted              = "started"
+	eventStepStarted          = "step_started"
+	eventStepFinished         = "step_finished"
+	eventNotificationStarted  = "notification_started"
+	eventNotificationFinished = "notification_finished"
+	eventArtifact             = "artifact"
+	eventCancelled            = "cancelled"
+)
+
+// buildEvent is a lifecycle event of a build, as stored by the API server
+type buildEvent struct {
+	Type      string                 `json:"type"`
+	Name      string                 `json:"name,omitempty"`
+	Status    string                 `json:"status,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// recordEvent sends a lifecycle event of a build to the API server, which
+// builds the build's timeline from them. The timeline is informational, so
+// failures are only logged.
+func (a *Agent) recordEvent(ctx context.Context, buildID, eventType, name, status string, metadata map[string]interface{}) {
+	event := buildEvent{Type: eventType, Name: name, Status: status, Timestamp: time.Now(), Metadata: metadata}
+	if err := a.postEvents(ctx, buildID, []buildEvent{event}); err != nil {
+		log.Debug().Err(err).Str("build_id", buildID).Str("event", eventType).Msg("Failed to record build event")
+	}
+}
+
+func (a *Agent) postEvents(ctx context.Context, buildID string, events []buildEvent) error {
+	url := fmt.Sprintf("%s/api/v1/builds/%s/events", a.apiURL, buildID)
+
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("event upload failed with code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package agent
+
+import (
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/plugin"
+	"github.com/solvyd/solvyd/worker-agent/internal/tracing"
+)
+
+// traceCarrier returns the trace context the API server passed with a build
+func traceCarrier(buildData map[string]interface{}) map[string]string {
+	raw, _ := buildData["trace_context"].(map[string]interface{})
+	carrier := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			carrier[k] = s
+		}
+	}
+	return carrier
+}
+
+// endStepSpan ends the span of a plugin step with its outcome
+func endStepSpan(span trace.Span, result *plugin.Result, err error) {
+	if err == nil && result != nil && !result.Success {
+		span.SetStatus(codes.Error, result.ErrorMessage)
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/config"
+)
+
+// newTransport returns the transport of the API server connection, through
+// the configured proxy (or that of HTTP_PROXY, HTTPS_PROXY and NO_PROXY) and
+// trusting the configured CA bundle besides the system CAs
+func newTransport(cfg *config.Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.Proxy != "" {
+		if _, err := url.Parse(cfg.Proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		// NO_PROXY still applies to the configured proxy
+		proxy := (&httpproxy.Config{
+			HTTPProxy:  cfg.Proxy,
+			HTTPSProxy: cfg.Proxy,
+			NoProxy:    getenvAny("NO_PROXY", "no_proxy"),
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+
+	if cfg.CACert == "" && !cfg.TLSSkipVerify {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.TLSSkipVerify {
+		log.Warn().Msg("TLS certificate verification of the API server is disabled")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// getenvAny returns the first of the environment variables that is set
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
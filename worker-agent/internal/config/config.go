@@ -2,11 +2,31 @@ package config
 
 // Config holds worker agent configuration
 type Config struct {
-	APIServer     string
-	WorkerName    string
-	MaxConcurrent int
-	Labels        map[string]string
-	IsolationType string
+	APIServer       string
+	LogServer       string // Where build logs are sent; the API server without one
+	WorkerName      string
+	MaxConcurrent   int
+	Pool            string // Worker pool; the default pool if empty
+	Labels          map[string]string
+	LogLevel        string
+	IsolationType   string
+	PluginDir       string
+	PluginPolicy    string // Sandbox policy file; plugins run unconfined without one
+	SecretProviders string // Secret provider file; step secrets cannot be resolved without one
+	CacheBackend    string // Cache backend file; step caches are not restored or saved without one
+	TracingEndpoint string // OTLP/HTTP collector (host:port); spans are not exported without one
+	TracingInsecure bool   // Export spans over HTTP instead of HTTPS
+	OneShot         bool   // Run a single build, then deregister and exit
+	ToolDir         string // Directory the tools builds declare are installed in
+
+	// Disk quotas in MB; 0 is unlimited
+	BuildDiskQuotaMB  int64 // of each build's workspace
+	WorkerDiskQuotaMB int64 // of all workspaces and the cache backend's directory together
+
+	// API server connection
+	Proxy         string // Proxy URL; HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored without one
+	CACert        string // PEM bundle of CAs trusted besides the system's, e.g. of a TLS-intercepting proxy
+	TLSSkipVerify bool   // Do not verify the API server's certificate
 
 	// System info (auto-detected)
 	CPUCores  int
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// settings maps the keys of the config file to their flags and environment
+// variables
+var settings = []struct {
+	key, flag, env string
+}{
+	{"api_server", "api-server", "SOLVYD_API_URL"},
+	{"log_server", "log-server", "SOLVYD_LOG_URL"},
+	{"name", "name", "SOLVYD_WORKER_NAME"},
+	{"max_concurrent", "max-concurrent", "SOLVYD_MAX_CONCURRENT_BUILDS"},
+	{"pool", "pool", "SOLVYD_WORKER_POOL"},
+	{"log_level", "log-level", "SOLVYD_LOG_LEVEL"},
+	{"isolation", "isolation", "SOLVYD_ISOLATION"},
+	{"plugin_dir", "plugin-dir", "SOLVYD_PLUGIN_DIR"},
+	{"plugin_policy", "plugin-policy", "SOLVYD_PLUGIN_POLICY"},
+	{"secret_providers", "secret-providers", "SOLVYD_SECRET_PROVIDERS"},
+	{"cache_backend", "cache-backend", "SOLVYD_CACHE_BACKEND"},
+	{"tracing_endpoint", "tracing-endpoint", "SOLVYD_TRACING_ENDPOINT"},
+	{"tracing_insecure", "tracing-insecure", "SOLVYD_TRACING_INSECURE"},
+	{"one_shot", "one-shot", "SOLVYD_ONE_SHOT"},
+	{"tool_dir", "tool-dir", "SOLVYD_TOOL_DIR"},
+	{"build_disk_quota_mb", "build-disk-quota-mb", "SOLVYD_BUILD_DISK_QUOTA_MB"},
+	{"worker_disk_quota_mb", "worker-disk-quota-mb", "SOLVYD_WORKER_DISK_QUOTA_MB"},
+	{"proxy", "proxy", "SOLVYD_PROXY"},
+	{"ca_cert", "ca-cert", "SOLVYD_CA_CERT"},
+	{"tls_skip_verify", "tls-skip-verify", "SOLVYD_TLS_SKIP_VERIFY"},
+}
+
+// Loader reads the agent configuration from flags, environment variables
+// and an optional YAML config file, in that order of precedence, and reads
+// it again on reload
+type Loader struct {
+	v      *viper.Viper
+	labels *flag.Flag
+}
+
+// NewLoader creates a loader for the agent's flags. The config file is the
+// one given with --config (or SOLVYD_AGENT_CONFIG), otherwise agent.yaml in
+// the working directory, ./config or /etc/solvyd if there is one.
+func NewLoader(flags *flag.FlagSet) (*Loader, error) {
+	v := viper.New()
+	for _, s := range settings {
+		if err := v.BindPFlag(s.key, flags.Lookup(s.flag)); err != nil {
+			return nil, err
+		}
+		if err := v.BindEnv(s.key, s.env); err != nil {
+			return nil, err
+		}
+	}
+
+	configFile, _ := flags.GetString("config")
+	if configFile == "" {
+		configFile = os.Getenv("SOLVYD_AGENT_CONFIG")
+	}
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+	} else {
+		v.SetConfigName("agent")
+		v.SetConfigType("yaml")
+		v.AddConfigPath(".")
+		v.AddConfigPath("./config")
+		v.AddConfigPath("/etc/solvyd")
+	}
+
+	return &Loader{v: v, labels: flags.Lookup("label")}, nil
+}
+
+// Load reads the config file, if any, and returns the configuration
+func (l *Loader) Load() (*Config, error) {
+	if err := l.v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		// No config file; flags, env vars and defaults only
+	}
+
+	labels, err := l.readLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		APIServer:         l.v.GetString("api_server"),
+		LogServer:         l.v.GetString("log_server"),
+		WorkerName:        l.v.GetString("name"),
+		MaxConcurrent:     l.v.GetInt("max_concurrent"),
+		Pool:              l.v.GetString("pool"),
+		Labels:            labels,
+		LogLevel:          l.v.GetString("log_level"),
+		IsolationType:     l.v.GetString("isolation"),
+		PluginDir:         l.v.GetString("plugin_dir"),
+		PluginPolicy:      l.v.GetString("plugin_policy"),
+		SecretProviders:   l.v.GetString("secret_providers"),
+		CacheBackend:      l.v.GetString("cache_backend"),
+		TracingEndpoint:   l.v.GetString("tracing_endpoint"),
+		TracingInsecure:   l.v.GetBool("tracing_insecure"),
+		OneShot:           l.v.GetBool("one_shot"),
+		ToolDir:           l.v.GetString("tool_dir"),
+		BuildDiskQuotaMB:  l.v.GetInt64("build_disk_quota_mb"),
+		WorkerDiskQuotaMB: l.v.GetInt64("worker_disk_quota_mb"),
+		Proxy:             l.v.GetString("proxy"),
+		CACert:            l.v.GetString("ca_cert"),
+		TLSSkipVerify:     l.v.GetBool("tls_skip_verify"),
+	}, nil
+}
+
+// ConfigFile returns the config file read by the last Load, or "" if there
+// was none
+func (l *Loader) ConfigFile() string {
+	return l.v.ConfigFileUsed()
+}
+
+// readLabels returns the labels of the --label flags, of SOLVYD_WORKER_LABELS
+// (key=value,key=value) or of the config file's labels map, whichever comes
+// first
+func (l *Loader) readLabels() (map[string]string, error) {
+	if l.labels != nil && l.labels.Changed {
+		values, _ := l.labels.Value.(flag.SliceValue)
+		if values != nil {
+			return parseLabels(values.GetSlice())
+		}
+	}
+	if env := os.Getenv("SOLVYD_WORKER_LABELS"); env != "" {
+		return parseLabels(strings.Split(env, ","))
+	}
+
+	labels := make(map[string]string)
+	for key, value := range l.v.GetStringMapString("labels") {
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// parseLabels parses labels in the key=value format
+func parseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, want key=value", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// RestartRequired returns the settings that differ in next but are only
+// applied on restart. Labels and max concurrent builds are reloaded.
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	check := func(key string, differs bool) {
+		if differs {
+			changed = append(changed, key)
+		}
+	}
+	check("api_server", next.APIServer != c.APIServer)
+	check("log_server", next.LogServer != c.LogServer)
+	// An unset name is generated at startup, so only a new name counts
+	check("name", next.WorkerName != "" && next.WorkerName != c.WorkerName)
+	check("pool", next.Pool != c.Pool)
+	check("log_level", next.LogLevel != c.LogLevel)
+	check("isolation", next.IsolationType != c.IsolationType)
+	check("plugin_dir", next.PluginDir != c.PluginDir)
+	check("plugin_policy", next.PluginPolicy != c.PluginPolicy)
+	check("secret_providers", next.SecretProviders != c.SecretProviders)
+	check("cache_backend", next.CacheBackend != c.CacheBackend)
+	check("tracing_endpoint", next.TracingEndpoint != c.TracingEndpoint)
+	check("tracing_insecure", next.TracingInsecure != c.TracingInsecure)
+	check("one_shot", next.OneShot != c.OneShot)
+	check("tool_dir", next.ToolDir != c.ToolDir)
+	check("build_disk_quota_mb", next.BuildDiskQuotaMB != c.BuildDiskQuotaMB)
+	check("worker_disk_quota_mb", next.WorkerDiskQuotaMB != c.WorkerDiskQuotaMB)
+	check("proxy", next.Proxy != c.Proxy)
+	check("ca_cert", next.CACert != c.CACert)
+	check("tls_skip_verify", next.TLSSkipVerify != c.TLSSkipVerify)
+	return changed
+}
@@ -5,24 +5,28 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/tools"
 )
 
+// containerToolDir is where the tool directory is mounted in build containers
+const containerToolDir = "/opt/solvyd/tools"
+
 // DockerExecutor executes builds in Docker containers
 type DockerExecutor struct {
 	workDir string
+	tools   *tools.Installer
 }
 
 // NewDockerExecutor creates a new Docker executor
 func NewDockerExecutor() *DockerExecutor {
-	workDir := os.Getenv("SOLVYD_WORK_DIR")
-	if workDir == "" {
-		workDir = "/tmp/solvyd-builds"
-	}
+	workDir := WorkDir()
 	os.MkdirAll(workDir, 0755)
 
 	return &DockerExecutor{
@@ -51,44 +55,239 @@ func (e *DockerExecutor) Execute(ctx context.Context, build *BuildRequest) (*Bui
 		result.ErrorMessage = fmt.Sprintf("Failed to create build directory: %v", err)
 		return result, err
 	}
+	result.WorkDir = buildDir
 
-	// Step 1: Clone repository
-	result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Cloning repository: %s", build.SCMURL))
-	if err := e.cloneRepository(ctx, build, buildDir, result); err != nil {
+	// Step 1: Clone repository, unless resuming in a restored workspace
+	if build.Resume {
+		result.LogLines = append(result.LogLines, "[INFO] Resuming in the workspace of the build being re-run")
+	} else {
+		result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Cloning repository: %s", build.SCMURL))
+		build.event(StageStarted, "clone", "")
+		if err := e.cloneRepository(ctx, build, buildDir, result); err != nil {
+			build.event(StageFinished, "clone", "failed")
+			result.Success = false
+			result.ErrorMessage = fmt.Sprintf("Failed to clone repository: %v", err)
+			result.ExitCode = 1
+			return result, err
+		}
+		build.event(StageFinished, "clone", "success")
+	}
+
+	if build.OnCheckout != nil {
+		if err := build.OnCheckout(buildDir); err != nil {
+			result.LogLines = append(result.LogLines, fmt.Sprintf("[ERROR] %v", err))
+			result.Success = false
+			result.ErrorMessage = err.Error()
+			result.ExitCode = 1
+			result.Duration = int(time.Since(startTime).Seconds())
+			return result, nil
+		}
+	}
+
+	// A rerun from a failed stage on a worker without the original's
+	// workspace runs its stages with the inputs restored
+	if len(build.Stages) > 0 && !build.Resume {
+		e.restoreInputs(build, buildDir, result)
+	}
+
+	// Step 2: Install the tools the build declares
+	installed, err := e.installTools(ctx, build, result)
+	if err != nil {
+		result.LogLines = append(result.LogLines, fmt.Sprintf("[ERROR] Failed to install tools: %v", err))
 		result.Success = false
-		result.ErrorMessage = fmt.Sprintf("Failed to clone repository: %v", err)
+		result.ErrorMessage = fmt.Sprintf("Failed to install tools: %v", err)
 		result.ExitCode = 1
-		return result, err
+		result.Duration = int(time.Since(startTime).Seconds())
+		return result, nil
 	}
 
-	// Step 2: Get build image from config or use default
+	// Step 3: Get build image from config or use default
 	buildImage := "ubuntu:22.04"
 	if img, ok := build.BuildConfig["image"].(string); ok && img != "" {
 		buildImage = img
 	}
 
-	// Step 3: Execute build in Docker container
-	result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Using Docker image: %s", buildImage))
+	// Step 4: Execute the build's stages, or its commands as a single build
+	// stage, in Docker containers
+	if stages, ok := build.BuildConfig["stages"].([]interface{}); ok && len(stages) > 0 {
+		result.Success = true
+		env := &whenEnv{
+			branch:  build.SCMBranch,
+			params:  build.Parameters,
+			stages:  map[string]string{},
+			status:  "success",
+			changed: e.changedFiles(ctx, build, buildDir, result),
+		}
+		for _, s := range stages {
+			stage, _ := s.(map[string]interface{})
+			name, _ := stage["name"].(string)
+			if !build.runsStage(name) {
+				result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Skipping stage %s: it succeeded in the build being re-run", name))
+				env.stages[name] = "success"
+				continue
+			}
+			when, _ := stage["when"].(string)
+			run, reason, err := runsAfter(when, env)
+			if err != nil {
+				result.LogLines = append(result.LogLines, fmt.Sprintf("[ERROR] Stage %s: %v", name, err))
+				build.event(StageStarted, name, "")
+				build.event(StageFinished, name, "failed")
+				env.stages[name], env.status = "failed", "failed"
+				e.failStage(result, 1, fmt.Sprintf("Stage %s has an %v", name, err))
+				continue
+			}
+			if !run {
+				result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Skipping stage %s: %s", name, reason))
+				build.event(StageStarted, name, "")
+				build.event(StageFinished, name, StageSkipped)
+				env.stages[name] = StageSkipped
+				continue
+			}
+			image := buildImage
+			if img, ok := stage["image"].(string); ok && img != "" {
+				image = img
+			}
+			if e.runStage(ctx, build, buildDir, name, image, stringList(stage["commands"]), installed, result) {
+				env.stages[name] = "success"
+				e.saveOutputs(build, buildDir, name, stringList(stage["outputs"]), result)
+			} else {
+				env.stages[name], env.status = "failed", "failed"
+			}
+		}
+	} else {
+		commands := stringList(build.BuildConfig["commands"])
 
-	containerName := fmt.Sprintf("solvyd-build-%s", build.BuildID)
+		// Default commands if none specified
+		if len(commands) == 0 {
+			commands = []string{
+				"echo 'No build commands specified'",
+				"ls -la",
+			}
+		}
+		result.Success = true
+		e.runStage(ctx, build, buildDir, "build", buildImage, commands, installed, result)
+	}
+	if result.Success {
+		result.LogLines = append(result.LogLines, "[INFO] Build completed successfully")
+	}
+
+	// Step 5: Collect artifacts (if any)
+	if artifactsPath, ok := build.BuildConfig["artifacts"].(string); ok {
+		e.collectArtifacts(buildDir, artifactsPath, result)
+	}
+
+	result.Duration = int(time.Since(startTime).Seconds())
+
+	return result, nil
+}
 
-	// Build commands from config
-	commands := []string{}
-	if cmds, ok := build.BuildConfig["commands"].([]interface{}); ok {
-		for _, cmd := range cmds {
-			if cmdStr, ok := cmd.(string); ok {
-				commands = append(commands, cmdStr)
+// SetTools lets builds declare the tools they need, which the installer
+// installs and the build's containers find on their PATH
+func (e *DockerExecutor) SetTools(installer *tools.Installer) {
+	e.tools = installer
+}
+
+// installTools installs the tools the build config declares, as a map of
+// tool names to versions
+func (e *DockerExecutor) installTools(ctx context.Context, build *BuildRequest, result *BuildResult) ([]tools.Tool, error) {
+	raw, ok := build.BuildConfig["tools"]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("build_config.tools must map tool names to versions, such as {\"go\": \"1.22\"}")
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	if e.tools == nil {
+		return nil, fmt.Errorf("this worker does not install tools")
+	}
+	declared := make(map[string]string, len(list))
+	for name, version := range list {
+		v, ok := version.(string)
+		if !ok {
+			return nil, fmt.Errorf("build_config.tools.%s: the version must be a string, such as \"1.22\"", name)
+		}
+		declared[name] = v
+	}
+
+	return e.tools.Install(ctx, declared, func(format string, args ...interface{}) {
+		result.LogLines = append(result.LogLines, fmt.Sprintf(format, args...))
+	})
+}
+
+// restoreInputs restores the outputs of the stages the rerun stages take as
+// inputs that are not run again, from the build being re-run, so that the
+// rerun runs only its stages. If the pipeline declares no inputs, or they
+// cannot be restored, the rerun runs all stages.
+func (e *DockerExecutor) restoreInputs(build *BuildRequest, buildDir string, result *BuildResult) {
+	stages, _ := build.BuildConfig["stages"].([]interface{})
+	declared := false
+	var inputs []string
+	seen := map[string]bool{}
+	for _, s := range stages {
+		stage, _ := s.(map[string]interface{})
+		name, _ := stage["name"].(string)
+		for _, input := range stringList(stage["inputs"]) {
+			declared = true
+			if build.runsStage(name) && !build.runsStage(input) && !seen[input] {
+				seen[input] = true
+				inputs = append(inputs, input)
 			}
 		}
 	}
 
-	// Default commands if none specified
-	if len(commands) == 0 {
-		commands = []string{
-			"echo 'No build commands specified'",
-			"ls -la",
+	if !declared || build.RestoreOutputs == nil {
+		result.LogLines = append(result.LogLines, "[WARN] The stages declare no inputs to restore; running all stages")
+		build.Stages = nil
+		return
+	}
+	if len(inputs) > 0 {
+		if err := build.RestoreOutputs(inputs, buildDir); err != nil {
+			result.LogLines = append(result.LogLines, fmt.Sprintf("[WARN] Cannot restore the inputs of the stages to re-run (%v); running all stages", err))
+			build.Stages = nil
+			return
+		}
+		result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Restored the outputs of stages %s", strings.Join(inputs, ", ")))
+	}
+	result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Re-running stages %s", strings.Join(build.Stages, ", ")))
+}
+
+// saveOutputs stores the outputs a stage that succeeded declares. Outputs
+// only serve reruns, so a failure to store them is a warning.
+func (e *DockerExecutor) saveOutputs(build *BuildRequest, buildDir, stage string, outputs []string, result *BuildResult) {
+	if len(outputs) == 0 || build.SaveOutputs == nil {
+		return
+	}
+	if err := build.SaveOutputs(stage, outputs, buildDir); err != nil {
+		result.LogLines = append(result.LogLines, fmt.Sprintf("[WARN] Failed to store the outputs of stage %s: %v", stage, err))
+		return
+	}
+	result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Stored the outputs of stage %s", stage))
+}
+
+// stringList returns the strings of a list from the build config
+func stringList(value interface{}) []string {
+	list := []string{}
+	items, _ := value.([]interface{})
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
 		}
 	}
+	return list
+}
+
+// runStage runs the commands of a stage in a container of image, with the
+// workspace and the installed tools mounted, and reports whether they
+// succeeded. A failure fails the build in result, unless an earlier stage
+// already has.
+func (e *DockerExecutor) runStage(ctx context.Context, build *BuildRequest, buildDir, stage, image string, commands []string, installed []tools.Tool, result *BuildResult) bool {
+	result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Using Docker image: %s", image))
+
+	containerName := fmt.Sprintf("solvyd-build-%s", build.BuildID)
 
 	// Combine commands
 	combinedCmd := strings.Join(commands, " && ")
@@ -107,7 +306,21 @@ func (e *DockerExecutor) Execute(ctx context.Context, build *BuildRequest) (*Bui
 		dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("%s=%s", key, value))
 	}
 
-	dockerArgs = append(dockerArgs, buildImage, "sh", "-c", combinedCmd)
+	// The tools go ahead of the image's own on its PATH, which is only known
+	// in the container
+	if len(installed) > 0 {
+		dockerArgs = append(dockerArgs, "-v", fmt.Sprintf("%s:%s:ro", e.tools.Dir(), containerToolDir))
+		var bins []string
+		for _, tool := range installed {
+			bins = append(bins, path.Join(containerToolDir, tool.Bin()))
+			for key, value := range tool.Env {
+				dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("%s=%s", key, path.Join(containerToolDir, value)))
+			}
+		}
+		combinedCmd = fmt.Sprintf(`export PATH="%s:$PATH" && %s`, strings.Join(bins, ":"), combinedCmd)
+	}
+
+	dockerArgs = append(dockerArgs, image, "sh", "-c", combinedCmd)
 
 	result.LogLines = append(result.LogLines, fmt.Sprintf("[INFO] Running: docker %s", strings.Join(dockerArgs, " ")))
 
@@ -115,6 +328,7 @@ func (e *DockerExecutor) Execute(ctx context.Context, build *BuildRequest) (*Bui
 	cmd.Dir = buildDir
 
 	// Capture output
+	build.event(StageStarted, stage, "")
 	output, err := cmd.CombinedOutput()
 	outputLines := strings.Split(string(output), "\n")
 	for _, line := range outputLines {
@@ -126,28 +340,74 @@ func (e *DockerExecutor) Execute(ctx context.Context, build *BuildRequest) (*Bui
 	// Check exit code
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			result.ExitCode = exitErr.ExitCode()
-			result.Success = false
-			result.ErrorMessage = fmt.Sprintf("Build failed with exit code %d", result.ExitCode)
+			message := fmt.Sprintf("Build failed with exit code %d", exitErr.ExitCode())
+			if stage != "build" {
+				message = fmt.Sprintf("Stage %s failed with exit code %d", stage, exitErr.ExitCode())
+			}
+			e.failStage(result, exitErr.ExitCode(), message)
 		} else {
-			result.Success = false
-			result.ErrorMessage = fmt.Sprintf("Failed to execute build: %v", err)
-			result.ExitCode = 1
+			e.failStage(result, 1, fmt.Sprintf("Failed to execute build: %v", err))
 		}
-	} else {
-		result.Success = true
-		result.ExitCode = 0
-		result.LogLines = append(result.LogLines, "[INFO] Build completed successfully")
+		build.event(StageFinished, stage, "failed")
+		return false
 	}
+	build.event(StageFinished, stage, "success")
+	return true
+}
 
-	// Step 4: Collect artifacts (if any)
-	if artifactsPath, ok := build.BuildConfig["artifacts"].(string); ok {
-		e.collectArtifacts(buildDir, artifactsPath, result)
+// failStage fails the build in result with the exit code and message of a
+// failed stage, unless an earlier stage already failed it
+func (e *DockerExecutor) failStage(result *BuildResult, exitCode int, message string) {
+	if !result.Success {
+		return
 	}
+	result.Success = false
+	result.ExitCode = exitCode
+	result.ErrorMessage = message
+}
 
-	result.Duration = int(time.Since(startTime).Seconds())
+// changedFiles returns a function listing the files changed between the
+// previous successful build's commit and the checked-out one, for changed()
+// in when expressions. The list is read once, on first use. A shallow clone
+// fetches the previous commit first.
+func (e *DockerExecutor) changedFiles(ctx context.Context, build *BuildRequest, buildDir string, result *BuildResult) func() ([]string, bool) {
+	var files []string
+	var known, listed bool
+	return func() ([]string, bool) {
+		if listed {
+			return files, known
+		}
+		listed = true
+		if build.PreviousCommitSHA == "" {
+			result.LogLines = append(result.LogLines, "[INFO] No previous successful build to compare with; changed() is true")
+			return nil, false
+		}
 
-	return result, nil
+		diff := func() ([]byte, error) {
+			cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", build.PreviousCommitSHA, "HEAD")
+			cmd.Dir = buildDir
+			return cmd.Output()
+		}
+		output, err := diff()
+		if err != nil {
+			fetch := exec.CommandContext(ctx, "git", "fetch", "--depth", "1", "origin", build.PreviousCommitSHA)
+			fetch.Dir = buildDir
+			if fetch.Run() == nil {
+				output, err = diff()
+			}
+		}
+		if err != nil {
+			result.LogLines = append(result.LogLines, fmt.Sprintf("[WARN] Cannot list the files changed since %s; changed() is true", build.PreviousCommitSHA))
+			return nil, false
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			if line != "" {
+				files = append(files, line)
+			}
+		}
+		known = true
+		return files, known
+	}
 }
 
 // cloneRepository clones the Git repository
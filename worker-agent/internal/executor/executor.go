@@ -3,6 +3,8 @@ package executor
 import (
 	"context"
 	"fmt"
+
+	"github.com/solvyd/solvyd/worker-agent/internal/tools"
 )
 
 // Executor defines the interface for build execution
@@ -16,17 +18,73 @@ type Executor interface {
 
 // BuildRequest contains all information needed to execute a build
 type BuildRequest struct {
-	BuildID     string
-	JobID       string
-	SCMType     string
-	SCMURL      string
-	SCMBranch   string
-	CommitSHA   string
+	BuildID   string
+	JobID     string
+	SCMType   string
+	SCMURL    string
+	SCMBranch string
+	CommitSHA string
+	// PreviousCommitSHA is the commit of the job's previous successful build
+	// on the branch, which changed() in when expressions compares with
+	PreviousCommitSHA string
+	// Parameters are the parameters the build was triggered with, as
+	// strings, for when expressions
+	Parameters  map[string]string
 	BuildConfig map[string]interface{}
 	EnvVars     map[string]string
 	WorkDir     string
+	// OnEvent, if set, is told when a stage of the build (clone, build)
+	// starts and finishes, with how it finished
+	OnEvent func(event, stage, status string)
+	// OnCheckout, if set, is called once the repository is checked out in
+	// workDir and may replace BuildConfig, e.g. with a pipeline read from the
+	// repository. An error fails the build.
+	OnCheckout func(workDir string) error
+	// Stages, if set, are the only stages run, e.g. by a rerun from a failed
+	// stage; the others are skipped
+	Stages []string
+	// Resume is set when the workspace was restored from the build a rerun
+	// restarts, so that the repository is already checked out
+	Resume bool
+	// SaveOutputs, if set, stores the files of workDir that the outputs a
+	// stage declares match, once the stage succeeds
+	SaveOutputs func(stage string, outputs []string, workDir string) error
+	// RestoreOutputs, if set, restores the outputs stages of the build a
+	// rerun restarts stored into workDir. A rerun of Stages without Resume
+	// restores those of the stages they take as inputs, or runs all stages.
+	RestoreOutputs func(stages []string, workDir string) error
+}
+
+// runsStage reports whether the build runs a stage
+func (b *BuildRequest) runsStage(name string) bool {
+	if len(b.Stages) == 0 {
+		return true
+	}
+	for _, stage := range b.Stages {
+		if stage == name {
+			return true
+		}
+	}
+	return false
+}
+
+// event reports a stage event to OnEvent
+func (b *BuildRequest) event(event, stage, status string) {
+	if b.OnEvent != nil {
+		b.OnEvent(event, stage, status)
+	}
 }
 
+// Stage events
+const (
+	StageStarted  = "stage_started"
+	StageFinished = "stage_finished"
+)
+
+// StageSkipped is the status a stage that did not run finishes with, after
+// a start at the same time, so that it is marked in the build timeline
+const StageSkipped = "skipped"
+
 // BuildResult contains the result of a build execution
 type BuildResult struct {
 	Success      bool
@@ -35,6 +93,24 @@ type BuildResult struct {
 	Duration     int // seconds
 	LogLines     []string
 	Artifacts    []Artifact
+	WorkDir      string // checked-out workspace, kept until Cleanup
+}
+
+// WorkspaceKeeper is implemented by executors that can keep the workspace
+// of a failed build, for a rerun from its failed stage to resume in
+type WorkspaceKeeper interface {
+	// KeepWorkspace keeps a build's workspace past Cleanup, for a while
+	KeepWorkspace(buildID string) error
+	// RestoreWorkspace makes the kept workspace of build originalID the
+	// workspace of build buildID, reporting false if it is not kept
+	RestoreWorkspace(originalID, buildID string) (bool, error)
+}
+
+// ToolInstaller is implemented by executors that can install the tools a
+// build config declares under tools, such as {"go": "1.22"}, and put them on
+// the PATH of its stages
+type ToolInstaller interface {
+	SetTools(installer *tools.Installer)
 }
 
 // Artifact represents a build artifact
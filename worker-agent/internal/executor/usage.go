@@ -0,0 +1,110 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BuildUsage is what a running build uses of the worker's resources
+type BuildUsage struct {
+	BuildID    string  `json:"build_id"`
+	CPUPercent float64 `json:"cpu_percent"` // of one core
+	MemoryMB   int64   `json:"memory_mb"`
+	DiskMB     int64   `json:"disk_mb"` // of its workspace
+}
+
+// UsageReporter is implemented by executors that can measure the resources
+// of the builds they are running
+type UsageReporter interface {
+	Usage(ctx context.Context, buildID string) (*BuildUsage, error)
+}
+
+// WorkDir returns the directory build workspaces are created in
+func WorkDir() string {
+	if workDir := os.Getenv("SOLVYD_WORK_DIR"); workDir != "" {
+		return workDir
+	}
+	return "/tmp/solvyd-builds"
+}
+
+// Usage measures a running build's container and workspace. CPU and memory
+// are zero between stages, while no container runs.
+func (e *DockerExecutor) Usage(ctx context.Context, buildID string) (*BuildUsage, error) {
+	usage := &BuildUsage{BuildID: buildID}
+
+	diskBytes, err := DirSize(filepath.Join(e.workDir, buildID))
+	if err != nil {
+		return nil, err
+	}
+	usage.DiskMB = diskBytes / (1 << 20)
+
+	containerName := fmt.Sprintf("solvyd-build-%s", buildID)
+	output, err := exec.CommandContext(ctx, "docker", "stats", "--no-stream", "--format", "{{json .}}", containerName).Output()
+	if err != nil {
+		return usage, nil // No container running
+	}
+	var stats struct {
+		CPUPerc  string `json:"CPUPerc"`
+		MemUsage string `json:"MemUsage"`
+	}
+	if err := json.Unmarshal(output, &stats); err != nil {
+		return nil, fmt.Errorf("unexpected docker stats output: %w", err)
+	}
+	usage.CPUPercent, _ = strconv.ParseFloat(strings.TrimSuffix(stats.CPUPerc, "%"), 64)
+	if used, _, ok := strings.Cut(stats.MemUsage, "/"); ok {
+		usage.MemoryMB = parseDockerSize(strings.TrimSpace(used)) / (1 << 20)
+	}
+	return usage, nil
+}
+
+// dockerSizeUnits are the units of the sizes docker stats reports
+var dockerSizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseDockerSize parses a size such as 12.5MiB into bytes
+func parseDockerSize(size string) int64 {
+	for _, unit := range dockerSizeUnits {
+		if number, ok := strings.CutSuffix(size, unit.suffix); ok {
+			value, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0
+			}
+			return int64(value * unit.bytes)
+		}
+	}
+	return 0
+}
+
+// DirSize returns the size of the files under dir, or 0 if it does not exist
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files may be removed by the build while walking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size, err
+}
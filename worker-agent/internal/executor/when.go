@@ -0,0 +1,379 @@
+package executor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A stage's when expression decides, as the build reaches the stage, whether
+// it runs:
+//
+//	branch == "main" && changed("src/**", "go.mod")
+//	params.deploy || stages.test == "failed"
+//
+// Operands are string literals and these values:
+//
+//	branch        the build's branch
+//	params.NAME   a parameter the build was triggered with, or ""
+//	stages.NAME   how an earlier stage ended: success, failed or skipped, or "" if it did not run
+//	status        the build's status so far: success or failed
+//
+// They are compared with == and !=, and conditions are combined with &&, ||
+// and ! and grouped with parentheses. changed("glob", ...) is true if a file
+// changed since the previous successful build matches one of the globs, in
+// which ** matches any number of directories. An operand on its own is true
+// unless it is "", "false" or "0".
+//
+// Once a stage fails, later stages are skipped unless their expression
+// refers to status or to a stage, and so decides for itself whether to run
+// after a failure.
+
+// whenNode is a node of a parsed when expression
+type whenNode struct {
+	op    string // lit, ref, ==, !=, &&, ||, !, changed
+	value string // the literal, or the reference such as params.deploy
+	args  []*whenNode
+}
+
+// whenCondition is a parsed when expression
+type whenCondition struct {
+	root *whenNode
+	// checksStatus is whether the expression refers to status or a stage
+	checksStatus bool
+}
+
+// whenEnv is what a when expression is evaluated against
+type whenEnv struct {
+	branch string
+	params map[string]string
+	stages map[string]string
+	status string
+	// changed returns the files changed since the previous successful
+	// build, or false if they are not known
+	changed func() ([]string, bool)
+}
+
+// runsAfter decides whether a stage with the when expression, which may be
+// empty, runs at this point of the build. If not, it returns why.
+func runsAfter(when string, env *whenEnv) (bool, string, error) {
+	if when == "" {
+		if env.status != "success" {
+			return false, "an earlier stage failed", nil
+		}
+		return true, "", nil
+	}
+	cond, err := parseWhen(when)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid when expression %q: %w", when, err)
+	}
+	if !cond.checksStatus && env.status != "success" {
+		return false, "an earlier stage failed", nil
+	}
+	if !cond.holds(env) {
+		return false, "when " + when + " is false", nil
+	}
+	return true, "", nil
+}
+
+// parseWhen parses a when expression
+func parseWhen(expr string) (*whenCondition, error) {
+	tokens, err := tokenizeWhen(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whenParser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", describeToken(p.tokens[p.pos]))
+	}
+	return &whenCondition{root: root, checksStatus: p.checksStatus}, nil
+}
+
+// holds evaluates the expression
+func (c *whenCondition) holds(env *whenEnv) bool {
+	return c.root.test(env)
+}
+
+func (n *whenNode) test(env *whenEnv) bool {
+	switch n.op {
+	case "==":
+		return n.args[0].operand(env) == n.args[1].operand(env)
+	case "!=":
+		return n.args[0].operand(env) != n.args[1].operand(env)
+	case "&&":
+		return n.args[0].test(env) && n.args[1].test(env)
+	case "||":
+		return n.args[0].test(env) || n.args[1].test(env)
+	case "!":
+		return !n.args[0].test(env)
+	case "changed":
+		files, known := env.changed()
+		if !known {
+			// Without a previous build to compare with, everything changed
+			return true
+		}
+		for _, arg := range n.args {
+			for _, file := range files {
+				if matchGlob(arg.value, file) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch v := n.operand(env); v {
+	case "", "false", "0":
+		return false
+	}
+	return true
+}
+
+// operand returns the value of a literal or reference
+func (n *whenNode) operand(env *whenEnv) string {
+	if n.op == "lit" {
+		return n.value
+	}
+	scope, name, _ := strings.Cut(n.value, ".")
+	switch scope {
+	case "branch":
+		return env.branch
+	case "status":
+		return env.status
+	case "params":
+		return env.params[name]
+	case "stages":
+		return env.stages[name]
+	}
+	return ""
+}
+
+// whenParser is a recursive descent parser of when expressions:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" or ")" | changed | operand [ ("==" | "!=") operand ]
+//	changed = "changed" "(" string { "," string } ")"
+//	operand = string | reference
+type whenParser struct {
+	tokens       []string
+	pos          int
+	checksStatus bool
+}
+
+func (p *whenParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *whenParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *whenParser) expect(want string) error {
+	if got := p.next(); got != want {
+		return fmt.Errorf("expected %s, got %s", want, describeToken(got))
+	}
+	return nil
+}
+
+func (p *whenParser) or() (*whenNode, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right *whenNode
+		if right, err = p.and(); err == nil {
+			left = &whenNode{op: "||", args: []*whenNode{left, right}}
+		}
+	}
+	return left, err
+}
+
+func (p *whenParser) and() (*whenNode, error) {
+	left, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right *whenNode
+		if right, err = p.unary(); err == nil {
+			left = &whenNode{op: "&&", args: []*whenNode{left, right}}
+		}
+	}
+	return left, err
+}
+
+func (p *whenParser) unary() (*whenNode, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		arg, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &whenNode{op: "!", args: []*whenNode{arg}}, nil
+	case "(":
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case "changed":
+		return p.changed()
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peek(); op == "==" || op == "!=" {
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &whenNode{op: op, args: []*whenNode{left, right}}, nil
+	}
+	return left, nil
+}
+
+func (p *whenParser) changed() (*whenNode, error) {
+	p.next()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	node := &whenNode{op: "changed"}
+	for {
+		t := p.next()
+		if !strings.HasPrefix(t, `"`) {
+			return nil, fmt.Errorf("changed takes glob strings, got %s", describeToken(t))
+		}
+		node.args = append(node.args, &whenNode{op: "lit", value: t[1:]})
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	return node, p.expect(")")
+}
+
+func (p *whenParser) operand() (*whenNode, error) {
+	t := p.next()
+	if strings.HasPrefix(t, `"`) {
+		return &whenNode{op: "lit", value: t[1:]}, nil
+	}
+	scope, name, dotted := strings.Cut(t, ".")
+	switch {
+	case (scope == "branch" || scope == "status") && !dotted:
+	case (scope == "params" || scope == "stages") && dotted && name != "":
+	default:
+		return nil, fmt.Errorf("expected a string, branch, status, params.NAME or stages.NAME, got %s", describeToken(t))
+	}
+	if scope == "status" || scope == "stages" {
+		p.checksStatus = true
+	}
+	return &whenNode{op: "ref", value: t}, nil
+}
+
+// tokenizeWhen splits an expression into operators, parentheses, commas,
+// words and string literals. String literals are returned with their opening
+// quote and without escapes.
+func tokenizeWhen(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") ||
+			strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case c == '!':
+			tokens = append(tokens, "!")
+			i++
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			b.WriteByte('"')
+			j := i + 1
+			for ; j < len(expr) && expr[j] != c; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) {
+					j++
+				}
+				b.WriteByte(expr[j])
+			}
+			if j == len(expr) {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			tokens = append(tokens, b.String())
+			i = j + 1
+		case isWordChar(c):
+			j := i
+			for j < len(expr) && isWordChar(expr[j]) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i+1)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return tokens, nil
+}
+
+// isWordChar reports whether c can be part of a reference, which includes
+// the characters of stage and parameter names
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.'
+}
+
+func describeToken(t string) string {
+	switch {
+	case t == "":
+		return "the end"
+	case strings.HasPrefix(t, `"`):
+		return fmt.Sprintf("string %q", t[1:])
+	}
+	return t
+}
+
+// matchGlob matches a slash-separated path against a glob in which **
+// matches any number of path segments and the rest of each segment follows
+// path.Match
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,162 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func testEnv() *whenEnv {
+	return &whenEnv{
+		branch: "main",
+		params: map[string]string{"deploy": "true", "dry_run": "false", "region": "eu-west-1"},
+		stages: map[string]string{"build": "success", "test": "failed"},
+		status: "success",
+		changed: func() ([]string, bool) {
+			return []string{"src/api/handler.go", "docs/README.md"}, true
+		},
+	}
+}
+
+func TestWhenHolds(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`branch == "main"`, true},
+		{`branch == 'main'`, true},
+		{`branch != "main"`, false},
+		{`"main" == branch`, true},
+		{`params.deploy`, true},
+		{`params.dry_run`, false},
+		{`params.missing`, false},
+		{`!params.missing`, true},
+		{`"0"`, false},
+		{`""`, false},
+		{`"yes"`, true},
+		{`params.region == "eu-west-1" && branch == "main"`, true},
+		{`params.region == "us-east-1" || branch == "main"`, true},
+		{`params.dry_run || branch == "dev" && params.deploy`, false},
+		{`(params.dry_run || branch == "main") && params.deploy`, true},
+		{`!(branch == "main")`, false},
+		{`!!params.deploy`, true},
+		{`stages.test == "failed"`, true},
+		{`stages.build == "success" && stages.lint == ""`, true},
+		{`status == "success"`, true},
+		{`changed("src/**")`, true},
+		{`changed("*.md")`, false},
+		{`changed("**/*.md")`, true},
+		{`changed("go.mod", "docs/*")`, true},
+		{`changed("src/*.go")`, false},
+		{`changed("src/**/*.go") && branch == "main"`, true},
+		{`branch == "it's"`, false},
+		{`"a\"b" == 'a"b'`, true},
+	}
+	for _, tt := range tests {
+		cond, err := parseWhen(tt.expr)
+		if err != nil {
+			t.Errorf("parseWhen(%s): %v", tt.expr, err)
+			continue
+		}
+		if got := cond.holds(testEnv()); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{``, "empty expression"},
+		{`   `, "empty expression"},
+		{`branch ==`, "got the end"},
+		{`branch = "main"`, `unexpected '='`},
+		{`branch == "main`, "unterminated string"},
+		{`(branch == "main"`, "expected ), got the end"},
+		{`branch == "main")`, "unexpected )"},
+		{`branch "main"`, `unexpected string "main"`},
+		{`commit == "abc"`, "got commit"},
+		{`params. == "x"`, "got params."},
+		{`stages`, "got stages"},
+		{`branch.name == "x"`, "got branch.name"},
+		{`changed()`, "changed takes glob strings, got )"},
+		{`changed(branch)`, "changed takes glob strings, got branch"},
+		{`changed("a",)`, "changed takes glob strings, got )"},
+		{`changed "a"`, `expected (, got string "a"`},
+		{`branch && && params.deploy`, "got &&"},
+	}
+	for _, tt := range tests {
+		_, err := parseWhen(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseWhen(%s) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestRunsAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		when       string
+		status     string
+		unknown    bool
+		want       bool
+		wantReason string
+		wantErr    bool
+	}{
+		{name: "no expression", want: true},
+		{name: "no expression after a failure", status: "failed", wantReason: "an earlier stage failed"},
+		{name: "true", when: `branch == "main"`, want: true},
+		{name: "false", when: `branch == "dev"`, wantReason: `when branch == "dev" is false`},
+		{name: "not checking status after a failure", when: `branch == "main"`, status: "failed", wantReason: "an earlier stage failed"},
+		{name: "checking status after a failure", when: `status == "failed"`, status: "failed", want: true},
+		{name: "checking a stage after a failure", when: `stages.test == "failed"`, status: "failed", want: true},
+		{name: "changes not known", when: `changed("*.md")`, unknown: true, want: true},
+		{name: "invalid", when: `branch ==`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := testEnv()
+			if tt.status != "" {
+				env.status = tt.status
+			}
+			if tt.unknown {
+				env.changed = func() ([]string, bool) { return nil, false }
+			}
+			got, reason, err := runsAfter(tt.when, env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want || reason != tt.wantReason {
+				t.Errorf("runsAfter = %v, %q, want %v, %q", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"go.mod", "go.mod", true},
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"**", "cmd/main.go", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/app/main.go", true},
+		{"src/**", "src", true},
+		{"src/**", "src/a/b", true},
+		{"src/**", "srcx/a", false},
+		{"src/**/test/*.go", "src/test/a.go", true},
+		{"src/**/test/*.go", "src/a/b/test/a.go", true},
+		{"src/**/test/*.go", "src/a/b/test/c/a.go", false},
+		{"docs/?.md", "docs/a.md", true},
+		{"docs/[a-c].md", "docs/d.md", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// keptDir is the directory of the work directory failed builds'
+	// workspaces are kept in
+	keptDir = ".kept"
+	// keptWorkspaceTTL is how long a failed build's workspace is kept
+	keptWorkspaceTTL = 24 * time.Hour
+)
+
+// KeepWorkspace moves a build's workspace aside, where Cleanup leaves it,
+// and removes the workspaces kept for longer than a day
+func (e *DockerExecutor) KeepWorkspace(buildID string) error {
+	kept := filepath.Join(e.workDir, keptDir)
+	if err := os.MkdirAll(kept, 0755); err != nil {
+		return err
+	}
+	e.pruneKeptWorkspaces(kept)
+
+	dest := filepath.Join(kept, buildID)
+	if err := os.Rename(filepath.Join(e.workDir, buildID), dest); err != nil {
+		return err
+	}
+	// The age of a kept workspace is that of its directory
+	now := time.Now()
+	return os.Chtimes(dest, now, now)
+}
+
+// RestoreWorkspace moves the kept workspace of build originalID into place
+// as the workspace of build buildID
+func (e *DockerExecutor) RestoreWorkspace(originalID, buildID string) (bool, error) {
+	src := filepath.Join(e.workDir, keptDir, originalID)
+	if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	dest := filepath.Join(e.workDir, buildID)
+	if err := os.RemoveAll(dest); err != nil {
+		return false, err
+	}
+	if err := os.Rename(src, dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pruneKeptWorkspaces removes the workspaces kept for longer than the TTL
+func (e *DockerExecutor) pruneKeptWorkspaces(kept string) {
+	entries, err := os.ReadDir(kept)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < keptWorkspaceTTL {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(kept, entry.Name())); err != nil {
+			log.Warn().Err(err).Str("build_id", entry.Name()).Msg("Failed to remove kept workspace")
+			continue
+		}
+		log.Debug().Str("build_id", entry.Name()).Msg("Removed expired kept workspace")
+	}
+}
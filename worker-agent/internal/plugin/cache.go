@@ -0,0 +1,185 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CacheBackend is a cache plugin and the config it is initialized with. Cache
+// plugins store dependency cache entries, directories saved and restored by
+// key, in storage such as an NFS share or an internal blob store.
+type CacheBackend struct {
+	Plugin string                 `json:"plugin"`
+	Config map[string]interface{} `json:"config"`
+}
+
+// LoadCacheBackend reads a cache backend file:
+//
+//	{"plugin": "dir-cache", "config": {"dir": "/mnt/solvyd-cache"}}
+func LoadCacheBackend(path string) (*CacheBackend, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache backend file: %w", err)
+	}
+	var backend CacheBackend
+	if err := json.Unmarshal(data, &backend); err != nil {
+		return nil, fmt.Errorf("invalid cache backend file: %w", err)
+	}
+	if backend.Plugin == "" {
+		return nil, fmt.Errorf("cache backend has no plugin")
+	}
+	return &backend, nil
+}
+
+// SetCacheBackend sets the cache plugin step caches are stored with
+func (m *Manager) SetCacheBackend(backend *CacheBackend) {
+	m.cacheBackend = backend
+}
+
+// StepCache is a workspace directory, such as a vulnerability database, that
+// a step restores from the cache before it runs and saves after it succeeds.
+// Keys are shared by every job on workers using the same backend.
+type StepCache struct {
+	Key  string
+	Path string // relative to the workspace
+}
+
+// parseCaches reads a step's caches list
+func parseCaches(v interface{}) ([]StepCache, error) {
+	if v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a list")
+	}
+	caches := make([]StepCache, 0, len(items))
+	for i, item := range items {
+		raw, _ := item.(map[string]interface{})
+		var c StepCache
+		c.Key, _ = raw["key"].(string)
+		c.Path, _ = raw["path"].(string)
+		if c.Key == "" || c.Path == "" {
+			return nil, fmt.Errorf("[%d] must have a key and a path", i)
+		}
+		clean := filepath.Clean(c.Path)
+		if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("[%d] path must be a directory inside the workspace", i)
+		}
+		c.Path = clean
+		caches = append(caches, c)
+	}
+	return caches, nil
+}
+
+// restoreCaches restores a step's caches into the workspace and returns the
+// cache plugin, still running so the caches can be saved once the step
+// succeeds. Caches only make steps faster, so problems are passed to sink as
+// warnings and the step runs without them. The result is nil if the step has
+// no caches or they cannot be used.
+func (m *Manager) restoreCaches(ctx context.Context, step Step, workDir string, sink LogSink) *Cache {
+	if len(step.Caches) == 0 {
+		return nil
+	}
+	if m.cacheBackend == nil {
+		sink(LogEntry{Level: "warn", Message: "Step caches are not restored: the worker has no cache backend"})
+		return nil
+	}
+	cache, err := m.OpenCache(ctx, *m.cacheBackend, workDir)
+	if err != nil {
+		sink(LogEntry{Level: "warn", Message: fmt.Sprintf("Step caches are not restored: %v", err)})
+		return nil
+	}
+	for _, c := range step.Caches {
+		found, err := cache.Restore(ctx, c.Key, filepath.Join(workDir, c.Path))
+		switch {
+		case err != nil:
+			sink(LogEntry{Level: "warn", Message: err.Error()})
+		case found:
+			sink(LogEntry{Level: "info", Message: fmt.Sprintf("Restored cache %s into %s", c.Key, c.Path)})
+		default:
+			sink(LogEntry{Level: "info", Message: fmt.Sprintf("No cache stored under %s", c.Key)})
+		}
+	}
+	return cache
+}
+
+// saveCaches saves a step's caches from the workspace, skipping directories
+// the step did not create
+func (m *Manager) saveCaches(ctx context.Context, cache *Cache, step Step, workDir string, sink LogSink) {
+	for _, c := range step.Caches {
+		src := filepath.Join(workDir, c.Path)
+		if info, err := os.Stat(src); err != nil || !info.IsDir() {
+			sink(LogEntry{Level: "warn", Message: fmt.Sprintf("Cache %s is not saved: %s is not a directory", c.Key, c.Path)})
+			continue
+		}
+		if err := cache.Save(ctx, c.Key, src); err != nil {
+			sink(LogEntry{Level: "warn", Message: err.Error()})
+			continue
+		}
+		sink(LogEntry{Level: "info", Message: fmt.Sprintf("Saved %s to cache %s", c.Path, c.Key)})
+	}
+}
+
+// Cache is a running cache plugin
+type Cache struct {
+	name string
+	proc *pluginProcess
+}
+
+// OpenCache starts a cache plugin under its sandbox policy and initializes it.
+// workDir is the workspace entries are saved from and restored into, which
+// the policy may limit the plugin to. Close stops the plugin.
+func (m *Manager) OpenCache(ctx context.Context, backend CacheBackend, workDir string) (*Cache, error) {
+	proc, err := m.open(ctx, backend.Plugin, backend.Config, workDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := proc.client.Health(ctx); err != nil {
+		proc.close()
+		return nil, fmt.Errorf("plugin %s is not healthy: %w", backend.Plugin, err)
+	}
+	return &Cache{name: backend.Plugin, proc: proc}, nil
+}
+
+// Save stores the directory src under key
+func (c *Cache) Save(ctx context.Context, key, src string) error {
+	if _, err := c.proc.client.cacheCall(ctx, "CacheSave", key, src); err != nil {
+		return fmt.Errorf("cache plugin %s failed to save %s: %w", c.name, key, err)
+	}
+	return nil
+}
+
+// Restore fills dest with the entry stored under key, reporting false if there is none
+func (c *Cache) Restore(ctx context.Context, key, dest string) (bool, error) {
+	found, err := c.proc.client.cacheCall(ctx, "CacheRestore", key, dest)
+	if err != nil {
+		return false, fmt.Errorf("cache plugin %s failed to restore %s: %w", c.name, key, err)
+	}
+	return found, nil
+}
+
+// Exists reports whether an entry is stored under key
+func (c *Cache) Exists(ctx context.Context, key string) (bool, error) {
+	found, err := c.proc.client.cacheCall(ctx, "CacheExists", key, "")
+	if err != nil {
+		return false, fmt.Errorf("cache plugin %s failed to look up %s: %w", c.name, key, err)
+	}
+	return found, nil
+}
+
+// Close cleans up and stops the plugin
+func (c *Cache) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := c.proc.client.Cleanup(ctx); err != nil {
+		log.Warn().Err(err).Str("plugin", c.name).Msg("Cache plugin cleanup failed")
+	}
+	c.proc.close()
+}
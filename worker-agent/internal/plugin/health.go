@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CapabilityNetwork is network access beyond Unix domain sockets. Native
+// plugins get it unless their sandbox policy denies it; WASM plugins never do.
+const CapabilityNetwork = "network"
+
+// healthCheckTimeout bounds a plugin's capability negotiation, Initialize and Health calls
+const healthCheckTimeout = 30 * time.Second
+
+// Check verifies that a step can run before the build starts: the plugin is
+// installed, the secret providers its secrets reference are registered, its
+// sandbox policy provides the capabilities it requires, and it
+// initializes with the step's config and passes its health check. WASM plugins
+// are only checked against their policy here; they report capabilities and
+// health when the step runs, since they cannot depend on external tools.
+func (m *Manager) Check(ctx context.Context, step Step) error {
+	path, err := m.lookup(ctx, step.Name)
+	if err != nil {
+		return err
+	}
+	if err := m.checkSecrets(step); err != nil {
+		return err
+	}
+	policy := m.policies.For(step.Name)
+	if strings.HasSuffix(path, ".wasm") {
+		if _, err := wasmGrants(step, policy); err != nil {
+			return fmt.Errorf("plugin %s: %w", step.Name, err)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	proc, err := m.start(step.Name, path, policy, "")
+	if err != nil {
+		return err
+	}
+	defer proc.close()
+	p := proc.client
+
+	if err := negotiate(ctx, p, policy); err != nil {
+		return fmt.Errorf("plugin %s %w", step.Name, err)
+	}
+	if err := p.Initialize(ctx, stepConfig(step)); err != nil {
+		return fmt.Errorf("plugin %s failed to initialize: %w", step.Name, err)
+	}
+	healthErr := p.Health(ctx)
+	if err := p.Cleanup(ctx); err != nil {
+		log.Warn().Err(err).Str("plugin", step.Name).Msg("Plugin cleanup failed")
+	}
+	if healthErr != nil {
+		return fmt.Errorf("plugin %s is not healthy: %w", step.Name, healthErr)
+	}
+	return nil
+}
+
+// negotiate fails if the policy cannot provide a capability the plugin requires.
+// Errors read as a continuation of "plugin <name>".
+func negotiate(ctx context.Context, p *Client, policy Policy) error {
+	caps, err := p.Capabilities(ctx)
+	if err != nil {
+		return fmt.Errorf("did not report its capabilities: %w", err)
+	}
+	for _, c := range caps {
+		var denied string
+		switch c {
+		case CapabilityNetwork:
+			if !policy.Network {
+				denied = "network access"
+			}
+		case CapabilityWorkspace:
+			if policy.Filesystem == FilesystemReadOnly {
+				denied = "write access to the workspace"
+			}
+		case CapabilityEnv:
+			if len(policy.Env) == 0 {
+				denied = "build environment variables"
+			}
+		case CapabilitySecrets:
+			if !policy.Secrets {
+				denied = "build secrets"
+			}
+		case CapabilityWorkspaceReadOnly:
+		default:
+			return fmt.Errorf("requires capability %q, which this worker agent does not support", c)
+		}
+		if denied != "" {
+			return fmt.Errorf("requires %s, which its sandbox policy denies", denied)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+)
+
+// Step is a plugin invocation configured on a job
+type Step struct {
+	Name            string
+	Config          map[string]interface{}
+	ContinueOnError bool
+	AlwaysRun       bool          // run even after an earlier step failed the build, e.g. to notify
+	Capabilities    []string      // granted to WASM plugins
+	Timeout         time.Duration // per attempt, 0 for none
+	Retry           RetryPolicy
+	Secrets         map[string]string // name -> "<provider>:<reference>"
+	FailOnFindings  string            // fail on new findings of this severity or above, "" to leave it to the plugin
+	Caches          []StepCache       // workspace directories restored before the step and saved after it succeeds
+}
+
+// Manager discovers plugins and runs them, either as native subprocesses or as
+// WebAssembly modules in an embedded runtime
+type Manager struct {
+	dir       string
+	wasmCache wazero.CompilationCache
+	policies  *Policies
+
+	secretProviders map[string]SecretProvider
+	cacheBackend    *CacheBackend
+
+	apiURL     string // set to download installed plugins from the API server
+	httpClient *http.Client
+	syncMu     sync.Mutex // serializes Sync
+
+	mu        sync.RWMutex
+	plugins   map[string]string // name -> executable or .wasm module path
+	checksums map[string]localChecksum
+}
+
+// NewManager creates a plugin manager for the given plugin directory
+func NewManager(dir string) *Manager {
+	return &Manager{
+		dir:       dir,
+		wasmCache: wazero.NewCompilationCache(),
+		policies:  DefaultPolicies(),
+		plugins:   make(map[string]string),
+		checksums: make(map[string]localChecksum),
+	}
+}
+
+// SetPolicies sets the sandbox policies plugins run under
+func (m *Manager) SetPolicies(policies *Policies) {
+	m.policies = policies
+}
+
+// Discover scans the plugin directory. A plugin is an executable or a
+// <name>.wasm module named after the plugin, either directly in the directory
+// or in a subdirectory of the same name.
+func (m *Manager) Discover() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	found := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(m.dir, name)
+		if strings.HasPrefix(name, ".") {
+			continue // includes downloads in progress
+		}
+
+		if !entry.IsDir() && strings.HasSuffix(name, ".wasm") {
+			found[strings.TrimSuffix(name, ".wasm")] = path
+			continue
+		}
+		if entry.IsDir() {
+			if wasm := filepath.Join(path, name+".wasm"); isFile(wasm) {
+				found[name] = wasm
+				continue
+			}
+			path = filepath.Join(path, name)
+		}
+		if isExecutable(path) {
+			found[name] = path
+		}
+	}
+
+	m.mu.Lock()
+	m.plugins = found
+	m.mu.Unlock()
+
+	log.Info().Str("dir", m.dir).Strs("plugins", m.Plugins()).Msg("Discovered plugins")
+	return nil
+}
+
+// Plugins returns the names of all discovered plugins
+func (m *Manager) Plugins() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.plugins))
+	for name := range m.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns the executable for a plugin, syncing and rescanning once for
+// plugins installed after startup
+func (m *Manager) lookup(ctx context.Context, name string) (string, error) {
+	m.mu.RLock()
+	path, ok := m.plugins[name]
+	m.mu.RUnlock()
+	if ok {
+		return path, nil
+	}
+
+	if err := m.Sync(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to sync installed plugins")
+	}
+	if err := m.Discover(); err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if path, ok := m.plugins[name]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("plugin %q not found in %s", name, m.dir)
+}
+
+// cleanupTimeout bounds a plugin's Cleanup call after Execute returns
+const cleanupTimeout = 10 * time.Second
+
+// runAttempt launches the plugin for a step and calls Initialize, Execute and
+// Cleanup. The plugin's log entries and output lines are passed to sink as they
+// are produced. Cancelling ctx cancels the plugin's execution context; Cleanup
+// is still called before the plugin process is killed. The result is nil if
+// the plugin did not run to completion.
+func (m *Manager) runAttempt(ctx context.Context, step Step, execCtx *ExecutionContext, sink LogSink) (*Result, error) {
+	path, err := m.lookup(ctx, step.Name)
+	if err != nil {
+		return nil, err
+	}
+	policy := m.policies.For(step.Name)
+	if strings.HasSuffix(path, ".wasm") {
+		return m.runWASM(ctx, path, step, policy, execCtx, sink)
+	}
+
+	proc, err := m.start(step.Name, path, policy, execCtx.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	defer proc.close()
+	p := proc.client
+	execCtx = policy.filterContext(execCtx)
+	if policy.Secrets && len(step.Secrets) > 0 {
+		secrets := m.newSecretResolver(step)
+		defer secrets.close()
+		execCtx.ResolvableSecrets = secretNames(step)
+		execCtx.SecretBroker = p.serveSecrets(secrets)
+	}
+
+	info, err := p.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().
+		Str("build_id", execCtx.BuildID).
+		Str("plugin", info.Name).
+		Str("version", info.Version).
+		Str("type", info.Type).
+		Bool("sandboxed", policy.Confined()).
+		Msg("Running plugin step")
+
+	if err := negotiate(ctx, p, policy); err != nil {
+		return nil, fmt.Errorf("plugin %s %w", step.Name, err)
+	}
+	if err := p.Initialize(ctx, stepConfig(step)); err != nil {
+		return nil, fmt.Errorf("plugin %s failed to initialize: %w", step.Name, err)
+	}
+
+	result, err := p.Execute(ctx, execCtx, sink)
+
+	// Let the plugin clean up even if the build was cancelled
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+	if cleanupErr := p.Cleanup(cleanupCtx); cleanupErr != nil {
+		log.Warn().Err(cleanupErr).Str("plugin", step.Name).Msg("Plugin cleanup failed")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return result, fmt.Errorf("plugin %s: %s", step.Name, result.Error)
+	}
+	return result, nil
+}
+
+// pluginProcess is a native plugin subprocess and its private temp directory
+type pluginProcess struct {
+	client *Client
+	conn   *goplugin.Client
+	tmpDir string
+}
+
+func (p *pluginProcess) close() {
+	p.conn.Kill()
+	os.RemoveAll(p.tmpDir)
+}
+
+// start launches a native plugin under its sandbox policy and connects to it
+func (m *Manager) start(name, path string, policy Policy, workDir string) (*pluginProcess, error) {
+	tmpDir, err := os.MkdirTemp("", "solvyd-plugin-"+name+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := pluginCommand(path, policy, workDir, tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+	cmd.Env = policy.processEnv(tmpDir)
+
+	conn := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          goplugin.PluginSet{pluginName: &grpcPlugin{}},
+		Cmd:              cmd,
+		SkipHostEnv:      true,
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		// Without network access the plugin may not connect(2), so brokered
+		// services such as secrets run over the connection the agent opened
+		GRPCBrokerMultiplex: !policy.Network,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin." + name,
+			Output: os.Stderr,
+			Level:  hclog.Warn,
+		}),
+	})
+	proc := &pluginProcess{conn: conn, tmpDir: tmpDir}
+
+	rpcClient, err := conn.Client()
+	if err != nil {
+		proc.close()
+		return nil, fmt.Errorf("failed to start plugin %s: %w", name, err)
+	}
+	raw, err := rpcClient.Dispense(pluginName)
+	if err != nil {
+		proc.close()
+		return nil, fmt.Errorf("failed to connect to plugin %s: %w", name, err)
+	}
+	proc.client = raw.(*Client)
+	return proc, nil
+}
+
+// open starts a native plugin that serves the agent rather than running as a
+// step, such as a secret provider or cache plugin, negotiates its capabilities
+// and initializes it with config
+func (m *Manager) open(ctx context.Context, name string, config map[string]interface{}, workDir string) (*pluginProcess, error) {
+	path, err := m.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".wasm") {
+		return nil, fmt.Errorf("plugin %s is a WASM plugin, which can only run as a step", name)
+	}
+
+	policy := m.policies.For(name)
+	proc, err := m.start(name, path, policy, workDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := negotiate(ctx, proc.client, policy); err != nil {
+		proc.close()
+		return nil, fmt.Errorf("plugin %s %w", name, err)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	if err := proc.client.Initialize(ctx, config); err != nil {
+		proc.close()
+		return nil, fmt.Errorf("plugin %s failed to initialize: %w", name, err)
+	}
+	return proc, nil
+}
+
+func stepConfig(step Step) map[string]interface{} {
+	if step.Config == nil {
+		return map[string]interface{}{}
+	}
+	return step.Config
+}
+
+// ParseSteps converts a job's plugins column into steps
+func ParseSteps(raw interface{}) ([]Step, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("plugins must be a list")
+	}
+
+	steps := make([]Step, 0, len(items))
+	for i, item := range items {
+		var step Step
+		switch v := item.(type) {
+		case string:
+			step.Name = v
+		case map[string]interface{}:
+			step.Name, _ = v["name"].(string)
+			step.Config, _ = v["config"].(map[string]interface{})
+			step.ContinueOnError, _ = v["continue_on_error"].(bool)
+			step.AlwaysRun, _ = v["always_run"].(bool)
+			var err error
+			if step.Timeout, err = parseDuration(v["timeout"]); err != nil {
+				return nil, fmt.Errorf("plugins[%d] timeout %v", i, err)
+			}
+			if step.Retry, err = parseRetry(v["retry"]); err != nil {
+				return nil, fmt.Errorf("plugins[%d] retry %v", i, err)
+			}
+			if step.Secrets, err = parseSecrets(v["secrets"]); err != nil {
+				return nil, fmt.Errorf("plugins[%d] secrets %v", i, err)
+			}
+			if step.FailOnFindings, err = parseSeverity(v["fail_on_findings"]); err != nil {
+				return nil, fmt.Errorf("plugins[%d] fail_on_findings %v", i, err)
+			}
+			if step.Caches, err = parseCaches(v["caches"]); err != nil {
+				return nil, fmt.Errorf("plugins[%d] caches %v", i, err)
+			}
+			if caps, ok := v["capabilities"].([]interface{}); ok {
+				for _, c := range caps {
+					if s, ok := c.(string); ok {
+						step.Capabilities = append(step.Capabilities, s)
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("plugins[%d] must be a name or an object", i)
+		}
+		if step.Name == "" {
+			return nil, fmt.Errorf("plugins[%d] has no name", i)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// parseSeverity reads a step's severity threshold. A missing value is "".
+func parseSeverity(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	s, _ := v.(string)
+	for _, severity := range Severities {
+		if s == severity {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("must be one of %s", strings.Join(Severities, ", "))
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return info.Mode()&0111 != 0
+}
@@ -0,0 +1,48 @@
+package plugin
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxOutputLine splits longer lines of WASM plugin output
+const maxOutputLine = 64 * 1024
+
+// lineWriter passes each complete line written to it to emit
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			if len(w.buf) >= maxOutputLine {
+				w.emit(string(w.buf[:maxOutputLine]))
+				w.buf = w.buf[maxOutputLine:]
+				continue
+			}
+			return len(p), nil
+		}
+		if line := string(bytes.TrimSuffix(w.buf[:i], []byte("\r"))); line != "" {
+			w.emit(line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush emits a trailing line that did not end with a newline
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
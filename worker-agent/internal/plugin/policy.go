@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Filesystem access levels of a sandbox policy
+const (
+	// FilesystemHost leaves the filesystem unrestricted
+	FilesystemHost = "host"
+	// FilesystemWorkspace makes the host read-only; the workspace and a private
+	// temp directory stay writable
+	FilesystemWorkspace = "workspace"
+	// FilesystemReadOnly is FilesystemWorkspace with a read-only workspace
+	FilesystemReadOnly = "readonly"
+)
+
+// Policy is the sandbox a plugin runs in
+type Policy struct {
+	Network    bool     `json:"network"`    // allow sockets other than Unix domain stream sockets, and connect(2)
+	Filesystem string   `json:"filesystem"` // host, workspace or readonly
+	Env        []string `json:"env"`        // variables passed through; "*" passes all
+	Secrets    bool     `json:"secrets"`    // pass build secrets to the plugin
+	Seccomp    bool     `json:"seccomp"`    // block ptrace, mount, bpf, module loading and similar syscalls
+}
+
+// Profiles are the built-in policies a policy file can start from
+var Profiles = map[string]Policy{
+	// unconfined runs plugins like any other process of the agent
+	"unconfined": {Network: true, Filesystem: FilesystemHost, Env: []string{"*"}, Secrets: true},
+	// standard keeps network access and secrets but confines writes to the workspace
+	"standard": {Network: true, Filesystem: FilesystemWorkspace, Secrets: true, Seccomp: true},
+	// restricted is for untrusted plugins: no network, no secrets, read-only workspace
+	"restricted": {Network: false, Filesystem: FilesystemReadOnly, Seccomp: true},
+}
+
+// alwaysPassedEnv are passed to every sandboxed plugin so tools can run
+var alwaysPassedEnv = []string{"PATH", "LANG", "LC_ALL", "TZ", "SSL_CERT_FILE", "SSL_CERT_DIR"}
+
+// Policies maps plugin names to sandbox policies
+type Policies struct {
+	Default Policy
+	Plugins map[string]Policy
+}
+
+// DefaultPolicies runs every plugin unconfined
+func DefaultPolicies() *Policies {
+	return &Policies{Default: Profiles["unconfined"], Plugins: map[string]Policy{}}
+}
+
+// policyFile is the JSON policy file format. An entry may name a built-in
+// profile to start from, then overrides any of its fields. Plugin entries
+// without a profile start from the default policy.
+//
+//	{
+//	  "default": {"profile": "standard"},
+//	  "plugins": {
+//	    "trivy-container-scan": {"profile": "restricted", "network": true},
+//	    "slack-notify": {"profile": "restricted", "network": true, "secrets": true}
+//	  }
+//	}
+type policyFile struct {
+	Default json.RawMessage            `json:"default"`
+	Plugins map[string]json.RawMessage `json:"plugins"`
+}
+
+// LoadPolicies reads a policy file. Plugins without an entry get the default,
+// which is unconfined unless the file says otherwise.
+func LoadPolicies(path string) (*Policies, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin policy file: %w", err)
+	}
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid plugin policy file: %w", err)
+	}
+
+	policies := DefaultPolicies()
+	if file.Default != nil {
+		if policies.Default, err = parsePolicy(file.Default, policies.Default); err != nil {
+			return nil, fmt.Errorf("default policy: %w", err)
+		}
+	}
+	for name, raw := range file.Plugins {
+		if policies.Plugins[name], err = parsePolicy(raw, policies.Default); err != nil {
+			return nil, fmt.Errorf("policy for %s: %w", name, err)
+		}
+	}
+	return policies, nil
+}
+
+// parsePolicy applies an entry's fields on top of its profile, or on top of
+// base if it names none
+func parsePolicy(raw json.RawMessage, base Policy) (Policy, error) {
+	var ref struct {
+		Profile string `json:"profile"`
+	}
+	if err := json.Unmarshal(raw, &ref); err != nil {
+		return Policy{}, err
+	}
+	policy := base
+	if ref.Profile != "" {
+		var ok bool
+		if policy, ok = Profiles[ref.Profile]; !ok {
+			return Policy{}, fmt.Errorf("unknown profile %q", ref.Profile)
+		}
+	}
+
+	policy.Env = append([]string(nil), policy.Env...)
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return Policy{}, err
+	}
+	switch policy.Filesystem {
+	case FilesystemHost, FilesystemWorkspace, FilesystemReadOnly:
+	default:
+		return Policy{}, fmt.Errorf("unknown filesystem access %q", policy.Filesystem)
+	}
+	return policy, nil
+}
+
+// For returns the policy of a plugin
+func (p *Policies) For(name string) Policy {
+	if policy, ok := p.Plugins[name]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Confined reports whether the policy restricts the plugin process, which
+// requires launching it through the sandbox helper
+func (p Policy) Confined() bool {
+	return !p.Network || p.Filesystem != FilesystemHost || p.Seccomp
+}
+
+// allowsEnv reports whether an environment variable may be passed to the plugin
+func (p Policy) allowsEnv(name string) bool {
+	for _, allowed := range p.Env {
+		if allowed == "*" || allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// processEnv builds the environment of the plugin process. Confined plugins
+// get a private HOME and TMPDIR, which is also where go-plugin creates its socket.
+func (p Policy) processEnv(tmpDir string) []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if p.allowsEnv(name) || containsName(alwaysPassedEnv, name) {
+			env = append(env, kv)
+		}
+	}
+	if p.Filesystem != FilesystemHost {
+		env = append(env, "HOME="+tmpDir, "TMPDIR="+tmpDir)
+	}
+	return env
+}
+
+// filterContext removes build environment variables and secrets the policy withholds
+func (p Policy) filterContext(execCtx *ExecutionContext) *ExecutionContext {
+	filtered := *execCtx
+	filtered.EnvVars = map[string]string{}
+	for k, v := range execCtx.EnvVars {
+		if p.allowsEnv(k) {
+			filtered.EnvVars[k] = v
+		}
+	}
+	if !p.Secrets {
+		filtered.Secrets = map[string]string{}
+		filtered.ResolvableSecrets = nil
+		filtered.SecretBroker = 0
+	}
+	return &filtered
+}
+
+// checkCapabilities rejects WASM capabilities the policy does not allow
+func (p Policy) checkCapabilities(caps []string) error {
+	for _, c := range caps {
+		denied := false
+		switch c {
+		case CapabilityWorkspace:
+			denied = p.Filesystem == FilesystemReadOnly
+		case CapabilitySecrets:
+			denied = !p.Secrets
+		}
+		if denied {
+			return fmt.Errorf("capability %q is denied by the plugin's sandbox policy", c)
+		}
+	}
+	return nil
+}
+
+func containsName(list []string, name string) bool {
+	for _, v := range list {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,390 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	goplugin "github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The definitions below must stay wire-compatible with plugin-sdk/pkg/sdk/serve.go.
+// They are duplicated because the agent is built without the SDK module.
+
+// Handshake must match sdk.Handshake
+var Handshake = goplugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "SOLVYD_PLUGIN",
+	MagicCookieValue: "8f6d2c1e-solvyd-plugin",
+}
+
+// pluginName is the name plugins are dispensed under
+const pluginName = "plugin"
+
+// serviceName is the gRPC service implemented by every plugin
+const serviceName = "solvyd.plugin.v1.PluginService"
+
+// Info describes a running plugin
+type Info struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+}
+
+// ExecutionContext is passed to a plugin's Execute call
+type ExecutionContext struct {
+	BuildID    string                 `json:"build_id"`
+	JobID      string                 `json:"job_id"`
+	WorkDir    string                 `json:"work_dir"`
+	EnvVars    map[string]string      `json:"env_vars"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Secrets    map[string]string      `json:"secrets"`
+
+	// Secrets the plugin can resolve through the agent's SecretService
+	ResolvableSecrets []string `json:"resolvable_secrets,omitempty"`
+	SecretBroker      uint32   `json:"secret_broker,omitempty"`
+}
+
+// Artifact is an artifact produced by a plugin
+type Artifact struct {
+	Name           string            `json:"name"`
+	Path           string            `json:"path"`
+	SizeBytes      int64             `json:"size_bytes"`
+	ChecksumSHA256 string            `json:"checksum_sha256"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+// Finding is a security issue reported by a scanner plugin
+type Finding struct {
+	Tool        string          `json:"tool,omitempty"`
+	RuleID      string          `json:"rule_id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description,omitempty"`
+	Severity    string          `json:"severity"`
+	Location    FindingLocation `json:"location"`
+	CWEs        []string        `json:"cwes,omitempty"`
+	CVEs        []string        `json:"cves,omitempty"`
+	HelpURL     string          `json:"help_url,omitempty"`
+	Fingerprint string          `json:"fingerprint,omitempty"`
+}
+
+// Severities of findings, from least to most severe
+var Severities = []string{"info", "low", "medium", "high", "critical"}
+
+// FindingLocation is where a finding was found
+type FindingLocation struct {
+	Path      string `json:"path,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	URI       string `json:"uri,omitempty"`
+	Package   string `json:"package,omitempty"`
+	Version   string `json:"version,omitempty"`
+}
+
+// TestCase is the outcome of one test reported by a test plugin
+type TestCase struct {
+	Suite      string `json:"suite,omitempty"`
+	ClassName  string `json:"class_name,omitempty"`
+	Name       string `json:"name"`
+	Status     string `json:"status"` // passed, failed, error or skipped
+	Message    string `json:"message,omitempty"`
+	Details    string `json:"details,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Coverage is the code coverage reported by a coverage plugin
+type Coverage struct {
+	Format          string `json:"format,omitempty"`
+	LinesCovered    int    `json:"lines_covered"`
+	LinesTotal      int    `json:"lines_total"`
+	BranchesCovered int    `json:"branches_covered"`
+	BranchesTotal   int    `json:"branches_total"`
+}
+
+// Annotation is a problem a plugin reported at a line of a file
+type Annotation struct {
+	Path      string `json:"path,omitempty"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+	Severity  string `json:"severity"` // error, warning or notice
+	Title     string `json:"title,omitempty"`
+	Message   string `json:"message"`
+}
+
+// CommitInfo is the commit an SCM plugin checked out
+type CommitInfo struct {
+	SHA          string   `json:"sha"`
+	Message      string   `json:"message,omitempty"`
+	Author       string   `json:"author,omitempty"`
+	Email        string   `json:"email,omitempty"`
+	Timestamp    string   `json:"timestamp,omitempty"`
+	ChangedFiles []string `json:"changed_files"`
+}
+
+// LogEntry is a message the plugin logged during Execute, or a line of its
+// console output if Level is LevelOutput
+type LogEntry struct {
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// LevelOutput marks log entries holding a line the plugin wrote to its output
+const LevelOutput = "output"
+
+// LogSink receives a plugin's log entries as they are produced
+type LogSink func(entry LogEntry)
+
+// streamMessage is one message of the ExecuteStream RPC
+type streamMessage struct {
+	Log    *LogEntry `json:"log,omitempty"`
+	Result *Result   `json:"result,omitempty"`
+}
+
+var executeStreamDesc = grpc.StreamDesc{StreamName: "ExecuteStream", ServerStreams: true}
+
+// Result is the outcome of a plugin's Execute call
+type Result struct {
+	Success      bool                   `json:"success"`
+	ExitCode     int                    `json:"exit_code"`
+	ErrorMessage string                 `json:"error_message"`
+	Output       string                 `json:"output"`
+	Artifacts    []Artifact             `json:"artifacts"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	Findings     []Finding              `json:"findings,omitempty"`
+	TestCases    []TestCase             `json:"test_cases,omitempty"`
+	Coverage     *Coverage              `json:"coverage,omitempty"`
+	Commit       *CommitInfo            `json:"commit,omitempty"`
+	Annotations  []Annotation           `json:"annotations,omitempty"`
+	Error        string                 `json:"error,omitempty"`    // error returned by Execute
+	Logs         []LogEntry             `json:"logs"`               // empty when streamed
+	Attempts     []Attempt              `json:"attempts,omitempty"` // recorded by Manager.Run
+}
+
+type capabilitiesResponse struct {
+	Capabilities []string `json:"capabilities"`
+}
+
+type errorResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// grpcPlugin is the go-plugin client side of the plugin protocol
+type grpcPlugin struct {
+	goplugin.NetRPCUnsupportedPlugin
+}
+
+func (p *grpcPlugin) GRPCServer(*goplugin.GRPCBroker, *grpc.Server) error {
+	return fmt.Errorf("the worker agent only implements the client side")
+}
+
+func (p *grpcPlugin) GRPCClient(_ context.Context, broker *goplugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return &Client{conn: conn, broker: broker}, nil
+}
+
+// Client calls a running plugin process
+type Client struct {
+	conn   *grpc.ClientConn
+	broker *goplugin.GRPCBroker // serves the SecretService to the plugin
+}
+
+// Info returns the plugin's name, version and type
+func (c *Client) Info(ctx context.Context) (*Info, error) {
+	info := &Info{}
+	if err := c.call(ctx, "Info", struct{}{}, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Capabilities returns what the plugin needs from the agent. Plugins built
+// before capability negotiation report none.
+func (c *Client) Capabilities(ctx context.Context) ([]string, error) {
+	var resp capabilitiesResponse
+	if err := c.call(ctx, "Capabilities", struct{}{}, &resp); err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return resp.Capabilities, nil
+}
+
+// Initialize configures the plugin
+func (c *Client) Initialize(ctx context.Context, config map[string]interface{}) error {
+	var resp errorResponse
+	if err := c.call(ctx, "Initialize", map[string]interface{}{"config": config}, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
+}
+
+// Health checks that the initialized plugin can run. Plugins built before
+// health checks are assumed healthy.
+func (c *Client) Health(ctx context.Context) error {
+	var resp errorResponse
+	if err := c.call(ctx, "Health", struct{}{}, &resp); err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return nil
+		}
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
+}
+
+// Execute runs the plugin, passing its log entries to sink as they are
+// produced. Plugins built before streaming run through the unary Execute
+// method and their logs are passed to sink when it returns.
+func (c *Client) Execute(ctx context.Context, execCtx *ExecutionContext, sink LogSink) (*Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	in, err := toStruct(execCtx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.conn.NewStream(ctx, &executeStreamDesc, "/"+serviceName+"/ExecuteStream")
+	if err == nil {
+		err = stream.SendMsg(in)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+
+	received := false
+	for err == nil {
+		out := new(structpb.Struct)
+		if err = stream.RecvMsg(out); err != nil {
+			break
+		}
+		received = true
+
+		var msg streamMessage
+		if err := fromStruct(out, &msg); err != nil {
+			return nil, err
+		}
+		if msg.Log != nil {
+			sink(*msg.Log)
+		}
+		if msg.Result != nil {
+			return msg.Result, nil
+		}
+	}
+
+	if !received && status.Code(err) == codes.Unimplemented {
+		return c.executeUnary(ctx, execCtx, sink)
+	}
+	if err == io.EOF {
+		err = fmt.Errorf("stream ended without a result")
+	}
+	return nil, fmt.Errorf("plugin Execute call failed: %w", err)
+}
+
+func (c *Client) executeUnary(ctx context.Context, execCtx *ExecutionContext, sink LogSink) (*Result, error) {
+	result := &Result{}
+	if err := c.call(ctx, "Execute", execCtx, result); err != nil {
+		return nil, err
+	}
+	for _, entry := range result.Logs {
+		sink(entry)
+	}
+	result.Logs = nil
+	return result, nil
+}
+
+// ResolveSecret asks a secret provider plugin for the value of a reference
+func (c *Client) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	var resp struct {
+		Value string `json:"value"`
+		Error string `json:"error,omitempty"`
+	}
+	if err := c.call(ctx, "ResolveSecret", map[string]string{"ref": ref}, &resp); err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return "", fmt.Errorf("plugin is not a secret provider")
+		}
+		return "", err
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Value, nil
+}
+
+// cacheResponse is the response of the cache plugin RPCs
+type cacheResponse struct {
+	Found bool   `json:"found,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// cacheCall invokes one of the cache plugin RPCs
+func (c *Client) cacheCall(ctx context.Context, method, key, path string) (bool, error) {
+	var resp cacheResponse
+	if err := c.call(ctx, method, map[string]string{"key": key, "path": path}, &resp); err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return false, fmt.Errorf("plugin is not a cache plugin")
+		}
+		return false, err
+	}
+	if resp.Error != "" {
+		return false, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Found, nil
+}
+
+// Cleanup releases resources held by the plugin
+func (c *Client) Cleanup(ctx context.Context) error {
+	var resp errorResponse
+	if err := c.call(ctx, "Cleanup", struct{}{}, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
+}
+
+// call invokes a unary method, converting request and response through protobuf Structs
+func (c *Client) call(ctx context.Context, method string, req, resp interface{}) error {
+	in, err := toStruct(req)
+	if err != nil {
+		return err
+	}
+	out := new(structpb.Struct)
+	if err := c.conn.Invoke(ctx, "/"+serviceName+"/"+method, in, out); err != nil {
+		return fmt.Errorf("plugin %s call failed: %w", method, err)
+	}
+	return fromStruct(out, resp)
+}
+
+// toStruct converts a JSON-serializable value to a protobuf Struct
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+// fromStruct decodes a protobuf Struct into a JSON-tagged value
+func fromStruct(s *structpb.Struct, v interface{}) error {
+	data, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
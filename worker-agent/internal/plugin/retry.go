@@ -0,0 +1,208 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Conditions a step can be retried on, which are also the outcomes of attempts
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure" // the plugin ran and reported failure
+	OutcomeError   = "error"   // the plugin could not be started or crashed
+	OutcomeTimeout = "timeout" // the attempt exceeded the step's timeout
+)
+
+const (
+	defaultRetryBackoff    = 10 * time.Second
+	defaultRetryMaxBackoff = 5 * time.Minute
+)
+
+// RetryPolicy controls how a failed step is retried. The delay before a retry
+// starts at Backoff and doubles after each attempt, up to MaxBackoff.
+type RetryPolicy struct {
+	Count      int           // retries after the first attempt
+	Backoff    time.Duration // default 10s
+	MaxBackoff time.Duration // default 5m
+	On         []string      // outcomes to retry on, default error and timeout
+}
+
+// Attempt records one execution of a step
+type Attempt struct {
+	Number     int       `json:"number"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (r RetryPolicy) retries(outcome string) bool {
+	on := r.On
+	if len(on) == 0 {
+		on = []string{OutcomeError, OutcomeTimeout}
+	}
+	for _, o := range on {
+		if o == outcome {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait after the given attempt before the next one
+func (r RetryPolicy) delay(attempt int) time.Duration {
+	d, max := r.Backoff, r.MaxBackoff
+	if d <= 0 {
+		d = defaultRetryBackoff
+	}
+	if max <= 0 {
+		max = defaultRetryMaxBackoff
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	return min(d, max)
+}
+
+// Run runs a step, limiting each attempt to the step's timeout and retrying
+// it according to the step's retry policy. The returned result, which is
+// non-nil even if every attempt failed to run, holds the history of attempts.
+// The plugin's log entries and output lines from every attempt, and a notice
+// before each retry, are passed to sink as they are produced. The step's
+// caches are restored before the first attempt and saved if one succeeds.
+func (m *Manager) Run(ctx context.Context, step Step, execCtx *ExecutionContext, sink LogSink) (*Result, error) {
+	if sink == nil {
+		sink = func(LogEntry) {}
+	}
+	cache := m.restoreCaches(ctx, step, execCtx.WorkDir, sink)
+	result, err := m.runAttempts(ctx, step, execCtx, sink)
+	if cache != nil {
+		if err == nil && result.Success {
+			m.saveCaches(ctx, cache, step, execCtx.WorkDir, sink)
+		}
+		cache.Close()
+	}
+	return result, err
+}
+
+// runAttempts runs a step's attempts
+func (m *Manager) runAttempts(ctx context.Context, step Step, execCtx *ExecutionContext, sink LogSink) (*Result, error) {
+	var attempts []Attempt
+	for n := 1; ; n++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if step.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, step.Timeout)
+		}
+		started := time.Now()
+		result, err := m.runAttempt(attemptCtx, step, execCtx, sink)
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+
+		attempt := Attempt{Number: n, StartedAt: started.UTC(), DurationMs: time.Since(started).Milliseconds()}
+		switch {
+		case timedOut:
+			attempt.Outcome = OutcomeTimeout
+			err = fmt.Errorf("plugin %s timed out after %s", step.Name, step.Timeout)
+		case err == nil && result.Success:
+			attempt.Outcome = OutcomeSuccess
+		case result != nil:
+			attempt.Outcome = OutcomeFailure
+		default:
+			attempt.Outcome = OutcomeError
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		} else if !result.Success {
+			attempt.Error = result.ErrorMessage
+		}
+		attempts = append(attempts, attempt)
+
+		if attempt.Outcome == OutcomeSuccess || n > step.Retry.Count || !step.Retry.retries(attempt.Outcome) || ctx.Err() != nil {
+			if result == nil {
+				result = &Result{ExitCode: 1, ErrorMessage: attempt.Error}
+			}
+			result.Attempts = attempts
+			return result, err
+		}
+
+		delay := step.Retry.delay(n)
+		sink(LogEntry{Level: "warn", Message: fmt.Sprintf("Attempt %d of %d ended with %s: %s; retrying in %s",
+			n, step.Retry.Count+1, attempt.Outcome, attempt.Error, delay)})
+		select {
+		case <-ctx.Done():
+			result = &Result{ExitCode: 1, ErrorMessage: attempt.Error, Attempts: attempts}
+			return result, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// parseDuration reads a step duration given as a string such as "90s" or as
+// a number of seconds. A missing value is zero.
+func parseDuration(v interface{}) (time.Duration, error) {
+	var d time.Duration
+	switch val := v.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		parsed, err := time.ParseDuration(val)
+		if err != nil {
+			return 0, fmt.Errorf("must be a duration such as \"5m\" or a number of seconds, got %q", val)
+		}
+		d = parsed
+	case float64:
+		d = time.Duration(val * float64(time.Second))
+	default:
+		return 0, fmt.Errorf("must be a duration such as \"5m\" or a number of seconds")
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return d, nil
+}
+
+// parseRetry reads a step's retry object
+func parseRetry(v interface{}) (RetryPolicy, error) {
+	var policy RetryPolicy
+	if v == nil {
+		return policy, nil
+	}
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return policy, fmt.Errorf("must be an object")
+	}
+
+	if count, ok := raw["count"]; ok {
+		n, isNumber := count.(float64)
+		if !isNumber || n < 0 || n != float64(int(n)) {
+			return policy, fmt.Errorf("count must be a non-negative integer")
+		}
+		policy.Count = int(n)
+	}
+
+	var err error
+	if policy.Backoff, err = parseDuration(raw["backoff"]); err != nil {
+		return policy, fmt.Errorf("backoff %v", err)
+	}
+	if policy.MaxBackoff, err = parseDuration(raw["max_backoff"]); err != nil {
+		return policy, fmt.Errorf("max_backoff %v", err)
+	}
+
+	if on, ok := raw["retry_on"]; ok {
+		list, ok := on.([]interface{})
+		if !ok {
+			return policy, fmt.Errorf("retry_on must be a list")
+		}
+		for _, item := range list {
+			switch item {
+			case OutcomeFailure, OutcomeError, OutcomeTimeout:
+				policy.On = append(policy.On, item.(string))
+			default:
+				return policy, fmt.Errorf("retry_on values must be failure, error or timeout, got %v", item)
+			}
+		}
+	}
+	return policy, nil
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// SandboxCommand is the hidden agent subcommand that confines itself according
+// to a policy and then executes the plugin. Restrictions applied before exec
+// (Landlock, seccomp, no_new_privs) are inherited by the plugin and cannot be lifted.
+const SandboxCommand = "__plugin-sandbox"
+
+// sandboxSpec is passed to the sandbox helper on its command line
+type sandboxSpec struct {
+	Policy  Policy `json:"policy"`
+	Plugin  string `json:"plugin"`
+	WorkDir string `json:"work_dir"`
+	TmpDir  string `json:"tmp_dir"`
+}
+
+// pluginCommand returns the command that starts a plugin under its policy
+func pluginCommand(path string, policy Policy, workDir, tmpDir string) (*exec.Cmd, error) {
+	if !policy.Confined() {
+		return exec.Command(path), nil
+	}
+	if err := sandboxSupported(); err != nil {
+		return nil, err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the agent executable: %w", err)
+	}
+	spec, err := json.Marshal(sandboxSpec{Policy: policy, Plugin: path, WorkDir: workDir, TmpDir: tmpDir})
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(self, SandboxCommand, string(spec)), nil
+}
+
+// RunSandboxHelper implements SandboxCommand. It never returns: it either
+// replaces the process with the plugin or exits with an error.
+func RunSandboxHelper(args []string) {
+	if err := runSandboxHelper(args); err != nil {
+		fmt.Fprintf(os.Stderr, "plugin sandbox: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runSandboxHelper(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a sandbox spec")
+	}
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		return fmt.Errorf("invalid sandbox spec: %w", err)
+	}
+
+	if err := confine(spec); err != nil {
+		return err
+	}
+	return syscall.Exec(spec.Plugin, []string{spec.Plugin}, os.Environ())
+}
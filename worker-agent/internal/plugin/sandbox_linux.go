@@ -0,0 +1,235 @@
+//go:build linux
+
+package plugin
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Landlock filesystem rights by ABI version
+const (
+	landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR
+	landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE
+	landlockAccessV1 = landlockReadAccess | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+)
+
+// deniedSyscalls are blocked by the seccomp filter: debugging other processes,
+// changing mounts and namespaces and loading kernel code
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_UNSHARE, unix.SYS_SETNS, unix.SYS_OPEN_TREE, unix.SYS_MOVE_MOUNT,
+	unix.SYS_FSOPEN, unix.SYS_FSMOUNT, unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD, unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE, unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN, unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_USERFAULTFD, unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_ACCT,
+}
+
+// ioUringSyscalls are blocked with seccomp and whenever network access is, as
+// io_uring opens sockets without socket(2), bypassing the socket filter
+var ioUringSyscalls = []uint32{
+	unix.SYS_IO_URING_SETUP, unix.SYS_IO_URING_ENTER, unix.SYS_IO_URING_REGISTER,
+}
+
+// sandboxSupported reports whether this kernel and architecture can confine plugins
+func sandboxSupported() error {
+	if auditArch == 0 {
+		return fmt.Errorf("plugin sandboxing is not supported on %s", runtime.GOARCH)
+	}
+	if _, err := landlockABI(); err != nil {
+		return fmt.Errorf("plugin sandboxing requires Landlock (Linux 5.13+): %w", err)
+	}
+	return nil
+}
+
+// confine restricts the current process according to spec.Policy. The thread
+// stays locked so the restrictions apply to the thread that calls exec.
+func confine(spec sandboxSpec) error {
+	runtime.LockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if spec.Policy.Filesystem != FilesystemHost {
+		if err := restrictFilesystem(spec); err != nil {
+			return err
+		}
+	}
+	if spec.Policy.Seccomp || !spec.Policy.Network {
+		if err := installSeccompFilter(spec.Policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func landlockABI() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(abi), nil
+}
+
+// restrictFilesystem makes the host read-only with Landlock, leaving the temp
+// directory (and the workspace, unless read-only) writable
+func restrictFilesystem(spec sandboxSpec) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return fmt.Errorf("landlock is unavailable: %w", err)
+	}
+	handled := uint64(landlockAccessV1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create landlock ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer unix.Close(ruleset)
+
+	rules := []struct {
+		path   string
+		access uint64
+	}{
+		{"/", landlockReadAccess},
+		{"/dev/null", landlockFileAccess & handled},
+		{spec.TmpDir, handled},
+	}
+	if spec.WorkDir != "" && spec.Policy.Filesystem == FilesystemWorkspace {
+		rules = append(rules, struct {
+			path   string
+			access uint64
+		}{spec.WorkDir, handled})
+	}
+
+	for _, rule := range rules {
+		if rule.path == "" {
+			continue
+		}
+		if err := addLandlockRule(ruleset, rule.path, rule.access); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("failed to enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+func addLandlockRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return err
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset),
+		unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to allow %s: %w", path, errno)
+	}
+	return nil
+}
+
+// Offsets into struct seccomp_data
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16 // low 32 bits on little-endian architectures
+	seccompDataArg1 = 24
+)
+
+// sockTypeMask masks the SOCK_NONBLOCK and SOCK_CLOEXEC flags out of the type
+// argument of socket(2)
+const sockTypeMask = 0xf
+
+// installSeccompFilter denies dangerous syscalls and, without network access,
+// io_uring, connect(2) and sockets other than Unix domain stream sockets.
+// go-plugin only listens on one and accepts the agent's connection; Landlock
+// does not govern connecting to existing sockets, such as the Docker socket,
+// and datagram sockets can send to them without connecting.
+func installSeccompFilter(policy Policy) error {
+	const (
+		ld  = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		and = unix.BPF_ALU | unix.BPF_AND | unix.BPF_K
+		jeq = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		ret = unix.BPF_RET | unix.BPF_K
+	)
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	noNetwork := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EACCES))
+
+	prog := []unix.SockFilter{
+		{Code: ld, K: seccompDataArch},
+		{Code: jeq, Jt: 1, K: auditArch},
+		{Code: ret, K: unix.SECCOMP_RET_KILL_PROCESS},
+		{Code: ld, K: seccompDataNr},
+	}
+	if x32SyscallBit != 0 {
+		prog = append(prog,
+			unix.SockFilter{Code: jge, Jf: 1, K: x32SyscallBit},
+			unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_KILL_PROCESS})
+	}
+	denied := []uint32{}
+	if policy.Seccomp {
+		denied = append(denied, deniedSyscalls...)
+	}
+	if policy.Seccomp || !policy.Network {
+		denied = append(denied, ioUringSyscalls...)
+	}
+	for _, nr := range denied {
+		prog = append(prog,
+			unix.SockFilter{Code: jeq, Jf: 1, K: nr},
+			unix.SockFilter{Code: ret, K: deny})
+	}
+	if !policy.Network {
+		prog = append(prog,
+			unix.SockFilter{Code: jeq, Jf: 1, K: unix.SYS_CONNECT},
+			unix.SockFilter{Code: ret, K: noNetwork},
+			// Other syscalls skip to the allow below; socket(2) must ask for AF_UNIX, SOCK_STREAM
+			unix.SockFilter{Code: jeq, Jf: 6, K: unix.SYS_SOCKET},
+			unix.SockFilter{Code: ld, K: seccompDataArg0},
+			unix.SockFilter{Code: jeq, Jf: 3, K: unix.AF_UNIX},
+			unix.SockFilter{Code: ld, K: seccompDataArg1},
+			unix.SockFilter{Code: and, K: sockTypeMask},
+			unix.SockFilter{Code: jeq, Jt: 1, K: unix.SOCK_STREAM},
+			unix.SockFilter{Code: ret, K: noNetwork})
+	}
+	prog = append(prog, unix.SockFilter{Code: ret, K: unix.SECCOMP_RET_ALLOW})
+
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, 0,
+		uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	return nil
+}
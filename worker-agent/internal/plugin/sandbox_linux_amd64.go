@@ -0,0 +1,9 @@
+package plugin
+
+import "golang.org/x/sys/unix"
+
+const (
+	auditArch = unix.AUDIT_ARCH_X86_64
+	// x32SyscallBit marks x32 ABI syscalls, which the filter rejects
+	x32SyscallBit = 0x40000000
+)
@@ -0,0 +1,8 @@
+package plugin
+
+import "golang.org/x/sys/unix"
+
+const (
+	auditArch     = unix.AUDIT_ARCH_AARCH64
+	x32SyscallBit = 0
+)
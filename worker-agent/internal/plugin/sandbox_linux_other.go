@@ -0,0 +1,9 @@
+//go:build linux && !amd64 && !arm64
+
+package plugin
+
+// The seccomp filter is only built for amd64 and arm64
+const (
+	auditArch     = 0
+	x32SyscallBit = 0
+)
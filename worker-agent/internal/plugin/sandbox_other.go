@@ -0,0 +1,16 @@
+//go:build !linux
+
+package plugin
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func sandboxSupported() error {
+	return fmt.Errorf("plugin sandboxing is not supported on %s", runtime.GOOS)
+}
+
+func confine(sandboxSpec) error {
+	return sandboxSupported()
+}
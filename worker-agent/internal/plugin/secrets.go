@@ -0,0 +1,235 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// secretServiceName is served by the agent over the go-plugin broker while a
+// step runs, so plugins can resolve secrets from a secret provider on first use
+const secretServiceName = "solvyd.plugin.v1.SecretService"
+
+// SecretProvider is a secret provider plugin registered under a name that
+// steps use in secret references ("<provider>:<reference>")
+type SecretProvider struct {
+	Plugin string                 `json:"plugin"`
+	Config map[string]interface{} `json:"config"`
+}
+
+// secretProvidersFile is the JSON secret provider file format:
+//
+//	{
+//	  "providers": {
+//	    "vault": {"plugin": "vault-secrets", "config": {"address": "https://vault.internal:8200"}}
+//	  }
+//	}
+type secretProvidersFile struct {
+	Providers map[string]SecretProvider `json:"providers"`
+}
+
+// LoadSecretProviders reads a secret provider file
+func LoadSecretProviders(path string) (map[string]SecretProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret provider file: %w", err)
+	}
+	var file secretProvidersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid secret provider file: %w", err)
+	}
+	for name, provider := range file.Providers {
+		if provider.Plugin == "" {
+			return nil, fmt.Errorf("secret provider %s has no plugin", name)
+		}
+	}
+	return file.Providers, nil
+}
+
+// SetSecretProviders sets the secret providers step secrets are resolved through
+func (m *Manager) SetSecretProviders(providers map[string]SecretProvider) {
+	m.secretProviders = providers
+}
+
+// checkSecrets fails if a step references a secret provider that is not registered
+func (m *Manager) checkSecrets(step Step) error {
+	for _, name := range secretNames(step) {
+		provider, _, _ := strings.Cut(step.Secrets[name], ":")
+		if _, ok := m.secretProviders[provider]; !ok {
+			return fmt.Errorf("plugin %s secret %s references unknown secret provider %q", step.Name, name, provider)
+		}
+	}
+	return nil
+}
+
+// parseSecrets reads a step's secrets object, which maps the names plugins
+// ask for to "<provider>:<reference>"
+func parseSecrets(v interface{}) (map[string]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be an object")
+	}
+	secrets := make(map[string]string, len(raw))
+	for name, value := range raw {
+		ref, _ := value.(string)
+		if provider, rest, ok := strings.Cut(ref, ":"); !ok || provider == "" || rest == "" {
+			return nil, fmt.Errorf("%s must be a reference such as \"vault:secret/app#token\"", name)
+		}
+		secrets[name] = ref
+	}
+	return secrets, nil
+}
+
+// secretResolver resolves the secrets a step references through secret
+// providers. Each secret is resolved the first time a plugin asks for it and
+// cached; provider plugins are started on demand and kept running until the
+// step ends.
+type secretResolver struct {
+	m    *Manager
+	step Step
+
+	mu        sync.Mutex
+	values    map[string]string
+	providers map[string]*pluginProcess
+}
+
+func (m *Manager) newSecretResolver(step Step) *secretResolver {
+	return &secretResolver{
+		m:         m,
+		step:      step,
+		values:    make(map[string]string),
+		providers: make(map[string]*pluginProcess),
+	}
+}
+
+// secretNames returns the sorted names of a step's secrets
+func secretNames(step Step) []string {
+	names := make([]string, 0, len(step.Secrets))
+	for name := range step.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve returns a secret's value. Values are never logged.
+func (r *secretResolver) resolve(ctx context.Context, name string) (string, error) {
+	ref, ok := r.step.Secrets[name]
+	if !ok {
+		return "", fmt.Errorf("step %s has no secret %s", r.step.Name, name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if value, ok := r.values[name]; ok {
+		return value, nil
+	}
+
+	providerName, secretRef, _ := strings.Cut(ref, ":")
+	p, err := r.provider(ctx, providerName)
+	if err != nil {
+		return "", err
+	}
+	value, err := p.ResolveSecret(ctx, secretRef)
+	if err != nil {
+		return "", fmt.Errorf("secret provider %s: %w", providerName, err)
+	}
+	log.Info().Str("plugin", r.step.Name).Str("secret", name).Str("provider", providerName).Msg("Resolved secret")
+	r.values[name] = value
+	return value, nil
+}
+
+// provider returns a running, initialized secret provider plugin
+func (r *secretResolver) provider(ctx context.Context, name string) (*Client, error) {
+	if proc, ok := r.providers[name]; ok {
+		return proc.client, nil
+	}
+	provider, ok := r.m.secretProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret provider %q", name)
+	}
+	proc, err := r.m.open(ctx, provider.Plugin, provider.Config, "")
+	if err != nil {
+		return nil, fmt.Errorf("secret provider %s: %w", name, err)
+	}
+	r.providers[name] = proc
+	return proc.client, nil
+}
+
+// close cleans up and stops the provider plugins started for the step
+func (r *secretResolver) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, proc := range r.providers {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		if err := proc.client.Cleanup(ctx); err != nil {
+			log.Warn().Err(err).Str("provider", name).Msg("Secret provider cleanup failed")
+		}
+		cancel()
+		proc.close()
+	}
+	r.providers = nil
+}
+
+// getSecret implements the SecretService's GetSecret method
+func (r *secretResolver) getSecret(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := fromStruct(in, &req); err != nil {
+		return nil, err
+	}
+	value, err := r.resolve(ctx, req.Name)
+	resp := map[string]interface{}{"value": value}
+	if err != nil {
+		resp = map[string]interface{}{"error": err.Error()}
+	}
+	return structpb.NewStruct(resp)
+}
+
+var secretServiceDesc = grpc.ServiceDesc{
+	ServiceName: secretServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "GetSecret",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(structpb.Struct)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			r := srv.(*secretResolver)
+			if interceptor == nil {
+				return r.getSecret(ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + secretServiceName + "/GetSecret"}
+			return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return r.getSecret(ctx, req.(*structpb.Struct))
+			})
+		},
+	}},
+	Metadata: "solvyd/plugin/v1",
+}
+
+// serveSecrets serves the resolver's SecretService to the plugin over the
+// go-plugin broker and returns the broker ID the plugin dials. The server
+// stops when the plugin process exits.
+func (c *Client) serveSecrets(r *secretResolver) uint32 {
+	id := c.broker.NextId()
+	go c.broker.AcceptAndServe(id, func(opts []grpc.ServerOption) *grpc.Server {
+		s := grpc.NewServer(opts...)
+		s.RegisterService(&secretServiceDesc, r)
+		return s
+	})
+	return id
+}
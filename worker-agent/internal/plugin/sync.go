@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// installedPlugin is a plugin installed on the API server
+type installedPlugin struct {
+	Name           string `json:"name"`
+	Version        string `json:"version"`
+	BinaryPath     string `json:"binary_path"`
+	BinaryChecksum string `json:"binary_checksum"`
+	Enabled        bool   `json:"enabled"`
+}
+
+// localChecksum caches the checksum of a plugin file until it changes
+type localChecksum struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+// SetRemote makes the manager download plugins installed on the API server
+// into the plugin directory, over transport
+func (m *Manager) SetRemote(apiURL string, transport http.RoundTripper) {
+	m.apiURL = apiURL
+	m.httpClient = &http.Client{Timeout: 10 * time.Minute, Transport: transport}
+}
+
+// Sync downloads installed plugins that are missing locally or whose checksum
+// differs, verifying each download against the checksum recorded at install
+// time. Plugins installed without a checksum are left to the operator.
+func (m *Manager) Sync(ctx context.Context) error {
+	if m.apiURL == "" {
+		return nil
+	}
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+
+	installed, err := m.listInstalled(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, p := range installed {
+		if !p.Enabled || p.BinaryPath == "" || p.BinaryChecksum == "" {
+			continue
+		}
+
+		target, ok := m.syncTarget(p)
+		if !ok {
+			continue
+		}
+		if sum, err := m.fileChecksum(target); err == nil && sum == p.BinaryChecksum {
+			continue
+		}
+
+		if err := m.download(ctx, p, target); err != nil {
+			log.Error().Err(err).Str("plugin", p.Name).Msg("Failed to download plugin")
+			continue
+		}
+		log.Info().Str("plugin", p.Name).Str("version", p.Version).Msg("Downloaded plugin")
+		changed = true
+	}
+
+	if changed {
+		return m.Discover()
+	}
+	return nil
+}
+
+// syncTarget returns where an installed plugin is stored, or false if it was
+// installed for another platform
+func (m *Manager) syncTarget(p installedPlugin) (string, bool) {
+	if p.Name != filepath.Base(p.Name) || strings.HasPrefix(p.Name, ".") {
+		return "", false
+	}
+	u, err := url.Parse(p.BinaryPath)
+	if err != nil {
+		return "", false
+	}
+	q := u.Query()
+	if q.Get("os") == "wasip1" {
+		return filepath.Join(m.dir, p.Name+".wasm"), true
+	}
+	if (q.Get("os") != "" && q.Get("os") != runtime.GOOS) || (q.Get("arch") != "" && q.Get("arch") != runtime.GOARCH) {
+		log.Debug().Str("plugin", p.Name).Str("platform", q.Get("os")+"/"+q.Get("arch")).Msg("Skipping plugin built for another platform")
+		return "", false
+	}
+	return filepath.Join(m.dir, p.Name), true
+}
+
+func (m *Manager) listInstalled(ctx context.Context) ([]installedPlugin, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.apiURL+"/api/v1/plugins", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list installed plugins: %s", resp.Status)
+	}
+	var installed []installedPlugin
+	if err := json.NewDecoder(resp.Body).Decode(&installed); err != nil {
+		return nil, err
+	}
+	return installed, nil
+}
+
+// download fetches a plugin binary and moves it into place once its checksum matches
+func (m *Manager) download(ctx context.Context, p installedPlugin, target string) error {
+	if !strings.HasPrefix(p.BinaryPath, "/") {
+		return fmt.Errorf("unexpected binary path %q", p.BinaryPath)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.apiURL+p.BinaryPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(m.dir, "."+p.Name+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != p.BinaryChecksum {
+		return fmt.Errorf("checksum mismatch: got %s, expected %s", sum, p.BinaryChecksum)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// fileChecksum returns the SHA-256 of a file, hashing it again only when it changed
+func (m *Manager) fileChecksum(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.RLock()
+	cached, ok := m.checksums[path]
+	m.mu.RUnlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	m.mu.Lock()
+	m.checksums[path] = localChecksum{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	m.mu.Unlock()
+	return sum, nil
+}
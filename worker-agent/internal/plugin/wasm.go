@@ -0,0 +1,279 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Capabilities a step can grant to a WASM plugin. Without any, the plugin can
+// only compute, log and return a result: it has no filesystem, environment,
+// network or secrets.
+const (
+	// CapabilityWorkspace mounts the build workspace read-write at /workspace
+	CapabilityWorkspace = "workspace"
+	// CapabilityWorkspaceReadOnly mounts the build workspace read-only at /workspace
+	CapabilityWorkspaceReadOnly = "workspace:ro"
+	// CapabilityEnv exposes the build environment variables to the plugin
+	CapabilityEnv = "env"
+	// CapabilitySecrets allows the plugin to read build secrets through get_secret
+	CapabilitySecrets = "secrets"
+)
+
+var knownCapabilities = map[string]bool{
+	CapabilityWorkspace:         true,
+	CapabilityWorkspaceReadOnly: true,
+	CapabilityEnv:               true,
+	CapabilitySecrets:           true,
+}
+
+// wasmWorkspace is where the build workspace appears inside the sandbox
+const wasmWorkspace = "/workspace"
+
+// wasmMemoryLimitPages caps plugin memory at 256 MiB (64 KiB pages)
+const wasmMemoryLimitPages = 4096
+
+// wasmRequest must match the SDK's wasmRequest
+type wasmRequest struct {
+	Config       map[string]interface{} `json:"config"`
+	Context      ExecutionContext       `json:"context"`
+	SecretNames  []string               `json:"secret_names"`
+	Capabilities []string               `json:"capabilities"` // granted by the step
+}
+
+// wasmResponse must match the SDK's wasmResponse
+type wasmResponse struct {
+	Info                Info     `json:"info"`
+	Capabilities        []string `json:"capabilities,omitempty"`
+	MissingCapabilities []string `json:"missing_capabilities,omitempty"`
+	InitializeError     string   `json:"initialize_error,omitempty"`
+	HealthError         string   `json:"health_error,omitempty"`
+	Result              *Result  `json:"result,omitempty"`
+	CleanupError        string   `json:"cleanup_error,omitempty"`
+}
+
+// runWASM runs a WebAssembly plugin in an embedded wazero runtime. The module is
+// a WASI command built with the SDK for GOOS=wasip1; it reads the request from
+// stdin and reports back through the "solvyd" host module. The sandbox policy
+// limits which capabilities a step may grant and which variables "env" exposes.
+// Logs and stdout are passed to sink as the module produces them.
+func (m *Manager) runWASM(ctx context.Context, path string, step Step, policy Policy, execCtx *ExecutionContext, sink LogSink) (*Result, error) {
+	caps, err := wasmGrants(step, policy)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", step.Name, err)
+	}
+	execCtx = policy.filterContext(execCtx)
+	var secrets *secretResolver
+	if caps[CapabilitySecrets] && len(step.Secrets) > 0 {
+		secrets = m.newSecretResolver(step)
+		defer secrets.close()
+	}
+
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin %s: %w", step.Name, err)
+	}
+
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(m.wasmCache).
+		WithMemoryLimitPages(wasmMemoryLimitPages).
+		WithCloseOnContextDone(true))
+	defer rt.Close(ctx)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		return nil, err
+	}
+
+	var response *wasmResponse
+	_, err = rt.NewHostModuleBuilder("solvyd").
+		NewFunctionBuilder().
+		WithFunc(func(_ context.Context, mod api.Module, ptr, size uint32) {
+			var entry LogEntry
+			if data, ok := mod.Memory().Read(ptr, size); ok && json.Unmarshal(data, &entry) == nil {
+				sink(entry)
+			}
+		}).
+		Export("log").
+		NewFunctionBuilder().
+		WithFunc(func(_ context.Context, mod api.Module, ptr, size uint32) {
+			data, ok := mod.Memory().Read(ptr, size)
+			if !ok {
+				return
+			}
+			var resp wasmResponse
+			if err := json.Unmarshal(data, &resp); err == nil {
+				response = &resp
+			}
+		}).
+		Export("set_result").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, namePtr, nameLen, bufPtr, bufLen uint32) int32 {
+			if !caps[CapabilitySecrets] {
+				return -1
+			}
+			name, ok := mod.Memory().Read(namePtr, nameLen)
+			if !ok {
+				return -1
+			}
+			value, ok := execCtx.Secrets[string(name)]
+			if !ok && secrets != nil {
+				var err error
+				if value, err = secrets.resolve(ctx, string(name)); err != nil {
+					sink(LogEntry{Level: "error", Message: err.Error()})
+					return -1
+				}
+				ok = true
+			}
+			if !ok {
+				return -1
+			}
+			if uint32(len(value)) <= bufLen && !mod.Memory().Write(bufPtr, []byte(value)) {
+				return -1
+			}
+			return int32(len(value))
+		}).
+		Export("get_secret").
+		Instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := rt.CompileModule(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile plugin %s: %w", step.Name, err)
+	}
+
+	// The sandbox sees only what the step's capabilities allow
+	sandboxCtx := *execCtx
+	sandboxCtx.WorkDir = ""
+	sandboxCtx.EnvVars = map[string]string{}
+	sandboxCtx.Secrets = nil
+
+	stdout := &lineWriter{emit: func(line string) { sink(LogEntry{Level: LevelOutput, Message: line}) }}
+	stderr := &lineWriter{emit: func(line string) { sink(LogEntry{Level: "warn", Message: line}) }}
+	modConfig := wazero.NewModuleConfig().
+		WithName(step.Name).
+		WithArgs(step.Name).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+
+	if execCtx.WorkDir != "" {
+		switch {
+		case caps[CapabilityWorkspace]:
+			modConfig = modConfig.WithFSConfig(wazero.NewFSConfig().WithDirMount(execCtx.WorkDir, wasmWorkspace))
+			sandboxCtx.WorkDir = wasmWorkspace
+		case caps[CapabilityWorkspaceReadOnly]:
+			modConfig = modConfig.WithFSConfig(wazero.NewFSConfig().WithReadOnlyDirMount(execCtx.WorkDir, wasmWorkspace))
+			sandboxCtx.WorkDir = wasmWorkspace
+		}
+	}
+	if caps[CapabilityEnv] {
+		sandboxCtx.EnvVars = execCtx.EnvVars
+		for k, v := range execCtx.EnvVars {
+			modConfig = modConfig.WithEnv(k, v)
+		}
+	}
+
+	req := wasmRequest{Config: step.Config, Context: sandboxCtx, Capabilities: step.Capabilities}
+	if caps[CapabilitySecrets] {
+		for name := range execCtx.Secrets {
+			req.SecretNames = append(req.SecretNames, name)
+		}
+		sort.Strings(req.SecretNames)
+	}
+	if secrets != nil {
+		req.Context.ResolvableSecrets = secretNames(step)
+	}
+	if req.Config == nil {
+		req.Config = map[string]interface{}{}
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	modConfig = modConfig.WithStdin(bytes.NewReader(input))
+
+	_, runErr := rt.InstantiateModule(ctx, compiled, modConfig)
+	var exitErr *sys.ExitError
+	if errors.As(runErr, &exitErr) && exitErr.ExitCode() == 0 {
+		runErr = nil
+	}
+
+	stdout.flush()
+	stderr.flush()
+
+	if response == nil {
+		if runErr == nil {
+			runErr = fmt.Errorf("exited without a result")
+		}
+		return nil, fmt.Errorf("plugin %s: %w", step.Name, runErr)
+	}
+
+	log.Info().
+		Str("build_id", execCtx.BuildID).
+		Str("plugin", response.Info.Name).
+		Str("version", response.Info.Version).
+		Str("type", response.Info.Type).
+		Strs("capabilities", step.Capabilities).
+		Msg("Ran WASM plugin step")
+
+	if len(response.MissingCapabilities) > 0 {
+		return nil, fmt.Errorf("plugin %s requires capabilities the step does not grant: %s",
+			step.Name, strings.Join(response.MissingCapabilities, ", "))
+	}
+	if response.InitializeError != "" {
+		return nil, fmt.Errorf("plugin %s failed to initialize: %s", step.Name, response.InitializeError)
+	}
+	if response.HealthError != "" {
+		return nil, fmt.Errorf("plugin %s is not healthy: %s", step.Name, response.HealthError)
+	}
+	if response.CleanupError != "" {
+		log.Warn().Str("plugin", step.Name).Str("error", response.CleanupError).Msg("Plugin cleanup failed")
+	}
+
+	result := response.Result
+	if result == nil {
+		result = &Result{}
+	}
+	for _, entry := range result.Logs {
+		sink(entry)
+	}
+	result.Logs = nil
+	if result.Error != "" {
+		return result, fmt.Errorf("plugin %s: %s", step.Name, result.Error)
+	}
+	if runErr != nil {
+		return result, fmt.Errorf("plugin %s: %w", step.Name, runErr)
+	}
+	return result, nil
+}
+
+// wasmGrants validates the capabilities a step grants against the plugin's policy
+func wasmGrants(step Step, policy Policy) (map[string]bool, error) {
+	if err := policy.checkCapabilities(step.Capabilities); err != nil {
+		return nil, err
+	}
+	caps := make(map[string]bool)
+	for _, c := range step.Capabilities {
+		if !knownCapabilities[c] {
+			return nil, fmt.Errorf("unknown capability %q", c)
+		}
+		caps[c] = true
+	}
+	return caps, nil
+}
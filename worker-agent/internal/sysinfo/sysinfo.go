@@ -0,0 +1,26 @@
+package sysinfo
+
+import "runtime"
+
+// Resources is a snapshot of the load and free resources of a worker
+type Resources struct {
+	CPUCores int `json:"cpu_cores"`
+	// Load averages over 1, 5 and 15 minutes
+	Load1  float64 `json:"load_1m"`
+	Load5  float64 `json:"load_5m"`
+	Load15 float64 `json:"load_15m"`
+	// Memory, and memory available to new processes
+	MemoryTotalMB     int64 `json:"memory_total_mb"`
+	MemoryAvailableMB int64 `json:"memory_available_mb"`
+	// Disk of the build workspaces
+	DiskTotalMB int64 `json:"disk_total_mb"`
+	DiskFreeMB  int64 `json:"disk_free_mb"`
+}
+
+// Collect measures the machine's resources, with the disk holding dir.
+// Measurements the platform does not support are left zero.
+func Collect(dir string) Resources {
+	r := Resources{CPUCores: runtime.NumCPU()}
+	collect(&r, dir)
+	return r
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package sysinfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+func collect(r *Resources, dir string) {
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		fmt.Sscanf(string(data), "%f %f %f", &r.Load1, &r.Load5, &r.Load15)
+	}
+
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch fields[0] {
+			case "MemTotal:":
+				r.MemoryTotalMB = kb / 1024
+			case "MemAvailable:":
+				r.MemoryAvailableMB = kb / 1024
+			}
+		}
+		f.Close()
+	}
+
+	var fs unix.Statfs_t
+	if err := unix.Statfs(dir, &fs); err == nil {
+		r.DiskTotalMB = int64(fs.Blocks) * int64(fs.Bsize) / (1 << 20)
+		r.DiskFreeMB = int64(fs.Bavail) * int64(fs.Bsize) / (1 << 20)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package sysinfo
+
+// collect measures nothing beyond the CPU cores on platforms without /proc
+func collect(r *Resources, dir string) {}
@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// download fetches the archive of a release, verifies its checksum and
+// unpacks it into dir
+func (i *Installer) download(ctx context.Context, rel release, dir string) error {
+	resp, err := get(ctx, i.client, rel.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	archive, err := os.CreateTemp(filepath.Dir(dir), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, hash), resp.Body); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	if rel.sha256 == "" {
+		return fmt.Errorf("the release has no checksum to verify")
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, rel.sha256) {
+		return fmt.Errorf("checksum mismatch: got %s, expected %s", sum, rel.sha256)
+	}
+
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return untar(archive, dir)
+}
+
+// untar unpacks a gzipped tar archive into dir, refusing entries and links
+// that lead outside it
+func untar(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a gzipped archive: %w", err)
+	}
+	defer gz.Close()
+
+	inside := func(name string) (string, error) {
+		target := filepath.Join(dir, name)
+		if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+			return "", fmt.Errorf("archive entry %s leads outside the tool directory", name)
+		}
+		return target, nil
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %w", err)
+		}
+		target, err := inside(hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0755|0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) {
+				return fmt.Errorf("archive link %s leads outside the tool directory", hdr.Name)
+			}
+			if _, err := inside(filepath.Join(filepath.Dir(hdr.Name), hdr.Linkname)); err != nil {
+				return fmt.Errorf("archive link %s leads outside the tool directory", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := inside(hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sources are the tools that can be installed, by the name builds declare
+// them with
+var sources = map[string]source{
+	"go":   {resolve: resolveGo},
+	"node": {resolve: resolveNode},
+	"java": {resolve: resolveJava, env: func(root string) map[string]string {
+		return map[string]string{"JAVA_HOME": root}
+	}},
+}
+
+// Where releases are listed and downloaded from
+const (
+	goIndexURL     = "https://go.dev/dl/?mode=json&include=all"
+	goDownloadURL  = "https://go.dev/dl/"
+	nodeIndexURL   = "https://nodejs.org/dist/index.json"
+	nodeDistURL    = "https://nodejs.org/dist/"
+	adoptiumAPIURL = "https://api.adoptium.net/v3/"
+)
+
+// resolveGo resolves a Go version from the Go release index, which lists
+// the newest releases first
+func resolveGo(ctx context.Context, client *http.Client, requested, arch string) (release, error) {
+	var index []struct {
+		Version string `json:"version"`
+		Stable  bool   `json:"stable"`
+		Files   []struct {
+			Filename string `json:"filename"`
+			OS       string `json:"os"`
+			Arch     string `json:"arch"`
+			SHA256   string `json:"sha256"`
+			Kind     string `json:"kind"`
+		} `json:"files"`
+	}
+	if err := getJSON(ctx, client, goIndexURL, &index); err != nil {
+		return release{}, err
+	}
+	for _, r := range index {
+		version := strings.TrimPrefix(r.Version, "go")
+		// Release candidates only when asked for by their name
+		if !matches(requested, version) || (!r.Stable && version != requested) {
+			continue
+		}
+		for _, f := range r.Files {
+			if f.OS == "linux" && f.Arch == arch && f.Kind == "archive" {
+				return release{version: version, url: goDownloadURL + f.Filename, sha256: f.SHA256}, nil
+			}
+		}
+	}
+	return release{}, fmt.Errorf("no release for linux/%s", arch)
+}
+
+// resolveNode resolves a Node.js version from the Node.js release index,
+// which lists the newest releases first, and its checksum from the release's
+// SHASUMS256.txt
+func resolveNode(ctx context.Context, client *http.Client, requested, arch string) (release, error) {
+	platform := map[string]string{"amd64": "linux-x64", "arm64": "linux-arm64"}[arch]
+	var index []struct {
+		Version string   `json:"version"`
+		Files   []string `json:"files"`
+	}
+	if err := getJSON(ctx, client, nodeIndexURL, &index); err != nil {
+		return release{}, err
+	}
+	for _, r := range index {
+		version := strings.TrimPrefix(r.Version, "v")
+		if !matches(requested, version) || !contains(r.Files, platform) {
+			continue
+		}
+		filename := fmt.Sprintf("node-%s-%s.tar.gz", r.Version, platform)
+		sum, err := nodeChecksum(ctx, client, r.Version, filename)
+		if err != nil {
+			return release{}, err
+		}
+		return release{version: version, url: nodeDistURL + r.Version + "/" + filename, sha256: sum}, nil
+	}
+	return release{}, fmt.Errorf("no release for %s", platform)
+}
+
+// nodeChecksum returns the SHA-256 of a file of a Node.js release
+func nodeChecksum(ctx context.Context, client *http.Client, version, filename string) (string, error) {
+	resp, err := get(ctx, client, nodeDistURL+version+"/SHASUMS256.txt")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == filename {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s", filename)
+}
+
+// resolveJava resolves a version of the Eclipse Temurin JDK from the
+// Adoptium API, by its feature release (21) or a full version (21.0.2)
+func resolveJava(ctx context.Context, client *http.Client, requested, arch string) (release, error) {
+	feature, _, _ := strings.Cut(strings.SplitN(requested, "+", 2)[0], ".")
+	if _, err := strconv.Atoi(feature); err != nil {
+		return release{}, fmt.Errorf("not a Java version")
+	}
+	query := url.Values{
+		"architecture": {map[string]string{"amd64": "x64", "arm64": "aarch64"}[arch]},
+		"image_type":   {"jdk"},
+		"os":           {"linux"},
+		"vendor":       {"eclipse"},
+		"page_size":    {"50"},
+		"sort_order":   {"DESC"},
+	}
+	var assets []struct {
+		VersionData struct {
+			Major    int `json:"major"`
+			Minor    int `json:"minor"`
+			Security int `json:"security"`
+			Build    int `json:"build"`
+		} `json:"version_data"`
+		Binaries []struct {
+			Package struct {
+				Link     string `json:"link"`
+				Checksum string `json:"checksum"`
+			} `json:"package"`
+		} `json:"binaries"`
+	}
+	if err := getJSON(ctx, client, adoptiumAPIURL+"assets/feature_releases/"+feature+"/ga?"+query.Encode(), &assets); err != nil {
+		return release{}, err
+	}
+	for _, a := range assets {
+		v := a.VersionData
+		version := fmt.Sprintf("%d.%d.%d+%d", v.Major, v.Minor, v.Security, v.Build)
+		if !matches(requested, version) {
+			continue
+		}
+		for _, b := range a.Binaries {
+			if path.Ext(b.Package.Link) == ".gz" {
+				return release{version: version, url: b.Package.Link, sha256: b.Package.Checksum}, nil
+			}
+		}
+	}
+	return release{}, fmt.Errorf("no release for linux/%s", arch)
+}
+
+// matches reports whether version is the requested version or one of its
+// releases: 1.22 matches 1.22 and 1.22.5, and 21.0.2 matches 21.0.2+13
+func matches(requested, version string) bool {
+	return version == requested ||
+		strings.HasPrefix(version, requested+".") ||
+		strings.HasPrefix(version, requested+"+")
+}
+
+// compareVersions compares the numbers of two versions in order, returning
+// a negative number, zero or a positive number as a is older than, the same
+// as or newer than b
+func compareVersions(a, b string) int {
+	split := func(v string) []int {
+		var parts []int
+		for _, field := range strings.FieldsFunc(v, func(r rune) bool { return !unicode.IsDigit(r) }) {
+			n, _ := strconv.Atoi(field)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	pa, pb := split(a), split(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] != pb[i] {
+			return pa[i] - pb[i]
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+func contains(list []string, item string) bool {
+	for _, s := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// get fetches a URL, failing on any status but 200 OK
+func get(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", rawURL, resp.Status)
+	}
+	return resp, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, rawURL string, v interface{}) error {
+	resp, err := get(ctx, client, rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,258 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// indexTTL is how long the releases a version resolved to are remembered, so
+// that builds do not read the release index every time
+const indexTTL = time.Hour
+
+// Tool is a tool installed for a build: its version and where it is
+// installed, relative to the tool directory
+type Tool struct {
+	Name    string
+	Version string
+	// Root is the directory the tool is installed in
+	Root string
+	// Env are environment variables the tool needs, whose values are
+	// relative to the tool directory, such as JAVA_HOME
+	Env map[string]string
+}
+
+// Bin returns the directory of the tool's executables, relative to the tool
+// directory
+func (t Tool) Bin() string {
+	return filepath.Join(t.Root, "bin")
+}
+
+// release is a version of a tool and the archive it is downloaded from
+type release struct {
+	version string
+	url     string
+	sha256  string
+}
+
+// source resolves the versions of a tool
+type source struct {
+	// resolve returns the newest release matching the requested version
+	// for the architecture
+	resolve func(ctx context.Context, client *http.Client, requested, arch string) (release, error)
+	// env returns the environment variables of a tool installed in root
+	env func(root string) map[string]string
+}
+
+// resolved is a version a request resolved to, remembered for indexTTL
+type resolved struct {
+	release release
+	at      time.Time
+}
+
+// Installer downloads the tools builds declare into a directory, where each
+// version is kept for later builds
+type Installer struct {
+	dir    string
+	client *http.Client
+
+	mu       sync.Mutex
+	resolved map[string]resolved    // by tool and requested version
+	installs map[string]*sync.Mutex // by tool and version
+}
+
+// NewInstaller creates an installer that keeps tools in dir and downloads
+// them over transport
+func NewInstaller(dir string, transport http.RoundTripper) (*Installer, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Installer{
+		dir:      abs,
+		client:   &http.Client{Timeout: 30 * time.Minute, Transport: transport},
+		resolved: map[string]resolved{},
+		installs: map[string]*sync.Mutex{},
+	}, nil
+}
+
+// Dir returns the directory tools are installed in
+func (i *Installer) Dir() string {
+	return i.dir
+}
+
+// Supported returns the names of the tools that can be installed
+func Supported() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Install installs the tools a build declares, as a map of tool names to
+// versions such as {"go": "1.22", "node": "20"}, unless they are installed
+// already, and returns them by name. A version matches its newest release:
+// "1.22" is the newest 1.22.x. If the release index cannot be read, the
+// newest matching version installed is used.
+func (i *Installer) Install(ctx context.Context, declared map[string]string, logf func(format string, args ...interface{})) ([]Tool, error) {
+	names := make([]string, 0, len(declared))
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	arch, ok := map[string]string{"amd64": "amd64", "arm64": "arm64"}[runtime.GOARCH]
+	if !ok || runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("tools cannot be installed on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+
+	var installed []Tool
+	for _, name := range names {
+		src, ok := sources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q; supported tools are %s", name, strings.Join(Supported(), ", "))
+		}
+		requested := strings.TrimPrefix(declared[name], "v")
+		if requested == "" {
+			return nil, fmt.Errorf("tool %s: no version", name)
+		}
+
+		rel, err := i.resolve(ctx, name, src, requested, arch)
+		if err != nil {
+			version, found := i.newestInstalled(name, requested)
+			if !found {
+				return nil, fmt.Errorf("tool %s %s: %w", name, requested, err)
+			}
+			logf("[WARN] Cannot resolve %s %s (%v); using %s %s installed before", name, requested, err, name, version)
+			rel = release{version: version}
+		}
+
+		dir := filepath.Join(name, rel.version)
+		root, err := i.install(ctx, name, rel, logf)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s %s: %w", name, rel.version, err)
+		}
+		tool := Tool{Name: name, Version: rel.version, Root: filepath.Join(dir, root)}
+		if src.env != nil {
+			tool.Env = src.env(tool.Root)
+		}
+		installed = append(installed, tool)
+	}
+	return installed, nil
+}
+
+// resolve returns the release a requested version resolves to
+func (i *Installer) resolve(ctx context.Context, name string, src source, requested, arch string) (release, error) {
+	key := name + "@" + requested
+	i.mu.Lock()
+	cached, ok := i.resolved[key]
+	i.mu.Unlock()
+	if ok && time.Since(cached.at) < indexTTL {
+		return cached.release, nil
+	}
+
+	rel, err := src.resolve(ctx, i.client, requested, arch)
+	if err != nil {
+		return release{}, err
+	}
+	i.mu.Lock()
+	i.resolved[key] = resolved{release: rel, at: time.Now()}
+	i.mu.Unlock()
+	return rel, nil
+}
+
+// install downloads and unpacks a release unless it is installed, and
+// returns the directory of the tool in its version's directory
+func (i *Installer) install(ctx context.Context, name string, rel release, logf func(format string, args ...interface{})) (string, error) {
+	dir := filepath.Join(i.dir, name, rel.version)
+
+	// Builds installing the same version wait for the first
+	i.mu.Lock()
+	lock, ok := i.installs[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		i.installs[dir] = lock
+	}
+	i.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	if root, err := toolRoot(dir); err == nil {
+		logf("[INFO] Using %s %s", name, rel.version)
+		return root, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if rel.url == "" {
+		return "", fmt.Errorf("not installed")
+	}
+
+	logf("[INFO] Installing %s %s from %s", name, rel.version, rel.url)
+	start := time.Now()
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	// Unpacked next to its final place and renamed, so that an interrupted
+	// install is never taken for a complete one
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), "."+rel.version+"-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := i.download(ctx, rel, tmp); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	log.Info().Str("tool", name).Str("version", rel.version).Dur("took", time.Since(start)).Msg("Installed tool")
+	return toolRoot(dir)
+}
+
+// toolRoot returns the directory an archive unpacked into dir holds the tool
+// in: its only top-level directory, as archives of releases have, or dir
+// itself
+func toolRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return entries[0].Name(), nil
+	}
+	return ".", nil
+}
+
+// newestInstalled returns the newest installed version of a tool that
+// matches the requested version
+func (i *Installer) newestInstalled(name, requested string) (string, bool) {
+	entries, err := os.ReadDir(filepath.Join(i.dir, name))
+	if err != nil {
+		return "", false
+	}
+	var newest string
+	for _, entry := range entries {
+		version := entry.Name()
+		if entry.IsDir() && !strings.HasPrefix(version, ".") && matches(requested, version) &&
+			(newest == "" || compareVersions(version, newest) > 0) {
+			newest = version
+		}
+	}
+	return newest, newest != ""
+}